@@ -0,0 +1,218 @@
+package carbonapi
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// admissionError is returned when a request is refused by the admission
+// controller. Code is the HTTP status to answer with. Requests refused for
+// overload also carry hints on when to retry.
+type admissionError struct {
+	Code   int
+	Reason string
+	msg    string
+
+	RetryAfter time.Duration
+	QueueDepth int64
+}
+
+func (err admissionError) Error() string {
+	return err.msg
+}
+
+// setHeaders sets the Retry-After and X-Queue-Depth headers of overload
+// responses.
+func (err admissionError) setHeaders(h http.Header) {
+	if err.RetryAfter <= 0 {
+		return
+	}
+	h.Set("Retry-After", strconv.Itoa(int((err.RetryAfter+time.Second-1)/time.Second)))
+	h.Set("X-Queue-Depth", strconv.FormatInt(err.QueueDepth, 10))
+}
+
+// admissionController enforces the limits of cfg.AdmissionConfig.
+type admissionController struct {
+	config   cfg.AdmissionConfig
+	inFlight *semaphore.Weighted
+	waiting  int64 // requests queued on inFlight
+	memory   *memoryGuard
+}
+
+func newAdmissionController(config cfg.AdmissionConfig) *admissionController {
+	ac := &admissionController{
+		config: config,
+		memory: newMemoryGuard(config.Memory),
+	}
+	if config.MaxDatapointsInFlight > 0 {
+		ac.inFlight = semaphore.NewWeighted(config.MaxDatapointsInFlight)
+	}
+
+	return ac
+}
+
+// admissionTicket keeps track of what a single request has been admitted to
+// fetch so far. It has to be released once the request is done.
+type admissionTicket struct {
+	ac *admissionController
+
+	mu         sync.Mutex
+	metrics    int
+	datapoints int64
+	held       int64
+
+	// acquiring serializes the acquisitions of in-flight datapoints of the
+	// request, which give back what it holds and wait for all it needs at
+	// once: waiting for more while holding some, requests could each hold
+	// part of the budget and wait for one another until they time out.
+	acquiring sync.Mutex
+}
+
+func (ac *admissionController) newTicket() *admissionTicket {
+	return &admissionTicket{ac: ac}
+}
+
+// admit accounts for fetching metrics between from and until, and returns an
+// admissionError if that takes the request over one of the limits.
+func (t *admissionTicket) admit(ctx context.Context, metrics int, from, until int64) error {
+	if t == nil || t.ac == nil {
+		return nil
+	}
+	config := t.ac.config
+
+	if max := config.MaxTimeRange; max > 0 && until-from > int64(max.Seconds()) {
+		return admissionError{
+			Code:   http.StatusRequestEntityTooLarge,
+			Reason: "time_range",
+			msg:    fmt.Sprintf("time range of %ds is over the limit of %ds", until-from, int64(max.Seconds())),
+		}
+	}
+
+	datapoints := int64(metrics)
+	if interval := int64(config.DatapointInterval.Seconds()); interval > 0 {
+		datapoints *= (until - from + interval - 1) / interval
+	}
+
+	t.mu.Lock()
+	t.metrics += metrics
+	t.datapoints += datapoints
+	totalMetrics, totalDatapoints := t.metrics, t.datapoints
+	t.mu.Unlock()
+
+	if max := config.MaxMetricsPerRequest; max > 0 && totalMetrics > max {
+		return admissionError{
+			Code:   http.StatusRequestEntityTooLarge,
+			Reason: "metrics",
+			msg:    fmt.Sprintf("request expands to at least %d metrics, the limit is %d", totalMetrics, max),
+		}
+	}
+	if max := config.MaxDatapointsPerRequest; max > 0 && totalDatapoints > max {
+		return admissionError{
+			Code:   http.StatusRequestEntityTooLarge,
+			Reason: "datapoints",
+			msg:    fmt.Sprintf("request fetches an estimated %d datapoints, the limit is %d", totalDatapoints, max),
+		}
+	}
+
+	if above, shed := t.ac.memory.shed(totalDatapoints); shed {
+		return admissionError{
+			Code:       http.StatusServiceUnavailable,
+			Reason:     "memory",
+			msg:        fmt.Sprintf("memory is short, requests of more than %d datapoints are refused, try again later", above),
+			RetryAfter: t.ac.retryAfter(),
+			QueueDepth: atomic.LoadInt64(&t.ac.waiting),
+		}
+	}
+
+	if t.ac.inFlight == nil || datapoints == 0 {
+		return nil
+	}
+	t.acquiring.Lock()
+	defer t.acquiring.Unlock()
+
+	// a request bigger than the whole budget would wait for ever
+	t.mu.Lock()
+	held := t.held
+	t.mu.Unlock()
+	if max := config.MaxDatapointsInFlight; held+datapoints > max {
+		return admissionError{
+			Code:   http.StatusRequestEntityTooLarge,
+			Reason: "datapoints_in_flight",
+			msg:    fmt.Sprintf("request fetches an estimated %d datapoints at once, more than the %d that can be in flight", held+datapoints, max),
+		}
+	}
+
+	queueCtx := ctx
+	if config.QueueTimeout > 0 {
+		var cancel context.CancelFunc
+		queueCtx, cancel = context.WithTimeout(ctx, config.QueueTimeout)
+		defer cancel()
+	}
+	t.mu.Lock()
+	t.held = 0
+	t.mu.Unlock()
+	if held > 0 {
+		t.ac.inFlight.Release(held)
+	}
+	atomic.AddInt64(&t.ac.waiting, 1)
+	err := t.ac.inFlight.Acquire(queueCtx, held+datapoints)
+	depth := atomic.AddInt64(&t.ac.waiting, -1)
+	if err != nil {
+		// the client went away, or the request timed out, rather than
+		// the backend being overloaded
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return admissionError{
+			Code:       http.StatusTooManyRequests,
+			Reason:     "queue_timeout",
+			msg:        "too many datapoints are being fetched, try again later",
+			RetryAfter: t.ac.retryAfter(),
+			QueueDepth: depth,
+		}
+	}
+
+	t.mu.Lock()
+	t.held = held + datapoints
+	t.mu.Unlock()
+
+	return nil
+}
+
+// retryAfter is how long an overloaded client is asked to wait. Up to as much
+// again of jitter is added to it, so that rejected clients do not all come
+// back at once.
+func (ac *admissionController) retryAfter() time.Duration {
+	base := ac.config.RetryAfter
+	if base < time.Second {
+		base = time.Second
+	}
+
+	return base + time.Duration(rand.Int63n(int64(base)+1))
+}
+
+// release gives back the in-flight datapoints held by the request.
+func (t *admissionTicket) release() {
+	if t == nil || t.ac == nil || t.ac.inFlight == nil {
+		return
+	}
+
+	t.mu.Lock()
+	held := t.held
+	t.held = 0
+	t.mu.Unlock()
+
+	if held > 0 {
+		t.ac.inFlight.Release(held)
+	}
+}
@@ -0,0 +1,248 @@
+package carbonapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+func TestAdmissionLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   cfg.AdmissionConfig
+		metrics  []int
+		from     int64
+		until    int64
+		expCode  int
+		expError bool
+	}{
+		{
+			name:    "no limits",
+			config:  cfg.AdmissionConfig{DatapointInterval: time.Minute},
+			metrics: []int{1000000},
+			from:    0,
+			until:   86400,
+		},
+		{
+			name:    "metrics under limit",
+			config:  cfg.AdmissionConfig{MaxMetricsPerRequest: 10},
+			metrics: []int{5, 5},
+			from:    0,
+			until:   3600,
+		},
+		{
+			name:     "metrics over limit",
+			config:   cfg.AdmissionConfig{MaxMetricsPerRequest: 10},
+			metrics:  []int{5, 6},
+			from:     0,
+			until:    3600,
+			expCode:  http.StatusRequestEntityTooLarge,
+			expError: true,
+		},
+		{
+			name:    "datapoints under limit",
+			config:  cfg.AdmissionConfig{MaxDatapointsPerRequest: 600, DatapointInterval: time.Minute},
+			metrics: []int{10},
+			from:    0,
+			until:   3600,
+		},
+		{
+			name:     "datapoints over limit",
+			config:   cfg.AdmissionConfig{MaxDatapointsPerRequest: 600, DatapointInterval: time.Minute},
+			metrics:  []int{10},
+			from:     0,
+			until:    3601,
+			expCode:  http.StatusRequestEntityTooLarge,
+			expError: true,
+		},
+		{
+			name:     "time range over limit",
+			config:   cfg.AdmissionConfig{MaxTimeRange: time.Hour},
+			metrics:  []int{1},
+			from:     0,
+			until:    7200,
+			expCode:  http.StatusRequestEntityTooLarge,
+			expError: true,
+		},
+	}
+
+	for _, tst := range tests {
+		tst := tst
+		t.Run(tst.name, func(t *testing.T) {
+			ticket := newAdmissionController(tst.config).newTicket()
+			defer ticket.release()
+
+			var err error
+			for _, m := range tst.metrics {
+				if err = ticket.admit(context.Background(), m, tst.from, tst.until); err != nil {
+					break
+				}
+			}
+
+			if !tst.expError {
+				if err != nil {
+					t.Fatalf("unexpected error %v", err)
+				}
+				return
+			}
+			var admissionErr admissionError
+			if !errors.As(err, &admissionErr) {
+				t.Fatalf("expected an admission error, got %v", err)
+			}
+			if admissionErr.Code != tst.expCode {
+				t.Errorf("expected code %d, got %d", tst.expCode, admissionErr.Code)
+			}
+			h := http.Header{}
+			admissionErr.setHeaders(h)
+			if h.Get("Retry-After") != "" {
+				t.Errorf("unexpected Retry-After '%s' on a rejected request", h.Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestAdmissionQueue(t *testing.T) {
+	ac := newAdmissionController(cfg.AdmissionConfig{
+		DatapointInterval:     time.Minute,
+		MaxDatapointsInFlight: 100,
+		QueueTimeout:          10 * time.Millisecond,
+		RetryAfter:            2 * time.Second,
+	})
+
+	first := ac.newTicket()
+	if err := first.admit(context.Background(), 1, 0, 80*60); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	second := ac.newTicket()
+	err := second.admit(context.Background(), 1, 0, 30*60)
+	var admissionErr admissionError
+	if !errors.As(err, &admissionErr) || admissionErr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected a %d, got %v", http.StatusTooManyRequests, err)
+	}
+	second.release()
+
+	h := http.Header{}
+	admissionErr.setHeaders(h)
+	if retry, err := strconv.Atoi(h.Get("Retry-After")); err != nil || retry < 2 || retry > 4 {
+		t.Errorf("expected Retry-After between 2 and 4, got '%s'", h.Get("Retry-After"))
+	}
+	if got := h.Get("X-Queue-Depth"); got != "0" {
+		t.Errorf("expected X-Queue-Depth 0, got '%s'", got)
+	}
+
+	done := make(chan error)
+	go func() {
+		third := ac.newTicket()
+		defer third.release()
+		done <- third.admit(context.Background(), 1, 0, 30*60)
+	}()
+	first.release()
+
+	if err := <-done; err != nil {
+		t.Errorf("expected the queued request to be admitted, got %v", err)
+	}
+}
+
+func TestAdmissionOverBudget(t *testing.T) {
+	ac := newAdmissionController(cfg.AdmissionConfig{
+		DatapointInterval:     time.Minute,
+		MaxDatapointsInFlight: 100,
+		QueueTimeout:          time.Minute,
+	})
+
+	ticket := ac.newTicket()
+	defer ticket.release()
+
+	// rejected at once rather than after the queue timeout
+	err := ticket.admit(context.Background(), 1, 0, 101*60)
+	var admissionErr admissionError
+	if !errors.As(err, &admissionErr) || admissionErr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a %d, got %v", http.StatusRequestEntityTooLarge, err)
+	}
+
+	// as is a request whose fetches add up to more than the budget
+	if err := ticket.admit(context.Background(), 1, 0, 60*60); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = ticket.admit(context.Background(), 1, 0, 41*60)
+	if !errors.As(err, &admissionErr) || admissionErr.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected a %d, got %v", http.StatusRequestEntityTooLarge, err)
+	}
+}
+
+func TestAdmissionNoHoldAndWait(t *testing.T) {
+	ac := newAdmissionController(cfg.AdmissionConfig{
+		DatapointInterval:     time.Minute,
+		MaxDatapointsInFlight: 100,
+		QueueTimeout:          time.Second,
+	})
+
+	first, second := ac.newTicket(), ac.newTicket()
+	defer first.release()
+	if err := first.admit(context.Background(), 1, 0, 60*60); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := second.admit(context.Background(), 1, 0, 30*60); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// both want 30 more: holding on to what they have, they would wait for
+	// each other until the queue timeout
+	done := make(chan error)
+	go func() {
+		done <- first.admit(context.Background(), 1, 0, 30*60)
+	}()
+	go func() {
+		err := second.admit(context.Background(), 1, 0, 30*60)
+		second.release()
+		done <- err
+	}()
+
+	var errs []error
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				errs = append(errs, err)
+			}
+			if i == 0 {
+				first.release()
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("the requests wait for each other")
+		}
+	}
+	if len(errs) != 0 {
+		t.Errorf("expected both requests to be admitted in turn, got %v", errs)
+	}
+}
+
+func TestAdmissionCanceled(t *testing.T) {
+	ac := newAdmissionController(cfg.AdmissionConfig{
+		DatapointInterval:     time.Minute,
+		MaxDatapointsInFlight: 100,
+		QueueTimeout:          time.Minute,
+	})
+
+	first := ac.newTicket()
+	defer first.release()
+	if err := first.admit(context.Background(), 1, 0, 80*60); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	second := ac.newTicket()
+	defer second.release()
+	err := second.admit(ctx, 1, 0, 30*60)
+	var admissionErr admissionError
+	if errors.As(err, &admissionErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancellation rather than an overload, got %v", err)
+	}
+}
@@ -0,0 +1,63 @@
+package carbonapi
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// apiVersion is the version of the HTTP API a request asks for. Versions
+// only change the behaviour of the handlers where old clients would break.
+type apiVersion int
+
+const (
+	// apiV1 is the API as it always was.
+	apiV1 apiVersion = 1
+	// apiV2 answers errors in JSON, and the render requests in JSON with
+	// the targets that failed, as withErrors does.
+	apiV2 apiVersion = 2
+)
+
+type apiVersionKey struct{}
+
+// withAPIVersion returns a copy of ctx for requests of version v.
+func withAPIVersion(ctx context.Context, v apiVersion) context.Context {
+	return context.WithValue(ctx, apiVersionKey{}, v)
+}
+
+// apiVersionFrom returns the API version of the request of ctx, v1 if it
+// has none.
+func apiVersionFrom(ctx context.Context) apiVersion {
+	if v, ok := ctx.Value(apiVersionKey{}).(apiVersion); ok {
+		return v
+	}
+	return apiV1
+}
+
+// apiVersionHandler strips the /v1 or /v2 prefix of the path of requests, for
+// them to be routed as the unprefixed paths, and tells the handlers the
+// version of the request: that of its prefix, or else the configured one.
+// It wraps the router, which matches the routes before its middlewares.
+func (app *App) apiVersionHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := apiVersion(app.config.APIVersion)
+		for _, prefixed := range []apiVersion{apiV1, apiV2} {
+			prefix := "/v" + strconv.Itoa(int(prefixed))
+			if r.URL.Path == prefix || strings.HasPrefix(r.URL.Path, prefix+"/") {
+				v = prefixed
+				r.URL.Path = strings.TrimPrefix(r.URL.Path, prefix)
+				if r.URL.Path == "" {
+					r.URL.Path = "/"
+				}
+				r.URL.RawPath = ""
+				break
+			}
+		}
+		if v == 0 {
+			v = apiV1
+		}
+
+		next.ServeHTTP(w, r.WithContext(withAPIVersion(r.Context(), v)))
+	})
+}
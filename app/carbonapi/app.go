@@ -18,13 +18,23 @@ import (
 	"github.com/bookingcom/carbonapi/cache"
 	"github.com/bookingcom/carbonapi/carbonapipb"
 	"github.com/bookingcom/carbonapi/cfg"
-	"github.com/bookingcom/carbonapi/expr/functions"
-	"github.com/bookingcom/carbonapi/expr/functions/cairo/png"
+	"github.com/bookingcom/carbonapi/internal/expr/functions"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/cairo/png"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/macro"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	"github.com/bookingcom/carbonapi/mstats"
 	"github.com/bookingcom/carbonapi/pathcache"
 	"github.com/bookingcom/carbonapi/pkg/backend"
+	bgrpc "github.com/bookingcom/carbonapi/pkg/backend/grpc"
+	binfluxdb "github.com/bookingcom/carbonapi/pkg/backend/influxdb"
 	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	bopentsdb "github.com/bookingcom/carbonapi/pkg/backend/opentsdb"
+	brewrite "github.com/bookingcom/carbonapi/pkg/backend/rewrite"
+	brrd "github.com/bookingcom/carbonapi/pkg/backend/rrd"
+	bwhisper "github.com/bookingcom/carbonapi/pkg/backend/whisper"
+	"github.com/bookingcom/carbonapi/pkg/metrictree"
+	"github.com/bookingcom/carbonapi/pkg/promql"
 	"github.com/bookingcom/carbonapi/pkg/trace"
 	"github.com/bookingcom/carbonapi/util"
 
@@ -33,6 +43,7 @@ import (
 	"github.com/peterbourgon/g2g"
 	"github.com/prometheus/client_golang/prometheus"
 	"go.uber.org/zap"
+	"golang.org/x/sync/singleflight"
 )
 
 // BuildVersion is provided to be overridden at build time. Eg. go build -ldflags -X 'main.BuildVersion=...'
@@ -55,13 +66,46 @@ func init() {
 // App is the main carbonapi runnable
 type App struct {
 	config         cfg.API
-	queryCache     cache.BytesCache
-	findCache      cache.BytesCache
+	queryCache     cache.Cache
+	findCache      cache.Cache
 	requestBlocker *blocker.RequestBlocker
 
+	// findTree caches the metric tree, nil if it is disabled
+	findTree *metrictree.Cache
+
+	// namespaces are the ones the load is counted by, nil if it is not
+	namespaces *namespaces
+
+	// cachePeers are the peered caches, served to the other instances
+	cachePeers []*cache.Peered
+
+	// finds shares the backend lookups of glob patterns that are in flight
+	// between the render and find handlers
+	finds singleflight.Group
+	// renders shares the backend renders in flight between requests, if
+	// enabled
+	renders singleflight.Group
+
 	defaultTimeZone *time.Location
 
-	backend backend.Backend
+	backend    backend.Backend
+	admission  *admissionController
+	renderJobs *renderJobs
+	inFlight   *inFlightRequests
+	verifier   *verifier
+
+	// events proxies the events API, nil if it is disabled
+	events *eventsProxy
+
+	// promqlTemplate maps Prometheus series to metrics, nil if the
+	// Prometheus query endpoint is disabled
+	promqlTemplate promql.PathTemplate
+
+	// standingQueries are by the cache key of their render requests
+	standingQueries map[string]*standingQuery
+
+	// queryRefs are the targets of the query refs, by name
+	queryRefs map[string][]string
 
 	prometheusMetrics PrometheusMetrics
 }
@@ -86,7 +130,8 @@ func New(config cfg.API, logger *zap.Logger, buildVersion string) (*App, error)
 	// TODO(gmagnusson): Setup backends
 	backend, err := initBackend(app.config, logger,
 		app.prometheusMetrics.ActiveUpstreamRequests,
-		app.prometheusMetrics.WaitingUpstreamRequests)
+		app.prometheusMetrics.WaitingUpstreamRequests,
+		app.prometheusMetrics.BackendProtocolFallbacks)
 	if err != nil {
 		logger.Fatal("couldn't initialize backends", zap.Error(err))
 	}
@@ -106,6 +151,9 @@ func (app *App) Start(logger *zap.Logger) func() {
 	prometheusServer := app.registerPrometheusMetrics(internalHandler)
 
 	app.requestBlocker.ScheduleRuleReload()
+	app.runStandingQueries(logger)
+	app.runWriteBack(logger)
+	app.runMemoryGuard(logger)
 
 	gracehttp.SetLogger(zap.NewStdLog(logger))
 	err := gracehttp.Serve(&http.Server{
@@ -126,6 +174,7 @@ func (app *App) registerPrometheusMetrics(internalHandler http.Handler) *http.Se
 	prometheus.MustRegister(app.prometheusMetrics.Requests)
 	prometheus.MustRegister(app.prometheusMetrics.Responses)
 	prometheus.MustRegister(app.prometheusMetrics.FindNotFound)
+	prometheus.MustRegister(app.prometheusMetrics.RenderNotFound)
 	prometheus.MustRegister(app.prometheusMetrics.RenderPartialFail)
 	prometheus.MustRegister(app.prometheusMetrics.RequestCancel)
 	prometheus.MustRegister(app.prometheusMetrics.DurationExp)
@@ -143,6 +192,23 @@ func (app *App) registerPrometheusMetrics(internalHandler http.Handler) *http.Se
 	prometheus.MustRegister(app.prometheusMetrics.TimeInQueueLin)
 	prometheus.MustRegister(app.prometheusMetrics.ActiveUpstreamRequests)
 	prometheus.MustRegister(app.prometheusMetrics.WaitingUpstreamRequests)
+	prometheus.MustRegister(app.prometheusMetrics.AdmissionRejections)
+	prometheus.MustRegister(app.prometheusMetrics.Degraded)
+	prometheus.MustRegister(app.prometheusMetrics.BackendProtocolFallbacks)
+	prometheus.MustRegister(app.prometheusMetrics.CacheRequests)
+	prometheus.MustRegister(app.prometheusMetrics.CacheDurations)
+	prometheus.MustRegister(app.prometheusMetrics.FindTreeRequests)
+	prometheus.MustRegister(app.prometheusMetrics.FindTreeEntries)
+	prometheus.MustRegister(app.prometheusMetrics.NamespaceRequests)
+	prometheus.MustRegister(app.prometheusMetrics.NamespaceDatapoints)
+	prometheus.MustRegister(app.prometheusMetrics.StandingQueryLastSuccess)
+	prometheus.MustRegister(app.prometheusMetrics.StandingQueryFailures)
+	prometheus.MustRegister(app.prometheusMetrics.WriteBackLastSuccess)
+	prometheus.MustRegister(app.prometheusMetrics.WriteBackFailures)
+	prometheus.MustRegister(app.prometheusMetrics.WriteBackPoints)
+	prometheus.MustRegister(app.prometheusMetrics.VerifyTargets)
+	prometheus.MustRegister(app.prometheusMetrics.VerifyDivergences)
+	prometheus.MustRegister(newFunctionStatsCollector())
 
 	writeTimeout := app.config.Timeouts.Global
 	if writeTimeout < 30*time.Second {
@@ -169,8 +235,137 @@ func setUpConfig(app *App, logger *zap.Logger) {
 		}
 	}
 
+	xFilesFactor := app.config.DefaultXFilesFactor
+	if app.config.FunctionDefaults.XFilesFactor != nil {
+		xFilesFactor = *app.config.FunctionDefaults.XFilesFactor
+	}
+	if xFilesFactor < 0 || xFilesFactor > 1 {
+		logger.Fatal("invalid default xFilesFactor, it must be between 0 and 1",
+			zap.Float64("default_xfiles_factor", xFilesFactor),
+		)
+	}
+	helper.SetDefaultXFilesFactor(xFilesFactor)
+
+	nullPolicy, err := helper.ParseNullPolicy(app.config.FunctionDefaults.NullWindows)
+	if err != nil {
+		logger.Fatal("invalid functionDefaults.nullWindows", zap.Error(err))
+	}
+	helper.SetNullPolicy(nullPolicy)
+	infinityPolicy, err := helper.ParseInfinityPolicy(app.config.FunctionDefaults.Infinity)
+	if err != nil {
+		logger.Fatal("invalid functionDefaults.infinity", zap.Error(err))
+	}
+	helper.SetInfinityPolicy(infinityPolicy)
+
+	parser.SetLimits(parser.Limits{
+		MaxDepth:     app.config.ParserLimits.MaxDepth,
+		MaxArguments: app.config.ParserLimits.MaxArguments,
+		MaxLength:    app.config.ParserLimits.MaxLength,
+		MaxPipes:     app.config.ParserLimits.MaxPipes,
+	})
+
+	if v := apiVersion(app.config.APIVersion); v != apiV1 && v != apiV2 {
+		logger.Fatal("invalid API version, it must be 1 or 2",
+			zap.Int("api_version", app.config.APIVersion),
+		)
+	}
+
+	if !validNotFoundStatus(app.config.RenderNotFoundStatus) {
+		logger.Fatal("invalid render not found status, it must be 200 or 404",
+			zap.Int("render_not_found_status", app.config.RenderNotFoundStatus),
+		)
+	}
+
+	if err := app.findPickleEncoder().Validate(); err != nil {
+		logger.Fatal("invalid pickle config", zap.Error(err))
+	}
+
+	if f := app.config.UnknownFormat; f != "" {
+		if _, err := app.checkFormat(f, append(renderFormats, findFormats...)); err != nil {
+			logger.Fatal("invalid format for unknown formats", zap.Error(err))
+		}
+	}
+
+	events, eventsErr := newEventsProxy(app.config.Events)
+	if eventsErr != nil {
+		logger.Fatal("invalid events config", zap.Error(eventsErr))
+	}
+	app.events = events
+
+	if t := app.config.PromQL.PathTemplate; t != "" {
+		var err error
+		if app.promqlTemplate, err = promql.ParsePathTemplate(t); err != nil {
+			logger.Fatal("invalid PromQL path template", zap.Error(err))
+		}
+	}
+
+	if app.config.Tenants.Enabled && len(app.config.Tenants.Keys) == 0 {
+		logger.Fatal("tenants enabled without the keys that name them")
+	}
+	for key, tenant := range app.config.Tenants.Keys {
+		if key == "" || tenant == "" {
+			logger.Fatal("invalid tenant key, the key and the tenant must not be empty",
+				zap.String("tenant", tenant),
+			)
+		}
+	}
+	for tenant, weight := range app.config.Tenants.Weights {
+		if weight <= 0 {
+			logger.Fatal("invalid tenant weight, it must be positive",
+				zap.String("tenant", tenant),
+				zap.Float64("weight", weight),
+			)
+		}
+	}
+
+	if configFile, ok := app.config.FunctionsConfigs["macro"]; ok {
+		if _, err := macro.Load(configFile); err != nil {
+			logger.Fatal("invalid macro config", zap.String("file", configFile), zap.Error(err))
+		}
+	}
 	functions.New(app.config.FunctionsConfigs, logger)
 
+	if m := app.config.Admission.Memory; (m.HeapWatermark > 0 || m.GCPauseWatermark > 0) && m.CheckInterval <= 0 {
+		logger.Fatal("invalid memory check interval, it must be positive",
+			zap.Duration("check_interval", m.CheckInterval),
+		)
+	}
+	if app.config.FindTree.Enabled {
+		if app.config.FindTree.TTL <= 0 {
+			logger.Fatal("invalid find tree TTL, it must be positive",
+				zap.Duration("ttl", app.config.FindTree.TTL),
+			)
+		}
+		app.findTree = metrictree.New(app.config.FindTree.TTL, app.config.FindTree.MaxEntries)
+	}
+
+	ns, nsErr := newNamespaces(app.config.NamespaceMetrics)
+	if nsErr != nil {
+		logger.Fatal("invalid namespace metrics config", zap.Error(nsErr))
+	}
+	app.namespaces = ns
+
+	app.admission = newAdmissionController(app.config.Admission)
+	app.renderJobs = newRenderJobs(app.config.AsyncRender)
+	app.inFlight = newInFlightRequests()
+	app.verifier = newVerifier(app.config.Verify)
+
+	standingQueries, sqErr := newStandingQueries(app.config.StandingQueries)
+	if sqErr != nil {
+		logger.Fatal("invalid standing queries", zap.Error(sqErr))
+	}
+	app.standingQueries = standingQueries
+
+	queryRefs, qrErr := app.newQueryRefs(app.config.QueryRefs, app.config.QueryRefsFile)
+	if qrErr != nil {
+		logger.Fatal("invalid query refs", zap.Error(qrErr))
+	}
+	app.queryRefs = queryRefs
+
+	if err := checkWriteBack(app.config.WriteBack); err != nil {
+		logger.Fatal("invalid write back config", zap.Error(err))
+	}
+
 	// TODO (grzkv): Move expvars to init since they are global to the package
 	expvar.Publish("config", expvar.Func(func() interface{} { return app.config }))
 
@@ -220,16 +415,55 @@ func setUpConfig(app *App, logger *zap.Logger) {
 		})
 		expvar.Publish("cache_items", apiMetrics.CacheItems)
 
+	case "peered":
+		if app.config.Cache.Self == "" {
+			logger.Fatal("peered cache requested but the address of this instance among the peers is not set")
+		}
+		if app.config.Cache.Secret == "" {
+			logger.Fatal("peered cache requested but the secret of the peers is not set")
+		}
+		logger.Info("peered cache configured",
+			zap.String("self", app.config.Cache.Self),
+			zap.Strings("peers", app.config.Cache.Peers),
+		)
+
+		timeout := time.Duration(app.config.Cache.QueryTimeoutMs) * time.Millisecond
+		queryCache := cache.NewPeered("query", app.config.Cache.Self, app.config.Cache.Secret, app.config.Cache.Peers,
+			cache.NewExpireCache(uint64(app.config.Cache.Size*1024*1024)), timeout)
+		findCache := cache.NewPeered("find", app.config.Cache.Self, app.config.Cache.Secret, app.config.Cache.Peers,
+			cache.NewExpireCache(uint64(app.config.Cache.Size*1024*1024)), timeout)
+
+		app.queryCache = queryCache
+		app.findCache = findCache
+		app.cachePeers = []*cache.Peered{queryCache, findCache}
+
 	case "null":
 		// defaults
 		app.queryCache = cache.NullCache{}
 		app.findCache = cache.NullCache{}
 	default:
-		logger.Error("unknown cache type",
-			zap.String("cache_type", app.config.Cache.Type),
-			zap.Strings("known_cache_types", []string{"null", "mem", "memcache"}),
-		)
+		if !cache.Registered(app.config.Cache.Type) {
+			logger.Error("unknown cache type",
+				zap.String("cache_type", app.config.Cache.Type),
+				zap.Strings("known_cache_types", []string{"null", "mem", "memcache", "memcacheReplicated", "peered"}),
+			)
+			break
+		}
+
+		var err error
+		app.queryCache, err = cache.New(app.config.Cache.Type, app.config.Cache)
+		if err != nil {
+			logger.Fatal("failed to set up query cache", zap.String("cache_type", app.config.Cache.Type), zap.Error(err))
+		}
+		app.findCache, err = cache.New(app.config.Cache.Type, app.config.Cache)
+		if err != nil {
+			logger.Fatal("failed to set up find cache", zap.String("cache_type", app.config.Cache.Type), zap.Error(err))
+		}
 	}
+	app.queryCache = cache.NewInstrumented("query", app.queryCache,
+		app.prometheusMetrics.CacheRequests, app.prometheusMetrics.CacheDurations)
+	app.findCache = cache.NewInstrumented("find", app.findCache,
+		app.prometheusMetrics.CacheRequests, app.prometheusMetrics.CacheDurations)
 
 	if app.config.TimezoneString != "" {
 		fields := strings.Split(app.config.TimezoneString, ",")
@@ -374,7 +608,7 @@ func setUpConfig(app *App, logger *zap.Logger) {
 	if app.config.PidFile != "" {
 		pidfile.SetPidfilePath(app.config.PidFile)
 	}
-	err := pidfile.Write()
+	err = pidfile.Write()
 	if err != nil && !pidfile.IsNotConfigured(err) {
 		logger.Fatal("error during pidfile.Write()",
 			zap.Error(err),
@@ -464,10 +698,31 @@ func (app *App) bucketRequestTimes(req *http.Request, t time.Duration) {
 	}
 }
 
-func initBackend(config cfg.API, logger *zap.Logger, activeUpstreamRequests, waitingUpstreamRequests prometheus.Gauge) (backend.Backend, error) {
+func initBackend(config cfg.API, logger *zap.Logger, activeUpstreamRequests, waitingUpstreamRequests prometheus.Gauge, protocolFallbacks *prometheus.CounterVec) (backend.Backend, error) {
+	b, err := newBackend(config, logger, activeUpstreamRequests, waitingUpstreamRequests, protocolFallbacks)
+	if err != nil {
+		return b, err
+	}
+
+	host := config.Backends[0]
+	if rewrites := config.BackendRewrites[host]; len(rewrites) > 0 {
+		rb, err := brewrite.New(b, rewrites)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+		}
+		return rb, nil
+	}
+
+	return b, nil
+}
+
+func newBackend(config cfg.API, logger *zap.Logger, activeUpstreamRequests, waitingUpstreamRequests prometheus.Gauge, protocolFallbacks *prometheus.CounterVec) (backend.Backend, error) {
 	client := &http.Client{}
 	client.Transport = &http.Transport{
 		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
+		IdleConnTimeout:     config.IdleConnTimeout,
+		DisableCompression:  !config.BackendCompression,
+		ForceAttemptHTTP2:   config.BackendHTTP2,
 		DialContext: (&net.Dialer{
 			Timeout:   config.Timeouts.Connect,
 			KeepAlive: config.KeepAliveInterval,
@@ -481,15 +736,98 @@ func initBackend(config cfg.API, logger *zap.Logger, activeUpstreamRequests, wai
 	}
 	host := config.Backends[0]
 
+	if strings.HasPrefix(host, bgrpc.Scheme+"://") {
+		b, err := bgrpc.New(bgrpc.Config{
+			Address:            host,
+			PoolSize:           config.GRPCPoolSize,
+			Timeout:            config.Timeouts.AfterStarted,
+			ConnectTimeout:     config.Timeouts.Connect,
+			KeepAliveInterval:  config.KeepAliveInterval,
+			Limit:              config.ConcurrencyLimitPerServer,
+			MaxQueueDepth:      config.MaxQueueDepthPerServer,
+			PathCacheExpirySec: uint32(config.ExpireDelaySec),
+			Logger:             logger,
+			UserAgent:          config.BackendUserAgent("carbonapi", BuildVersion),
+			ActiveRequests:     activeUpstreamRequests,
+			WaitingRequests:    waitingUpstreamRequests,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+		}
+
+		return b, nil
+	}
+	if strings.HasPrefix(host, binfluxdb.Scheme+"://") {
+		b, err := binfluxdb.New(binfluxdb.Config{
+			Address:            host,
+			Client:             client,
+			Timeout:            config.Timeouts.AfterStarted,
+			PathCacheExpirySec: uint32(config.ExpireDelaySec),
+			Logger:             logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+		}
+
+		return b, nil
+	}
+	if strings.HasPrefix(host, bopentsdb.Scheme+"://") {
+		b, err := bopentsdb.New(bopentsdb.Config{
+			Address:            host,
+			Client:             client,
+			Timeout:            config.Timeouts.AfterStarted,
+			PathCacheExpirySec: uint32(config.ExpireDelaySec),
+			Logger:             logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+		}
+
+		return b, nil
+	}
+	if strings.HasPrefix(host, bwhisper.Scheme+"://") {
+		b, err := bwhisper.New(bwhisper.Config{
+			Address: host,
+			Logger:  logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+		}
+
+		return b, nil
+	}
+	if strings.HasPrefix(host, brrd.Scheme+"://") {
+		b, err := brrd.New(brrd.Config{
+			Address: host,
+			Logger:  logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+		}
+
+		return b, nil
+	}
+
 	b, err := bnet.New(bnet.Config{
 		Address:            host,
 		Client:             client,
 		Timeout:            config.Timeouts.AfterStarted,
 		Limit:              config.ConcurrencyLimitPerServer,
+		MaxQueueDepth:      config.MaxQueueDepthPerServer,
 		PathCacheExpirySec: uint32(config.ExpireDelaySec),
 		Logger:             logger,
+		Protocol:           config.BackendProtocol,
+		UserAgent:          config.BackendUserAgent("carbonapi", BuildVersion),
+		QueryParams:        config.BackendQueryParams,
+		Compression:        config.BackendCompression,
+		FindProtocol:       config.BackendEndpointProtocols[host].Find,
+		RenderProtocol:     config.BackendEndpointProtocols[host].Render,
+		InfoProtocol:       config.BackendEndpointProtocols[host].Info,
 		ActiveRequests:     activeUpstreamRequests,
 		WaitingRequests:    waitingUpstreamRequests,
+		FallbackProtocol:   config.BackendFallbackProtocol,
+		ProtocolFallbacks:  protocolFallbacks,
+		TenantWeights:      config.Tenants.Weights,
 	})
 
 	if err != nil {
@@ -2,16 +2,22 @@ package carbonapi
 
 import (
 	"context"
+	stdjson "encoding/json"
 	"errors"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/bookingcom/carbonapi/blocker"
 	"github.com/bookingcom/carbonapi/cache"
 	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	bgrpc "github.com/bookingcom/carbonapi/pkg/backend/grpc"
 	"github.com/bookingcom/carbonapi/pkg/backend/mock"
 	types "github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/json"
@@ -56,7 +62,7 @@ func render(ctx context.Context, request types.RenderRequest) ([]types.Metric, e
 			StopTime:  1510913880,
 			StepTime:  60,
 			Values:    []float64{0, 1510913759, 1510913818},
-			IsAbsent:  []bool{true, false, false},
+			IsAbsent:  types.AbsenceOf(true, false, false),
 		},
 	}, nil
 }
@@ -119,11 +125,24 @@ func TestMain(m *testing.M) {
 
 func TestAppHandlers(t *testing.T) {
 	t.Run("RenderHandler", renderHandler)
+	t.Run("RenderHandlerJSONP", renderHandlerJSONP)
 	t.Run("RenderHandlerErrors", renderHandlerErrs)
 	t.Run("RenderHandlerNotFoundErrors", renderHandlerNotFoundErrs)
+	t.Run("RenderHandlerNotFoundStatus", renderHandlerNotFoundStatus)
+	t.Run("RenderHandlerPartialErrors", renderHandlerPartialErrs)
+	t.Run("RenderHandlerV2", renderHandlerV2)
+	t.Run("RenderBatchHandler", renderBatchHandler)
+	t.Run("RenderBatchHandlerErrors", renderBatchHandlerErrs)
+	t.Run("RenderBatchHandlerTimeZones", renderBatchHandlerTimeZones)
 	t.Run("FindHandler", findHandler)
 	t.Run("FindHandlerCompleter", findHandlerCompleter)
+	t.Run("FindHandlerPickleErrors", findHandlerPickleErrs)
 	t.Run("RenderHandlerNotFoundErrors", infoHandler)
+	t.Run("InvalidTargets", invalidTargets)
+	t.Run("AdmissionControl", admissionControl)
+	t.Run("FunctionsHandler", functionsHandler)
+	t.Run("RenderAsyncHandler", renderAsyncHandler)
+	t.Run("RenderParseError", renderParseError)
 }
 
 func SetUpTestConfig() (*App, http.Handler) {
@@ -213,6 +232,32 @@ func renderHandler(t *testing.T) {
 	}
 }
 
+func renderHandlerJSONP(t *testing.T) {
+	req := httptest.NewRequest("GET",
+		"/render?target=foo.bar&from=-10minutes&format=json&noCache=1&noNullPoints=true&jsonp=angular.callbacks._0", nil)
+	rr := httptest.NewRecorder()
+
+	testApp.backend = mock.New(mock.Config{
+		Find:   find,
+		Info:   info,
+		Render: render,
+	})
+
+	testRouter.ServeHTTP(rr, req)
+
+	expected := `angular.callbacks._0([{"target":"foo.bar","datapoints":[[1510913759,1510913340],[1510913818,1510913400]]}])`
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if rr.Header().Get("Content-Type") != contentTypeJavaScript {
+		t.Errorf("Expected content type %s, got %s", contentTypeJavaScript, rr.Header().Get("Content-Type"))
+	}
+	if expected != rr.Body.String() {
+		t.Errorf("Expected %s, got %s", expected, rr.Body.String())
+	}
+}
+
 func renderHandlerErrs(t *testing.T) {
 	tests := []struct {
 		req     string
@@ -279,6 +324,333 @@ func renderHandlerNotFoundErrs(t *testing.T) {
 	}
 }
 
+func renderHandlerNotFoundStatus(t *testing.T) {
+	testApp.backend = mock.New(mock.Config{
+		Find:   find,
+		Info:   info,
+		Render: renderErrNotFound,
+	})
+
+	tests := []struct {
+		query   string
+		expCode int
+		expBody string
+	}{
+		{"", http.StatusOK, "[]"},
+		{"&notFoundStatus=200", http.StatusOK, "[]"},
+		{"&notFoundStatus=404", http.StatusNotFound, ""},
+		{"&notFoundStatus=500", http.StatusBadRequest, ""},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.query, func(t *testing.T) {
+			req := httptest.NewRequest("GET",
+				"/render/?target=foo.bar&from=-10minutes&format=json&noCache=1"+tst.query, nil)
+			rr := httptest.NewRecorder()
+
+			testRouter.ServeHTTP(rr, req)
+
+			if rr.Code != tst.expCode {
+				t.Errorf("Expected status code %d, got %d", tst.expCode, rr.Code)
+			}
+			if tst.expBody != "" && strings.TrimSpace(rr.Body.String()) != tst.expBody {
+				t.Errorf("Expected body %s, got %s", tst.expBody, rr.Body.String())
+			}
+		})
+	}
+}
+
+func renderHandlerPartialErrs(t *testing.T) {
+	// WARNING: Test results depend on the order of execution now. ENJOY THE GLOBAL STATE!!!
+	// TODO (grzkv): Fix this
+	testApp.backend = mock.New(mock.Config{
+		Find: func(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+			return types.Matches{
+				Name:    request.Query,
+				Matches: []types.Match{{Path: request.Query, IsLeaf: true}},
+			}, nil
+		},
+		Info: info,
+		Render: func(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+			if request.Targets[0] == "foo.fail" {
+				return renderErr(ctx, request)
+			}
+			return render(ctx, request)
+		},
+	})
+
+	tests := []struct {
+		req     string
+		expCode int
+		failed  []targetError
+	}{
+		{
+			req:     "/render/?target=foo.bar&target=foo.fail&from=-10minutes&format=json&noCache=1",
+			expCode: http.StatusOK,
+			failed:  []targetError{{Target: "foo.fail", Error: "error during render"}},
+		},
+		{
+			req:     "/render/?target=foo.fail&target=sum(foo.fail)&from=-10minutes&format=json&noCache=1",
+			expCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tst := range tests {
+		tst := tst
+		t.Run(tst.req, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tst.req, nil)
+			rr := httptest.NewRecorder()
+			testRouter.ServeHTTP(rr, req)
+
+			if rr.Code != tst.expCode {
+				t.Fatalf("Expected status code %d, got %d", tst.expCode, rr.Code)
+			}
+			if tst.failed == nil {
+				return
+			}
+
+			var failed []targetError
+			if err := stdjson.Unmarshal([]byte(rr.Header().Get(errorsHeader)), &failed); err != nil {
+				t.Fatalf("could not decode %s: %v", errorsHeader, err)
+			}
+			if len(failed) != 1 || failed[0].Target != tst.failed[0].Target || !strings.Contains(failed[0].Error, tst.failed[0].Error) {
+				t.Errorf("expected failed targets %v, got %v", tst.failed, failed)
+			}
+
+			var series []stdjson.RawMessage
+			if err := stdjson.Unmarshal(rr.Body.Bytes(), &series); err != nil || len(series) != 1 {
+				t.Errorf("expected the series of the other target, got %s", rr.Body.String())
+			}
+		})
+	}
+
+	req := httptest.NewRequest("GET", "/render/?target=foo.bar&target=foo.fail&from=-10minutes&format=json&noCache=1&withErrors=1", nil)
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	var got struct {
+		Series []stdjson.RawMessage `json:"series"`
+		Errors []targetError        `json:"errors"`
+	}
+	if err := stdjson.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(got.Series) != 1 || len(got.Errors) != 1 || got.Errors[0].Target != "foo.fail" {
+		t.Errorf("expected a series and an error in the body, got %s", rr.Body.String())
+	}
+}
+
+func renderHandlerV2(t *testing.T) {
+	req := httptest.NewRequest("GET", "/v2/render/?target=foo.bar&target=foo.fail&from=-10minutes&format=json&noCache=1", nil)
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	var got struct {
+		Series []stdjson.RawMessage `json:"series"`
+		Errors []targetError        `json:"errors"`
+	}
+	if err := stdjson.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(got.Series) != 1 || len(got.Errors) != 1 {
+		t.Errorf("expected v2 to answer the failed targets in the body, got %s", rr.Body.String())
+	}
+
+	req = httptest.NewRequest("GET", "/v2/render/?target=foo.bar&from=now&until=-1h&format=json", nil)
+	rr = httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	var gotErr struct {
+		Error   string `json:"error"`
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	}
+	if err := stdjson.Unmarshal(rr.Body.Bytes(), &gotErr); err != nil {
+		t.Fatalf("could not decode error %q: %v", rr.Body.String(), err)
+	}
+	if rr.Code != http.StatusBadRequest || gotErr.Code != http.StatusBadRequest || gotErr.Message == "" {
+		t.Errorf("expected a JSON 400, got %d %s", rr.Code, rr.Body.String())
+	}
+}
+
+func renderBatchHandler(t *testing.T) {
+	body := `[
+		{"id": "a", "target": "foo.bar", "from": "-10minutes"},
+		{"target": "foo.bar(", "from": "-10minutes"},
+		{"target": "foo.bar", "from": "now", "until": "-1h"},
+		// multi-line targets are flattened
+		{"id": "b", "target": "sumSeries(
+			foo.bar
+		)", "from": "-10minutes"}
+	]`
+	req := httptest.NewRequest("POST", "/render/batch?noCache=1", strings.NewReader(body))
+	rr := httptest.NewRecorder()
+
+	// WARNING: Test results depend on the order of execution now. ENJOY THE GLOBAL STATE!!!
+	// TODO (grzkv): Fix this
+	testApp.backend = mock.New(mock.Config{
+		Find:   find,
+		Info:   info,
+		Render: render,
+	})
+
+	testRouter.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	var got map[string]renderBatchResult
+	if err := stdjson.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatalf("could not decode response: %v", err)
+	}
+	if len(got) != 4 {
+		t.Fatalf("Expected 4 results, got %d", len(got))
+	}
+
+	expected := `[{"target":"foo.bar","datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}]`
+	if got["a"].Error != "" || string(got["a"].Datapoints) != expected {
+		t.Errorf("unexpected result for query a: %+v", got["a"])
+	}
+	if got["1"].Error == "" {
+		t.Error("Expected parse error for query 1")
+	}
+	if got["2"].Error == "" {
+		t.Error("Expected time range error for query 2")
+	}
+	if got["b"].Error != "" || got["b"].Target != "sumSeries( foo.bar )" {
+		t.Errorf("unexpected result for query b: %+v", got["b"])
+	}
+}
+
+func renderBatchHandlerTimeZones(t *testing.T) {
+	var mu sync.Mutex
+	from := make(map[string]int64)
+	testApp.backend = mock.New(mock.Config{
+		Find: find,
+		Info: info,
+		Render: func(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+			mu.Lock()
+			from[request.Targets[0]] = request.From
+			mu.Unlock()
+			return nil, nil
+		},
+	})
+
+	body := `[
+		{"id": "utc", "target": "foo.utc", "from": "00:00_20171117", "until": "12:00_20171117", "tz": "UTC"},
+		{"id": "tokyo", "target": "foo.tokyo", "from": "00:00_20171117", "until": "12:00_20171117", "tz": "Asia/Tokyo"}
+	]`
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, httptest.NewRequest("POST", "/render/batch?noCache=1", strings.NewReader(body)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if from["foo.utc"] != 1510876800 || from["foo.tokyo"] != 1510876800-9*3600 {
+		t.Errorf("expected each query to be read in its own time zone, got from %v", from)
+	}
+}
+
+func renderBatchHandlerErrs(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		body    string
+		expCode int
+	}{
+		{"GET", "GET", "", http.StatusMethodNotAllowed},
+		{"invalid JSON", "POST", "{", http.StatusBadRequest},
+		{"unterminated comment", "POST", `[{"target":"foo.bar"}] /*`, http.StatusBadRequest},
+		{"empty batch", "POST", "[]", http.StatusBadRequest},
+		{"duplicate id", "POST", `[{"id":"a","target":"foo.bar"},{"id":"a","target":"foo.bar"}]`, http.StatusBadRequest},
+		{"duplicate position id", "POST", `[{"target":"foo.bar"},{"id":"0","target":"foo.baz"}]`, http.StatusBadRequest},
+		{"too many queries", "POST", "[" + strings.Repeat(`{"target":"foo.bar"},`, 100) + `{"target":"foo.bar"}]`, http.StatusBadRequest},
+		{"too large", "POST", `[{"target":"foo.bar"}]` + strings.Repeat(" ", int(testApp.config.MaxRenderBatchBytes)), http.StatusRequestEntityTooLarge},
+	}
+
+	// invalid batches are rejected before any query is rendered
+	defer func(b backend.Backend) { testApp.backend = b }(testApp.backend)
+	testApp.backend = mock.New(mock.Config{
+		Find: find,
+		Info: info,
+		Render: func(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+			t.Errorf("unexpected render of %v", request.Targets)
+			return nil, nil
+		},
+	})
+
+	for _, tst := range tests {
+		tst := tst
+		t.Run(tst.name, func(t *testing.T) {
+			req := httptest.NewRequest(tst.method, "/render/batch", strings.NewReader(tst.body))
+			rr := httptest.NewRecorder()
+
+			testRouter.ServeHTTP(rr, req)
+
+			if rr.Code != tst.expCode {
+				t.Errorf("Expected status code %d, got %d", tst.expCode, rr.Code)
+			}
+		})
+	}
+}
+
+func invalidTargets(t *testing.T) {
+	long := strings.Repeat("a", testApp.config.MaxTargetLength+1)
+	depth := testApp.config.ParserLimits.MaxDepth + 1
+	deep := strings.Repeat("absolute(", depth) + "foo.bar" + strings.Repeat(")", depth)
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+	}{
+		{"render newline", "GET", "/render/?target=foo.bar%0Afoo.baz&format=json", ""},
+		{"render null byte", "GET", "/render/?target=foo.bar&target=foo%00bar&format=json", ""},
+		{"render too long", "GET", "/render/?format=json&target=" + long, ""},
+		{"render too deep", "GET", "/render/?format=json&target=" + deep, ""},
+		{"render jsonp", "GET", "/render/?target=foo.bar&format=json&jsonp=alert(1)//", ""},
+		{"render batch", "POST", "/render/batch", `[{"target":"foo.bar"},{"target":"foo\u0000bar"}]`},
+		{"find", "GET", "/metrics/find/?format=json&query=foo%0D.bar", ""},
+		{"find too long", "GET", "/metrics/find/?format=json&query=" + long, ""},
+		{"find jsonp", "GET", "/metrics/find/?format=json&query=foo.bar&jsonp=%3Cscript%3E", ""},
+		{"render unknown format", "GET", "/render/?target=foo.bar&format=xml", ""},
+		{"find unknown format", "GET", "/metrics/find/?format=xml&query=foo.bar", ""},
+		{"info unknown format", "GET", "/info/?format=csv&target=foo.bar", ""},
+		{"info", "GET", "/info/?target=foo%1B.bar", ""},
+	}
+
+	for _, tst := range tests {
+		tst := tst
+		t.Run(tst.name, func(t *testing.T) {
+			req := httptest.NewRequest(tst.method, tst.url, strings.NewReader(tst.body))
+			rr := httptest.NewRecorder()
+
+			testRouter.ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusBadRequest {
+				t.Errorf("Expected status code %d, got %d", http.StatusBadRequest, rr.Code)
+			}
+		})
+	}
+}
+
+func admissionControl(t *testing.T) {
+	admission := testApp.admission
+	defer func() { testApp.admission = admission }()
+	testApp.admission = newAdmissionController(cfg.AdmissionConfig{MaxMetricsPerRequest: 1})
+
+	req := httptest.NewRequest("GET", "/render/?target=foo.b*&from=-10minutes&format=json&noCache=1", nil)
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status code %d, got %d", http.StatusRequestEntityTooLarge, rr.Code)
+	}
+}
+
 func findHandler(t *testing.T) {
 	req := httptest.NewRequest("GET", "/metrics/find/?query=foo.bar&format=json", nil)
 	rr := httptest.NewRecorder()
@@ -297,6 +669,63 @@ func findHandler(t *testing.T) {
 	}
 }
 
+// failingResponseWriter fails to write the body of a response.
+type failingResponseWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w failingResponseWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func findHandlerPickleErrs(t *testing.T) {
+	t.Run("invalid protocol", func(t *testing.T) {
+		defer func(protocol int) { testApp.config.Pickle.Protocol = protocol }(testApp.config.Pickle.Protocol)
+		testApp.config.Pickle.Protocol = 1
+
+		errs := apiMetrics.Errors.Value()
+		req := httptest.NewRequest("GET", "/metrics/find/?query=foo.bar&format=pickle", nil)
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusInternalServerError {
+			t.Errorf("expected status %d, got %d", http.StatusInternalServerError, rr.Code)
+		}
+		if got := apiMetrics.Errors.Value() - errs; got != 1 {
+			t.Errorf("expected 1 error counted, got %d", got)
+		}
+	})
+
+	t.Run("failed mid-stream", func(t *testing.T) {
+		errs := apiMetrics.Errors.Value()
+		req := httptest.NewRequest("GET", "/metrics/find/?query=foo.bar&format=pickle", nil)
+		func() {
+			defer func() {
+				if r := recover(); r != http.ErrAbortHandler {
+					t.Errorf("expected the response to be aborted, got %v", r)
+				}
+			}()
+			testRouter.ServeHTTP(failingResponseWriter{httptest.NewRecorder()}, req)
+		}()
+
+		if got := apiMetrics.Errors.Value() - errs; got != 1 {
+			t.Errorf("expected 1 error counted, got %d", got)
+		}
+	})
+
+	t.Run("client gone", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		errs := apiMetrics.Errors.Value()
+		req := httptest.NewRequest("GET", "/metrics/find/?query=foo.bar&format=pickle", nil).WithContext(ctx)
+		testRouter.ServeHTTP(failingResponseWriter{httptest.NewRecorder()}, req)
+
+		if got := apiMetrics.Errors.Value() - errs; got != 0 {
+			t.Errorf("expected no error counted, got %d", got)
+		}
+	})
+}
+
 func findHandlerCompleter(t *testing.T) {
 	testMetrics := []string{"foo.b/", "foo.bar"}
 	for _, testMetric := range testMetrics {
@@ -314,6 +743,49 @@ func findHandlerCompleter(t *testing.T) {
 	}
 }
 
+func functionsHandler(t *testing.T) {
+	req := httptest.NewRequest("GET", "/functions/", nil)
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("HttpStatusCode should be 200 OK, got %d", rr.Code)
+	}
+	var all map[string]map[string]interface{}
+	if err := stdjson.Unmarshal(rr.Body.Bytes(), &all); err != nil {
+		t.Fatal(err)
+	}
+	if all["sumSeries"]["function"] != "sumSeries(*seriesLists)" {
+		t.Errorf("unexpected description of sumSeries: %v", all["sumSeries"])
+	}
+
+	req = httptest.NewRequest("GET", "/functions/sumSeries", nil)
+	rr = httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("HttpStatusCode should be 200 OK, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != contentTypeJSON {
+		t.Errorf("expected content type %s, got %s", contentTypeJSON, ct)
+	}
+	var one map[string]interface{}
+	if err := stdjson.Unmarshal(rr.Body.Bytes(), &one); err != nil {
+		t.Fatal(err)
+	}
+	if one["name"] != "sumSeries" || one["group"] != "Combine" {
+		t.Errorf("unexpected description of sumSeries: %v", one)
+	}
+
+	req = httptest.NewRequest("GET", "/functions/noSuchFunction", nil)
+	rr = httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("HttpStatusCode should be 404 Not Found, got %d", rr.Code)
+	}
+}
+
 func infoHandler(t *testing.T) {
 	req := httptest.NewRequest("GET", "/info/?target=foo.bar&format=json", nil)
 	rr := httptest.NewRecorder()
@@ -335,3 +807,99 @@ func infoHandler(t *testing.T) {
 		t.Error("Http response should be same.")
 	}
 }
+
+func renderAsyncHandler(t *testing.T) {
+	testApp.backend = mock.New(mock.Config{
+		Find:   find,
+		Info:   info,
+		Render: render,
+	})
+
+	form := strings.NewReader("target=foo.bar&from=-10minutes&format=json&noCache=1")
+	req := httptest.NewRequest("POST", "/render/async", form)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-CTX-CarbonAPI-UUID", "client-chosen-id")
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Fatalf("HttpStatusCode should be 202 Accepted, got %d", rr.Code)
+	}
+	var status renderJobStatus
+	if err := stdjson.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if status.ID == "" || status.ID == "client-chosen-id" || rr.Header().Get("Location") != "/render/status/"+status.ID {
+		t.Fatalf("unexpected job %+v at %s", status, rr.Header().Get("Location"))
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); status.Status == renderJobRunning; {
+		if time.Now().After(deadline) {
+			t.Fatal("render job did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		rr = httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, httptest.NewRequest("GET", "/render/status/"+status.ID, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("HttpStatusCode should be 200 OK, got %d", rr.Code)
+		}
+		if err := stdjson.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status.Status != renderJobDone || status.Code != http.StatusOK {
+		t.Fatalf("unexpected job status %+v", status)
+	}
+
+	rr = httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, httptest.NewRequest("GET", "/render/result/"+status.ID, nil))
+
+	expected := `[{"target":"foo.bar","datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818,1510913400]]}]`
+	if rr.Code != http.StatusOK {
+		t.Errorf("HttpStatusCode should be 200 OK, got %d", rr.Code)
+	}
+	if rr.Body.String() != expected {
+		t.Errorf("unexpected result %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, httptest.NewRequest("GET", "/render/result/unknown", nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("HttpStatusCode should be 404 Not Found, got %d", rr.Code)
+	}
+}
+
+func renderParseError(t *testing.T) {
+	req := httptest.NewRequest("GET", "/render/?format=json&target=sumSeries(foo.bar%20baz)", nil)
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Fatalf("HttpStatusCode should be 400 Bad Request, got %d", rr.Code)
+	}
+	if ct := rr.Header().Get("Content-Type"); ct != contentTypeJSON {
+		t.Errorf("expected content type %s, got %s", contentTypeJSON, ct)
+	}
+
+	var got map[string]interface{}
+	if err := stdjson.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["offset"] != float64(18) || got["token"] != "baz" || got["message"] != "unexpected character" {
+		t.Errorf("unexpected parse error %v", got)
+	}
+}
+
+func TestNewBackendGRPC(t *testing.T) {
+	config := cfg.DefaultAPIConfig()
+	config.Backends = []string{"grpc://127.0.0.1:1"}
+
+	b, err := newBackend(config, zap.NewNop(), nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := b.(*bgrpc.Backend); !ok {
+		t.Errorf("expected a gRPC backend, got %T", b)
+	}
+}
@@ -0,0 +1,110 @@
+package carbonapi
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.opentelemetry.io/otel/api/trace"
+	"go.uber.org/zap"
+)
+
+// eventsProxy passes the requests of the events API of graphite-web on to
+// a server that stores the events, for annotations to keep working without
+// graphite-web in front.
+type eventsProxy struct {
+	base      *url.URL
+	timeout   time.Duration
+	transport http.RoundTripper
+}
+
+// newEventsProxy returns nil if the events API is disabled.
+func newEventsProxy(config cfg.EventsConfig) (*eventsProxy, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+
+	base, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	if base.Scheme != "http" && base.Scheme != "https" || base.Host == "" {
+		return nil, fmt.Errorf("invalid events URL %s, expected http(s)://host[:port][/path]", config.URL)
+	}
+
+	return &eventsProxy{
+		base:      base,
+		timeout:   config.Timeout,
+		transport: http.DefaultTransport,
+	}, nil
+}
+
+// eventsPaths maps the paths of the events endpoints to those of
+// graphite-web, which redirects the ones without a trailing slash.
+var eventsPaths = map[string]string{
+	"/events":          "/events/",
+	"/events/get_data": "/events/get_data",
+}
+
+// eventsHandler proxies /events, to list and create events, and
+// /events/get_data, which Grafana reads annotations from.
+func (app *App) eventsHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.events.timeout)
+	defer cancel()
+	span := trace.SpanFromContext(ctx)
+	uuid := util.GetUUID(ctx)
+
+	toLog := carbonapipb.NewAccessLogDetails(r, "events", &app.config)
+	toLog.Format = jsonFormat
+	span.SetAttribute("graphite.username", toLog.Username)
+
+	logAsError := false
+	defer func() {
+		app.deferredAccessLogging(logger, r, &toLog, t0, logAsError)
+	}()
+
+	apiMetrics.Requests.Add(1)
+	app.prometheusMetrics.Requests.Inc()
+
+	path := eventsPaths[r.URL.Path]
+	proxy := &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = app.events.base.Scheme
+			req.URL.Host = app.events.base.Host
+			req.URL.Path = strings.TrimSuffix(app.events.base.Path, "/") + path
+			req.URL.RawPath = ""
+			req.Host = app.events.base.Host
+			req.Header.Set("X-Carbonapi-UUID", uuid)
+		},
+		Transport: app.events.transport,
+		ModifyResponse: func(resp *http.Response) error {
+			toLog.HttpCode = int32(resp.StatusCode)
+			if resp.StatusCode >= http.StatusInternalServerError {
+				toLog.Reason = "events server answered " + resp.Status
+				logAsError = true
+			}
+			resp.Header.Set("X-Carbonapi-UUID", uuid)
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, req *http.Request, err error) {
+			code := http.StatusBadGateway
+			if ctx.Err() == context.DeadlineExceeded {
+				code = http.StatusGatewayTimeout
+			}
+			writeError(uuid, r, w, code, "events server failed: "+err.Error(), jsonFormat, &toLog, span)
+			logAsError = true
+		},
+	}
+
+	proxy.ServeHTTP(w, r.WithContext(ctx))
+}
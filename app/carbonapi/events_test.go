@@ -0,0 +1,85 @@
+package carbonapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+
+	"go.uber.org/zap"
+)
+
+func TestEventsHandler(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		switch {
+		case r.URL.Path == "/graphite/events/get_data" && r.URL.Query().Get("tags") == "deploy":
+			_, _ = w.Write([]byte(`[{"what":"deploy","when":1510913280}]`))
+		case r.URL.Path == "/graphite/events/" && r.Method == "POST" && string(body) == `{"what":"deploy"}`:
+			w.WriteHeader(http.StatusCreated)
+		default:
+			http.Error(w, r.Method+" "+r.URL.String(), http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	events := testApp.events
+	defer func() { testApp.events = events }()
+
+	var err error
+	testApp.events, err = newEventsProxy(cfg.EventsConfig{URL: server.URL + "/graphite", Timeout: cfg.DefaultAPIConfig().Events.Timeout})
+	if err != nil {
+		t.Fatal(err)
+	}
+	router := initHandlers(testApp, zap.NewNop())
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		body   string
+		code   int
+		resp   string
+	}{
+		{"get data", "GET", "/events/get_data?from=-1d&tags=deploy", "", http.StatusOK, `[{"what":"deploy","when":1510913280}]`},
+		{"create", "POST", "/events/", `{"what":"deploy"}`, http.StatusCreated, ""},
+		{"server error", "GET", "/events/get_data?tags=none", "", http.StatusInternalServerError, ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if tt.resp != "" && rr.Body.String() != tt.resp {
+				t.Errorf("expected %s, got %s", tt.resp, rr.Body.String())
+			}
+		})
+	}
+
+	server.Close()
+	rr := httptest.NewRecorder()
+	router.ServeHTTP(rr, httptest.NewRequest("GET", "/events/get_data", nil))
+	if rr.Code != http.StatusBadGateway {
+		t.Errorf("expected status code %d without the events server, got %d", http.StatusBadGateway, rr.Code)
+	}
+}
+
+func TestNewEventsProxy(t *testing.T) {
+	if p, err := newEventsProxy(cfg.EventsConfig{}); p != nil || err != nil {
+		t.Errorf("expected no proxy without a URL, got %v (%v)", p, err)
+	}
+	for _, u := range []string{"graphite:8080", "ftp://graphite", "http://"} {
+		if _, err := newEventsProxy(cfg.EventsConfig{URL: u}); err == nil {
+			t.Errorf("%s: expected an error", u)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package carbonapi
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+// resolveGlobsFromTree answers the find of metric from the cache of the
+// metric tree, if it is enabled and has the nodes metric goes through.
+func (app *App) resolveGlobsFromTree(metric string) (dataTypes.Matches, bool) {
+	if app.findTree == nil {
+		return dataTypes.Matches{}, false
+	}
+
+	matches, ok := app.findTree.Get(metric)
+	if !ok {
+		app.prometheusMetrics.FindTreeRequests.WithLabelValues("miss").Inc()
+		return matches, false
+	}
+	app.prometheusMetrics.FindTreeRequests.WithLabelValues("hit").Inc()
+
+	return matches, true
+}
+
+// invalidateFindTree drops the nodes of the cache of the metric tree above
+// and under the one of the path parameter, or all of them without one, for
+// new or removed metrics to be found before the nodes expire.
+func (app *App) invalidateFindTree(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
+	t0 := time.Now()
+	apiMetrics.Requests.Add(1)
+	toLog := carbonapipb.NewAccessLogDetails(r, "invalidateFindTree", &app.config)
+
+	logAsError := false
+	defer func() {
+		app.deferredAccessLogging(logger, r, &toLog, t0, logAsError)
+	}()
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	if app.findTree == nil {
+		w.WriteHeader(http.StatusNotFound)
+		toLog.HttpCode = http.StatusNotFound
+		if _, err := w.Write([]byte(`{"success":"false"}`)); err != nil {
+			toLog.HttpCode = 499
+		}
+		return
+	}
+
+	path := r.FormValue("path")
+	app.findTree.Invalidate(path)
+	app.prometheusMetrics.FindTreeEntries.Set(float64(app.findTree.Entries()))
+	logger.Info("find tree invalidated", zap.String("path", path))
+
+	_, err := w.Write([]byte(`{"success":"true"}`))
+	toLog.HttpCode = http.StatusOK
+	if err != nil {
+		toLog.HttpCode = 499
+	}
+}
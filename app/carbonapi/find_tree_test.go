@@ -0,0 +1,71 @@
+package carbonapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/metrictree"
+	types "github.com/bookingcom/carbonapi/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+func TestFindTree(t *testing.T) {
+	var finds int64
+	backend, findTree := testApp.backend, testApp.findTree
+	defer func() {
+		testApp.backend, testApp.findTree = backend, findTree
+	}()
+	testApp.findTree = metrictree.New(time.Minute, 0)
+	testApp.backend = mock.New(mock.Config{
+		Find: func(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+			atomic.AddInt64(&finds, 1)
+			if request.Query != "tree.*" {
+				return types.Matches{}, types.ErrNotFound("not found")
+			}
+			return types.Matches{
+				Name: request.Query,
+				Matches: []types.Match{
+					{Path: "tree.bar", IsLeaf: true},
+					{Path: "tree.baz", IsLeaf: true},
+					{Path: "tree.qux", IsLeaf: true},
+				},
+			}, nil
+		},
+	})
+
+	find := func(query string) string {
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics/find?query="+query, nil))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("find of %s: expected status code 200, got %d", query, rr.Code)
+		}
+		return rr.Body.String()
+	}
+
+	find("tree.*")
+	if body := find("tree.ba*"); !strings.Contains(body, "tree.bar") || !strings.Contains(body, "tree.baz") || strings.Contains(body, "tree.qux") {
+		t.Errorf("unexpected matches of tree.ba*: %s", body)
+	}
+	if finds != 1 {
+		t.Errorf("expected tree.ba* to be answered from the tree, the backend got %d finds", finds)
+	}
+
+	internal := initHandlersInternal(testApp, zap.NewNop())
+	rr := httptest.NewRecorder()
+	internal.ServeHTTP(rr, httptest.NewRequest("GET", "/invalidate-find-tree?path=tree", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code 200 invalidating the tree, got %d", rr.Code)
+	}
+
+	find("tree.b*")
+	if finds != 2 {
+		t.Errorf("expected tree.b* to be found on the backend once invalidated, the backend got %d finds", finds)
+	}
+}
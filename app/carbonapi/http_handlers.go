@@ -6,21 +6,26 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/bookingcom/carbonapi/pkg/handlerlog"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"regexp"
 	"runtime/debug"
 	"strconv"
 	"strings"
 	"sync/atomic"
 	"time"
+	"unicode"
 
 	"github.com/bookingcom/carbonapi/carbonapipb"
 	"github.com/bookingcom/carbonapi/date"
-	"github.com/bookingcom/carbonapi/expr"
-	"github.com/bookingcom/carbonapi/expr/functions/cairo/png"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/metadata"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/cairo/png"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
 	ourJson "github.com/bookingcom/carbonapi/pkg/types/encoding/json"
@@ -47,6 +52,27 @@ const (
 	completerFormat = "completer"
 )
 
+// The formats of the responses of the handlers.
+var (
+	renderFormats = []string{jsonFormat, pngFormat, svgFormat, csvFormat, rawFormat, pickleFormat, protobufFormat, protobuf3Format}
+	findFormats   = []string{treejsonFormat, jsonFormat, completerFormat, rawFormat, pickleFormat, protobufFormat, protobuf3Format}
+	infoFormats   = []string{jsonFormat, protobufFormat, protobuf3Format}
+)
+
+// checkFormat returns format if it is one of the supported ones, or else
+// the format configured for unknown ones, if supported.
+func (app *App) checkFormat(format string, supported []string) (string, error) {
+	for _, f := range []string{format, app.config.UnknownFormat} {
+		for _, s := range supported {
+			if f == s {
+				return f, nil
+			}
+		}
+	}
+
+	return format, fmt.Errorf("unknown format %s, the supported formats are %s", format, strings.Join(supported, ", "))
+}
+
 // for testing
 // TODO (grzkv): Clean up
 var timeNow = time.Now
@@ -142,11 +168,38 @@ type renderResponse struct {
 	error error
 }
 
+// targetError is a target of a render request that failed, while others
+// did not.
+type targetError struct {
+	Target string `json:"target"`
+	Error  string `json:"error"`
+}
+
+// errorsHeader lists the targetErrors of a render response, in JSON.
+const errorsHeader = "X-Carbonapi-Errors"
+
+// withTargetErrors wraps a JSON render body in an object, along with the
+// targets that failed.
+func withTargetErrors(body []byte, failed []targetError) ([]byte, error) {
+	if failed == nil {
+		failed = []targetError{}
+	}
+
+	return json.Marshal(struct {
+		Series json.RawMessage `json:"series"`
+		Errors []targetError   `json:"errors"`
+	}{body, failed})
+}
+
 func (app *App) renderHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
 	t0 := time.Now()
 	size := 0
 
-	ctx, cancel := context.WithTimeout(r.Context(), app.config.Timeouts.Global)
+	timeout := app.config.Timeouts.Global
+	if isRenderJob(r.Context()) {
+		timeout = app.config.AsyncRender.Timeout
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
 	defer cancel()
 	span := trace.SpanFromContext(ctx)
 	uuid := util.GetUUID(ctx)
@@ -198,9 +251,22 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request, logger *za
 		return
 	}
 
+	var debugReport *renderDebug
+	if form.debug {
+		debugReport = &renderDebug{QueryCache: "disabled"}
+		ctx = withRenderDebug(ctx, debugReport)
+	}
+
+	// debug requests are evaluated for their report, rather than answered
+	// by a standing query
+	if sq, ok := app.standingQueries[form.cacheKey]; ok && !isStandingQuery(ctx) && !form.debug {
+		logAsError = app.serveStandingQuery(ctx, w, r, sq, form, &toLog) != nil
+		return
+	}
+
 	if form.useCache {
 		tc := time.Now()
-		response, cacheErr := app.queryCache.Get(form.cacheKey)
+		response, cacheErr := app.queryCache.Get(ctx, form.cacheKey)
 		td := time.Since(tc).Nanoseconds()
 		apiMetrics.RenderCacheOverheadNS.Add(td)
 
@@ -209,7 +275,13 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request, logger *za
 
 		if cacheErr == nil {
 			apiMetrics.RequestCacheHits.Add(1)
-			writeErr := writeResponse(ctx, w, response, form.format, form.jsonp)
+			var writeErr error
+			if debugReport != nil {
+				debugReport.QueryCache = "hit"
+				writeErr = writeRenderDebug(ctx, w, debugReport, t0, form.jsonp)
+			} else {
+				writeErr = writeResponse(ctx, w, response, form.format, form.jsonp)
+			}
 			if writeErr != nil {
 				logAsError = true
 			}
@@ -219,13 +291,25 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request, logger *za
 			return
 		}
 		apiMetrics.RequestCacheMisses.Add(1)
+		if debugReport != nil {
+			debugReport.QueryCache = "miss"
+		}
 	}
 	span.SetAttribute("from_cache", false)
 
 	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
+	ticket := app.admission.newTicket()
+	defer ticket.release()
+	ctx = interfaces.WithTimeZone(ctx, date.TimeZone(form.qtz, app.defaultTimeZone))
+	ctx = interfaces.WithStacks(ctx)
+	ctx = expr.WithMemo(ctx, metricMap)
+	ctx = withRenderFetches(ctx)
 
 	tracer := span.Tracer()
 	var results []*types.MetricData
+	verify := app.verifier.sample()
+	var verified []verifiedTarget
+	var failed []targetError
 	for targetIdx := 0; targetIdx < len(form.targets); targetIdx++ {
 		target := form.targets[targetIdx]
 		targetCtx, targetSpan := tracer.Start(ctx, "carbonapi render", trace.WithAttributes(
@@ -233,20 +317,22 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request, logger *za
 		))
 		exp, e, parseErr := parser.ParseExpr(target)
 		if parseErr != nil || e != "" {
-			msg := buildParseErrorString(target, e, parseErr)
-			writeError(uuid, r, w, http.StatusBadRequest, msg, form.format, &toLog, span)
+			writeParseError(uuid, r, w, target, e, parseErr, form.format, &toLog, span)
 			logAsError = true
 			return
 		}
 		targetSpan.AddEvent(targetCtx, "parsed expression")
+		if debugReport != nil {
+			debugReport.target(target, exp)
+		}
 
 		getTargetData := func(ctx context.Context, exp parser.Expr, from, until int32, metricMap map[parser.MetricRequest][]*types.MetricData) (error, int) {
-			return app.getTargetData(ctx, target, exp, metricMap, form.useCache, from, until, &toLog, logger, &partiallyFailed, targetSpan)
+			return app.getTargetData(ctx, target, exp, metricMap, form.useCache, from, until, ticket, &toLog, logger, &partiallyFailed, targetSpan)
 		}
 		targetSpan.AddEvent(targetCtx, "retrieved target data")
 
 		targetErr, metricSize := app.getTargetData(targetCtx, target, exp, metricMap,
-			form.useCache, form.from32, form.until32, &toLog, logger, &partiallyFailed, targetSpan)
+			form.useCache, form.from32, form.until32, ticket, &toLog, logger, &partiallyFailed, targetSpan)
 
 		// Continue query execution even though no metric is found in
 		// prefetch as there are Graphite query functions that are able
@@ -256,17 +342,23 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request, logger *za
 		//
 		// Refrence behaviour in graphite-web: https://github.com/graphite-project/graphite-web/blob/1.1.8/webapp/graphite/render/evaluator.py#L14-L46
 		var notFound dataTypes.ErrNotFound
+		evaluated := len(results)
 		if targetErr == nil || errors.As(targetErr, &notFound) {
 			targetErr = evalExprRender(targetCtx, exp, &results, metricMap, &form, app.config.PrintErrorStackTrace, getTargetData)
 		}
 		targetSpan.AddEvent(targetCtx, "evaluated expression")
 
 		if targetErr != nil {
+			if debugReport != nil {
+				debugReport.targetError(targetErr)
+			}
 			// we can have 3 error types here
 			// a) dataTypes.ErrNotFound  > Continue, at the end we check if all errors are 'not found' and we answer with http 404
 			// b) parser.ParseError -> Return with this error(like above, but with less details )
 			// c) anything else -> continue, answer will be 5xx if all targets have one error
 			var parseError parser.ParseError
+			var admissionErr admissionError
+			var overloaded dataTypes.ErrBackendOverloaded
 			switch {
 			case errors.As(targetErr, &notFound):
 				// When not found, graphite answers with  http 200 and []
@@ -288,6 +380,17 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request, logger *za
 				writeError(uuid, r, w, http.StatusBadRequest, targetErr.Error(), form.format, &toLog, span)
 				logAsError = true
 				return
+			case errors.As(targetErr, &admissionErr):
+				admissionErr.setHeaders(w.Header())
+				writeError(uuid, r, w, admissionErr.Code, admissionErr.Error(), form.format, &toLog, span)
+				app.prometheusMetrics.AdmissionRejections.WithLabelValues(admissionErr.Reason).Inc()
+				logAsError = true
+				return
+			case errors.As(targetErr, &overloaded):
+				w.Header().Set("Retry-After", strconv.Itoa(overloaded.RetryAfterSeconds()))
+				writeError(uuid, r, w, http.StatusServiceUnavailable, targetErr.Error(), form.format, &toLog, span)
+				logAsError = true
+				return
 			case errors.Is(err, context.DeadlineExceeded):
 				writeError(uuid, r, w, http.StatusUnprocessableEntity, "request too complex", form.format, &toLog, span)
 				logAsError = true
@@ -295,12 +398,22 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request, logger *za
 					"render", ctx.Err().Error(),
 				).Inc()
 				return
-			default:
+			case len(form.targets) == 1:
 				writeError(uuid, r, w, http.StatusInternalServerError, targetErr.Error(), form.format, &toLog, span)
 				logAsError = true
 				return
+			default:
+				// the other targets are answered, and the failure
+				// reported along
+				failed = append(failed, targetError{Target: target, Error: targetErr.Error()})
+				partiallyFailed = true
+				targetSpan.End()
+				continue
 			}
 		}
+		if verify {
+			verified = append(verified, verifiedTarget{target: target, exp: exp, results: results[evaluated:]})
+		}
 		size += metricSize
 		targetSpan.End()
 	}
@@ -312,22 +425,55 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request, logger *za
 		).Inc()
 	}
 
+	if len(failed) > 0 && len(failed) == len(form.targets) {
+		writeError(uuid, r, w, http.StatusInternalServerError, failed[0].Error, form.format, &toLog, span)
+		logAsError = true
+		return
+	}
+
+	if len(results) == 0 && len(failed) == 0 {
+		// like finds, the renders that match nothing are counted even when
+		// answered with 200 and an empty result
+		app.prometheusMetrics.RenderNotFound.Inc()
+		if form.notFoundStatus == http.StatusNotFound && debugReport == nil {
+			writeError(uuid, r, w, http.StatusNotFound, "no metrics found", form.format, &toLog, span)
+			return
+		}
+	}
+
 	body, err := app.renderWriteBody(results, form, r, logger)
+	if err == nil && form.withErrors {
+		body, err = withTargetErrors(body, failed)
+	}
 	if err != nil {
 		writeError(uuid, r, w, http.StatusInternalServerError, err.Error(), form.format, &toLog, span)
 		logAsError = true
 		return
 	}
+	if len(failed) > 0 {
+		b, _ := json.Marshal(failed)
+		w.Header().Set(errorsHeader, string(b))
+		toLog.Reason = fmt.Sprintf("%d of %d targets failed", len(failed), len(form.targets))
+	}
 
-	writeErr := writeResponse(ctx, w, body, form.format, form.jsonp)
+	var writeErr error
+	if debugReport != nil {
+		writeErr = writeRenderDebug(ctx, w, debugReport, t0, form.jsonp)
+	} else {
+		writeErr = writeResponse(ctx, w, body, form.format, form.jsonp)
+	}
 	if writeErr != nil {
 		toLog.HttpCode = 499
 	}
-	if len(results) != 0 {
+	if verify {
+		app.verify(verified, form.from32, form.until32, logger)
+	}
+	// partial responses are not cached, the failures may be transient
+	if len(results) != 0 && len(failed) == 0 {
 		tc := time.Now()
 		// TODO (grzkv): Timeout is passed as "expire" argument.
 		// Looks like things are mixed.
-		app.queryCache.Set(form.cacheKey, body, form.cacheTimeout)
+		app.queryCache.Set(ctx, form.cacheKey, body, form.cacheTimeout)
 		td := time.Since(tc).Nanoseconds()
 		apiMetrics.RenderCacheOverheadNS.Add(td)
 	}
@@ -338,6 +484,217 @@ func (app *App) renderHandler(w http.ResponseWriter, r *http.Request, logger *za
 	toLog.HttpCode = http.StatusOK
 }
 
+// renderBatchQuery is a single query of a /render/batch request.
+type renderBatchQuery struct {
+	ID            string `json:"id"`
+	Target        string `json:"target"`
+	From          string `json:"from"`
+	Until         string `json:"until"`
+	TZ            string `json:"tz"`
+	MaxDataPoints int    `json:"maxDataPoints"`
+}
+
+// renderBatchResult is the outcome of a single query of a /render/batch
+// request. Exactly one of Datapoints and Error is set.
+type renderBatchResult struct {
+	Target     string          `json:"target"`
+	Datapoints json.RawMessage `json:"data,omitempty"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// renderBatchHandler evaluates a JSON array of independent render queries and
+// answers with a JSON object holding the result of each query under its id.
+// Queries without an id are keyed by their position in the array. A failing
+// query does not fail the whole batch, its error is reported in its result.
+// The array may have comments, and its targets span several lines.
+func (app *App) renderBatchHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.Timeouts.Global)
+	defer cancel()
+	span := trace.SpanFromContext(ctx)
+	uuid := util.GetUUID(ctx)
+
+	partiallyFailed := false
+	toLog := carbonapipb.NewAccessLogDetails(r, "render_batch", &app.config)
+	toLog.Format = jsonFormat
+	span.SetAttribute("graphite.username", toLog.Username)
+
+	logAsError := false
+	defer func() {
+		app.deferredAccessLogging(logger, r, &toLog, t0, logAsError)
+	}()
+
+	apiMetrics.Requests.Add(1)
+	app.prometheusMetrics.Requests.Inc()
+
+	batch := r.Body
+	maxBytes := app.config.MaxRenderBatchBytes
+	if maxBytes > 0 {
+		batch = http.MaxBytesReader(w, r.Body, maxBytes)
+	}
+	doc, err := ioutil.ReadAll(batch)
+	if err != nil && maxBytes > 0 && int64(len(doc)) >= maxBytes {
+		msg := fmt.Sprintf("batch is larger than %d bytes", maxBytes)
+		writeError(uuid, r, w, http.StatusRequestEntityTooLarge, msg, jsonFormat, &toLog, span)
+		logAsError = true
+		return
+	}
+	if err == nil {
+		doc, err = stripQueryDocument(doc)
+	}
+	var queries []renderBatchQuery
+	if err == nil {
+		err = json.Unmarshal(doc, &queries)
+	}
+	if err != nil {
+		writeError(uuid, r, w, http.StatusBadRequest, "invalid batch: "+err.Error(), jsonFormat, &toLog, span)
+		logAsError = true
+		return
+	}
+	if len(queries) == 0 {
+		writeError(uuid, r, w, http.StatusBadRequest, "empty batch", jsonFormat, &toLog, span)
+		logAsError = true
+		return
+	}
+	if max := app.config.MaxRenderBatchQueries; max > 0 && len(queries) > max {
+		msg := fmt.Sprintf("batch has %d queries, at most %d are allowed", len(queries), max)
+		writeError(uuid, r, w, http.StatusBadRequest, msg, jsonFormat, &toLog, span)
+		logAsError = true
+		return
+	}
+
+	useCache := !parser.TruthyBool(r.URL.Query().Get("noCache"))
+	toLog.UseCache = useCache
+
+	ids := make(map[string]bool, len(queries))
+	for i := range queries {
+		queries[i].Target = flattenTarget(queries[i].Target)
+		if err := app.checkTarget(queries[i].Target); err != nil {
+			writeError(uuid, r, w, http.StatusBadRequest, err.Error(), jsonFormat, &toLog, span)
+			logAsError = true
+			return
+		}
+		if queries[i].ID == "" {
+			queries[i].ID = strconv.Itoa(i)
+		}
+		if ids[queries[i].ID] {
+			writeError(uuid, r, w, http.StatusBadRequest, "duplicate query id "+queries[i].ID, jsonFormat, &toLog, span)
+			logAsError = true
+			return
+		}
+		ids[queries[i].ID] = true
+		toLog.Targets = append(toLog.Targets, queries[i].Target)
+	}
+	setInFlightTargets(ctx, toLog.Targets)
+
+	ticket := app.admission.newTicket()
+	defer ticket.release()
+	ctx = withRenderFetches(ctx)
+
+	tracer := span.Tracer()
+	results := make(map[string]renderBatchResult, len(queries))
+	for _, q := range queries {
+		queryCtx, querySpan := tracer.Start(ctx, "carbonapi render", trace.WithAttributes(
+			kv.String("graphite.target", q.Target),
+		))
+		data, err := app.renderBatchQuery(queryCtx, q, useCache, ticket, &toLog, logger, &partiallyFailed, querySpan)
+		querySpan.End()
+
+		// the limits apply to the batch as a whole
+		var admissionErr admissionError
+		if errors.As(err, &admissionErr) {
+			admissionErr.setHeaders(w.Header())
+			writeError(uuid, r, w, admissionErr.Code, admissionErr.Error(), jsonFormat, &toLog, span)
+			app.prometheusMetrics.AdmissionRejections.WithLabelValues(admissionErr.Reason).Inc()
+			logAsError = true
+			return
+		}
+
+		res := renderBatchResult{Target: q.Target}
+		if err != nil {
+			res.Error = err.Error()
+			partiallyFailed = true
+		} else {
+			if q.MaxDataPoints != 0 {
+				data = types.ConsolidateJSON(q.MaxDataPoints, data)
+			}
+			res.Datapoints = types.MarshalJSON(data, false)
+		}
+		results[q.ID] = res
+	}
+
+	if ctx.Err() != nil {
+		app.prometheusMetrics.RequestCancel.WithLabelValues(
+			"render_batch", ctx.Err().Error(),
+		).Inc()
+	}
+
+	body, err := json.Marshal(results)
+	if err != nil {
+		writeError(uuid, r, w, http.StatusInternalServerError, err.Error(), jsonFormat, &toLog, span)
+		logAsError = true
+		return
+	}
+
+	if writeErr := writeResponse(ctx, w, body, jsonFormat, ""); writeErr != nil {
+		toLog.HttpCode = 499
+		return
+	}
+
+	if partiallyFailed {
+		app.prometheusMetrics.RenderPartialFail.Inc()
+	}
+	toLog.HttpCode = http.StatusOK
+}
+
+// renderBatchQuery evaluates a single query of a batch. Like /render, it
+// returns an empty result rather than an error when no metrics are found.
+func (app *App) renderBatchQuery(ctx context.Context, q renderBatchQuery, useCache bool, ticket *admissionTicket,
+	toLog *carbonapipb.AccessLogDetails, logger *zap.Logger, partFail *bool,
+	span trace.Span) ([]*types.MetricData, error) {
+
+	var form renderForm
+	var errFrom, errUntil error
+	form.qtz = q.TZ
+	form.from32, errFrom = date.DateParamToEpoch(q.From, q.TZ, timeNow().Add(-24*time.Hour).Unix(), app.defaultTimeZone)
+	form.until32, errUntil = date.DateParamToEpoch(q.Until, q.TZ, timeNow().Unix(), app.defaultTimeZone)
+	if errFrom != nil {
+		return nil, fmt.Errorf("%s, invalid parameter from=%s", errFrom.Error(), q.From)
+	}
+	if errUntil != nil {
+		return nil, fmt.Errorf("%s, invalid parameter until=%s", errUntil.Error(), q.Until)
+	}
+	if form.from32 >= form.until32 {
+		return nil, fmt.Errorf("parameter from=%s is not before parameter until=%s. Result time range is empty", q.From, q.Until)
+	}
+
+	exp, e, err := parser.ParseExpr(q.Target)
+	if err != nil || e != "" {
+		return nil, errors.New(buildParseErrorString(q.Target, e, err))
+	}
+
+	ctx = interfaces.WithTimeZone(ctx, date.TimeZone(q.TZ, app.defaultTimeZone))
+	ctx = interfaces.WithStacks(ctx)
+	metricMap := make(map[parser.MetricRequest][]*types.MetricData)
+	ctx = expr.WithMemo(ctx, metricMap)
+	getTargetData := func(ctx context.Context, exp parser.Expr, from, until int32, metricMap map[parser.MetricRequest][]*types.MetricData) (error, int) {
+		return app.getTargetData(ctx, q.Target, exp, metricMap, useCache, from, until, ticket, toLog, logger, partFail, span)
+	}
+
+	var results []*types.MetricData
+	var notFound dataTypes.ErrNotFound
+	err, _ = getTargetData(ctx, exp, form.from32, form.until32, metricMap)
+	if err == nil || errors.As(err, &notFound) {
+		err = evalExprRender(ctx, exp, &results, metricMap, &form, app.config.PrintErrorStackTrace, getTargetData)
+	}
+	if errors.As(err, &notFound) || errors.Is(err, parser.ErrSeriesDoesNotExist) {
+		return results, nil
+	}
+
+	return results, err
+}
+
 func writeError(uuid string,
 	r *http.Request, w http.ResponseWriter,
 	code int, s string, format string,
@@ -362,11 +719,68 @@ func writeError(uuid string,
 		if err != nil {
 			accessLogDetails.Reason += " 499"
 		}
+	} else if apiVersionFrom(r.Context()) >= apiV2 {
+		b, _ := json.Marshal(struct {
+			Error   string `json:"error"`
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}{
+			Error:   http.StatusText(code),
+			Code:    code,
+			Message: s,
+		})
+		w.Header().Set("X-Carbonapi-UUID", uuid)
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(code)
+		if _, err := w.Write(b); err != nil {
+			accessLogDetails.Reason += " 499"
+		}
 	} else {
 		http.Error(w, http.StatusText(code)+" ("+strconv.Itoa(code)+") Details: "+s, code)
 	}
 }
 
+// writeParseError answers 400 to a target that cannot be parsed, telling
+// where it is invalid in JSON, or in an image for the png format.
+func writeParseError(uuid string,
+	r *http.Request, w http.ResponseWriter,
+	target, rest string, err error, format string,
+	accessLogDetails *carbonapipb.AccessLogDetails,
+	span trace.Span) {
+	msg := buildParseErrorString(target, rest, err)
+	if format == pngFormat {
+		writeError(uuid, r, w, http.StatusBadRequest, msg, format, accessLogDetails, span)
+		return
+	}
+
+	var syntaxErr *parser.SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		// the expression was parsed, but the target goes on
+		syntaxErr = parser.NewSyntaxError(target, rest, parser.ErrUnexpectedCharacter)
+	}
+
+	accessLogDetails.HttpCode = http.StatusBadRequest
+	accessLogDetails.Reason = msg
+	span.SetAttribute("error", true)
+	span.SetAttribute("error.message", msg)
+
+	b, _ := json.Marshal(struct {
+		Error  string `json:"error"`
+		Target string `json:"target"`
+		*parser.SyntaxError
+	}{
+		Error:       http.StatusText(http.StatusBadRequest),
+		Target:      target,
+		SyntaxError: syntaxErr,
+	})
+	w.Header().Set("X-Carbonapi-UUID", uuid)
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(http.StatusBadRequest)
+	if _, err := w.Write(b); err != nil {
+		accessLogDetails.Reason += " 499"
+	}
+}
+
 func evalExprRender(ctx context.Context, exp parser.Expr, res *([]*types.MetricData),
 	metricMap map[parser.MetricRequest][]*types.MetricData,
 	form *renderForm, printErrorStackTrace bool, getTargetData interfaces.GetTargetData) (retErr error) {
@@ -379,6 +793,10 @@ func evalExprRender(ctx context.Context, exp parser.Expr, res *([]*types.MetricD
 		}
 	}()
 
+	exp, err := expr.RewriteExpr(ctx, exp, form.from32, form.until32, metricMap, getTargetData)
+	if err != nil {
+		return err
+	}
 	exprs, err := expr.EvalExpr(ctx, exp, form.from32, form.until32, metricMap, getTargetData)
 	if err != nil {
 		return err
@@ -391,7 +809,7 @@ func evalExprRender(ctx context.Context, exp parser.Expr, res *([]*types.MetricD
 
 func (app *App) getTargetData(ctx context.Context, target string, exp parser.Expr,
 	metricMap map[parser.MetricRequest][]*types.MetricData,
-	useCache bool, from, until int32,
+	useCache bool, from, until int32, ticket *admissionTicket,
 	toLog *carbonapipb.AccessLogDetails, lg *zap.Logger, partFail *bool,
 	span trace.Span) (error, int) {
 
@@ -400,10 +818,11 @@ func (app *App) getTargetData(ctx context.Context, target string, exp parser.Exp
 	var targetMetricFetches []parser.MetricRequest
 	var metricErrs []error
 
+	app.countNamespaceRequests(exp.Metrics())
 	for _, m := range exp.Metrics() {
 		mfetch := m
-		mfetch.From += from
-		mfetch.Until += until
+		mfetch.From += int64(from)
+		mfetch.Until += int64(until)
 
 		targetMetricFetches = append(targetMetricFetches, mfetch)
 		if _, ok := metricMap[mfetch]; ok {
@@ -412,7 +831,10 @@ func (app *App) getTargetData(ctx context.Context, target string, exp parser.Exp
 		}
 
 		// This _sometimes_ sends a *find* request
-		renderRequests, err := app.getRenderRequests(ctx, m, useCache, toLog)
+		renderRequests, metricCount, err := app.getRenderRequests(ctx, m, useCache, toLog)
+		if d := renderDebugFrom(ctx); d != nil {
+			d.metric(mfetch, renderRequests, err)
+		}
 		if err != nil {
 			metricErrs = append(metricErrs, err)
 			continue
@@ -420,6 +842,9 @@ func (app *App) getTargetData(ctx context.Context, target string, exp parser.Exp
 			metricErrs = append(metricErrs, dataTypes.ErrMetricsNotFound)
 			continue
 		}
+		if err := ticket.admit(ctx, metricCount, mfetch.From, mfetch.Until); err != nil {
+			return err, 0
+		}
 		renderRequestContext := ctx
 		subrequestCount := len(renderRequests)
 		if subrequestCount > 1 {
@@ -485,15 +910,23 @@ func optimistFanIn(errs []error, n int, subj string) (error, string) {
 	}
 
 	// everything failed.
-	// If all the failures are not-founds, it's a not-found
-	allErrorsNotFound := true
+	// If all the failures are not-founds, it's a not-found, and if they are
+	// all overloads, it's an overload
+	allErrorsNotFound, allErrorsOverloaded := true, true
+	var retryAfter time.Duration
 	errStr := ""
 	for _, e := range errs {
 		var notFound dataTypes.ErrNotFound
+		var overloaded dataTypes.ErrBackendOverloaded
 		errStr = errStr + e.Error() + ", "
 		if !errors.As(e, &notFound) {
 			allErrorsNotFound = false
 		}
+		if !errors.As(e, &overloaded) {
+			allErrorsOverloaded = false
+		} else if overloaded.RetryAfter > retryAfter {
+			retryAfter = overloaded.RetryAfter
+		}
 	}
 
 	if len(errStr) > 200 {
@@ -509,29 +942,78 @@ func optimistFanIn(errs []error, n int, subj string) (error, string) {
 			" not found; merged errs: (" + errStr + ")"), errStr
 	}
 
+	if allErrorsOverloaded {
+		return dataTypes.ErrBackendOverloaded{
+			Message:    "all " + subj + " overloaded; merged errs: (" + errStr + ")",
+			RetryAfter: retryAfter,
+		}, errStr
+	}
+
 	return errors.New("all " + subj +
 		" failed with mixed errrors; merged errs: (" + errStr + ")"), errStr
 }
 
 func (app *App) sendRenderRequest(ctx context.Context, ch chan<- renderResponse,
-	path string, from, until int32, toLog *carbonapipb.AccessLogDetails) {
+	path string, from, until int64, toLog *carbonapipb.AccessLogDetails) {
 
-	apiMetrics.RenderRequests.Add(1)
-	atomic.AddInt64(&toLog.ZipperRequests, 1)
+	// traced is the request of this fetch to the backend, unless it is
+	// shared with another
+	var traced *dataTypes.RenderRequest
+	t0 := time.Now()
+
+	render := func(ctx context.Context, from, until int64) ([]dataTypes.Metric, error) {
+		apiMetrics.RenderRequests.Add(1)
+		atomic.AddInt64(&toLog.ZipperRequests, 1)
 
-	request := dataTypes.NewRenderRequest([]string{path}, from, until)
-	metrics, err := app.backend.Render(ctx, request)
+		request := dataTypes.NewRenderRequest([]string{path}, from, until)
+		traced = &request
+		done := backendRequest(ctx)
+		metrics, err := app.backend.Render(ctx, request)
+		done()
 
-	// time in queue is converted to ms
-	app.prometheusMetrics.TimeInQueueExp.Observe(float64(request.Trace.Report()[2]) / 1000 / 1000)
-	app.prometheusMetrics.TimeInQueueLin.Observe(float64(request.Trace.Report()[2]) / 1000 / 1000)
+		// time in queue is converted to ms
+		report := request.Trace.Report()
+		app.prometheusMetrics.TimeInQueueExp.Observe(float64(report.Queue) / float64(time.Millisecond))
+		app.prometheusMetrics.TimeInQueueLin.Observe(float64(report.Queue) / float64(time.Millisecond))
+
+		return metrics, err
+	}
+
+	fetch := func() ([]dataTypes.Metric, error) {
+		if !app.config.CoalesceRenders.Enabled {
+			return render(ctx, from, until)
+		}
+
+		metrics, shared, err := app.coalesceRender(ctx, path, from, until, render)
+		if shared {
+			apiMetrics.RenderRequestsCoalesced.Add(1)
+		}
+
+		return metrics, err
+	}
+
+	var metrics []dataTypes.Metric
+	var err error
+	if fetches := renderFetchesFrom(ctx); fetches != nil {
+		var shared bool
+		metrics, shared, err = fetches.do(ctx, parser.MetricRequest{Metric: path, From: from, Until: until}, fetch)
+		if shared {
+			apiMetrics.RenderRequestsShared.Add(1)
+		}
+	} else {
+		metrics, err = fetch()
+	}
+	if d := renderDebugFrom(ctx); d != nil {
+		d.fetch(path, from, until, time.Since(t0), traced, metrics, err)
+	}
 
 	metricData := make([]*types.MetricData, 0)
+	datapoints := 0
 	for i := range metrics {
-		metricData = append(metricData, &types.MetricData{
-			Metric: metrics[i],
-		})
+		metricData = append(metricData, types.FromMetric(metrics[i]))
+		datapoints += len(metrics[i].Values)
 	}
+	app.countNamespaceDatapoints(path, datapoints)
 
 	ch <- renderResponse{
 		data:  metricData,
@@ -546,12 +1028,58 @@ type renderForm struct {
 	format       string
 	template     string
 	useCache     bool
+	// from32 and until32 are the unix times of the request. The evaluator
+	// works in 32 bits, up to 2038, while the requests to the backends
+	// carry 64-bit times.
 	from32       int32
 	until32      int32
 	jsonp        string
+	noNullPoints bool
+	withErrors   bool
 	cacheKey     string
 	cacheTimeout int32
 	qtz          string
+	// debug answers with the execution report of the request rather than
+	// its data
+	debug bool
+	// notFoundStatus is the status of the response when no metrics match
+	notFoundStatus int
+}
+
+// validNotFoundStatus reports whether code is a status render requests that
+// match no metrics can be answered with.
+func validNotFoundStatus(code int) bool {
+	return code == http.StatusOK || code == http.StatusNotFound
+}
+
+// jsonpCallback matches the names of the functions JSONP responses can be
+// wrapped in, e.g. cb, jQuery123_456 or angular.callbacks._0, so that the
+// callback cannot inject code.
+var jsonpCallback = regexp.MustCompile(`^[A-Za-z_$][\w$]*(\.[A-Za-z_$][\w$]*|\[\d+\])*$`)
+
+// validJSONP reports whether jsonp is empty or a valid callback.
+func validJSONP(jsonp string) bool {
+	return jsonp == "" || jsonpCallback.MatchString(jsonp)
+}
+
+// renderCacheKey strips the parameters of form that do not change the
+// response, and returns the rest as the cache key of the response.
+func renderCacheKey(form url.Values) string {
+	// make sure the cache key doesn't say noCache, because it will never hit
+	form.Del("noCache")
+
+	// jsonp callback names are frequently autogenerated and hurt our cache
+	form.Del("jsonp")
+
+	// Strip some cache-busters.  If you don't want to cache, use noCache=1
+	form.Del("_salt")
+	form.Del("_ts")
+	form.Del("_t") // Used by jquery.graphite.js
+
+	// the data of debug requests is the same, only the response differs
+	form.Del("debug")
+
+	return form.Encode()
 }
 
 func (app *App) renderHandlerProcessForm(r *http.Request, accessLogDetails *carbonapipb.AccessLogDetails, logger *zap.Logger) (renderForm, error) {
@@ -561,17 +1089,34 @@ func (app *App) renderHandlerProcessForm(r *http.Request, accessLogDetails *carb
 	if err != nil {
 		return res, err
 	}
+	if err := app.resolveQueryRefs(r.Form); err != nil {
+		return res, err
+	}
 
 	res.targets = r.Form["target"]
+	for _, target := range res.targets {
+		if err := app.checkTarget(target); err != nil {
+			return res, err
+		}
+	}
 	res.from = r.FormValue("from")
 	res.until = r.FormValue("until")
 	res.format = r.FormValue("format")
 	res.template = r.FormValue("template")
 	res.useCache = !parser.TruthyBool(r.FormValue("noCache"))
+	res.debug = parser.TruthyBool(r.FormValue("debug"))
 
 	if res.format == jsonFormat {
-		// TODO(dgryski): check jsonp only has valid characters
 		res.jsonp = r.FormValue("jsonp")
+		if !validJSONP(res.jsonp) {
+			return res, fmt.Errorf("invalid parameter jsonp=%s", res.jsonp)
+		}
+		res.noNullPoints = parser.TruthyBool(r.FormValue("noNullPoints"))
+		if apiVersionFrom(r.Context()) >= apiV2 && r.FormValue("withErrors") == "" {
+			// the cache key tells the responses apart
+			r.Form.Set("withErrors", "1")
+		}
+		res.withErrors = parser.TruthyBool(r.FormValue("withErrors"))
 	}
 
 	if res.format == "" && (parser.TruthyBool(r.FormValue("rawData")) || parser.TruthyBool(r.FormValue("rawdata"))) {
@@ -581,6 +1126,9 @@ func (app *App) renderHandlerProcessForm(r *http.Request, accessLogDetails *carb
 	if res.format == "" {
 		res.format = pngFormat
 	}
+	if res.format, err = app.checkFormat(res.format, renderFormats); err != nil {
+		return res, err
+	}
 
 	res.cacheTimeout = app.config.Cache.DefaultTimeoutSec
 
@@ -596,18 +1144,16 @@ func (app *App) renderHandlerProcessForm(r *http.Request, accessLogDetails *carb
 		}
 	}
 
-	// make sure the cache key doesn't say noCache, because it will never hit
-	r.Form.Del("noCache")
-
-	// jsonp callback names are frequently autogenerated and hurt our cache
-	r.Form.Del("jsonp")
-
-	// Strip some cache-busters.  If you don't want to cache, use noCache=1
-	r.Form.Del("_salt")
-	r.Form.Del("_ts")
-	r.Form.Del("_t") // Used by jquery.graphite.js
+	res.notFoundStatus = app.config.RenderNotFoundStatus
+	if s := r.FormValue("notFoundStatus"); s != "" {
+		code, err := strconv.Atoi(s)
+		if err != nil || !validNotFoundStatus(code) {
+			return res, fmt.Errorf("invalid parameter notFoundStatus=%s, it must be 200 or 404", s)
+		}
+		res.notFoundStatus = code
+	}
 
-	res.cacheKey = r.Form.Encode()
+	res.cacheKey = renderCacheKey(r.Form)
 
 	// normalize from and until values
 	res.qtz = r.FormValue("tz")
@@ -624,6 +1170,7 @@ func (app *App) renderHandlerProcessForm(r *http.Request, accessLogDetails *carb
 	accessLogDetails.CacheTimeout = res.cacheTimeout
 	accessLogDetails.Format = res.format
 	accessLogDetails.Targets = res.targets
+	setInFlightTargets(r.Context(), res.targets)
 
 	span := trace.SpanFromContext(r.Context())
 	span.SetAttributes(
@@ -658,7 +1205,7 @@ func (app *App) renderWriteBody(results []*types.MetricData, form renderForm, r
 			results = types.ConsolidateJSON(maxDataPoints, results)
 		}
 
-		body = types.MarshalJSON(results)
+		body = types.MarshalJSON(results, form.noNullPoints)
 	case protobufFormat, protobuf3Format:
 		body, err = types.MarshalProtobuf(results)
 		if err != nil {
@@ -708,9 +1255,9 @@ func (app *App) sendGlobs(glob dataTypes.Matches) bool {
 	return app.config.SendGlobsAsIs && len(glob.Matches) < app.config.MaxBatchSize
 }
 
-func (app *App) resolveGlobsFromCache(metric string) (dataTypes.Matches, error) {
+func (app *App) resolveGlobsFromCache(ctx context.Context, metric string) (dataTypes.Matches, error) {
 	tc := time.Now()
-	blob, err := app.findCache.Get(metric)
+	blob, err := app.findCache.Get(ctx, metric)
 	td := time.Since(tc).Nanoseconds()
 	apiMetrics.FindCacheOverheadNS.Add(td)
 
@@ -730,51 +1277,77 @@ func (app *App) resolveGlobsFromCache(metric string) (dataTypes.Matches, error)
 
 func (app *App) resolveGlobs(ctx context.Context, metric string, useCache bool, accessLogDetails *carbonapipb.AccessLogDetails) (dataTypes.Matches, bool, error) {
 	if useCache {
-		matches, err := app.resolveGlobsFromCache(metric)
+		if matches, ok := app.resolveGlobsFromTree(metric); ok {
+			return matches, true, nil
+		}
+		matches, err := app.resolveGlobsFromCache(ctx, metric)
 		if err == nil {
 			return matches, true, nil
 		}
 	}
 
 	apiMetrics.FindCacheMisses.Add(1)
-	apiMetrics.FindRequests.Add(1)
-	accessLogDetails.ZipperRequests++
 
-	request := dataTypes.NewFindRequest(metric)
-	request.IncCall()
-	matches, err := app.backend.Find(ctx, request)
-	if err != nil {
-		return matches, false, err
-	}
+	// Renders and finds of the same pattern at the same time share a single
+	// lookup, whose result they then share through the find cache.
+	v, shared, err := doShared(ctx, &app.finds, metric, app.config.Timeouts.Global, func(ctx context.Context) (interface{}, error) {
+		apiMetrics.FindRequests.Add(1)
 
-	blob, err := carbonapi_v2.FindEncoder(matches)
-	if err == nil {
-		tc := time.Now()
-		app.findCache.Set(metric, blob, app.config.Cache.DefaultTimeoutSec)
-		td := time.Since(tc).Nanoseconds()
-		apiMetrics.FindCacheOverheadNS.Add(td)
+		request := dataTypes.NewFindRequest(metric)
+		request.IncCall()
+		done := backendRequest(ctx)
+		matches, err := app.backend.Find(ctx, request)
+		done()
+		if err != nil {
+			return matches, err
+		}
+		if app.findTree != nil && app.findTree.Set(metric, matches) {
+			app.prometheusMetrics.FindTreeEntries.Set(float64(app.findTree.Entries()))
+		}
+
+		blob, err := carbonapi_v2.FindEncoder(matches)
+		if err == nil {
+			tc := time.Now()
+			app.findCache.Set(ctx, metric, blob, app.config.Cache.DefaultTimeoutSec)
+			td := time.Since(tc).Nanoseconds()
+			apiMetrics.FindCacheOverheadNS.Add(td)
+		}
+
+		return matches, nil
+	})
+	if shared {
+		apiMetrics.FindRequestsShared.Add(1)
+	} else {
+		accessLogDetails.ZipperRequests++
 	}
+	matches, _ := v.(dataTypes.Matches)
 
-	return matches, false, nil
+	return matches, false, err
 }
 
+// getRenderRequests returns the render requests to send for m, and the
+// number of metrics they are known to fetch.
 func (app *App) getRenderRequests(ctx context.Context, m parser.MetricRequest, useCache bool,
-	toLog *carbonapipb.AccessLogDetails) ([]string, error) {
+	toLog *carbonapipb.AccessLogDetails) ([]string, int, error) {
 	if app.config.AlwaysSendGlobsAsIs {
-		return []string{m.Metric}, nil
+		return []string{m.Metric}, 1, nil
 	}
-	if !strings.ContainsAny(m.Metric, "*{") {
-		return []string{m.Metric}, nil
+	if !strings.ContainsAny(m.Metric, "*{") || parser.IsSeriesByTag(m.Metric) {
+		// tag queries are resolved by the backends
+		return []string{m.Metric}, 1, nil
 	}
 
-	glob, _, err := app.resolveGlobs(ctx, m.Metric, useCache, toLog)
+	glob, fromCache, err := app.resolveGlobs(ctx, m.Metric, useCache, toLog)
+	if d := renderDebugFrom(ctx); d != nil {
+		d.find(m.Metric, fromCache, glob, err)
+	}
 	toLog.TotalMetricCount += int64(len(glob.Matches))
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
 	if app.sendGlobs(glob) {
-		return []string{m.Metric}, nil
+		return []string{m.Metric}, len(glob.Matches), nil
 	}
 
 	toLog.SendGlobs = false
@@ -785,7 +1358,7 @@ func (app *App) getRenderRequests(ctx context.Context, m parser.MetricRequest, u
 		}
 	}
 
-	return renderRequests, nil
+	return renderRequests, len(renderRequests), nil
 }
 
 func (app *App) findHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
@@ -805,7 +1378,12 @@ func (app *App) findHandler(w http.ResponseWriter, r *http.Request, logger *zap.
 	useCache := !parser.TruthyBool(r.FormValue("noCache"))
 
 	toLog := carbonapipb.NewAccessLogDetails(r, "find", &app.config)
-	toLog.Targets = []string{query}
+	targetErr := app.checkTarget(query)
+	if targetErr == nil {
+		// rejected queries are kept out of the access log
+		toLog.Targets = []string{query}
+		setInFlightTargets(r.Context(), toLog.Targets)
+	}
 	span.SetAttributes(
 		kv.String("grahite.target", query),
 		kv.String("graphite.username", toLog.Username),
@@ -823,12 +1401,24 @@ func (app *App) findHandler(w http.ResponseWriter, r *http.Request, logger *zap.
 		app.deferredAccessLogging(logger, r, &toLog, t0, logAsError)
 	}()
 
+	if format == "" {
+		format = treejsonFormat
+	}
+	format, formatErr := app.checkFormat(format, findFormats)
+	if formatErr != nil {
+		writeError(uuid, r, w, http.StatusBadRequest, formatErr.Error(), "", &toLog, span)
+		logAsError = true
+		return
+	}
+
 	if format == completerFormat {
 		query = getCompleterQuery(query)
 	}
 
-	if format == "" {
-		format = treejsonFormat
+	if !validJSONP(jsonp) {
+		writeError(uuid, r, w, http.StatusBadRequest, "invalid parameter jsonp="+jsonp, "", &toLog, span)
+		logAsError = true
+		return
 	}
 
 	if query == "" {
@@ -836,6 +1426,11 @@ func (app *App) findHandler(w http.ResponseWriter, r *http.Request, logger *zap.
 		logAsError = true
 		return
 	}
+	if targetErr != nil {
+		writeError(uuid, r, w, http.StatusBadRequest, targetErr.Error(), "", &toLog, span)
+		logAsError = true
+		return
+	}
 	span.SetAttribute("graphite.format", format)
 	metrics, fromCache, err := app.resolveGlobs(ctx, query, useCache, &toLog)
 	toLog.FromCache = fromCache
@@ -848,8 +1443,15 @@ func (app *App) findHandler(w http.ResponseWriter, r *http.Request, logger *zap.
 			zap.Error(err),
 		)
 		var notFound dataTypes.ErrNotFound
+		var overloaded dataTypes.ErrBackendOverloaded
 
 		switch {
+		case errors.As(err, &overloaded):
+			w.Header().Set("Retry-After", strconv.Itoa(overloaded.RetryAfterSeconds()))
+			writeError(uuid, r, w, http.StatusServiceUnavailable, err.Error(), "", &toLog, span)
+			apiMetrics.Errors.Add(1)
+			logAsError = true
+			return
 		case errors.As(err, &notFound):
 			// graphite-web 0.9.12 needs to get a 200 OK response with an empty
 			// body to be happy with its life, so we can't 404 a /metrics/find
@@ -886,12 +1488,32 @@ func (app *App) findHandler(w http.ResponseWriter, r *http.Request, logger *zap.
 		contentType = contentTypeJSON
 		blob, err = ourJson.FindEncoder(metrics)
 	case "", pickleFormat:
-		contentType = contentTypePickle
-		if app.config.GraphiteWeb09Compatibility {
-			blob, err = pickle.FindEncoderV0_9(metrics)
-		} else {
-			blob, err = pickle.FindEncoderV1_0(metrics)
+		// large finds are streamed rather than encoded in one buffer
+		w.Header().Set("Content-Type", contentTypePickle)
+		sw := &startedWriter{w: w}
+		err = app.findPickleEncoder().Encode(sw, metrics)
+		var tooLarge pickle.ErrTooLarge
+		switch {
+		case err == nil:
+			toLog.HttpCode = http.StatusOK
+		case errors.As(err, &tooLarge):
+			writeError(uuid, r, w, http.StatusUnprocessableEntity, err.Error(), "", &toLog, span)
+			apiMetrics.Errors.Add(1)
+			logAsError = true
+		case r.Context().Err() != nil:
+			toLog.HttpCode = 499
+		case !sw.started:
+			writeError(uuid, r, w, http.StatusInternalServerError, err.Error(), "", &toLog, span)
+			logAsError = true
+		default:
+			// the status is sent already, and the client is not to take
+			// the pickle cut short for a whole one
+			toLog.HttpCode = http.StatusInternalServerError
+			toLog.Reason = err.Error()
+			logAsError = true
+			panic(http.ErrAbortHandler)
 		}
+		return
 	case rawFormat:
 		blob = findList(metrics)
 		contentType = rawFormat
@@ -937,6 +1559,27 @@ func (app *App) findHandler(w http.ResponseWriter, r *http.Request, logger *zap.
 	toLog.HttpCode = http.StatusOK
 }
 
+// startedWriter tells if a response streamed to w started, i.e. if it is too
+// late to answer with an error instead.
+type startedWriter struct {
+	w       io.Writer
+	started bool
+}
+
+func (sw *startedWriter) Write(p []byte) (int, error) {
+	sw.started = true
+	return sw.w.Write(p)
+}
+
+// findPickleEncoder encodes find responses in pickle as configured.
+func (app *App) findPickleEncoder() pickle.FindEncoder {
+	return pickle.FindEncoder{
+		Protocol:      app.config.Pickle.Protocol,
+		MaxSize:       app.config.Pickle.MaxFindSize,
+		GraphiteWeb09: app.config.GraphiteWeb09Compatibility,
+	}
+}
+
 func getCompleterQuery(query string) string {
 	var replacer = strings.NewReplacer("/", ".")
 	query = replacer.Replace(query)
@@ -1033,6 +1676,15 @@ func (app *App) infoHandler(w http.ResponseWriter, r *http.Request, logger *zap.
 		app.deferredAccessLogging(logger, r, &toLog, t0, logAsError)
 	}()
 
+	format, err := app.checkFormat(format, infoFormats)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		toLog.HttpCode = http.StatusBadRequest
+		toLog.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
 	query := r.FormValue("target")
 	if query == "" {
 		http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
@@ -1041,10 +1693,19 @@ func (app *App) infoHandler(w http.ResponseWriter, r *http.Request, logger *zap.
 		logAsError = true
 		return
 	}
+	if err := app.checkTarget(query); err != nil {
+		http.Error(w, http.StatusText(http.StatusBadRequest)+": "+err.Error(), http.StatusBadRequest)
+		toLog.HttpCode = http.StatusBadRequest
+		toLog.Reason = err.Error()
+		logAsError = true
+		return
+	}
 
 	request := dataTypes.NewInfoRequest(query)
 	request.IncCall()
+	done := backendRequest(ctx)
 	infos, err := app.backend.Info(ctx, request)
+	done()
 	if err != nil {
 		var notFound dataTypes.ErrNotFound
 		if errors.As(err, &notFound) {
@@ -1054,6 +1715,15 @@ func (app *App) infoHandler(w http.ResponseWriter, r *http.Request, logger *zap.
 			logAsError = true
 			return
 		}
+		var overloaded dataTypes.ErrBackendOverloaded
+		if errors.As(err, &overloaded) {
+			w.Header().Set("Retry-After", strconv.Itoa(overloaded.RetryAfterSeconds()))
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			toLog.HttpCode = http.StatusServiceUnavailable
+			toLog.Reason = err.Error()
+			logAsError = true
+			return
+		}
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		toLog.HttpCode = http.StatusInternalServerError
 		toLog.Reason = err.Error()
@@ -1093,21 +1763,29 @@ func (app *App) infoHandler(w http.ResponseWriter, r *http.Request, logger *zap.
 	toLog.HttpCode = http.StatusOK
 }
 
+// lbcheckHandler answers 503 while memory is short, for load balancers to
+// send the requests to the other instances.
 func (app *App) lbcheckHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
 	t0 := time.Now()
 
+	code, body := http.StatusOK, "Ok\n"
+	if app.admission.memory.degraded() {
+		code, body = http.StatusServiceUnavailable, "Degraded\n"
+	}
+
 	apiMetrics.Requests.Add(1)
 	app.prometheusMetrics.Requests.Inc()
 	defer func() {
 		apiMetrics.Responses.Add(1)
-		app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(http.StatusOK), "lbcheck", "false").Inc()
+		app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(code), "lbcheck", "false").Inc()
 	}()
 
-	_, writeErr := w.Write([]byte("Ok\n"))
+	w.WriteHeader(code)
+	_, writeErr := w.Write([]byte(body))
 
 	toLog := carbonapipb.NewAccessLogDetails(r, "lbcheck", &app.config)
 	toLog.Runtime = time.Since(t0).Seconds()
-	toLog.HttpCode = http.StatusOK
+	toLog.HttpCode = int32(code)
 	if writeErr != nil {
 		toLog.HttpCode = 499
 	}
@@ -1159,8 +1837,10 @@ func (app *App) versionHandler(w http.ResponseWriter, r *http.Request, logger *z
 	logger.Info("request served", fields...)
 }
 
+// functionsHandler serves the descriptions of the supported functions like
+// graphite-web, all of them on /functions and a single one on
+// /functions/<name>
 func (app *App) functionsHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
-	// TODO: Implement helper for specific functions
 	t0 := time.Now()
 
 	apiMetrics.Requests.Add(1)
@@ -1211,6 +1891,18 @@ func (app *App) functionsHandler(w http.ResponseWriter, r *http.Request, logger
 		function = path[2]
 	}
 
+	if function != "" {
+		metadata.FunctionMD.RLock()
+		_, ok := metadata.FunctionMD.Descriptions[function]
+		metadata.FunctionMD.RUnlock()
+		if !ok {
+			http.Error(w, "function not found: "+function, http.StatusNotFound)
+			toLog.HttpCode = http.StatusNotFound
+			toLog.Reason = "function not found"
+			return
+		}
+	}
+
 	var b []byte
 	if !nativeOnly {
 		metadata.FunctionMD.RLock()
@@ -1266,6 +1958,7 @@ func (app *App) functionsHandler(w http.ResponseWriter, r *http.Request, logger
 		return
 	}
 
+	w.Header().Set("Content-Type", contentTypeJSON)
 	_, err = w.Write(b)
 	toLog.Runtime = time.Since(t0).Seconds()
 	toLog.HttpCode = http.StatusOK
@@ -1414,6 +2107,41 @@ func (app *App) debugVersionHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "GIT_TAG: %s\n", BuildVersion)
 }
 
+// debugFunctionsHandler reports how often each graphite function was called
+// and what it cost, in JSON.
+func (app *App) debugFunctionsHandler(w http.ResponseWriter, r *http.Request) {
+	apiMetrics.Requests.Add(1)
+	app.prometheusMetrics.Requests.Inc()
+
+	b, err := json.Marshal(expr.GetFunctionStats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apiMetrics.Responses.Add(1)
+		app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(http.StatusInternalServerError), "debugfunctions", "false").Inc()
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.Write(b)
+	apiMetrics.Responses.Add(1)
+	app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(http.StatusOK), "debugfunctions", "false").Inc()
+}
+
+// checkTarget rejects targets that are too long or contain control
+// characters, before they are parsed, logged or sent to the backends.
+func (app *App) checkTarget(target string) error {
+	if max := app.config.MaxTargetLength; max > 0 && len(target) > max {
+		return fmt.Errorf("target is %d bytes long, at most %d are allowed", len(target), max)
+	}
+	for _, r := range target {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("target contains control character %U", r)
+		}
+	}
+
+	return nil
+}
+
 func buildParseErrorString(target, e string, err error) string {
 	msg := fmt.Sprintf("%s\n\n%-20s: %s\n", http.StatusText(http.StatusBadRequest), "Target", target)
 	if err != nil {
@@ -1,9 +1,19 @@
 package carbonapi
 
 import (
+	"context"
 	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
+	"github.com/bookingcom/carbonapi/cache"
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
 	typ "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -42,11 +52,12 @@ func TestFindCompleter(t *testing.T) {
 
 func TestOptimistErrsFanIn(t *testing.T) {
 	var tests = []struct {
-		name       string
-		in         []error
-		n          int
-		isErr      bool
-		isNotFound bool
+		name         string
+		in           []error
+		n            int
+		isErr        bool
+		isNotFound   bool
+		isOverloaded bool
 	}{
 		{
 			name: "1 err, 1 result",
@@ -99,6 +110,17 @@ func TestOptimistErrsFanIn(t *testing.T) {
 			isErr:      false,
 			isNotFound: false,
 		},
+		{
+			name: "2 overloaded errs, 2 results",
+			in: []error{
+				typ.ErrBackendOverloaded{Message: "slow down", RetryAfter: time.Second},
+				typ.ErrBackendOverloaded{Message: "slow down", RetryAfter: 3 * time.Second},
+			},
+			n:            2,
+			isErr:        true,
+			isNotFound:   false,
+			isOverloaded: true,
+		},
 		{
 			name: "1 arbitrary err, 2 results",
 			in: []error{
@@ -123,7 +145,162 @@ func TestOptimistErrsFanIn(t *testing.T) {
 				if _, ok := err.(typ.ErrNotFound); ok != tst.isNotFound {
 					t.Fatalf("got err *%v* when not found err expected", err)
 				}
+				overloaded, ok := err.(typ.ErrBackendOverloaded)
+				if ok != tst.isOverloaded {
+					t.Fatalf("got err *%v* when overloaded err expected", err)
+				}
+				if ok && overloaded.RetryAfter != 3*time.Second {
+					t.Errorf("expected to be retried after the longest wait, got %v", overloaded.RetryAfter)
+				}
 			}
 		})
 	}
 }
+
+// joinContext signals joined the first time Done is asked for, which
+// doShared only does once its caller waits for a call, in flight or not.
+type joinContext struct {
+	context.Context
+	joined chan struct{}
+	once   sync.Once
+}
+
+func newJoinContext(ctx context.Context) *joinContext {
+	return &joinContext{Context: ctx, joined: make(chan struct{})}
+}
+
+func (c *joinContext) Done() <-chan struct{} {
+	c.once.Do(func() { close(c.joined) })
+	return c.Context.Done()
+}
+
+func sharedFindApp(called chan<- struct{}, release <-chan struct{}) (*App, *int32) {
+	var calls int32
+	app := &App{
+		config:     cfg.DefaultAPIConfig(),
+		queryCache: cache.NullCache{},
+		findCache:  cache.NewExpireCache(1000),
+	}
+	app.config.Cache.DefaultTimeoutSec = 60
+	app.backend = mock.New(mock.Config{
+		Find: func(ctx context.Context, request typ.FindRequest) (typ.Matches, error) {
+			atomic.AddInt32(&calls, 1)
+			called <- struct{}{}
+			<-release
+			return typ.Matches{
+				Name:    request.Query,
+				Matches: []typ.Match{{Path: "foo.bar", IsLeaf: true}},
+			}, ctx.Err()
+		},
+	})
+
+	return app, &calls
+}
+
+func TestResolveGlobsShared(t *testing.T) {
+	called := make(chan struct{}, 1)
+	release := make(chan struct{})
+	app, calls := sharedFindApp(called, release)
+
+	// A render and a find resolving the same pattern at the same time
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		toLog := carbonapipb.AccessLogDetails{}
+		renderRequests, _, err := app.getRenderRequests(context.Background(),
+			parser.MetricRequest{Metric: "foo.*"}, true, &toLog)
+		if err != nil || len(renderRequests) != 1 || renderRequests[0] != "foo.bar" {
+			t.Errorf("Unexpected render requests %v, %v", renderRequests, err)
+		}
+	}()
+	<-called
+	ctx := newJoinContext(context.Background())
+	go func() {
+		defer wg.Done()
+		toLog := carbonapipb.AccessLogDetails{}
+		matches, _, err := app.resolveGlobs(ctx, "foo.*", true, &toLog)
+		if err != nil || len(matches.Matches) != 1 {
+			t.Errorf("Unexpected matches %v, %v", matches, err)
+		}
+	}()
+	<-ctx.joined
+	close(release)
+	wg.Wait()
+
+	// and a later find of it
+	toLog := carbonapipb.AccessLogDetails{}
+	_, fromCache, err := app.resolveGlobs(context.Background(), "foo.*", true, &toLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fromCache {
+		t.Error("Expected the matches to come from the find cache")
+	}
+
+	if *calls != 1 {
+		t.Errorf("Expected a single lookup in the backend, got %d", *calls)
+	}
+}
+
+func TestResolveGlobsSharedCancel(t *testing.T) {
+	called := make(chan struct{}, 1)
+	release := make(chan struct{})
+	app, calls := sharedFindApp(called, release)
+
+	// The request that started the lookup gives up before it is done
+	firstCtx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		toLog := carbonapipb.AccessLogDetails{}
+		_, _, err := app.resolveGlobs(firstCtx, "foo.*", false, &toLog)
+		first <- err
+	}()
+	<-called
+	secondCtx := newJoinContext(context.Background())
+	second := make(chan error)
+	go func() {
+		toLog := carbonapipb.AccessLogDetails{}
+		matches, _, err := app.resolveGlobs(secondCtx, "foo.*", false, &toLog)
+		if err == nil && len(matches.Matches) != 1 {
+			t.Errorf("Unexpected matches %v", matches)
+		}
+		second <- err
+	}()
+	<-secondCtx.joined
+
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("Expected the first request to be canceled, got %v", err)
+	}
+	close(release)
+	if err := <-second; err != nil {
+		t.Errorf("Expected the request that joined to succeed, got %v", err)
+	}
+
+	if *calls != 1 {
+		t.Errorf("Expected a single lookup in the backend, got %d", *calls)
+	}
+}
+
+func TestCheckFormat(t *testing.T) {
+	app := &App{}
+
+	if got, err := app.checkFormat(csvFormat, renderFormats); err != nil || got != csvFormat {
+		t.Errorf("expected csv, got %q (%v)", got, err)
+	}
+	_, err := app.checkFormat("xml", renderFormats)
+	if err == nil || !strings.Contains(err.Error(), "json, png, svg") {
+		t.Errorf("expected an error listing the supported formats, got %v", err)
+	}
+
+	app.config.UnknownFormat = jsonFormat
+	if got, err := app.checkFormat("xml", renderFormats); err != nil || got != jsonFormat {
+		t.Errorf("expected xml to be answered in json, got %q (%v)", got, err)
+	}
+
+	app.config.UnknownFormat = pngFormat
+	if _, err := app.checkFormat("xml", infoFormats); err == nil {
+		t.Error("expected an error when info does not support the default format")
+	}
+}
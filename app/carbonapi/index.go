@@ -0,0 +1,174 @@
+package carbonapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.opentelemetry.io/otel/api/trace"
+	"go.uber.org/zap"
+)
+
+// indexCacheKey is the key of the index in the find cache, which can not
+// be a glob of metrics.
+const indexCacheKey = "/metrics/index.json"
+
+// errIndexTooLarge is returned for indexes of more metrics than allowed.
+var errIndexTooLarge = errors.New("too many metrics")
+
+// metricIndex lists all the metrics, sorted, walking their tree level by
+// level with finds of the children of each node. It returns
+// errIndexTooLarge beyond the configured number of metrics.
+func (app *App) metricIndex(ctx context.Context, useCache bool, toLog *carbonapipb.AccessLogDetails) ([]string, error) {
+	maxConcurrent := app.config.Index.MaxConcurrent
+	if maxConcurrent <= 0 {
+		maxConcurrent = 1
+	}
+	slots := make(chan struct{}, maxConcurrent)
+
+	var (
+		mu       sync.Mutex
+		metrics  = make(map[string]bool)
+		branches = make(map[string]bool)
+		firstErr error
+	)
+
+	level := []string{"*"}
+	for len(level) > 0 {
+		var next []string
+		var wg sync.WaitGroup
+		for _, glob := range level {
+			slots <- struct{}{}
+			wg.Add(1)
+			go func(glob string) {
+				defer func() {
+					<-slots
+					wg.Done()
+				}()
+
+				// resolveGlobs counts its requests in the details it is given
+				var details carbonapipb.AccessLogDetails
+				matches, _, err := app.resolveGlobs(ctx, glob, useCache, &details)
+
+				mu.Lock()
+				defer mu.Unlock()
+				toLog.ZipperRequests += details.ZipperRequests
+				var notFound dataTypes.ErrNotFound
+				if err != nil && !errors.As(err, &notFound) {
+					if firstErr == nil {
+						firstErr = err
+					}
+					return
+				}
+				for _, m := range matches.Matches {
+					if m.IsLeaf {
+						metrics[m.Path] = true
+					} else if !branches[m.Path] {
+						branches[m.Path] = true
+						next = append(next, m.Path+".*")
+					}
+				}
+				if max := app.config.Index.MaxMetrics; max > 0 && len(metrics) > max && firstErr == nil {
+					firstErr = fmt.Errorf("%w, at most %d are listed", errIndexTooLarge, max)
+				}
+			}(glob)
+		}
+		wg.Wait()
+
+		if firstErr != nil {
+			return nil, firstErr
+		}
+		level = next
+	}
+
+	index := make([]string, 0, len(metrics))
+	for m := range metrics {
+		index = append(index, m)
+	}
+	sort.Strings(index)
+
+	return index, nil
+}
+
+// indexHandler answers /metrics/index.json, the JSON list of all the
+// metrics of the backends, as graphite-web does for dashboards. The index
+// is kept in the find cache.
+func (app *App) indexHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.Timeouts.Global)
+	defer cancel()
+	span := trace.SpanFromContext(ctx)
+	uuid := util.GetUUID(ctx)
+
+	toLog := carbonapipb.NewAccessLogDetails(r, "index", &app.config)
+	toLog.Format = jsonFormat
+	span.SetAttribute("graphite.username", toLog.Username)
+
+	logAsError := false
+	defer func() {
+		app.deferredAccessLogging(logger, r, &toLog, t0, logAsError)
+	}()
+
+	apiMetrics.Requests.Add(1)
+	app.prometheusMetrics.Requests.Inc()
+
+	jsonp := r.FormValue("jsonp")
+	if !validJSONP(jsonp) {
+		writeError(uuid, r, w, http.StatusBadRequest, "invalid parameter jsonp="+jsonp, jsonFormat, &toLog, span)
+		logAsError = true
+		return
+	}
+	useCache := !parser.TruthyBool(r.FormValue("noCache"))
+	toLog.UseCache = useCache
+
+	var body []byte
+	if useCache {
+		if blob, err := app.findCache.Get(ctx, indexCacheKey); err == nil {
+			body = blob
+			toLog.FromCache = true
+		}
+	}
+
+	if body == nil {
+		index, err := app.metricIndex(ctx, useCache, &toLog)
+		if err != nil {
+			code := http.StatusInternalServerError
+			switch {
+			case errors.Is(err, errIndexTooLarge):
+				code = http.StatusUnprocessableEntity
+			case ctx.Err() != nil:
+				code = http.StatusServiceUnavailable
+				app.prometheusMetrics.RequestCancel.WithLabelValues("index", ctx.Err().Error()).Inc()
+			}
+			writeError(uuid, r, w, code, "failed to list the metrics: "+err.Error(), jsonFormat, &toLog, span)
+			logAsError = true
+			return
+		}
+		toLog.TotalMetricCount = int64(len(index))
+
+		body, err = json.Marshal(index)
+		if err != nil {
+			writeError(uuid, r, w, http.StatusInternalServerError, err.Error(), jsonFormat, &toLog, span)
+			logAsError = true
+			return
+		}
+		app.findCache.Set(ctx, indexCacheKey, body, app.config.Index.CacheTimeoutSec)
+	}
+
+	if writeErr := writeResponse(ctx, w, body, jsonFormat, jsonp); writeErr != nil {
+		toLog.HttpCode = 499
+		return
+	}
+	toLog.HttpCode = http.StatusOK
+}
@@ -0,0 +1,71 @@
+package carbonapi
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	types "github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestIndexHandler(t *testing.T) {
+	tree := map[string][]types.Match{
+		"*": {
+			{Path: "foo", IsLeaf: false},
+			{Path: "top", IsLeaf: true},
+		},
+		"foo.*": {
+			{Path: "foo.baz", IsLeaf: false},
+			{Path: "foo.bar", IsLeaf: true},
+		},
+		"foo.baz.*": {
+			{Path: "foo.baz.qux", IsLeaf: true},
+		},
+	}
+
+	backend, config := testApp.backend, testApp.config.Index
+	defer func() {
+		testApp.backend, testApp.config.Index = backend, config
+	}()
+	testApp.backend = mock.New(mock.Config{
+		Find: func(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+			matches, ok := tree[request.Query]
+			if !ok {
+				return types.Matches{}, types.ErrNotFound("no metrics for " + request.Query)
+			}
+			return types.Matches{Name: request.Query, Matches: matches}, nil
+		},
+	})
+
+	tests := []struct {
+		name       string
+		url        string
+		maxMetrics int
+		code       int
+		resp       string
+	}{
+		{"index", "/metrics/index.json?noCache=1", 0, http.StatusOK, `["foo.bar","foo.baz.qux","top"]`},
+		{"jsonp", "/metrics/index.json?noCache=1&jsonp=cb", 0, http.StatusOK, `cb(["foo.bar","foo.baz.qux","top"])`},
+		{"within the limit", "/metrics/index.json?noCache=1", 3, http.StatusOK, `["foo.bar","foo.baz.qux","top"]`},
+		{"beyond the limit", "/metrics/index.json?noCache=1", 2, http.StatusUnprocessableEntity, ""},
+		{"invalid jsonp", "/metrics/index.json?noCache=1&jsonp=alert(1)", 0, http.StatusBadRequest, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			testApp.config.Index.MaxMetrics = tt.maxMetrics
+
+			rr := httptest.NewRecorder()
+			testRouter.ServeHTTP(rr, httptest.NewRequest("GET", tt.url, nil))
+
+			if rr.Code != tt.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			if tt.resp != "" && rr.Body.String() != tt.resp {
+				t.Errorf("expected %s, got %s", tt.resp, rr.Body.String())
+			}
+		})
+	}
+}
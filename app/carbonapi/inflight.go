@@ -0,0 +1,278 @@
+package carbonapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.uber.org/zap"
+)
+
+// inFlightRequest is a request being served, or a render job running
+type inFlightRequest struct {
+	id      string
+	uuid    string
+	cancel  context.CancelFunc
+	path    string
+	started time.Time
+
+	// pending counts the backend requests waited for
+	pending int64
+
+	mu      sync.Mutex
+	targets []string
+}
+
+// inFlightKey is the context key of the inFlightRequest of a request.
+type inFlightKey struct{}
+
+func withInFlightRequest(ctx context.Context, req *inFlightRequest) context.Context {
+	return context.WithValue(ctx, inFlightKey{}, req)
+}
+
+func inFlightRequestFromContext(ctx context.Context) *inFlightRequest {
+	req, _ := ctx.Value(inFlightKey{}).(*inFlightRequest)
+	return req
+}
+
+// setInFlightTargets records the targets of the request of ctx, once they are
+// parsed, for them to be listed.
+func setInFlightTargets(ctx context.Context, targets []string) {
+	req := inFlightRequestFromContext(ctx)
+	if req == nil {
+		return
+	}
+
+	req.mu.Lock()
+	req.targets = targets
+	req.mu.Unlock()
+}
+
+// backendRequest counts a backend request of the request of ctx as pending
+// until the function it returns is called.
+func backendRequest(ctx context.Context) func() {
+	req := inFlightRequestFromContext(ctx)
+	if req == nil {
+		return func() {}
+	}
+
+	atomic.AddInt64(&req.pending, 1)
+	return func() { atomic.AddInt64(&req.pending, -1) }
+}
+
+// inFlightRequests keeps the requests being served by an id of their own,
+// for them to be cancelled from the internal listener. The UUIDs of requests
+// are set by clients, so several requests may share one.
+type inFlightRequests struct {
+	lastID uint64
+
+	mu       sync.Mutex
+	requests map[string]*inFlightRequest
+}
+
+func newInFlightRequests() *inFlightRequests {
+	return &inFlightRequests{
+		requests: make(map[string]*inFlightRequest),
+	}
+}
+
+// add registers the request uuid for path, which is cancelled by cancel.
+func (f *inFlightRequests) add(uuid, path string, cancel context.CancelFunc) *inFlightRequest {
+	req := &inFlightRequest{
+		id:      strconv.FormatUint(atomic.AddUint64(&f.lastID, 1), 10),
+		uuid:    uuid,
+		cancel:  cancel,
+		path:    path,
+		started: time.Now(),
+	}
+
+	f.mu.Lock()
+	f.requests[req.id] = req
+	f.mu.Unlock()
+
+	return req
+}
+
+// remove forgets req.
+func (f *inFlightRequests) remove(req *inFlightRequest) {
+	f.mu.Lock()
+	delete(f.requests, req.id)
+	f.mu.Unlock()
+}
+
+// cancel cancels the context of the request id. It returns false if there
+// is no such request in flight.
+func (f *inFlightRequests) cancel(id string) bool {
+	f.mu.Lock()
+	req, ok := f.requests[id]
+	f.mu.Unlock()
+
+	if ok {
+		req.cancel()
+	}
+
+	return ok
+}
+
+// cancelUUID cancels the contexts of all the requests in flight with uuid, as
+// logged as carbonapi_uuid. It returns the number of requests cancelled.
+func (f *inFlightRequests) cancelUUID(uuid string) int {
+	var reqs []*inFlightRequest
+	f.mu.Lock()
+	for _, req := range f.requests {
+		if req.uuid == uuid {
+			reqs = append(reqs, req)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, req := range reqs {
+		req.cancel()
+	}
+
+	return len(reqs)
+}
+
+// inFlightRequestStatus is a request in flight, as listed.
+type inFlightRequestStatus struct {
+	ID              string   `json:"id"`
+	UUID            string   `json:"uuid"`
+	Path            string   `json:"path"`
+	Targets         []string `json:"targets"`
+	Elapsed         float64  `json:"elapsed"`
+	PendingBackends int64    `json:"pendingBackendRequests"`
+}
+
+// maxListedTargetLength truncates the targets listed, which are meant to
+// tell requests apart rather than to be run again.
+const maxListedTargetLength = 256
+
+// list returns the requests in flight at now, the longest running first.
+func (f *inFlightRequests) list(now time.Time) []inFlightRequestStatus {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	list := make([]inFlightRequestStatus, 0, len(f.requests))
+	for _, req := range f.requests {
+		req.mu.Lock()
+		targets := make([]string, len(req.targets))
+		for i, t := range req.targets {
+			if len(t) > maxListedTargetLength {
+				t = t[:maxListedTargetLength] + "..."
+			}
+			targets[i] = t
+		}
+		req.mu.Unlock()
+
+		list = append(list, inFlightRequestStatus{
+			ID:              req.id,
+			UUID:            req.uuid,
+			Path:            req.path,
+			Targets:         targets,
+			Elapsed:         now.Sub(req.started).Seconds(),
+			PendingBackends: atomic.LoadInt64(&req.pending),
+		})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Elapsed != list[j].Elapsed {
+			return list[i].Elapsed > list[j].Elapsed
+		}
+		return list[i].ID < list[j].ID
+	})
+
+	return list
+}
+
+// cancellableHandler makes the requests handled by h cancellable by the id
+// they are listed with.
+func (app *App) cancellableHandler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancel(r.Context())
+		defer cancel()
+
+		uuid := util.GetUUID(ctx)
+		req := app.inFlight.add(uuid, r.URL.Path, cancel)
+		defer app.inFlight.remove(req)
+
+		h.ServeHTTP(w, r.WithContext(withInFlightRequest(ctx, req)))
+	})
+}
+
+// cancelRequest cancels the request, or render job, with the id given by the
+// id parameter, as listed by inFlightRequestsHandler, or all the requests with
+// the UUID given by the uuid parameter, as logged in carbonapi_uuid.
+func (app *App) cancelRequest(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
+	t0 := time.Now()
+	apiMetrics.Requests.Add(1)
+	toLog := carbonapipb.NewAccessLogDetails(r, "cancelRequest", &app.config)
+
+	logAsError := false
+	defer func() {
+		app.deferredAccessLogging(logger, r, &toLog, t0, logAsError)
+	}()
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	id, uuid := r.FormValue("id"), r.FormValue("uuid")
+	var cancelled bool
+	if uuid != "" {
+		cancelled = app.inFlight.cancelUUID(uuid) > 0
+	} else {
+		cancelled = app.inFlight.cancel(id)
+	}
+	if !cancelled {
+		w.WriteHeader(http.StatusNotFound)
+		toLog.HttpCode = http.StatusNotFound
+		if _, err := w.Write([]byte(`{"success":"false"}`)); err != nil {
+			toLog.HttpCode = 499
+		}
+		return
+	}
+
+	logger.Warn("request cancelled",
+		zap.String("cancelled_id", id),
+		zap.String("cancelled_uuid", uuid),
+	)
+	_, err := w.Write([]byte(`{"success":"true"}`))
+	toLog.HttpCode = http.StatusOK
+	if err != nil {
+		toLog.HttpCode = 499
+	}
+}
+
+// inFlightRequestsHandler lists the requests, and render jobs, in flight,
+// with their targets, how long they have been running and the number of
+// backend requests they wait for.
+func (app *App) inFlightRequestsHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
+	t0 := time.Now()
+	apiMetrics.Requests.Add(1)
+	toLog := carbonapipb.NewAccessLogDetails(r, "inFlightRequests", &app.config)
+
+	logAsError := false
+	defer func() {
+		app.deferredAccessLogging(logger, r, &toLog, t0, logAsError)
+	}()
+
+	body, err := json.Marshal(app.inFlight.list(t0))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		toLog.HttpCode = http.StatusInternalServerError
+		toLog.Reason = err.Error()
+		logAsError = true
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	_, err = w.Write(body)
+	toLog.HttpCode = http.StatusOK
+	if err != nil {
+		toLog.HttpCode = 499
+	}
+}
@@ -0,0 +1,191 @@
+package carbonapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.uber.org/zap"
+)
+
+// inFlightIDs returns the ids of the requests in flight with uuid.
+func inFlightIDs(uuid string) []string {
+	var ids []string
+	for _, req := range testApp.inFlight.list(time.Now()) {
+		if req.UUID == uuid {
+			ids = append(ids, req.ID)
+		}
+	}
+
+	return ids
+}
+
+func TestCancelRequest(t *testing.T) {
+	started := make(chan struct{}, 2)
+	h := util.UUIDHandler(testApp.cancellableHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})))
+
+	// two requests that share a UUID, which clients choose
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			req := httptest.NewRequest("GET", "/render?target=foo.bar", nil)
+			req.Header.Set("X-CTX-CarbonAPI-UUID", "runaway")
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			done <- rr.Code
+		}()
+	}
+	<-started
+	<-started
+
+	ids := inFlightIDs("runaway")
+	if len(ids) != 2 || ids[0] == ids[1] {
+		t.Fatalf("expected both requests listed with ids of their own, got %q", ids)
+	}
+
+	internal := initHandlersInternal(testApp, zap.NewNop())
+	for _, id := range ids {
+		rr := httptest.NewRecorder()
+		internal.ServeHTTP(rr, httptest.NewRequest("GET", "/cancel-request/?id="+id, nil))
+		if rr.Code != http.StatusOK {
+			t.Errorf("HttpStatusCode should be 200 OK, got %d", rr.Code)
+		}
+
+		select {
+		case code := <-done:
+			if code != http.StatusServiceUnavailable {
+				t.Errorf("unexpected status %d of the cancelled request", code)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("request was not cancelled")
+		}
+		if id == ids[0] && len(inFlightIDs("runaway")) != 1 {
+			t.Errorf("expected the other request to be left running")
+		}
+	}
+
+	rr := httptest.NewRecorder()
+	internal.ServeHTTP(rr, httptest.NewRequest("GET", "/cancel-request/?id="+ids[0], nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("HttpStatusCode should be 404 Not Found once the request is done, got %d", rr.Code)
+	}
+}
+
+func TestCancelRequestByUUID(t *testing.T) {
+	logged := make(chan string, 2)
+	h := util.UUIDHandler(testApp.cancellableHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logged <- carbonapipb.NewAccessLogDetails(r, "render", &testApp.config).CarbonapiUuid
+		<-r.Context().Done()
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})))
+
+	// the UUID is generated, and only known from the logs
+	done := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("GET", "/render?target=foo.bar", nil))
+			done <- rr.Code
+		}()
+	}
+	uuid := <-logged
+	other := <-logged
+	if uuid == "" || uuid == other {
+		t.Fatalf("expected distinct logged UUIDs, got %q and %q", uuid, other)
+	}
+
+	internal := initHandlersInternal(testApp, zap.NewNop())
+	rr := httptest.NewRecorder()
+	internal.ServeHTTP(rr, httptest.NewRequest("GET", "/cancel-request/?uuid="+uuid, nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("HttpStatusCode should be 200 OK, got %d", rr.Code)
+	}
+
+	select {
+	case code := <-done:
+		if code != http.StatusServiceUnavailable {
+			t.Errorf("unexpected status %d of the cancelled request", code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not cancelled")
+	}
+	if len(inFlightIDs(uuid)) != 0 || len(inFlightIDs(other)) != 1 {
+		t.Errorf("expected only the request %s to be cancelled", uuid)
+	}
+
+	if n := testApp.inFlight.cancelUUID(other); n != 1 {
+		t.Errorf("expected 1 request cancelled, got %d", n)
+	}
+	<-done
+
+	rr = httptest.NewRecorder()
+	internal.ServeHTTP(rr, httptest.NewRequest("GET", "/cancel-request/?uuid="+uuid, nil))
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("HttpStatusCode should be 404 Not Found once the request is done, got %d", rr.Code)
+	}
+}
+
+func TestInFlightRequestsList(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	h := util.UUIDHandler(testApp.cancellableHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		setInFlightTargets(r.Context(), []string{"sumSeries(foo.*)", strings.Repeat("a", 300)})
+		done := backendRequest(r.Context())
+		close(started)
+		<-release
+		done()
+	})))
+
+	finished := make(chan struct{})
+	go func() {
+		req := httptest.NewRequest("GET", "/render?target=foo.bar", nil)
+		req.Header.Set("X-CTX-CarbonAPI-UUID", "busy")
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		close(finished)
+	}()
+	<-started
+
+	internal := initHandlersInternal(testApp, zap.NewNop())
+	rr := httptest.NewRecorder()
+	internal.ServeHTTP(rr, httptest.NewRequest("GET", "/in-flight-requests", nil))
+	close(release)
+	<-finished
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("HttpStatusCode should be 200 OK, got %d", rr.Code)
+	}
+	var list []inFlightRequestStatus
+	if err := json.Unmarshal(rr.Body.Bytes(), &list); err != nil {
+		t.Fatal(err)
+	}
+
+	var busy *inFlightRequestStatus
+	for i := range list {
+		if list[i].UUID == "busy" {
+			busy = &list[i]
+		}
+	}
+	if busy == nil {
+		t.Fatalf("request busy is not listed in %s", rr.Body.String())
+	}
+	if busy.ID == "" || busy.Path != "/render" || busy.PendingBackends != 1 || busy.Elapsed <= 0 {
+		t.Errorf("unexpected status %+v", *busy)
+	}
+	if len(busy.Targets) != 2 || busy.Targets[0] != "sumSeries(foo.*)" || len(busy.Targets[1]) != maxListedTargetLength+3 {
+		t.Errorf("unexpected targets %q", busy.Targets)
+	}
+
+	if list := testApp.inFlight.list(time.Now()); len(list) != 0 {
+		t.Errorf("expected no requests in flight once they are done, got %+v", list)
+	}
+}
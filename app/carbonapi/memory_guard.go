@@ -0,0 +1,136 @@
+package carbonapi
+
+import (
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+
+	"go.uber.org/zap"
+)
+
+// memoryGuard sheds the requests estimated to fetch the most datapoints
+// while the heap is over its watermark or GC pauses spike, to keep query
+// storms from getting carbonapi killed for running out of memory.
+type memoryGuard struct {
+	config       cfg.MemoryGuardConfig
+	readMemStats func(*runtime.MemStats)
+
+	// numGC is the number of GCs seen by the last check.
+	numGC uint32
+	// shedAbove is the estimated datapoints above which requests are
+	// shed, 0 while memory is not under pressure.
+	shedAbove int64
+}
+
+// newMemoryGuard returns nil if neither watermark is set.
+func newMemoryGuard(config cfg.MemoryGuardConfig) *memoryGuard {
+	if config.HeapWatermark == 0 && config.GCPauseWatermark <= 0 {
+		return nil
+	}
+
+	return &memoryGuard{
+		config:       config,
+		readMemStats: runtime.ReadMemStats,
+	}
+}
+
+// pressure returns why memory is under pressure, "" if it is not.
+func (g *memoryGuard) pressure() string {
+	var ms runtime.MemStats
+	g.readMemStats(&ms)
+
+	// the pauses of the GCs since the last check, of which the last 256
+	// are kept
+	first := g.numGC + 1
+	if ms.NumGC > uint32(len(ms.PauseNs)) && first <= ms.NumGC-uint32(len(ms.PauseNs)) {
+		first = ms.NumGC - uint32(len(ms.PauseNs)) + 1
+	}
+	var pause time.Duration
+	for n := first; n <= ms.NumGC; n++ {
+		if p := time.Duration(ms.PauseNs[(n+255)%256]); p > pause {
+			pause = p
+		}
+	}
+	g.numGC = ms.NumGC
+
+	if max := g.config.HeapWatermark; max > 0 && ms.HeapInuse > max {
+		return fmt.Sprintf("heap in use of %d bytes is over the watermark of %d", ms.HeapInuse, max)
+	}
+	if max := g.config.GCPauseWatermark; max > 0 && pause > max {
+		return fmt.Sprintf("GC pause of %s is over the watermark of %s", pause, max)
+	}
+
+	return ""
+}
+
+// check updates the threshold of the requests shed from the memory stats.
+// The threshold is halved at every check that memory is still under
+// pressure, until all requests are shed. It returns the pressure, and
+// whether the guard started or stopped shedding requests.
+func (g *memoryGuard) check() (string, bool) {
+	reason := g.pressure()
+
+	prev := atomic.LoadInt64(&g.shedAbove)
+	var next int64
+	if reason != "" {
+		next = prev / 2
+		if prev == 0 {
+			next = g.config.ShedDatapoints
+		}
+		if next < 1 {
+			next = 1
+		}
+	}
+	atomic.StoreInt64(&g.shedAbove, next)
+
+	return reason, (prev == 0) != (next == 0)
+}
+
+// shed reports whether a request estimated to fetch datapoints is refused.
+func (g *memoryGuard) shed(datapoints int64) (int64, bool) {
+	if g == nil {
+		return 0, false
+	}
+	above := atomic.LoadInt64(&g.shedAbove)
+
+	return above, above > 0 && datapoints > above
+}
+
+// degraded reports whether requests are being shed.
+func (g *memoryGuard) degraded() bool {
+	return g != nil && atomic.LoadInt64(&g.shedAbove) > 0
+}
+
+// runMemoryGuard checks the memory every check interval, for the lifetime
+// of the app.
+func (app *App) runMemoryGuard(logger *zap.Logger) {
+	g := app.admission.memory
+	if g == nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(g.config.CheckInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			reason, changed := g.check()
+			if !changed {
+				continue
+			}
+			if reason != "" {
+				app.prometheusMetrics.Degraded.Set(1)
+				logger.Warn("memory is short, shedding the most expensive requests",
+					zap.String("reason", reason),
+					zap.Int64("shed_above_datapoints", g.config.ShedDatapoints),
+				)
+			} else {
+				app.prometheusMetrics.Degraded.Set(0)
+				logger.Info("memory recovered, no longer shedding requests")
+			}
+		}
+	}()
+}
@@ -0,0 +1,100 @@
+package carbonapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+func TestMemoryGuard(t *testing.T) {
+	if g := newMemoryGuard(cfg.MemoryGuardConfig{ShedDatapoints: 100}); g != nil {
+		t.Fatal("expected no guard without watermarks")
+	}
+
+	var stats runtime.MemStats
+	g := newMemoryGuard(cfg.MemoryGuardConfig{
+		HeapWatermark:    1000,
+		GCPauseWatermark: 100 * time.Millisecond,
+		ShedDatapoints:   100,
+	})
+	g.readMemStats = func(ms *runtime.MemStats) { *ms = stats }
+
+	steps := []struct {
+		name      string
+		heap      uint64
+		pauses    []time.Duration
+		changed   bool
+		shedAbove int64
+	}{
+		{"low heap", 500, nil, false, 0},
+		{"high heap", 2000, nil, true, 100},
+		{"still high", 2000, nil, false, 50},
+		{"long pause", 500, []time.Duration{time.Millisecond, time.Second}, false, 25},
+		{"short pauses", 500, []time.Duration{time.Millisecond}, true, 0},
+		{"no new pause", 500, nil, false, 0},
+	}
+
+	for _, s := range steps {
+		stats.HeapInuse = s.heap
+		for _, p := range s.pauses {
+			stats.PauseNs[stats.NumGC%256] = uint64(p)
+			stats.NumGC++
+		}
+
+		reason, changed := g.check()
+		if changed != s.changed {
+			t.Errorf("%s: expected changed %v, got %v (%q)", s.name, s.changed, changed, reason)
+		}
+		if above, _ := g.shed(0); above != s.shedAbove {
+			t.Errorf("%s: expected to shed above %d datapoints, got %d", s.name, s.shedAbove, above)
+		}
+		if g.degraded() != (s.shedAbove > 0) {
+			t.Errorf("%s: expected degraded %v", s.name, s.shedAbove > 0)
+		}
+	}
+
+	// the threshold bottoms out at shedding all requests
+	stats.HeapInuse = 2000
+	for i := 0; i < 10; i++ {
+		g.check()
+	}
+	if above, shed := g.shed(2); above != 1 || !shed {
+		t.Errorf("expected to shed above 1 datapoint, got %d", above)
+	}
+}
+
+func TestAdmissionMemoryShedding(t *testing.T) {
+	ac := newAdmissionController(cfg.AdmissionConfig{
+		DatapointInterval: time.Minute,
+		RetryAfter:        time.Second,
+		Memory: cfg.MemoryGuardConfig{
+			HeapWatermark:  1,
+			ShedDatapoints: 600,
+		},
+	})
+	ac.memory.readMemStats = func(ms *runtime.MemStats) { ms.HeapInuse = 2 }
+	ac.memory.check()
+
+	ticket := ac.newTicket()
+	defer ticket.release()
+	if err := ticket.admit(context.Background(), 10, 0, 3600); err != nil {
+		t.Fatalf("expected a request of 600 datapoints to be admitted, got %v", err)
+	}
+
+	var admissionErr admissionError
+	err := ticket.admit(context.Background(), 1, 0, 60)
+	if !errors.As(err, &admissionErr) {
+		t.Fatalf("expected an admission error over 600 datapoints, got %v", err)
+	}
+	if admissionErr.Code != http.StatusServiceUnavailable || admissionErr.Reason != "memory" {
+		t.Errorf("expected a 503 for memory, got %d for %s", admissionErr.Code, admissionErr.Reason)
+	}
+	if admissionErr.RetryAfter <= 0 {
+		t.Error("expected a Retry-After hint")
+	}
+}
@@ -2,8 +2,10 @@ package carbonapi
 
 import (
 	"expvar"
+	"time"
 
 	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/internal/expr"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -12,6 +14,7 @@ type PrometheusMetrics struct {
 	Requests                  prometheus.Counter
 	Responses                 *prometheus.CounterVec
 	FindNotFound              prometheus.Counter
+	RenderNotFound            prometheus.Counter
 	RenderPartialFail         prometheus.Counter
 	RequestCancel             *prometheus.CounterVec
 	DurationExp               prometheus.Histogram
@@ -29,6 +32,74 @@ type PrometheusMetrics struct {
 	TimeInQueueLin            prometheus.Histogram
 	ActiveUpstreamRequests    prometheus.Gauge
 	WaitingUpstreamRequests   prometheus.Gauge
+	AdmissionRejections       *prometheus.CounterVec
+	Degraded                  prometheus.Gauge
+	BackendProtocolFallbacks  *prometheus.CounterVec
+
+	CacheRequests  *prometheus.CounterVec
+	CacheDurations *prometheus.HistogramVec
+
+	FindTreeRequests *prometheus.CounterVec
+	FindTreeEntries  prometheus.Gauge
+
+	NamespaceRequests   *prometheus.CounterVec
+	NamespaceDatapoints *prometheus.CounterVec
+
+	StandingQueryLastSuccess *prometheus.GaugeVec
+	StandingQueryFailures    *prometheus.CounterVec
+
+	WriteBackLastSuccess *prometheus.GaugeVec
+	WriteBackFailures    *prometheus.CounterVec
+	WriteBackPoints      *prometheus.CounterVec
+
+	VerifyTargets     *prometheus.CounterVec
+	VerifyDivergences *prometheus.CounterVec
+}
+
+// functionStatsCollector exports the execution statistics of the graphite
+// functions, as kept by the expr package.
+type functionStatsCollector struct {
+	calls        *prometheus.Desc
+	errors       *prometheus.Desc
+	duration     *prometheus.Desc
+	outputSeries *prometheus.Desc
+	outputPoints *prometheus.Desc
+}
+
+func newFunctionStatsCollector() *functionStatsCollector {
+	labels := []string{"function"}
+	return &functionStatsCollector{
+		calls: prometheus.NewDesc("function_calls_total",
+			"Count of graphite function evaluations, partitioned by function", labels, nil),
+		errors: prometheus.NewDesc("function_errors_total",
+			"Count of graphite function evaluations that failed, partitioned by function", labels, nil),
+		duration: prometheus.NewDesc("function_duration_seconds_total",
+			"Time spent evaluating graphite functions, excluding their arguments, partitioned by function", labels, nil),
+		outputSeries: prometheus.NewDesc("function_output_series_total",
+			"Count of series returned by graphite functions, partitioned by function", labels, nil),
+		outputPoints: prometheus.NewDesc("function_output_points_total",
+			"Count of datapoints returned by graphite functions, partitioned by function", labels, nil),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *functionStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.calls
+	ch <- c.errors
+	ch <- c.duration
+	ch <- c.outputSeries
+	ch <- c.outputPoints
+}
+
+// Collect implements prometheus.Collector
+func (c *functionStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, fs := range expr.GetFunctionStats() {
+		ch <- prometheus.MustNewConstMetric(c.calls, prometheus.CounterValue, float64(fs.Calls), fs.Name)
+		ch <- prometheus.MustNewConstMetric(c.errors, prometheus.CounterValue, float64(fs.Errors), fs.Name)
+		ch <- prometheus.MustNewConstMetric(c.duration, prometheus.CounterValue, time.Duration(fs.TimeNS).Seconds(), fs.Name)
+		ch <- prometheus.MustNewConstMetric(c.outputSeries, prometheus.CounterValue, float64(fs.OutputSeries), fs.Name)
+		ch <- prometheus.MustNewConstMetric(c.outputPoints, prometheus.CounterValue, float64(fs.OutputPoints), fs.Name)
+	}
 }
 
 func newPrometheusMetrics(config cfg.API) PrometheusMetrics {
@@ -52,6 +123,12 @@ func newPrometheusMetrics(config cfg.API) PrometheusMetrics {
 				Help: "Count of not-found /find responses",
 			},
 		),
+		RenderNotFound: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "render_not_found",
+				Help: "Count of /render requests that matched no metrics",
+			},
+		),
 		RenderPartialFail: prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Name: "render_part_fail",
@@ -208,6 +285,117 @@ func newPrometheusMetrics(config cfg.API) PrometheusMetrics {
 				Help: "Number of upstream requests waiting on the limiter",
 			},
 		),
+		AdmissionRejections: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "admission_rejections",
+				Help: "Count of render requests rejected by admission control, by the limit they hit",
+			},
+			[]string{"reason"},
+		),
+		Degraded: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "degraded",
+				Help: "1 while memory is short and the most expensive requests are shed, 0 otherwise",
+			},
+		),
+		BackendProtocolFallbacks: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "backend_protocol_fallbacks_total",
+				Help: "Count of backend requests retried with the fallback protocol, partitioned by endpoint",
+			},
+			[]string{"endpoint"},
+		),
+		FindTreeRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "find_tree_requests_total",
+				Help: "Count of finds looked up in the cache of the metric tree, partitioned by result, hit or miss",
+			},
+			[]string{"result"},
+		),
+		FindTreeEntries: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "find_tree_entries",
+				Help: "Number of children of nodes kept in the cache of the metric tree",
+			},
+		),
+		NamespaceRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "namespace_requests_total",
+				Help: "Count of render targets, partitioned by namespace of their metrics",
+			},
+			[]string{"namespace"},
+		),
+		NamespaceDatapoints: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "namespace_datapoints_total",
+				Help: "Count of datapoints fetched for render requests, partitioned by namespace of their metrics",
+			},
+			[]string{"namespace"},
+		),
+		CacheRequests: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "cache_requests_total",
+				Help: "Count of cache operations, partitioned by cache, operation and result",
+			},
+			[]string{"cache", "operation", "result"},
+		),
+		CacheDurations: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "cache_request_duration_seconds",
+				Help:    "The duration of cache operations, partitioned by cache and operation",
+				Buckets: prometheus.ExponentialBuckets(0.0001, 2, 14),
+			},
+			[]string{"cache", "operation"},
+		),
+		StandingQueryLastSuccess: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "standing_query_last_success_timestamp_seconds",
+				Help: "Unix time of the last successful evaluation of each standing query",
+			},
+			[]string{"name"},
+		),
+		StandingQueryFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "standing_query_failures_total",
+				Help: "Count of failed evaluations of each standing query",
+			},
+			[]string{"name"},
+		),
+		WriteBackLastSuccess: prometheus.NewGaugeVec(
+			prometheus.GaugeOpts{
+				Name: "write_back_last_success_timestamp_seconds",
+				Help: "Unix time of the last successful write back of each series",
+			},
+			[]string{"name"},
+		),
+		WriteBackFailures: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "write_back_failures_total",
+				Help: "Count of failed evaluations or writes of each write back series",
+			},
+			[]string{"name"},
+		),
+		WriteBackPoints: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "write_back_points_total",
+				Help: "Count of points written back to carbon, by series",
+			},
+			[]string{"name"},
+		),
+		VerifyTargets: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "verify_targets_total",
+				Help: "Count of targets verified against the reference graphite-web, by result",
+			},
+			[]string{"result"},
+		),
+		VerifyDivergences: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "verify_divergences_total",
+				Help: "Count of targets whose results diverge from the reference graphite-web, by function of the target",
+			},
+			[]string{"function"},
+		),
 	}
 }
 
@@ -225,10 +413,12 @@ var apiMetrics = struct {
 	// TODO (grzkv) Move to Prom
 	// Despite the names, these only count /render requests
 	// TODO duplicate
-	RenderRequests        *expvar.Int
-	RequestCacheHits      *expvar.Int
-	RequestCacheMisses    *expvar.Int
-	RenderCacheOverheadNS *expvar.Int
+	RenderRequests          *expvar.Int
+	RenderRequestsShared    *expvar.Int
+	RenderRequestsCoalesced *expvar.Int
+	RequestCacheHits        *expvar.Int
+	RequestCacheMisses      *expvar.Int
+	RenderCacheOverheadNS   *expvar.Int
 
 	// TODO (grzkv) Move to Prom
 	// TODO duplicate
@@ -236,6 +426,7 @@ var apiMetrics = struct {
 	FindCacheHits       *expvar.Int
 	FindCacheMisses     *expvar.Int
 	FindCacheOverheadNS *expvar.Int
+	FindRequestsShared  *expvar.Int
 
 	MemcacheTimeouts expvar.Func
 
@@ -247,15 +438,18 @@ var apiMetrics = struct {
 	Errors:    expvar.NewInt("errors"),
 
 	// TODO: request_cache -> render_cache
-	RenderRequests:        expvar.NewInt("render_requests"),
-	RequestCacheHits:      expvar.NewInt("request_cache_hits"),
-	RequestCacheMisses:    expvar.NewInt("request_cache_misses"),
-	RenderCacheOverheadNS: expvar.NewInt("render_cache_overhead_ns"),
+	RenderRequests:          expvar.NewInt("render_requests"),
+	RenderRequestsShared:    expvar.NewInt("render_requests_shared"),
+	RenderRequestsCoalesced: expvar.NewInt("render_requests_coalesced"),
+	RequestCacheHits:        expvar.NewInt("request_cache_hits"),
+	RequestCacheMisses:      expvar.NewInt("request_cache_misses"),
+	RenderCacheOverheadNS:   expvar.NewInt("render_cache_overhead_ns"),
 
 	FindRequests:        expvar.NewInt("find_requests"),
 	FindCacheHits:       expvar.NewInt("find_cache_hits"),
 	FindCacheMisses:     expvar.NewInt("find_cache_misses"),
 	FindCacheOverheadNS: expvar.NewInt("find_cache_overhead_ns"),
+	FindRequestsShared:  expvar.NewInt("find_requests_shared"),
 
 }
 
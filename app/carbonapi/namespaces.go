@@ -0,0 +1,85 @@
+package carbonapi
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+// otherNamespace counts the metrics of the namespaces not listed.
+const otherNamespace = "other"
+
+// namespaces tells the namespaces that the load of render requests is
+// counted by.
+type namespaces struct {
+	depth  int
+	listed map[string]bool
+}
+
+// newNamespaces returns nil if the load is not counted by namespace.
+func newNamespaces(config cfg.NamespaceMetricsConfig) (*namespaces, error) {
+	if config.Depth == 0 {
+		return nil, nil
+	}
+	if config.Depth < 0 || config.Depth > 2 {
+		return nil, fmt.Errorf("invalid depth %d, it must be 1 or 2", config.Depth)
+	}
+
+	ns := &namespaces{
+		depth:  config.Depth,
+		listed: make(map[string]bool, len(config.Namespaces)),
+	}
+	for _, n := range config.Namespaces {
+		if strings.Count(n, ".") != config.Depth-1 || strings.ContainsAny(n, "*?[]{}") || n == otherNamespace {
+			return nil, fmt.Errorf("invalid namespace %q, it must be %d nodes", n, config.Depth)
+		}
+		ns.listed[n] = true
+	}
+
+	return ns, nil
+}
+
+// of returns the namespace of metric, which may be a glob.
+func (ns *namespaces) of(metric string) string {
+	if parser.IsSeriesByTag(metric) {
+		return otherNamespace
+	}
+
+	nodes := strings.SplitN(metric, ".", ns.depth+1)
+	if len(nodes) > ns.depth {
+		nodes = nodes[:ns.depth]
+	}
+	if n := strings.Join(nodes, "."); ns.listed[n] {
+		return n
+	}
+
+	return otherNamespace
+}
+
+// countNamespaceRequests counts a request of the metrics of a target once
+// for every namespace of them.
+func (app *App) countNamespaceRequests(metrics []parser.MetricRequest) {
+	if app.namespaces == nil {
+		return
+	}
+
+	seen := make(map[string]bool)
+	for _, m := range metrics {
+		n := app.namespaces.of(m.Metric)
+		if !seen[n] {
+			seen[n] = true
+			app.prometheusMetrics.NamespaceRequests.WithLabelValues(n).Inc()
+		}
+	}
+}
+
+// countNamespaceDatapoints counts the datapoints fetched for metric.
+func (app *App) countNamespaceDatapoints(metric string, datapoints int) {
+	if app.namespaces == nil || datapoints == 0 {
+		return
+	}
+
+	app.prometheusMetrics.NamespaceDatapoints.WithLabelValues(app.namespaces.of(metric)).Add(float64(datapoints))
+}
@@ -0,0 +1,84 @@
+package carbonapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+)
+
+func TestNamespaces(t *testing.T) {
+	if ns, err := newNamespaces(cfg.NamespaceMetricsConfig{}); ns != nil || err != nil {
+		t.Errorf("expected no namespaces without a depth, got %v (%v)", ns, err)
+	}
+	for _, config := range []cfg.NamespaceMetricsConfig{
+		{Depth: 3},
+		{Depth: 1, Namespaces: []string{"team.service"}},
+		{Depth: 2, Namespaces: []string{"team"}},
+		{Depth: 2, Namespaces: []string{"team.*"}},
+	} {
+		if _, err := newNamespaces(config); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+
+	ns, err := newNamespaces(cfg.NamespaceMetricsConfig{Depth: 2, Namespaces: []string{"team.api", "team.db"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for metric, expected := range map[string]string{
+		"team.api.requests":            "team.api",
+		"team.db":                      "team.db",
+		"team.web.requests":            "other",
+		"team.*.requests":              "other",
+		"team":                         "other",
+		"seriesByTag('name=team.api')": "other",
+	} {
+		if got := ns.of(metric); got != expected {
+			t.Errorf("%s: expected namespace %s, got %s", metric, expected, got)
+		}
+	}
+}
+
+func TestNamespaceMetrics(t *testing.T) {
+	backend, namespaces := testApp.backend, testApp.namespaces
+	defer func() {
+		testApp.backend, testApp.namespaces = backend, namespaces
+	}()
+	testApp.backend = mock.New(mock.Config{
+		Find:   find,
+		Info:   info,
+		Render: render,
+	})
+	var err error
+	testApp.namespaces, err = newNamespaces(cfg.NamespaceMetricsConfig{Depth: 1, Namespaces: []string{"foo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	requests := testApp.prometheusMetrics.NamespaceRequests
+	datapoints := testApp.prometheusMetrics.NamespaceDatapoints
+	fooRequests := counterValue(t, requests.WithLabelValues("foo"))
+	otherRequests := counterValue(t, requests.WithLabelValues("other"))
+	fooDatapoints := counterValue(t, datapoints.WithLabelValues("foo"))
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/render?noCache=1&format=json&target=sumSeries(foo.bar,foo.baz,bar.baz)", nil)
+	testRouter.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	if got := counterValue(t, requests.WithLabelValues("foo")) - fooRequests; got != 1 {
+		t.Errorf("expected a request of foo, got %v", got)
+	}
+	if got := counterValue(t, requests.WithLabelValues("other")) - otherRequests; got != 1 {
+		t.Errorf("expected a request of other, got %v", got)
+	}
+	// the mock backend answers with 3 datapoints for every metric
+	if got := counterValue(t, datapoints.WithLabelValues("foo")) - fooDatapoints; got != 6 {
+		t.Errorf("expected 6 datapoints of foo, got %v", got)
+	}
+}
@@ -0,0 +1,252 @@
+package carbonapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/pkg/promql"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.opentelemetry.io/otel/api/trace"
+	"go.uber.org/zap"
+)
+
+// promqlResponse is the envelope of the responses of the Prometheus HTTP
+// API.
+type promqlResponse struct {
+	Status    string      `json:"status"`
+	Data      *promqlData `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+}
+
+type promqlData struct {
+	ResultType string         `json:"resultType"`
+	Result     []promqlSeries `json:"result"`
+}
+
+// promqlSeries is a series of a matrix. Its values are pairs of a unix time
+// in seconds and the value as a string.
+type promqlSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]interface{}  `json:"values"`
+}
+
+// parsePromQLTime parses a time of the Prometheus HTTP API, a unix time in
+// seconds or an RFC 3339 date.
+func parsePromQLTime(s string) (float64, error) {
+	if t, err := strconv.ParseFloat(s, 64); err == nil && !math.IsNaN(t) && !math.IsInf(t, 0) {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return float64(t.UnixNano()) / 1e9, nil
+	}
+	return 0, fmt.Errorf("invalid time %q", s)
+}
+
+// parsePromQLStep parses a step of the Prometheus HTTP API, in seconds or a
+// duration.
+func parsePromQLStep(s string) (float64, error) {
+	step, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		var d time.Duration
+		d, err = time.ParseDuration(s)
+		step = d.Seconds()
+	}
+	if err != nil || !(step > 0) || math.IsInf(step, 0) {
+		return 0, fmt.Errorf("invalid step %q, it must be a positive number of seconds", s)
+	}
+	return step, nil
+}
+
+// promqlMatrix converts the series target evaluated to, between start and
+// end, to a matrix sorted by labels. The series without values are left
+// out, as Prometheus does.
+func promqlMatrix(target promql.Target, results []*types.MetricData, start, end float64) []promqlSeries {
+	matrix := []promqlSeries{}
+	for _, r := range results {
+		labels, ok := target.Labels(r.Name)
+		if !ok {
+			continue
+		}
+
+		s := promqlSeries{Metric: make(map[string]string, len(labels))}
+		for i, v := range r.Values {
+			ts := float64(r.StartTime + int32(i)*r.StepTime)
+			if r.IsAbsent.Get(i) || math.IsNaN(v) || math.IsInf(v, 0) || ts < start || ts > end {
+				continue
+			}
+			s.Values = append(s.Values, [2]interface{}{ts, strconv.FormatFloat(v, 'f', -1, 64)})
+		}
+		if len(s.Values) == 0 {
+			continue
+		}
+		for _, l := range labels {
+			s.Metric[l.Name] = l.Value
+		}
+		matrix = append(matrix, s)
+	}
+
+	sort.SliceStable(matrix, func(i, j int) bool {
+		return promqlKey(matrix[i].Metric) < promqlKey(matrix[j].Metric)
+	})
+
+	return matrix
+}
+
+// promqlKey orders series by their labels, as Prometheus does.
+func promqlKey(metric map[string]string) string {
+	names := make([]string, 0, len(metric))
+	for name := range metric {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name + "\x00" + metric[name] + "\x00")
+	}
+	return b.String()
+}
+
+// queryRangeHandler implements /api/v1/query_range of the Prometheus HTTP
+// API, for the subset of PromQL of package promql, on the metrics the path
+// template maps to series.
+func (app *App) queryRangeHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(r.Context(), app.config.Timeouts.Global)
+	defer cancel()
+	span := trace.SpanFromContext(ctx)
+	uuid := util.GetUUID(ctx)
+
+	partiallyFailed := false
+	toLog := carbonapipb.NewAccessLogDetails(r, "query_range", &app.config)
+	toLog.Format = jsonFormat
+	span.SetAttribute("graphite.username", toLog.Username)
+
+	logAsError := false
+	defer func() {
+		app.deferredAccessLogging(logger, r, &toLog, t0, logAsError)
+	}()
+
+	apiMetrics.Requests.Add(1)
+	app.prometheusMetrics.Requests.Inc()
+
+	fail := func(code int, errorType string, err error) {
+		toLog.HttpCode = int32(code)
+		toLog.Reason = err.Error()
+		span.SetAttribute("error", true)
+		span.SetAttribute("error.message", err.Error())
+		logAsError = true
+
+		body, _ := json.Marshal(promqlResponse{Status: "error", ErrorType: errorType, Error: err.Error()})
+		w.Header().Set("X-Carbonapi-UUID", uuid)
+		w.Header().Set("Content-Type", contentTypeJSON)
+		w.WriteHeader(code)
+		if _, err := w.Write(body); err != nil {
+			toLog.Reason += " 499"
+		}
+	}
+
+	start, err := parsePromQLTime(r.FormValue("start"))
+	if err != nil {
+		fail(http.StatusBadRequest, "bad_data", fmt.Errorf("invalid parameter start: %v", err))
+		return
+	}
+	end, err := parsePromQLTime(r.FormValue("end"))
+	if err != nil {
+		fail(http.StatusBadRequest, "bad_data", fmt.Errorf("invalid parameter end: %v", err))
+		return
+	}
+	if end < start {
+		fail(http.StatusBadRequest, "bad_data", errors.New("invalid parameter end: end timestamp must not be before start time"))
+		return
+	}
+	step, err := parsePromQLStep(r.FormValue("step"))
+	if err != nil {
+		fail(http.StatusBadRequest, "bad_data", fmt.Errorf("invalid parameter step: %v", err))
+		return
+	}
+
+	query := r.FormValue("query")
+	e, err := promql.Parse(query)
+	if err != nil {
+		fail(http.StatusBadRequest, "bad_data", fmt.Errorf("invalid parameter query: %v", err))
+		return
+	}
+	target, err := app.promqlTemplate.Compile(e)
+	if err != nil {
+		fail(http.StatusBadRequest, "bad_data", fmt.Errorf("invalid parameter query: %v", err))
+		return
+	}
+	toLog.Targets = []string{target.Target}
+	setInFlightTargets(ctx, toLog.Targets)
+	span.SetAttribute("graphite.target", target.Target)
+
+	ticket := app.admission.newTicket()
+	defer ticket.release()
+	ctx = withRenderFetches(ctx)
+
+	from, until := int64(math.Floor(start)), int64(math.Ceil(end))
+	if until == from {
+		until++
+	}
+	q := renderBatchQuery{
+		Target: target.Target,
+		From:   strconv.FormatInt(from, 10),
+		Until:  strconv.FormatInt(until, 10),
+	}
+	results, err := app.renderBatchQuery(ctx, q, true, ticket, &toLog, logger, &partiallyFailed, span)
+	var admissionErr admissionError
+	switch {
+	case errors.As(err, &admissionErr):
+		admissionErr.setHeaders(w.Header())
+		app.prometheusMetrics.AdmissionRejections.WithLabelValues(admissionErr.Reason).Inc()
+		fail(admissionErr.Code, "execution", err)
+		return
+	case err != nil && ctx.Err() != nil:
+		app.prometheusMetrics.RequestCancel.WithLabelValues("query_range", ctx.Err().Error()).Inc()
+		fail(http.StatusServiceUnavailable, "timeout", err)
+		return
+	case err != nil:
+		fail(http.StatusUnprocessableEntity, "execution", err)
+		return
+	}
+
+	if consolidated := types.ConsolidateJSON(int((end-start)/step)+1, results); consolidated != nil {
+		results = consolidated
+	}
+
+	body, err := json.Marshal(promqlResponse{
+		Status: "success",
+		Data: &promqlData{
+			ResultType: "matrix",
+			Result:     promqlMatrix(target, results, start, end),
+		},
+	})
+	if err != nil {
+		fail(http.StatusInternalServerError, "internal", err)
+		return
+	}
+
+	if writeErr := writeResponse(ctx, w, body, jsonFormat, ""); writeErr != nil {
+		toLog.HttpCode = 499
+		return
+	}
+
+	if partiallyFailed {
+		app.prometheusMetrics.RenderPartialFail.Inc()
+	}
+	toLog.HttpCode = http.StatusOK
+}
@@ -0,0 +1,114 @@
+package carbonapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/promql"
+
+	"go.uber.org/zap"
+)
+
+func TestParsePromQLTime(t *testing.T) {
+	for s, expected := range map[string]float64{
+		"1510913280":           1510913280,
+		"1510913280.5":         1510913280.5,
+		"2017-11-17T10:08:00Z": 1510913280,
+	} {
+		if got, err := parsePromQLTime(s); err != nil || got != expected {
+			t.Errorf("%s: expected %v, got %v (%v)", s, expected, got, err)
+		}
+	}
+	for _, s := range []string{"", "now", "NaN"} {
+		if _, err := parsePromQLTime(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+
+	for s, expected := range map[string]float64{"60": 60, "0.5": 0.5, "1m": 60} {
+		if got, err := parsePromQLStep(s); err != nil || got != expected {
+			t.Errorf("%s: expected %v, got %v (%v)", s, expected, got, err)
+		}
+	}
+	for _, s := range []string{"", "0", "-1", "1x"} {
+		if _, err := parsePromQLStep(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestQueryRangeHandler(t *testing.T) {
+	template, backend := testApp.promqlTemplate, testApp.backend
+	defer func() { testApp.promqlTemplate, testApp.backend = template, backend }()
+
+	var err error
+	testApp.promqlTemplate, err = promql.ParsePathTemplate("{__name__}.{host}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	testApp.backend = mock.New(mock.Config{Find: find, Info: info, Render: render})
+	router := initHandlers(testApp, zap.NewNop())
+
+	tests := []struct {
+		name     string
+		query    string
+		code     int
+		expected promqlResponse
+	}{
+		{
+			name:  "selector",
+			query: `foo{host="bar"}`,
+			code:  http.StatusOK,
+			expected: promqlResponse{Status: "success", Data: &promqlData{ResultType: "matrix", Result: []promqlSeries{{
+				Metric: map[string]string{"__name__": "foo", "host": "bar"},
+				Values: [][2]interface{}{{1510913340.0, "1510913759"}, {1510913400.0, "1510913818"}},
+			}}}},
+		},
+		{
+			name:  "sum by",
+			query: `sum by (host) (foo{host="bar"})`,
+			code:  http.StatusOK,
+			expected: promqlResponse{Status: "success", Data: &promqlData{ResultType: "matrix", Result: []promqlSeries{{
+				Metric: map[string]string{"host": "bar"},
+				Values: [][2]interface{}{{1510913340.0, "1510913759"}, {1510913400.0, "1510913818"}},
+			}}}},
+		},
+		{
+			name:     "unknown label",
+			query:    `foo{dc="a"}`,
+			code:     http.StatusBadRequest,
+			expected: promqlResponse{Status: "error", ErrorType: "bad_data", Error: "invalid parameter query: label dc is not in the path template"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{
+				"query": {tt.query},
+				"start": {"1510913280"},
+				"end":   {"1510913880"},
+				"step":  {"60"},
+			}
+			req := httptest.NewRequest("GET", "/api/v1/query_range?"+form.Encode(), nil)
+			rr := httptest.NewRecorder()
+			router.ServeHTTP(rr, req)
+
+			if rr.Code != tt.code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.code, rr.Code, rr.Body.String())
+			}
+			var got promqlResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.expected) {
+				t.Errorf("expected %+v, got %+v", tt.expected, got)
+			}
+		})
+	}
+}
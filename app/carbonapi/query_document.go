@@ -0,0 +1,96 @@
+package carbonapi
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+var errUnterminatedComment = errors.New("unterminated comment")
+
+// stripQueryDocument makes a POSTed query document plain JSON, so that stored
+// documents can be written for people to read. It drops the // line and
+// /* */ block comments outside strings, and turns the line breaks and tabs
+// inside strings, which JSON does not allow, into spaces, so that targets can
+// be formatted over several lines.
+func stripQueryDocument(doc []byte) ([]byte, error) {
+	out := make([]byte, 0, len(doc))
+	inString := false
+	for i := 0; i < len(doc); i++ {
+		c := doc[i]
+		if inString {
+			switch c {
+			case '\\':
+				out = append(out, c)
+				if i+1 < len(doc) {
+					i++
+					out = append(out, doc[i])
+				}
+				continue
+			case '"':
+				inString = false
+			case '\n', '\r', '\t':
+				c = ' '
+			}
+			out = append(out, c)
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(doc) && doc[i+1] == '/':
+			for i < len(doc) && doc[i] != '\n' {
+				i++
+			}
+			// keep the line break, it may end a token
+			if i < len(doc) {
+				out = append(out, '\n')
+			}
+			continue
+		case c == '/' && i+1 < len(doc) && doc[i+1] == '*':
+			end := strings.Index(string(doc[i+2:]), "*/")
+			if end < 0 {
+				return nil, errUnterminatedComment
+			}
+			i += end + 3
+			out = append(out, ' ')
+			continue
+		}
+		out = append(out, c)
+	}
+
+	return out, nil
+}
+
+// flattenTarget puts a target formatted over several lines back on a single
+// one: the runs of whitespace outside quoted strings become a single space,
+// and the target is trimmed.
+func flattenTarget(target string) string {
+	var b strings.Builder
+	b.Grow(len(target))
+
+	var quote rune
+	space := false
+	for _, r := range strings.TrimSpace(target) {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case r == '\'' || r == '"':
+			quote = r
+		}
+
+		if space {
+			b.WriteByte(' ')
+			space = false
+		}
+		b.WriteRune(r)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,53 @@
+package carbonapi
+
+import (
+	"testing"
+)
+
+func TestStripQueryDocument(t *testing.T) {
+	tests := []struct {
+		name     string
+		doc      string
+		expected string
+	}{
+		{"plain", `[{"target": "foo.bar"}]`, `[{"target": "foo.bar"}]`},
+		{"line comment", "[ // the queries\n{\"target\": \"foo.bar\"}]", "[ \n{\"target\": \"foo.bar\"}]"},
+		{"block comment", `[/* a, b */{"target": "foo.bar"}]`, `[ {"target": "foo.bar"}]`},
+		{"comments in strings", `[{"target": "foo.bar", "id": "//a /*b*/"}]`, `[{"target": "foo.bar", "id": "//a /*b*/"}]`},
+		{"escaped quote", `[{"id": "a\"//", "target": "foo.bar"}]`, `[{"id": "a\"//", "target": "foo.bar"}]`},
+		{"multi-line target", "[{\"target\": \"sumSeries(\n\tfoo.bar,\r\n\tfoo.baz\n)\"}]", "[{\"target\": \"sumSeries(  foo.bar,   foo.baz )\"}]"},
+	}
+
+	for _, tt := range tests {
+		got, err := stripQueryDocument([]byte(tt.doc))
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+			continue
+		}
+		if string(got) != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.expected, got)
+		}
+	}
+
+	if _, err := stripQueryDocument([]byte(`[/* the queries`)); err != errUnterminatedComment {
+		t.Errorf("expected %v for an unterminated comment, got %v", errUnterminatedComment, err)
+	}
+}
+
+func TestFlattenTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"foo.bar", "foo.bar"},
+		{"  sumSeries(\n\tfoo.bar,\n\tfoo.baz\n)\n", "sumSeries( foo.bar, foo.baz )"},
+		{"foo.bar\n  | alias('a  b')", "foo.bar | alias('a  b')"},
+		{`alias(foo.bar,  "it's  here")`, `alias(foo.bar, "it's  here")`},
+	}
+
+	for _, tt := range tests {
+		if got := flattenTarget(tt.target); got != tt.expected {
+			t.Errorf("%q: expected %q, got %q", tt.target, tt.expected, got)
+		}
+	}
+}
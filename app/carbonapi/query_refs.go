@@ -0,0 +1,80 @@
+package carbonapi
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/internal/parser"
+
+	"gopkg.in/yaml.v2"
+)
+
+// newQueryRefs checks the configured query refs, and those of file if set,
+// and returns their targets by name. Targets may span several lines, and are
+// parsed so that a ref is vetted once, when it is loaded.
+func (app *App) newQueryRefs(configs []cfg.QueryRefConfig, file string) (map[string][]string, error) {
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var more []cfg.QueryRefConfig
+		if err := yaml.Unmarshal(b, &more); err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		configs = append(configs[:len(configs):len(configs)], more...)
+	}
+
+	refs := make(map[string][]string, len(configs))
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, errors.New("query ref without a name")
+		}
+		if _, ok := refs[config.Name]; ok {
+			return nil, fmt.Errorf("duplicate query ref %s", config.Name)
+		}
+		if len(config.Targets) == 0 {
+			return nil, fmt.Errorf("query ref %s: no target", config.Name)
+		}
+
+		targets := make([]string, len(config.Targets))
+		for i, target := range config.Targets {
+			targets[i] = flattenTarget(target)
+			if err := app.checkTarget(targets[i]); err != nil {
+				return nil, fmt.Errorf("query ref %s: %w", config.Name, err)
+			}
+			if _, e, err := parser.ParseExpr(targets[i]); err != nil || e != "" {
+				return nil, fmt.Errorf("query ref %s: %s", config.Name, buildParseErrorString(targets[i], e, err))
+			}
+		}
+		refs[config.Name] = targets
+	}
+
+	return refs, nil
+}
+
+// resolveQueryRefs replaces the queryRef parameters of a render request by
+// the targets they refer to, after the targets of its own, for the request
+// to be evaluated, logged and cached as if it had them all.
+func (app *App) resolveQueryRefs(form url.Values) error {
+	names := form["queryRef"]
+	if len(names) == 0 {
+		return nil
+	}
+
+	targets := form["target"]
+	for _, name := range names {
+		refTargets, ok := app.queryRefs[name]
+		if !ok {
+			return fmt.Errorf("unknown queryRef %s", name)
+		}
+		targets = append(targets[:len(targets):len(targets)], refTargets...)
+	}
+	form["target"] = targets
+	form.Del("queryRef")
+
+	return nil
+}
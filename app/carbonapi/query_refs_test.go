@@ -0,0 +1,78 @@
+package carbonapi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+func TestNewQueryRefs(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "query_refs.yaml")
+	content := `
+- name: bar
+  targets:
+    - |
+      sumSeries(
+        foo.*)
+`
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configs := []cfg.QueryRefConfig{{Name: "foo", Targets: []string{"foo.bar", "foo.baz"}}}
+	refs, err := testApp.newQueryRefs(configs, file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string][]string{
+		"foo": {"foo.bar", "foo.baz"},
+		"bar": {"sumSeries( foo.*)"},
+	}
+	if !reflect.DeepEqual(refs, expected) {
+		t.Errorf("expected %v, got %v", expected, refs)
+	}
+
+	for _, invalid := range [][]cfg.QueryRefConfig{
+		{{Targets: []string{"foo.bar"}}},
+		{{Name: "foo"}},
+		{{Name: "foo", Targets: []string{"foo.bar"}}, {Name: "foo", Targets: []string{"foo.baz"}}},
+		{{Name: "foo", Targets: []string{"sumSeries(foo.bar"}}},
+	} {
+		if _, err := testApp.newQueryRefs(invalid, ""); err == nil {
+			t.Errorf("expected an error for %+v", invalid)
+		}
+	}
+
+	if _, err := testApp.newQueryRefs(nil, filepath.Join(t.TempDir(), "missing.yaml")); !os.IsNotExist(err) {
+		t.Errorf("expected the missing file to be an error, got %v", err)
+	}
+}
+
+func TestRenderQueryRef(t *testing.T) {
+	defer func(refs map[string][]string) {
+		testApp.queryRefs = refs
+	}(testApp.queryRefs)
+	testApp.queryRefs = map[string][]string{"foo": {"foo.bar"}}
+
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, httptest.NewRequest("GET", "/render?queryRef=foo&format=json&noCache=1", nil))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+	if !strings.Contains(rr.Body.String(), `"target":"foo.bar"`) {
+		t.Errorf("expected the targets of the ref to be rendered, got %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, httptest.NewRequest("GET", "/render?queryRef=baz&format=json", nil))
+	if rr.Code != http.StatusBadRequest || !strings.Contains(rr.Body.String(), "unknown queryRef baz") {
+		t.Errorf("expected an unknown ref to be a bad request, got %d: %s", rr.Code, rr.Body.String())
+	}
+}
@@ -0,0 +1,218 @@
+package carbonapi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/internal/parser"
+	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// renderDebug is the execution report of a render request with debug=true,
+// answered instead of its data, to tell why the request is slow: what its
+// targets expand to, what was fetched and how long that took, and what was
+// answered from the caches.
+type renderDebug struct {
+	mu sync.Mutex
+
+	QueryCache      string              `json:"queryCache"`
+	Targets         []renderDebugTarget `json:"targets"`
+	Finds           []renderDebugFind   `json:"finds"`
+	Fetches         []renderDebugFetch  `json:"fetches"`
+	Mismatches      int64               `json:"mismatches"`
+	FixedMismatches int64               `json:"fixedMismatches"`
+	Seconds         float64             `json:"seconds"`
+}
+
+type renderDebugTarget struct {
+	Target     string              `json:"target"`
+	Expression renderDebugExpr     `json:"expression"`
+	Metrics    []renderDebugMetric `json:"metrics"`
+	Error      string              `json:"error,omitempty"`
+}
+
+// renderDebugExpr is a node of the tree of a parsed expression.
+type renderDebugExpr struct {
+	Type      string                     `json:"type"`
+	Value     string                     `json:"value"`
+	Args      []renderDebugExpr          `json:"args,omitempty"`
+	NamedArgs map[string]renderDebugExpr `json:"namedArgs,omitempty"`
+}
+
+// renderDebugMetric is a metric request of a target, with the requests it
+// is fetched with, after its globs are resolved.
+type renderDebugMetric struct {
+	Metric   string   `json:"metric"`
+	From     int64    `json:"from"`
+	Until    int64    `json:"until"`
+	Requests []string `json:"requests"`
+	Error    string   `json:"error,omitempty"`
+}
+
+type renderDebugFind struct {
+	Query     string `json:"query"`
+	FromCache bool   `json:"fromCache"`
+	Matches   int    `json:"matches"`
+	Error     string `json:"error,omitempty"`
+}
+
+// renderDebugFetch is a render request to the backend. Shared fetches are
+// answered by an identical one in flight, which only that one is timed in
+// detail for.
+type renderDebugFetch struct {
+	Path            string  `json:"path"`
+	From            int64   `json:"from"`
+	Until           int64   `json:"until"`
+	Seconds         float64 `json:"seconds"`
+	QueueSeconds    float64 `json:"queueSeconds"`
+	FetchSeconds    float64 `json:"fetchSeconds"`
+	DecodeSeconds   float64 `json:"decodeSeconds"`
+	Shared          bool    `json:"shared"`
+	Series          int     `json:"series"`
+	Datapoints      int     `json:"datapoints"`
+	Mismatches      int64   `json:"mismatches"`
+	FixedMismatches int64   `json:"fixedMismatches"`
+	Error           string  `json:"error,omitempty"`
+}
+
+type renderDebugKey struct{}
+
+func withRenderDebug(ctx context.Context, d *renderDebug) context.Context {
+	return context.WithValue(ctx, renderDebugKey{}, d)
+}
+
+// renderDebugFrom returns the report of the request of ctx, nil unless it
+// asks for one.
+func renderDebugFrom(ctx context.Context) *renderDebug {
+	d, _ := ctx.Value(renderDebugKey{}).(*renderDebug)
+	return d
+}
+
+func newRenderDebugExpr(e parser.Expr) renderDebugExpr {
+	node := renderDebugExpr{Value: e.Target()}
+	switch e.Type() {
+	case parser.EtName:
+		node.Type = "name"
+	case parser.EtFunc:
+		node.Type = "func"
+	case parser.EtConst:
+		node.Type = "const"
+		node.Value = e.ToString()
+	case parser.EtString:
+		node.Type = "string"
+		node.Value = e.StringValue()
+	}
+
+	for _, arg := range e.Args() {
+		node.Args = append(node.Args, newRenderDebugExpr(arg))
+	}
+	if named := e.NamedArgs(); len(named) > 0 {
+		node.NamedArgs = make(map[string]renderDebugExpr, len(named))
+		for name, arg := range named {
+			node.NamedArgs[name] = newRenderDebugExpr(arg)
+		}
+	}
+
+	return node
+}
+
+func (d *renderDebug) target(target string, exp parser.Expr) {
+	d.mu.Lock()
+	d.Targets = append(d.Targets, renderDebugTarget{
+		Target:     target,
+		Expression: newRenderDebugExpr(exp),
+	})
+	d.mu.Unlock()
+}
+
+// targetError records the error of the last target.
+func (d *renderDebug) targetError(err error) {
+	d.mu.Lock()
+	if n := len(d.Targets); n > 0 {
+		d.Targets[n-1].Error = err.Error()
+	}
+	d.mu.Unlock()
+}
+
+// metric records a metric request of the last target.
+func (d *renderDebug) metric(m parser.MetricRequest, requests []string, err error) {
+	metric := renderDebugMetric{
+		Metric:   m.Metric,
+		From:     m.From,
+		Until:    m.Until,
+		Requests: requests,
+	}
+	if err != nil {
+		metric.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	if n := len(d.Targets); n > 0 {
+		d.Targets[n-1].Metrics = append(d.Targets[n-1].Metrics, metric)
+	}
+	d.mu.Unlock()
+}
+
+func (d *renderDebug) find(query string, fromCache bool, matches dataTypes.Matches, err error) {
+	find := renderDebugFind{
+		Query:     query,
+		FromCache: fromCache,
+		Matches:   len(matches.Matches),
+	}
+	if err != nil {
+		find.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	d.Finds = append(d.Finds, find)
+	d.mu.Unlock()
+}
+
+// fetch records a render request of path that took elapsed, traced by
+// request unless it was shared.
+func (d *renderDebug) fetch(path string, from, until int64, elapsed time.Duration, request *dataTypes.RenderRequest,
+	metrics []dataTypes.Metric, err error) {
+	fetch := renderDebugFetch{
+		Path:    path,
+		From:    from,
+		Until:   until,
+		Seconds: elapsed.Seconds(),
+		Shared:  request == nil,
+		Series:  len(metrics),
+	}
+	for _, m := range metrics {
+		fetch.Datapoints += len(m.Values)
+	}
+	if request != nil {
+		report := request.Trace.Report()
+		fetch.QueueSeconds = report.Queue.Seconds()
+		fetch.FetchSeconds = report.Fetch.Seconds()
+		fetch.DecodeSeconds = report.Decode.Seconds()
+		fetch.Mismatches, fetch.FixedMismatches = request.Trace.Mismatches()
+	}
+	if err != nil {
+		fetch.Error = err.Error()
+	}
+
+	d.mu.Lock()
+	d.Fetches = append(d.Fetches, fetch)
+	d.Mismatches += fetch.Mismatches
+	d.FixedMismatches += fetch.FixedMismatches
+	d.mu.Unlock()
+}
+
+// writeRenderDebug answers with the report of a request that started at t0.
+func writeRenderDebug(ctx context.Context, w http.ResponseWriter, d *renderDebug, t0 time.Time, jsonp string) error {
+	d.mu.Lock()
+	d.Seconds = time.Since(t0).Seconds()
+	b, err := json.Marshal(d)
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	return writeResponse(ctx, w, b, jsonFormat, jsonp)
+}
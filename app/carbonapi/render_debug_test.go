@@ -0,0 +1,60 @@
+package carbonapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+)
+
+func TestRenderDebug(t *testing.T) {
+	backend := testApp.backend
+	defer func() {
+		testApp.backend = backend
+	}()
+	testApp.backend = mock.New(mock.Config{
+		Find:   find,
+		Info:   info,
+		Render: render,
+	})
+
+	rr := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/render?noCache=1&format=json&debug=1&target=scale(foo.b*,2)", nil)
+	testRouter.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code 200, got %d: %s", rr.Code, rr.Body.String())
+	}
+
+	var report renderDebug
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatalf("expected a report, got %s: %v", rr.Body.String(), err)
+	}
+	if report.QueryCache != "disabled" {
+		t.Errorf("expected the query cache to be disabled, got %s", report.QueryCache)
+	}
+	if len(report.Targets) != 1 {
+		t.Fatalf("expected a target, got %+v", report.Targets)
+	}
+
+	target := report.Targets[0]
+	if e := target.Expression; e.Type != "func" || e.Value != "scale" || len(e.Args) != 2 ||
+		e.Args[0].Type != "name" || e.Args[0].Value != "foo.b*" || e.Args[1].Type != "const" {
+		t.Errorf("unexpected expression %+v", e)
+	}
+	if len(target.Metrics) != 1 || len(target.Metrics[0].Requests) != 2 {
+		t.Errorf("expected foo.b* to be fetched with 2 requests, got %+v", target.Metrics)
+	}
+	if len(report.Finds) != 1 || report.Finds[0].Query != "foo.b*" || report.Finds[0].Matches != 2 {
+		t.Errorf("expected a find of foo.b* with 2 matches, got %+v", report.Finds)
+	}
+	if len(report.Fetches) != 2 {
+		t.Fatalf("expected 2 fetches, got %+v", report.Fetches)
+	}
+	for _, f := range report.Fetches {
+		if f.Shared || f.Series != 1 || f.Datapoints != 3 {
+			t.Errorf("unexpected fetch %+v", f)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package carbonapi
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/internal/parser"
+	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/util"
+)
+
+// renderFetches coalesces the render requests of a single API request: each
+// series is fetched once for a range, however many of its targets, the
+// expressions they expand to or the queries of a batch ask for it, and
+// whether or not the fetch failed. The metricMap of a target only spares the
+// fetches of the same metric request after a success.
+type renderFetches struct {
+	mu      sync.Mutex
+	fetches map[parser.MetricRequest]*renderFetch
+}
+
+type renderFetch struct {
+	done    chan struct{}
+	metrics []dataTypes.Metric
+	err     error
+}
+
+type renderFetchesKey struct{}
+
+// withRenderFetches returns ctx with a new set of coalesced fetches, for the
+// lifetime of an API request.
+func withRenderFetches(ctx context.Context) context.Context {
+	return context.WithValue(ctx, renderFetchesKey{}, &renderFetches{
+		fetches: make(map[parser.MetricRequest]*renderFetch),
+	})
+}
+
+func renderFetchesFrom(ctx context.Context) *renderFetches {
+	f, _ := ctx.Value(renderFetchesKey{}).(*renderFetches)
+	return f
+}
+
+// do returns the result of fetch for m, calling it only if m wasn't fetched
+// before, and whether the result is shared with an earlier call. The metrics
+// are shared as they are, like metricMap shares them between targets.
+func (f *renderFetches) do(ctx context.Context, m parser.MetricRequest, fetch func() ([]dataTypes.Metric, error)) ([]dataTypes.Metric, bool, error) {
+	f.mu.Lock()
+	if call, ok := f.fetches[m]; ok {
+		f.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.metrics, true, call.err
+		case <-ctx.Done():
+			return nil, true, ctx.Err()
+		}
+	}
+	call := &renderFetch{done: make(chan struct{})}
+	f.fetches[m] = call
+	f.mu.Unlock()
+
+	call.metrics, call.err = fetch()
+	close(call.done)
+
+	return call.metrics, false, call.err
+}
+
+// coalesceRender returns the result of render for path, sharing it with the
+// requests asking for the same range at the same time, and whether it was
+// shared. The range is widened to the coalescing bucket, if any, and the
+// result trimmed back to the range asked for. Only the requests of the same
+// tenant and priority share renders, for the backend limiter to account
+// for them as if they were not shared. render runs on a context detached
+// from the one of the request that started it, as in doShared.
+func (app *App) coalesceRender(ctx context.Context, path string, from, until int64,
+	render func(ctx context.Context, from, until int64) ([]dataTypes.Metric, error)) ([]dataTypes.Metric, bool, error) {
+
+	renderFrom, renderUntil := from, until
+	if bucket := int64(app.config.CoalesceRenders.Bucket / time.Second); bucket > 0 {
+		renderFrom -= renderFrom % bucket
+		if renderUntil%bucket != 0 {
+			renderUntil += bucket - renderUntil%bucket
+		}
+	}
+
+	key := fmt.Sprintf("%s\x00%d\x00%d\x00%s\x00%d", path, renderFrom, renderUntil,
+		util.GetTenant(ctx), util.GetPriority(ctx))
+	v, shared, err := doShared(ctx, &app.renders, key, app.config.Timeouts.Global, func(ctx context.Context) (interface{}, error) {
+		return render(ctx, renderFrom, renderUntil)
+	})
+	metrics, _ := v.([]dataTypes.Metric)
+	if renderFrom != from || renderUntil != until {
+		metrics = trimMetrics(metrics, from, until)
+	}
+
+	return metrics, shared, err
+}
+
+// trimMetrics returns metrics with only their points between from and until,
+// both included. The metrics are shared, so they are sliced rather than
+// changed.
+func trimMetrics(metrics []dataTypes.Metric, from, until int64) []dataTypes.Metric {
+	if metrics == nil {
+		return nil
+	}
+
+	trimmed := make([]dataTypes.Metric, len(metrics))
+	for k, m := range metrics {
+		trimmed[k] = m
+		step, start := int64(m.StepTime), int64(m.StartTime)
+		if step <= 0 {
+			continue
+		}
+
+		n := len(m.Values)
+		i, j := 0, 0
+		if from > start {
+			i = int((from - start + step - 1) / step)
+		}
+		if until >= start {
+			j = int((until-start)/step) + 1
+		}
+		if j > n {
+			j = n
+		}
+		if i > j {
+			i = j
+		}
+
+		trimmed[k].Values = m.Values[i:j:j]
+		if m.IsAbsent.Len() >= j {
+			trimmed[k].IsAbsent = m.IsAbsent.Slice(i, j)
+		}
+		trimmed[k].StartTime = int32(start + int64(i)*step)
+		trimmed[k].StopTime = int32(start + int64(j)*step)
+	}
+
+	return trimmed
+}
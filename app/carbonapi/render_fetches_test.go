@@ -0,0 +1,151 @@
+package carbonapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestRenderFetches(t *testing.T) {
+	fetches := renderFetchesFrom(withRenderFetches(context.Background()))
+	m := parser.MetricRequest{Metric: "foo.bar", From: 60, Until: 120}
+
+	calls := 0
+	fetch := func() ([]types.Metric, error) {
+		calls++
+		return nil, errors.New("fetch failed")
+	}
+
+	_, shared, err := fetches.do(context.Background(), m, fetch)
+	if shared || err == nil {
+		t.Fatalf("expected the first fetch to fail unshared, got shared=%v err=%v", shared, err)
+	}
+	_, shared, err2 := fetches.do(context.Background(), m, fetch)
+	if !shared || err2 != err {
+		t.Errorf("expected the error to be shared, got shared=%v err=%v", shared, err2)
+	}
+	if calls != 1 {
+		t.Errorf("expected 1 fetch, got %d", calls)
+	}
+
+	m.Until = 180
+	if _, shared, _ := fetches.do(context.Background(), m, fetch); shared || calls != 2 {
+		t.Errorf("expected another range to be fetched, got shared=%v after %d fetches", shared, calls)
+	}
+}
+
+func TestRenderBatchFetchesOnce(t *testing.T) {
+	var renders int64
+	defer func(b backend.Backend) { testApp.backend = b }(testApp.backend)
+	testApp.backend = mock.New(mock.Config{
+		Find: find,
+		Info: info,
+		Render: func(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+			atomic.AddInt64(&renders, 1)
+			return render(ctx, request)
+		},
+	})
+
+	body := `[
+		{"target": "foo.bar", "from": "1510913280", "until": "1510913460"},
+		{"target": "sumSeries(foo.bar, foo.bar)", "from": "1510913280", "until": "1510913460"}
+	]`
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, httptest.NewRequest("POST", "/render/batch?noCache=1", strings.NewReader(body)))
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+	if renders != 1 {
+		t.Errorf("expected foo.bar to be rendered once, got %d renders", renders)
+	}
+}
+
+func TestCoalesceRender(t *testing.T) {
+	app := &App{config: cfg.API{CoalesceRenders: cfg.CoalesceRendersConfig{
+		Enabled: true,
+		Bucket:  time.Minute,
+	}}}
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	var calls int64
+	var ranges [][2]int64
+	render := func(ctx context.Context, from, until int64) ([]types.Metric, error) {
+		atomic.AddInt64(&calls, 1)
+		ranges = append(ranges, [2]int64{from, until})
+		started <- struct{}{}
+		<-release
+		return []types.Metric{{
+			Name:      "foo.bar",
+			StartTime: int32(from),
+			StopTime:  int32(until),
+			StepTime:  60,
+			Values:    []float64{1, 2, 3, 4},
+			IsAbsent:  types.AbsenceOf(false, true, false, false),
+		}}, ctx.Err()
+	}
+
+	// the request that starts the render gives up before it is done
+	firstCtx, cancel := context.WithCancel(context.Background())
+	first := make(chan error)
+	go func() {
+		_, _, err := app.coalesceRender(firstCtx, "foo.bar", 90, 290, render)
+		first <- err
+	}()
+	<-started
+	secondCtx := newJoinContext(context.Background())
+	type result struct {
+		metrics []types.Metric
+		shared  bool
+		err     error
+	}
+	second := make(chan result)
+	go func() {
+		metrics, shared, err := app.coalesceRender(secondCtx, "foo.bar", 100, 290, render)
+		second <- result{metrics, shared, err}
+	}()
+	<-secondCtx.joined
+	cancel()
+	if err := <-first; err != context.Canceled {
+		t.Errorf("expected the first request to be canceled, got %v", err)
+	}
+	close(release)
+
+	r := <-second
+	if !r.shared || r.err != nil {
+		t.Errorf("expected the render to be shared, got %v, %v", r.shared, r.err)
+	}
+	if calls != 1 || ranges[0] != [2]int64{60, 300} {
+		t.Errorf("expected a single render of [60, 300), got %v", ranges)
+	}
+	// trimmed to the points of [100, 290]
+	want := types.Metric{
+		Name:      "foo.bar",
+		StartTime: 120,
+		StopTime:  300,
+		StepTime:  60,
+		Values:    []float64{2, 3, 4},
+		IsAbsent:  types.AbsenceOf(true, false, false),
+	}
+	if len(r.metrics) != 1 || !types.MetricsEqual(r.metrics[0], want) {
+		t.Errorf("expected %v, got %v", want, r.metrics)
+	}
+
+	go func() { <-started }()
+	metrics, shared, err := app.coalesceRender(context.Background(), "foo.bar", 60, 300, render)
+	if shared || err != nil || len(metrics) != 1 || len(metrics[0].Values) != 4 {
+		t.Errorf("expected a render of its own once none is in flight, got %v, %v, %v", metrics, shared, err)
+	}
+}
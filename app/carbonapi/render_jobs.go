@@ -0,0 +1,291 @@
+package carbonapi
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/api/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	renderJobRunning = "running"
+	renderJobDone    = "done"
+	renderJobFailed  = "failed"
+)
+
+var errTooManyRenderJobs = errors.New("too many render jobs")
+
+// renderJob is a render request run in the background. Its response is
+// recorded, to be fetched once it is done.
+type renderJob struct {
+	id       string
+	created  time.Time
+	finished time.Time // zero while running
+	response *renderJobResponse
+}
+
+// renderJobStatus is the answer of /render/status
+type renderJobStatus struct {
+	ID       string     `json:"id"`
+	Status   string     `json:"status"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Code     int        `json:"code,omitempty"`
+}
+
+// renderJobs keeps the render jobs, running or holding a result, up to the
+// limits of cfg.AsyncRenderConfig. Results are expired lazily.
+type renderJobs struct {
+	config cfg.AsyncRenderConfig
+
+	mu   sync.Mutex
+	jobs map[string]*renderJob
+}
+
+func newRenderJobs(config cfg.AsyncRenderConfig) *renderJobs {
+	return &renderJobs{
+		config: config,
+		jobs:   make(map[string]*renderJob),
+	}
+}
+
+// add registers a new running job, unless there are too many already. Its
+// id is random, so that only the client that started it can find it.
+func (rj *renderJobs) add(now time.Time) (*renderJob, error) {
+	id, err := newRenderJobID()
+	if err != nil {
+		return nil, err
+	}
+
+	rj.mu.Lock()
+	defer rj.mu.Unlock()
+
+	rj.expire(now)
+	if len(rj.jobs) >= rj.config.MaxJobs {
+		return nil, errTooManyRenderJobs
+	}
+	if _, ok := rj.jobs[id]; ok {
+		return nil, errors.New("duplicate render job " + id)
+	}
+
+	job := &renderJob{
+		id:      id,
+		created: now,
+	}
+	rj.jobs[id] = job
+
+	return job, nil
+}
+
+// newRenderJobID returns a random 128-bit id, hex-encoded.
+func newRenderJobID() (string, error) {
+	var id [16]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(id[:]), nil
+}
+
+// finish records the response of job.
+func (rj *renderJobs) finish(job *renderJob, response *renderJobResponse, now time.Time) {
+	rj.mu.Lock()
+	defer rj.mu.Unlock()
+
+	job.response = response
+	job.finished = now
+}
+
+// get returns the status of the job id and its response if it is done. It
+// returns false for unknown and expired jobs.
+func (rj *renderJobs) get(id string, now time.Time) (renderJobStatus, *renderJobResponse, bool) {
+	rj.mu.Lock()
+	defer rj.mu.Unlock()
+
+	rj.expire(now)
+	job, ok := rj.jobs[id]
+	if !ok {
+		return renderJobStatus{}, nil, false
+	}
+
+	status := renderJobStatus{
+		ID:      job.id,
+		Status:  renderJobRunning,
+		Created: job.created,
+	}
+	if job.response == nil {
+		return status, nil, true
+	}
+
+	finished := job.finished
+	status.Finished = &finished
+	status.Code = job.response.code
+	if status.Code/100 == 2 {
+		status.Status = renderJobDone
+	} else {
+		status.Status = renderJobFailed
+	}
+
+	return status, job.response, true
+}
+
+// expire forgets the jobs done for longer than the result TTL. It must be
+// called with mu held.
+func (rj *renderJobs) expire(now time.Time) {
+	for id, job := range rj.jobs {
+		if job.response != nil && now.Sub(job.finished) > rj.config.ResultTTL {
+			delete(rj.jobs, id)
+		}
+	}
+}
+
+// renderJobResponse records the response of a render job.
+type renderJobResponse struct {
+	header http.Header
+	code   int
+	body   bytes.Buffer
+}
+
+func newRenderJobResponse() *renderJobResponse {
+	return &renderJobResponse{header: make(http.Header)}
+}
+
+func (r *renderJobResponse) Header() http.Header {
+	return r.header
+}
+
+func (r *renderJobResponse) WriteHeader(code int) {
+	if r.code == 0 {
+		r.code = code
+	}
+}
+
+func (r *renderJobResponse) Write(b []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(b)
+}
+
+// writeTo replays the recorded response on w.
+func (r *renderJobResponse) writeTo(w http.ResponseWriter) error {
+	for k, v := range r.header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(r.code)
+	_, err := w.Write(r.body.Bytes())
+
+	return err
+}
+
+type renderJobKey struct{}
+
+// isRenderJob tells if ctx is the one of a render job.
+func isRenderJob(ctx context.Context) bool {
+	return ctx.Value(renderJobKey{}) != nil
+}
+
+// renderAsyncHandler starts a render job with the parameters of /render, and
+// answers with its id.
+func (app *App) renderAsyncHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
+	t0 := time.Now()
+	ctx := r.Context()
+	span := trace.SpanFromContext(ctx)
+	uuid := util.GetUUID(ctx)
+
+	toLog := carbonapipb.NewAccessLogDetails(r, "render_async", &app.config)
+	logAsError := false
+	defer func() {
+		app.deferredAccessLogging(logger, r, &toLog, t0, logAsError)
+	}()
+
+	// The form has to be read before the request is done
+	if err := r.ParseForm(); err != nil {
+		writeError(uuid, r, w, http.StatusBadRequest, err.Error(), "", &toLog, span)
+		logAsError = true
+		return
+	}
+
+	job, err := app.renderJobs.add(t0)
+	if err != nil {
+		writeError(uuid, r, w, http.StatusServiceUnavailable, err.Error(), "", &toLog, span)
+		logAsError = true
+		return
+	}
+
+	jobCtx, cancel := context.WithTimeout(
+		context.WithValue(detachedContext{ctx}, renderJobKey{}, job.id),
+		app.config.AsyncRender.Timeout)
+	inFlight := app.inFlight.add(uuid, r.URL.Path, cancel)
+	jobReq := r.Clone(withInFlightRequest(jobCtx, inFlight))
+	go func() {
+		defer cancel()
+		defer app.inFlight.remove(inFlight)
+
+		response := newRenderJobResponse()
+		app.renderHandler(response, jobReq, logger)
+		app.renderJobs.finish(job, response, time.Now())
+	}()
+
+	status, _, _ := app.renderJobs.get(job.id, t0)
+	w.Header().Set("Location", "/render/status/"+job.id)
+	toLog.HttpCode = http.StatusAccepted
+	writeRenderJobStatus(w, http.StatusAccepted, status)
+}
+
+// renderStatusHandler answers with the status of a render job.
+func (app *App) renderStatusHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
+	id := mux.Vars(r)["id"]
+	status, _, ok := app.renderJobs.get(id, time.Now())
+	if !ok {
+		http.Error(w, "render job not found: "+id, http.StatusNotFound)
+		return
+	}
+
+	writeRenderJobStatus(w, http.StatusOK, status)
+}
+
+// renderResultHandler answers with the response of a render job once it is
+// done, and with its status while it is running.
+func (app *App) renderResultHandler(w http.ResponseWriter, r *http.Request, logger *zap.Logger) {
+	id := mux.Vars(r)["id"]
+	status, response, ok := app.renderJobs.get(id, time.Now())
+	if !ok {
+		http.Error(w, "render job not found: "+id, http.StatusNotFound)
+		return
+	}
+	if response == nil {
+		writeRenderJobStatus(w, http.StatusAccepted, status)
+		return
+	}
+
+	if err := response.writeTo(w); err != nil {
+		logger.Warn("error writing render job result",
+			zap.String("carbonapi_uuid", id),
+			zap.Error(err),
+		)
+	}
+}
+
+func writeRenderJobStatus(w http.ResponseWriter, code int, status renderJobStatus) {
+	b, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", contentTypeJSON)
+	w.WriteHeader(code)
+	w.Write(b)
+}
@@ -0,0 +1,58 @@
+package carbonapi
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+func TestRenderJobs(t *testing.T) {
+	jobs := newRenderJobs(cfg.AsyncRenderConfig{MaxJobs: 2, ResultTTL: time.Minute})
+	now := time.Now()
+
+	first, err := jobs.add(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.id) != 32 {
+		t.Errorf("expected a 128-bit hex id, got %q", first.id)
+	}
+	second, err := jobs.add(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.id == first.id {
+		t.Errorf("expected distinct ids, got %q twice", first.id)
+	}
+	if _, err := jobs.add(now); err != errTooManyRenderJobs {
+		t.Errorf("expected %v, got %v", errTooManyRenderJobs, err)
+	}
+
+	status, response, ok := jobs.get(first.id, now)
+	if !ok || response != nil || status.Status != renderJobRunning {
+		t.Errorf("unexpected status %+v of a running job", status)
+	}
+
+	failed := newRenderJobResponse()
+	failed.WriteHeader(http.StatusBadRequest)
+	jobs.finish(first, failed, now)
+	status, response, ok = jobs.get(first.id, now.Add(time.Minute))
+	if !ok || response != failed || status.Status != renderJobFailed || status.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status %+v of a failed job", status)
+	}
+
+	// the result of first expires, making room for third, while second,
+	// still running, is kept
+	later := now.Add(2 * time.Minute)
+	if _, _, ok := jobs.get(first.id, later); ok {
+		t.Error("expected the result of first to expire")
+	}
+	if _, _, ok := jobs.get(second.id, later); !ok {
+		t.Error("expected second to be kept while running")
+	}
+	if _, err := jobs.add(later); err != nil {
+		t.Error(err)
+	}
+}
@@ -23,13 +23,25 @@ func initHandlersInternal(app *App, logger *zap.Logger) http.Handler {
 
 	r.HandleFunc("/unblock-headers", httputil.TimeHandler(handlerlog.WithLogger(app.unblockHeaders, logger), app.bucketRequestTimes))
 
+	r.HandleFunc("/cancel-request", httputil.TimeHandler(handlerlog.WithLogger(app.cancelRequest, logger), app.bucketRequestTimes))
+
+	r.HandleFunc("/in-flight-requests", httputil.TimeHandler(handlerlog.WithLogger(app.inFlightRequestsHandler, logger), app.bucketRequestTimes))
+
+	r.HandleFunc("/invalidate-find-tree", httputil.TimeHandler(handlerlog.WithLogger(app.invalidateFindTree, logger), app.bucketRequestTimes))
+
 	r.HandleFunc("/debug/version", app.debugVersionHandler)
 
+	r.HandleFunc("/debug/functions", app.debugFunctionsHandler)
+
 	r.Handle("/debug/vars", expvar.Handler())
 	r.PathPrefix("/debug/pprof").HandlerFunc(pprof.Index)
 
 	r.Handle("/metrics", promhttp.Handler())
 
+	for _, p := range app.cachePeers {
+		r.Handle(p.Path(), p)
+	}
+
 	return routeMiddleware(r)
 }
 
@@ -40,16 +52,56 @@ func initHandlers(app *App, logger *zap.Logger) http.Handler {
 	r.Use(handlers.CORS())
 	r.Use(handlers.ProxyHeaders)
 	r.Use(util.UUIDHandler)
+	r.Use(app.cancellableHandler)
+	if app.config.Tenants.Enabled {
+		r.Use(app.tenantHandler)
+	}
 	r.Use(muxtrace.Middleware("carbonapi"))
 
 	r.HandleFunc("/render", httputil.TimeHandler(
 		app.validateRequest(app.renderHandler, "render", logger),
 		app.bucketRequestTimes))
 
+	r.HandleFunc("/render/batch", httputil.TimeHandler(
+		app.validateRequest(app.renderBatchHandler, "render_batch", logger),
+		app.bucketRequestTimes)).Methods("POST")
+
+	if app.config.AsyncRender.MaxJobs > 0 {
+		r.HandleFunc("/render/async", httputil.TimeHandler(
+			app.validateRequest(app.renderAsyncHandler, "render_async", logger),
+			app.bucketRequestTimes)).Methods("POST")
+
+		r.HandleFunc("/render/status/{id}", httputil.TimeHandler(
+			handlerlog.WithLogger(app.renderStatusHandler, logger),
+			app.bucketRequestTimes))
+
+		r.HandleFunc("/render/result/{id}", httputil.TimeHandler(
+			handlerlog.WithLogger(app.renderResultHandler, logger),
+			app.bucketRequestTimes))
+	}
+
+	if app.events != nil {
+		for path := range eventsPaths {
+			r.HandleFunc(path, httputil.TimeHandler(
+				app.validateRequest(app.eventsHandler, "events", logger),
+				app.bucketRequestTimes))
+		}
+	}
+
+	if app.promqlTemplate != nil {
+		r.HandleFunc("/api/v1/query_range", httputil.TimeHandler(
+			app.validateRequest(app.queryRangeHandler, "query_range", logger),
+			app.bucketRequestTimes)).Methods("GET", "POST")
+	}
+
 	r.HandleFunc("/metrics/find", httputil.TimeHandler(
 		app.validateRequest(app.findHandler, "find", logger),
 		app.bucketRequestTimes))
 
+	r.HandleFunc("/metrics/index.json", httputil.TimeHandler(
+		app.validateRequest(app.indexHandler, "index", logger),
+		app.bucketRequestTimes))
+
 	r.HandleFunc("/info", httputil.TimeHandler(
 		app.validateRequest(app.infoHandler, "info", logger),
 		app.bucketRequestTimes))
@@ -66,6 +118,10 @@ func initHandlers(app *App, logger *zap.Logger) http.Handler {
 		handlerlog.WithLogger(app.functionsHandler, logger),
 		app.bucketRequestTimes))
 
+	r.HandleFunc("/functions/{function}", httputil.TimeHandler(
+		handlerlog.WithLogger(app.functionsHandler, logger),
+		app.bucketRequestTimes))
+
 	r.HandleFunc("/tags/autoComplete/tags", httputil.TimeHandler(
 		handlerlog.WithLogger(app.tagsHandler, logger),
 		app.bucketRequestTimes))
@@ -78,7 +134,7 @@ func initHandlers(app *App, logger *zap.Logger) http.Handler {
 		handlerlog.WithLogger(app.usageHandler, logger),
 		app.bucketRequestTimes)
 
-	return routeMiddleware(r)
+	return routeMiddleware(app.apiVersionHandler(r))
 }
 
 // routeHelper formats the route using regex to accept optional trailing slash
@@ -90,3 +146,19 @@ func routeMiddleware(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// tenantHandler tells the backend the tenant of requests, for tenants to
+// share it fairly. The tenant is the one of the key of the request, so that
+// no one claims the weight of another tenant by naming it.
+func (app *App) tenantHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var key string
+		if app.config.Tenants.Header != "" {
+			key = r.Header.Get(app.config.Tenants.Header)
+		} else {
+			_, key, _ = r.BasicAuth()
+		}
+		tenant := app.config.Tenants.Keys[key]
+		next.ServeHTTP(w, r.WithContext(util.WithTenant(r.Context(), tenant)))
+	})
+}
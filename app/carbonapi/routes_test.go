@@ -4,6 +4,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/util"
 )
 
 //Note: All routes are already validated in the tests for app handlers
@@ -38,3 +41,72 @@ func testRoutingForPath(t *testing.T, path string) {
 		t.Errorf("Failed to route path: %s", path)
 	}
 }
+
+func TestTenantHandler(t *testing.T) {
+	keys := map[string]string{"secret": "alice", "grafana-key": "grafana"}
+	tests := []struct {
+		name   string
+		config cfg.TenantsConfig
+		want   string
+	}{
+		{"basic auth", cfg.TenantsConfig{Enabled: true, Keys: keys}, "alice"},
+		{"header", cfg.TenantsConfig{Enabled: true, Header: "X-Carbonapi-Tenant-Key", Keys: keys}, "grafana"},
+		{"unknown key", cfg.TenantsConfig{Enabled: true, Header: "X-Grafana-Org-Id", Keys: keys}, ""},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			app := &App{}
+			app.config.Tenants = tt.config
+
+			var got string
+			h := app.tenantHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = util.GetTenant(r.Context())
+			}))
+
+			req := httptest.NewRequest("GET", "/render", nil)
+			req.SetBasicAuth("alice", "secret")
+			req.Header.Set("X-Carbonapi-Tenant-Key", "grafana-key")
+			req.Header.Set("X-Grafana-Org-Id", "42")
+			h.ServeHTTP(httptest.NewRecorder(), req)
+
+			if got != tt.want {
+				t.Errorf("expected tenant %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAPIVersionHandler(t *testing.T) {
+	tests := []struct {
+		path        string
+		defaultV    int
+		wantPath    string
+		wantVersion apiVersion
+	}{
+		{"/render", 0, "/render", apiV1},
+		{"/render", 2, "/render", apiV2},
+		{"/v1/render", 2, "/render", apiV1},
+		{"/v2/metrics/find", 1, "/metrics/find", apiV2},
+		{"/v2", 1, "/", apiV2},
+		{"/v20/render", 1, "/v20/render", apiV1},
+	}
+
+	for _, tt := range tests {
+		app := &App{}
+		app.config.APIVersion = tt.defaultV
+
+		var gotPath string
+		var gotVersion apiVersion
+		h := app.apiVersionHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotPath = r.URL.Path
+			gotVersion = apiVersionFrom(r.Context())
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+
+		if gotPath != tt.wantPath || gotVersion != tt.wantVersion {
+			t.Errorf("%s: expected %s in v%d, got %s in v%d", tt.path, tt.wantPath, tt.wantVersion, gotPath, gotVersion)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package carbonapi
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// detachedContext keeps the values of its parent, e.g. the request UUID and
+// trace span, but neither its deadline nor its cancellation. Render jobs
+// outlive the requests that started them, and the calls shared between
+// requests outlive the one that happened to start them.
+type detachedContext struct {
+	context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) {
+	return time.Time{}, false
+}
+
+func (detachedContext) Done() <-chan struct{} {
+	return nil
+}
+
+func (detachedContext) Err() error {
+	return nil
+}
+
+// doShared calls fn once for all the callers of key in flight in g, and
+// returns its result and whether it was shared. fn runs on a context
+// detached from the one of the caller that started it, bounded by timeout if
+// positive, so that a caller giving up does not fail the others: each one
+// only waits for the result until its own ctx is done.
+func doShared(ctx context.Context, g *singleflight.Group, key string, timeout time.Duration,
+	fn func(ctx context.Context) (interface{}, error)) (interface{}, bool, error) {
+
+	ch := g.DoChan(key, func() (interface{}, error) {
+		callCtx := context.Context(detachedContext{ctx})
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			callCtx, cancel = context.WithTimeout(callCtx, timeout)
+			defer cancel()
+		}
+
+		return fn(callCtx)
+	})
+
+	select {
+	case r := <-ch:
+		return r.Val, r.Shared, r.Err
+	case <-ctx.Done():
+		return nil, false, ctx.Err()
+	}
+}
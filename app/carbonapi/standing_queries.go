@@ -0,0 +1,161 @@
+package carbonapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.opentelemetry.io/otel/api/trace"
+	"go.uber.org/zap"
+)
+
+// standingQuery is a render request evaluated on a schedule, with its last
+// successful result.
+type standingQuery struct {
+	config cfg.StandingQueryConfig
+	// form holds the parameters of the request, without cache busters.
+	form url.Values
+
+	mu      sync.RWMutex
+	body    []byte
+	updated time.Time // zero until the first success
+}
+
+// newStandingQueries checks the configured standing queries and returns them
+// by the cache key of their render requests.
+func newStandingQueries(configs []cfg.StandingQueryConfig) (map[string]*standingQuery, error) {
+	queries := make(map[string]*standingQuery, len(configs))
+	names := make(map[string]bool, len(configs))
+	for _, config := range configs {
+		if config.Name == "" {
+			return nil, errors.New("standing query without a name")
+		}
+		if names[config.Name] {
+			return nil, fmt.Errorf("duplicate standing query %s", config.Name)
+		}
+		names[config.Name] = true
+
+		if config.Interval <= 0 {
+			return nil, fmt.Errorf("standing query %s: interval must be positive", config.Name)
+		}
+		form, err := url.ParseQuery(config.Query)
+		if err != nil {
+			return nil, fmt.Errorf("standing query %s: invalid query: %w", config.Name, err)
+		}
+		if len(form["target"]) == 0 {
+			return nil, fmt.Errorf("standing query %s: no target", config.Name)
+		}
+
+		key := renderCacheKey(form)
+		if other, ok := queries[key]; ok {
+			return nil, fmt.Errorf("standing query %s: same query as %s", config.Name, other.config.Name)
+		}
+		queries[key] = &standingQuery{
+			config: config,
+			form:   form,
+		}
+	}
+
+	return queries, nil
+}
+
+// result returns the last result of the query and when it was evaluated.
+func (sq *standingQuery) result() ([]byte, time.Time) {
+	sq.mu.RLock()
+	defer sq.mu.RUnlock()
+
+	return sq.body, sq.updated
+}
+
+func (sq *standingQuery) setResult(body []byte, updated time.Time) {
+	sq.mu.Lock()
+	defer sq.mu.Unlock()
+
+	sq.body = body
+	sq.updated = updated
+}
+
+type standingQueryKey struct{}
+
+// isStandingQuery tells if ctx is the one of a standing query evaluation.
+func isStandingQuery(ctx context.Context) bool {
+	return ctx.Value(standingQueryKey{}) != nil
+}
+
+// runStandingQueries evaluates each standing query every interval, for the
+// lifetime of the app.
+func (app *App) runStandingQueries(logger *zap.Logger) {
+	for _, sq := range app.standingQueries {
+		go func(sq *standingQuery) {
+			app.evalStandingQuery(sq, logger)
+			ticker := time.NewTicker(sq.config.Interval)
+			for range ticker.C {
+				app.evalStandingQuery(sq, logger)
+			}
+		}(sq)
+	}
+}
+
+// evalStandingQuery runs the render request of sq, and keeps its response if
+// it succeeds. A failure keeps the previous result.
+func (app *App) evalStandingQuery(sq *standingQuery, logger *zap.Logger) {
+	ctx := context.WithValue(util.WithUUID(context.Background()), standingQueryKey{}, sq.config.Name)
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, "/render?"+sq.form.Encode(), nil)
+	if err != nil {
+		logger.Error("failed to make standing query request",
+			zap.String("standing_query", sq.config.Name),
+			zap.Error(err),
+		)
+		return
+	}
+
+	response := newRenderJobResponse()
+	app.renderHandler(response, r, logger)
+	if response.code != http.StatusOK {
+		app.prometheusMetrics.StandingQueryFailures.WithLabelValues(sq.config.Name).Inc()
+		logger.Warn("standing query failed",
+			zap.String("standing_query", sq.config.Name),
+			zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+			zap.Int("http_code", response.code),
+		)
+		return
+	}
+
+	now := time.Now()
+	sq.setResult(response.body.Bytes(), now)
+	app.prometheusMetrics.StandingQueryLastSuccess.WithLabelValues(sq.config.Name).Set(float64(now.Unix()))
+}
+
+// serveStandingQuery answers a render request with the last result of sq. The
+// request is never evaluated, even before the first result.
+func (app *App) serveStandingQuery(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	sq *standingQuery, form renderForm, toLog *carbonapipb.AccessLogDetails) error {
+	body, updated := sq.result()
+	if updated.IsZero() {
+		err := fmt.Errorf("standing query %s has no result yet", sq.config.Name)
+		w.Header().Set("Retry-After", strconv.Itoa(int(sq.config.Interval.Seconds())+1))
+		writeError(util.GetUUID(ctx), r, w, http.StatusServiceUnavailable, err.Error(), form.format, toLog, trace.SpanFromContext(ctx))
+		return err
+	}
+
+	toLog.FromCache = true
+	toLog.CarbonzipperResponseSizeBytes = 0
+	toLog.CarbonapiResponseSizeBytes = int64(len(body))
+	w.Header().Set("Age", strconv.Itoa(int(time.Since(updated).Seconds())))
+	if err := writeResponse(ctx, w, body, form.format, form.jsonp); err != nil {
+		toLog.HttpCode = 499
+		return err
+	}
+	toLog.HttpCode = http.StatusOK
+
+	return nil
+}
@@ -0,0 +1,90 @@
+package carbonapi
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+func TestStandingQuery(t *testing.T) {
+	queries, err := newStandingQueries([]cfg.StandingQueryConfig{{
+		Name:     "foo",
+		Query:    "target=foo.bar&from=-10minutes&format=json",
+		Interval: time.Minute,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(queries map[string]*standingQuery) {
+		testApp.standingQueries = queries
+	}(testApp.standingQueries)
+	testApp.standingQueries = queries
+
+	request := func() *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		testRouter.ServeHTTP(rr, httptest.NewRequest("GET", "/render?target=foo.bar&from=-10minutes&format=json&_salt=1", nil))
+		return rr
+	}
+
+	if rr := request(); rr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("HttpStatusCode should be 503 before the first evaluation, got %d", rr.Code)
+	}
+
+	for _, sq := range queries {
+		testApp.evalStandingQuery(sq, zap.NewNop())
+	}
+
+	// The result is served without asking the backend
+	defer func(b backend.Backend) {
+		testApp.backend = b
+	}(testApp.backend)
+	testApp.backend = mock.New(mock.Config{
+		Find: find,
+		Info: info,
+		Render: func(context.Context, types.RenderRequest) ([]types.Metric, error) {
+			return nil, errors.New("backend is down")
+		},
+	})
+
+	rr := request()
+	if rr.Code != http.StatusOK {
+		t.Fatalf("HttpStatusCode should be 200 OK, got %d", rr.Code)
+	}
+	if !strings.Contains(rr.Body.String(), "foo.bar") {
+		t.Errorf("unexpected body %s", rr.Body.String())
+	}
+	if rr.Header().Get("Age") == "" {
+		t.Error("expected the age of the result")
+	}
+}
+
+func TestNewStandingQueries(t *testing.T) {
+	valid := cfg.StandingQueryConfig{Name: "foo", Query: "target=foo", Interval: time.Minute}
+	tests := map[string][]cfg.StandingQueryConfig{
+		"no name":        {{Query: "target=foo", Interval: time.Minute}},
+		"no target":      {{Name: "foo", Query: "from=-1h", Interval: time.Minute}},
+		"no interval":    {{Name: "foo", Query: "target=foo"}},
+		"invalid query":  {{Name: "foo", Query: "target=%zz", Interval: time.Minute}},
+		"duplicate name": {valid, {Name: "foo", Query: "target=bar", Interval: time.Minute}},
+		"same query":     {valid, {Name: "bar", Query: "target=foo&_salt=1", Interval: time.Minute}},
+	}
+
+	for name, configs := range tests {
+		t.Run(name, func(t *testing.T) {
+			if _, err := newStandingQueries(configs); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
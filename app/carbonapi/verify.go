@@ -0,0 +1,225 @@
+package carbonapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+
+	"go.uber.org/zap"
+)
+
+// verifier compares the results of render requests to those of a reference
+// graphite-web.
+type verifier struct {
+	config cfg.VerifyConfig
+	client *http.Client
+	// slots limits the requests verified at once.
+	slots chan struct{}
+}
+
+// newVerifier returns nil if verification is disabled.
+func newVerifier(config cfg.VerifyConfig) *verifier {
+	if config.URL == "" || config.SampleRate <= 0 {
+		return nil
+	}
+
+	slots := config.MaxConcurrent
+	if slots <= 0 {
+		slots = 1
+	}
+
+	return &verifier{
+		config: config,
+		client: &http.Client{Timeout: config.Timeout},
+		slots:  make(chan struct{}, slots),
+	}
+}
+
+// sample reports whether a request is to be verified.
+func (v *verifier) sample() bool {
+	return v != nil && rand.Float64() < v.config.SampleRate
+}
+
+// verifiedTarget is a target of a render request, with its results.
+type verifiedTarget struct {
+	target  string
+	exp     parser.Expr
+	results []*types.MetricData
+}
+
+// referenceSeries is a series in the JSON of graphite-web. Values are kept
+// as numbers, for graphite-web writes infinite values as 1e9999, and null
+// values are empty.
+type referenceSeries struct {
+	Target     string           `json:"target"`
+	Datapoints [][2]json.Number `json:"datapoints"`
+}
+
+// verify compares the results of the targets of a request, evaluated from
+// until, to those of the reference in the background, unless as many
+// requests as allowed are being verified already.
+func (app *App) verify(targets []verifiedTarget, from, until int32, logger *zap.Logger) {
+	v := app.verifier
+	select {
+	case v.slots <- struct{}{}:
+	default:
+		app.prometheusMetrics.VerifyTargets.WithLabelValues("skipped").Add(float64(len(targets)))
+		return
+	}
+
+	go func() {
+		defer func() { <-v.slots }()
+		for _, t := range targets {
+			app.verifyTarget(v, t, from, until, logger)
+		}
+	}()
+}
+
+func (app *App) verifyTarget(v *verifier, t verifiedTarget, from, until int32, logger *zap.Logger) {
+	reference, err := v.render(t.target, from, until)
+	if err != nil {
+		app.prometheusMetrics.VerifyTargets.WithLabelValues("failed").Inc()
+		logger.Warn("failed to render target on the reference",
+			zap.String("target", t.target),
+			zap.Error(err),
+		)
+		return
+	}
+
+	divergence := compareRender(t.results, reference, v.config.Tolerance)
+	if divergence == "" {
+		app.prometheusMetrics.VerifyTargets.WithLabelValues("match").Inc()
+		return
+	}
+
+	app.prometheusMetrics.VerifyTargets.WithLabelValues("divergent").Inc()
+	for _, f := range calledFunctions(t.exp) {
+		app.prometheusMetrics.VerifyDivergences.WithLabelValues(f).Inc()
+	}
+	logger.Warn("target diverges from the reference",
+		zap.String("target", t.target),
+		zap.Int32("from", from),
+		zap.Int32("until", until),
+		zap.String("divergence", divergence),
+	)
+}
+
+// render renders target from until on the reference, in JSON.
+func (v *verifier) render(target string, from, until int32) ([]referenceSeries, error) {
+	u := strings.TrimSuffix(v.config.URL, "/") + "/render?" + url.Values{
+		"target": {target},
+		"from":   {strconv.Itoa(int(from))},
+		"until":  {strconv.Itoa(int(until))},
+		"format": {jsonFormat},
+	}.Encode()
+
+	resp, err := v.client.Get(u)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("reference answered %s", resp.Status)
+	}
+
+	var series []referenceSeries
+	if err := json.NewDecoder(resp.Body).Decode(&series); err != nil {
+		return nil, err
+	}
+
+	return series, nil
+}
+
+// compareRender returns how the results diverge from the reference, or ""
+// if they don't: they must have the same series, with points at the same
+// times, known at the same times, and values within tolerance of each other
+// relative to the larger. Infinite values are not known, as carbonapi
+// writes them as null.
+func compareRender(results []*types.MetricData, reference []referenceSeries, tolerance float64) string {
+	if len(results) != len(reference) {
+		return fmt.Sprintf("%d series, the reference has %d", len(results), len(reference))
+	}
+
+	byName := make(map[string]referenceSeries, len(reference))
+	for _, s := range reference {
+		byName[s.Target] = s
+	}
+
+	for _, r := range results {
+		ref, ok := byName[r.Name]
+		if !ok {
+			return fmt.Sprintf("series %s is not in the reference", r.Name)
+		}
+		if len(r.Values) != len(ref.Datapoints) {
+			return fmt.Sprintf("series %s has %d points, the reference has %d", r.Name, len(r.Values), len(ref.Datapoints))
+		}
+
+		for i, v := range r.Values {
+			t := int64(r.StartTime) + int64(i)*int64(r.StepTime)
+			point := ref.Datapoints[i]
+			if refT, err := point[1].Int64(); err != nil || refT != t {
+				return fmt.Sprintf("series %s has a point at %d, the reference at %s", r.Name, t, point[1])
+			}
+
+			known := !r.IsAbsent.Get(i) && !math.IsNaN(v) && !math.IsInf(v, 0)
+			refV, err := strconv.ParseFloat(string(point[0]), 64)
+			refKnown := err == nil && !math.IsNaN(refV)
+			if known == refKnown && (!known || math.Abs(v-refV) <= tolerance*math.Max(math.Abs(v), math.Abs(refV))) {
+				continue
+			}
+
+			value, refValue := "null", "null"
+			if known {
+				value = strconv.FormatFloat(v, 'g', -1, 64)
+			}
+			if refKnown {
+				refValue = string(point[0])
+			}
+			return fmt.Sprintf("series %s is %s at %d, the reference is %s", r.Name, value, t, refValue)
+		}
+	}
+
+	return ""
+}
+
+// calledFunctions returns the names of the functions called in exp, or none
+// for a series.
+func calledFunctions(exp parser.Expr) []string {
+	seen := make(map[string]bool)
+	var walk func(e parser.Expr)
+	walk = func(e parser.Expr) {
+		if !e.IsFunc() {
+			return
+		}
+		seen[e.Target()] = true
+		for _, arg := range e.Args() {
+			walk(arg)
+		}
+		for _, arg := range e.NamedArgs() {
+			walk(arg)
+		}
+	}
+	walk(exp)
+
+	if len(seen) == 0 {
+		return []string{"none"}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
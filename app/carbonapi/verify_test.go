@@ -0,0 +1,136 @@
+package carbonapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestCompareRender(t *testing.T) {
+	results := []*types.MetricData{
+		types.MakeMetricData("foo", []float64{1, math.NaN(), math.Inf(1), 1000}, 60, 600),
+	}
+
+	tests := map[string]struct {
+		reference string
+		expected  string
+	}{
+		"same": {
+			`[{"target":"foo","datapoints":[[1.0,600],[null,660],[1e9999,720],[1000.0000001,780]]}]`,
+			"",
+		},
+		"different value": {
+			`[{"target":"foo","datapoints":[[1.0,600],[null,660],[null,720],[1001.0,780]]}]`,
+			"series foo is 1000 at 780, the reference is 1001.0",
+		},
+		"known value": {
+			`[{"target":"foo","datapoints":[[1.0,600],[2.0,660],[null,720],[1000.0,780]]}]`,
+			"series foo is null at 660, the reference is 2.0",
+		},
+		"different times": {
+			`[{"target":"foo","datapoints":[[1.0,660],[null,720],[null,780],[1000.0,840]]}]`,
+			"series foo has a point at 600, the reference at 660",
+		},
+		"different name": {
+			`[{"target":"bar","datapoints":[]}]`,
+			"series foo is not in the reference",
+		},
+		"no series": {
+			`[]`,
+			"1 series, the reference has 0",
+		},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			var reference []referenceSeries
+			if err := json.Unmarshal([]byte(tt.reference), &reference); err != nil {
+				t.Fatal(err)
+			}
+			if got := compareRender(results, reference, 1e-6); got != tt.expected {
+				t.Errorf("expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestCalledFunctions(t *testing.T) {
+	exp, _, err := parser.ParseExpr("sumSeries(scale(foo.*,2),scale(bar,3),offset(baz,1))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(calledFunctions(exp), ","); got != "offset,scale,sumSeries" {
+		t.Errorf("expected offset,scale,sumSeries, got %s", got)
+	}
+
+	exp, _, _ = parser.ParseExpr("foo.bar")
+	if got := strings.Join(calledFunctions(exp), ","); got != "none" {
+		t.Errorf("expected none, got %s", got)
+	}
+}
+
+func counterValue(t *testing.T, c interface{ Write(*dto.Metric) error }) float64 {
+	t.Helper()
+	var m dto.Metric
+	if err := c.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func TestVerify(t *testing.T) {
+	// the reference knows foo.bar as the mock backend does, and nothing else
+	reference := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `[{"target":%q,"datapoints":[[null,1510913280],[1510913759,1510913340],[1510913818.0,1510913400]]}]`,
+			r.FormValue("target"))
+	}))
+	defer reference.Close()
+
+	defer func(v *verifier) { testApp.verifier = v }(testApp.verifier)
+	testApp.verifier = newVerifier(cfg.VerifyConfig{
+		URL:           reference.URL,
+		SampleRate:    1,
+		Tolerance:     1e-6,
+		Timeout:       time.Second,
+		MaxConcurrent: 1,
+	})
+	testApp.backend = mock.New(mock.Config{
+		Find:   find,
+		Info:   info,
+		Render: render,
+	})
+
+	req := httptest.NewRequest("GET", "/render?target=foo.bar&target=scale(foo.bar,2)&from=-10minutes&format=json&noCache=1", nil)
+	rr := httptest.NewRecorder()
+	testRouter.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("Expected status code %d, got %d", http.StatusOK, rr.Code)
+	}
+
+	metrics := testApp.prometheusMetrics
+	for deadline := time.Now().Add(5 * time.Second); counterValue(t, metrics.VerifyTargets.WithLabelValues("divergent")) == 0; {
+		if time.Now().After(deadline) {
+			t.Fatal("targets were not verified")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if matched := counterValue(t, metrics.VerifyTargets.WithLabelValues("match")); matched != 1 {
+		t.Errorf("expected foo.bar to match the reference, got %v matches", matched)
+	}
+	if scale := counterValue(t, metrics.VerifyDivergences.WithLabelValues("scale")); scale != 1 {
+		t.Errorf("expected a divergence of scale, got %v", scale)
+	}
+}
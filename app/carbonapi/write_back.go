@@ -0,0 +1,176 @@
+package carbonapi
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/bookingcom/carbonapi/carbonapipb"
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	"github.com/bookingcom/carbonapi/util"
+
+	"go.opentelemetry.io/otel/api/trace"
+	"go.uber.org/zap"
+)
+
+// checkWriteBack checks the expressions written back to carbon.
+func checkWriteBack(config cfg.WriteBackConfig) error {
+	if config.Relay == "" {
+		return nil
+	}
+
+	names := make(map[string]bool, len(config.Series))
+	for _, series := range config.Series {
+		if series.Name == "" {
+			return errors.New("write back series without a name")
+		}
+		if names[series.Name] {
+			return fmt.Errorf("duplicate write back series %s", series.Name)
+		}
+		names[series.Name] = true
+
+		if series.Interval <= 0 {
+			return fmt.Errorf("write back series %s: interval must be positive", series.Name)
+		}
+		if _, e, err := parser.ParseExpr(series.Target); err != nil || e != "" {
+			return fmt.Errorf("write back series %s: %s", series.Name, buildParseErrorString(series.Target, e, err))
+		}
+	}
+
+	return nil
+}
+
+// runWriteBack evaluates each write back series every interval and writes
+// its results to the relay, for the lifetime of the app.
+func (app *App) runWriteBack(logger *zap.Logger) {
+	if app.config.WriteBack.Relay == "" {
+		return
+	}
+
+	for _, series := range app.config.WriteBack.Series {
+		go func(series cfg.WriteBackSeries) {
+			ticker := time.NewTicker(series.Interval)
+			for range ticker.C {
+				app.writeBack(series, logger)
+			}
+		}(series)
+	}
+}
+
+// writeBack evaluates series and writes its known points to the relay. The
+// whole range is written every time, carbon keeps the last value written for
+// a timestamp.
+func (app *App) writeBack(series cfg.WriteBackSeries, logger *zap.Logger) {
+	ctx, cancel := context.WithTimeout(util.WithUUID(context.Background()), app.config.Timeouts.Global)
+	defer cancel()
+
+	from := series.From
+	if from == "" {
+		from = "-" + strconv.Itoa(int(2*series.Interval/time.Second)) + "s"
+	}
+	q := renderBatchQuery{
+		ID:     series.Name,
+		Target: series.Target,
+		From:   from,
+	}
+
+	ticket := app.admission.newTicket()
+	defer ticket.release()
+	var toLog carbonapipb.AccessLogDetails
+	partiallyFailed := false
+	data, err := app.renderBatchQuery(ctx, q, false, ticket, &toLog, logger, &partiallyFailed, trace.SpanFromContext(ctx))
+
+	points := 0
+	if err == nil {
+		points, err = writeSeries(app.config.WriteBack, series.Prefix, data)
+	}
+	if err != nil {
+		app.prometheusMetrics.WriteBackFailures.WithLabelValues(series.Name).Inc()
+		logger.Warn("failed to write back series",
+			zap.String("write_back_series", series.Name),
+			zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+			zap.Error(err),
+		)
+		return
+	}
+
+	app.prometheusMetrics.WriteBackPoints.WithLabelValues(series.Name).Add(float64(points))
+	app.prometheusMetrics.WriteBackLastSuccess.WithLabelValues(series.Name).Set(float64(time.Now().Unix()))
+}
+
+// writeSeries writes the known points of data to the relay in the plaintext
+// protocol, and returns how many there were.
+func writeSeries(config cfg.WriteBackConfig, prefix string, data []*types.MetricData) (int, error) {
+	conn, err := net.DialTimeout("tcp", config.Relay, config.Timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := conn.SetDeadline(time.Now().Add(config.Timeout)); err != nil {
+		return 0, err
+	}
+
+	w := bufio.NewWriter(conn)
+	points := 0
+	for _, m := range data {
+		name := writeBackName(prefix, m.Name)
+		for i, v := range m.Values {
+			if m.IsAbsent.Get(i) || math.IsNaN(v) {
+				continue
+			}
+			ts := m.StartTime + int32(i)*m.StepTime
+			if _, err := fmt.Fprintf(w, "%s %s %d\n", name, strconv.FormatFloat(v, 'f', -1, 64), ts); err != nil {
+				return points, err
+			}
+			points++
+		}
+	}
+
+	return points, w.Flush()
+}
+
+// writeBackName is the metric name of a series written back. Each run of
+// characters that are not valid in graphite paths, e.g. the whitespace that
+// would split the line of the plaintext protocol or the parentheses, commas
+// and globs of a target, is replaced by an underscore.
+func writeBackName(prefix, name string) string {
+	if prefix != "" {
+		name = prefix + "." + name
+	}
+
+	var b strings.Builder
+	invalid := false
+	for _, c := range name {
+		if validPathChar(c) {
+			b.WriteRune(c)
+			invalid = false
+			continue
+		}
+		if !invalid {
+			b.WriteByte('_')
+			invalid = true
+		}
+	}
+
+	return strings.Trim(b.String(), "_")
+}
+
+// validPathChar tells if c is valid in a graphite path as is: letters,
+// digits, the dots between nodes and a few punctuation marks that are neither
+// whitespace nor part of the syntax of targets and globs.
+func validPathChar(c rune) bool {
+	if unicode.IsLetter(c) || unicode.IsDigit(c) {
+		return true
+	}
+
+	return strings.ContainsRune(".-_:#@+%~=", c)
+}
@@ -0,0 +1,107 @@
+package carbonapi
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+
+	"go.uber.org/zap"
+)
+
+func TestWriteBack(t *testing.T) {
+	relay, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer relay.Close()
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := relay.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := ioutil.ReadAll(conn)
+		received <- string(b)
+	}()
+
+	defer func(config cfg.WriteBackConfig, b backend.Backend) {
+		testApp.config.WriteBack = config
+		testApp.backend = b
+	}(testApp.config.WriteBack, testApp.backend)
+	testApp.config.WriteBack = cfg.WriteBackConfig{
+		Relay:   relay.Addr().String(),
+		Timeout: time.Second,
+	}
+	testApp.backend = mock.New(mock.Config{
+		Find:   find,
+		Info:   info,
+		Render: render,
+	})
+
+	testApp.writeBack(cfg.WriteBackSeries{
+		Name:     "foo",
+		Target:   "foo.bar",
+		Prefix:   "derived",
+		Interval: time.Minute,
+	}, zap.NewNop())
+
+	want := "derived.foo.bar 1510913759 1510913340\nderived.foo.bar 1510913818 1510913400\n"
+	select {
+	case got := <-received:
+		if got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("nothing was written back")
+	}
+}
+
+func TestCheckWriteBack(t *testing.T) {
+	valid := cfg.WriteBackSeries{Name: "foo", Target: "foo.bar", Interval: time.Minute}
+	tests := map[string][]cfg.WriteBackSeries{
+		"no name":        {{Target: "foo.bar", Interval: time.Minute}},
+		"no interval":    {{Name: "foo", Target: "foo.bar"}},
+		"invalid target": {{Name: "foo", Target: "sum(foo.bar", Interval: time.Minute}},
+		"duplicate name": {valid, valid},
+	}
+
+	for name, series := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := checkWriteBack(cfg.WriteBackConfig{Relay: "localhost:2003", Series: series}); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+
+	if err := checkWriteBack(cfg.WriteBackConfig{Series: tests["no name"]}); err != nil {
+		t.Errorf("expected no check without a relay, got %v", err)
+	}
+}
+
+func TestWriteBackName(t *testing.T) {
+	tests := []struct {
+		prefix, name string
+		want         string
+	}{
+		{"", "web.requests", "web.requests"},
+		{"derived", "web.requests", "derived.web.requests"},
+		{"", "web requests\tper\nsecond", "web_requests_per_second"},
+		{"derived", "sumSeries(web.*.requests)", "derived.sumSeries_web._.requests"},
+		{"", "asPercent(web.{a,b}.errors, web.[ab].requests)", "asPercent_web._a_b_.errors_web._ab_.requests"},
+		{"", "web.host-1.cpu:usage@50%", "web.host-1.cpu:usage@50%"},
+		{"", "web.caf\u00e9.requests", "web.caf\u00e9.requests"},
+	}
+
+	for _, tt := range tests {
+		if got := writeBackName(tt.prefix, tt.name); got != tt.want {
+			t.Errorf("writeBackName(%q, %q): expected %q, got %q", tt.prefix, tt.name, tt.want, got)
+		}
+	}
+}
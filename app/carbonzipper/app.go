@@ -1,7 +1,7 @@
 package zipper
 
 import (
-	"context"
+	"errors"
 	"expvar"
 	"fmt"
 	"log"
@@ -10,6 +10,7 @@ import (
 	"os"
 	"runtime"
 	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -18,9 +19,16 @@ import (
 	"github.com/bookingcom/carbonapi/cfg"
 	"github.com/bookingcom/carbonapi/mstats"
 	"github.com/bookingcom/carbonapi/pkg/backend"
+	bgrpc "github.com/bookingcom/carbonapi/pkg/backend/grpc"
+	binfluxdb "github.com/bookingcom/carbonapi/pkg/backend/influxdb"
 	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
+	bopentsdb "github.com/bookingcom/carbonapi/pkg/backend/opentsdb"
+	brewrite "github.com/bookingcom/carbonapi/pkg/backend/rewrite"
+	brrd "github.com/bookingcom/carbonapi/pkg/backend/rrd"
+	bwhisper "github.com/bookingcom/carbonapi/pkg/backend/whisper"
+	"github.com/bookingcom/carbonapi/pkg/promql"
 	"github.com/bookingcom/carbonapi/pkg/trace"
-	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/pickle"
 	"github.com/bookingcom/carbonapi/util"
 
 	"github.com/dgryski/httputil"
@@ -37,26 +45,118 @@ var BuildVersion string
 type App struct {
 	config              cfg.Zipper
 	prometheusMetrics   *PrometheusMetrics
-	backends            []backend.Backend
 	topLevelDomainCache *expirecache.Cache
+	logger              *zap.Logger
+
+	// backendsMu guards backends, routes, ring, namespaces and the backend settings of config,
+	// which are replaced when the backends are reloaded. They are written
+	// with probeMu held too, so that probes can read them under probeMu.
+	backendsMu sync.RWMutex
+	backends   []backend.Backend
+	routes     []route
+	ring       *hashRing
+	namespaces map[string]namespace
+	// configFile is where the backends are reloaded from.
+	configFile string
+	// remoteConfig is where the backends are reloaded from instead, if
+	// set.
+	remoteConfig *remoteConfig
+
+	// probeMu serializes TLD probes and guards backendTLDs, the domains last
+	// seen on each backend, indexed like backends.
+	probeMu     sync.Mutex
+	backendTLDs [][]string
+	// tldLastRefresh is the unix time of the last completed probe.
+	tldLastRefresh int64
+
+	// remoteReadTemplate maps Prometheus series to metrics, nil if remote
+	// read is disabled.
+	remoteReadTemplate promql.PathTemplate
 }
 
 // New inits backends and makes a new copy of the app. Does not run the app
 func New(config cfg.Zipper, logger *zap.Logger, buildVersion string) (*App, error) {
 	BuildVersion = buildVersion
-	bs, err := initBackends(config, logger)
+	prometheusMetrics := NewPrometheusMetrics(config)
+	if config.Discovery.Type != "" && config.RemoteConfig.URL != "" {
+		err := errors.New("both discovery and a remote config are set")
+		logger.Fatal("Failed to initialize backends",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	config, err := discoverBackends(config)
+	if err != nil {
+		logger.Fatal("Failed to discover backends",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	remoteConfig, err := newRemoteConfig(config.RemoteConfig)
+	if err != nil {
+		logger.Fatal("Failed to initialize the remote config",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	config = fetchRemoteConfig(config, remoteConfig, logger)
+	bs, err := initBackends(config, config.GetBackends(), logger, prometheusMetrics)
 	if err != nil {
 		logger.Fatal("Failed to initialize backends",
 			zap.Error(err),
 		)
 		return nil, err
 	}
+	routes, err := newRoutes(config, bs)
+	if err != nil {
+		logger.Fatal("Failed to initialize routing",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	ring, err := newHashRing(config, bs)
+	if err != nil {
+		logger.Fatal("Failed to initialize hash routing",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	namespaces, err := newNamespaces(config, bs)
+	if err != nil {
+		logger.Fatal("Failed to initialize routing namespaces",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	findPickle := pickle.FindEncoder{Protocol: config.Pickle.Protocol, MaxSize: config.Pickle.MaxFindSize}
+	if err := findPickle.Validate(); err != nil {
+		logger.Fatal("Failed to initialize the pickle encoding",
+			zap.Error(err),
+		)
+		return nil, err
+	}
+	var remoteReadTemplate promql.PathTemplate
+	if config.RemoteRead.PathTemplate != "" {
+		remoteReadTemplate, err = promql.ParsePathTemplate(config.RemoteRead.PathTemplate)
+		if err != nil {
+			logger.Fatal("Failed to initialize remote read",
+				zap.Error(err),
+			)
+			return nil, err
+		}
+	}
 
 	app := App{
 		config:              config,
-		prometheusMetrics:   NewPrometheusMetrics(config),
+		prometheusMetrics:   prometheusMetrics,
 		backends:            bs,
 		topLevelDomainCache: expirecache.New(0),
+		logger:              logger,
+		routes:              routes,
+		ring:                ring,
+		namespaces:          namespaces,
+		remoteReadTemplate:  remoteReadTemplate,
+		remoteConfig:        remoteConfig,
 	}
 	return &app, nil
 }
@@ -104,6 +204,15 @@ func (app *App) Start(logger *zap.Logger) func() {
 	}
 
 	go app.probeTopLevelDomains()
+	if app.configFile != "" || app.remoteConfig != nil {
+		go app.reloadBackendsOnSignal()
+	}
+	if app.remoteConfig != nil {
+		go app.watchRemoteConfig()
+	}
+	if app.config.Discovery.Type != "" {
+		go app.watchBackends()
+	}
 	metricsServer := metricsServer(app)
 
 	gracehttp.SetLogger(zap.NewStdLog(logger))
@@ -122,42 +231,6 @@ func (app *App) Start(logger *zap.Logger) func() {
 	return flush
 }
 
-func (app *App) doProbe() {
-	topLevelDomainCache := make(map[string][]*backend.Backend)
-	for i := 0; i < len(app.backends); i++ {
-		topLevelDomains := getTopLevelDomains(app.backends[i])
-		for _, topLevelDomain := range topLevelDomains {
-			topLevelDomainCache[topLevelDomain] = append(topLevelDomainCache[topLevelDomain], &app.backends[i])
-		}
-	}
-	app.topLevelDomainCache.Set("tlds", topLevelDomainCache, 0, 2*app.config.InternalRoutingCache)
-}
-
-// Returns the backend's top-level domains.
-func getTopLevelDomains(backend backend.Backend) []string {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	request := types.NewFindRequest("*")
-	matches, err := backend.Find(ctx, request)
-	if err != nil {
-		return nil
-	}
-	var paths []string
-	for _, m := range matches.Matches {
-		paths = append(paths, m.Path)
-	}
-	return paths
-}
-
-func (app *App) probeTopLevelDomains() {
-	app.doProbe()
-	probeTicker := time.NewTicker(time.Duration(app.config.InternalRoutingCache) * time.Second)
-	for range probeTicker.C {
-		app.doProbe()
-	}
-}
-
 func (app *App) bucketRequestTimes(req *http.Request, t time.Duration) {
 	ms := t.Nanoseconds() / int64(time.Millisecond)
 
@@ -181,11 +254,14 @@ func (app *App) bucketRequestTimes(req *http.Request, t time.Duration) {
 	}
 }
 
-func initBackends(config cfg.Zipper, logger *zap.Logger) ([]backend.Backend, error) {
+// initBackends makes the backends of hosts, which must be in config.
+func initBackends(config cfg.Zipper, hosts []string, logger *zap.Logger, metrics *PrometheusMetrics) ([]backend.Backend, error) {
 	client := &http.Client{}
 	client.Transport = &http.Transport{
 		MaxIdleConnsPerHost: config.MaxIdleConnsPerHost,
-		IdleConnTimeout:     3 * time.Second,
+		IdleConnTimeout:     config.IdleConnTimeout,
+		DisableCompression:  !config.BackendCompression,
+		ForceAttemptHTTP2:   config.BackendHTTP2,
 		DialContext: (&net.Dialer{
 			Timeout:   config.Timeouts.Connect,
 			KeepAlive: config.KeepAliveInterval,
@@ -193,10 +269,88 @@ func initBackends(config cfg.Zipper, logger *zap.Logger) ([]backend.Backend, err
 		}).DialContext,
 	}
 
-	configBackendList := config.GetBackends()
-	backends := make([]backend.Backend, 0, len(configBackendList))
-	for _, host := range configBackendList {
+	userAgent := config.BackendUserAgent("carbonzipper", BuildVersion)
+	backends := make([]backend.Backend, 0, len(hosts))
+	for _, host := range hosts {
 		dc, cluster, _ := config.InfoOfBackend(host)
+		if strings.HasPrefix(host, bgrpc.Scheme+"://") {
+			b, err := bgrpc.New(bgrpc.Config{
+				Address:            host,
+				DC:                 dc,
+				Cluster:            cluster,
+				PoolSize:           config.GRPCPoolSize,
+				Timeout:            config.Timeouts.AfterStarted,
+				ConnectTimeout:     config.Timeouts.Connect,
+				KeepAliveInterval:  config.KeepAliveInterval,
+				Limit:              config.ConcurrencyLimitPerServer,
+				MaxQueueDepth:      config.MaxQueueDepthPerServer,
+				QueueTime:          metrics.BackendQueueTime.WithLabelValues(host),
+				PathCacheExpirySec: uint32(config.ExpireDelaySec),
+				Logger:             logger,
+				UserAgent:          userAgent,
+			})
+			if err != nil {
+				return backends, fmt.Errorf("Couldn't create backend for '%s'", host)
+			}
+
+			backends = append(backends, newMonitoredBackend(b))
+			continue
+		}
+		if strings.HasPrefix(host, bwhisper.Scheme+"://") {
+			b, err := bwhisper.New(bwhisper.Config{
+				Address: host,
+				Logger:  logger,
+			})
+			if err != nil {
+				return backends, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+			}
+
+			backends = append(backends, newMonitoredBackend(b))
+			continue
+		}
+		if strings.HasPrefix(host, binfluxdb.Scheme+"://") {
+			b, err := binfluxdb.New(binfluxdb.Config{
+				Address:            host,
+				Client:             client,
+				Timeout:            config.Timeouts.AfterStarted,
+				PathCacheExpirySec: uint32(config.ExpireDelaySec),
+				Logger:             logger,
+			})
+			if err != nil {
+				return backends, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+			}
+
+			backends = append(backends, newMonitoredBackend(b))
+			continue
+		}
+		if strings.HasPrefix(host, bopentsdb.Scheme+"://") {
+			b, err := bopentsdb.New(bopentsdb.Config{
+				Address:            host,
+				Client:             client,
+				Timeout:            config.Timeouts.AfterStarted,
+				PathCacheExpirySec: uint32(config.ExpireDelaySec),
+				Logger:             logger,
+			})
+			if err != nil {
+				return backends, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+			}
+
+			backends = append(backends, newMonitoredBackend(b))
+			continue
+		}
+		if strings.HasPrefix(host, brrd.Scheme+"://") {
+			b, err := brrd.New(brrd.Config{
+				Address: host,
+				Logger:  logger,
+			})
+			if err != nil {
+				return backends, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+			}
+
+			backends = append(backends, newMonitoredBackend(b))
+			continue
+		}
+
 		b, err := bnet.New(bnet.Config{
 			Address:            host,
 			DC:                 dc,
@@ -204,15 +358,39 @@ func initBackends(config cfg.Zipper, logger *zap.Logger) ([]backend.Backend, err
 			Client:             client,
 			Timeout:            config.Timeouts.AfterStarted,
 			Limit:              config.ConcurrencyLimitPerServer,
+			MaxQueueDepth:      config.MaxQueueDepthPerServer,
+			QueueTime:          metrics.BackendQueueTime.WithLabelValues(host),
 			PathCacheExpirySec: uint32(config.ExpireDelaySec),
 			Logger:             logger,
+			Protocol:           config.BackendProtocol,
+			UserAgent:          userAgent,
+			QueryParams:        config.BackendQueryParams,
+			Compression:        config.BackendCompression,
+			FindProtocol:       config.BackendEndpointProtocols[host].Find,
+			RenderProtocol:     config.BackendEndpointProtocols[host].Render,
+			InfoProtocol:       config.BackendEndpointProtocols[host].Info,
+			FallbackProtocol:   config.BackendFallbackProtocol,
+			ProtocolFallbacks:  metrics.BackendProtocolFallbacks,
 		})
 
 		if err != nil {
 			return backends, fmt.Errorf("Couldn't create backend for '%s'", host)
 		}
 
-		backends = append(backends, b)
+		backends = append(backends, newMonitoredBackend(b))
+	}
+
+	for i, host := range hosts {
+		rewrites := config.BackendRewrites[host]
+		if len(rewrites) == 0 {
+			continue
+		}
+		mb := backends[i].(*monitoredBackend)
+		b, err := brewrite.New(mb.Backend, rewrites)
+		if err != nil {
+			return backends, fmt.Errorf("Couldn't create backend for '%s': %v", host, err)
+		}
+		mb.Backend = b
 	}
 
 	return backends, nil
@@ -275,6 +453,7 @@ func metricsServer(app *App) *http.Server {
 	prometheus.MustRegister(app.prometheusMetrics.Renders)
 	prometheus.MustRegister(app.prometheusMetrics.RenderMismatches)
 	prometheus.MustRegister(app.prometheusMetrics.RenderFixedMismatches)
+	prometheus.MustRegister(app.prometheusMetrics.RenderSanitized)
 	prometheus.MustRegister(app.prometheusMetrics.RenderMismatchedResponses)
 	prometheus.MustRegister(app.prometheusMetrics.FindNotFound)
 	prometheus.MustRegister(app.prometheusMetrics.RequestCancel)
@@ -286,13 +465,24 @@ func metricsServer(app *App) *http.Server {
 	prometheus.MustRegister(app.prometheusMetrics.FindDurationLin)
 	prometheus.MustRegister(app.prometheusMetrics.TimeInQueueExp)
 	prometheus.MustRegister(app.prometheusMetrics.TimeInQueueLin)
+	prometheus.MustRegister(app.prometheusMetrics.TLDCacheLastRefresh)
+	prometheus.MustRegister(app.prometheusMetrics.TLDCacheSize)
+	prometheus.MustRegister(app.prometheusMetrics.TLDProbeErrors)
+	prometheus.MustRegister(app.prometheusMetrics.BackendProtocolFallbacks)
+	prometheus.MustRegister(app.prometheusMetrics.CompressedResponses)
+	prometheus.MustRegister(app.prometheusMetrics.CompressionSavedBytes)
+	prometheus.MustRegister(app.prometheusMetrics.BackendErrors)
+	prometheus.MustRegister(app.prometheusMetrics.BackendQueueTime)
+	prometheus.MustRegister(app.prometheusMetrics.ValuesPoolGets)
+	prometheus.MustRegister(app.prometheusMetrics.ValuesPoolHits)
+	prometheus.MustRegister(app.prometheusMetrics.ValuesPoolPuts)
 
 	writeTimeout := app.config.Timeouts.Global
 	if writeTimeout < 30*time.Second {
 		writeTimeout = time.Minute
 	}
 
-	r := initMetricHandlers()
+	r := initMetricHandlers(app)
 
 	s := &http.Server{
 		Addr:         app.config.ListenInternal,
@@ -320,7 +510,11 @@ func publishExpvarz(app *App) {
 	expvar.Publish("uptime", Metrics.Uptime)
 
 	// export config via expvars
-	expvar.Publish("config", expvar.Func(func() interface{} { return app.config }))
+	expvar.Publish("config", expvar.Func(func() interface{} {
+		app.backendsMu.RLock()
+		defer app.backendsMu.RUnlock()
+		return app.config
+	}))
 
 	/* Configure zipper */
 	// set up caches
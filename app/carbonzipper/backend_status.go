@@ -0,0 +1,224 @@
+package zipper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+const (
+	// backendStatsWindow is how far back the error rate and the latency of
+	// a backend are computed.
+	backendStatsWindow = time.Minute
+	// backendStatsSamples bounds the requests kept per backend within the
+	// window.
+	backendStatsSamples = 1024
+)
+
+// backendStatus is the state of one backend on /admin/backends.
+type backendStatus struct {
+	Address   string   `json:"address"`
+	TLDs      []string `json:"tlds"`
+	InFlight  int64    `json:"inFlight"`
+	Requests  int      `json:"requests"`
+	ErrorRate float64  `json:"errorRate"`
+	// OverloadRate is the rate of the requests the backend throttled or
+	// shed, which do not count as errors.
+	OverloadRate float64 `json:"overloadRate"`
+	// P99Latency is in seconds.
+	P99Latency float64 `json:"p99Latency"`
+}
+
+type backendSample struct {
+	at       time.Time
+	duration time.Duration
+	failed   bool
+	// overloaded requests are not failed
+	overloaded bool
+}
+
+// monitoredBackend counts the requests in flight to a backend and keeps the
+// outcome of its recent requests.
+type monitoredBackend struct {
+	backend.Backend
+
+	inFlight int64
+
+	mu      sync.Mutex
+	samples []backendSample // ring buffer
+	next    int
+}
+
+func newMonitoredBackend(b backend.Backend) *monitoredBackend {
+	return &monitoredBackend{
+		Backend: b,
+		samples: make([]backendSample, 0, backendStatsSamples),
+	}
+}
+
+// begin marks the start of a request, the returned func its end.
+func (b *monitoredBackend) begin() func(error) {
+	atomic.AddInt64(&b.inFlight, 1)
+	t0 := time.Now()
+
+	return func(err error) {
+		atomic.AddInt64(&b.inFlight, -1)
+		b.record(backendSample{
+			at:         t0,
+			duration:   time.Since(t0),
+			failed:     isBackendFailure(err),
+			overloaded: isBackendOverloaded(err),
+		})
+	}
+}
+
+func (b *monitoredBackend) record(s backendSample) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if len(b.samples) < cap(b.samples) {
+		b.samples = append(b.samples, s)
+		return
+	}
+	b.samples[b.next] = s
+	b.next = (b.next + 1) % len(b.samples)
+}
+
+// isBackendFailure tells if err is the fault of the backend. Missing metrics,
+// requests given up by the client and requests the backend was too loaded
+// to take are not.
+func isBackendFailure(err error) bool {
+	var notFound types.ErrNotFound
+	return err != nil && !errors.As(err, &notFound) && !errors.Is(err, context.Canceled) && !isBackendOverloaded(err)
+}
+
+// isBackendOverloaded tells if the backend throttled or shed the request.
+func isBackendOverloaded(err error) bool {
+	var overloaded types.ErrBackendOverloaded
+	return errors.As(err, &overloaded)
+}
+
+// idle tells if no request is in flight to the backend.
+func (b *monitoredBackend) idle() bool {
+	return atomic.LoadInt64(&b.inFlight) == 0
+}
+
+// Close closes the backend, if it holds connections.
+func (b *monitoredBackend) Close() error {
+	if closer, ok := b.Backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (b *monitoredBackend) Find(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+	end := b.begin()
+	matches, err := b.Backend.Find(ctx, request)
+	end(err)
+
+	return matches, err
+}
+
+func (b *monitoredBackend) Info(ctx context.Context, request types.InfoRequest) ([]types.Info, error) {
+	end := b.begin()
+	infos, err := b.Backend.Info(ctx, request)
+	end(err)
+
+	return infos, err
+}
+
+func (b *monitoredBackend) Render(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+	end := b.begin()
+	metrics, err := b.Backend.Render(ctx, request)
+	end(err)
+
+	return metrics, err
+}
+
+// status reports the requests of the backend since now - backendStatsWindow.
+func (b *monitoredBackend) status(now time.Time) backendStatus {
+	status := backendStatus{
+		Address:  b.GetServerAddress(),
+		InFlight: atomic.LoadInt64(&b.inFlight),
+	}
+
+	b.mu.Lock()
+	durations := make([]time.Duration, 0, len(b.samples))
+	failed, overloaded := 0, 0
+	for _, s := range b.samples {
+		if now.Sub(s.at) > backendStatsWindow {
+			continue
+		}
+		durations = append(durations, s.duration)
+		if s.failed {
+			failed++
+		}
+		if s.overloaded {
+			overloaded++
+		}
+	}
+	b.mu.Unlock()
+
+	status.Requests = len(durations)
+	if status.Requests == 0 {
+		return status
+	}
+	status.ErrorRate = float64(failed) / float64(status.Requests)
+	status.OverloadRate = float64(overloaded) / float64(status.Requests)
+	sort.Slice(durations, func(i, j int) bool {
+		return durations[i] < durations[j]
+	})
+	status.P99Latency = durations[(len(durations)*99-1)/100].Seconds()
+
+	return status
+}
+
+// backendsStatus reports the state of all backends, in the order of the
+// config.
+func (app *App) backendsStatus() []backendStatus {
+	app.probeMu.Lock()
+	backends := app.backends
+	tlds := make([][]string, len(backends))
+	copy(tlds, app.backendTLDs)
+	app.probeMu.Unlock()
+
+	now := time.Now()
+	statuses := make([]backendStatus, 0, len(backends))
+	for i, b := range backends {
+		var status backendStatus
+		if mb, ok := b.(*monitoredBackend); ok {
+			status = mb.status(now)
+		} else {
+			status.Address = b.GetServerAddress()
+		}
+		status.TLDs = tlds[i]
+		if status.TLDs == nil {
+			status.TLDs = []string{}
+		}
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+// backendsStatusHandler reports the state of each backend, to find the sick
+// ones without correlating metrics by hand.
+func (app *App) backendsStatusHandler(w http.ResponseWriter, req *http.Request) {
+	body, err := json.Marshal(app.backendsStatus())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
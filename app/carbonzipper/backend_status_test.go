@@ -0,0 +1,68 @@
+package zipper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"go.uber.org/zap"
+)
+
+func TestBackendsStatusHandler(t *testing.T) {
+	app, err := New(cfg.DefaultZipperConfig(), zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+
+	failing := errors.New("unavailable")
+	app.backends = []backend.Backend{
+		newMonitoredBackend(mock.New(mock.Config{Find: findTLDs("foo")})),
+		newMonitoredBackend(mock.New(mock.Config{
+			Find: func(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+				if request.Query == "*" {
+					return findTLDs("bar")(ctx, request)
+				}
+				return types.Matches{}, failing
+			},
+			Render: func(context.Context, types.RenderRequest) ([]types.Metric, error) {
+				return nil, types.ErrMetricsNotFound
+			},
+		})),
+	}
+	app.doProbe()
+
+	ctx := context.Background()
+	app.backends[1].Find(ctx, types.NewFindRequest("bar.*"))
+	app.backends[1].Render(ctx, types.NewRenderRequest([]string{"bar"}, 0, 1))
+
+	r := initMetricHandlers(app)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/admin/backends", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got code %d expected %d", w.Code, http.StatusOK)
+	}
+
+	var statuses []backendStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("error decoding status: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 backends, got %d", len(statuses))
+	}
+
+	if got := statuses[0]; len(got.TLDs) != 1 || got.TLDs[0] != "foo" || got.Requests != 1 || got.ErrorRate != 0 {
+		t.Errorf("unexpected status of the healthy backend %+v", got)
+	}
+	// the probe succeeded and the find failed. The missing metrics
+	// are not a failure
+	if got := statuses[1]; got.Requests != 3 || got.ErrorRate != 1.0/3 || got.InFlight != 0 {
+		t.Errorf("unexpected status of the failing backend %+v", got)
+	}
+}
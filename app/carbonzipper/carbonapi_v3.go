@@ -0,0 +1,111 @@
+package zipper
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
+
+	"go.uber.org/zap"
+)
+
+// formatTypeCarbonAPIV3 is the format of the requests in carbonapi_v3_pb,
+// which are answered in the same protocol whatever the protocol of the
+// backends, so that carbonapi and carbonzipper instances in front of this
+// one switch protocols on their own schedule during upgrades.
+const formatTypeCarbonAPIV3 = carbonapi_v3.ProtocolName
+
+// carbonAPIV3Requests sets the form of the requests in carbonapi_v3_pb from
+// their protobuf body, the way the same requests in carbonapi_v2_pb have it
+// in their query string, for the handlers to parse both alike.
+func carbonAPIV3Requests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != carbonapi_v3.ContentType {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := ioutil.ReadAll(r.Body)
+		if err == nil {
+			err = setCarbonAPIV3Form(r, body)
+		}
+		if err != nil {
+			http.Error(w, "invalid carbonapi_v3_pb request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+func setCarbonAPIV3Form(r *http.Request, body []byte) error {
+	form, err := url.ParseQuery(r.URL.RawQuery)
+	if err != nil {
+		return err
+	}
+
+	switch r.URL.Path {
+	case "/metrics/find/":
+		queries, err := carbonapi_v3.FindRequestDecoder(body)
+		if err != nil {
+			return err
+		}
+		if len(queries) != 1 {
+			return fmt.Errorf("%d queries, a find request must have one", len(queries))
+		}
+		form.Set("query", queries[0])
+	case "/render/":
+		targets, from, until, err := carbonapi_v3.RenderRequestDecoder(body)
+		if err != nil {
+			return err
+		}
+		form["target"] = targets
+		form.Set("from", strconv.FormatInt(from, 10))
+		form.Set("until", strconv.FormatInt(until, 10))
+	case "/info/":
+		names, err := carbonapi_v3.InfoRequestDecoder(body)
+		if err != nil {
+			return err
+		}
+		if len(names) != 1 {
+			return fmt.Errorf("%d names, an info request must have one", len(names))
+		}
+		form.Set("target", names[0])
+	}
+	form.Set("format", formatTypeCarbonAPIV3)
+
+	r.Form = form
+	r.PostForm = url.Values{}
+
+	return nil
+}
+
+// capabilitiesHandler tells the clients that negotiate their protocol that
+// both carbonapi_v2_pb and carbonapi_v3_pb are supported.
+func (app *App) capabilitiesHandler(w http.ResponseWriter, req *http.Request, logger *zap.Logger) {
+	t0 := time.Now()
+
+	blob, err := carbonapi_v3.CapabilityEncoder("carbonzipper", []string{bnet.ProtocolCarbonAPIV2, bnet.ProtocolCarbonAPIV3})
+	if err != nil {
+		http.Error(w, "error marshaling data", http.StatusInternalServerError)
+		logger.Error("capabilities failed",
+			zap.Int("http_code", http.StatusInternalServerError),
+			zap.Error(err),
+		)
+		return
+	}
+
+	w.Header().Set("Content-Type", carbonapi_v3.ContentType)
+	if _, err := w.Write(blob); err != nil {
+		logger.Error("error writing the response",
+			zap.Int("http_code", 499),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+	}
+}
@@ -0,0 +1,76 @@
+package zipper
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+func TestCarbonAPIV3Proxy(t *testing.T) {
+	app, err := New(cfg.DefaultZipperConfig(), zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+	// the backends of the zipper answer in carbonapi_v2_pb, or anything
+	app.backends = []backend.Backend{
+		mock.New(mock.Config{
+			Find:   find,
+			Info:   info,
+			Render: render,
+		}),
+	}
+
+	// as Start does, for the request times
+	timeBuckets = make([]int64, app.config.Buckets+1)
+	expTimeBuckets = make([]int64, app.config.Buckets+1)
+	server := httptest.NewServer(initHandlers(app, zap.NewNop()))
+	defer server.Close()
+
+	client, err := bnet.New(bnet.Config{
+		Address:  strings.TrimPrefix(server.URL, "http://"),
+		Client:   server.Client(),
+		Protocol: bnet.ProtocolAuto,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if protocol := client.Protocol(ctx); protocol != bnet.ProtocolCarbonAPIV3 {
+		t.Fatalf("expected the zipper to be asked in %s, got %s", bnet.ProtocolCarbonAPIV3, protocol)
+	}
+
+	matches, err := client.Find(ctx, types.NewFindRequest("foo.bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches.Matches) != 1 || matches.Matches[0].Path != "foo.bar" {
+		t.Errorf("unexpected matches %+v", matches)
+	}
+
+	metrics, err := client.Render(ctx, types.NewRenderRequest([]string{"foo.bar"}, 1510913280, 1510913880))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Name != "foo.bar" || !metrics[0].IsAbsent.Get(0) || metrics[0].Values[2] != 1510913818 {
+		t.Errorf("unexpected metrics %+v", metrics)
+	}
+
+	infos, err := client.Info(ctx, types.NewInfoRequest("foo.bar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the hosts of the infos are kept through the zipper
+	if len(infos) != 1 || infos[0].Host != "http://127.0.0.1:8080" || infos[0].AggregationMethod != "Average" {
+		t.Errorf("unexpected infos %+v", infos)
+	}
+}
@@ -0,0 +1,218 @@
+package zipper
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+// compressHandler compresses the responses of h in gzip for the clients that
+// accept it, once they reach the minimum size of config.
+func compressHandler(config cfg.Compression, metrics *PrometheusMetrics) func(http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		if !config.Enabled {
+			return h
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				h.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				config:         config,
+				status:         http.StatusOK,
+			}
+			defer func() {
+				cw.close()
+				if cw.gz != nil {
+					metrics.CompressedResponses.Inc()
+					metrics.CompressionSavedBytes.Add(float64(cw.size - cw.compressed.size))
+				}
+			}()
+
+			h.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// acceptsGzip reports whether an Accept-Encoding header accepts gzip, as
+// RFC 7231 negotiates it: the weight of gzip is that of its own entry if it
+// has one, whatever its position, or else that of *, and gzip;q=0 or *;q=0
+// without a gzip entry refuse it.
+//
+// zstd is not offered: there is no zstd encoder in the tree, and clients
+// that accept both get gzip.
+func acceptsGzip(header string) bool {
+	gzipWeight, anyWeight := -1.0, -1.0
+	for _, enc := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(enc, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "*" {
+			continue
+		}
+
+		weight, ok := qValue(params)
+		if !ok {
+			continue
+		}
+		if name == "gzip" {
+			gzipWeight = weight
+		} else {
+			anyWeight = weight
+		}
+	}
+
+	if gzipWeight >= 0 {
+		return gzipWeight > 0
+	}
+	return anyWeight > 0
+}
+
+// qValue returns the weight of the parameters of an Accept-Encoding entry,
+// 1 without a q parameter, and whether it is valid.
+func qValue(params string) (float64, bool) {
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+
+		weight, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || weight < 0 || weight > 1 {
+			return 0, false
+		}
+		return weight, true
+	}
+
+	return 1, true
+}
+
+// compressWriter holds a response back until it reaches the minimum size,
+// and compresses it from then on.
+type compressWriter struct {
+	http.ResponseWriter
+	config cfg.Compression
+
+	status      int
+	wroteHeader bool
+	// started is set once the headers are written, and gz if the response
+	// is compressed.
+	started bool
+	// buf is the response held back.
+	buf []byte
+	// size is the size of the response, uncompressed.
+	size int
+
+	gz         *gzip.Writer
+	compressed countingWriter
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w    http.ResponseWriter
+	size int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.size += n
+	return n, err
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if !cw.wroteHeader {
+		cw.status = status
+		cw.wroteHeader = true
+	}
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	cw.wroteHeader = true
+	cw.size += len(p)
+	if cw.gz != nil {
+		return cw.gz.Write(p)
+	}
+	if cw.started {
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) < cw.config.MinSize {
+		return len(p), nil
+	}
+	if err := cw.start(); err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// start writes the headers and the response held back, compressed unless it
+// is encoded already.
+func (cw *compressWriter) start() error {
+	cw.started = true
+	header := cw.Header()
+	if header.Get("Content-Encoding") != "" {
+		cw.ResponseWriter.WriteHeader(cw.status)
+		_, err := cw.ResponseWriter.Write(cw.buf)
+		cw.buf = nil
+		return err
+	}
+
+	if header.Get("Content-Type") == "" {
+		header.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	header.Set("Content-Encoding", "gzip")
+	header.Del("Content-Length")
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	cw.compressed.w = cw.ResponseWriter
+	gz, err := gzip.NewWriterLevel(&cw.compressed, cw.config.Level)
+	if err != nil {
+		gz = gzip.NewWriter(&cw.compressed)
+	}
+	cw.gz = gz
+
+	_, err = cw.gz.Write(cw.buf)
+	cw.buf = nil
+	return err
+}
+
+// Flush sends what was written so far, compressed whatever its size.
+func (cw *compressWriter) Flush() {
+	if !cw.started && cw.wroteHeader {
+		cw.start()
+	}
+	if cw.gz != nil {
+		cw.gz.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close ends the response, written as is if it is smaller than the minimum
+// size.
+func (cw *compressWriter) close() {
+	if cw.gz != nil {
+		cw.gz.Close()
+		return
+	}
+	if cw.started {
+		return
+	}
+
+	if cw.wroteHeader {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if len(cw.buf) > 0 {
+		cw.ResponseWriter.Write(cw.buf)
+	}
+}
@@ -0,0 +1,141 @@
+package zipper
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for header, expected := range map[string]bool{
+		"":                   false,
+		"gzip":               true,
+		"deflate, gzip":      true,
+		"gzip;q=0.5, br":     true,
+		"br, gzip; q=0":      false,
+		"*":                  true,
+		"identity":           false,
+		"x-gzip, deflate":    false,
+		"zstd, gzip;q=0.001": true,
+		"zstd":               false,
+		"*;q=0":              false,
+		"*;q=0, gzip":        true,
+		"gzip, *;q=0":        true,
+		"*, gzip;q=0":        false,
+		"gzip;q=0, *":        false,
+		"GZIP;Q=0.5":         true,
+		"gzip;level=1;q=0":   false,
+		"gzip;q=x":           false,
+		"gzip;q=x, *":        true,
+		"gzip;q=2":           false,
+	} {
+		if got := acceptsGzip(header); got != expected {
+			t.Errorf("%q: expected %v, got %v", header, expected, got)
+		}
+	}
+}
+
+func TestCompressHandler(t *testing.T) {
+	large := strings.Repeat("foo.bar.baz ", 1000)
+	config := cfg.Compression{Enabled: true, MinSize: 1024, Level: gzip.BestSpeed}
+
+	tests := map[string]struct {
+		body           string
+		acceptEncoding string
+		compressed     bool
+	}{
+		"large":         {large, "gzip", true},
+		"small":         {"foo.bar", "gzip", false},
+		"not accepted":  {large, "", false},
+		"empty":         {"", "gzip", false},
+		"just the size": {large[:1024], "gzip", true},
+	}
+
+	for name, tt := range tests {
+		t.Run(name, func(t *testing.T) {
+			metrics := NewPrometheusMetrics(cfg.DefaultZipperConfig())
+			h := compressHandler(config, metrics)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Header().Set("Content-Length", strconv.Itoa(len(tt.body)))
+				w.WriteHeader(http.StatusTeapot)
+				// in pieces, smaller than the minimum size
+				for body := tt.body; body != ""; {
+					n := len(body)
+					if n > 100 {
+						n = 100
+					}
+					io.WriteString(w, body[:n])
+					body = body[n:]
+				}
+			}))
+
+			r := httptest.NewRequest("GET", "/render/", nil)
+			r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != http.StatusTeapot {
+				t.Errorf("expected status %d, got %d", http.StatusTeapot, w.Code)
+			}
+			if vary := w.Header().Get("Vary"); vary != "Accept-Encoding" {
+				t.Errorf("expected to vary on Accept-Encoding, got %q", vary)
+			}
+
+			body, size := w.Body.String(), w.Body.Len()
+			if encoding := w.Header().Get("Content-Encoding"); (encoding == "gzip") != tt.compressed {
+				t.Fatalf("expected compressed %v, got encoding %q", tt.compressed, encoding)
+			}
+			if tt.compressed {
+				if w.Header().Get("Content-Length") != "" {
+					t.Error("expected the length of the uncompressed response to be dropped")
+				}
+				gz, err := gzip.NewReader(w.Body)
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, err := io.ReadAll(gz)
+				if err != nil {
+					t.Fatal(err)
+				}
+				body = string(b)
+			}
+			if body != tt.body {
+				t.Errorf("expected the body of %d bytes, got %d", len(tt.body), len(body))
+			}
+
+			var m dto.Metric
+			metrics.CompressedResponses.Write(&m)
+			if compressed := m.GetCounter().GetValue() == 1; compressed != tt.compressed {
+				t.Errorf("expected compressed %v to be counted, got %v", tt.compressed, m.GetCounter().GetValue())
+			}
+			if tt.compressed {
+				metrics.CompressionSavedBytes.Write(&m)
+				if saved := int(m.GetCounter().GetValue()); saved != len(tt.body)-size {
+					t.Errorf("expected %d bytes saved, got %d", len(tt.body)-size, saved)
+				}
+			}
+		})
+	}
+}
+
+func TestCompressHandlerDisabled(t *testing.T) {
+	compress := compressHandler(cfg.Compression{MinSize: 1}, NewPrometheusMetrics(cfg.DefaultZipperConfig()))
+
+	r := httptest.NewRequest("GET", "/render/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, strings.Repeat("foo", 100))
+	})).ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "" {
+		t.Error("expected no compression when disabled")
+	}
+}
@@ -0,0 +1,74 @@
+package zipper
+
+import (
+	"context"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend/discovery"
+
+	"go.uber.org/zap"
+)
+
+// discoveryTimeout bounds each lookup of the backends.
+const discoveryTimeout = 10 * time.Second
+
+// discoverBackends replaces the backends of config by the discovered ones, if
+// discovery is configured.
+func discoverBackends(config cfg.Zipper) (cfg.Zipper, error) {
+	if config.Discovery.Type == "" {
+		return config, nil
+	}
+
+	d, err := discovery.New(config.Discovery)
+	if err != nil {
+		return config, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+	hosts, err := d.Backends(ctx)
+	if err != nil {
+		return config, err
+	}
+
+	return withBackends(config, hosts), nil
+}
+
+// withBackends returns config with hosts as its only backends.
+func withBackends(config cfg.Zipper, hosts []string) cfg.Zipper {
+	config.Backends = hosts
+	config.BackendsByCluster = nil
+	config.BackendsByDC = nil
+
+	return config
+}
+
+// watchBackends swaps the backends for the discovered ones whenever they
+// change, for the lifetime of the app.
+func (app *App) watchBackends() {
+	d, err := discovery.New(app.config.Discovery)
+	if err != nil {
+		// checked by New
+		return
+	}
+
+	app.backendsMu.RLock()
+	current := app.config.Backends
+	app.backendsMu.RUnlock()
+
+	update := func(hosts []string) error {
+		app.backendsMu.RLock()
+		config := app.config
+		app.backendsMu.RUnlock()
+
+		return app.ReloadBackends(withBackends(config, hosts))
+	}
+	onError := func(err error) {
+		app.logger.Error("failed to discover backends",
+			zap.String("discovery", app.config.Discovery.Name),
+			zap.Error(err),
+		)
+	}
+
+	discovery.Watch(context.Background(), d, app.config.Discovery.Interval, discoveryTimeout, current, update, onError)
+}
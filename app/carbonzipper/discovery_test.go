@@ -0,0 +1,29 @@
+package zipper
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"go.uber.org/zap"
+)
+
+func TestDiscoveredBackends(t *testing.T) {
+	backendsFile := filepath.Join(t.TempDir(), "backends.json")
+	if err := ioutil.WriteFile(backendsFile, []byte(`["http://127.0.0.1:1", "http://127.0.0.1:2"]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	config := cfg.DefaultZipperConfig()
+	config.Backends = []string{"http://127.0.0.1:3"}
+	config.Discovery = cfg.Discovery{Type: "file", Name: backendsFile}
+	app, err := New(config, zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+
+	if got := addresses(app.backends); len(got) != 2 || got[0] != "127.0.0.1:1" || got[1] != "127.0.0.1:2" {
+		t.Errorf("expected the discovered backends, got %v", got)
+	}
+}
@@ -0,0 +1,214 @@
+package zipper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
+	"github.com/bookingcom/carbonapi/pkg/prioritylimiter"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// The classes of the errors of backends, in logs and metrics.
+const (
+	errClassNotFound   = "not_found"
+	errClassBadRequest = "bad_request"
+	errClassForbidden  = "forbidden"
+	errClassOverloaded = "overloaded"
+	errClassTimeout    = "timeout"
+	errClassCanceled   = "canceled"
+	errClassDecode     = "decode"
+	errClassQueueFull  = "queue_full"
+	errClassOther      = "other"
+)
+
+// errorClass returns the class of the error of a backend: not found, bad
+// request, forbidden, overloaded, timeout, canceled, http_4xx or http_5xx for the other
+// HTTP codes of the response, decode for a response that could not be
+// decoded, queue_full for a request refused for too many waiting for the
+// backend, or other.
+func errorClass(err error) string {
+	for ; err != nil; err = unwrap(err) {
+		var netErr net.Error
+		switch e := err.(type) {
+		case types.ErrNotFound:
+			return errClassNotFound
+		case types.ErrBadRequest:
+			return errClassBadRequest
+		case types.ErrForbidden:
+			return errClassForbidden
+		case types.ErrBackendOverloaded:
+			return errClassOverloaded
+		case bnet.ErrHTTPCode:
+			return fmt.Sprintf("http_%dxx", e/100)
+		case bnet.ErrDecode:
+			return errClassDecode
+		}
+		switch {
+		case err == prioritylimiter.ErrQueueFull:
+			return errClassQueueFull
+		case err == context.DeadlineExceeded:
+			return errClassTimeout
+		case err == context.Canceled:
+			return errClassCanceled
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return errClassTimeout
+		}
+	}
+
+	return errClassOther
+}
+
+// unwrap returns the error wrapped in err, if any. The backends wrap errors
+// with github.com/pkg/errors, which tells the cause rather than unwrapping.
+func unwrap(err error) error {
+	if next := errors.Unwrap(err); next != nil {
+		return next
+	}
+	if c, ok := err.(interface{ Cause() error }); ok {
+		return c.Cause()
+	}
+
+	return nil
+}
+
+// clientError returns the HTTP code and message to answer a request that
+// failed with err, if the backends refused it rather than failed: all those
+// that failed found it bad, all forbade it, or all were overloaded.
+func clientError(err error) (int, string, bool) {
+	errs := []error{err}
+	var backendsErr *backendsError
+	if errors.As(err, &backendsErr) {
+		errs = backendsErr.errs
+	}
+
+	code, msg := 0, ""
+	for _, e := range errs {
+		c, m := refusal(e)
+		if c == 0 || (code != 0 && c != code) {
+			return 0, "", false
+		}
+		if code == 0 {
+			code, msg = c, m
+		}
+	}
+
+	return code, msg, code != 0
+}
+
+// refusal returns the HTTP code and message of the refusal of a backend that
+// failed with err, or 0 if err is no refusal.
+func refusal(err error) (int, string) {
+	for ; err != nil; err = unwrap(err) {
+		switch e := err.(type) {
+		case types.ErrBadRequest:
+			return http.StatusBadRequest, "bad request: " + string(e)
+		case types.ErrForbidden:
+			return http.StatusForbidden, "forbidden: " + string(e)
+		case types.ErrBackendOverloaded:
+			return http.StatusServiceUnavailable, "backend overloaded: " + e.Message
+		}
+	}
+
+	return 0, ""
+}
+
+// setRetryAfter sets the Retry-After header of the answer to a request that
+// failed with err because the backends were overloaded, to the longest they
+// told to wait.
+func setRetryAfter(h http.Header, err error) {
+	errs := []error{err}
+	var backendsErr *backendsError
+	if errors.As(err, &backendsErr) {
+		errs = backendsErr.errs
+	}
+
+	seconds := 0
+	for _, e := range errs {
+		for ; e != nil; e = unwrap(e) {
+			if overloaded, ok := e.(types.ErrBackendOverloaded); ok {
+				if overloaded.RetryAfterSeconds() > seconds {
+					seconds = overloaded.RetryAfterSeconds()
+				}
+				break
+			}
+		}
+	}
+	if seconds > 0 {
+		h.Set("Retry-After", strconv.Itoa(seconds))
+	}
+}
+
+// backendAddress returns the address of the backend that failed with err,
+// if it is known.
+func backendAddress(err error) string {
+	var backendErr backend.Error
+	if errors.As(err, &backendErr) {
+		return backendErr.Backend
+	}
+
+	return "unknown"
+}
+
+// maxBackendsInError bounds the backends listed in the message of a
+// backendsError.
+const maxBackendsInError = 10
+
+// backendsError is the error of a request that failed because of the errors
+// of its backends, out of nBackends.
+type backendsError struct {
+	errs      []error
+	nBackends int
+}
+
+func (err *backendsError) Error() string {
+	var b strings.Builder
+	if len(err.errs) == err.nBackends {
+		fmt.Fprintf(&b, "all %d backends failed: ", err.nBackends)
+	} else {
+		fmt.Fprintf(&b, "%d of %d backends failed: ", len(err.errs), err.nBackends)
+	}
+
+	for i, e := range err.errs {
+		if i == maxBackendsInError {
+			fmt.Fprintf(&b, ", and %d more", len(err.errs)-i)
+			break
+		}
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		fmt.Fprintf(&b, "%s (%s)", backendAddress(e), errorClass(e))
+	}
+
+	return b.String()
+}
+
+// errorClasses returns the number of the errors of each class of the
+// backends that err tells failed, if any.
+func errorClasses(err error) map[string]int {
+	var backendsErr *backendsError
+	if !errors.As(err, &backendsErr) {
+		return nil
+	}
+
+	classes := make(map[string]int)
+	for _, e := range backendsErr.errs {
+		classes[errorClass(e)]++
+	}
+
+	return classes
+}
+
+// countBackendErrors counts the errors of the backends of a request to
+// handler, by backend and class.
+func (app *App) countBackendErrors(handler string, errs []error) {
+	for _, e := range errs {
+		app.prometheusMetrics.BackendErrors.WithLabelValues(handler, backendAddress(e), errorClass(e)).Inc()
+	}
+}
@@ -0,0 +1,239 @@
+package zipper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
+	"github.com/bookingcom/carbonapi/pkg/prioritylimiter"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	pkgerrors "github.com/pkg/errors"
+	dto "github.com/prometheus/client_model/go"
+	"go.uber.org/zap"
+)
+
+func TestErrorClass(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{types.ErrMetricsNotFound, errClassNotFound},
+		{types.ErrBadRequest("bad query"), errClassBadRequest},
+		{pkgerrors.Wrap(types.ErrForbidden("too many metrics"), "HTTP call failed"), errClassForbidden},
+		{types.ErrBackendOverloaded{Message: "slow down"}, errClassOverloaded},
+		{bnet.ErrHTTPCode(503), "http_5xx"},
+		{bnet.ErrHTTPCode(400), "http_4xx"},
+		{bnet.ErrDecode{Err: errors.New("unexpected EOF")}, errClassDecode},
+		{prioritylimiter.ErrQueueFull, errClassQueueFull},
+		{context.DeadlineExceeded, errClassTimeout},
+		{context.Canceled, errClassCanceled},
+		{pkgerrors.Wrap(bnet.ErrHTTPCode(502), "HTTP call failed"), "http_5xx"},
+		{fmt.Errorf("render: %w", context.DeadlineExceeded), errClassTimeout},
+		{errors.New("some error"), errClassOther},
+	}
+
+	for _, tt := range tests {
+		err := backend.Error{Backend: "b1:8080", Err: tt.err}
+		if got := errorClass(err); got != tt.class {
+			t.Errorf("%v: expected class %s, got %s", tt.err, tt.class, got)
+		}
+		if got := backendAddress(err); got != "b1:8080" {
+			t.Errorf("%v: expected backend b1:8080, got %s", tt.err, got)
+		}
+	}
+
+	if got := backendAddress(errors.New("some error")); got != "unknown" {
+		t.Errorf("expected an unknown backend, got %s", got)
+	}
+}
+
+func TestErrorsFanIn(t *testing.T) {
+	errs := []error{
+		backend.Error{Backend: "b1", Err: bnet.ErrHTTPCode(503)},
+		backend.Error{Backend: "b2", Err: context.DeadlineExceeded},
+		backend.Error{Backend: "b3", Err: types.ErrMetricsNotFound},
+	}
+
+	err := errorsFanIn(errs, 3, cfg.ResponsePolicyPartial)
+	expected := "all 3 backends failed: b1 (http_5xx), b2 (timeout), b3 (not_found)"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+	classes := errorClasses(err)
+	if len(classes) != 3 || classes["http_5xx"] != 1 || classes[errClassTimeout] != 1 || classes[errClassNotFound] != 1 {
+		t.Errorf("expected one error of each class, got %v", classes)
+	}
+
+	err = errorsFanIn(errs, 4, cfg.ResponsePolicyStrict)
+	expected = "2 of 4 backends failed: b1 (http_5xx), b2 (timeout)"
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected %q, got %v", expected, err)
+	}
+
+	if err := errorsFanIn(errs, 4, cfg.ResponsePolicyPartial); err != nil {
+		t.Errorf("expected no error, got %v", err)
+	}
+
+	notFound := []error{errs[0], errs[2], errs[2]}
+	err = errorsFanIn(notFound, 3, cfg.ResponsePolicyPartial)
+	if !errors.As(err, new(types.ErrNotFound)) {
+		t.Errorf("expected not found, got %v", err)
+	}
+	if classes := errorClasses(err); classes != nil {
+		t.Errorf("expected no classes, got %v", classes)
+	}
+
+	var many []error
+	for i := 0; i < maxBackendsInError+2; i++ {
+		many = append(many, backend.Error{Backend: fmt.Sprintf("b%d", i), Err: errors.New("some error")})
+	}
+	err = errorsFanIn(many, len(many), cfg.ResponsePolicyPartial)
+	expected = fmt.Sprintf("all %d backends failed: b0 (other), ", len(many))
+	if err == nil || !strings.HasPrefix(err.Error(), expected) {
+		t.Errorf("expected the message to start with %q, got %v", expected, err)
+	}
+	if suffix := ", and 2 more"; err == nil || !strings.HasSuffix(err.Error(), suffix) {
+		t.Errorf("expected the message to end with %q, got %v", suffix, err)
+	}
+}
+
+func TestClientError(t *testing.T) {
+	badRequest := backend.Error{Backend: "b1", Err: types.ErrBadRequest("bad query")}
+	forbidden := backend.Error{Backend: "b2", Err: pkgerrors.Wrap(types.ErrForbidden("too many metrics"), "HTTP call failed")}
+	other := backend.Error{Backend: "b3", Err: errors.New("some error")}
+	overloaded := backend.Error{Backend: "b4", Err: types.ErrBackendOverloaded{Message: "slow down"}}
+
+	tests := []struct {
+		name string
+		err  error
+		code int
+		msg  string
+	}{
+		{"bad request", badRequest, http.StatusBadRequest, "bad request: bad query"},
+		{"all bad requests", &backendsError{errs: []error{badRequest, badRequest}, nBackends: 2}, http.StatusBadRequest, "bad request: bad query"},
+		{"all forbidden", &backendsError{errs: []error{forbidden}, nBackends: 1}, http.StatusForbidden, "forbidden: too many metrics"},
+		{"all overloaded", &backendsError{errs: []error{overloaded}, nBackends: 1}, http.StatusServiceUnavailable, "backend overloaded: slow down"},
+		{"mixed refusals", &backendsError{errs: []error{badRequest, forbidden}, nBackends: 2}, 0, ""},
+		{"mixed errors", &backendsError{errs: []error{forbidden, other}, nBackends: 2}, 0, ""},
+		{"other", other, 0, ""},
+	}
+
+	for _, tt := range tests {
+		code, msg, ok := clientError(tt.err)
+		if ok != (tt.code != 0) || code != tt.code || msg != tt.msg {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.name, tt.code, tt.msg, code, msg)
+		}
+	}
+}
+
+func TestRenderForwardsClientErrors(t *testing.T) {
+	logger := zap.NewNop()
+	forbidden := func(context.Context, types.RenderRequest) ([]types.Metric, error) {
+		return nil, types.ErrForbidden("too many metrics")
+	}
+
+	tests := []struct {
+		name   string
+		render func(context.Context, types.RenderRequest) ([]types.Metric, error)
+		code   int
+	}{
+		{"forbidden", forbidden, http.StatusForbidden},
+		{"mixed", renderWithGenericError, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app, err := New(cfg.DefaultZipperConfig(), logger, "test")
+			if err != nil {
+				t.Fatalf("got error %v when making new app", err)
+			}
+			app.backends = []backend.Backend{
+				mock.New(mock.Config{Render: forbidden}),
+				mock.New(mock.Config{Render: tt.render}),
+			}
+
+			req := httptest.NewRequest("GET", "/render?target=foo.bar&from=1110&until=1111", nil)
+			w := httptest.NewRecorder()
+			app.renderHandler(w, req, logger)
+			if w.Code != tt.code {
+				t.Fatalf("got code %d expected %d", w.Code, tt.code)
+			}
+			if tt.code == http.StatusForbidden && !strings.Contains(w.Body.String(), "too many metrics") {
+				t.Errorf("expected the message of the backends, got %q", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRenderOverloaded(t *testing.T) {
+	logger := zap.NewNop()
+	overloaded := func(retryAfter time.Duration) func(context.Context, types.RenderRequest) ([]types.Metric, error) {
+		return func(context.Context, types.RenderRequest) ([]types.Metric, error) {
+			return nil, types.ErrBackendOverloaded{Message: "slow down", RetryAfter: retryAfter}
+		}
+	}
+
+	app, err := New(cfg.DefaultZipperConfig(), logger, "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+	app.backends = []backend.Backend{
+		mock.New(mock.Config{Render: overloaded(time.Second)}),
+		mock.New(mock.Config{Render: overloaded(1500 * time.Millisecond)}),
+	}
+
+	req := httptest.NewRequest("GET", "/render?target=foo.bar&from=1110&until=1111", nil)
+	w := httptest.NewRecorder()
+	app.renderHandler(w, req, logger)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got code %d expected %d", w.Code, http.StatusServiceUnavailable)
+	}
+	if got := w.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("expected to be retried after the longest wait, 2s, got %q", got)
+	}
+}
+
+func TestRenderCountsBackendErrors(t *testing.T) {
+	logger := zap.NewNop()
+	app, err := New(cfg.DefaultZipperConfig(), logger, "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+	app.backends = []backend.Backend{
+		mock.New(mock.Config{
+			Find:    find,
+			Info:    info,
+			Render:  render,
+			Address: "b1",
+		}),
+		mock.New(mock.Config{
+			Find:    find,
+			Info:    info,
+			Render:  renderWithGenericError,
+			Address: "b2",
+		}),
+	}
+
+	req := httptest.NewRequest("GET", "/render?target=foo.bar&from=1110&until=1111", nil)
+	w := httptest.NewRecorder()
+	app.renderHandler(w, req, logger)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got code %d expected %d", w.Code, http.StatusOK)
+	}
+
+	var m dto.Metric
+	app.prometheusMetrics.BackendErrors.WithLabelValues("render", "b2", errClassOther).Write(&m)
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("expected 1 error of b2 to be counted, got %v", got)
+	}
+}
@@ -0,0 +1,206 @@
+package zipper
+
+import (
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+)
+
+// ringReplicas is the number of points of each server on the ring of
+// carbon_ch and fnv1a_ch.
+const ringReplicas = 100
+
+// defaultRelayPort is the port of the servers of carbon-c-relay that don't
+// have one.
+const defaultRelayPort = 2003
+
+type ringEntry struct {
+	position int
+	shard    int
+}
+
+// hashRing finds the shards of metrics the way the relays in front of the
+// backends place them. Its shards are in the order of the config.
+type hashRing struct {
+	hash     string
+	replicas int
+	shards   [][]backend.Backend
+	ring     []ringEntry // sorted by position, empty for jump_fnv1a_ch
+}
+
+// newHashRing resolves the hash routing of the config, or returns nil if it
+// isn't configured. backends must be in the order of config.GetBackends().
+func newHashRing(config cfg.Zipper, backends []backend.Backend) (*hashRing, error) {
+	hr := config.Routing.Hash
+	if hr.Type == "" {
+		return nil, nil
+	}
+	switch hr.Type {
+	case "carbon_ch", "fnv1a_ch", "jump_fnv1a_ch":
+	default:
+		return nil, fmt.Errorf("unknown routing hash '%s'", hr.Type)
+	}
+	if len(hr.Shards) == 0 {
+		return nil, fmt.Errorf("routing hash '%s' has no shards", hr.Type)
+	}
+
+	h := &hashRing{
+		hash:     hr.Type,
+		replicas: hr.Replicas,
+	}
+	if h.replicas == 0 {
+		h.replicas = 1
+	}
+	if h.replicas < 0 || h.replicas > len(hr.Shards) {
+		return nil, fmt.Errorf("invalid number of routing hash replicas %d", hr.Replicas)
+	}
+
+	servers := make(map[string]bool)
+	taken := make(map[int]bool)
+	for i, shard := range hr.Shards {
+		if shard.Server == "" || servers[shard.Server] {
+			return nil, fmt.Errorf("invalid routing hash server '%s'", shard.Server)
+		}
+		servers[shard.Server] = true
+
+		bs, err := resolveBackends(config, backends, shard.Backends, shard.Clusters)
+		if err != nil {
+			return nil, fmt.Errorf("%v for routing hash server '%s'", err, shard.Server)
+		}
+		h.shards = append(h.shards, bs)
+
+		if h.hash == "jump_fnv1a_ch" {
+			continue
+		}
+		host, port, instance := parseRelayServer(shard.Server)
+		for r := 0; r < ringReplicas; r++ {
+			var position int
+			if h.hash == "carbon_ch" {
+				// The key is the node of carbon, a tuple of python.
+				node := "None"
+				if instance != "" {
+					node = "'" + instance + "'"
+				}
+				position = carbonPosition(fmt.Sprintf("('%s', %s):%d", host, node, r))
+			} else {
+				key := instance
+				if key == "" {
+					key = host + ":" + strconv.Itoa(port)
+				}
+				position = fnv1aPosition(fmt.Sprintf("%d-%s", r, key))
+			}
+			// Like carbon, move on from the positions that are taken.
+			for taken[position] {
+				position++
+			}
+			taken[position] = true
+			h.ring = append(h.ring, ringEntry{position: position, shard: i})
+		}
+	}
+	sort.Slice(h.ring, func(i, j int) bool {
+		return h.ring[i].position < h.ring[j].position
+	})
+
+	return h, nil
+}
+
+// parseRelayServer splits a server of the relay config, host:port=instance.
+func parseRelayServer(server string) (host string, port int, instance string) {
+	if i := strings.IndexByte(server, '='); i >= 0 {
+		server, instance = server[:i], server[i+1:]
+	}
+	host, port = server, defaultRelayPort
+	if h, p, err := net.SplitHostPort(server); err == nil {
+		if n, err := strconv.Atoi(p); err == nil {
+			host, port = h, n
+		}
+	}
+
+	return host, port, instance
+}
+
+// backends returns the backends of the shards that own metric.
+func (h *hashRing) backends(metric string) []backend.Backend {
+	var shards []int
+	if h.hash == "jump_fnv1a_ch" {
+		// The replicas are on the next servers.
+		first := jumpHash(fnv1a64(metric), len(h.shards))
+		for r := 0; r < h.replicas; r++ {
+			shards = append(shards, (first+r)%len(h.shards))
+		}
+	} else {
+		var position int
+		if h.hash == "carbon_ch" {
+			position = carbonPosition(metric)
+		} else {
+			position = fnv1aPosition(metric)
+		}
+		i := sort.Search(len(h.ring), func(i int) bool {
+			return h.ring[i].position >= position
+		})
+		seen := make(map[int]bool)
+		for n := 0; n < len(h.ring) && len(shards) < h.replicas; n++ {
+			shard := h.ring[(i+n)%len(h.ring)].shard
+			if !seen[shard] {
+				seen[shard] = true
+				shards = append(shards, shard)
+			}
+		}
+	}
+
+	var bs []backend.Backend
+	for _, shard := range shards {
+		bs = append(bs, h.shards[shard]...)
+	}
+
+	return bs
+}
+
+// carbonPosition is the position of key on the ring of carbon_ch: the first
+// two bytes of its MD5.
+func carbonPosition(key string) int {
+	/* #nosec */
+	sum := md5.Sum([]byte(key))
+	return int(binary.BigEndian.Uint16(sum[:2]))
+}
+
+// fnv1aPosition is the position of key on the ring of fnv1a_ch: its 32-bit
+// FNV-1a folded to 16 bits.
+func fnv1aPosition(key string) int {
+	hash := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint32(key[i])
+		hash *= 16777619
+	}
+
+	return int((hash >> 16) ^ (hash & 0xffff))
+}
+
+func fnv1a64(key string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(key); i++ {
+		hash ^= uint64(key[i])
+		hash *= 1099511628211
+	}
+
+	return hash
+}
+
+// jumpHash is the jump consistent hash of Lamping and Veach.
+func jumpHash(key uint64, buckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(buckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+
+	return int(b)
+}
@@ -0,0 +1,134 @@
+package zipper
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"go.uber.org/zap"
+)
+
+func hashRoutingConfig(hash string, replicas int) cfg.Zipper {
+	config := cfg.DefaultZipperConfig()
+	config.Backends = []string{"http://a:8080", "http://b:8080", "http://c:8080"}
+	config.Routing.Hash = cfg.HashRouting{
+		Type:     hash,
+		Replicas: replicas,
+		Shards: []cfg.HashShard{
+			{Server: "10.0.0.1:2003", Backends: []string{"http://a:8080"}},
+			{Server: "10.0.0.2:2003=b", Backends: []string{"http://b:8080"}},
+			{Server: "10.0.0.3", Backends: []string{"http://c:8080"}},
+		},
+	}
+
+	return config
+}
+
+func testBackends() []backend.Backend {
+	return []backend.Backend{
+		newNamedBackend("a", nil),
+		newNamedBackend("b", nil),
+		newNamedBackend("c", nil),
+	}
+}
+
+func TestHashRing(t *testing.T) {
+	metrics := []string{"a.b.c", "sys.cpu.user", "foo", "servers.web01.load", "x"}
+	// as placed by the ConsistentHashRing of carbon
+	tests := map[string][]string{
+		"carbon_ch": {"a", "c", "c", "a", "a"},
+		"fnv1a_ch":  {"a", "b", "a", "a", "c"},
+	}
+
+	for hash, want := range tests {
+		ring, err := newHashRing(hashRoutingConfig(hash, 1), testBackends())
+		if err != nil {
+			t.Fatalf("%s: %v", hash, err)
+		}
+		var got []string
+		for _, m := range metrics {
+			got = append(got, addresses(ring.backends(m))...)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", hash, want, got)
+		}
+	}
+}
+
+func TestHashRingReplicas(t *testing.T) {
+	for _, hash := range []string{"carbon_ch", "fnv1a_ch", "jump_fnv1a_ch"} {
+		ring, err := newHashRing(hashRoutingConfig(hash, 2), testBackends())
+		if err != nil {
+			t.Fatalf("%s: %v", hash, err)
+		}
+		got := addresses(ring.backends("sys.cpu.user"))
+		if len(got) != 2 || got[0] == got[1] {
+			t.Errorf("%s: expected 2 distinct shards, got %v", hash, got)
+		}
+	}
+}
+
+func TestJumpHash(t *testing.T) {
+	// Growing from 10 to 11 buckets only moves keys to the new bucket.
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := fnv1a64(strconv.Itoa(i))
+		before, after := jumpHash(key, 10), jumpHash(key, 11)
+		if before != after {
+			if after != 10 {
+				t.Fatalf("key %d moved from %d to %d", i, before, after)
+			}
+			moved++
+		}
+	}
+	if moved < 500 || moved > 1300 {
+		t.Errorf("expected about 1/11 of the keys to move, got %d", moved)
+	}
+}
+
+func TestNewHashRingErrors(t *testing.T) {
+	invalid := []func(*cfg.Zipper){
+		func(c *cfg.Zipper) { c.Routing.Hash.Type = "md5" },
+		func(c *cfg.Zipper) { c.Routing.Hash.Replicas = 4 },
+		func(c *cfg.Zipper) { c.Routing.Hash.Shards = nil },
+		func(c *cfg.Zipper) { c.Routing.Hash.Shards[1].Server = "10.0.0.1:2003" },
+		func(c *cfg.Zipper) { c.Routing.Hash.Shards[0].Backends = []string{"http://d:8080"} },
+	}
+	for i, change := range invalid {
+		config := hashRoutingConfig("carbon_ch", 1)
+		change(&config)
+		if _, err := newHashRing(config, testBackends()); err == nil {
+			t.Errorf("%d: expected an error for %+v", i, config.Routing.Hash)
+		}
+	}
+}
+
+func TestFilterBackendsByHash(t *testing.T) {
+	app, err := New(hashRoutingConfig("carbon_ch", 1), zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+	app.backends = []backend.Backend{
+		newNamedBackend("a", map[string][]types.Match{"*": {{Path: "sys"}}}),
+		newNamedBackend("b", map[string][]types.Match{"*": {{Path: "sys"}}}),
+		newNamedBackend("c", nil),
+	}
+	app.ring, err = newHashRing(app.config, app.backends)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.doProbe()
+
+	if got := addresses(app.filterBackendsForMetrics([]string{"sys.cpu.user"})); !reflect.DeepEqual(got, []string{"c"}) {
+		t.Errorf("expected the shard of the metric, got %v", got)
+	}
+	if got := addresses(app.filterBackendsForMetrics([]string{"sys.cpu.*"})); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("expected globs to be routed by prefix, got %v", got)
+	}
+	if got := addresses(app.filterBackendsByPrefix([]string{"sys.cpu.user"})); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("expected find to be routed by prefix, got %v", got)
+	}
+}
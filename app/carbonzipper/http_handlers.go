@@ -6,9 +6,15 @@
 // Error codes policy (applies to find, render, info endpoints)
 //
 //   - if at least one backend succeeds, it's a success with code 200.
+//     Unless the response policy, of the config or of the
+//     X-Carbonzipper-Response-Policy header of the request, is strict: then
+//     any backend failing with an error other than not-found fails with 500.
 //   - if all bakends fail
 //     - if all errors are not-found, it's a not found. But code is 200 + a monitoring counter incremented.
 //     - if errors are of mixed type we fail with code 500.
+//   - if all the backends that fail the request refuse it as bad (400) or
+//     forbidden (403), e.g. beyond their limits, the code is theirs instead
+//     of 500.
 package zipper
 
 import (
@@ -21,9 +27,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/date"
 	"github.com/bookingcom/carbonapi/pkg/backend"
+	bnet "github.com/bookingcom/carbonapi/pkg/backend/net"
 	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/json"
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/pickle"
 	"github.com/bookingcom/carbonapi/util"
@@ -79,10 +89,11 @@ func (app *App) findHandler(w http.ResponseWriter, req *http.Request, logger *za
 		kv.String("graphite.target", originalQuery),
 	)
 	request := types.NewFindRequest(originalQuery)
-	bs := app.filterBackendByTopLevelDomain([]string{originalQuery})
+	bs := app.filterBackendsByPrefix([]string{originalQuery})
 	bs = backend.Filter(bs, []string{originalQuery})
 	metrics, errs := backend.Finds(ctx, bs, request)
-	err := errorsFanIn(errs, len(bs))
+	app.countBackendErrors("find", errs)
+	err := errorsFanIn(errs, len(bs), app.responsePolicy(req))
 
 	if ctx.Err() != nil {
 		// context was cancelled even if some of the requests succeeded
@@ -107,13 +118,18 @@ func (app *App) findHandler(w http.ResponseWriter, req *http.Request, logger *za
 				zap.Error(err))
 			// TODO (grzkv) Should we return here?
 		} else {
-			code := http.StatusInternalServerError
+			code, msg := http.StatusInternalServerError, err.Error()
+			if c, m, ok := clientError(err); ok {
+				code, msg = c, m
+				setRetryAfter(w.Header(), err)
+			}
 			logger.Error("find failed",
 				zap.Int("http_code", code),
 				zap.Duration("runtime_seconds", time.Since(t0)),
 				zap.Error(err),
+				zap.Any("backend_error_classes", errorClasses(err)),
 			)
-			http.Error(w, err.Error(), code)
+			http.Error(w, msg, code)
 			Metrics.Errors.Add(1)
 			app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(code), "find").Inc()
 			return
@@ -141,13 +157,13 @@ func (app *App) findHandler(w http.ResponseWriter, req *http.Request, logger *za
 	case formatTypeJSON:
 		contentType = contentTypeJSON
 		blob, err = json.FindEncoder(metrics)
+	case formatTypeCarbonAPIV3:
+		contentType = carbonapi_v3.ContentType
+		blob, err = carbonapi_v3.FindEncoder(metrics)
 	case formatTypeEmpty, formatTypePickle:
-		contentType = contentTypePickle
-		if app.config.GraphiteWeb09Compatibility {
-			blob, err = pickle.FindEncoderV0_9(metrics)
-		} else {
-			blob, err = pickle.FindEncoderV1_0(metrics)
-		}
+		// large finds are streamed rather than encoded in one buffer
+		app.writeFindPickle(w, metrics, logger, t0, span)
+		return
 	default:
 		err = fmt.Errorf("Unknown format %s", format)
 	}
@@ -188,6 +204,54 @@ func (app *App) findHandler(w http.ResponseWriter, req *http.Request, logger *za
 	)
 }
 
+// findPickleEncoder encodes find responses in pickle as configured.
+func (app *App) findPickleEncoder() pickle.FindEncoder {
+	return pickle.FindEncoder{
+		Protocol:      app.config.Pickle.Protocol,
+		MaxSize:       app.config.Pickle.MaxFindSize,
+		GraphiteWeb09: app.config.GraphiteWeb09Compatibility,
+	}
+}
+
+// writeFindPickle streams the pickle of a find response to w, unless it is
+// larger than configured.
+func (app *App) writeFindPickle(w http.ResponseWriter, metrics types.Matches, logger *zap.Logger, t0 time.Time, span trace.Span) {
+	w.Header().Set("Content-Type", contentTypePickle)
+	err := app.findPickleEncoder().Encode(w, metrics)
+
+	var tooLarge pickle.ErrTooLarge
+	if errors.As(err, &tooLarge) {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		logger.Error("find failed",
+			zap.Int("http_code", http.StatusUnprocessableEntity),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(http.StatusUnprocessableEntity), "find").Inc()
+		span.SetAttribute("error", true)
+		span.SetAttribute("error.message", err.Error())
+		return
+	}
+
+	Metrics.Responses.Add(1)
+	app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(http.StatusOK), "find").Inc()
+
+	if err != nil {
+		logger.Error("error writing the response",
+			zap.Int("http_code", 499),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		return
+	}
+
+	logger.Info("request served",
+		zap.Int("http_code", http.StatusOK),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+}
+
 func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *zap.Logger) {
 	t0 := time.Now()
 	memoryUsage := 0
@@ -226,7 +290,9 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *
 		return
 	}
 
-	target := req.FormValue("target")
+	// requests in carbonapi_v3_pb may have several targets
+	targets := req.Form["target"]
+	target := strings.Join(targets, ",")
 	format := req.FormValue("format")
 	logger = logger.With(
 		zap.String("format", format),
@@ -236,12 +302,14 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *
 		kv.String("graphite.target", target),
 		kv.String("graphite.format", format),
 	)
-	from, err := strconv.ParseInt(req.FormValue("from"), 10, 64)
+	qtz := req.FormValue("tz")
+	now := time.Now()
+	from, err := date.DateParamToEpoch(req.FormValue("from"), qtz, now.Add(-24*time.Hour).Unix(), time.Local)
 	if err != nil {
-		http.Error(w, "from is not a integer", http.StatusBadRequest)
+		http.Error(w, "invalid from: "+err.Error(), http.StatusBadRequest)
 		logger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
-			zap.String("reason", "from is not a integer"),
+			zap.String("reason", "invalid from"),
 			zap.Int("http_code", http.StatusBadRequest),
 			zap.Duration("runtime_seconds", time.Since(t0)),
 			zap.Error(err),
@@ -249,16 +317,16 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *
 		Metrics.Errors.Add(1)
 		app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(http.StatusBadRequest), "render").Inc()
 		span.SetAttribute("error", true)
-		span.SetAttribute("error.message", "from is not a integer")
+		span.SetAttribute("error.message", "invalid from")
 		return
 	}
 
-	until, err := strconv.ParseInt(req.FormValue("until"), 10, 64)
+	until, err := date.DateParamToEpoch(req.FormValue("until"), qtz, now.Unix(), time.Local)
 	if err != nil {
-		http.Error(w, "until is not a integer", http.StatusBadRequest)
+		http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
 		logger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
-			zap.String("reason", "until is not a integer"),
+			zap.String("reason", "invalid until"),
 			zap.Int("http_code", http.StatusBadRequest),
 			zap.Duration("runtime_seconds", time.Since(t0)),
 			zap.Error(err),
@@ -266,16 +334,16 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *
 		Metrics.Errors.Add(1)
 		app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(http.StatusBadRequest), "render").Inc()
 		span.SetAttribute("error", true)
-		span.SetAttribute("error.message", "until is not a integer")
+		span.SetAttribute("error.message", "invalid until")
 		return
 	}
 
 	span.SetAttributes(
-		kv.Int64("graphite.from", from),
-		kv.Int64("graphite.until", until),
+		kv.Int32("graphite.from", from),
+		kv.Int32("graphite.until", until),
 	)
 
-	if target == "" {
+	if len(targets) == 0 || targets[0] == "" {
 		http.Error(w, "empty target", http.StatusBadRequest)
 		logger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
@@ -290,19 +358,25 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *
 		return
 	}
 
-	request := types.NewRenderRequest([]string{target}, int32(from), int32(until))
+	request := types.NewRenderRequest(targets, int64(from), int64(until))
 	request.Trace.OutDuration = app.prometheusMetrics.RenderOutDurationExp
-	bs := app.filterBackendByTopLevelDomain(request.Targets)
+	bs := app.filterBackendsForMetrics(request.Targets)
 	bs = backend.Filter(bs, request.Targets)
 	metrics, stats, errs := backend.Renders(ctx, bs, request, app.config.RenderReplicaMismatchConfig, logger)
+	// the metrics are this request's own, and not needed once answered
+	defer types.ReleaseMetrics(metrics)
 	app.prometheusMetrics.Renders.Add(float64(stats.DataPointCount))
 	app.prometheusMetrics.RenderMismatches.Add(float64(stats.MismatchCount))
 	app.prometheusMetrics.RenderFixedMismatches.Add(float64(stats.FixedMismatchCount))
-	err = errorsFanIn(errs, len(bs))
+	app.countSanitized(stats.Sanitized)
+	app.countBackendErrors("render", errs)
+	err = errorsFanIn(errs, len(bs), app.responsePolicy(req))
 	span.SetAttribute("graphite.metrics", len(metrics))
+	report := request.Trace.Report()
+	span.SetAttributes(report.KeyValues()...)
 	// time in queue is converted to ms
-	app.prometheusMetrics.TimeInQueueExp.Observe(float64(request.Trace.Report()[2]) / 1000 / 1000)
-	app.prometheusMetrics.TimeInQueueLin.Observe(float64(request.Trace.Report()[2]) / 1000 / 1000)
+	app.prometheusMetrics.TimeInQueueExp.Observe(float64(report.Queue) / float64(time.Millisecond))
+	app.prometheusMetrics.TimeInQueueLin.Observe(float64(report.Queue) / float64(time.Millisecond))
 
 	if ctx.Err() != nil {
 		// context was cancelled even if some of the requests succeeded
@@ -320,15 +394,19 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *
 		if errors.As(err, &notFound) {
 			msg = "not found"
 			code = http.StatusNotFound
+		} else if c, m, ok := clientError(err); ok {
+			code, msg = c, m
+			setRetryAfter(w.Header(), err)
 		}
 
 		http.Error(w, msg, code)
 		logger.Error("request failed",
 			zap.Int("memory_usage_bytes", memoryUsage),
 			zap.Error(err),
+			zap.Any("backend_error_classes", errorClasses(err)),
 			zap.Int("http_code", code),
 			zap.Duration("runtime_seconds", time.Since(t0)),
-			zap.Int64s("trace", request.Trace.Report()),
+			zap.Object("trace", report),
 		)
 
 		Metrics.Errors.Add(1)
@@ -347,6 +425,9 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *
 	case formatTypeJSON:
 		contentType = contentTypeJSON
 		blob, err = json.RenderEncoder(metrics)
+	case formatTypeCarbonAPIV3:
+		contentType = carbonapi_v3.ContentType
+		blob, err = carbonapi_v3.RenderEncoder(metrics)
 	case formatTypeEmpty, formatTypePickle:
 		contentType = contentTypePickle
 		blob, err = pickle.RenderEncoder(metrics)
@@ -362,7 +443,7 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *
 			zap.Duration("runtime_seconds", time.Since(t0)),
 			zap.Int("memory_usage_bytes", memoryUsage),
 			zap.Error(err),
-			zap.Int64s("trace", request.Trace.Report()),
+			zap.Object("trace", report),
 		)
 		Metrics.Errors.Add(1)
 		app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(http.StatusInternalServerError), "render").Inc()
@@ -372,6 +453,10 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *
 		return
 	}
 
+	if stats.MismatchCount > 0 {
+		w.Header().Set(bnet.MismatchesHeader, strconv.Itoa(stats.MismatchCount))
+		w.Header().Set(bnet.FixedMismatchesHeader, strconv.Itoa(stats.FixedMismatchCount))
+	}
 	w.Header().Set("Content-Type", contentType)
 	_, writeErr := w.Write(blob)
 
@@ -394,7 +479,7 @@ func (app *App) renderHandler(w http.ResponseWriter, req *http.Request, logger *
 		zap.Int("memory_usage_bytes", memoryUsage),
 		zap.Int("http_code", http.StatusOK),
 		zap.Duration("runtime_seconds", time.Since(t0)),
-		zap.Int64s("trace", request.Trace.Report()),
+		zap.Object("trace", report),
 	)
 }
 
@@ -454,10 +539,11 @@ func (app *App) infoHandler(w http.ResponseWriter, req *http.Request, logger *za
 	}
 
 	request := types.NewInfoRequest(target)
-	bs := app.filterBackendByTopLevelDomain([]string{target})
+	bs := app.filterBackendsForMetrics([]string{target})
 	bs = backend.Filter(bs, []string{target})
 	infos, errs := backend.Infos(ctx, bs, request)
-	err = errorsFanIn(errs, len(bs))
+	app.countBackendErrors("info", errs)
+	err = errorsFanIn(errs, len(bs), app.responsePolicy(req))
 	if err != nil {
 
 		var notFound types.ErrNotFound
@@ -471,14 +557,20 @@ func (app *App) infoHandler(w http.ResponseWriter, req *http.Request, logger *za
 			return
 		}
 
+		code, msg := http.StatusInternalServerError, "info: error processing request"
+		if c, m, ok := clientError(err); ok {
+			code, msg = c, "info: "+m
+			setRetryAfter(w.Header(), err)
+		}
 		logger.Error("info failed",
-			zap.Int("http_code", http.StatusInternalServerError),
+			zap.Int("http_code", code),
 			zap.Error(err),
+			zap.Any("backend_error_classes", errorClasses(err)),
 			zap.Duration("runtime_seconds", time.Since(t0)),
 		)
-		http.Error(w, "info: error processing request", http.StatusInternalServerError)
+		http.Error(w, msg, code)
 		Metrics.Errors.Add(1)
-		app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(http.StatusInternalServerError), "info").Inc()
+		app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(code), "info").Inc()
 		return
 	}
 
@@ -491,6 +583,9 @@ func (app *App) infoHandler(w http.ResponseWriter, req *http.Request, logger *za
 	case formatTypeEmpty, formatTypeJSON:
 		contentType = contentTypeJSON
 		blob, err = json.InfoEncoder(infos)
+	case formatTypeCarbonAPIV3:
+		contentType = carbonapi_v3.ZipperInfoContentType
+		blob, err = carbonapi_v3.InfoEncoder(infos)
 	default:
 		err = fmt.Errorf("Unknown format %s", format)
 	}
@@ -551,70 +646,52 @@ func (app *App) lbCheckHandler(w http.ResponseWriter, req *http.Request, logger
 		"lbcheck").Inc()
 }
 
-func (app *App) filterBackendByTopLevelDomain(targets []string) []backend.Backend {
-	targetTlds := make([]string, 0, len(targets))
-	for _, target := range targets {
-		targetTlds = append(targetTlds, getTopLevelDomain(target))
-	}
-
-	bs := app.filterByTopLevelDomain(app.backends, targetTlds)
-	if len(bs) > 0 {
-		return bs
-	}
-	return app.backends
-}
+// responsePolicyHeader overrides the response policy of the config for a
+// request.
+const responsePolicyHeader = "X-Carbonzipper-Response-Policy"
 
-func getTopLevelDomain(target string) string {
-	return strings.SplitN(target, ".", 2)[0]
-}
-
-func (app *App) filterByTopLevelDomain(backends []backend.Backend, targetTLDs []string) []backend.Backend {
-	bs := make([]backend.Backend, 0)
-	allTLDBackends := make([]*backend.Backend, 0)
-
-	topLevelDomainCache, _ := app.topLevelDomainCache.Get("tlds")
-	tldCache := make(map[string][]*backend.Backend)
-	if x, ok := topLevelDomainCache.(map[string][]*backend.Backend); ok {
-		tldCache = x
-	}
-
-	if tldCache == nil {
-		return backends
-	}
-	alreadyAddedBackends := make(map[string]bool)
-	for _, target := range targetTLDs {
-		tldBackends := tldCache[target]
-		for _, backend := range tldBackends {
-			a := *backend
-			if !alreadyAddedBackends[a.GetServerAddress()] {
-				alreadyAddedBackends[a.GetServerAddress()] = true
-				allTLDBackends = append(allTLDBackends, backend)
-			}
+// responsePolicy returns the response policy of a request, that of its
+// header if it is valid, else that of the config.
+func (app *App) responsePolicy(req *http.Request) cfg.ResponsePolicy {
+	if h := req.Header.Get(responsePolicyHeader); h != "" {
+		if policy, err := cfg.ParseResponsePolicy(h); err == nil {
+			return policy
 		}
 	}
-	for _, tldBackend := range allTLDBackends {
-		bs = append(bs, *tldBackend)
-	}
 
-	return bs
+	return app.config.ResponsePolicy
 }
 
-func errorsFanIn(errs []error, nBackends int) error {
+// errorsFanIn returns the error of a request from the errors of its
+// backends, out of nBackends: a *backendsError telling which backends failed
+// and how, unless the majority of them did not find the metrics.
+func errorsFanIn(errs []error, nBackends int, policy cfg.ResponsePolicy) error {
 	nErrs := len(errs)
-	var counts = make(map[string]int)
 	switch {
 	case (nErrs == 0):
 		return nil
 	case (nErrs < nBackends):
-		return nil
+		if policy != cfg.ResponsePolicyStrict {
+			return nil
+		}
+		// backends not having the metrics is no failure
+		var failed []error
+		for _, e := range errs {
+			if errorClass(e) != errClassNotFound {
+				failed = append(failed, e)
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		return &backendsError{errs: failed, nBackends: nBackends}
 	case (nErrs > nBackends):
 		return errors.New("got more errors than there are backends. Probably something is broken")
 	default:
 		// everything failed, nErrs == nBackends
 		nNotNotFounds := 0
 		for _, e := range errs {
-			counts[e.Error()] += 1
-			if _, ok := e.(types.ErrNotFound); !ok {
+			if errorClass(e) != errClassNotFound {
 				nNotNotFounds += 1
 			}
 		}
@@ -626,10 +703,6 @@ func errorsFanIn(errs []error, nBackends int) error {
 				"majority of backends returned not found. %d total errors, %d not found",
 				nErrs, nErrs-nNotNotFounds))
 		}
-		message := fmt.Sprintf("all backends failed with mixed errors: %+v", counts)
-		if len(message) > 300 {
-			message = message[:300]
-		}
-		return errors.New(message)
+		return &backendsError{errs: errs, nBackends: nBackends}
 	}
 }
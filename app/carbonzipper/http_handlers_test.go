@@ -5,7 +5,9 @@ import (
 	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/bookingcom/carbonapi/cfg"
 	"github.com/bookingcom/carbonapi/pkg/backend"
@@ -89,6 +91,67 @@ func TestRenderSingleBackend(t *testing.T) {
 	}
 }
 
+func TestRenderTimeFormats(t *testing.T) {
+	logger := zap.NewNop()
+
+	app, err := New(cfg.DefaultZipperConfig(), logger, "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+
+	var got types.RenderRequest
+	app.backends = []backend.Backend{
+		mock.New(mock.Config{
+			Render: func(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+				got = request
+				return render(ctx, request)
+			},
+		}),
+	}
+
+	midnight := time.Date(2023, time.December, 1, 0, 0, 0, 0, time.UTC)
+	var tt = []struct {
+		path  string
+		code  int
+		from  int64
+		until int64
+	}{
+		{"/render?target=foo.bar&from=1110&until=1111", http.StatusOK, 1110, 1111},
+		{"/render?target=foo.bar&from=20231201&until=20231202&tz=UTC", http.StatusOK,
+			midnight.Unix(), midnight.AddDate(0, 0, 1).Unix()},
+		{"/render?target=foo.bar&from=-1h&until=now", http.StatusOK, 0, 0},
+		{"/render?target=foo.bar&from=-1hour-ish", http.StatusBadRequest, 0, 0},
+		{"/render?target=foo.bar&from=1110&until=someday", http.StatusBadRequest, 0, 0},
+	}
+
+	for _, tst := range tt {
+		got = types.RenderRequest{}
+		w := httptest.NewRecorder()
+		req, err := http.NewRequest("GET", tst.path, nil)
+		if err != nil {
+			t.Fatalf("error making request %v", err)
+		}
+
+		app.renderHandler(w, req, logger)
+		if w.Code != tst.code {
+			t.Fatalf("%s: got code %d expected %d", tst.path, w.Code, tst.code)
+		}
+		if tst.code != http.StatusOK {
+			continue
+		}
+		if tst.from == 0 {
+			// from and until are taken at slightly different times
+			if d := got.Until - got.From; d < 3600 || d > 3601 {
+				t.Errorf("%s: expected a 1h range, got %d to %d", tst.path, got.From, got.Until)
+			}
+			continue
+		}
+		if got.From != tst.from || got.Until != tst.until {
+			t.Errorf("%s: got range %d to %d, expected %d to %d", tst.path, got.From, got.Until, tst.from, tst.until)
+		}
+	}
+}
+
 func TestRenderSingleGenericBackendError(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 	defer logger.Sync()
@@ -239,6 +302,64 @@ func TestRenderMultipleBackendsSomeErrors(t *testing.T) {
 	}
 }
 
+func TestRenderResponsePolicy(t *testing.T) {
+	logger, _ := zap.NewDevelopment()
+	defer logger.Sync()
+
+	tests := []struct {
+		name   string
+		policy cfg.ResponsePolicy
+		header string
+		render func(context.Context, types.RenderRequest) ([]types.Metric, error)
+		code   int
+	}{
+		{"partial", cfg.ResponsePolicyPartial, "", renderWithGenericError, http.StatusOK},
+		{"strict", cfg.ResponsePolicyStrict, "", renderWithGenericError, http.StatusInternalServerError},
+		{"strict not found", cfg.ResponsePolicyStrict, "", renderWithNotFoundError, http.StatusOK},
+		{"strict header", cfg.ResponsePolicyPartial, "strict", renderWithGenericError, http.StatusInternalServerError},
+		{"partial header", cfg.ResponsePolicyStrict, "partial", renderWithGenericError, http.StatusOK},
+		{"invalid header", cfg.ResponsePolicyStrict, "lenient", renderWithGenericError, http.StatusInternalServerError},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			config := cfg.DefaultZipperConfig()
+			config.ResponsePolicy = tst.policy
+			app, err := New(config, logger, "test")
+			if err != nil {
+				t.Fatalf("got error %v when making new app", err)
+			}
+			app.backends = []backend.Backend{
+				mock.New(mock.Config{
+					Find:   find,
+					Info:   info,
+					Render: render,
+				}),
+				mock.New(mock.Config{
+					Find:   find,
+					Info:   info,
+					Render: tst.render,
+				}),
+			}
+
+			w := httptest.NewRecorder()
+			req, err := http.NewRequest("GET", "/render?target=foo.bar&from=1110&until=1111", nil)
+			if err != nil {
+				t.Fatalf("error making request %v", err)
+			}
+			if tst.header != "" {
+				req.Header.Set(responsePolicyHeader, tst.header)
+			}
+
+			app.renderHandler(w, req, logger)
+
+			if w.Code != tst.code {
+				t.Fatalf("got code %d expected %d", w.Code, tst.code)
+			}
+		})
+	}
+}
+
 func TestRenderMultipleBackendsAllNotfoundErrors(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 	defer logger.Sync()
@@ -486,6 +607,38 @@ func TestFindSingleBackend(t *testing.T) {
 	}
 }
 
+func TestFindPickle(t *testing.T) {
+	config := cfg.DefaultZipperConfig()
+	config.Pickle.Protocol = 2
+	app, err := New(config, zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+	app.backends = []backend.Backend{
+		mock.New(mock.Config{
+			Find:   find,
+			Info:   info,
+			Render: render,
+		}),
+	}
+
+	w := httptest.NewRecorder()
+	app.findHandler(w, httptest.NewRequest("GET", "/metrics/find?query=foo.bar&format=pickle", nil), zap.NewNop())
+	if w.Code != http.StatusOK {
+		t.Fatalf("got code %d expected %d", w.Code, http.StatusOK)
+	}
+	if !strings.HasPrefix(w.Body.String(), "\x80\x02") {
+		t.Errorf("expected a pickle of protocol 2, got %q", w.Body.String())
+	}
+
+	app.config.Pickle.MaxFindSize = w.Body.Len() - 1
+	w = httptest.NewRecorder()
+	app.findHandler(w, httptest.NewRequest("GET", "/metrics/find?query=foo.bar&format=pickle", nil), zap.NewNop())
+	if w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("got code %d expected %d", w.Code, http.StatusUnprocessableEntity)
+	}
+}
+
 func TestFindSingleBackendWithGenericError(t *testing.T) {
 	logger, _ := zap.NewDevelopment()
 	defer logger.Sync()
@@ -1000,7 +1153,7 @@ func render(ctx context.Context, request types.RenderRequest) ([]types.Metric, e
 			StopTime:  1510913880,
 			StepTime:  60,
 			Values:    []float64{0, 1510913759, 1510913818},
-			IsAbsent:  []bool{true, false, false},
+			IsAbsent:  types.AbsenceOf(true, false, false),
 		},
 	}, nil
 }
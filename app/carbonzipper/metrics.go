@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 
 	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/types"
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -59,6 +60,7 @@ type PrometheusMetrics struct {
 	Responses                 *prometheus.CounterVec
 	RenderMismatches          prometheus.Counter
 	RenderFixedMismatches     prometheus.Counter
+	RenderSanitized           *prometheus.CounterVec
 	RenderMismatchedResponses prometheus.Counter
 	Renders                   prometheus.Counter
 	FindNotFound              prometheus.Counter
@@ -71,6 +73,17 @@ type PrometheusMetrics struct {
 	FindDurationLin           prometheus.Histogram
 	TimeInQueueExp            prometheus.Histogram
 	TimeInQueueLin            prometheus.Histogram
+	TLDCacheLastRefresh       prometheus.Gauge
+	TLDCacheSize              prometheus.Gauge
+	TLDProbeErrors            *prometheus.CounterVec
+	BackendProtocolFallbacks  *prometheus.CounterVec
+	CompressedResponses       prometheus.Counter
+	CompressionSavedBytes     prometheus.Counter
+	BackendErrors             *prometheus.CounterVec
+	BackendQueueTime          *prometheus.HistogramVec
+	ValuesPoolGets            prometheus.CounterFunc
+	ValuesPoolHits            prometheus.CounterFunc
+	ValuesPoolPuts            prometheus.CounterFunc
 }
 
 // NewPrometheusMetrics creates a set of default Prom metrics
@@ -101,6 +114,13 @@ func NewPrometheusMetrics(config cfg.Zipper) *PrometheusMetrics {
 				Help: "Count of fixed mismatched rendered data points",
 			},
 		),
+		RenderSanitized: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "render_sanitized_total",
+				Help: "Count of the metrics dropped as invalid, the metrics with a misaligned stop time and the duplicate points of backends, partitioned by backend",
+			},
+			[]string{"backend", "kind"},
+		),
 		RenderMismatches: prometheus.NewCounter(
 			prometheus.CounterOpts{
 				Name: "render_mismatches_total",
@@ -209,6 +229,80 @@ func NewPrometheusMetrics(config cfg.Zipper) *PrometheusMetrics {
 					config.Monitoring.TimeInQueueLinHistogram.BucketsNum),
 			},
 		),
+		TLDCacheLastRefresh: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "tld_cache_last_refresh_timestamp_seconds",
+				Help: "Unix time of the last refresh of the top-level domain cache",
+			},
+		),
+		TLDCacheSize: prometheus.NewGauge(
+			prometheus.GaugeOpts{
+				Name: "tld_cache_domains",
+				Help: "Number of top-level domains in the top-level domain cache",
+			},
+		),
+		TLDProbeErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "tld_probe_errors_total",
+				Help: "Count of failed top-level domain probes, partitioned by backend",
+			},
+			[]string{"backend"},
+		),
+		BackendQueueTime: prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    "backend_queue_seconds",
+				Help:    "Time requests wait for the concurrency limit of a backend, partitioned by backend",
+				Buckets: prometheus.ExponentialBuckets(0.001, 2, 15),
+			},
+			[]string{"backend"},
+		),
+		BackendProtocolFallbacks: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "backend_protocol_fallbacks_total",
+				Help: "Count of backend requests retried with the fallback protocol, partitioned by endpoint",
+			},
+			[]string{"endpoint"},
+		),
+		CompressedResponses: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "http_responses_compressed_total",
+				Help: "Count of HTTP responses compressed in gzip",
+			},
+		),
+		CompressionSavedBytes: prometheus.NewCounter(
+			prometheus.CounterOpts{
+				Name: "http_response_compression_saved_bytes_total",
+				Help: "Bytes saved by compressing HTTP responses",
+			},
+		),
+		BackendErrors: prometheus.NewCounterVec(
+			prometheus.CounterOpts{
+				Name: "backend_errors_total",
+				Help: "Count of the errors of backends, partitioned by handler, backend and class",
+			},
+			[]string{"handler", "backend", "class"},
+		),
+		ValuesPoolGets: prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "values_pool_gets_total",
+				Help: "Count of the slices of values asked for in decoding and merging metrics",
+			},
+			func() float64 { return float64(types.GetPoolStats().Gets) },
+		),
+		ValuesPoolHits: prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "values_pool_hits_total",
+				Help: "Count of the slices of values reused from the pool rather than allocated",
+			},
+			func() float64 { return float64(types.GetPoolStats().Hits) },
+		),
+		ValuesPoolPuts: prometheus.NewCounterFunc(
+			prometheus.CounterOpts{
+				Name: "values_pool_puts_total",
+				Help: "Count of the slices of values given back to the pool",
+			},
+			func() float64 { return float64(types.GetPoolStats().Puts) },
+		),
 	}
 }
 
@@ -218,6 +312,16 @@ var expTimeBuckets []int64
 type bucketEntry int
 type expBucketEntry int
 
+// countSanitized counts what was fixed in the metrics of the backends of a
+// render request, by backend.
+func (app *App) countSanitized(sanitized map[string]types.SanitizeStats) {
+	for b, stats := range sanitized {
+		app.prometheusMetrics.RenderSanitized.WithLabelValues(b, "invalid").Add(float64(stats.Invalid))
+		app.prometheusMetrics.RenderSanitized.WithLabelValues(b, "misaligned").Add(float64(stats.Misaligned))
+		app.prometheusMetrics.RenderSanitized.WithLabelValues(b, "duplicate").Add(float64(stats.Duplicates))
+	}
+}
+
 func (b bucketEntry) String() string {
 	return strconv.Itoa(int(atomic.LoadInt64(&timeBuckets[b])))
 }
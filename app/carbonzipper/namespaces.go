@@ -0,0 +1,123 @@
+package zipper
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/glob"
+)
+
+// namespace limits the metrics a backend is queried for, by the segments of
+// prefixes.
+type namespace struct {
+	allow [][]string
+	deny  [][]string
+}
+
+// newNamespaces resolves the namespaces of the config, keyed by the server
+// address of their backends. backends must be in the order of
+// config.GetBackends(), as returned by initBackends.
+func newNamespaces(config cfg.Zipper, backends []backend.Backend) (map[string]namespace, error) {
+	hosts := config.GetBackends()
+	namespaces := make(map[string]namespace, len(config.Routing.Namespaces))
+	for host, ns := range config.Routing.Namespaces {
+		i := 0
+		for i < len(hosts) && hosts[i] != host {
+			i++
+		}
+		if i == len(hosts) || i >= len(backends) {
+			return nil, fmt.Errorf("unknown backend '%s' in routing namespaces", host)
+		}
+
+		var n namespace
+		for _, prefixes := range []struct {
+			list []string
+			to   *[][]string
+		}{{ns.Allow, &n.allow}, {ns.Deny, &n.deny}} {
+			for _, prefix := range prefixes.list {
+				if prefix == "" || glob.IsGlob(prefix) {
+					return nil, fmt.Errorf("invalid namespace prefix '%s' of backend '%s'", prefix, host)
+				}
+				*prefixes.to = append(*prefixes.to, strings.Split(prefix, "."))
+			}
+		}
+		namespaces[backends[i].GetServerAddress()] = n
+	}
+
+	return namespaces, nil
+}
+
+// allows reports whether the target may have metrics in the namespace: if
+// it isn't under a denied prefix, and with allowed prefixes if it may match
+// names under one of them, as * does for a.b.
+func (ns namespace) allows(target string) bool {
+	segments := strings.Split(target, ".")
+	for _, prefix := range ns.deny {
+		if under(segments, prefix) {
+			return false
+		}
+	}
+	if len(ns.allow) == 0 {
+		return true
+	}
+	for _, prefix := range ns.allow {
+		if overlaps(segments, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// under reports whether all the names the segments match are under prefix.
+func under(segments, prefix []string) bool {
+	if len(segments) < len(prefix) {
+		return false
+	}
+	for i, p := range prefix {
+		if segments[i] != p {
+			return false
+		}
+	}
+
+	return true
+}
+
+// overlaps reports whether the segments may match names under prefix, or
+// its nodes.
+func overlaps(segments, prefix []string) bool {
+	for i := 0; i < len(segments) && i < len(prefix); i++ {
+		if !glob.Match(segments[i], prefix[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// filterNamespaces returns the backends whose namespaces allow any of the
+// targets.
+func filterNamespaces(backends []backend.Backend, namespaces map[string]namespace, targets []string) []backend.Backend {
+	if len(namespaces) == 0 {
+		return backends
+	}
+
+	bs := make([]backend.Backend, 0, len(backends))
+	for _, b := range backends {
+		ns, ok := namespaces[b.GetServerAddress()]
+		if !ok {
+			bs = append(bs, b)
+			continue
+		}
+		for _, target := range targets {
+			if ns.allows(target) {
+				bs = append(bs, b)
+				break
+			}
+		}
+	}
+
+	return bs
+}
@@ -0,0 +1,102 @@
+package zipper
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+func TestNamespaceAllows(t *testing.T) {
+	ns := namespace{
+		allow: [][]string{{"dc1", "web"}},
+		deny:  [][]string{{"dc1", "web", "tmp"}},
+	}
+
+	for target, want := range map[string]bool{
+		"dc1.web.cpu":     true,
+		"dc1.web":         true,
+		"dc1":             true,
+		"*":               true,
+		"dc1.*.cpu":       true,
+		"dc{1,2}.web.cpu": true,
+		"dc1.db.cpu":      false,
+		"dc2.web.cpu":     false,
+		"dc1.web.tmp.cpu": false,
+		"dc1.web.tmp":     false,
+		"dc1.web.t*.cpu":  true,
+	} {
+		if got := ns.allows(target); got != want {
+			t.Errorf("allows(%s) = %v, want %v", target, got, want)
+		}
+	}
+}
+
+func TestNewNamespaces(t *testing.T) {
+	config := cfg.DefaultZipperConfig()
+	config.Backends = []string{"http://a:8080", "http://b:8080"}
+	bs := []backend.Backend{
+		newNamedBackend("a", nil),
+		newNamedBackend("b", nil),
+	}
+
+	config.Routing.Namespaces = map[string]cfg.Namespace{
+		"http://b:8080": {Allow: []string{"dc1.web"}},
+	}
+	namespaces, err := newNamespaces(config, bs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]namespace{"b": {allow: [][]string{{"dc1", "web"}}}}; !reflect.DeepEqual(namespaces, want) {
+		t.Errorf("expected %+v, got %+v", want, namespaces)
+	}
+
+	invalid := []map[string]cfg.Namespace{
+		{"http://c:8080": {Allow: []string{"dc1"}}},
+		{"http://a:8080": {Allow: []string{""}}},
+		{"http://a:8080": {Deny: []string{"dc*"}}},
+	}
+	for _, ns := range invalid {
+		config.Routing.Namespaces = ns
+		if _, err := newNamespaces(config, bs); err == nil {
+			t.Errorf("expected error for namespaces %+v", ns)
+		}
+	}
+}
+
+func TestFilterBackendsByNamespace(t *testing.T) {
+	app, err := New(cfg.DefaultZipperConfig(), zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+	app.backends = []backend.Backend{
+		newNamedBackend("a", map[string][]types.Match{"*": {{Path: "dc1"}}}),
+		newNamedBackend("b", map[string][]types.Match{"*": {{Path: "dc1"}}}),
+	}
+	app.namespaces = map[string]namespace{
+		"a": {deny: [][]string{{"dc1", "db"}}},
+		"b": {allow: [][]string{{"dc1", "web"}}},
+	}
+	app.doProbe()
+
+	tests := []struct {
+		targets []string
+		want    []string
+	}{
+		{[]string{"dc1.web.cpu"}, []string{"a", "b"}},
+		{[]string{"dc1.db.cpu"}, nil},
+		{[]string{"dc1.app.cpu"}, []string{"a"}},
+		{[]string{"dc1.db.cpu", "dc1.web.cpu"}, []string{"a", "b"}},
+		{[]string{"unknown.metric"}, []string{"a"}},
+	}
+	for _, tst := range tests {
+		got := addresses(app.filterBackendsByPrefix(tst.targets))
+		if !reflect.DeepEqual(got, tst.want) {
+			t.Errorf("filterBackendsByPrefix(%v) = %v, want %v", tst.targets, got, tst.want)
+		}
+	}
+}
@@ -0,0 +1,227 @@
+package zipper
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+
+	"go.uber.org/zap"
+)
+
+// backendCloseInterval is how often a removed backend is checked for requests
+// still in flight before it is closed.
+const backendCloseInterval = time.Second
+
+// SetConfigFile sets the file the backends are reloaded from on SIGHUP and
+// on /admin/backends/reload, unless there is a remote config.
+func (app *App) SetConfigFile(configFile string) {
+	app.configFile = configFile
+}
+
+// reloadBackendsOnSignal reloads the backends on every SIGHUP, for the
+// lifetime of the app.
+func (app *App) reloadBackendsOnSignal() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGHUP)
+	for range ch {
+		if err := app.reloadBackends(); err != nil {
+			app.logger.Error("failed to reload backends",
+				zap.String("config_file", app.configFile),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+func (app *App) reloadBackendsFromFile() error {
+	if app.configFile == "" {
+		return errors.New("no config file to reload backends from")
+	}
+
+	fh, err := os.Open(app.configFile)
+	if err != nil {
+		return err
+	}
+	defer fh.Close()
+
+	config, err := cfg.ParseZipperConfig(fh)
+	if err != nil {
+		return fmt.Errorf("failed to parse config at %s: %w", app.configFile, err)
+	}
+	if app.config.Discovery.Type != "" {
+		// the backends are the discovered ones
+		app.backendsMu.RLock()
+		config = withBackends(config, app.config.Backends)
+		app.backendsMu.RUnlock()
+	}
+
+	return app.ReloadBackends(config)
+}
+
+// ReloadBackends replaces the backends and the routing rules by the ones of
+// config, then probes the backends for their domains. Backends that are kept,
+// in the same dc and cluster, are left as they are, so that the requests in
+// flight to them carry on. Removed backends are closed once idle. The other
+// settings of config take a restart.
+func (app *App) ReloadBackends(config cfg.Zipper) error {
+	if err := app.swapBackends(config); err != nil {
+		return err
+	}
+	app.doProbe()
+
+	return nil
+}
+
+func (app *App) swapBackends(config cfg.Zipper) error {
+	hosts := config.GetBackends()
+	if len(hosts) == 0 {
+		return errors.New("no backends")
+	}
+
+	app.probeMu.Lock()
+	defer app.probeMu.Unlock()
+
+	// The running config, with the backends of the new one
+	next := app.config
+	setBackendSettings(&next, config)
+
+	kept := make(map[string]int)
+	for i, host := range app.config.GetBackends() {
+		if i < len(app.backends) && sameBackend(app.config, next, host) {
+			kept[host] = i
+		}
+	}
+
+	var added []string
+	for _, host := range hosts {
+		if _, ok := kept[host]; !ok {
+			added = append(added, host)
+		}
+	}
+	created, err := initBackends(next, added, app.logger, app.prometheusMetrics)
+	if err != nil {
+		closeBackends(created)
+		return err
+	}
+
+	backends := make([]backend.Backend, 0, len(hosts))
+	tlds := make([][]string, 0, len(hosts))
+	used := make(map[int]bool)
+	pending := created
+	for _, host := range hosts {
+		i, ok := kept[host]
+		if !ok {
+			backends = append(backends, pending[0])
+			tlds = append(tlds, nil)
+			pending = pending[1:]
+			continue
+		}
+		backends = append(backends, app.backends[i])
+		if i < len(app.backendTLDs) {
+			tlds = append(tlds, app.backendTLDs[i])
+		} else {
+			tlds = append(tlds, nil)
+		}
+		used[i] = true
+	}
+
+	routes, err := newRoutes(next, backends)
+	if err != nil {
+		closeBackends(created)
+		return err
+	}
+	ring, err := newHashRing(next, backends)
+	if err != nil {
+		closeBackends(created)
+		return err
+	}
+	namespaces, err := newNamespaces(next, backends)
+	if err != nil {
+		closeBackends(created)
+		return err
+	}
+
+	var removed []backend.Backend
+	for i, b := range app.backends {
+		if !used[i] {
+			removed = append(removed, b)
+		}
+	}
+
+	app.backendsMu.Lock()
+	setBackendSettings(&app.config, next)
+	app.backends = backends
+	app.routes = routes
+	app.ring = ring
+	app.namespaces = namespaces
+	app.backendsMu.Unlock()
+
+	app.backendTLDs = tlds
+	app.setTLDCache()
+
+	for _, b := range removed {
+		go closeWhenIdle(b)
+	}
+
+	app.logger.Info("reloaded backends",
+		zap.Strings("added", added),
+		zap.Int("removed", len(removed)),
+		zap.Int("backends", len(backends)),
+	)
+
+	return nil
+}
+
+// sameBackend tells if host is in the same dc and cluster in both configs,
+// with the same rewrites.
+func sameBackend(old, next cfg.Zipper, host string) bool {
+	oldDC, oldCluster, _ := old.InfoOfBackend(host)
+	dc, cluster, err := next.InfoOfBackend(host)
+
+	return err == nil && dc == oldDC && cluster == oldCluster &&
+		reflect.DeepEqual(old.BackendRewrites[host], next.BackendRewrites[host])
+}
+
+func closeBackends(backends []backend.Backend) {
+	for _, b := range backends {
+		if mb, ok := b.(*monitoredBackend); ok {
+			mb.Close()
+		}
+	}
+}
+
+// closeWhenIdle closes a removed backend once no request is in flight to it.
+func closeWhenIdle(b backend.Backend) {
+	mb, ok := b.(*monitoredBackend)
+	if !ok {
+		return
+	}
+
+	ticker := time.NewTicker(backendCloseInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		if mb.idle() {
+			mb.Close()
+			return
+		}
+	}
+}
+
+// backendsReloadHandler reloads the backends from the remote config or the
+// config file and reports their state.
+func (app *App) backendsReloadHandler(w http.ResponseWriter, req *http.Request) {
+	if err := app.reloadBackends(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	app.backendsStatusHandler(w, req)
+}
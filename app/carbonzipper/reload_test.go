@@ -0,0 +1,80 @@
+package zipper
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"go.uber.org/zap"
+)
+
+func TestReloadBackends(t *testing.T) {
+	config := cfg.DefaultZipperConfig()
+	config.Backends = []string{"http://127.0.0.1:1", "http://127.0.0.1:2"}
+	app, err := New(config, zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+	kept := app.backends[0]
+
+	config.Backends = []string{"http://127.0.0.1:1", "http://127.0.0.1:3"}
+	config.Routing.Rules = []cfg.RoutingRule{{Prefix: "foo", Backends: []string{"http://127.0.0.1:3"}}}
+	if err := app.ReloadBackends(config); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(app.backends) != 2 {
+		t.Fatalf("expected 2 backends, got %d", len(app.backends))
+	}
+	if app.backends[0] != kept {
+		t.Error("expected the kept backend to be left as it is")
+	}
+	if got := app.backends[1].GetServerAddress(); got != "127.0.0.1:3" {
+		t.Errorf("expected the added backend, got %s", got)
+	}
+	if got := addresses(app.filterBackendsByPrefix([]string{"foo.bar"})); len(got) != 1 || got[0] != "127.0.0.1:3" {
+		t.Errorf("expected foo to be routed to the added backend, got %v", got)
+	}
+
+	config.Routing.Rules = []cfg.RoutingRule{{Prefix: "foo", Backends: []string{"http://127.0.0.1:4"}}}
+	if err := app.ReloadBackends(config); err == nil {
+		t.Error("expected an error for a rule with an unknown backend")
+	}
+	if len(app.backends) != 2 || app.backends[0] != kept {
+		t.Error("expected the backends to be unchanged after a failed reload")
+	}
+}
+
+func TestBackendsReloadHandler(t *testing.T) {
+	config := cfg.DefaultZipperConfig()
+	config.Backends = []string{"http://127.0.0.1:1"}
+	app, err := New(config, zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "carbonzipper.yaml")
+	yaml := "backends:\n  - \"http://127.0.0.1:1\"\n  - \"http://127.0.0.1:2\"\n"
+	if err := ioutil.WriteFile(configFile, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	app.SetConfigFile(configFile)
+
+	w := httptest.NewRecorder()
+	initMetricHandlers(app).ServeHTTP(w, httptest.NewRequest("POST", "/admin/backends/reload", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got code %d expected %d", w.Code, http.StatusOK)
+	}
+
+	var statuses []backendStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &statuses); err != nil {
+		t.Fatalf("error decoding status: %v", err)
+	}
+	if len(statuses) != 2 {
+		t.Errorf("expected 2 backends after reload, got %d", len(statuses))
+	}
+}
@@ -0,0 +1,211 @@
+package zipper
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+
+	"go.uber.org/zap"
+)
+
+// maxRemoteConfigSize is the size of the largest remote config read.
+const maxRemoteConfigSize = 16 << 20
+
+// remoteConfig fetches the backends and the routing rules from a config
+// served over HTTP.
+type remoteConfig struct {
+	url    string
+	client *http.Client
+
+	// mu serializes the reloads from the remote config, and guards
+	// version, that of the config last applied: its ETag, or the hash of
+	// its content if it has none.
+	mu      sync.Mutex
+	version string
+}
+
+// newRemoteConfig returns the remote config of config, nil if there is none.
+func newRemoteConfig(config cfg.RemoteConfig) (*remoteConfig, error) {
+	if config.URL == "" {
+		return nil, nil
+	}
+
+	if config.Interval <= 0 {
+		return nil, fmt.Errorf("invalid remote config interval %s, it must be positive", config.Interval)
+	}
+
+	u, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid remote config URL %s: %w", config.URL, err)
+	}
+	switch u.Scheme {
+	case "http", "https":
+	case "s3":
+		if u.Host == "" || u.Path == "" {
+			return nil, fmt.Errorf("invalid remote config URL %s: expected s3://bucket/key", config.URL)
+		}
+		u = &url.URL{Scheme: "https", Host: u.Host + ".s3.amazonaws.com", Path: u.Path}
+	default:
+		return nil, fmt.Errorf("invalid remote config URL %s: unsupported scheme %q", config.URL, u.Scheme)
+	}
+
+	return &remoteConfig{
+		url:    u.String(),
+		client: &http.Client{Timeout: config.Timeout},
+	}, nil
+}
+
+// fetch fetches the config, and reports whether it changed since the one of
+// version. It asks the server not to send it again if version is an ETag.
+func (rc *remoteConfig) fetch(ctx context.Context, version string) (cfg.Zipper, string, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rc.url, nil)
+	if err != nil {
+		return cfg.Zipper{}, "", false, err
+	}
+	if version != "" && !isContentVersion(version) {
+		req.Header.Set("If-None-Match", version)
+	}
+
+	resp, err := rc.client.Do(req)
+	if err != nil {
+		return cfg.Zipper{}, "", false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotModified {
+		return cfg.Zipper{}, version, false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return cfg.Zipper{}, "", false, fmt.Errorf("fetching %s: %s", rc.url, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize+1))
+	if err != nil {
+		return cfg.Zipper{}, "", false, err
+	}
+	if len(body) > maxRemoteConfigSize {
+		return cfg.Zipper{}, "", false, fmt.Errorf("config at %s is larger than %d bytes", rc.url, maxRemoteConfigSize)
+	}
+	next := resp.Header.Get("ETag")
+	if next == "" {
+		sum := sha256.Sum256(body)
+		next = contentVersionPrefix + hex.EncodeToString(sum[:])
+	}
+	if next == version {
+		return cfg.Zipper{}, version, false, nil
+	}
+
+	config, err := cfg.ParseZipperConfig(bytes.NewReader(body))
+	if err != nil {
+		return cfg.Zipper{}, "", false, fmt.Errorf("failed to parse config at %s: %w", rc.url, err)
+	}
+	if len(config.GetBackends()) == 0 {
+		return cfg.Zipper{}, "", false, fmt.Errorf("config at %s has no backends", rc.url)
+	}
+
+	return config, next, true, nil
+}
+
+// contentVersionPrefix tells the versions that are hashes of the content
+// from ETags.
+const contentVersionPrefix = "sha256:"
+
+func isContentVersion(version string) bool {
+	return strings.HasPrefix(version, contentVersionPrefix)
+}
+
+// setBackendSettings sets the backends and the routing rules of config to
+// the ones of from, the settings that are reloaded without a restart.
+func setBackendSettings(config *cfg.Zipper, from cfg.Zipper) {
+	config.Backends = from.Backends
+	config.BackendsByCluster = from.BackendsByCluster
+	config.BackendsByDC = from.BackendsByDC
+	config.BackendEndpointProtocols = from.BackendEndpointProtocols
+	config.BackendRewrites = from.BackendRewrites
+	config.Routing.Rules = from.Routing.Rules
+	config.Routing.Hash = from.Routing.Hash
+	config.Routing.Namespaces = from.Routing.Namespaces
+}
+
+// fetchRemoteConfig replaces the backend settings of config by the ones of
+// the remote config, if there is one. If it cannot be fetched, config is
+// kept until it is reloaded from the remote config.
+func fetchRemoteConfig(config cfg.Zipper, rc *remoteConfig, logger *zap.Logger) cfg.Zipper {
+	if rc == nil {
+		return config
+	}
+
+	remote, version, _, err := rc.fetch(context.Background(), "")
+	if err != nil {
+		logger.Warn("failed to fetch the remote config, starting with the backends of the local config",
+			zap.String("url", rc.url),
+			zap.Error(err),
+		)
+		return config
+	}
+	rc.version = version
+	setBackendSettings(&config, remote)
+
+	return config
+}
+
+// reloadBackendsFromRemote swaps the backends and the routing rules for the
+// ones of the remote config, if it changed since it was last applied.
+func (app *App) reloadBackendsFromRemote() error {
+	rc := app.remoteConfig
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	config, version, changed, err := rc.fetch(context.Background(), rc.version)
+	if err != nil || !changed {
+		return err
+	}
+	if err := app.ReloadBackends(config); err != nil {
+		return err
+	}
+	rc.version = version
+
+	app.logger.Info("applied remote config",
+		zap.String("url", rc.url),
+		zap.String("version", version),
+	)
+
+	return nil
+}
+
+// watchRemoteConfig reloads the backends from the remote config every
+// interval, for the lifetime of the app.
+func (app *App) watchRemoteConfig() {
+	ticker := time.NewTicker(app.config.RemoteConfig.Interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := app.reloadBackendsFromRemote(); err != nil {
+			app.logger.Error("failed to reload backends from the remote config",
+				zap.String("url", app.remoteConfig.url),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// reloadBackends reloads the backends from the remote config if there is
+// one, from the config file otherwise.
+func (app *App) reloadBackends() error {
+	if app.remoteConfig != nil {
+		return app.reloadBackendsFromRemote()
+	}
+
+	return app.reloadBackendsFromFile()
+}
@@ -0,0 +1,181 @@
+package zipper
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"go.uber.org/zap"
+)
+
+func TestNewRemoteConfig(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"", ""},
+		{"https://config.example.com/carbonzipper.yaml", "https://config.example.com/carbonzipper.yaml"},
+		{"s3://configs/zipper/dc1.yaml", "https://configs.s3.amazonaws.com/zipper/dc1.yaml"},
+	}
+
+	for _, tt := range tests {
+		rc, err := newRemoteConfig(cfg.RemoteConfig{URL: tt.url, Interval: time.Minute})
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.url, err)
+			continue
+		}
+		got := ""
+		if rc != nil {
+			got = rc.url
+		}
+		if got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.url, tt.expected, got)
+		}
+	}
+
+	for _, u := range []string{"ftp://config.example.com/zipper.yaml", "s3://configs"} {
+		if _, err := newRemoteConfig(cfg.RemoteConfig{URL: u, Interval: time.Minute}); err == nil {
+			t.Errorf("%s: expected an error", u)
+		}
+	}
+	if _, err := newRemoteConfig(cfg.RemoteConfig{URL: "http://config.example.com/zipper.yaml"}); err == nil {
+		t.Error("expected an error without an interval")
+	}
+}
+
+func TestRemoteConfigFetch(t *testing.T) {
+	var fetches int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("backends:\n  - \"http://127.0.0.1:1\"\n"))
+	}))
+	defer server.Close()
+
+	rc, err := newRemoteConfig(cfg.RemoteConfig{URL: server.URL, Interval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, version, changed, err := rc.fetch(context.Background(), "")
+	if err != nil || !changed || version != `"v1"` {
+		t.Fatalf("expected the config at version \"v1\", got %s, %v (%v)", version, changed, err)
+	}
+	if got := config.GetBackends(); len(got) != 1 || got[0] != "http://127.0.0.1:1" {
+		t.Errorf("unexpected backends %v", got)
+	}
+
+	if _, _, changed, err := rc.fetch(context.Background(), version); err != nil || changed {
+		t.Errorf("expected the config not to change, got %v (%v)", changed, err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 2 {
+		t.Errorf("expected 2 fetches, got %d", n)
+	}
+}
+
+func TestRemoteConfigFetchWithoutETag(t *testing.T) {
+	body := "backends:\n  - \"http://127.0.0.1:1\"\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" {
+			t.Error("unexpected If-None-Match without an ETag")
+		}
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	rc, err := newRemoteConfig(cfg.RemoteConfig{URL: server.URL, Interval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, version, changed, err := rc.fetch(context.Background(), "")
+	if err != nil || !changed {
+		t.Fatalf("expected the config, got %v (%v)", changed, err)
+	}
+	if _, _, changed, err := rc.fetch(context.Background(), version); err != nil || changed {
+		t.Errorf("expected the same content not to change, got %v (%v)", changed, err)
+	}
+
+	body = "backends: []\n"
+	if _, _, _, err := rc.fetch(context.Background(), version); err == nil {
+		t.Error("expected an error for a config without backends")
+	}
+}
+
+func TestReloadBackendsFromRemote(t *testing.T) {
+	body := "backends:\n  - \"http://127.0.0.1:1\"\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+
+	config := cfg.DefaultZipperConfig()
+	config.RemoteConfig.URL = server.URL
+	app, err := New(config, zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+	if len(app.backends) != 1 {
+		t.Fatalf("expected the backend of the remote config, got %d backends", len(app.backends))
+	}
+
+	body = "backends:\n  - \"http://127.0.0.1:1\"\n  - \"http://127.0.0.1:2\"\n"
+	if err := app.reloadBackends(); err != nil {
+		t.Fatal(err)
+	}
+	if len(app.backends) != 2 {
+		t.Errorf("expected 2 backends after reload, got %d", len(app.backends))
+	}
+
+	body = "backends:\n  - \"http://127.0.0.1:1\"\nrouting:\n  rules:\n    - prefix: \"foo\"\n      backends: [\"http://127.0.0.1:3\"]\n"
+	if err := app.reloadBackends(); err == nil {
+		t.Error("expected an error for a rule with an unknown backend")
+	}
+	if len(app.backends) != 2 {
+		t.Error("expected the backends to be unchanged after a failed reload")
+	}
+}
+
+func TestRemoteConfigFetchTooLarge(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("backends:\n  - \"http://127.0.0.1:1\"\n"))
+		_, _ = w.Write(make([]byte, maxRemoteConfigSize))
+	}))
+	defer server.Close()
+
+	rc, err := newRemoteConfig(cfg.RemoteConfig{URL: server.URL, Interval: time.Minute})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := rc.fetch(context.Background(), ""); err == nil {
+		t.Error("expected an error for a config larger than the maximum size")
+	}
+}
+
+func TestFetchRemoteConfigUnreachable(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	rc, err := newRemoteConfig(cfg.RemoteConfig{URL: server.URL, Interval: time.Minute, Timeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local := cfg.Zipper{Common: cfg.Common{Backends: []string{"http://local:8080"}}}
+	config := fetchRemoteConfig(local, rc, zap.NewNop())
+	if got := config.GetBackends(); len(got) != 1 || got[0] != "http://local:8080" {
+		t.Errorf("expected the backends of the local config, got %v", got)
+	}
+	if rc.version != "" {
+		t.Errorf("expected no version applied, got %q", rc.version)
+	}
+}
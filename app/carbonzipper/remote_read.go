@@ -0,0 +1,162 @@
+package zipper
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/promql"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/prometheus"
+	"github.com/bookingcom/carbonapi/util"
+	"go.uber.org/zap"
+)
+
+// samples returns the known values of metric between the unix times in
+// milliseconds start and end.
+func samples(metric types.Metric, start, end int64) []prometheus.Sample {
+	var res []prometheus.Sample
+	for i, v := range metric.Values {
+		if metric.IsAbsent.Get(i) || math.IsNaN(v) {
+			continue
+		}
+		ts := int64(metric.StartTime+int32(i)*metric.StepTime) * 1000
+		if ts < start || ts > end {
+			continue
+		}
+		res = append(res, prometheus.Sample{Value: v, Timestamp: ts})
+	}
+
+	return res
+}
+
+// remoteRead answers a query of a remote read request with the series of
+// the metrics of its glob, which its matchers select.
+func (app *App) remoteRead(ctx context.Context, q prometheus.Query, req *http.Request, logger *zap.Logger) ([]prometheus.Series, error) {
+	glob := app.remoteReadTemplate.Glob(q.Matchers)
+	// round the range out to whole seconds
+	from, until := q.Start/1000, (q.End+999)/1000
+
+	request := types.NewRenderRequest([]string{glob}, from, until)
+	request.Trace.OutDuration = app.prometheusMetrics.RenderOutDurationExp
+	bs := app.filterBackendsForMetrics(request.Targets)
+	bs = backend.Filter(bs, request.Targets)
+	metrics, stats, errs := backend.Renders(ctx, bs, request, app.config.RenderReplicaMismatchConfig, logger)
+	app.prometheusMetrics.Renders.Add(float64(stats.DataPointCount))
+	app.countSanitized(stats.Sanitized)
+	app.countBackendErrors("remote_read", errs)
+	err := errorsFanIn(errs, len(bs), app.responsePolicy(req))
+	var notFound types.ErrNotFound
+	if errors.As(err, &notFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var series []prometheus.Series
+	for _, m := range metrics {
+		labels, ok := app.remoteReadTemplate.Labels(m.Name)
+		if !ok {
+			continue
+		}
+		ok, err := promql.Selects(q.Matchers, labels)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		series = append(series, prometheus.Series{Labels: labels, Samples: samples(m, q.Start, q.End)})
+	}
+
+	return series, nil
+}
+
+// remoteReadHandler implements the remote read protocol of Prometheus, for
+// it to query the metrics of the backends as series, whose labels are the
+// nodes of their paths.
+func (app *App) remoteReadHandler(w http.ResponseWriter, req *http.Request, logger *zap.Logger) {
+	t0 := time.Now()
+
+	ctx, cancel := context.WithTimeout(req.Context(), app.config.Timeouts.Global)
+	defer cancel()
+
+	Metrics.Requests.Add(1)
+	app.prometheusMetrics.Requests.Inc()
+
+	logger = logger.With(
+		zap.String("handler", "remote_read"),
+		zap.String("carbonapi_uuid", util.GetUUID(ctx)),
+	)
+
+	fail := func(code int, reason string, err error) {
+		http.Error(w, reason+": "+err.Error(), code)
+		logger.Error("request failed",
+			zap.String("reason", reason),
+			zap.Int("http_code", code),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(err),
+		)
+		Metrics.Errors.Add(1)
+		app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(code), "remote_read").Inc()
+	}
+
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		fail(http.StatusBadRequest, "failed to read the request", err)
+		return
+	}
+	queries, err := prometheus.ReadRequestDecoder(body)
+	if err != nil {
+		fail(http.StatusBadRequest, "failed to decode the request", err)
+		return
+	}
+
+	results := make([][]prometheus.Series, 0, len(queries))
+	for _, q := range queries {
+		series, err := app.remoteRead(ctx, q, req, logger)
+		if err != nil {
+			code := http.StatusInternalServerError
+			if c, _, ok := clientError(err); ok {
+				code = c
+			}
+			fail(code, "error fetching the data", err)
+			return
+		}
+		results = append(results, series)
+	}
+
+	blob, err := prometheus.ReadResponseEncoder(results)
+	if err != nil {
+		fail(http.StatusInternalServerError, "error marshaling data", err)
+		return
+	}
+
+	w.Header().Set("Content-Type", prometheus.ContentType)
+	w.Header().Set("Content-Encoding", prometheus.ContentEncoding)
+	_, writeErr := w.Write(blob)
+
+	Metrics.Responses.Add(1)
+	app.prometheusMetrics.Responses.WithLabelValues(strconv.Itoa(http.StatusOK), "remote_read").Inc()
+
+	if writeErr != nil {
+		logger.Error("error writing the response",
+			zap.Int("http_code", 499),
+			zap.Duration("runtime_seconds", time.Since(t0)),
+			zap.Error(writeErr),
+		)
+		return
+	}
+
+	logger.Info("request served",
+		zap.Int("http_code", http.StatusOK),
+		zap.Int("queries", len(queries)),
+		zap.Duration("runtime_seconds", time.Since(t0)),
+	)
+}
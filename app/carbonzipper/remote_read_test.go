@@ -0,0 +1,90 @@
+package zipper
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/prometheus"
+
+	"go.uber.org/zap"
+)
+
+func TestRemoteReadHandler(t *testing.T) {
+	logger := zap.NewNop()
+	config := cfg.DefaultZipperConfig()
+	config.RemoteRead.PathTemplate = "servers.{host}.{__name__}"
+	app, err := New(config, logger, "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+
+	var targets []string
+	app.backends = []backend.Backend{mock.New(mock.Config{
+		Render: func(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+			targets = request.Targets
+			return []types.Metric{
+				{
+					Name:      "servers.a.cpu",
+					StartTime: 60,
+					StopTime:  240,
+					StepTime:  60,
+					Values:    []float64{1, 0, 3},
+					IsAbsent:  types.AbsenceOf(false, true, false),
+				},
+				{
+					Name:      "servers.b.cpu",
+					StartTime: 60,
+					StopTime:  240,
+					StepTime:  60,
+					Values:    []float64{4, 5, 6},
+					IsAbsent:  types.AbsenceOf(false, false, false),
+				},
+			}, nil
+		},
+	})}
+
+	body, err := prometheus.ReadRequestEncoder([]prometheus.Query{{
+		Start: 60000,
+		End:   180000,
+		Matchers: []prometheus.Matcher{
+			{Type: prometheus.MatchEqual, Name: "__name__", Value: "cpu"},
+			{Type: prometheus.MatchNotEqual, Name: "host", Value: "b"},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := httptest.NewRequest("POST", "/api/v1/read", bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	app.remoteReadHandler(w, req, logger)
+	if w.Code != http.StatusOK {
+		t.Fatalf("got code %d expected %d: %s", w.Code, http.StatusOK, w.Body.String())
+	}
+	if w.Header().Get("Content-Encoding") != "snappy" {
+		t.Errorf("expected a snappy response, got %q", w.Header().Get("Content-Encoding"))
+	}
+	if len(targets) != 1 || targets[0] != "servers.*.cpu" {
+		t.Errorf("expected the backends to render servers.*.cpu, got %v", targets)
+	}
+
+	got, err := prometheus.ReadResponseDecoder(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]prometheus.Series{{{
+		Labels:  []prometheus.Label{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "a"}},
+		Samples: []prometheus.Sample{{Value: 1, Timestamp: 60000}, {Value: 3, Timestamp: 180000}},
+	}}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
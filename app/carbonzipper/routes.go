@@ -19,20 +19,31 @@ func initHandlers(app *App, logger *zap.Logger) http.Handler {
 
 	r.Use(util.UUIDHandler)
 	r.Use(muxtrace.Middleware("carbonzipper"))
+	r.Use(compressHandler(app.config.Compression, app.prometheusMetrics))
+	r.Use(carbonAPIV3Requests)
 
 	r.HandleFunc("/metrics/find/", httputil.TrackConnections(httputil.TimeHandler(handlerlog.WithLogger(app.findHandler, logger), app.bucketRequestTimes)))
 	r.HandleFunc("/render/", httputil.TrackConnections(httputil.TimeHandler(handlerlog.WithLogger(app.renderHandler, logger), app.bucketRequestTimes)))
 	r.HandleFunc("/info/", httputil.TrackConnections(httputil.TimeHandler(handlerlog.WithLogger(app.infoHandler, logger), app.bucketRequestTimes)))
 	r.HandleFunc("/lb_check", handlerlog.WithLogger(app.lbCheckHandler, logger))
+	r.HandleFunc("/_internal/capabilities/", handlerlog.WithLogger(app.capabilitiesHandler, logger))
+	if app.remoteReadTemplate != nil {
+		r.HandleFunc("/api/v1/read", httputil.TrackConnections(httputil.TimeHandler(handlerlog.WithLogger(app.remoteReadHandler, logger), app.bucketRequestTimes))).Methods("POST")
+	}
 
 	return r
 }
 
-func initMetricHandlers() http.Handler {
+func initMetricHandlers(app *App) http.Handler {
 	r := mux.NewRouter()
 
 	r.Handle("/metrics", promhttp.Handler())
 
+	r.HandleFunc("/admin/tld", app.tldStatusHandler).Methods("GET")
+	r.HandleFunc("/admin/tld/refresh", app.tldRefreshHandler).Methods("POST")
+	r.HandleFunc("/admin/backends", app.backendsStatusHandler).Methods("GET")
+	r.HandleFunc("/admin/backends/reload", app.backendsReloadHandler).Methods("POST")
+
 	r.Handle("/debug/vars", expvar.Handler())
 	r.PathPrefix("/debug/pprof").HandlerFunc(pprof.Index)
 
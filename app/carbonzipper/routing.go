@@ -0,0 +1,192 @@
+package zipper
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+)
+
+// route is an explicit routing rule, resolved to the backends it sends
+// queries to.
+type route struct {
+	prefix   string
+	backends []backend.Backend
+}
+
+// newRoutes resolves the routing rules of the config. backends must be in the
+// order of config.GetBackends(), as returned by initBackends.
+func newRoutes(config cfg.Zipper, backends []backend.Backend) ([]route, error) {
+	if config.Routing.PrefixDepth < 0 {
+		return nil, fmt.Errorf("invalid routing prefix depth %d", config.Routing.PrefixDepth)
+	}
+
+	routes := make([]route, 0, len(config.Routing.Rules))
+	for _, rule := range config.Routing.Rules {
+		if rule.Prefix == "" || strings.ContainsAny(rule.Prefix, "*?{}[]") {
+			return nil, fmt.Errorf("invalid routing prefix '%s'", rule.Prefix)
+		}
+
+		bs, err := resolveBackends(config, backends, rule.Backends, rule.Clusters)
+		if err != nil {
+			return nil, fmt.Errorf("%v in routing rule for '%s'", err, rule.Prefix)
+		}
+		routes = append(routes, route{prefix: rule.Prefix, backends: bs})
+	}
+
+	// The longest matching prefix wins, so try the longest ones first.
+	sort.SliceStable(routes, func(i, j int) bool {
+		return len(routes[i].prefix) > len(routes[j].prefix)
+	})
+
+	return routes, nil
+}
+
+// resolveBackends returns the backends that are listed by name or are in one
+// of the clusters, in the order of backends, which must be the one of
+// config.GetBackends().
+func resolveBackends(config cfg.Zipper, backends []backend.Backend, names, clusters []string) ([]backend.Backend, error) {
+	hosts := config.GetBackends()
+	matched := make(map[int]bool)
+	for _, b := range names {
+		found := false
+		for i, host := range hosts {
+			if host == b {
+				matched[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown backend '%s'", b)
+		}
+	}
+	for _, c := range clusters {
+		found := false
+		for i, host := range hosts {
+			if _, cluster, _ := config.InfoOfBackend(host); cluster == c {
+				matched[i] = true
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown cluster '%s'", c)
+		}
+	}
+	if len(matched) == 0 {
+		return nil, errors.New("no backends")
+	}
+
+	var bs []backend.Backend
+	for i := range backends {
+		if matched[i] {
+			bs = append(bs, backends[i])
+		}
+	}
+
+	return bs, nil
+}
+
+// prefixDepth is the number of leading path segments used for routing.
+func (app *App) prefixDepth() int {
+	if app.config.Routing.PrefixDepth < 1 {
+		return 1
+	}
+	return app.config.Routing.PrefixDepth
+}
+
+// routingPrefix returns the leading segments of target that can be used for
+// routing: at most depth of them, and none from the first glob on.
+func routingPrefix(target string, depth int) []string {
+	segments := strings.SplitN(target, ".", depth+1)
+	if len(segments) > depth {
+		segments = segments[:depth]
+	}
+	for i, s := range segments {
+		if strings.ContainsAny(s, "*?{}[]") {
+			return segments[:i]
+		}
+	}
+	return segments
+}
+
+// matchRoute returns the routing rule of routes for target, if any.
+func matchRoute(routes []route, target string) (route, bool) {
+	for _, r := range routes {
+		if target == r.prefix || strings.HasPrefix(target, r.prefix+".") {
+			return r, true
+		}
+	}
+	return route{}, false
+}
+
+// filterBackendsByPrefix returns the backends that can have metrics for the
+// targets. Targets matching a routing rule go to the backends of the rule,
+// the others go to the backends that have the longest probed prefix of the
+// target. If none of the targets can be routed, all backends are returned.
+// Backends whose namespaces allow none of the targets are left out.
+func (app *App) filterBackendsByPrefix(targets []string) []backend.Backend {
+	return app.filterBackends(targets, false)
+}
+
+// filterBackendsForMetrics is filterBackendsByPrefix for targets that are
+// metrics, not nodes of the tree, such as those of render and info: with hash
+// routing, those that aren't globs go to the shards that own them.
+func (app *App) filterBackendsForMetrics(targets []string) []backend.Backend {
+	return app.filterBackends(targets, true)
+}
+
+func (app *App) filterBackends(targets []string, metrics bool) []backend.Backend {
+	var prefixCache map[string][]*backend.Backend
+	if x, ok := app.topLevelDomainCache.Get("tlds"); ok {
+		prefixCache, _ = x.(map[string][]*backend.Backend)
+	}
+
+	app.backendsMu.RLock()
+	backends, routes, ring, namespaces := app.backends, app.routes, app.ring, app.namespaces
+	app.backendsMu.RUnlock()
+
+	depth := app.prefixDepth()
+	bs := make([]backend.Backend, 0)
+	alreadyAddedBackends := make(map[string]bool)
+	add := func(b backend.Backend) {
+		if !alreadyAddedBackends[b.GetServerAddress()] {
+			alreadyAddedBackends[b.GetServerAddress()] = true
+			bs = append(bs, b)
+		}
+	}
+
+	for _, target := range targets {
+		if r, ok := matchRoute(routes, target); ok {
+			for _, b := range r.backends {
+				add(b)
+			}
+			continue
+		}
+		if metrics && ring != nil && !strings.ContainsAny(target, "*?{}[]") {
+			for _, b := range ring.backends(target) {
+				add(b)
+			}
+			continue
+		}
+
+		segments := routingPrefix(target, depth)
+		for n := len(segments); n > 0; n-- {
+			prefixBackends, ok := prefixCache[strings.Join(segments[:n], ".")]
+			if !ok {
+				continue
+			}
+			for _, b := range prefixBackends {
+				add(*b)
+			}
+			break
+		}
+	}
+
+	if len(bs) == 0 {
+		bs = backends
+	}
+	return filterNamespaces(bs, namespaces, targets)
+}
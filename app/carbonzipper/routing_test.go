@@ -0,0 +1,154 @@
+package zipper
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"go.uber.org/zap"
+)
+
+// namedBackend is a mock backend with a distinct server address.
+type namedBackend struct {
+	mock.Backend
+	address string
+}
+
+func (b namedBackend) GetServerAddress() string {
+	return b.address
+}
+
+func newNamedBackend(address string, tree map[string][]types.Match) backend.Backend {
+	return namedBackend{
+		Backend: mock.New(mock.Config{
+			Find: func(_ context.Context, request types.FindRequest) (types.Matches, error) {
+				return types.Matches{Name: request.Query, Matches: tree[request.Query]}, nil
+			},
+		}),
+		address: address,
+	}
+}
+
+func addresses(bs []backend.Backend) []string {
+	var addrs []string
+	for _, b := range bs {
+		addrs = append(addrs, b.GetServerAddress())
+	}
+	return addrs
+}
+
+func TestRoutingPrefix(t *testing.T) {
+	tests := []struct {
+		target string
+		depth  int
+		want   []string
+	}{
+		{"dc1.host.cpu", 1, []string{"dc1"}},
+		{"dc1.host.cpu", 2, []string{"dc1", "host"}},
+		{"dc1.host", 3, []string{"dc1", "host"}},
+		{"dc1.h*.cpu", 2, []string{"dc1"}},
+		{"dc{1,2}.host.cpu", 2, []string{}},
+	}
+
+	for _, tst := range tests {
+		got := routingPrefix(tst.target, tst.depth)
+		if len(got) != len(tst.want) || (len(got) > 0 && !reflect.DeepEqual(got, tst.want)) {
+			t.Errorf("routingPrefix(%q, %d) = %v, want %v", tst.target, tst.depth, got, tst.want)
+		}
+	}
+}
+
+func TestNewRoutes(t *testing.T) {
+	config := cfg.DefaultZipperConfig()
+	config.BackendsByCluster = []cfg.Cluster{
+		{Name: "c1", Backends: []string{"http://a:8080", "http://b:8080"}},
+		{Name: "c2", Backends: []string{"http://c:8080"}},
+	}
+	bs := []backend.Backend{
+		newNamedBackend("a", nil),
+		newNamedBackend("b", nil),
+		newNamedBackend("c", nil),
+	}
+
+	config.Routing.Rules = []cfg.RoutingRule{
+		{Prefix: "dc1", Clusters: []string{"c1"}},
+		{Prefix: "dc1.special", Backends: []string{"http://c:8080"}},
+	}
+	routes, err := newRoutes(config, bs)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(routes) != 2 || routes[0].prefix != "dc1.special" {
+		t.Fatalf("expected the longest prefix first, got %+v", routes)
+	}
+	if got := addresses(routes[1].backends); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("expected cluster c1 to resolve to [a b], got %v", got)
+	}
+
+	invalid := [][]cfg.RoutingRule{
+		{{Prefix: "", Clusters: []string{"c1"}}},
+		{{Prefix: "dc*", Clusters: []string{"c1"}}},
+		{{Prefix: "dc1"}},
+		{{Prefix: "dc1", Clusters: []string{"c3"}}},
+		{{Prefix: "dc1", Backends: []string{"http://d:8080"}}},
+	}
+	for _, rules := range invalid {
+		config.Routing.Rules = rules
+		if _, err := newRoutes(config, bs); err == nil {
+			t.Errorf("expected error for rules %+v", rules)
+		}
+	}
+}
+
+func TestFilterBackendsByPrefix(t *testing.T) {
+	config := cfg.DefaultZipperConfig()
+	config.Routing.PrefixDepth = 2
+
+	app, err := New(config, zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+
+	app.backends = []backend.Backend{
+		newNamedBackend("a", map[string][]types.Match{
+			"*":   {{Path: "dc1"}, {Path: "leaf", IsLeaf: true}},
+			"*.*": {{Path: "dc1.web"}},
+		}),
+		newNamedBackend("b", map[string][]types.Match{
+			"*":   {{Path: "dc1"}},
+			"*.*": {{Path: "dc1.db"}},
+		}),
+		newNamedBackend("c", map[string][]types.Match{
+			"*": {{Path: "dc2", IsLeaf: true}},
+		}),
+	}
+	app.routes = []route{
+		{prefix: "dc3.db", backends: app.backends[2:]},
+	}
+	app.doProbe()
+
+	tests := []struct {
+		targets []string
+		want    []string
+	}{
+		{[]string{"dc1.web.cpu"}, []string{"a"}},
+		{[]string{"dc1.db.cpu"}, []string{"b"}},
+		{[]string{"dc1.*.cpu"}, []string{"a", "b"}},
+		{[]string{"dc1.other.cpu"}, []string{"a", "b"}},
+		{[]string{"dc1.web.cpu", "dc2"}, []string{"a", "c"}},
+		{[]string{"dc3.db.cpu"}, []string{"c"}},
+		{[]string{"leaf"}, []string{"a"}},
+		{[]string{"unknown.metric"}, []string{"a", "b", "c"}},
+	}
+
+	for _, tst := range tests {
+		got := addresses(app.filterBackendsByPrefix(tst.targets))
+		if !reflect.DeepEqual(got, tst.want) {
+			t.Errorf("filterBackendsByPrefix(%v) = %v, want %v", tst.targets, got, tst.want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package zipper
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// tldProbeTimeout bounds each find request sent to a backend by the probe.
+const tldProbeTimeout = 5 * time.Second
+
+// tldStatus is the response of the TLD admin endpoints.
+type tldStatus struct {
+	LastRefresh int64               `json:"lastRefresh"`
+	AgeSeconds  int64               `json:"ageSeconds"`
+	Domains     map[string][]string `json:"domains"`
+}
+
+// probeTopLevelDomains refreshes the TLD cache every InternalRoutingCache
+// seconds, for the lifetime of the app.
+func (app *App) probeTopLevelDomains() {
+	app.doProbe()
+	probeTicker := time.NewTicker(time.Duration(app.config.InternalRoutingCache) * time.Second)
+	for range probeTicker.C {
+		app.doProbe()
+	}
+}
+
+// doProbe asks all backends for their prefixes, down to the configured
+// routing depth, and replaces the TLD cache. A backend that fails to answer
+// keeps the prefixes it had last time, so a transient error does not take it
+// out of routing.
+func (app *App) doProbe() {
+	app.probeMu.Lock()
+	defer app.probeMu.Unlock()
+
+	if len(app.backendTLDs) != len(app.backends) {
+		app.backendTLDs = make([][]string, len(app.backends))
+	}
+
+	var wg sync.WaitGroup
+	for i := range app.backends {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tlds, err := getPrefixes(app.backends[i], app.prefixDepth())
+			if err != nil {
+				app.prometheusMetrics.TLDProbeErrors.WithLabelValues(app.backends[i].GetServerAddress()).Inc()
+				return
+			}
+			app.backendTLDs[i] = tlds
+		}(i)
+	}
+	wg.Wait()
+
+	app.setTLDCache()
+
+	now := time.Now().Unix()
+	atomic.StoreInt64(&app.tldLastRefresh, now)
+	app.prometheusMetrics.TLDCacheLastRefresh.Set(float64(now))
+}
+
+// setTLDCache fills the TLD cache from the domains last seen on each
+// backend. It must be called with probeMu held.
+func (app *App) setTLDCache() {
+	topLevelDomainCache := make(map[string][]*backend.Backend)
+	for i := range app.backends {
+		for _, topLevelDomain := range app.backendTLDs[i] {
+			topLevelDomainCache[topLevelDomain] = append(topLevelDomainCache[topLevelDomain], &app.backends[i])
+		}
+	}
+	app.topLevelDomainCache.Set("tlds", topLevelDomainCache, 0, 2*app.config.InternalRoutingCache)
+	app.prometheusMetrics.TLDCacheSize.Set(float64(len(topLevelDomainCache)))
+}
+
+// getPrefixes returns the backend's paths up to depth segments long.
+func getPrefixes(backend backend.Backend, depth int) ([]string, error) {
+	var paths []string
+	query := "*"
+	for level := 1; level <= depth; level++ {
+		matches, err := findPrefixes(backend, query)
+		if err != nil {
+			return nil, err
+		}
+
+		hasBranches := false
+		for _, m := range matches.Matches {
+			paths = append(paths, m.Path)
+			hasBranches = hasBranches || !m.IsLeaf
+		}
+		if !hasBranches {
+			break
+		}
+		query += ".*"
+	}
+	return paths, nil
+}
+
+func findPrefixes(backend backend.Backend, query string) (types.Matches, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), tldProbeTimeout)
+	defer cancel()
+
+	matches, err := backend.Find(ctx, types.NewFindRequest(query))
+	if err != nil && err != types.ErrMatchesNotFound {
+		return matches, err
+	}
+	return matches, nil
+}
+
+func (app *App) tldStatus() tldStatus {
+	status := tldStatus{
+		LastRefresh: atomic.LoadInt64(&app.tldLastRefresh),
+		Domains:     make(map[string][]string),
+	}
+	if status.LastRefresh > 0 {
+		status.AgeSeconds = time.Now().Unix() - status.LastRefresh
+	}
+
+	cached, _ := app.topLevelDomainCache.Get("tlds")
+	if tldCache, ok := cached.(map[string][]*backend.Backend); ok {
+		for tld, backends := range tldCache {
+			addrs := make([]string, 0, len(backends))
+			for _, b := range backends {
+				addrs = append(addrs, (*b).GetServerAddress())
+			}
+			sort.Strings(addrs)
+			status.Domains[tld] = addrs
+		}
+	}
+
+	return status
+}
+
+// tldStatusHandler reports the content and the age of the TLD cache.
+func (app *App) tldStatusHandler(w http.ResponseWriter, req *http.Request) {
+	writeTLDStatus(w, app.tldStatus())
+}
+
+// tldRefreshHandler probes the backends right away instead of waiting for the
+// next scheduled refresh.
+func (app *App) tldRefreshHandler(w http.ResponseWriter, req *http.Request) {
+	app.doProbe()
+	writeTLDStatus(w, app.tldStatus())
+}
+
+func writeTLDStatus(w http.ResponseWriter, status tldStatus) {
+	body, err := json.Marshal(status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
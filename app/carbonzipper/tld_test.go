@@ -0,0 +1,103 @@
+package zipper
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"go.uber.org/zap"
+)
+
+func findTLDs(tlds ...string) func(context.Context, types.FindRequest) (types.Matches, error) {
+	return func(context.Context, types.FindRequest) (types.Matches, error) {
+		matches := types.Matches{Name: "*"}
+		for _, tld := range tlds {
+			matches.Matches = append(matches.Matches, types.Match{Path: tld})
+		}
+		return matches, nil
+	}
+}
+
+func TestProbeKeepsDomainsOfFailedBackend(t *testing.T) {
+	app, err := New(cfg.DefaultZipperConfig(), zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+
+	app.backends = []backend.Backend{
+		mock.New(mock.Config{Find: findTLDs("foo", "bar")}),
+		mock.New(mock.Config{Find: findTLDs("foo")}),
+	}
+	app.doProbe()
+
+	status := app.tldStatus()
+	if got := len(status.Domains["foo"]); got != 2 {
+		t.Errorf("expected foo on 2 backends, got %d", got)
+	}
+	if got := len(status.Domains["bar"]); got != 1 {
+		t.Errorf("expected bar on 1 backend, got %d", got)
+	}
+	if status.LastRefresh == 0 {
+		t.Error("expected last refresh to be set")
+	}
+
+	app.backends[0] = mock.New(mock.Config{
+		Find: func(context.Context, types.FindRequest) (types.Matches, error) {
+			return types.Matches{}, errors.New("unavailable")
+		},
+	})
+	app.doProbe()
+
+	status = app.tldStatus()
+	if got := len(status.Domains["bar"]); got != 1 {
+		t.Errorf("expected bar to be kept after a failed probe, got %d backends", got)
+	}
+}
+
+func TestTLDRefreshHandler(t *testing.T) {
+	app, err := New(cfg.DefaultZipperConfig(), zap.NewNop(), "test")
+	if err != nil {
+		t.Fatalf("got error %v when making new app", err)
+	}
+
+	app.backends = []backend.Backend{
+		mock.New(mock.Config{Find: findTLDs("foo")}),
+	}
+
+	r := initMetricHandlers(app)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/admin/tld", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got code %d expected %d", w.Code, http.StatusOK)
+	}
+	var status tldStatus
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("error decoding status: %v", err)
+	}
+	if status.LastRefresh != 0 || len(status.Domains) != 0 {
+		t.Errorf("expected empty status before refresh, got %+v", status)
+	}
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/admin/tld/refresh", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got code %d expected %d", w.Code, http.StatusOK)
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &status); err != nil {
+		t.Fatalf("error decoding status: %v", err)
+	}
+	if len(status.Domains["foo"]) != 1 {
+		t.Errorf("expected foo after refresh, got %+v", status.Domains)
+	}
+	if status.LastRefresh == 0 {
+		t.Error("expected last refresh to be set")
+	}
+}
@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"errors"
@@ -19,17 +20,24 @@ var (
 	ErrNotFound = errors.New("cache: not found")
 )
 
-type BytesCache interface {
-	Get(k string) ([]byte, error)
-	Set(k string, v []byte, expire int32)
+// Cache is the interface of the caching layers of carbonapi. Custom
+// implementations can be plugged in with Register.
+type Cache interface {
+	// Get returns the value for k, or ErrNotFound if there is none.
+	Get(ctx context.Context, k string) ([]byte, error)
+	// Set sets the value for k, to expire after ttl seconds.
+	Set(ctx context.Context, k string, v []byte, ttl int32)
+	// Del removes the value for k, if any.
+	Del(ctx context.Context, k string)
 }
 
 type NullCache struct{}
 
-func (NullCache) Get(string) ([]byte, error) { return nil, ErrNotFound }
-func (NullCache) Set(string, []byte, int32)  {}
+func (NullCache) Get(context.Context, string) ([]byte, error) { return nil, ErrNotFound }
+func (NullCache) Set(context.Context, string, []byte, int32)  {}
+func (NullCache) Del(context.Context, string)                 {}
 
-func NewExpireCache(maxsize uint64) BytesCache {
+func NewExpireCache(maxsize uint64) Cache {
 	ec := expirecache.New(maxsize)
 	go ec.ApproximateCleaner(10 * time.Second)
 	return &ExpireCache{ec: ec}
@@ -39,7 +47,7 @@ type ExpireCache struct {
 	ec *expirecache.Cache
 }
 
-func (ec ExpireCache) Get(k string) ([]byte, error) {
+func (ec ExpireCache) Get(_ context.Context, k string) ([]byte, error) {
 	v, ok := ec.ec.Get(k)
 
 	if !ok {
@@ -49,15 +57,21 @@ func (ec ExpireCache) Get(k string) ([]byte, error) {
 	return v.([]byte), nil
 }
 
-func (ec ExpireCache) Set(k string, v []byte, expire int32) {
+func (ec ExpireCache) Set(_ context.Context, k string, v []byte, expire int32) {
 	ec.ec.Set(k, v, uint64(len(v)), expire)
 }
 
+// Del expires the value for k, the underlying cache drops it on the next
+// cleanup.
+func (ec ExpireCache) Del(_ context.Context, k string) {
+	ec.ec.Set(k, nil, 0, -1)
+}
+
 func (ec ExpireCache) Items() int { return ec.ec.Items() }
 
 func (ec ExpireCache) Size() uint64 { return ec.ec.Size() }
 
-func NewMemcached(prefix string, timeoutMs uint64, servers ...string) BytesCache {
+func NewMemcached(prefix string, timeoutMs uint64, servers ...string) Cache {
 	return &MemcachedCache{
 		prefix:         prefix,
 		queryTimeoutMs: timeoutMs,
@@ -72,7 +86,7 @@ type MemcachedCache struct {
 	queryTimeoutMs uint64
 }
 
-func (m *MemcachedCache) Get(k string) ([]byte, error) {
+func (m *MemcachedCache) Get(_ context.Context, k string) ([]byte, error) {
 	key := sha256.Sum256([]byte(k))
 	hk := hex.EncodeToString(key[:])
 	done := make(chan bool, 1)
@@ -109,12 +123,18 @@ func (m *MemcachedCache) Get(k string) ([]byte, error) {
 	return item.Value, nil
 }
 
-func (m *MemcachedCache) Set(k string, v []byte, expire int32) {
+func (m *MemcachedCache) Set(_ context.Context, k string, v []byte, expire int32) {
 	key := sha256.Sum256([]byte(k))
 	hk := hex.EncodeToString(key[:])
 	go m.client.Set(&memcache.Item{Key: m.prefix + hk, Value: v, Expiration: expire})
 }
 
+func (m *MemcachedCache) Del(_ context.Context, k string) {
+	key := sha256.Sum256([]byte(k))
+	hk := hex.EncodeToString(key[:])
+	go m.client.Delete(m.prefix + hk)
+}
+
 func (m *MemcachedCache) Timeouts() uint64 {
 	return atomic.LoadUint64(&m.timeouts)
 }
@@ -123,19 +143,20 @@ func (m *MemcachedCache) Timeouts() uint64 {
 // are identical. Each read and write refers to all of them.
 type ReplicatedMemcached struct {
 	prefix    string
-	instances []Cache
+	instances []MemcacheClient
 
 	timeoutMs uint64 // timeout for getting data
 }
 
-// Cache is a cache interface. Mainly for testing abilities.
-type Cache interface {
+// MemcacheClient is the interface of a memcached client. Mainly for testing abilities.
+type MemcacheClient interface {
 	Get(string) (*memcache.Item, error)
 	Set(*memcache.Item) error
+	Delete(string) error
 }
 
 // NewReplicatedMemcached creates a set of identical memcached instances.
-func NewReplicatedMemcached(prefix string, timeout uint64, servers ...string) BytesCache {
+func NewReplicatedMemcached(prefix string, timeout uint64, servers ...string) Cache {
 	m := ReplicatedMemcached{
 		prefix:    prefix,
 		timeoutMs: timeout,
@@ -151,7 +172,7 @@ func NewReplicatedMemcached(prefix string, timeout uint64, servers ...string) By
 // Get gets value for the key from the replicated memcached.
 // It sends the request to all replicas and picks the first valid answer
 // (event if it's a not-found) or times out.
-func (m *ReplicatedMemcached) Get(k string) ([]byte, error) {
+func (m *ReplicatedMemcached) Get(_ context.Context, k string) ([]byte, error) {
 	// chan size is selected so that timeouts do not block getFromReplica goroutines
 	resCh := make(chan cacheResponse, len(m.instances))
 
@@ -181,14 +202,14 @@ func (m *ReplicatedMemcached) Get(k string) ([]byte, error) {
 }
 
 // Set sets the key-value pair for all cache instances.
-func (rm *ReplicatedMemcached) Set(k string, val []byte, expire int32) {
+func (rm *ReplicatedMemcached) Set(_ context.Context, k string, val []byte, expire int32) {
 	key := sha256.Sum256([]byte(k))
 	hk := hex.EncodeToString(key[:])
 
 	var wg sync.WaitGroup
 	for _, m := range rm.instances {
 		wg.Add(1)
-		go func(k_ string, val_ []byte, expire_ int32, m_ Cache) {
+		go func(k_ string, val_ []byte, expire_ int32, m_ MemcacheClient) {
 			m_.Set(&memcache.Item{
 				Key:        rm.prefix + k_,
 				Value:      val_,
@@ -201,13 +222,29 @@ func (rm *ReplicatedMemcached) Set(k string, val []byte, expire int32) {
 	wg.Wait()
 }
 
+// Del removes the key from all cache instances.
+func (rm *ReplicatedMemcached) Del(_ context.Context, k string) {
+	key := sha256.Sum256([]byte(k))
+	hk := hex.EncodeToString(key[:])
+
+	var wg sync.WaitGroup
+	for _, m := range rm.instances {
+		wg.Add(1)
+		go func(m_ MemcacheClient) {
+			m_.Delete(rm.prefix + hk)
+			wg.Done()
+		}(m)
+	}
+	wg.Wait()
+}
+
 type cacheResponse struct {
 	found bool
 	data  []byte
 	err   error
 }
 
-func getFromReplica(m Cache, k string, prefix string, res chan<- cacheResponse) {
+func getFromReplica(m MemcacheClient, k string, prefix string, res chan<- cacheResponse) {
 	key := sha256.Sum256([]byte(k))
 	hk := hex.EncodeToString(key[:])
 
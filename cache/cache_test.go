@@ -1,6 +1,7 @@
 package cache
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -33,11 +34,19 @@ func (m *TestMemcache) Set(i *memcache.Item) error {
 	return nil
 }
 
+func (m *TestMemcache) Delete(k string) error {
+	if _, there := m.data[k]; !there {
+		return memcache.ErrCacheMiss
+	}
+	delete(m.data, k)
+	return nil
+}
+
 func TestReplicatedMemcacheWithPartialTimeout(t *testing.T) {
 	m := ReplicatedMemcached{
 		prefix:    "test",
 		timeoutMs: 20,
-		instances: []Cache{
+		instances: []MemcacheClient{
 			&TestMemcache{
 				delayMs: 1,
 			},
@@ -53,10 +62,10 @@ func TestReplicatedMemcacheWithPartialTimeout(t *testing.T) {
 
 	aData := []byte("aval")
 	bData := []byte("bval")
-	m.Set("a", aData, 0)
-	m.Set("b", bData, 0)
+	m.Set(context.Background(), "a", aData, 0)
+	m.Set(context.Background(), "b", bData, 0)
 
-	aRes, err := m.Get("a")
+	aRes, err := m.Get(context.Background(), "a")
 	if !cmp.Equal(aRes, aData) {
 		t.Fatalf("Expected %v value for key %s, got %v", aData, "a", aRes)
 	}
@@ -64,7 +73,7 @@ func TestReplicatedMemcacheWithPartialTimeout(t *testing.T) {
 		t.Fatalf("Error while getting value for key %s; %v", "a", err)
 	}
 
-	xRes, err := m.Get("x")
+	xRes, err := m.Get(context.Background(), "x")
 	if err != ErrNotFound {
 		t.Fatalf("Expected cache miss, that did not happen. Got %v and err %v instead", xRes, err)
 	}
@@ -74,7 +83,7 @@ func TestReplicatedMemcacheTimeout(t *testing.T) {
 	m := ReplicatedMemcached{
 		prefix:    "test",
 		timeoutMs: 10,
-		instances: []Cache{
+		instances: []MemcacheClient{
 			&TestMemcache{
 				delayMs: 250,
 			},
@@ -89,11 +98,42 @@ func TestReplicatedMemcacheTimeout(t *testing.T) {
 
 	aData := []byte("aval")
 	bData := []byte("bval")
-	m.Set("a", aData, 0)
-	m.Set("b", bData, 0)
+	m.Set(context.Background(), "a", aData, 0)
+	m.Set(context.Background(), "b", bData, 0)
 
-	aRes, err := m.Get("a")
+	aRes, err := m.Get(context.Background(), "a")
 	if err != ErrTimeout {
 		t.Fatalf("Expected timeout, got val %v, err %v", aRes, err)
 	}
 }
+
+func TestReplicatedMemcacheDel(t *testing.T) {
+	ctx := context.Background()
+	m := ReplicatedMemcached{
+		prefix:    "test",
+		timeoutMs: 20,
+		instances: []MemcacheClient{&TestMemcache{}, &TestMemcache{}},
+	}
+
+	m.Set(ctx, "a", []byte("aval"), 0)
+	m.Del(ctx, "a")
+
+	if res, err := m.Get(ctx, "a"); err != ErrNotFound {
+		t.Fatalf("Expected cache miss after delete, got %v and err %v instead", res, err)
+	}
+}
+
+func TestExpireCacheDel(t *testing.T) {
+	ctx := context.Background()
+	c := NewExpireCache(1000)
+
+	c.Set(ctx, "a", []byte("aval"), 60)
+	if _, err := c.Get(ctx, "a"); err != nil {
+		t.Fatalf("Error while getting value for key %s; %v", "a", err)
+	}
+
+	c.Del(ctx, "a")
+	if res, err := c.Get(ctx, "a"); err != ErrNotFound {
+		t.Fatalf("Expected cache miss after delete, got %v and err %v instead", res, err)
+	}
+}
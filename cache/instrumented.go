@@ -0,0 +1,85 @@
+package cache
+
+import (
+	"context"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/api/kv"
+	"go.opentelemetry.io/otel/api/trace"
+)
+
+// Instrumented is a Cache that counts and times the operations of the cache
+// it wraps, and traces them as spans of the requests they are done for.
+type Instrumented struct {
+	name  string
+	cache Cache
+
+	requests  *prometheus.CounterVec
+	durations *prometheus.HistogramVec
+}
+
+// NewInstrumented wraps c, labelling its metrics with name. requests must
+// have the labels cache, operation and result, durations the labels cache
+// and operation.
+func NewInstrumented(name string, c Cache, requests *prometheus.CounterVec, durations *prometheus.HistogramVec) *Instrumented {
+	return &Instrumented{
+		name:      name,
+		cache:     c,
+		requests:  requests,
+		durations: durations,
+	}
+}
+
+// Unwrap returns the wrapped cache.
+func (c *Instrumented) Unwrap() Cache {
+	return c.cache
+}
+
+func (c *Instrumented) Get(ctx context.Context, k string) ([]byte, error) {
+	ctx, span := c.start(ctx, "get")
+	defer span.End()
+
+	t0 := time.Now()
+	v, err := c.cache.Get(ctx, k)
+	result := "hit"
+	if err == ErrNotFound {
+		result = "miss"
+	} else if err != nil {
+		result = "error"
+		span.RecordError(ctx, err)
+	}
+	c.observe("get", result, t0)
+	span.SetAttributes(kv.String("cache.result", result))
+
+	return v, err
+}
+
+func (c *Instrumented) Set(ctx context.Context, k string, v []byte, ttl int32) {
+	ctx, span := c.start(ctx, "set")
+	defer span.End()
+
+	t0 := time.Now()
+	c.cache.Set(ctx, k, v, ttl)
+	c.observe("set", "ok", t0)
+}
+
+func (c *Instrumented) Del(ctx context.Context, k string) {
+	ctx, span := c.start(ctx, "del")
+	defer span.End()
+
+	t0 := time.Now()
+	c.cache.Del(ctx, k)
+	c.observe("del", "ok", t0)
+}
+
+func (c *Instrumented) start(ctx context.Context, operation string) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).Tracer().Start(ctx, "cache "+operation, trace.WithAttributes(
+		kv.String("cache.name", c.name),
+	))
+}
+
+func (c *Instrumented) observe(operation, result string, t0 time.Time) {
+	c.requests.WithLabelValues(c.name, operation, result).Inc()
+	c.durations.WithLabelValues(c.name, operation).Observe(time.Since(t0).Seconds())
+}
@@ -0,0 +1,71 @@
+package cache
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+func TestInstrumented(t *testing.T) {
+	ctx := context.Background()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "requests"}, []string{"cache", "operation", "result"})
+	durations := prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "durations"}, []string{"cache", "operation"})
+	c := NewInstrumented("test", NewExpireCache(1000), requests, durations)
+
+	c.Get(ctx, "a")
+	c.Set(ctx, "a", []byte("aval"), 60)
+	c.Get(ctx, "a")
+	c.Get(ctx, "a")
+	c.Del(ctx, "a")
+
+	for _, tt := range []struct {
+		operation, result string
+		want              float64
+	}{
+		{"get", "miss", 1},
+		{"get", "hit", 2},
+		{"get", "error", 0},
+		{"set", "ok", 1},
+		{"del", "ok", 1},
+	} {
+		var m dto.Metric
+		if err := requests.WithLabelValues("test", tt.operation, tt.result).Write(&m); err != nil {
+			t.Fatal(err)
+		}
+		got := m.GetCounter().GetValue()
+		if got != tt.want {
+			t.Errorf("expected %v %s requests with result %s, got %v", tt.want, tt.operation, tt.result, got)
+		}
+	}
+}
+
+func TestRegister(t *testing.T) {
+	if Registered("test") {
+		t.Fatal("expected the test type not to be registered yet")
+	}
+	if _, err := New("test", cfg.CacheConfig{}); err == nil {
+		t.Fatal("expected an error for an unregistered type")
+	}
+
+	Register("test", func(config cfg.CacheConfig) (Cache, error) {
+		return NewExpireCache(uint64(config.Size)), nil
+	})
+
+	c, err := New("test", cfg.CacheConfig{Size: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.(*ExpireCache); !ok {
+		t.Fatalf("expected the registered factory to be used, got %T", c)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected registering a type twice to panic")
+		}
+	}()
+	Register("test", func(cfg.CacheConfig) (Cache, error) { return NullCache{}, nil })
+}
@@ -0,0 +1,210 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/golang/groupcache/consistenthash"
+)
+
+// peerReplicas is the number of points of each peer on the hash ring.
+const peerReplicas = 50
+
+// maxPeeredValueSize is the size of the largest value peers set on each
+// other. Larger values are not cached.
+const maxPeeredValueSize = 64 << 20
+
+// peerSecretHeader carries the secret shared by the peers, which ServeHTTP
+// requires of every request.
+const peerSecretHeader = "X-Carbonapi-Cache-Secret"
+
+// Peered is a cache shared by a pool of carbonapi instances. As in
+// groupcache, every key is owned by one of the peers, picked by consistent
+// hashing, which keeps it in its local cache. The other peers get and set it
+// through the owner over HTTP, so that all instances behind a load balancer
+// hit the same entries.
+type Peered struct {
+	name   string
+	self   string
+	secret string
+	local  Cache
+	ring   *consistenthash.Map
+
+	client  *http.Client
+	timeout time.Duration
+}
+
+// NewPeered creates the cache name shared by peers, given as the base URLs
+// of their internal listeners. self is the URL of this instance among them,
+// and local keeps the entries it owns. The peers authenticate to each other
+// with secret, which must not be empty.
+func NewPeered(name, self, secret string, peers []string, local Cache, timeout time.Duration) *Peered {
+	ring := consistenthash.New(peerReplicas, nil)
+	ring.Add(peers...)
+
+	return &Peered{
+		name:    name,
+		self:    self,
+		secret:  secret,
+		local:   local,
+		ring:    ring,
+		client:  &http.Client{},
+		timeout: timeout,
+	}
+}
+
+// Path is the path the cache is served on by ServeHTTP.
+func (p *Peered) Path() string {
+	return "/cache/" + p.name
+}
+
+func (p *Peered) owner(k string) string {
+	if p.ring.IsEmpty() {
+		return p.self
+	}
+
+	return p.ring.Get(k)
+}
+
+func (p *Peered) Get(ctx context.Context, k string) ([]byte, error) {
+	owner := p.owner(k)
+	if owner == p.self {
+		return p.local.Get(ctx, k)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	resp, err := p.do(ctx, http.MethodGet, owner, url.Values{"key": {k}}, nil)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrTimeout
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// peers do not keep larger values, so a larger reply is not one
+		v, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxPeeredValueSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(v) > maxPeeredValueSize {
+			return nil, fmt.Errorf("cache: peer %s returned more than %d bytes", owner, maxPeeredValueSize)
+		}
+		return v, nil
+	case http.StatusNotFound:
+		return nil, ErrNotFound
+	default:
+		return nil, fmt.Errorf("cache: peer %s returned %s", owner, resp.Status)
+	}
+}
+
+// Set sets the value in the background, as the memcached caches do, if the
+// key is owned by another peer.
+func (p *Peered) Set(ctx context.Context, k string, v []byte, ttl int32) {
+	owner := p.owner(k)
+	if owner == p.self {
+		p.local.Set(ctx, k, v, ttl)
+		return
+	}
+	if len(v) > maxPeeredValueSize {
+		return
+	}
+
+	go p.send(http.MethodPut, owner, url.Values{"key": {k}, "ttl": {strconv.Itoa(int(ttl))}}, v)
+}
+
+func (p *Peered) Del(ctx context.Context, k string) {
+	owner := p.owner(k)
+	if owner == p.self {
+		p.local.Del(ctx, k)
+		return
+	}
+
+	go p.send(http.MethodDelete, owner, url.Values{"key": {k}}, nil)
+}
+
+func (p *Peered) send(method, peer string, params url.Values, body []byte) {
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	resp, err := p.do(ctx, method, peer, params, body)
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
+
+func (p *Peered) do(ctx context.Context, method, peer string, params url.Values, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, peer+p.Path()+"?"+params.Encode(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(peerSecretHeader, p.secret)
+
+	return p.client.Do(req.WithContext(ctx))
+}
+
+// ServeHTTP serves the entries owned by this instance to its peers, and to
+// no one else: requests without the secret of the peers are forbidden.
+func (p *Peered) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	secret := r.Header.Get(peerSecretHeader)
+	if p.secret == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(p.secret)) != 1 {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	ctx := r.Context()
+	k := r.FormValue("key")
+	if k == "" {
+		http.Error(w, "missing key", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		v, err := p.local.Get(ctx, k)
+		if err == ErrNotFound {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(v)
+	case http.MethodPut:
+		ttl, err := strconv.Atoi(r.FormValue("ttl"))
+		if err != nil {
+			http.Error(w, "invalid ttl", http.StatusBadRequest)
+			return
+		}
+		if r.ContentLength > maxPeeredValueSize {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		v, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxPeeredValueSize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		p.local.Set(ctx, k, v, int32(ttl))
+		w.WriteHeader(http.StatusNoContent)
+	case http.MethodDelete:
+		p.local.Del(ctx, k)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,133 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPeered(t *testing.T) {
+	ctx := context.Background()
+
+	var handlers [2]http.Handler
+	var urls []string
+	for i := range handlers {
+		i := i
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handlers[i].ServeHTTP(w, r)
+		}))
+		defer srv.Close()
+		urls = append(urls, srv.URL)
+	}
+
+	var peers [2]*Peered
+	for i := range peers {
+		peers[i] = NewPeered("test", urls[i], "secret", urls, NewExpireCache(0), time.Second)
+		mux := http.NewServeMux()
+		mux.Handle(peers[i].Path(), peers[i])
+		handlers[i] = mux
+	}
+
+	owned := [2]int{}
+	for k := 0; k < 20; k++ {
+		key := fmt.Sprintf("key%d", k)
+		val := []byte(key + "val")
+
+		peers[k%2].Set(ctx, key, val, 60)
+
+		// sets on other peers are sent in the background
+		var got []byte
+		var err error
+		for try := 0; try < 100; try++ {
+			if got, err = peers[(k+1)%2].Get(ctx, key); err == nil {
+				break
+			}
+			time.Sleep(time.Millisecond)
+		}
+		if err != nil || string(got) != string(val) {
+			t.Fatalf("expected %s for %s from the other peer, got %s, err %v", val, key, got, err)
+		}
+
+		for i := range peers {
+			if _, err := peers[i].local.Get(ctx, key); err == nil {
+				owned[i]++
+			}
+		}
+	}
+	if owned[0]+owned[1] != 20 || owned[0] == 0 || owned[1] == 0 {
+		t.Errorf("expected keys to be spread over both peers, got %v", owned)
+	}
+
+	peers[0].Del(ctx, "key1")
+	for try := 0; try < 100; try++ {
+		if _, err := peers[1].Get(ctx, "key1"); err == ErrNotFound {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Error("expected key1 to be deleted")
+}
+
+func TestPeeredUnreachable(t *testing.T) {
+	p := NewPeered("test", "http://self", "secret", []string{"http://127.0.0.1:1"}, NewExpireCache(0), 100*time.Millisecond)
+	if _, err := p.Get(context.Background(), "key"); err == nil {
+		t.Error("expected an error from an unreachable peer")
+	}
+}
+
+func TestPeeredOversizedReply(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, maxPeeredValueSize+1))
+	}))
+	defer srv.Close()
+
+	p := NewPeered("test", "http://self", "secret", []string{srv.URL}, NewExpireCache(0), 10*time.Second)
+	if v, err := p.Get(context.Background(), "key"); err == nil || v != nil {
+		t.Errorf("expected an error for an oversized reply, got %d bytes", len(v))
+	}
+}
+
+func TestPeeredServeHTTP(t *testing.T) {
+	p := NewPeered("test", "http://self", "secret", nil, NewExpireCache(0), time.Second)
+	p.local.Set(context.Background(), "key", []byte("val"), 60)
+
+	tests := []struct {
+		name   string
+		method string
+		secret string
+		body   io.Reader
+		code   int
+	}{
+		{"get without the secret", http.MethodGet, "", nil, http.StatusForbidden},
+		{"put with a wrong secret", http.MethodPut, "wrong", strings.NewReader("evil"), http.StatusForbidden},
+		{"delete without the secret", http.MethodDelete, "", nil, http.StatusForbidden},
+		{"put too large", http.MethodPut, "secret", bytes.NewReader(make([]byte, maxPeeredValueSize+1)), http.StatusRequestEntityTooLarge},
+		{"put too large, chunked", http.MethodPut, "secret", io.MultiReader(bytes.NewReader(make([]byte, maxPeeredValueSize)), strings.NewReader("x")), http.StatusBadRequest},
+		{"get with the secret", http.MethodGet, "secret", nil, http.StatusOK},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			req := httptest.NewRequest(tst.method, p.Path()+"?key=key&ttl=60", tst.body)
+			if tst.secret != "" {
+				req.Header.Set(peerSecretHeader, tst.secret)
+			}
+			rr := httptest.NewRecorder()
+			p.ServeHTTP(rr, req)
+
+			if rr.Code != tst.code {
+				t.Errorf("expected %d, got %d", tst.code, rr.Code)
+			}
+		})
+	}
+
+	if v, err := p.local.Get(context.Background(), "key"); err != nil || string(v) != "val" {
+		t.Errorf("expected the entry to be left alone, got %q, err %v", v, err)
+	}
+}
@@ -0,0 +1,52 @@
+package cache
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+// Factory creates a cache as configured by the cache section of the config.
+type Factory func(config cfg.CacheConfig) (Cache, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register makes a custom cache type available, to be selected by its name
+// as the type of the cache in the config. It is meant to be called from the
+// init of the package implementing the cache, and panics if the name is
+// taken.
+func Register(name string, f Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if _, ok := factories[name]; ok {
+		panic(fmt.Sprintf("cache: type %s registered twice", name))
+	}
+	factories[name] = f
+}
+
+// New creates a cache of a registered custom type.
+func New(name string, config cfg.CacheConfig) (Cache, error) {
+	factoriesMu.RLock()
+	f, ok := factories[name]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("cache: unknown type %s", name)
+	}
+
+	return f(config)
+}
+
+// Registered returns whether a custom cache type was registered as name.
+func Registered(name string) bool {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	_, ok := factories[name]
+	return ok
+}
@@ -2,6 +2,7 @@ package cfg
 
 import (
 	"io"
+	"net/http"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -48,6 +49,22 @@ func ParseAPIConfig(r io.Reader) (API, error) {
 		api.Timeouts = pre.Upstreams.Timeouts
 	}
 
+	if pre.Upstreams.MaxIdleConnsPerHost != defaultCfg.MaxIdleConnsPerHost {
+		api.MaxIdleConnsPerHost = pre.Upstreams.MaxIdleConnsPerHost
+	}
+	if pre.Upstreams.IdleConnTimeout != defaultCfg.IdleConnTimeout {
+		api.IdleConnTimeout = pre.Upstreams.IdleConnTimeout
+	}
+	if pre.Upstreams.BackendCompression != defaultCfg.BackendCompression {
+		api.BackendCompression = pre.Upstreams.BackendCompression
+	}
+	if pre.Upstreams.BackendHTTP2 != defaultCfg.BackendHTTP2 {
+		api.BackendHTTP2 = pre.Upstreams.BackendHTTP2
+	}
+	if pre.Upstreams.GRPCPoolSize != defaultCfg.GRPCPoolSize {
+		api.GRPCPoolSize = pre.Upstreams.GRPCPoolSize
+	}
+
 	if len(pre.Upstreams.Backends) >= 1 {
 		api.Backends = pre.Upstreams.Backends
 	}
@@ -71,6 +88,51 @@ func DefaultAPIConfig() API {
 		},
 	}
 
+	cfg.APIVersion = 1
+	cfg.RenderNotFoundStatus = http.StatusOK
+	cfg.MaxRenderBatchQueries = 100
+	cfg.MaxRenderBatchBytes = 4 << 20
+	cfg.MaxTargetLength = 16384
+	cfg.Admission = AdmissionConfig{
+		DatapointInterval: time.Minute,
+		QueueTimeout:      time.Second,
+		RetryAfter:        2 * time.Second,
+		Memory: MemoryGuardConfig{
+			CheckInterval:  time.Second,
+			ShedDatapoints: 10000000,
+		},
+	}
+	cfg.FunctionDefaults = FunctionDefaultsConfig{
+		NullWindows: "null",
+		Infinity:    "keep",
+	}
+	cfg.ParserLimits = ParserLimitsConfig{
+		MaxDepth:     100,
+		MaxArguments: 1000,
+	}
+	cfg.AsyncRender = AsyncRenderConfig{
+		MaxJobs:   100,
+		ResultTTL: 10 * time.Minute,
+		Timeout:   10 * time.Minute,
+	}
+	cfg.WriteBack.Timeout = 5 * time.Second
+	cfg.Verify = VerifyConfig{
+		Tolerance:     1e-6,
+		Timeout:       30 * time.Second,
+		MaxConcurrent: 10,
+	}
+	cfg.Events.Timeout = 10 * time.Second
+	cfg.FindTree = FindTreeConfig{
+		TTL:        5 * time.Minute,
+		MaxEntries: 1000000,
+	}
+	cfg.Index = IndexConfig{
+		MaxMetrics:      1000000,
+		MaxConcurrent:   16,
+		CacheTimeoutSec: 600,
+	}
+	// carbonapi keeps its connections to the zipper open
+	cfg.IdleConnTimeout = 0
 	cfg.Listen = ":8081"
 	cfg.MaxProcs = 0
 	cfg.Graphite.Prefix = "carbon.api"
@@ -101,11 +163,318 @@ type API struct {
 	DefaultColors             map[string]string `yaml:"defaultColors"`
 	FunctionsConfigs          map[string]string `yaml:"functionsConfig"`
 	GraphiteVersionForGrafana string            `yaml:"graphiteVersionForGrafana"`
+
+	// MaxRenderBatchQueries limits the number of queries in a single
+	// /render/batch request. Zero means no limit.
+	MaxRenderBatchQueries int `yaml:"maxRenderBatchQueries"`
+
+	// MaxRenderBatchBytes limits the size of the body of a /render/batch
+	// request. Zero means no limit.
+	MaxRenderBatchBytes int64 `yaml:"maxRenderBatchBytes"`
+
+	// MaxTargetLength is the longest target, in bytes, that is accepted by
+	// the render, find and info handlers. Zero means no limit.
+	MaxTargetLength int `yaml:"maxTargetLength"`
+
+	// DefaultXFilesFactor is the ratio of points that must be known for an
+	// aggregation of them to be known, for functions not given an
+	// xFilesFactor. Like in graphite-web it defaults to 0.
+	DefaultXFilesFactor float64 `yaml:"defaultXFilesFactor"`
+
+	// FunctionDefaults are the other defaults of the aggregating and
+	// summarizing functions, for their results to match the settings of
+	// graphite-web of the site.
+	FunctionDefaults FunctionDefaultsConfig `yaml:"functionDefaults"`
+
+	// Admission limits how much data a single render request may fetch.
+	Admission AdmissionConfig `yaml:"admission"`
+
+	// ParserLimits bound the expressions of targets.
+	ParserLimits ParserLimitsConfig `yaml:"parserLimits"`
+
+	// Tenants configures the sharing of the backend between tenants.
+	Tenants TenantsConfig `yaml:"tenants"`
+
+	// AsyncRender configures the render requests run in the background,
+	// for exports too long to be waited for.
+	AsyncRender AsyncRenderConfig `yaml:"asyncRender"`
+
+	// StandingQueries are render requests evaluated on a schedule. Render
+	// requests with the same parameters are only answered from their last
+	// result, to take expensive dashboards off the backend.
+	StandingQueries []StandingQueryConfig `yaml:"standingQueries"`
+
+	// QueryRefs are lists of targets rendered by name, with queryRef=name,
+	// for alerting systems to refer to vetted queries rather than repeat
+	// their expressions.
+	QueryRefs []QueryRefConfig `yaml:"queryRefs"`
+	// QueryRefsFile is a YAML file of more query refs, a list like
+	// QueryRefs, for them to be curated apart from the configuration.
+	QueryRefsFile string `yaml:"queryRefsFile"`
+
+	// WriteBack writes the results of expressions to carbon on a schedule,
+	// to store expensive derived series once.
+	WriteBack WriteBackConfig `yaml:"writeBack"`
+
+	// CoalesceRenders shares the backend renders in flight between requests.
+	CoalesceRenders CoalesceRendersConfig `yaml:"coalesceRenders"`
+
+	// Verify compares the results of a sample of render requests to those
+	// of a reference graphite-web, to validate the functions.
+	Verify VerifyConfig `yaml:"verify"`
+
+	// APIVersion is the version of the HTTP API, 1 or 2, of the requests
+	// to paths without a /v1 or /v2 prefix.
+	APIVersion int `yaml:"apiVersion"`
+
+	// UnknownFormat is the format of the responses to the requests in a
+	// format carbonapi does not know, if the handler supports it. Empty
+	// means such requests are answered with 400.
+	UnknownFormat string `yaml:"unknownFormat"`
+
+	// RenderNotFoundStatus is the status of the render requests that match
+	// no metrics, 200 with an empty result as graphite-web, or 404. The
+	// notFoundStatus parameter overrides it per request.
+	RenderNotFoundStatus int `yaml:"renderNotFoundStatus"`
+
+	// PromQL configures the Prometheus query endpoint,
+	// /api/v1/query_range.
+	PromQL PromQLConfig `yaml:"promql"`
+
+	// Events is where the /events endpoints of graphite-web are proxied
+	// to.
+	Events EventsConfig `yaml:"events"`
+
+	// Index configures /metrics/index.json, the list of all the metrics.
+	Index IndexConfig `yaml:"index"`
+
+	// FindTree caches the metric tree in memory, for browsing it not to
+	// find every level of it on the backends.
+	FindTree FindTreeConfig `yaml:"findTree"`
+
+	// NamespaceMetrics attributes the load of render requests to the
+	// namespaces of their metrics.
+	NamespaceMetrics NamespaceMetricsConfig `yaml:"namespaceMetrics"`
+}
+
+// NamespaceMetricsConfig holds the namespaces the requests and datapoints
+// of render requests are counted by. The namespace of a metric is its first
+// Depth nodes, 1 or 2, 0 disables the counts. Namespaces not listed are
+// counted as "other", for the cardinality of the counts to stay bounded.
+type NamespaceMetricsConfig struct {
+	Depth      int      `yaml:"depth"`
+	Namespaces []string `yaml:"namespaces"`
+}
+
+// AdmissionConfig holds the limits enforced on render requests before their
+// metrics are fetched. Zero values mean no limit.
+type AdmissionConfig struct {
+	// MaxMetricsPerRequest limits the number of metrics the targets of a
+	// request expand to.
+	MaxMetricsPerRequest int `yaml:"maxMetricsPerRequest"`
+	// MaxDatapointsPerRequest limits the estimated number of datapoints a
+	// request fetches.
+	MaxDatapointsPerRequest int64 `yaml:"maxDatapointsPerRequest"`
+	// MaxTimeRange limits the time range of every fetch.
+	MaxTimeRange time.Duration `yaml:"maxTimeRange"`
+	// DatapointInterval is the resolution assumed when estimating datapoints.
+	DatapointInterval time.Duration `yaml:"datapointInterval"`
+
+	// MaxDatapointsInFlight limits the estimated number of datapoints fetched
+	// by all requests at once. Requests that would exceed it are queued for
+	// up to QueueTimeout, and told to retry after RetryAfter plus jitter.
+	// A request bigger than all of it is rejected at once.
+	MaxDatapointsInFlight int64         `yaml:"maxDatapointsInFlight"`
+	QueueTimeout          time.Duration `yaml:"queueTimeout"`
+	RetryAfter            time.Duration `yaml:"retryAfter"`
+
+	// Memory sheds the most expensive requests while memory is short.
+	Memory MemoryGuardConfig `yaml:"memory"`
+}
+
+// MemoryGuardConfig holds when carbonapi protects itself from running out of
+// memory. While the heap in use is over HeapWatermark, or a GC pause is over
+// GCPauseWatermark, requests estimated to fetch more than ShedDatapoints are
+// refused, and the threshold is halved at every check the pressure lasts.
+// Zero watermarks disable the check.
+type MemoryGuardConfig struct {
+	HeapWatermark    uint64        `yaml:"heapWatermark"`
+	GCPauseWatermark time.Duration `yaml:"gcPauseWatermark"`
+	CheckInterval    time.Duration `yaml:"checkInterval"`
+	ShedDatapoints   int64         `yaml:"shedDatapoints"`
+}
+
+// FunctionDefaultsConfig holds the defaults of the aggregating and
+// summarizing functions.
+type FunctionDefaultsConfig struct {
+	// XFilesFactor, if set, overrides DefaultXFilesFactor.
+	XFilesFactor *float64 `yaml:"xFilesFactor"`
+	// NullWindows is what aggregating points that are all absent gives,
+	// "null" like graphite-web, or "zero".
+	NullWindows string `yaml:"nullWindows"`
+	// Infinity is how infinite points are aggregated, "keep" like
+	// graphite-web, or "null" to treat them as absent.
+	Infinity string `yaml:"infinity"`
+}
+
+// ParserLimitsConfig holds the limits of the expressions parsed from targets.
+// Zero values mean no limit.
+type ParserLimitsConfig struct {
+	// MaxDepth limits the nesting of function calls.
+	MaxDepth int `yaml:"maxDepth"`
+	// MaxArguments limits the arguments of a function call.
+	MaxArguments int `yaml:"maxArguments"`
+	// MaxLength limits the length of an expression, in bytes, including
+	// the ones expanded from macros.
+	MaxLength int `yaml:"maxLength"`
+	// MaxPipes limits the pipes of a chain, e.g. 2 for a|b()|c().
+	MaxPipes int `yaml:"maxPipes"`
+}
+
+// TenantsConfig holds how requests are told apart by tenant, for tenants to
+// share the concurrency limit of the backend in proportion to their weights
+// rather than first come, first served.
+type TenantsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Header is the request header carrying the key of the tenant. Defaults
+	// to the password of basic authentication.
+	Header string `yaml:"header"`
+	// Keys maps the secret keys handed out to tenants to their names.
+	// Requests without a known key are of the unnamed tenant.
+	Keys map[string]string `yaml:"keys"`
+	// Weights of tenants in sharing the backend. Others weigh 1.
+	Weights map[string]float64 `yaml:"weights"`
+}
+
+// AsyncRenderConfig holds the limits of the asynchronous render jobs.
+type AsyncRenderConfig struct {
+	// MaxJobs limits the number of jobs, running or holding a result, kept
+	// at once. Zero disables asynchronous rendering.
+	MaxJobs int `yaml:"maxJobs"`
+	// ResultTTL is how long the result of a job is kept once it is done.
+	ResultTTL time.Duration `yaml:"resultTTL"`
+	// Timeout replaces the global timeout for jobs.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// StandingQueryConfig holds a render request evaluated on a schedule.
+type StandingQueryConfig struct {
+	// Name identifies the query in metrics and logs.
+	Name string `yaml:"name"`
+	// Query is the query string of the render request, e.g.
+	// target=sum(foo.*)&from=-1h&format=json
+	Query string `yaml:"query"`
+	// Interval is how often the query is evaluated.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// QueryRefConfig holds a list of targets rendered by name.
+type QueryRefConfig struct {
+	Name    string   `yaml:"name"`
+	Targets []string `yaml:"targets"`
+}
+
+// WriteBackConfig holds the expressions whose results are written back to
+// carbon, and where to.
+type WriteBackConfig struct {
+	// Relay is the address of a carbon relay taking the plaintext protocol,
+	// e.g. localhost:2003. Empty disables writing back.
+	Relay string `yaml:"relay"`
+	// Timeout bounds connecting and writing to the relay.
+	Timeout time.Duration     `yaml:"timeout"`
+	Series  []WriteBackSeries `yaml:"series"`
+}
+
+// WriteBackSeries is an expression whose results are written back.
+type WriteBackSeries struct {
+	// Name identifies the expression in metrics and logs.
+	Name string `yaml:"name"`
+	// Target is the expression. Its series are written under their names,
+	// which should be metric names, e.g. made by aliasByNode.
+	Target string `yaml:"target"`
+	// Prefix is prepended to the names of the series, if set.
+	Prefix string `yaml:"prefix"`
+	// From is the start of the evaluated range, e.g. -10min. Defaults to
+	// twice the interval.
+	From string `yaml:"from"`
+	// Interval is how often the expression is evaluated and written.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// CoalesceRendersConfig holds how the renders of the same metric and range
+// are shared by the requests waiting for them at once, e.g. dashboards
+// refreshed together.
+type CoalesceRendersConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Bucket widens the range of renders to its multiples, for renders of
+	// ranges relative to now to be shared even when asked a bit apart. It
+	// should not exceed the resolution of the metrics. Zero shares renders
+	// of the same range only.
+	Bucket time.Duration `yaml:"bucket"`
+}
+
+// VerifyConfig holds where the results of render requests are verified, and
+// how closely they must match.
+type VerifyConfig struct {
+	// URL is the base URL of the reference graphite-web, e.g.
+	// http://graphite:8080. Empty disables verification.
+	URL string `yaml:"url"`
+	// SampleRate is the ratio of render requests verified, from 0 to 1.
+	SampleRate float64 `yaml:"sampleRate"`
+	// Tolerance is the largest difference between values, relative to the
+	// larger of them, that is not a divergence.
+	Tolerance float64 `yaml:"tolerance"`
+	// Timeout bounds the requests to the reference.
+	Timeout time.Duration `yaml:"timeout"`
+	// MaxConcurrent limits the requests verified at once. Requests sampled
+	// beyond it are not verified.
+	MaxConcurrent int `yaml:"maxConcurrent"`
+}
+
+// PromQLConfig configures the Prometheus query endpoint, which is disabled
+// without a path template.
+type PromQLConfig struct {
+	// PathTemplate maps the labels of Prometheus series to the paths of
+	// Graphite metrics, like the remote read of carbonzipper does.
+	PathTemplate string `yaml:"pathTemplate"`
+}
+
+// EventsConfig configures the proxy of the events API of graphite-web,
+// which carbonapi does not store.
+type EventsConfig struct {
+	// URL is the base URL of the server of the events, e.g.
+	// http://graphite:8080, which implements /events/ and
+	// /events/get_data as graphite-web does. Empty disables the endpoints.
+	URL string `yaml:"url"`
+	// Timeout bounds the requests to the server.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// IndexConfig configures /metrics/index.json, which walks the tree of the
+// metrics with finds.
+type IndexConfig struct {
+	// MaxMetrics is the most metrics listed, beyond which the index is
+	// answered with 422. 0 means no limit.
+	MaxMetrics int `yaml:"maxMetrics"`
+	// MaxConcurrent limits the finds in flight to build the index.
+	MaxConcurrent int `yaml:"maxConcurrent"`
+	// CacheTimeoutSec is how long the index is kept in the find cache.
+	CacheTimeoutSec int32 `yaml:"cacheTimeoutSec"`
+}
+
+// FindTreeConfig holds the cache of the metric tree. The children of a node
+// listed by a find of <node>.* are kept for TTL, and the finds of globs going
+// through the nodes listed are answered from them. At most MaxEntries
+// children are kept in all, 0 for no limit.
+type FindTreeConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	TTL        time.Duration `yaml:"ttl"`
+	MaxEntries int           `yaml:"maxEntries"`
 }
 
 // CacheConfig configs the cache
 type CacheConfig struct {
-	// possible values are: null, mem, memcache, replicatedMemcache
+	// possible values are: null, mem, memcache, replicatedMemcache, peered
 	Type             string   `yaml:"type"`
 	Size             int      `yaml:"size_mb"`
 	MemcachedServers []string `yaml:"memcachedServers"`
@@ -113,6 +482,14 @@ type CacheConfig struct {
 	DefaultTimeoutSec int32  `yaml:"defaultTimeoutSec"`
 	QueryTimeoutMs    uint64 `yaml:"queryTimeoutMs"`
 	Prefix            string `yaml:"prefix"`
+
+	// Peers are the base URLs of the internal listeners of the carbonapi
+	// instances sharing a peered cache, and Self is the one of this
+	// instance among them. Secret is shared by the peers, who only serve
+	// each other.
+	Peers  []string `yaml:"peers"`
+	Self   string   `yaml:"self"`
+	Secret string   `yaml:"secret"`
 }
 
 type preAPI struct {
@@ -144,6 +144,10 @@ upstreams:
     concurrencyLimit: 1024
     keepAliveInterval: "30s"
     maxIdleConnsPerHost: 1024
+    idleConnTimeout: "90s"
+    backendCompression: false
+    backendHTTP2: true
+    grpcPoolSize: 4
     backends:
         - "http://localhost:8000"
 expireDelaySec: 0
@@ -211,6 +215,13 @@ loggerConfig:
 	if !eqCommon(got.Common, expected.Common) {
 		t.Fatalf("Didn't parse expected struct from config\nGot: %v\nExp: %v", got, expected)
 	}
+	if got.IdleConnTimeout != 90*time.Second || got.BackendCompression || !got.BackendHTTP2 {
+		t.Errorf("Didn't take the connections to upstreams from config, got idle timeout %v, compression %v and HTTP/2 %v",
+			got.IdleConnTimeout, got.BackendCompression, got.BackendHTTP2)
+	}
+	if got.GRPCPoolSize != 4 {
+		t.Errorf("Didn't take the gRPC pool size from upstreams, got %d", got.GRPCPoolSize)
+	}
 }
 
 func eqStringSlice(a, b []string) bool {
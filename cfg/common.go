@@ -1,11 +1,13 @@
 package cfg
 
 import (
+	"compress/gzip"
 	"fmt"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"io"
 	"log"
+	"os"
 	"time"
 
 	"gopkg.in/yaml.v2"
@@ -54,10 +56,25 @@ func DefaultCommonConfig() Common {
 		ConcurrencyLimitPerServer: 20,
 		KeepAliveInterval:         30 * time.Second,
 		MaxIdleConnsPerHost:       100,
+		IdleConnTimeout:           3 * time.Second,
+		BackendCompression:        true,
+		Discovery: Discovery{
+			Interval: 30 * time.Second,
+		},
+		RemoteConfig: RemoteConfig{
+			Interval: time.Minute,
+			Timeout:  10 * time.Second,
+		},
 
 		ExpireDelaySec:       int32(10 * time.Minute / time.Second),
 		InternalRoutingCache: int32(5 * time.Minute / time.Second),
 
+		Compression: Compression{
+			MinSize: 1024,
+			Level:   gzip.DefaultCompression,
+		},
+		ResponsePolicy: ResponsePolicyPartial,
+
 		Buckets: 10,
 		Graphite: GraphiteConfig{
 			Interval: 60 * time.Second,
@@ -131,6 +148,7 @@ func DefaultCommonConfig() Common {
 			RenderReplicaMismatchApproximateCheck: false,
 			RenderReplicaMatchMode:                ReplicaMatchModeNormal,
 			RenderReplicaMismatchReportLimit:      10,
+			RenderDuplicatePolicy:                 DuplicatePolicyFirst,
 		},
 	}
 }
@@ -157,6 +175,46 @@ func GetDefaultLoggerConfig() zap.Config {
 	}
 }
 
+// EndpointProtocols are the protocols used for the endpoints of a backend.
+// Endpoints that are not set use the protocol of the backend.
+type EndpointProtocols struct {
+	// Find can also be pickle, the protocol of graphite-web.
+	Find   string `yaml:"find"`
+	Render string `yaml:"render"`
+	Info   string `yaml:"info"`
+}
+
+// Compression configures the compression of responses, in gzip, for the
+// clients that accept it. zstd is not supported.
+type Compression struct {
+	Enabled bool `yaml:"enabled"`
+	// MinSize is the size in bytes from which responses are compressed.
+	// Smaller ones are not worth it.
+	MinSize int `yaml:"minSize"`
+	// Level is the gzip level, from 1 (fastest) to 9 (smallest). Defaults
+	// to that of compress/gzip.
+	Level int `yaml:"level"`
+}
+
+// RemoteReadConfig configures the Prometheus remote read endpoint,
+// /api/v1/read, which is disabled without a path template.
+type RemoteReadConfig struct {
+	// PathTemplate maps the labels of Prometheus series to the paths of
+	// Graphite metrics, e.g. "{job}.{instance}.{__name__}". Each node of
+	// the path is either a label in braces or a literal.
+	PathTemplate string `yaml:"pathTemplate"`
+}
+
+// PickleConfig configures the encoding of find responses in pickle.
+type PickleConfig struct {
+	// Protocol is the pickle protocol, 2 or 3. 0, the default, leaves the
+	// protocol out, which any graphite-web can load.
+	Protocol int `yaml:"protocol"`
+	// MaxFindSize is the most bytes a find response is encoded in. Larger
+	// ones fail rather than be sent. 0 means no limit.
+	MaxFindSize int `yaml:"maxFindSize"`
+}
+
 // Common is the configuration shared by carbonapi and carbonzipper
 type Common struct {
 	Listen            string    `yaml:"listen"`
@@ -164,16 +222,75 @@ type Common struct {
 	Backends          []string  `yaml:"backends"`
 	BackendsByCluster []Cluster `yaml:"backendsByCluster"`
 	BackendsByDC      []DC      `yaml:"backendsByDC"`
+	// Discovery finds the backends of carbonzipper, instead of the lists
+	// above.
+	Discovery Discovery `yaml:"discovery"`
+	// RemoteConfig is where carbonzipper fetches its backends from,
+	// instead of the lists above.
+	RemoteConfig RemoteConfig `yaml:"remoteConfig"`
 
 	MaxProcs                  int           `yaml:"maxProcs"`
 	Timeouts                  Timeouts      `yaml:"timeouts"`
 	ConcurrencyLimitPerServer int           `yaml:"concurrencyLimit"`
 	KeepAliveInterval         time.Duration `yaml:"keepAliveInterval"`
 	MaxIdleConnsPerHost       int           `yaml:"maxIdleConnsPerHost"`
+	// MaxQueueDepthPerServer limits the requests waiting for the
+	// concurrency limit of each backend. The ones beyond fail at once,
+	// rather than pile up. 0 means no limit.
+	MaxQueueDepthPerServer int `yaml:"maxQueueDepth"`
+	// IdleConnTimeout closes the connections to backends that are idle for
+	// that long. 0 keeps them open.
+	IdleConnTimeout time.Duration `yaml:"idleConnTimeout"`
+	// BackendCompression asks HTTP backends for responses compressed in
+	// gzip, which saves bandwidth at the cost of CPU on both sides.
+	BackendCompression bool `yaml:"backendCompression"`
+	// BackendHTTP2 lets the connections to HTTPS backends use HTTP/2.
+	BackendHTTP2 bool `yaml:"backendHTTP2"`
+
+	// BackendProtocol is the protocol used to talk to backends. One of
+	// carbonapi_v2_pb (default), carbonapi_v3_pb, or auto, which asks each
+	// backend for its capabilities.
+	BackendProtocol string `yaml:"backendProtocol"`
+	// BackendEndpointProtocols override BackendProtocol per endpoint for
+	// single backends, keyed by their address, e.g. for a backend that
+	// answers find only in pickle.
+	BackendEndpointProtocols map[string]EndpointProtocols `yaml:"backendEndpointProtocols"`
+	// BackendFallbackProtocol is the protocol a request to an HTTP backend
+	// is retried with once if its response can not be decoded, e.g. while
+	// backends are upgraded to another protocol. Empty means no retry.
+	BackendFallbackProtocol string `yaml:"backendFallbackProtocol"`
+	// GRPCPoolSize is the number of connections kept open to each backend
+	// addressed as grpc://host:port. Defaults to 1.
+	GRPCPoolSize int `yaml:"grpcPoolSize"`
+	// InstanceID identifies this instance in the User-Agent of backend
+	// requests. Defaults to the hostname.
+	InstanceID string `yaml:"instanceID"`
+	// BackendQueryParams are added to the query string of every request to
+	// an HTTP backend, e.g. source: carbonapi-dc1.
+	BackendQueryParams map[string]string `yaml:"backendQueryParams"`
+	// BackendRewrites rename metrics on the fly for single backends, keyed
+	// by their address, e.g. for a backend that stores them under another
+	// prefix than the one clients know. The first rule that matches a name
+	// applies.
+	BackendRewrites map[string][]NameRewrite `yaml:"backendRewrites"`
 
 	ExpireDelaySec             int32 `yaml:"expireDelaySec"`
 	InternalRoutingCache       int32 `yaml:"internalRoutingCache"`
 	GraphiteWeb09Compatibility bool  `yaml:"graphite09compat"`
+	// Pickle configures the find responses encoded in pickle.
+	Pickle PickleConfig `yaml:"pickle"`
+
+	// Routing configures which backends carbonzipper sends a query to.
+	Routing Routing `yaml:"routing"`
+	// Compression configures the compression of the responses of
+	// carbonzipper.
+	Compression Compression `yaml:"compression"`
+	// ResponsePolicy is how carbonzipper answers when some of the backends
+	// of a request fail, partial by default.
+	ResponsePolicy ResponsePolicy `yaml:"responsePolicy"`
+	// RemoteRead configures the Prometheus remote read endpoint of
+	// carbonzipper.
+	RemoteRead RemoteReadConfig `yaml:"remoteRead"`
 
 	Buckets      int            `yaml:"buckets"`
 	Graphite     GraphiteConfig `yaml:"graphite"`
@@ -206,6 +323,16 @@ type RenderReplicaMismatchConfig struct {
 	// RenderReplicaMismatchReportLimit limits the number of mismatched metrics to be logged
 	// for a single render request.
 	RenderReplicaMismatchReportLimit int `yaml:"renderReplicaMismatchReportLimit"`
+
+	// RenderSanitize checks the metrics of each backend before they are
+	// merged: the ones with invalid steps are dropped, the ones whose stop
+	// time does not follow from their values are fixed, and the ones a
+	// backend answers more than once are merged by RenderDuplicatePolicy.
+	RenderSanitize bool `yaml:"renderSanitize"`
+
+	// RenderDuplicatePolicy resolves the points a backend answers more than
+	// once when RenderSanitize is enabled.
+	RenderDuplicatePolicy DuplicatePolicy `yaml:"renderDuplicatePolicy"`
 }
 
 func (c *RenderReplicaMismatchConfig) String() string {
@@ -244,6 +371,21 @@ func (common Common) GetBackends() []string {
 	return backends
 }
 
+// BackendUserAgent returns the User-Agent that program, at version, sends to
+// the backends.
+func (common Common) BackendUserAgent(program, version string) string {
+	instance := common.InstanceID
+	if instance == "" {
+		/* #nosec */
+		instance, _ = os.Hostname()
+	}
+	if version == "" {
+		version = "unknown"
+	}
+
+	return fmt.Sprintf("%s/%s (%s)", program, version, instance)
+}
+
 // InfoOfBackend returns the dc and cluster of a given backend address from common configuration
 func (common Common) InfoOfBackend(address string) (string, string, error) {
 	for _, dc := range common.BackendsByDC {
@@ -303,6 +445,110 @@ type Timeouts struct {
 	Connect      time.Duration `yaml:"connect"`
 }
 
+// Discovery configures how carbonzipper finds its backends
+type Discovery struct {
+	// Type is srv, to resolve DNS SRV records, or file, to read a JSON or
+	// YAML list of backends. Empty disables discovery.
+	Type string `yaml:"type"`
+	// Name is the SRV name, e.g. _carbon._tcp.graphite.svc.cluster.local,
+	// or the path of the file.
+	Name string `yaml:"name"`
+	// Scheme is the scheme of the backends found by SRV, http or grpc.
+	// Defaults to http.
+	Scheme string `yaml:"scheme"`
+	// Interval is how often the backends are looked up again. On Linux,
+	// the file is also read again as soon as it changes.
+	Interval time.Duration `yaml:"interval"`
+}
+
+// RemoteConfig configures where carbonzipper fetches its backends and
+// routing rules from, for fleets to be reconfigured from a single place.
+type RemoteConfig struct {
+	// URL is the http, https or s3 URL of a config in the format of the
+	// config file, of which only the backends and the routing rules are
+	// used. s3://bucket/key is read from the HTTPS endpoint of the bucket,
+	// without signing the requests. Empty disables it.
+	URL string `yaml:"url"`
+	// Interval is how often the config is fetched again. It is only
+	// applied when its ETag, or its content without one, changed.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout bounds each fetch of the config.
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// Routing configures prefix-based routing of queries to backends
+type Routing struct {
+	// PrefixDepth is the number of leading path segments that are probed on
+	// each backend and used to route queries. 0 or 1 means that only the
+	// top-level domain is used.
+	PrefixDepth int `yaml:"prefixDepth"`
+	// Rules explicitly map metric prefixes to groups of backends. The rule
+	// with the longest matching prefix wins, and rules take precedence over
+	// the probed prefixes.
+	Rules []RoutingRule `yaml:"rules"`
+	// Hash maps metrics to the shards that own them, as the relay in front
+	// of the backends does. Rules take precedence over it.
+	Hash HashRouting `yaml:"hash"`
+	// Namespaces limit the metrics that single backends, keyed by their
+	// address, are queried for. They apply after the routing above.
+	Namespaces map[string]Namespace `yaml:"namespaces"`
+}
+
+// Namespace limits the metrics a backend is queried for by prefix: the ones
+// under any of Allow, if set, and under none of Deny.
+type Namespace struct {
+	Allow []string `yaml:"allow"`
+	Deny  []string `yaml:"deny"`
+}
+
+// HashRouting sends the queries for a metric only to the shard that owns it,
+// hashed like carbon-c-relay and carbon-relay-ng do. Globs can't be hashed,
+// so their queries are still routed by prefix.
+type HashRouting struct {
+	// Type is the hash of the relay: carbon_ch, fnv1a_ch or jump_fnv1a_ch.
+	// Empty disables hash routing.
+	Type string `yaml:"type"`
+	// Replicas is the number of shards the relay writes each metric to.
+	// Default: 1
+	Replicas int `yaml:"replicas"`
+	// Shards are the servers of the hash ring of the relay, in its order,
+	// which matters for jump_fnv1a_ch.
+	Shards []HashShard `yaml:"shards"`
+}
+
+// HashShard is a server of the hash ring of the relay, and the backends that
+// hold its metrics.
+type HashShard struct {
+	// Server is the server as in the relay config, "host:port" or
+	// "host:port=instance", which the ring is built from.
+	Server   string   `yaml:"server"`
+	Backends []string `yaml:"backends"`
+	Clusters []string `yaml:"clusters"`
+}
+
+// RoutingRule sends the queries for metrics under Prefix to the listed
+// backends and to all backends of the listed clusters
+type RoutingRule struct {
+	Prefix   string   `yaml:"prefix"`
+	Backends []string `yaml:"backends"`
+	Clusters []string `yaml:"clusters"`
+}
+
+// NameRewrite renames metrics between the names known by clients and the
+// ones stored by a backend. It either replaces Prefix with BackendPrefix,
+// either of which may be empty to add or strip a prefix, or replaces the
+// matches of the regular expressions Match in requests and ResponseMatch in
+// responses, which should undo each other.
+type NameRewrite struct {
+	Prefix        string `yaml:"prefix"`
+	BackendPrefix string `yaml:"backendPrefix"`
+
+	Match           string `yaml:"match"`
+	Replace         string `yaml:"replace"`
+	ResponseMatch   string `yaml:"responseMatch"`
+	ResponseReplace string `yaml:"responseReplace"`
+}
+
 // Cluster is a definition for set of backends
 type Cluster struct {
 	Name     string   `yaml:"name"`
@@ -347,3 +593,83 @@ func (cm *ReplicaMatchMode) UnmarshalYAML(unmarshal func(interface{}) error) err
 	}
 	return nil
 }
+
+// ResponsePolicy is how carbonzipper answers when some of the backends of a
+// request fail.
+type ResponsePolicy string
+
+const (
+	// ResponsePolicyPartial answers with the responses of the backends that
+	// did not fail, and fails only if they all did.
+	ResponsePolicyPartial ResponsePolicy = "partial"
+	// ResponsePolicyStrict fails if any backend fails, other than not
+	// finding the metrics.
+	ResponsePolicyStrict ResponsePolicy = "strict"
+)
+
+// ParseResponsePolicy returns the policy named s.
+func ParseResponsePolicy(s string) (ResponsePolicy, error) {
+	switch p := ResponsePolicy(s); p {
+	case ResponsePolicyPartial, ResponsePolicyStrict:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown response policy %q, expected %s or %s", s, ResponsePolicyPartial, ResponsePolicyStrict)
+}
+
+func (p *ResponsePolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	policy, err := ParseResponsePolicy(s)
+	if err != nil {
+		return err
+	}
+	*p = policy
+
+	return nil
+}
+
+// DuplicatePolicy is which of the values a backend answers for the same
+// point of a metric is kept.
+type DuplicatePolicy string
+
+const (
+	// DuplicatePolicyFirst keeps the value answered first.
+	DuplicatePolicyFirst DuplicatePolicy = "first"
+	// DuplicatePolicyLast keeps the value answered last, the most recent
+	// after the restart of a backend.
+	DuplicatePolicyLast DuplicatePolicy = "last"
+	// DuplicatePolicyMax keeps the largest value.
+	DuplicatePolicyMax DuplicatePolicy = "max"
+	// DuplicatePolicyMin keeps the smallest value.
+	DuplicatePolicyMin DuplicatePolicy = "min"
+)
+
+// ParseDuplicatePolicy returns the policy named s.
+func ParseDuplicatePolicy(s string) (DuplicatePolicy, error) {
+	switch p := DuplicatePolicy(s); p {
+	case DuplicatePolicyFirst, DuplicatePolicyLast, DuplicatePolicyMax, DuplicatePolicyMin:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown duplicate policy %q, expected %s, %s, %s or %s", s,
+		DuplicatePolicyFirst, DuplicatePolicyLast, DuplicatePolicyMax, DuplicatePolicyMin)
+}
+
+func (p *DuplicatePolicy) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+
+	policy, err := ParseDuplicatePolicy(s)
+	if err != nil {
+		return err
+	}
+	*p = policy
+
+	return nil
+}
@@ -1,6 +1,8 @@
 package cfg
 
 import (
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -589,3 +591,75 @@ func eqCommon(a, b Common) bool {
 	return toComparableCommon(a) == toComparableCommon(b) &&
 		eqStringSlice(a.GetBackends(), b.GetBackends())
 }
+
+func TestParseCommonRouting(t *testing.T) {
+	var input = `
+listen: ":8000"
+backendsByCluster:
+    - name: "cluster1"
+      backends:
+      - "http://10.190.202.31:8080"
+    - name: "cluster2"
+      backends:
+      - "http://10.190.202.32:8080"
+routing:
+    prefixDepth: 2
+    rules:
+        - prefix: "dc1.hostclass"
+          clusters: ["cluster1"]
+        - prefix: "dc2"
+          backends: ["http://10.190.202.32:8080"]
+`
+
+	r := strings.NewReader(input)
+	got, err := ParseCommon(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := Routing{
+		PrefixDepth: 2,
+		Rules: []RoutingRule{
+			{Prefix: "dc1.hostclass", Clusters: []string{"cluster1"}},
+			{Prefix: "dc2", Backends: []string{"http://10.190.202.32:8080"}},
+		},
+	}
+	if !reflect.DeepEqual(got.Routing, expected) {
+		t.Fatalf("Didn't parse expected routing from config\nGot: %v\nExp: %v", got.Routing, expected)
+	}
+}
+
+func TestParseCommonResponsePolicy(t *testing.T) {
+	got, err := ParseCommon(strings.NewReader(`listen: ":8000"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ResponsePolicy != ResponsePolicyPartial {
+		t.Errorf("expected the %s response policy by default, got %s", ResponsePolicyPartial, got.ResponsePolicy)
+	}
+
+	got, err = ParseCommon(strings.NewReader(`responsePolicy: "strict"`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ResponsePolicy != ResponsePolicyStrict {
+		t.Errorf("expected the %s response policy, got %s", ResponsePolicyStrict, got.ResponsePolicy)
+	}
+
+	if _, err := ParseCommon(strings.NewReader(`responsePolicy: "majority"`)); err == nil {
+		t.Error("expected an unknown response policy to fail")
+	}
+}
+
+func TestBackendUserAgent(t *testing.T) {
+	c := Common{InstanceID: "zipper-dc1-01"}
+	if got := c.BackendUserAgent("carbonzipper", "1.2.3"); got != "carbonzipper/1.2.3 (zipper-dc1-01)" {
+		t.Errorf("unexpected User-Agent %q", got)
+	}
+
+	hostname, _ := os.Hostname()
+	c = Common{}
+	if got, exp := c.BackendUserAgent("carbonapi", ""), "carbonapi/unknown ("+hostname+")"; got != exp {
+		t.Errorf("expected User-Agent %q, got %q", exp, got)
+	}
+}
@@ -46,7 +46,7 @@ func main() {
 		runtime.GOMAXPROCS(config.MaxProcs)
 	}
 
-	if len(config.GetBackends()) == 0 {
+	if len(config.GetBackends()) == 0 && config.Discovery.Type == "" && config.RemoteConfig.URL == "" {
 		log.Fatal("no Backends loaded -- exiting")
 	}
 
@@ -72,6 +72,7 @@ func main() {
 	if err != nil {
 		logger.Error("Error initializing app")
 	}
+	app.SetConfigFile(*configFile)
 	flush := app.Start(logger)
 	defer flush()
 }
@@ -2,17 +2,19 @@ package date
 
 import (
 	"errors"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 var errBadTime = errors.New("time has incorrect format")
 var errBadRelativeTime = errors.New("invalid relative timestamp")
 var errTsPartsCount = errors.New("timestamp has too many parts")
 var errDateFormat = errors.New("invalid date format")
+var errTimeOutOfRange = errors.New("time out of range, it must be between 1901-12-13 and 2038-01-19")
 var timeNow = time.Now
 
 // parseTime parses a time and returns hours and minutes
@@ -48,12 +50,39 @@ func parseTime(s string) (hour, minute int, err error) {
 
 var TimeFormats = []string{"20060102", "01/02/06"}
 
-// DateParamToEpoch turns a passed string parameter into a unix epoch
+// looksLikeDate tells if the number s is a YYYYMMDD date rather than a unix
+// timestamp, the same way graphite-web does.
+func looksLikeDate(s string) bool {
+	if len(s) != 8 {
+		return false
+	}
+	yy, _ := strconv.Atoi(s[:4])
+	mm, _ := strconv.Atoi(s[4:6])
+	dd, _ := strconv.Atoi(s[6:])
+	return yy > 1900 && mm < 13 && dd < 32
+}
+
+// TimeZone returns the time zone named qtz, if it is a valid one, and
+// defaultTimeZone otherwise.
+func TimeZone(qtz string, defaultTimeZone *time.Location) *time.Location {
+	if qtz != "" {
+		if z, err := time.LoadLocation(qtz); err == nil {
+			return z
+		}
+	}
+
+	return defaultTimeZone
+}
+
+// DateParamToEpoch turns a passed string parameter into a unix epoch.
+// Absolute dates are in the qtz time zone, if it is a valid one, and in
+// defaultTimeZone otherwise. Requests are evaluated on 32-bit unix times, so
+// times that do not fit are rejected rather than wrapped around.
 func DateParamToEpoch(s string, qtz string, d int64, defaultTimeZone *time.Location) (int32, error) {
 
 	if s == "" {
 		// return the default if nothing was passed
-		return int32(d), nil
+		return epoch(d)
 	}
 
 	// relative timestamp
@@ -63,23 +92,35 @@ func DateParamToEpoch(s string, qtz string, d int64, defaultTimeZone *time.Locat
 			return 0, errBadRelativeTime
 		}
 
-		return int32(timeNow().Add(time.Duration(offset) * time.Second).Unix()), nil
+		return epoch(timeNow().Add(time.Duration(offset) * time.Second).Unix())
 	}
 
+	tz := TimeZone(qtz, defaultTimeZone)
+
 	switch s {
 	case "now":
-		return int32(timeNow().Unix()), nil
+		return epoch(timeNow().Unix())
 	case "midnight", "noon", "teatime":
-		yy, mm, dd := timeNow().Date()
+		yy, mm, dd := timeNow().In(tz).Date()
 		hh, min, _ := parseTime(s) // error ignored, we know it's valid
-		dt := time.Date(yy, mm, dd, hh, min, 0, 0, defaultTimeZone)
-		return int32(dt.Unix()), nil
+		dt := time.Date(yy, mm, dd, hh, min, 0, 0, tz)
+		return epoch(dt.Unix())
+	}
+
+	// relative to now, e.g. now-1h
+	if strings.HasPrefix(s, "now-") || strings.HasPrefix(s, "now+") {
+		offset, err := parser.IntervalString(s[3:], 1)
+		if err != nil {
+			return 0, errBadRelativeTime
+		}
+
+		return epoch(timeNow().Add(time.Duration(offset) * time.Second).Unix())
 	}
 
 	sint, err := strconv.ParseInt(s, 10, 64)
-	// need to check that len(s) > 8 to avoid turning 20060102 into seconds
-	if err == nil && len(s) > 8 {
-		return int32(sint), nil // We got a timestamp so returning it
+	// like graphite-web, take 8 digits that look like YYYYMMDD as a date
+	if err == nil && !looksLikeDate(s) {
+		return epoch(sint) // We got a timestamp so returning it
 	}
 
 	s = strings.Replace(s, "_", " ", 1) // Go can't parse _ in date strings
@@ -96,23 +137,16 @@ func DateParamToEpoch(s string, qtz string, d int64, defaultTimeZone *time.Locat
 		return 0, errTsPartsCount
 	}
 
-	var tz = defaultTimeZone
-	if qtz != "" {
-		if z, loadErr := time.LoadLocation(qtz); loadErr != nil {
-			tz = z
-		}
-	}
-
 	var t time.Time
 dateStringSwitch:
 	switch ds {
 	case "today":
-		t = timeNow()
+		t = timeNow().In(tz)
 		// nothing
 	case "yesterday":
-		t = timeNow().AddDate(0, 0, -1)
+		t = timeNow().In(tz).AddDate(0, 0, -1)
 	case "tomorrow":
-		t = timeNow().AddDate(0, 0, 1)
+		t = timeNow().In(tz).AddDate(0, 0, 1)
 	default:
 		for _, format := range TimeFormats {
 			t, err = time.ParseInLocation(format, ds, tz)
@@ -134,7 +168,16 @@ dateStringSwitch:
 	}
 
 	yy, mm, dd := t.Date()
-	t = time.Date(yy, mm, dd, hour, minute, 0, 0, defaultTimeZone)
+	t = time.Date(yy, mm, dd, hour, minute, 0, 0, tz)
+
+	return epoch(t.Unix())
+}
+
+// epoch returns the unix time t in the 32 bits the evaluator works in.
+func epoch(t int64) (int32, error) {
+	if t < math.MinInt32 || t > math.MaxInt32 {
+		return 0, errTimeOutOfRange
+	}
 
-	return int32(t.Unix()), nil
+	return int32(t), nil
 }
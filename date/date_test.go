@@ -40,6 +40,8 @@ func TestDateParamToEpoch(t *testing.T) {
 		{"today", "00:00 1994-Aug-16", false},
 		{"yesterday", "00:00 1994-Aug-15", false},
 		{"1556201160", time.Unix(1556201160, 0).Format(shortForm), false}, // time.Unix returns a local time
+		{"1140", time.Unix(1140, 0).Format(shortForm), false},
+		{"19941300", time.Unix(19941300, 0).Format(shortForm), false},
 		{"", defaultTsStr, false},
 		{"-something", defaultTsStr, true},
 		{"17:04 19940812 1001", defaultTsStr, true},
@@ -47,6 +49,12 @@ func TestDateParamToEpoch(t *testing.T) {
 		{"12:30 08-15-06", defaultTsStr, true},
 		{"08/15/06 12:30", defaultTsStr, true},
 		{"+5m", defaultTsStr, true},
+		{"now-1h", "14:30 1994-Aug-16", false},
+		{"now+1d", "15:30 1994-Aug-17", false},
+		{"now-", defaultTsStr, true},
+		{"2147483648", defaultTsStr, true},
+		{"20400101", defaultTsStr, true},
+		{"now+50y", defaultTsStr, true},
 	}
 
 	defaultTime, _ := time.ParseInLocation(shortForm, defaultTsStr, defaultTimeZone)
@@ -71,3 +79,39 @@ func TestDateParamToEpoch(t *testing.T) {
 		}
 	}
 }
+
+func TestDateParamToEpochTimeZone(t *testing.T) {
+	timeNow = func() time.Time {
+		//16 Aug 1994 23:30 UTC, already the 17th in Amsterdam
+		return time.Date(1994, time.August, 16, 23, 30, 0, 0, time.UTC)
+	}
+
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Skip("time zone database is not available")
+	}
+
+	var tests = []struct {
+		input string
+		qtz   string
+		want  time.Time
+	}{
+		{"19940812", "", time.Date(1994, time.August, 12, 0, 0, 0, 0, time.UTC)},
+		{"19940812", "Europe/Amsterdam", time.Date(1994, time.August, 12, 0, 0, 0, 0, amsterdam)},
+		{"12:00 19940812", "Europe/Amsterdam", time.Date(1994, time.August, 12, 12, 0, 0, 0, amsterdam)},
+		{"noon", "Europe/Amsterdam", time.Date(1994, time.August, 17, 12, 0, 0, 0, amsterdam)},
+		{"today", "Europe/Amsterdam", time.Date(1994, time.August, 17, 0, 0, 0, 0, amsterdam)},
+		{"19940812", "Not/AZone", time.Date(1994, time.August, 12, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, tt := range tests {
+		got, err := DateParamToEpoch(tt.input, tt.qtz, 0, time.UTC)
+		if err != nil {
+			t.Errorf("DateParamToEpoch(%q, %q) failed: %v", tt.input, tt.qtz, err)
+			continue
+		}
+		if want := int32(tt.want.Unix()); got != want {
+			t.Errorf("DateParamToEpoch(%q, %q)=%v, want %v", tt.input, tt.qtz, got, want)
+		}
+	}
+}
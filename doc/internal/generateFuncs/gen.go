@@ -41,10 +41,10 @@ import (
 	"strings"
 `)
 	for _, m := range funcs {
-		fmt.Fprintf(writer, "	\"github.com/bookingcom/carbonapi/expr/functions/%s\"\n", m)
+		fmt.Fprintf(writer, "	\"github.com/bookingcom/carbonapi/internal/expr/functions/%s\"\n", m)
 	}
-	fmt.Fprintf(writer, `	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/metadata"
+	fmt.Fprintf(writer, `	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
 )
 
 type initFunc struct {
@@ -1,3 +0,0 @@
-package functions
-
-//go:generate go run ../../doc/internal/generateFuncs/gen.go
@@ -5,12 +5,12 @@ import (
 	"context"
 	"fmt"
 
-	_ "github.com/bookingcom/carbonapi/expr/functions"
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/metadata"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	_ "github.com/bookingcom/carbonapi/internal/expr/functions"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -40,7 +40,7 @@ func EvalExpr(ctx context.Context, e parser.Expr, from, until int32, values map[
 	}
 
 	if e.IsName() {
-		val := values[parser.MetricRequest{Metric: e.Target(), From: from, Until: until}]
+		val := values[parser.MetricRequest{Metric: e.Target(), From: int64(from), Until: int64(until)}]
 		if val == nil {
 			return nil, parser.ErrSeriesDoesNotExist
 		}
@@ -65,9 +65,32 @@ func EvalExpr(ctx context.Context, e parser.Expr, from, until int32, values map[
 	metadata.FunctionMD.RLock()
 	f, ok := metadata.FunctionMD.Functions[e.Target()]
 	metadata.FunctionMD.RUnlock()
-	if ok {
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", helper.ErrUnknownFunction, e.Target())
+	}
+
+	m := getMemo(ctx, values)
+	if stacks(e) {
+		// each call adds to the running totals of the request
+		m = nil
+	}
+	var key memoKey
+	if m != nil {
+		key = memoKey{target: e.ToString(), from: from, until: until}
+		if res, ok := m.get(key); ok {
+			return res, nil
+		}
+	}
+
+	ctx = interfaces.WithFetchStats(ctx, func() interfaces.FetchStats {
+		return fetchStats(e, from, until, values)
+	})
+	res, err := evalWithStats(ctx, e.Target(), func(ctx context.Context) ([]*types.MetricData, error) {
 		return f.Do(ctx, e, from, until, values, getTargetData)
+	})
+	if m != nil && err == nil {
+		m.set(key, res)
 	}
 
-	return nil, fmt.Errorf("%w: %s", helper.ErrUnknownFunction, e.Target())
+	return res, err
 }
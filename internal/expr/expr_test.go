@@ -10,10 +10,10 @@ import (
 
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/functions"
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/functions"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 	th "github.com/bookingcom/carbonapi/tests"
 )
@@ -173,18 +173,18 @@ func TestEvalExpr(t *testing.T) {
 			metricMap := make(map[parser.MetricRequest][]*types.MetricData)
 			request := parser.MetricRequest{
 				Metric: test.metric,
-				From:   test.from,
-				Until:  test.until,
+				From:   int64(test.from),
+				Until:  int64(test.until),
 			}
 
 			data := types.MetricData{
 				Metric: dataTypes.Metric{
 					Name:      request.Metric,
-					StartTime: request.From,
-					StopTime:  request.Until,
+					StartTime: test.from,
+					StopTime:  test.until,
 					StepTime:  test.stepTime,
 					Values:    test.values,
-					IsAbsent:  test.isAbsent,
+					IsAbsent:  types.AbsenceOf(test.isAbsent...),
 				},
 			}
 
@@ -192,7 +192,7 @@ func TestEvalExpr(t *testing.T) {
 				&data,
 			}
 
-			EvalExpr(ctx, exp, int32(request.From), int32(request.Until), metricMap, noopGetTargetData)
+			EvalExpr(ctx, exp, test.from, test.until, metricMap, noopGetTargetData)
 		})
 	}
 }
@@ -348,6 +348,20 @@ func TestEvalExpression(t *testing.T) {
 			},
 			[]*types.MetricData{types.MakeMetricData("movingSum(metric1,2)", []float64{math.NaN(), math.NaN(), 3, 5, 7, 9}, 1, now32)},
 		},
+		{
+			"movingSum(metric1,2,0.5)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, math.NaN(), math.NaN(), 4, 5, 6}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("movingSum(metric1,2)", []float64{math.NaN(), math.NaN(), 1, math.NaN(), 4, 9}, 1, now32)},
+		},
+		{
+			"movingSum(metric1,2,xFilesFactor=1)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, math.NaN(), math.NaN(), 4, 5, 6}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("movingSum(metric1,2)", []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN(), 9}, 1, now32)},
+		},
 		{
 			"movingMin(metric1,2)",
 			map[parser.MetricRequest][]*types.MetricData{
@@ -886,6 +900,20 @@ func TestEvalExpression(t *testing.T) {
 			[]*types.MetricData{types.MakeMetricData("removeAbovePercentile(metric1, 50)",
 				[]float64{1, 2, -1, 7, math.NaN(), math.NaN(), math.NaN(), math.NaN()}, 1, now32)},
 		},
+		{
+			"removeBetweenPercentile(metric*, 30)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric*", 0, 1}: {
+					types.MakeMetricData("metric1", []float64{1, 1, 1}, 1, now32),
+					types.MakeMetricData("metric2", []float64{2, math.NaN(), 2}, 1, now32),
+					types.MakeMetricData("metric3", []float64{3, 3, 10}, 1, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("metric1", []float64{1, 1, 1}, 1, now32),
+				types.MakeMetricData("metric3", []float64{3, 3, 10}, 1, now32),
+			},
+		},
 		{
 			"linearRegression(metric1)",
 			map[parser.MetricRequest][]*types.MetricData{
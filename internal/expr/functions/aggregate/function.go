@@ -0,0 +1,94 @@
+package aggregate
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type aggregate struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &aggregate{}
+	functions := []string{"aggregate"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// aggregate(seriesList, func, xFilesFactor=None)
+func (f *aggregate) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	aggFunc, err := e.GetStringNamedOrPosArgDefault("func", 1, "")
+	if err != nil {
+		return nil, err
+	}
+	if aggFunc == "" {
+		return nil, parser.ErrMissingArgument
+	}
+	xFilesFactor, err := helper.GetXFilesFactor(e, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	name := fmt.Sprintf("%sSeries(%s)", aggFunc, e.Args()[0].ToString())
+	return helper.AggregateSeriesByName(name, args, aggFunc, xFilesFactor)
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *aggregate) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"aggregate": {
+			Description: "Aggregate series using the specified function.\n\nExample:\n\n.. code-block:: none\n\n  &target=aggregate(host.cpu-[0-7].cpu-{user,system}.value, \"sum\")\n\nThis would be the equivalent of\n\n.. code-block:: none\n\n  &target=sumSeries(host.cpu-[0-7].cpu-{user,system}.value)\n\nThis function can be used with aggregation functions ``average``, ``median``, ``sum``, ``min``,\n``max``, ``diff``, ``stddev``, ``count``, ``range``, ``multiply`` & ``last``.",
+			Function:    "aggregate(seriesList, func, xFilesFactor=None)",
+			Group:       "Combine",
+			Module:      "graphite.render.functions",
+			Name:        "aggregate",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "func",
+					Required: true,
+					Options: []string{
+						"average",
+						"count",
+						"diff",
+						"last",
+						"max",
+						"median",
+						"min",
+						"multiply",
+						"range",
+						"stddev",
+						"sum",
+					},
+					Type: types.AggFunc,
+				},
+				{
+					Name: "xFilesFactor",
+					Type: types.Float,
+				},
+			},
+		},
+	}
+}
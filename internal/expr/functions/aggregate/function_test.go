@@ -0,0 +1,109 @@
+package aggregate
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestAggregate(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	metrics := map[parser.MetricRequest][]*types.MetricData{
+		{"m.*", 0, 1}: {
+			types.MakeMetricData("m.a", []float64{1, 2, 3, math.NaN(), 5}, 1, now32),
+			types.MakeMetricData("m.b", []float64{4, 6, 3, math.NaN(), math.NaN()}, 1, now32),
+			types.MakeMetricData("m.c", []float64{1, 1, 3, math.NaN(), 2}, 1, now32),
+		},
+	}
+
+	tests := []struct {
+		aggFunc string
+		want    []float64
+	}{
+		{"sum", []float64{6, 9, 9, math.NaN(), 7}},
+		{"total", []float64{6, 9, 9, math.NaN(), 7}},
+		{"avg", []float64{2, 3, 3, math.NaN(), 3.5}},
+		{"average", []float64{2, 3, 3, math.NaN(), 3.5}},
+		{"median", []float64{1, 2, 3, math.NaN(), 3.5}},
+		{"min", []float64{1, 1, 3, math.NaN(), 2}},
+		{"max", []float64{4, 6, 3, math.NaN(), 5}},
+		{"diff", []float64{-4, -5, -3, math.NaN(), 3}},
+		{"stddev", []float64{math.Sqrt(2), math.Sqrt(14.0 / 3), 0, math.NaN(), 1.5}},
+		{"count", []float64{3, 3, 3, math.NaN(), 2}},
+		{"range", []float64{3, 5, 0, math.NaN(), 3}},
+		{"rangeOf", []float64{3, 5, 0, math.NaN(), 3}},
+		{"multiply", []float64{4, 12, 27, math.NaN(), math.NaN()}},
+		{"last", []float64{1, 1, 3, math.NaN(), 2}},
+		{"current", []float64{1, 1, 3, math.NaN(), 2}},
+	}
+
+	for _, tst := range tests {
+		tt := th.EvalTestItem{
+			Target: `aggregate(m.*,"` + tst.aggFunc + `")`,
+			M:      metrics,
+			Want: []*types.MetricData{
+				types.MakeMetricData(tst.aggFunc+"Series(m.*)", tst.want, 1, now32),
+			},
+		}
+		t.Run(tt.Target, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestAggregateXFilesFactor(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	tt := th.EvalTestItem{
+		Target: `aggregate(m.*,"sum",0.6)`,
+		M: map[parser.MetricRequest][]*types.MetricData{
+			{"m.*", 0, 1}: {
+				types.MakeMetricData("m.a", []float64{1, 2, 3}, 1, now32),
+				types.MakeMetricData("m.b", []float64{1, math.NaN(), math.NaN()}, 1, now32),
+				types.MakeMetricData("m.c", []float64{1, 2, math.NaN()}, 1, now32),
+			},
+		},
+		Want: []*types.MetricData{
+			types.MakeMetricData("sumSeries(m.*)", []float64{3, 4, math.NaN()}, 1, now32),
+		},
+	}
+	th.TestEvalExpr(t, &tt)
+}
+
+func TestAggregateUnknownFunction(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	for _, target := range []string{`aggregate(m.*,"foo")`, `aggregate(m.*)`} {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		metrics := map[parser.MetricRequest][]*types.MetricData{
+			{"m.*", 0, 1}: {types.MakeMetricData("m.a", []float64{1}, 1, now32)},
+		}
+		_, err = metadata.GetEvaluator().EvalExpr(context.Background(), exp, 0, 1, metrics, th.NoopGetTargetData)
+		if err == nil {
+			t.Errorf("expected an error for %s", target)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package aggregateLine
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type aggregateLine struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &aggregateLine{}
+	functions := []string{"aggregateLine"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// aggregateLine(seriesList, func='average', keepStep=False)
+func (f *aggregateLine) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	aggFunc, err := e.GetStringNamedOrPosArgDefault("func", 1, "average")
+	if err != nil {
+		return nil, err
+	}
+	aggregation, err := helper.GetAggregateFunc(aggFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	keepStep, err := e.GetBoolNamedOrPosArgDefault("keepStep", 2, false)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*types.MetricData, 0, len(args))
+	for _, a := range args {
+		var known []float64
+		for i, v := range a.Values {
+			if !a.IsAbsent.Get(i) {
+				known = append(known, v)
+			}
+		}
+
+		value, absent := 0.0, true
+		if len(known) > 0 {
+			value, absent = aggregation(known)
+		}
+
+		r := &types.MetricData{Metric: a.Metric}
+		r.Name = fmt.Sprintf("aggregateLine(%s, %.6g)", a.Name, value)
+		if absent {
+			r.Name = fmt.Sprintf("aggregateLine(%s, None)", a.Name)
+		}
+
+		n := len(a.Values)
+		if !keepStep {
+			// Like constantLine, a line over the whole range.
+			n = 3
+			r.StartTime = from
+			r.StopTime = until
+			r.StepTime = (until - from) / 2
+		}
+		r.Values = make([]float64, n)
+		r.IsAbsent = types.NewAbsence(n)
+		for i := range r.Values {
+			r.Values[i] = value
+			r.IsAbsent.Set(i, absent)
+		}
+
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *aggregateLine) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"aggregateLine": {
+			Description: "Takes a metric or wildcard seriesList and draws a horizontal line\nbased on the function applied to each series.\n\nIf the optional keepStep parameter is set to True, the result will\nhave the same time period and step as the source series.\n\nNote: By default, the graphite renderer consolidates data points by\naveraging data points over time. If you are using the 'min' or 'max'\nfunction for aggregateLine, this can cause an unusual gap in the\nline drawn by this function and the data itself. To fix this, you\nshould use the consolidateBy() function with the same function\nargument you are using for aggregateLine. This will ensure that the\nproper data points are retained and the graph should line up\ncorrectly.\n\nExample:\n\n.. code-block:: none\n\n  &target=aggregateLine(server01.connections.total, 'avg')\n  &target=aggregateLine(server*.connections.total, 'avg')",
+			Function:    "aggregateLine(seriesList, func='average', keepStep=False)",
+			Group:       "Calculate",
+			Module:      "graphite.render.functions",
+			Name:        "aggregateLine",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Default: types.NewSuggestion("average"),
+					Name:    "func",
+					Options: []string{
+						"average",
+						"count",
+						"diff",
+						"last",
+						"max",
+						"median",
+						"min",
+						"multiply",
+						"range",
+						"stddev",
+						"sum",
+					},
+					Type: types.AggFunc,
+				},
+				{
+					Default: types.NewSuggestion(false),
+					Name:    "keepStep",
+					Type:    types.Boolean,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,94 @@
+package aggregateLine
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestAggregateLineKeepStep(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	metrics := map[parser.MetricRequest][]*types.MetricData{
+		{"m.*", 0, 1}: {
+			types.MakeMetricData("m.a", []float64{1, 2, math.NaN(), 5}, 1, now32),
+			types.MakeMetricData("m.b", []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN()}, 1, now32),
+		},
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			"aggregateLine(m.*,keepStep=true)",
+			metrics,
+			[]*types.MetricData{
+				types.MakeMetricData("aggregateLine(m.a, 2.66667)", []float64{8.0 / 3, 8.0 / 3, 8.0 / 3, 8.0 / 3}, 1, now32),
+				types.MakeMetricData("aggregateLine(m.b, None)", []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN()}, 1, now32),
+			},
+		},
+		{
+			`aggregateLine(m.*,"max",true)`,
+			metrics,
+			[]*types.MetricData{
+				types.MakeMetricData("aggregateLine(m.a, 5)", []float64{5, 5, 5, 5}, 1, now32),
+				types.MakeMetricData("aggregateLine(m.b, None)", []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN()}, 1, now32),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		th.TestEvalExpr(t, &tt)
+	}
+}
+
+func TestAggregateLine(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	from, until := now32-100, now32
+
+	exp, _, err := parser.ParseExpr(`aggregateLine(m.a,"sum")`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics := map[parser.MetricRequest][]*types.MetricData{
+		{"m.a", int64(from), int64(until)}: {
+			types.MakeMetricData("m.a", []float64{1, 2, math.NaN(), 5}, 1, now32),
+		},
+	}
+
+	g, err := metadata.GetEvaluator().EvalExpr(context.Background(), exp, from, until, metrics, th.NoopGetTargetData)
+	if err != nil {
+		t.Fatalf("failed to eval: %v", err)
+	}
+	if len(g) != 1 {
+		t.Fatalf("expected 1 series, got %d", len(g))
+	}
+
+	want := types.MakeMetricData("aggregateLine(m.a, 8)", []float64{8, 8, 8}, 50, from)
+	if g[0].Name != want.Name {
+		t.Errorf("bad Name: got %s, want %s", g[0].Name, want.Name)
+	}
+	if g[0].StartTime != from || g[0].StopTime != until || g[0].StepTime != 50 {
+		t.Errorf("expected a line from %d to %d with step 50, got %d to %d with step %d",
+			from, until, g[0].StartTime, g[0].StopTime, g[0].StepTime)
+	}
+	if !th.NearlyEqualMetrics(g[0], want) {
+		t.Errorf("bad values: got %v, want %v", g[0].Values, want.Values)
+	}
+}
@@ -0,0 +1,180 @@
+package aggregateSeriesLists
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type aggregateSeriesLists struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &aggregateSeriesLists{}
+	functions := []string{"aggregateSeriesLists", "combine"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// aggregateSeriesLists(seriesListFirstPos, seriesListSecondPos, func, xFilesFactor=None)
+// combine(seriesListA, seriesListB, func, mismatchPolicy='error', xFilesFactor=None)
+func (f *aggregateSeriesLists) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	if len(e.Args()) < 3 {
+		return nil, parser.ErrMissingArgument
+	}
+
+	first, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+	second, err := helper.GetSeriesArg(ctx, e.Args()[1], from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	aggFunc, err := e.GetStringNamedOrPosArgDefault("func", 2, "")
+	if err != nil {
+		return nil, err
+	}
+	if aggFunc == "" {
+		return nil, parser.ErrMissingArgument
+	}
+	if _, err := helper.GetAggregateFunc(aggFunc); err != nil {
+		return nil, err
+	}
+
+	policy := helper.ListMismatchError
+	xFilesFactorPos := 3
+	if e.Target() == "combine" {
+		s, err := e.GetStringNamedOrPosArgDefault("mismatchPolicy", 3, string(helper.ListMismatchError))
+		if err != nil {
+			return nil, err
+		}
+		if policy, err = helper.ParseListMismatchPolicy(s); err != nil {
+			return nil, err
+		}
+		xFilesFactorPos = 4
+	}
+
+	xFilesFactor, err := helper.GetXFilesFactor(e, xFilesFactorPos)
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, err := helper.PairSeriesLists(first, second, policy)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.Target(), err)
+	}
+
+	results := make([]*types.MetricData, 0, len(pairs))
+	for _, pair := range pairs {
+		name := fmt.Sprintf("%sSeries(%s,%s)", aggFunc, pair[0].Name, pair[1].Name)
+		r, err := helper.AggregateSeriesByName(name, pair[:], aggFunc, xFilesFactor)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, r...)
+	}
+
+	return results, nil
+}
+
+var aggFuncOptions = []string{
+	"average",
+	"count",
+	"diff",
+	"last",
+	"max",
+	"median",
+	"min",
+	"multiply",
+	"range",
+	"stddev",
+	"sum",
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *aggregateSeriesLists) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"aggregateSeriesLists": {
+			Description: "Iterates over a two lists and aggregates using specified function\nlist1[0] to list2[0], list1[1] to list2[1] and so on.\nThe lists will need to be the same length\n\nPosition of seriesList matters. For example using \"sum\" function\n``aggregateSeriesLists(list1[0..n], list2[0..n], \"sum\")``\nit would find sum for each member\nof the list ``list1[0] + list2[0], list1[1] + list2[1], ..., list1[n] + list2[n]``.\n\nExample:\n\n.. code-block:: none\n\n  &target=aggregateSeriesLists(mining.{carbon,graphite,diamond}.extracted,mining.{carbon,graphite,diamond}.shipped, 'diff')\n\nAn example above would be the same as running :py:func:`aggregate <aggregate>` for each member of the list:\n\n.. code-block:: none\n\n  ?target=aggregate(mining.carbon.extracted,mining.carbon.shipped, 'diff')\n  &target=aggregate(mining.graphite.extracted,mining.graphite.shipped, 'diff')\n  &target=aggregate(mining.diamond.extracted,mining.diamond.shipped, 'diff')\n\nThis function can be used with aggregation functions ``average``, ``median``, ``sum``, ``min``,\n``max``, ``diff``, ``stddev``, ``count``, ``range``, ``multiply`` & ``last``.",
+			Function:    "aggregateSeriesLists(seriesListFirstPos, seriesListSecondPos, func, xFilesFactor=None)",
+			Group:       "Combine",
+			Module:      "graphite.render.functions",
+			Name:        "aggregateSeriesLists",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesListFirstPos",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "seriesListSecondPos",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "func",
+					Required: true,
+					Options:  aggFuncOptions,
+					Type:     types.AggFunc,
+				},
+				{
+					Name: "xFilesFactor",
+					Type: types.Float,
+				},
+			},
+		},
+		"combine": {
+			Description: "Iterates over two lists and aggregates list1[0] with list2[0], list1[1] with list2[1] and so on,\nlike aggregateSeriesLists, choosing what to do when the lists are not of the same length.\n\n``mismatchPolicy`` is ``error`` to fail, ``truncate`` to drop the series of the longer list that have no pair,\nor ``pad-null`` to aggregate them with a series of nulls named None.\n\nExample:\n\n.. code-block:: none\n\n  &target=combine(servers.*.requests,servers.*.errors,'diff','truncate')",
+			Function:    "combine(seriesListA, seriesListB, func, mismatchPolicy='error', xFilesFactor=None)",
+			Group:       "Combine",
+			Module:      "graphite.render.functions.custom",
+			Name:        "combine",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesListA",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "seriesListB",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "func",
+					Required: true,
+					Options:  aggFuncOptions,
+					Type:     types.AggFunc,
+				},
+				{
+					Name:    "mismatchPolicy",
+					Default: types.NewSuggestion("error"),
+					Options: []string{
+						string(helper.ListMismatchError),
+						string(helper.ListMismatchTruncate),
+						string(helper.ListMismatchPadNull),
+					},
+					Type: types.String,
+				},
+				{
+					Name: "xFilesFactor",
+					Type: types.Float,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,138 @@
+package aggregateSeriesLists
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestAggregateSeriesLists(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	metrics := map[parser.MetricRequest][]*types.MetricData{
+		{"a.*", 0, 1}: {
+			types.MakeMetricData("a.x", []float64{1, 2, math.NaN(), 4}, 1, now32),
+			types.MakeMetricData("a.y", []float64{5, 6, 7, 8}, 1, now32),
+			types.MakeMetricData("a.z", []float64{9, 10, 11, 12}, 1, now32),
+		},
+		{"b.*", 0, 1}: {
+			types.MakeMetricData("b.x", []float64{1, 1, 1, math.NaN()}, 1, now32),
+			types.MakeMetricData("b.y", []float64{2, 2, 2, 2}, 1, now32),
+		},
+		{"c.*", 0, 1}: {
+			types.MakeMetricData("c.x", []float64{1, 1, 1, math.NaN()}, 1, now32),
+			types.MakeMetricData("c.y", []float64{2, 2, 2, 2}, 1, now32),
+			types.MakeMetricData("c.z", []float64{3, 3, 3, 3}, 1, now32),
+		},
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			Target: `aggregateSeriesLists(a.*,c.*,"sum")`,
+			M:      metrics,
+			Want: []*types.MetricData{
+				types.MakeMetricData("sumSeries(a.x,c.x)", []float64{2, 3, 1, 4}, 1, now32),
+				types.MakeMetricData("sumSeries(a.y,c.y)", []float64{7, 8, 9, 10}, 1, now32),
+				types.MakeMetricData("sumSeries(a.z,c.z)", []float64{12, 13, 14, 15}, 1, now32),
+			},
+		},
+		{
+			Target: `aggregateSeriesLists(a.*,c.*,"diff",1)`,
+			M:      metrics,
+			Want: []*types.MetricData{
+				types.MakeMetricData("diffSeries(a.x,c.x)", []float64{0, 1, math.NaN(), math.NaN()}, 1, now32),
+				types.MakeMetricData("diffSeries(a.y,c.y)", []float64{3, 4, 5, 6}, 1, now32),
+				types.MakeMetricData("diffSeries(a.z,c.z)", []float64{6, 7, 8, 9}, 1, now32),
+			},
+		},
+		{
+			Target: `combine(a.*,b.*,"multiply","truncate")`,
+			M:      metrics,
+			Want: []*types.MetricData{
+				types.MakeMetricData("multiplySeries(a.x,b.x)", []float64{1, 2, math.NaN(), math.NaN()}, 1, now32),
+				types.MakeMetricData("multiplySeries(a.y,b.y)", []float64{10, 12, 14, 16}, 1, now32),
+			},
+		},
+		{
+			Target: `combine(b.*,a.*,"max",mismatchPolicy="pad-null")`,
+			M:      metrics,
+			Want: []*types.MetricData{
+				types.MakeMetricData("maxSeries(b.x,a.x)", []float64{1, 2, 1, 4}, 1, now32),
+				types.MakeMetricData("maxSeries(b.y,a.y)", []float64{5, 6, 7, 8}, 1, now32),
+				types.MakeMetricData("maxSeries(None,a.z)", []float64{9, 10, 11, 12}, 1, now32),
+			},
+		},
+		{
+			Target: `combine(a.*,b.*,"sum","pad-null",1)`,
+			M:      metrics,
+			Want: []*types.MetricData{
+				types.MakeMetricData("sumSeries(a.x,b.x)", []float64{2, 3, math.NaN(), math.NaN()}, 1, now32),
+				types.MakeMetricData("sumSeries(a.y,b.y)", []float64{7, 8, 9, 10}, 1, now32),
+				types.MakeMetricData("sumSeries(a.z,None)", []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN()}, 1, now32),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.Target, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestAggregateSeriesListsMismatch(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	metrics := map[parser.MetricRequest][]*types.MetricData{
+		{"a.*", 0, 1}: {
+			types.MakeMetricData("a.x", []float64{1}, 1, now32),
+			types.MakeMetricData("a.y", []float64{2}, 1, now32),
+		},
+		{"b.*", 0, 1}: {
+			types.MakeMetricData("b.x", []float64{1}, 1, now32),
+		},
+	}
+
+	tests := []struct {
+		target string
+		err    error
+	}{
+		{`aggregateSeriesLists(a.*,b.*,"sum")`, parser.ErrDifferentCountMetrics},
+		{`combine(a.*,b.*,"sum")`, parser.ErrDifferentCountMetrics},
+		{`combine(a.*,b.*,"sum","error")`, parser.ErrDifferentCountMetrics},
+		{`combine(a.*,b.*,"sum","longest")`, parser.ErrInvalidArgumentValue},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			exp, _, err := parser.ParseExpr(tt.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = metadata.GetEvaluator().EvalExpr(context.Background(), exp, 0, 1, metrics, th.NoopGetTargetData)
+			if !errors.Is(err, tt.err) {
+				t.Errorf("expected error %v, got %v", tt.err, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package aliasByTags
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestAliasByTags(t *testing.T) {
+	data := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "metric1", From: 0, Until: 1}: {
+			types.MakeMetricData("cpu.load;dc=ams;host=web1", []float64{1, 2, 3}, 1, 0),
+		},
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			Target: "aliasByTags(metric1,\"host\",\"dc\")",
+			M:      data,
+			Want:   []*types.MetricData{types.MakeMetricData("web1.ams", []float64{1, 2, 3}, 1, 0)},
+		},
+		{
+			Target: "aliasByTags(metric1,\"dc\",1,\"missing\")",
+			M:      data,
+			Want:   []*types.MetricData{types.MakeMetricData("ams.load.", []float64{1, 2, 3}, 1, 0)},
+		},
+		{
+			Target: "aliasByTags(metric1,-2,\"name\")",
+			M:      data,
+			Want:   []*types.MetricData{types.MakeMetricData("cpu.cpu.load", []float64{1, 2, 3}, 1, 0)},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		testName := tt.Target
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 func GetOrder() interfaces.Order {
@@ -28,39 +28,91 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-func (f *applyByNode) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+// apply returns the templates of e applied to the unique prefixes of
+// its series, and the names of their results.
+func (f *applyByNode) apply(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) (targets, names []string, err error) {
 	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
 	if err != nil {
-		return nil, fmt.Errorf("applyByNode first argument: %w", err)
+		return nil, nil, fmt.Errorf("applyByNode first argument: %w", err)
 	}
 
 	field, err := e.GetIntArg(1)
 	if err != nil {
-		return nil, fmt.Errorf("applyByNode second argument: %w", err)
+		return nil, nil, fmt.Errorf("applyByNode second argument: %w", err)
 	}
 
 	callback, err := e.GetStringArg(2)
 	if err != nil {
-		return nil, fmt.Errorf("applyByNode third argument: %w", err)
+		return nil, nil, fmt.Errorf("applyByNode third argument: %w", err)
 	}
 
 	var newName string
 	if len(e.Args()) == 4 {
 		newName, err = e.GetStringArg(3)
 		if err != nil {
-			return nil, fmt.Errorf("applyByNode fourth argument: %w", err)
+			return nil, nil, fmt.Errorf("applyByNode fourth argument: %w", err)
 		}
 	}
 
-	results := make([]*types.MetricData, 0, len(args))
-
+	// the template is evaluated once per unique prefix, in order of appearance
+	seen := make(map[string]bool)
 	for _, a := range args {
-		metric := helper.ExtractMetric(a.Name)
-		nodes := strings.Split(metric, ".")
-		node := strings.Join(nodes[0:field+1], ".")
-		newTarget := strings.Replace(callback, "%", node, -1)
+		nodes := strings.Split(helper.ExtractMetric(a.Name), ".")
+		if field+1 < len(nodes) {
+			nodes = nodes[:field+1]
+		}
+		prefix := strings.Join(nodes, ".")
+		if seen[prefix] {
+			continue
+		}
+		seen[prefix] = true
+
+		targets = append(targets, strings.Replace(callback, "%", prefix, -1))
+		if newName != "" {
+			names = append(names, strings.Replace(newName, "%", prefix, -1))
+		} else {
+			names = append(names, "")
+		}
+	}
+
+	return targets, names, nil
+}
+
+// Rewrite rewrites applyByNode into its templates applied to each prefix,
+// aliased to their new names.
+func (f *applyByNode) Rewrite(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]string, error) {
+	targets, names, err := f.apply(ctx, e, from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, name := range names {
+		if name == "" {
+			continue
+		}
+		// strings are parsed without escapes
+		quote := "'"
+		if strings.Contains(name, quote) {
+			quote = `"`
+			if strings.Contains(name, quote) {
+				return nil, nil
+			}
+		}
+		targets[i] = "alias(" + targets[i] + "," + quote + name + quote + ")"
+	}
+
+	return targets, nil
+}
+
+func (f *applyByNode) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	targets, names, err := f.apply(ctx, e, from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
 
-		newExpr, _, err := parser.ParseExpr(newTarget)
+	results := make([]*types.MetricData, 0, len(targets))
+	for i, target := range targets {
+		newExpr, _, err := parser.ParseExpr(target)
 		if err != nil {
 			return nil, err
 		}
@@ -75,11 +127,9 @@ func (f *applyByNode) Do(ctx context.Context, e parser.Expr, from, until int32,
 			return nil, err
 		}
 		for _, r := range result {
-			if newName != "" {
-				r.Name = strings.Replace(newName, "%", node, -1)
+			if names[i] != "" {
+				r.Name = names[i]
 			}
-			r.StartTime = a.StartTime
-			r.StopTime = a.StopTime
 			results = append(results, r)
 		}
 	}
@@ -5,12 +5,12 @@ import (
 	"testing"
 	"time"
 
-	"github.com/bookingcom/carbonapi/expr/functions/divideSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/sum"
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/metadata"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/divideSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/sum"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	th "github.com/bookingcom/carbonapi/tests"
 )
 
@@ -64,6 +64,43 @@ func TestApplyByNode(t *testing.T) {
 				types.MakeMetricData("servers.s2.disk.pct_used", []float64{0.01, 0.02, 0.03}, 1, now32),
 			},
 		},
+		{
+			"applyByNode(haproxy.*.*XX, 1, 'sumSeries(%.*XX)', '%.total')",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"haproxy.*.*XX", 0, 1}: {
+					types.MakeMetricData("haproxy.web.2XX", []float64{1, 2, 3}, 1, now32),
+					types.MakeMetricData("haproxy.web.5XX", []float64{1, 1, 1}, 1, now32),
+					types.MakeMetricData("haproxy.api.2XX", []float64{5, 5, 5}, 1, now32),
+					types.MakeMetricData("haproxy.api.5XX", []float64{0, 1, 0}, 1, now32),
+				},
+				{"haproxy.web.*XX", 0, 1}: {
+					types.MakeMetricData("haproxy.web.2XX", []float64{1, 2, 3}, 1, now32),
+					types.MakeMetricData("haproxy.web.5XX", []float64{1, 1, 1}, 1, now32),
+				},
+				{"haproxy.api.*XX", 0, 1}: {
+					types.MakeMetricData("haproxy.api.2XX", []float64{5, 5, 5}, 1, now32),
+					types.MakeMetricData("haproxy.api.5XX", []float64{0, 1, 0}, 1, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("haproxy.web.total", []float64{2, 3, 4}, 1, now32),
+				types.MakeMetricData("haproxy.api.total", []float64{5, 6, 5}, 1, now32),
+			},
+		},
+		{
+			"applyByNode(short.*, 5, 'sumSeries(%)')",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"short.*", 0, 1}: {
+					types.MakeMetricData("short.a", []float64{1, 2, 3}, 1, now32),
+				},
+				{"short.a", 0, 1}: {
+					types.MakeMetricData("short.a", []float64{1, 2, 3}, 1, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("sumSeries(short.a)", []float64{1, 2, 3}, 1, now32),
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,100 @@
+package areaBetween
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type areaBetween struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &areaBetween{}
+	functions := []string{"areaBetween"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// areaBetween(seriesList)
+func (f *areaBetween) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArgs(ctx, e.Args(), from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(args) != 2 {
+		return nil, fmt.Errorf("areaBetween needs exactly two arguments (%d given)", len(args))
+	}
+
+	if args[0].StepTime != args[1].StepTime || len(args[0].Values) != len(args[1].Values) {
+		return nil, fmt.Errorf("areaBetween needs series with the same step and length")
+	}
+
+	name := fmt.Sprintf("%s(%s)", e.Target(), e.RawArgs())
+
+	// The order of the series does not matter, the band is formed by the
+	// smaller and the bigger value of every point.
+	lower := *args[0]
+	lower.Name = name
+	lower.Stacked = true
+	lower.StackName = types.DefaultStackName
+	lower.Cumulative = true
+	lower.Invisible = true
+	lower.Values = make([]float64, len(args[0].Values))
+	lower.IsAbsent = types.NewAbsence(len(args[0].Values))
+
+	upper := *args[1]
+	upper.Name = name
+	upper.Stacked = true
+	upper.StackName = types.DefaultStackName
+	upper.Cumulative = true
+	upper.Values = make([]float64, len(args[1].Values))
+	upper.IsAbsent = types.NewAbsence(len(args[1].Values))
+
+	for i := range lower.Values {
+		if args[0].IsAbsent.Get(i) || args[1].IsAbsent.Get(i) {
+			lower.IsAbsent.Set(i, true)
+			upper.IsAbsent.Set(i, true)
+			continue
+		}
+
+		lower.Values[i] = math.Min(args[0].Values[i], args[1].Values[i])
+		upper.Values[i] = math.Max(args[0].Values[i], args[1].Values[i])
+	}
+
+	return []*types.MetricData{&lower, &upper}, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *areaBetween) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"areaBetween": {
+			Description: "Draws the vertical area in between the two series in seriesList. Useful for\nvisualizing a range such as the minimum and maximum latency for a service.\n\nareaBetween expects **exactly one argument** that results in exactly two series\n(see example below). The order of the lower and higher values series does not\nmatter. The visualization only works when used in conjunction with\n``areaMode=stacked``.\n\nMost likely use case is to provide a band within which another metric should\nmove. In such case applying an ``alpha()``, as in the second example, gives\nbest visual results.\n\nExample:\n\n.. code-block:: none\n\n  &target=areaBetween(service.latency.{min,max})&areaMode=stacked\n\n  &target=alpha(areaBetween(service.latency.{min,max}),0.3)&areaMode=stacked\n\nIf for instance, you need to build a seriesList, you should use the ``group``\nfunction, like so:\n\n.. code-block:: none\n\n  &target=areaBetween(group(minSeries(a.*.min),maxSeries(a.*.max)))",
+			Function:    "areaBetween(seriesList)",
+			Group:       "Graph",
+			Module:      "graphite.render.functions",
+			Name:        "areaBetween",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+			},
+		},
+	}
+}
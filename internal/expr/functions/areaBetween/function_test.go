@@ -0,0 +1,101 @@
+package areaBetween
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestAreaBetween(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	tests := []th.EvalTestItem{
+		{
+			"areaBetween(metric*)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric*", 0, 1}: {
+					types.MakeMetricData("metric.max", []float64{5, 6, math.NaN(), 1}, 1, now32),
+					types.MakeMetricData("metric.min", []float64{1, 2, 3, 4}, 1, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("areaBetween(metric*)", []float64{1, 2, math.NaN(), 1}, 1, now32),
+				types.MakeMetricData("areaBetween(metric*)", []float64{5, 6, math.NaN(), 4}, 1, now32),
+			},
+		},
+		{
+			"areaBetween(metric1,metric2)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2}, 1, now32)},
+				{"metric2", 0, 1}: {types.MakeMetricData("metric2", []float64{3, 4}, 1, now32)},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("areaBetween(metric1,metric2)", []float64{1, 2}, 1, now32),
+				types.MakeMetricData("areaBetween(metric1,metric2)", []float64{3, 4}, 1, now32),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		testName := tt.Target
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestAreaBetweenOptions(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	exp, _, err := parser.ParseExpr("areaBetween(metric*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{"metric*", 0, 1}: {
+			types.MakeMetricData("metric.min", []float64{1}, 1, now32),
+			types.MakeMetricData("metric.max", []float64{2}, 1, now32),
+		},
+	}
+
+	results, err := metadata.GetEvaluator().EvalExpr(context.Background(), exp, 0, 1, values, th.NoopGetTargetData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lower, upper := results[0], results[1]
+	if !lower.Stacked || !lower.Invisible || !lower.Cumulative {
+		t.Errorf("Lower series should be stacked, cumulative and invisible: %+v", lower)
+	}
+
+	if !upper.Stacked || upper.Invisible || !upper.Cumulative {
+		t.Errorf("Upper series should be stacked, cumulative and visible: %+v", upper)
+	}
+
+	_, err = metadata.GetEvaluator().EvalExpr(context.Background(), exp, 0, 1, map[parser.MetricRequest][]*types.MetricData{
+		{"metric*", 0, 1}: {types.MakeMetricData("metric.min", []float64{1}, 1, now32)},
+	}, th.NoopGetTargetData)
+	if err == nil {
+		t.Error("Expected error for a single series")
+	}
+}
@@ -6,11 +6,10 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/functions/sum"
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type asPercent struct {
@@ -65,7 +64,7 @@ func asPercentWithNodes(ctx context.Context, e parser.Expr, from, until int32, v
 			totalSeries[key] = tmpTotalSeries[key][0]
 		} else {
 			name := fmt.Sprintf("sumSeries(%s)", e.Args()[1].Target())
-			aggregated, err := helper.AggregateSeries(name, seriesList, false, false, sum.SumAggregation)
+			aggregated, err := helper.AggregateSeriesByName(name, seriesList, "sum", helper.DefaultXFilesFactor())
 			if err != nil {
 				return nil, err
 			}
@@ -86,11 +85,7 @@ func asPercentWithNodes(ctx context.Context, e parser.Expr, from, until int32, v
 			series2 := totalSeries[key]
 			name := fmt.Sprintf("asPercent(MISSING,%s)", series2.Name)
 			values := make([]float64, len(series2.Values))
-			isAbsent := make([]bool, len(series2.Values))
-			for i := 0; i < len(values); i++ {
-				values[i] = 0
-				isAbsent[i] = true
-			}
+			isAbsent := types.AllAbsent(len(series2.Values))
 			result := types.New(name, values, isAbsent, series2.StepTime, series2.StartTime)
 			results = append(results, result)
 			continue
@@ -99,11 +94,7 @@ func asPercentWithNodes(ctx context.Context, e parser.Expr, from, until int32, v
 			if _, found := totalSeries[key]; !found {
 				name := fmt.Sprintf("asPercent(%s,MISSING)", series1.Name)
 				values := make([]float64, len(series1.Values))
-				isAbsent := make([]bool, len(series1.Values))
-				for i := 0; i < len(values); i++ {
-					values[i] = 0
-					isAbsent[i] = true
-				}
+				isAbsent := types.AllAbsent(len(series1.Values))
 				result := types.New(name, values, isAbsent, series1.StepTime, series1.StartTime)
 				results = append(results, result)
 				continue
@@ -153,7 +144,7 @@ func asPercentWithoutNodes(ctx context.Context, e parser.Expr, from, until int32
 	switch {
 	case len(e.Args()) == 1:
 		name := fmt.Sprintf("sumSeries(%s)", e.Args()[0].Target())
-		aggregated, err := helper.AggregateSeries(name, seriesList, false, false, sum.SumAggregation)
+		aggregated, err := helper.AggregateSeriesByName(name, seriesList, "sum", helper.DefaultXFilesFactor())
 		if err != nil {
 			return nil, err
 		}
@@ -164,11 +155,10 @@ func asPercentWithoutNodes(ctx context.Context, e parser.Expr, from, until int32
 		if err != nil {
 			return nil, err
 		}
-		isAbsent := make([]bool, n)
+		isAbsent := types.NewAbsence(n)
 		values := make([]float64, n)
 		for i := 0; i < n; i++ {
 			values[i] = value
-			isAbsent[i] = false
 		}
 		totalText = fmt.Sprintf("%0.2f", value)
 		total = types.New(totalText, values, isAbsent, seriesList[0].StepTime, seriesList[0].StartTime)
@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type averageSeries struct {
@@ -36,13 +36,7 @@ func (f *averageSeries) Do(ctx context.Context, e parser.Expr, from, until int32
 
 	e.SetTarget("averageSeries")
 	name := fmt.Sprintf("%s(%s)", e.Target(), e.RawArgs())
-	return helper.AggregateSeries(name, args, false, false, func(values []float64) (float64, bool) {
-		sum := 0.0
-		for _, value := range values {
-			sum += value
-		}
-		return sum / float64(len(values)), false
-	})
+	return helper.AggregateSeriesByName(name, args, "average", helper.DefaultXFilesFactor())
 }
 
 // Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
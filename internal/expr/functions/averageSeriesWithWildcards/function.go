@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type averageSeriesWithWildcards struct {
@@ -70,34 +70,12 @@ func (f *averageSeriesWithWildcards) Do(ctx context.Context, e parser.Expr, from
 	}
 
 	for _, series := range nodeList {
-		args := groups[series]
-		r := *args[0]
-		r.Name = fmt.Sprintf("averageSeriesWithWildcards(%s)", series)
-		r.Values = make([]float64, len(args[0].Values))
-		r.IsAbsent = make([]bool, len(args[0].Values))
-
-		length := make([]float64, len(args[0].Values))
-		atLeastOne := make([]bool, len(args[0].Values))
-		for _, arg := range args {
-			for i, v := range arg.Values {
-				if arg.IsAbsent[i] {
-					continue
-				}
-				atLeastOne[i] = true
-				length[i]++
-				r.Values[i] += v
-			}
-		}
-
-		for i, v := range atLeastOne {
-			if v {
-				r.Values[i] = r.Values[i] / length[i]
-			} else {
-				r.IsAbsent[i] = true
-			}
+		r, err := helper.AggregateSeriesByName(fmt.Sprintf("averageSeriesWithWildcards(%s)", series), groups[series], "average", helper.DefaultXFilesFactor())
+		if err != nil {
+			return nil, err
 		}
 
-		results = append(results, &r)
+		results = append(results, r...)
 	}
 	return results, nil
 }
@@ -4,10 +4,10 @@ import (
 	"context"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type below struct {
@@ -42,7 +42,7 @@ func (f *below) Do(ctx context.Context, e parser.Expr, from, until int32, values
 
 	isAbove := strings.HasSuffix(e.Target(), "Above")
 	isInclusive := true
-	var compute func([]float64, []bool) float64
+	var compute func([]float64, types.Absence) float64
 	switch {
 	case strings.HasPrefix(e.Target(), "average"):
 		compute = helper.AvgValue
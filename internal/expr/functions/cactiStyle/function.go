@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	"github.com/dustin/go-humanize"
 )
 
@@ -65,7 +65,7 @@ func (f *cactiStyle) Do(ctx context.Context, e parser.Expr, from, until int32, v
 		currentVal := math.Inf(-1)
 		maxVal := math.Inf(-1)
 		for i, av := range a.Values {
-			if !a.IsAbsent[i] {
+			if !a.IsAbsent.Get(i) {
 				minVal = math.Min(minVal, av)
 				maxVal = math.Max(maxVal, av)
 				currentVal = av
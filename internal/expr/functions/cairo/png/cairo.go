@@ -16,10 +16,10 @@ import (
 	"strings"
 	"time"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 
 	"github.com/evmar/gocairo/cairo"
@@ -527,38 +527,6 @@ func Description() map[string]types.FunctionDescription {
 			Function:    "color(seriesList, theColor)",
 			Group:       "Graph",
 		},
-		"stacked": {
-			Name: "stacked",
-			Params: []types.FunctionParam{
-				{
-					Name:     "seriesList",
-					Required: true,
-					Type:     types.SeriesList,
-				},
-				{
-					Name: "stack",
-					Type: types.String,
-				},
-			},
-			Module:      "graphite.render.functions",
-			Description: "Takes one metric or a wildcard seriesList and change them so they are\nstacked. This is a way of stacking just a couple of metrics without having\nto use the stacked area mode (that stacks everything). By means of this a mixed\nstacked and non stacked graph can be made\n\nIt can also take an optional argument with a name of the stack, in case there is\nmore than one, e.g. for input and output metrics.\n\nExample:\n\n.. code-block:: none\n\n  &target=stacked(company.server.application01.ifconfig.TXPackets, 'tx')",
-			Function:    "stacked(seriesLists, stackName='__DEFAULT__')",
-			Group:       "Graph",
-		},
-		"areaBetween": {
-			Name: "areaBetween",
-			Params: []types.FunctionParam{
-				{
-					Name:     "seriesList",
-					Required: true,
-					Type:     types.SeriesList,
-				},
-			},
-			Module:      "graphite.render.functions",
-			Description: "Draws the vertical area in between the two series in seriesList. Useful for\nvisualizing a range such as the minimum and maximum latency for a service.\n\nareaBetween expects **exactly one argument** that results in exactly two series\n(see example below). The order of the lower and higher values series does not\nmatter. The visualization only works when used in conjunction with\n``areaMode=stacked``.\n\nMost likely use case is to provide a band within which another metric should\nmove. In such case applying an ``alpha()``, as in the second example, gives\nbest visual results.\n\nExample:\n\n.. code-block:: none\n\n  &target=areaBetween(service.latency.{min,max})&areaMode=stacked\n\n  &target=alpha(areaBetween(service.latency.{min,max}),0.3)&areaMode=stacked\n\nIf for instance, you need to build a seriesList, you should use the ``group``\nfunction, like so:\n\n.. code-block:: none\n\n  &target=areaBetween(group(minSeries(a.*.min),maxSeries(a.*.max)))",
-			Function:    "areaBetween(seriesList)",
-			Group:       "Graph",
-		},
 		"alpha": {
 			Name: "alpha",
 			Params: []types.FunctionParam{
@@ -611,20 +579,6 @@ func Description() map[string]types.FunctionDescription {
 			Function:    "drawAsInfinite(seriesList)",
 			Group:       "Graph",
 		},
-		"secondYAxis": {
-			Name: "secondYAxis",
-			Params: []types.FunctionParam{
-				{
-					Name:     "seriesList",
-					Required: true,
-					Type:     types.SeriesList,
-				},
-			},
-			Module:      "graphite.render.functions",
-			Description: "Graph the series on the secondary Y axis.",
-			Function:    "secondYAxis(seriesList)",
-			Group:       "Graph",
-		},
 		"lineWidth": {
 			Name: "lineWidth",
 			Params: []types.FunctionParam{
@@ -695,68 +649,6 @@ func EvalExprGraph(ctx context.Context, e parser.Expr, from, until int32, values
 
 		return results, nil
 
-	case "stacked": // stacked(seriesList, stackname="__DEFAULT__")
-		arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
-		if err != nil {
-			return nil, err
-		}
-
-		stackName, err := e.GetStringNamedOrPosArgDefault("stackname", 1, types.DefaultStackName)
-		if err != nil {
-			return nil, err
-		}
-
-		var results []*types.MetricData
-
-		for _, a := range arg {
-			r := *a
-			r.Stacked = true
-			r.StackName = stackName
-			results = append(results, &r)
-		}
-
-		return results, nil
-
-	case "areaBetween":
-		arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
-		if err != nil {
-			return nil, err
-		}
-
-		if len(arg) != 2 {
-			return nil, fmt.Errorf("areaBetween needs exactly two arguments (%d given)", len(arg))
-		}
-
-		name := fmt.Sprintf("%s(%s)", e.Target(), e.RawArgs())
-
-		lower := *arg[0]
-		lower.Stacked = true
-		lower.StackName = types.DefaultStackName
-		lower.Invisible = true
-		lower.Name = name
-
-		upper := *arg[1]
-		upper.Stacked = true
-		upper.StackName = types.DefaultStackName
-		upper.Name = name
-
-		vals := make([]float64, len(upper.Values))
-		absent := make([]bool, len(upper.Values))
-
-		for i, v := range upper.Values {
-			if upper.IsAbsent[i] || lower.IsAbsent[i] {
-				absent[i] = true
-				continue
-			}
-
-			vals[i] = v - lower.Values[i]
-		}
-
-		upper.Values = vals
-		upper.IsAbsent = absent
-
-		return []*types.MetricData{&lower, &upper}, nil
-
 	case "alpha": // alpha(seriesList, theAlpha)
 		arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
 		if err != nil {
@@ -779,7 +671,7 @@ func EvalExprGraph(ctx context.Context, e parser.Expr, from, until int32, values
 
 		return results, nil
 
-	case "dashed", "drawAsInfinite", "secondYAxis":
+	case "dashed", "drawAsInfinite":
 		arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
 		if err != nil {
 			return nil, err
@@ -800,8 +692,6 @@ func EvalExprGraph(ctx context.Context, e parser.Expr, from, until int32, values
 				r.Dashed = d
 			case "drawAsInfinite":
 				r.DrawAsInfinite = true
-			case "secondYAxis":
-				r.SecondYAxis = true
 			}
 
 			results = append(results, &r)
@@ -862,7 +752,7 @@ func EvalExprGraph(ctx context.Context, e parser.Expr, from, until int32, values
 				StopTime:  until,
 				StepTime:  until - from,
 				Values:    []float64{value, value},
-				IsAbsent:  []bool{false, false},
+				IsAbsent:  types.NewAbsence(2),
 			},
 			GraphOptions: types.GraphOptions{Color: color},
 		}
@@ -1232,13 +1122,27 @@ func drawGraph(cr *cairoSurfaceContext, params *Params,
 
 			absent := r.IsAbsent
 			vals := r.Values
+
+			if r.Cumulative {
+				// values are running totals already, only follow them
+				for i, v := range vals {
+					if len(total) <= i {
+						total = append(total, 0)
+					}
+
+					if !absent.Get(i) {
+						total[i] = v
+					}
+				}
+				continue
+			}
 			for i, v := range vals {
 
 				if len(total) <= i {
 					total = append(total, 0)
 				}
 
-				if !absent[i] {
+				if !absent.Get(i) {
 					vals[i] += total[i]
 					total[i] += v
 				}
@@ -1322,22 +1226,15 @@ func setupTwoYAxes(cr *cairoSurfaceContext, params *Params) {
 	Rdata = params.dataRight
 
 	for _, s := range Ldata {
-		for _, v := range s.IsAbsent {
-			if v {
-				seriesWithMissingValuesL = append(seriesWithMissingValuesL, s)
-				break
-			}
+		if s.IsAbsent.Count() > 0 {
+			seriesWithMissingValuesL = append(seriesWithMissingValuesL, s)
 		}
 	}
 
 	for _, s := range Rdata {
-		for _, v := range s.IsAbsent {
-			if v {
-				seriesWithMissingValuesR = append(seriesWithMissingValuesR, s)
-				break
-			}
+		if s.IsAbsent.Count() > 0 {
+			seriesWithMissingValuesR = append(seriesWithMissingValuesR, s)
 		}
-
 	}
 
 	yMinValueL := math.Inf(1)
@@ -1350,7 +1247,7 @@ func setupTwoYAxes(cr *cairoSurfaceContext, params *Params) {
 			}
 			absent := s.IsAbsent
 			for i, v := range s.Values {
-				if absent[i] {
+				if absent.Get(i) {
 					continue
 				}
 				if v < yMinValueL {
@@ -1370,7 +1267,7 @@ func setupTwoYAxes(cr *cairoSurfaceContext, params *Params) {
 			}
 			absent := s.IsAbsent
 			for i, v := range s.Values {
-				if absent[i] {
+				if absent.Get(i) {
 					continue
 				}
 				if v < yMinValueR {
@@ -1385,7 +1282,7 @@ func setupTwoYAxes(cr *cairoSurfaceContext, params *Params) {
 	for _, s := range Ldata {
 		absent := s.IsAbsent
 		for i, v := range s.Values {
-			if absent[i] {
+			if absent.Get(i) {
 				continue
 			}
 
@@ -1399,7 +1296,7 @@ func setupTwoYAxes(cr *cairoSurfaceContext, params *Params) {
 	for _, s := range Rdata {
 		absent := s.IsAbsent
 		for i, v := range s.Values {
-			if absent[i] {
+			if absent.Get(i) {
 				continue
 			}
 
@@ -1650,11 +1547,11 @@ func setupYAxis(cr *cairoSurfaceContext, params *Params, results []*types.Metric
 		pushed := false
 		absent := r.IsAbsent
 		for i, v := range r.Values {
-			if absent[i] && !pushed {
+			if absent.Get(i) && !pushed {
 				seriesWithMissingValues = append(seriesWithMissingValues, r)
 				pushed = true
 			} else {
-				if absent[i] {
+				if absent.Get(i) {
 					continue
 				}
 				if !math.IsInf(v, 0) && (math.IsNaN(yMinValue) || yMinValue > v) {
@@ -2277,7 +2174,7 @@ func drawLines(cr *cairoSurfaceContext, params *Params, results []*types.MetricD
 						StartTime: r.StartTime,
 						StepTime:  r.StepTime,
 						Values:    make([]float64, len(r.Values)),
-						IsAbsent:  make([]bool, len(r.Values)),
+						IsAbsent:  r.IsAbsent.Clone(),
 					},
 					ValuesPerPoint: 1,
 					GraphOptions: types.GraphOptions{
@@ -2287,7 +2184,6 @@ func drawLines(cr *cairoSurfaceContext, params *Params, results []*types.MetricD
 					},
 				}
 				copy(newSeries.Values, r.Values)
-				copy(newSeries.IsAbsent, r.IsAbsent)
 				strokeSeries = append(strokeSeries, &newSeries)
 			}
 		}
@@ -2340,7 +2236,7 @@ func drawLines(cr *cairoSurfaceContext, params *Params, results []*types.MetricD
 		for index, value := range series.Values {
 			x = origX + (float64(index) * series.XStep)
 
-			if absent[index] {
+			if absent.Get(index) {
 				value = math.NaN()
 			}
 
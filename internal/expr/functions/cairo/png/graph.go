@@ -0,0 +1,522 @@
+//go:build !cairo
+// +build !cairo
+
+package png
+
+import (
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+)
+
+// point is a position on a canvas, in pixels from the top left corner.
+type point struct {
+	x, y float64
+}
+
+// canvas is what graphs are drawn on, as a PNG image or as an SVG document.
+type canvas interface {
+	fillRect(x, y, width, height float64, c color.RGBA)
+	// fill fills the polygon of the points.
+	fill(points []point, c color.RGBA)
+	// stroke draws the line through the points.
+	stroke(points []point, width float64, c color.RGBA)
+	// text draws s in the line starting at y.
+	text(x, y float64, s string, c color.RGBA)
+	textWidth(s string) float64
+	lineHeight() float64
+
+	// clip limits drawing to a rectangle, until unclip.
+	clip(x, y, width, height float64)
+	unclip()
+}
+
+// plotted is a series as it is drawn: its values, NaN where absent, on top
+// of the series below it if it is stacked.
+type plotted struct {
+	name    string
+	color   color.RGBA
+	start   int32
+	step    int32
+	values  []float64
+	stacked bool
+}
+
+// graph draws series the way graphite-web does: the title on top, the legend
+// below the graph and the axes on its sides.
+type graph struct {
+	c     canvas
+	p     PictureParams
+	ratio float64
+	fg    color.RGBA
+
+	width, height float64
+	// left, top, right and bottom bound the area of the series.
+	left, top, right, bottom float64
+
+	yMin, yMax, yStep float64
+	start, end        int32
+	xStep             int32
+	xFormat           string
+}
+
+// ratioOf returns the pixel ratio of p.
+func ratioOf(p PictureParams) float64 {
+	if p.PixelRatio <= 0 {
+		return 1
+	}
+	return p.PixelRatio
+}
+
+// sizeOf returns the size in pixels of the pictures of p.
+func sizeOf(p PictureParams) (width, height float64) {
+	ratio := ratioOf(p)
+	return math.Max(math.Round(p.Width*ratio), 1), math.Max(math.Round(p.Height*ratio), 1)
+}
+
+// drawGraph draws the results on c, or emptyText if there are no results.
+func drawGraph(c canvas, p PictureParams, results []*types.MetricData, emptyText string) {
+	g := &graph{
+		c:     c,
+		p:     p,
+		ratio: ratioOf(p),
+		fg:    string2RGBA(p.FgColor),
+	}
+	g.width, g.height = sizeOf(p)
+	margin := float64(p.Margin) * g.ratio
+	g.left, g.top, g.right, g.bottom = margin, margin, g.width-margin, g.height-margin
+
+	c.fillRect(0, 0, g.width, g.height, string2RGBA(p.BgColor))
+
+	series := g.series(results)
+	if len(series) == 0 {
+		if emptyText == "" {
+			emptyText = "No Data"
+		}
+		g.centered(emptyText, g.width/2, (g.height-c.lineHeight())/2)
+		return
+	}
+
+	if !p.GraphOnly {
+		if p.Title != "" {
+			for _, line := range strings.Split(p.Title, "\n") {
+				g.centered(line, g.width/2, g.top)
+				g.top += c.lineHeight()
+			}
+			g.top += c.lineHeight() / 2
+		}
+		if !p.HideLegend {
+			g.drawLegend(series)
+		}
+	}
+
+	g.setYAxis(series)
+	g.setXAxis(series)
+	if !p.HideGrid {
+		g.drawGrid()
+	}
+	g.drawSeries(series)
+}
+
+// series returns the results to draw, stacking them as asked.
+func (g *graph) series(results []*types.MetricData) []plotted {
+	colors := g.p.ColorList
+	if len(colors) == 0 {
+		colors = DefaultColorList
+	}
+
+	var series []plotted
+	totals := make(map[int32]float64)
+	for _, r := range results {
+		if r.StepTime <= 0 {
+			continue
+		}
+
+		s := plotted{
+			name:    r.Name,
+			color:   string2RGBA(colors[len(series)%len(colors)]),
+			start:   r.StartTime,
+			step:    r.StepTime,
+			values:  make([]float64, len(r.Values)),
+			stacked: g.p.AreaMode == AreaModeStacked || r.Stacked,
+		}
+		for i, v := range r.Values {
+			absent := i < r.IsAbsent.Len() && r.IsAbsent.Get(i)
+			switch {
+			case absent && g.p.DrawNullAsZero:
+				v = 0
+			case absent || math.IsInf(v, 0):
+				v = math.NaN()
+			}
+			if s.stacked && !r.Cumulative && !math.IsNaN(v) {
+				t := r.StartTime + int32(i)*r.StepTime
+				totals[t] += v
+				v = totals[t]
+			}
+			s.values[i] = v
+		}
+
+		if !r.Invisible {
+			series = append(series, s)
+		}
+	}
+
+	return series
+}
+
+// centered draws s centered on x.
+func (g *graph) centered(s string, x, y float64) {
+	g.c.text(x-g.c.textWidth(s)/2, y, s, g.fg)
+}
+
+// drawLegend draws a line of the legend below the graph for each series,
+// in as many rows as they take.
+func (g *graph) drawLegend(series []plotted) {
+	lh := g.c.lineHeight()
+
+	type entry struct {
+		name  string
+		color color.RGBA
+		width float64
+	}
+	var rows [][]entry
+	var x float64
+	seen := make(map[string]bool)
+	for _, s := range series {
+		if g.p.UniqueLegend {
+			if seen[s.name] {
+				continue
+			}
+			seen[s.name] = true
+		}
+
+		e := entry{name: s.name, color: s.color, width: lh + g.c.textWidth(s.name) + lh}
+		if len(rows) == 0 || x > 0 && x+e.width > g.right-g.left {
+			rows = append(rows, nil)
+			x = 0
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], e)
+		x += e.width
+	}
+
+	g.bottom -= float64(len(rows))*lh + lh/2
+	y := g.bottom + lh/2
+	for _, row := range rows {
+		x := g.left
+		for _, e := range row {
+			g.c.fillRect(x, y+lh*0.15, lh*0.7, lh*0.7, e.color)
+			g.c.text(x+lh, y, e.name, g.fg)
+			x += e.width
+		}
+		y += lh
+	}
+}
+
+// setYAxis sets the range and the step of the values on the Y axis, and
+// makes room for their labels.
+func (g *graph) setYAxis(series []plotted) {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, s := range series {
+		for _, v := range s.values {
+			if !math.IsNaN(v) {
+				lo, hi = math.Min(lo, v), math.Max(hi, v)
+			}
+		}
+	}
+	if math.IsInf(lo, 1) {
+		lo, hi = 0, 1
+	}
+	if g.p.AreaMode != AreaModeNone && lo > 0 {
+		lo = 0
+	}
+
+	if !math.IsNaN(g.p.YMin) {
+		lo = g.p.YMin
+	}
+	if !math.IsNaN(g.p.YMax) {
+		hi = g.p.YMax
+	}
+	if hi <= lo {
+		if !math.IsNaN(g.p.YMax) && math.IsNaN(g.p.YMin) {
+			lo = hi - 1
+		} else {
+			hi = lo + 1
+		}
+	}
+
+	step := g.p.YStep
+	if math.IsNaN(step) || step <= 0 {
+		step = niceStep((hi - lo) / 5)
+	}
+	if math.IsNaN(g.p.YMin) {
+		lo = math.Floor(lo/step) * step
+	}
+	if math.IsNaN(g.p.YMax) {
+		hi = math.Ceil(hi/step) * step
+	}
+	g.yMin, g.yMax, g.yStep = lo, hi, step
+
+	if !g.showYAxis() {
+		return
+	}
+	var width float64
+	for _, v := range g.yTicks() {
+		width = math.Max(width, g.c.textWidth(g.yLabel(v)))
+	}
+	width += g.c.lineHeight() / 2
+	if g.p.YAxisSide == YAxisSideRight {
+		g.right -= width
+	} else {
+		g.left += width
+	}
+	// the labels of the ticks at the ends stick out by half a line
+	g.top += g.c.lineHeight() / 2
+}
+
+func (g *graph) showYAxis() bool {
+	return !g.p.GraphOnly && !g.p.HideAxes && !g.p.HideYAxis
+}
+
+func (g *graph) showXAxis() bool {
+	return !g.p.GraphOnly && !g.p.HideAxes && !g.p.HideXAxis
+}
+
+// niceStep returns the multiple of 1, 2 or 5 by a power of ten from x on.
+func niceStep(x float64) float64 {
+	if x <= 0 || math.IsNaN(x) || math.IsInf(x, 0) {
+		return 1
+	}
+	magnitude := math.Pow(10, math.Floor(math.Log10(x)))
+	for _, n := range []float64{1, 2, 5} {
+		if x <= n*magnitude {
+			return n * magnitude
+		}
+	}
+	return 10 * magnitude
+}
+
+// yTicks returns the values of the Y axis with a label.
+func (g *graph) yTicks() []float64 {
+	var ticks []float64
+	for i := math.Ceil(g.yMin/g.yStep - 1e-9); i*g.yStep <= g.yMax+g.yStep*1e-9 && len(ticks) < 100; i++ {
+		v := i * g.yStep
+		if v == 0 {
+			// not -0
+			v = 0
+		}
+		ticks = append(ticks, v)
+	}
+	return ticks
+}
+
+// yLabel returns the label of v on the Y axis, in the unit of the Y axis.
+func (g *graph) yLabel(v float64) string {
+	var base float64
+	var prefixes []string
+	switch g.p.YUnitSystem {
+	case "si":
+		base, prefixes = 1000, []string{"K", "M", "G", "T", "P", "E"}
+	case "binary":
+		base, prefixes = 1024, []string{"Ki", "Mi", "Gi", "Ti", "Pi", "Ei"}
+	}
+
+	unit, prefix := 1.0, ""
+	top := math.Max(math.Abs(g.yMin), math.Abs(g.yMax))
+	for _, p := range prefixes {
+		if top < unit*base {
+			break
+		}
+		unit, prefix = unit*base, p
+	}
+
+	decimals := 0
+	step := strconv.FormatFloat(g.yStep/unit, 'f', -1, 64)
+	if i := strings.IndexByte(step, '.'); i >= 0 {
+		decimals = len(step) - i - 1
+		if decimals > 6 {
+			decimals = 6
+		}
+	}
+
+	return strconv.FormatFloat(v/unit, 'f', decimals, 64) + prefix
+}
+
+// xSteps are the intervals between the labels of the X axis, in seconds.
+var xSteps = []int32{
+	1, 5, 10, 15, 30,
+	60, 5 * 60, 10 * 60, 15 * 60, 30 * 60,
+	3600, 2 * 3600, 3 * 3600, 6 * 3600, 12 * 3600,
+	86400, 2 * 86400, 7 * 86400, 14 * 86400, 28 * 86400,
+}
+
+// setXAxis sets the range and the step of the times on the X axis, and makes
+// room for their labels.
+func (g *graph) setXAxis(series []plotted) {
+	g.start, g.end = series[0].start, series[0].start
+	for _, s := range series {
+		if s.start < g.start {
+			g.start = s.start
+		}
+		if stop := s.start + int32(len(s.values))*s.step; stop > g.end {
+			g.end = stop
+		}
+	}
+	if g.end <= g.start {
+		g.end = g.start + 1
+	}
+
+	if g.showXAxis() {
+		g.bottom -= g.c.lineHeight() * 1.5
+	}
+
+	duration := g.end - g.start
+	switch {
+	case duration <= 86400:
+		g.xFormat = "15:04"
+	case duration <= 7*86400:
+		g.xFormat = "01/02 15:04"
+	default:
+		g.xFormat = "01/02"
+	}
+
+	width := g.c.textWidth(g.xFormat) + g.c.lineHeight()*2
+	ticks := int32(math.Max((g.right-g.left)/width, 1))
+	g.xStep = xSteps[len(xSteps)-1]
+	for _, step := range xSteps {
+		if duration/step <= ticks {
+			g.xStep = step
+			break
+		}
+	}
+}
+
+// xTicks returns the times of the X axis with a label, on round times of
+// the time zone of the graph.
+func (g *graph) xTicks() []int32 {
+	tz := g.p.Tz
+	if tz == nil {
+		tz = time.Local
+	}
+	_, offset := time.Unix(int64(g.start), 0).In(tz).Zone()
+	step := int64(g.xStep)
+	t := (int64(g.start) + int64(offset) + step - 1) / step * step
+
+	var ticks []int32
+	for ; t-int64(offset) <= int64(g.end); t += step {
+		ticks = append(ticks, int32(t-int64(offset)))
+	}
+	return ticks
+}
+
+// x returns the position of t on the X axis.
+func (g *graph) x(t int32) float64 {
+	return g.left + float64(t-g.start)/float64(g.end-g.start)*(g.right-g.left)
+}
+
+// y returns the position of v on the Y axis.
+func (g *graph) y(v float64) float64 {
+	return g.bottom - (v-g.yMin)/(g.yMax-g.yMin)*(g.bottom-g.top)
+}
+
+// drawGrid draws the lines of the grid and the labels of the axes.
+func (g *graph) drawGrid() {
+	lh := g.c.lineHeight()
+	line := string2RGBA(g.p.MajorGridLineColor)
+
+	for _, v := range g.yTicks() {
+		y := g.y(v)
+		g.c.stroke([]point{{g.left, y}, {g.right, y}}, g.ratio, line)
+		if g.showYAxis() {
+			label := g.yLabel(v)
+			x := g.left - lh/4 - g.c.textWidth(label)
+			if g.p.YAxisSide == YAxisSideRight {
+				x = g.right + lh/4
+			}
+			g.c.text(x, y-lh/2, label, g.fg)
+		}
+	}
+
+	tz := g.p.Tz
+	if tz == nil {
+		tz = time.Local
+	}
+	for _, t := range g.xTicks() {
+		x := g.x(t)
+		g.c.stroke([]point{{x, g.top}, {x, g.bottom}}, g.ratio, line)
+		if g.showXAxis() {
+			label := time.Unix(int64(t), 0).In(tz).Format(g.xFormat)
+			if w := g.c.textWidth(label); x-w/2 >= 0 && x+w/2 <= g.width {
+				g.centered(label, x, g.bottom+lh/4)
+			}
+		}
+	}
+}
+
+// drawSeries draws the series, filling the areas below them as asked.
+func (g *graph) drawSeries(series []plotted) {
+	g.c.clip(g.left, g.top, g.right-g.left, g.bottom-g.top)
+	defer g.c.unclip()
+
+	alpha := g.p.AreaAlpha
+	if math.IsNaN(alpha) || alpha < 0 || alpha > 1 {
+		alpha = 1
+	}
+	fill := func(s plotted) {
+		c := s.color
+		c.A = uint8(alpha * 255)
+		for _, run := range g.runs(s) {
+			area := append(run, point{run[len(run)-1].x, g.bottom}, point{run[0].x, g.bottom})
+			g.c.fill(area, c)
+		}
+	}
+	// the stacked series are filled to the bottom, from the top of the
+	// stack so that the series below are filled over them
+	for i := len(series) - 1; i >= 0; i-- {
+		if series[i].stacked {
+			fill(series[i])
+		}
+	}
+	for i, s := range series {
+		if !s.stacked && (g.p.AreaMode == AreaModeAll || g.p.AreaMode == AreaModeFirst && i == 0) {
+			fill(s)
+		}
+	}
+
+	for _, s := range series {
+		for _, run := range g.runs(s) {
+			g.c.stroke(run, g.p.LineWidth*g.ratio, s.color)
+		}
+	}
+}
+
+// runs returns the lines through the values of s, as the line mode joins
+// them.
+func (g *graph) runs(s plotted) [][]point {
+	var runs [][]point
+	var run []point
+	for i, v := range s.values {
+		if math.IsNaN(v) {
+			if g.p.LineMode != LineModeConnected && len(run) > 0 {
+				runs = append(runs, run)
+				run = nil
+			}
+			continue
+		}
+
+		t := s.start + int32(i)*s.step
+		y := g.y(v)
+		run = append(run, point{g.x(t), y})
+		if g.p.LineMode == LineModeStaircase {
+			run = append(run, point{g.x(t + s.step), y})
+		}
+	}
+	if len(run) > 0 {
+		runs = append(runs, run)
+	}
+
+	return runs
+}
@@ -0,0 +1,66 @@
+// +build !cairo
+
+package png
+
+import (
+	"bytes"
+	"context"
+	imagepng "image/png"
+	"net/http"
+
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+// HaveGraphSupport tells whether the functions setting how series are drawn,
+// like color(), are available. Without cairo, graphs are drawn in pure Go
+// from the parameters of the request only.
+const HaveGraphSupport = false
+
+func EvalExprGraph(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	return nil, nil
+}
+
+func MarshalPNG(params PictureParams, results []*types.MetricData) ([]byte, error) {
+	return marshalPNG(params, results, "")
+}
+
+func MarshalSVG(params PictureParams, results []*types.MetricData) ([]byte, error) {
+	width, height := sizeOf(params)
+	s := newSVG(width, height, params)
+	drawGraph(s, params, results, "")
+
+	return s.bytes(), nil
+}
+
+func MarshalPNGRequest(r *http.Request, results []*types.MetricData, templateName string) ([]byte, error) {
+	return MarshalPNG(GetPictureParamsWithTemplate(r, templateName, results), results)
+}
+
+func MarshalPNGRequestErr(r *http.Request, errStr string, templateName string) ([]byte, error) {
+	return marshalPNG(GetPictureParamsWithTemplate(r, templateName, nil), nil, errStr)
+}
+
+func MarshalSVGRequest(r *http.Request, results []*types.MetricData, templateName string) ([]byte, error) {
+	return MarshalSVG(GetPictureParamsWithTemplate(r, templateName, results), results)
+}
+
+// marshalPNG draws the results, or emptyText if there are none, as a PNG
+// image.
+func marshalPNG(params PictureParams, results []*types.MetricData, emptyText string) ([]byte, error) {
+	width, height := sizeOf(params)
+	r := newRaster(width, height, params.FontSize*ratioOf(params))
+	drawGraph(r, params, results, emptyText)
+
+	var b bytes.Buffer
+	if err := imagepng.Encode(&b, r.img); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+func Description() map[string]types.FunctionDescription {
+	return nil
+}
@@ -0,0 +1,155 @@
+//go:build !cairo
+// +build !cairo
+
+package png
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	imagepng "image/png"
+	"io"
+	"math"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+)
+
+func testResults() []*types.MetricData {
+	return []*types.MetricData{
+		types.MakeMetricData("web1.cpu", []float64{10, 20, math.NaN(), 40, 30}, 60, 600),
+		types.MakeMetricData("web2.cpu", []float64{5, 5, 5, 5, 5}, 60, 600),
+	}
+}
+
+func decode(t *testing.T, b []byte) image.Image {
+	t.Helper()
+	img, err := imagepng.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+// count returns how many pixels of img are of the color named clr.
+func count(img image.Image, clr string) int {
+	want := string2RGBA(clr)
+	n := 0
+	for y := img.Bounds().Min.Y; y < img.Bounds().Max.Y; y++ {
+		for x := img.Bounds().Min.X; x < img.Bounds().Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if uint8(r>>8) == want.R && uint8(g>>8) == want.G && uint8(b>>8) == want.B {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+func TestMarshalPNG(t *testing.T) {
+	r := httptest.NewRequest("GET", "/render?width=400&height=200&bgcolor=white&colorList=red,blue", nil)
+	b, err := MarshalPNGRequest(r, testResults(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	img := decode(t, b)
+	if size := img.Bounds().Size(); size.X != 400 || size.Y != 200 {
+		t.Errorf("expected a picture of 400x200, got %v", size)
+	}
+	if count(img, "white") == 0 || count(img, "red") == 0 || count(img, "blue") == 0 {
+		t.Error("expected the background and the series to be drawn in their colors")
+	}
+}
+
+func TestMarshalPNGArea(t *testing.T) {
+	lines := decode(t, mustMarshalPNG(t, "/render?colorList=red,blue"))
+	area := decode(t, mustMarshalPNG(t, "/render?colorList=red,blue&areaMode=first"))
+	if count(area, "red") <= 10*count(lines, "red") || count(area, "blue") > count(lines, "blue") {
+		t.Errorf("expected the area below the first series only to be filled, got %d red pixels from %d and %d blue from %d",
+			count(area, "red"), count(lines, "red"), count(area, "blue"), count(lines, "blue"))
+	}
+
+	stacked := decode(t, mustMarshalPNG(t, "/render?colorList=red,blue&areaMode=stacked"))
+	if count(stacked, "blue") <= 10*count(lines, "blue") {
+		t.Error("expected the stacked series to be filled")
+	}
+}
+
+func TestMarshalPNGYRange(t *testing.T) {
+	all := decode(t, mustMarshalPNG(t, "/render?colorList=red,blue&hideLegend=true"))
+	above := decode(t, mustMarshalPNG(t, "/render?colorList=red,blue&hideLegend=true&yMin=15&yMax=40"))
+	if count(above, "blue") != 0 || count(above, "red") == 0 || count(all, "blue") == 0 {
+		t.Error("expected the series out of the range of the Y axis not to be drawn")
+	}
+}
+
+func mustMarshalPNG(t *testing.T, url string) []byte {
+	t.Helper()
+	b, err := MarshalPNGRequest(httptest.NewRequest("GET", url, nil), testResults(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestMarshalPNGRequestErr(t *testing.T) {
+	b, err := MarshalPNGRequestErr(httptest.NewRequest("GET", "/render?bgcolor=black&fgcolor=white", nil), "Bad Request (400)", "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if img := decode(t, b); count(img, "white") == 0 {
+		t.Error("expected the error to be written")
+	}
+}
+
+func TestMarshalSVG(t *testing.T) {
+	r := httptest.NewRequest("GET", "/render?title=CPU+%26+load&lineMode=staircase&areaMode=all&areaAlpha=0.5", nil)
+	b, err := MarshalSVGRequest(r, testResults(), "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := xml.NewDecoder(bytes.NewReader(b))
+	for {
+		if _, err := d.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid SVG: %v\n%s", err, b)
+		}
+	}
+
+	for _, want := range []string{
+		`<svg xmlns="http://www.w3.org/2000/svg" width="330" height="250"`,
+		`>CPU &amp; load</text>`,
+		`>web1.cpu</text>`,
+		`fill="#6464ff" fill-opacity="0.5"`,
+		`<polyline`,
+	} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("expected %s in\n%s", want, b)
+		}
+	}
+}
+
+func TestYLabel(t *testing.T) {
+	for _, tt := range []struct {
+		system   string
+		min, max float64
+		step, v  float64
+		expected string
+	}{
+		{"si", 0, 100, 20, 40, "40"},
+		{"si", 0, 5000, 1000, 2000, "2K"},
+		{"si", 0, 1, 0.2, 0.4, "0.4"},
+		{"binary", 0, 4096, 1024, 2048, "2Ki"},
+		{"none", 0, 5000, 1000, 2000, "2000"},
+	} {
+		g := &graph{p: PictureParams{YUnitSystem: tt.system}, yMin: tt.min, yMax: tt.max, yStep: tt.step}
+		if got := g.yLabel(tt.v); got != tt.expected {
+			t.Errorf("%+v: expected %s, got %s", tt, tt.expected, got)
+		}
+	}
+}
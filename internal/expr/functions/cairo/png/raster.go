@@ -0,0 +1,198 @@
+// +build !cairo
+
+package png
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// raster draws graphs on an image, with the bitmap font.
+type raster struct {
+	img *image.RGBA
+	// scale is the size of the pixels of the font.
+	scale  int
+	bounds image.Rectangle
+}
+
+func newRaster(width, height, fontSize float64) *raster {
+	img := image.NewRGBA(image.Rect(0, 0, int(width), int(height)))
+	return &raster{
+		img:    img,
+		scale:  int(math.Max(math.Round(fontSize/10), 1)),
+		bounds: img.Bounds(),
+	}
+}
+
+// set blends c over the pixel at x, y.
+func (r *raster) set(x, y int, c color.RGBA) {
+	if !(image.Point{x, y}).In(r.bounds) {
+		return
+	}
+	if c.A == 0xff {
+		r.img.SetRGBA(x, y, c)
+		return
+	}
+
+	d := r.img.RGBAAt(x, y)
+	a := uint32(c.A)
+	blend := func(s, d uint8) uint8 {
+		return uint8((uint32(s)*a + uint32(d)*(0xff-a)) / 0xff)
+	}
+	r.img.SetRGBA(x, y, color.RGBA{
+		R: blend(c.R, d.R),
+		G: blend(c.G, d.G),
+		B: blend(c.B, d.B),
+		A: uint8(a + uint32(d.A)*(0xff-a)/0xff),
+	})
+}
+
+func (r *raster) fillRect(x, y, width, height float64, c color.RGBA) {
+	for py := int(math.Round(y)); py < int(math.Round(y+height)); py++ {
+		for px := int(math.Round(x)); px < int(math.Round(x+width)); px++ {
+			r.set(px, py, c)
+		}
+	}
+}
+
+// fill fills the pixels whose centers are inside the polygon.
+func (r *raster) fill(points []point, c color.RGBA) {
+	var xs []float64
+	for py := r.bounds.Min.Y; py < r.bounds.Max.Y; py++ {
+		y := float64(py) + 0.5
+		xs = xs[:0]
+		for i, a := range points {
+			b := points[(i+1)%len(points)]
+			if (a.y <= y) != (b.y <= y) {
+				xs = append(xs, a.x+(y-a.y)*(b.x-a.x)/(b.y-a.y))
+			}
+		}
+		sort.Float64s(xs)
+
+		for i := 0; i+1 < len(xs); i += 2 {
+			from := math.Max(math.Ceil(xs[i]-0.5), float64(r.bounds.Min.X))
+			to := math.Min(math.Ceil(xs[i+1]-0.5), float64(r.bounds.Max.X))
+			for px := int(from); px < int(to); px++ {
+				r.set(px, py, c)
+			}
+		}
+	}
+}
+
+// stroke draws the line by stamping squares of its width along it, each
+// pixel once.
+func (r *raster) stroke(points []point, width float64, c color.RGBA) {
+	half := math.Max(width, 1) / 2
+	drawn := make(map[image.Point]bool)
+	stamp := func(x, y float64) {
+		x0, y0 := int(math.Floor(x-half+0.5)), int(math.Floor(y-half+0.5))
+		x1, y1 := int(math.Floor(x+half+0.5)), int(math.Floor(y+half+0.5))
+		if x1 == x0 {
+			x1++
+		}
+		if y1 == y0 {
+			y1++
+		}
+		for py := y0; py < y1; py++ {
+			for px := x0; px < x1; px++ {
+				if p := (image.Point{px, py}); !drawn[p] {
+					drawn[p] = true
+					r.set(px, py, c)
+				}
+			}
+		}
+	}
+
+	box := r.bounds.Inset(-int(math.Ceil(width)) - 1)
+	for i := 1; i < len(points); i++ {
+		a, b, ok := clipSegment(points[i-1], points[i], box)
+		if !ok {
+			continue
+		}
+		n := int(math.Ceil(math.Hypot(b.x-a.x, b.y-a.y) * 2))
+		if n == 0 {
+			n = 1
+		}
+		for j := 0; j <= n; j++ {
+			t := float64(j) / float64(n)
+			stamp(a.x+(b.x-a.x)*t, a.y+(b.y-a.y)*t)
+		}
+	}
+}
+
+// clipSegment returns the part of the segment from a to b inside the box,
+// if any.
+func clipSegment(a, b point, box image.Rectangle) (point, point, bool) {
+	dx, dy := b.x-a.x, b.y-a.y
+	t0, t1 := 0.0, 1.0
+	for _, edge := range [...][2]float64{
+		{-dx, a.x - float64(box.Min.X)},
+		{dx, float64(box.Max.X) - a.x},
+		{-dy, a.y - float64(box.Min.Y)},
+		{dy, float64(box.Max.Y) - a.y},
+	} {
+		p, q := edge[0], edge[1]
+		if p == 0 {
+			if q < 0 {
+				return a, b, false
+			}
+			continue
+		}
+		t := q / p
+		if p < 0 {
+			t0 = math.Max(t0, t)
+		} else {
+			t1 = math.Min(t1, t)
+		}
+		if t0 > t1 {
+			return a, b, false
+		}
+	}
+
+	return point{a.x + t0*dx, a.y + t0*dy}, point{a.x + t1*dx, a.y + t1*dy}, true
+}
+
+func (r *raster) text(x, y float64, s string, c color.RGBA) {
+	px, py := int(math.Round(x)), int(math.Round(y))+r.scale
+	for _, ch := range s {
+		for col, bits := range glyph(ch) {
+			for row := 0; row < glyphHeight; row++ {
+				if bits>>uint(row)&1 == 0 {
+					continue
+				}
+				for dy := 0; dy < r.scale; dy++ {
+					for dx := 0; dx < r.scale; dx++ {
+						r.set(px+col*r.scale+dx, py+row*r.scale+dy, c)
+					}
+				}
+			}
+		}
+		px += (glyphWidth + 1) * r.scale
+	}
+}
+
+func (r *raster) textWidth(s string) float64 {
+	n := utf8.RuneCountInString(s)
+	if n == 0 {
+		return 0
+	}
+	return float64(n*(glyphWidth+1)*r.scale - r.scale)
+}
+
+func (r *raster) lineHeight() float64 {
+	return float64((glyphHeight + 3) * r.scale)
+}
+
+func (r *raster) clip(x, y, width, height float64) {
+	r.bounds = image.Rect(
+		int(math.Round(x)), int(math.Round(y)),
+		int(math.Round(x+width)), int(math.Round(y+height)),
+	).Intersect(r.img.Bounds())
+}
+
+func (r *raster) unclip() {
+	r.bounds = r.img.Bounds()
+}
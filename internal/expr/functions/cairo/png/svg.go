@@ -0,0 +1,130 @@
+// +build !cairo
+
+package png
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"image/color"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// svg draws graphs as an SVG document, leaving the fonts to the viewer.
+type svg struct {
+	buf      bytes.Buffer
+	font     string
+	fontSize float64
+	// clips counts the clipping groups open, and ids the clip paths.
+	clips, ids int
+}
+
+func newSVG(width, height float64, p PictureParams) *svg {
+	s := &svg{fontSize: p.FontSize * ratioOf(p)}
+	if s.fontSize <= 0 {
+		s.fontSize = DefaultParams.FontSize
+	}
+
+	s.font = fmt.Sprintf(` font-family="%s" font-size="%s"`, escape(p.FontName), num(s.fontSize))
+	if p.FontBold == FontWeightBold {
+		s.font += ` font-weight="bold"`
+	}
+	if p.FontItalic == FontSlantItalic {
+		s.font += ` font-style="italic"`
+	}
+
+	fmt.Fprintf(&s.buf, `<svg xmlns="http://www.w3.org/2000/svg" width="%s" height="%s" viewBox="0 0 %[1]s %[2]s">`+"\n",
+		num(width), num(height))
+
+	return s
+}
+
+// bytes returns the document.
+func (s *svg) bytes() []byte {
+	for ; s.clips > 0; s.clips-- {
+		s.buf.WriteString("</g>\n")
+	}
+	s.buf.WriteString("</svg>\n")
+	return s.buf.Bytes()
+}
+
+func num(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+func escape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// paint returns the attribute of c for what is painted, fill or stroke.
+func paint(what string, c color.RGBA) string {
+	attr := fmt.Sprintf(` %s="#%02x%02x%02x"`, what, c.R, c.G, c.B)
+	if c.A != 0xff {
+		attr += fmt.Sprintf(` %s-opacity="%s"`, what, num(float64(c.A)/0xff))
+	}
+	return attr
+}
+
+func (s *svg) points(points []point) string {
+	var b bytes.Buffer
+	for i, p := range points {
+		if i > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(num(p.x))
+		b.WriteByte(',')
+		b.WriteString(num(p.y))
+	}
+	return b.String()
+}
+
+func (s *svg) fillRect(x, y, width, height float64, c color.RGBA) {
+	fmt.Fprintf(&s.buf, `<rect x="%s" y="%s" width="%s" height="%s"%s/>`+"\n",
+		num(x), num(y), num(width), num(height), paint("fill", c))
+}
+
+func (s *svg) fill(points []point, c color.RGBA) {
+	fmt.Fprintf(&s.buf, `<polygon points="%s"%s/>`+"\n", s.points(points), paint("fill", c))
+}
+
+func (s *svg) stroke(points []point, width float64, c color.RGBA) {
+	if len(points) < 2 {
+		return
+	}
+	fmt.Fprintf(&s.buf, `<polyline points="%s" fill="none"%s stroke-width="%s" stroke-linejoin="round"/>`+"\n",
+		s.points(points), paint("stroke", c), num(width))
+}
+
+func (s *svg) text(x, y float64, text string, c color.RGBA) {
+	fmt.Fprintf(&s.buf, `<text x="%s" y="%s"%s%s>%s</text>`+"\n",
+		num(x), num(y+s.fontSize), s.font, paint("fill", c), escape(text))
+}
+
+// textWidth returns about the width of text, as fonts have glyphs about 0.6
+// times as wide as their size.
+func (s *svg) textWidth(text string) float64 {
+	return float64(utf8.RuneCountInString(text)) * s.fontSize * 0.6
+}
+
+func (s *svg) lineHeight() float64 {
+	return s.fontSize * 1.3
+}
+
+func (s *svg) clip(x, y, width, height float64) {
+	s.clips++
+	s.ids++
+	fmt.Fprintf(&s.buf, `<clipPath id="clip%d"><rect x="%s" y="%s" width="%s" height="%s"/></clipPath>`+"\n",
+		s.ids, num(x), num(y), num(width), num(height))
+	fmt.Fprintf(&s.buf, `<g clip-path="url(#clip%d)">`+"\n", s.ids)
+}
+
+func (s *svg) unclip() {
+	if s.clips > 0 {
+		s.clips--
+		s.buf.WriteString("</g>\n")
+	}
+}
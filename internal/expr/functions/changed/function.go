@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type changed struct {
@@ -41,7 +41,7 @@ func (f *changed) Do(ctx context.Context, e parser.Expr, from, until int32, valu
 		r := *a
 		r.Name = fmt.Sprintf("%s(%s)", e.Target(), a.Name)
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 
 		prev := math.NaN()
 		for i, v := range a.Values {
@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -34,14 +34,21 @@ func (f *constantLine) Do(ctx context.Context, e parser.Expr, from, until int32,
 	if err != nil {
 		return nil, err
 	}
+	// like graphite-web, the line is drawn with 3 points, the last of them
+	// at until, or just past it when the range is odd, so that the line
+	// spans the whole range whatever its length
+	step := (until - from + 1) / 2
+	if step < 1 {
+		step = 1
+	}
 	p := types.MetricData{
 		Metric: dataTypes.Metric{
 			Name:      fmt.Sprintf("%g", value),
 			StartTime: from,
-			StopTime:  until,
-			StepTime:  (until - from) / 2,
+			StopTime:  from + 3*step,
+			StepTime:  step,
 			Values:    []float64{value, value, value},
-			IsAbsent:  []bool{false, false, false},
+			IsAbsent:  types.NewAbsence(3),
 		},
 	}
 
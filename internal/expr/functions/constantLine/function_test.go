@@ -6,10 +6,10 @@ import (
 	"testing"
 	"time"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/metadata"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	th "github.com/bookingcom/carbonapi/tests"
 )
 
@@ -77,3 +77,32 @@ func TestConstantLine(t *testing.T) {
 		}
 	}
 }
+
+func TestConstantLineRange(t *testing.T) {
+	exp, _, err := parser.ParseExpr("constantLine(1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		from, until int32
+		step        int32
+	}{
+		{0, 10, 5},
+		{0, 11, 6},
+		{10, 10, 1},
+	}
+
+	for _, tst := range tests {
+		g, err := metadata.GetEvaluator().EvalExpr(context.Background(), exp, tst.from, tst.until, nil, th.NoopGetTargetData)
+		if err != nil {
+			t.Fatal(err)
+		}
+		line := g[0]
+		// the last point is at until or just past it
+		if line.StartTime != tst.from || line.StepTime != tst.step || line.StopTime != tst.from+3*tst.step {
+			t.Errorf("from %d until %d: expected step %d, got start %d, step %d and stop %d",
+				tst.from, tst.until, tst.step, line.StartTime, line.StepTime, line.StopTime)
+		}
+	}
+}
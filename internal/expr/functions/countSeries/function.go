@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type countSeries struct {
@@ -36,17 +36,20 @@ func (f *countSeries) Do(ctx context.Context, e parser.Expr, from, until int32,
 		return nil, err
 	}
 
-	r := *args[0]
-	r.Name = fmt.Sprintf("countSeries(%s)", e.RawArgs())
-	r.Values = make([]float64, len(args[0].Values))
-	r.IsAbsent = make([]bool, len(args[0].Values))
-	count := float64(len(args))
+	_, start, end, step, err := helper.Normalize(args)
+	if err != nil {
+		return nil, err
+	}
 
-	for i := range args[0].Values {
-		r.Values[i] = count
+	length := int((end - start) / step)
+	counts := make([]float64, length)
+	count := float64(len(args))
+	for i := range counts {
+		counts[i] = count
 	}
 
-	return []*types.MetricData{&r}, nil
+	r := helper.NewCombined(fmt.Sprintf("countSeries(%s)", e.RawArgs()), counts, types.NewAbsence(length), step, start, args)
+	return []*types.MetricData{r}, nil
 }
 
 // Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type delay struct {
@@ -46,26 +46,22 @@ func (f *delay) Do(ctx context.Context, e parser.Expr, from, until int32, values
 		length := len(series.Values)
 
 		newValues := make([]float64, length)
-		newIsAbsents := make([]bool, length)
+		newIsAbsents := types.NewAbsence(length)
 		var prevValues []float64
-		var prevIsAbsent []bool
 
 		for i, value := range series.Values {
 			if len(prevValues) < steps {
 				newValues[i] = 0
-				newIsAbsents[i] = true
+				newIsAbsents.Set(i, true)
 			} else {
 				newValue := prevValues[0]
-				newIsAbsent := prevIsAbsent[0]
 				prevValues = prevValues[1:]
-				prevIsAbsent = prevIsAbsent[1:]
 
 				newValues[i] = newValue
-				newIsAbsents[i] = newIsAbsent
+				newIsAbsents.Set(i, series.IsAbsent.Get(i-steps))
 			}
 
 			prevValues = append(prevValues, value)
-			prevIsAbsent = append(prevIsAbsent, series.IsAbsent[i])
 		}
 
 		result := *series
@@ -4,10 +4,10 @@ import (
 	"context"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type derivative struct {
@@ -33,11 +33,11 @@ func (f *derivative) Do(ctx context.Context, e parser.Expr, from, until int32, v
 	return helper.ForEachSeriesDo(ctx, e, from, until, values, func(a *types.MetricData, r *types.MetricData) *types.MetricData {
 		prev := math.NaN()
 		for i, v := range a.Values {
-			if a.IsAbsent[i] {
-				r.IsAbsent[i] = true
+			if a.IsAbsent.Get(i) {
+				r.IsAbsent.Set(i, true)
 				continue
 			} else if math.IsNaN(prev) {
-				r.IsAbsent[i] = true
+				r.IsAbsent.Set(i, true)
 				prev = v
 				continue
 			}
@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	"github.com/dgryski/go-onlinestats"
 )
 
@@ -51,13 +51,13 @@ func (f *ewma) Do(ctx context.Context, e parser.Expr, from, until int32, values
 		r := *a
 		r.Name = name
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 
 		ewma := onlinestats.NewExpWeight(alpha)
 
 		for i, v := range a.Values {
-			if a.IsAbsent[i] {
-				r.IsAbsent[i] = true
+			if a.IsAbsent.Get(i) {
+				r.IsAbsent.Set(i, true)
 				continue
 			}
 
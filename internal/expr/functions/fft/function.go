@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math/cmplx"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	realFFT "github.com/mjibson/go-dsp/fft"
 )
 
@@ -47,7 +47,7 @@ func (f *fft) Do(ctx context.Context, e parser.Expr, from, until int32, values m
 		r := *m
 		r.Name = name
 		r.Values = make([]float64, len(values))
-		r.IsAbsent = make([]bool, len(values))
+		r.IsAbsent = types.NewAbsence(len(values))
 		for i, v := range values {
 			r.Values[i] = f(v)
 		}
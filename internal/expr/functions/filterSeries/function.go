@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type filterSeries struct {
@@ -39,20 +39,7 @@ func (f *filterSeries) Do(ctx context.Context, e parser.Expr, from, until int32,
 		return nil, err
 	}
 
-	var callbackFunc func([]float64, []bool) (float64, bool)
-	switch callback {
-	case "max":
-		callbackFunc = types.AggMax
-	case "min":
-		callbackFunc = types.AggMin
-	case "sum":
-		callbackFunc = types.AggSum
-	case "average":
-		callbackFunc = types.AggMean
-	case "last":
-		callbackFunc = types.AggLast
-	// TODO: this implementation does not support diff, median, multiply, range, stddev
-	default:
+	if _, err := helper.GetAggregateFunc(callback); err != nil {
 		return nil, fmt.Errorf("%w: unsupported consolidation function %v", parser.ErrInvalidArgumentValue, callback)
 	}
 
@@ -77,13 +64,24 @@ func (f *filterSeries) Do(ctx context.Context, e parser.Expr, from, until int32,
 
 	threshold, err := e.GetFloatArg(3)
 	if err != nil {
-		return nil, fmt.Errorf("deneme: %w", err)
+		return nil, err
 	}
 
 	var results []*types.MetricData
 	for _, a := range args {
+		var values []float64
+		for i, v := range a.Values {
+			if !a.IsAbsent.Get(i) {
+				values = append(values, v)
+			}
+		}
+
+		// like graphite-web, series without values are filtered out
+		val, absent, _ := helper.SummarizeValues(callback, values)
+		if absent {
+			continue
+		}
 
-		val, _ := callbackFunc(a.Values, a.IsAbsent)
 		filterOut := true
 		switch operator {
 		case "=":
@@ -141,9 +139,14 @@ func (f *filterSeries) Description() map[string]types.FunctionDescription {
 					Required: true,
 					Options: []string{
 						"average",
+						"diff",
 						"last",
 						"max",
+						"median",
 						"min",
+						"multiply",
+						"range",
+						"stddev",
 						"sum",
 					},
 					Type: types.AggFunc,
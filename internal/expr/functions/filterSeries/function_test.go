@@ -2,13 +2,14 @@ package filterSeries
 
 import (
 	"go.uber.org/zap"
+	"math"
 	"testing"
 	"time"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/metadata"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	th "github.com/bookingcom/carbonapi/tests"
 )
 
@@ -92,6 +93,33 @@ func TestFilterSeriesMultiReturn(t *testing.T) {
 				"metric1.foo.bar5.baz": {types.MakeMetricData("metric1.foo.bar5.baz", []float64{15, 22, 13, 24, 15}, 1, now32)},
 			},
 		},
+		{
+			"filterSeries(metric1.foo.*.baz,\"median\", \">=\",15)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*.baz", 0, 1}: {
+					types.MakeMetricData("metric1.foo.bar5.baz", []float64{15, 22, 13, 24, 15}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar6.baz", []float64{11, 12, 13, 14, 15}, 1, now32),
+				},
+			},
+			"filterSeries",
+			map[string][]*types.MetricData{
+				"metric1.foo.bar5.baz": {types.MakeMetricData("metric1.foo.bar5.baz", []float64{15, 22, 13, 24, 15}, 1, now32)},
+			},
+		},
+		{
+			"filterSeries(metric1.foo.*.baz,\"range\", \"<\",5)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*.baz", 0, 1}: {
+					types.MakeMetricData("metric1.foo.bar5.baz", []float64{15, 22, 13, 24, 15}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar6.baz", []float64{11, 12, 13, 14, 15}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar7.baz", []float64{math.NaN(), math.NaN(), math.NaN(), math.NaN(), math.NaN()}, 1, now32),
+				},
+			},
+			"filterSeries",
+			map[string][]*types.MetricData{
+				"metric1.foo.bar6.baz": {types.MakeMetricData("metric1.foo.bar6.baz", []float64{11, 12, 13, 14, 15}, 1, now32)},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,3 @@
+package functions
+
+//go:generate go run ../../../doc/internal/generateFuncs/gen.go
@@ -5,97 +5,110 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/functions/absolute"
-	"github.com/bookingcom/carbonapi/expr/functions/alias"
-	"github.com/bookingcom/carbonapi/expr/functions/aliasByMetric"
-	"github.com/bookingcom/carbonapi/expr/functions/aliasByNode"
-	"github.com/bookingcom/carbonapi/expr/functions/aliasSub"
-	"github.com/bookingcom/carbonapi/expr/functions/applyByNode"
-	"github.com/bookingcom/carbonapi/expr/functions/asPercent"
-	"github.com/bookingcom/carbonapi/expr/functions/averageSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/averageSeriesWithWildcards"
-	"github.com/bookingcom/carbonapi/expr/functions/below"
-	"github.com/bookingcom/carbonapi/expr/functions/cactiStyle"
-	"github.com/bookingcom/carbonapi/expr/functions/cairo"
-	"github.com/bookingcom/carbonapi/expr/functions/changed"
-	"github.com/bookingcom/carbonapi/expr/functions/consolidateBy"
-	"github.com/bookingcom/carbonapi/expr/functions/constantLine"
-	"github.com/bookingcom/carbonapi/expr/functions/countSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/cumulative"
-	"github.com/bookingcom/carbonapi/expr/functions/delay"
-	"github.com/bookingcom/carbonapi/expr/functions/derivative"
-	"github.com/bookingcom/carbonapi/expr/functions/diffSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/divideSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/ewma"
-	"github.com/bookingcom/carbonapi/expr/functions/exclude"
-	"github.com/bookingcom/carbonapi/expr/functions/fallbackSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/fft"
-	"github.com/bookingcom/carbonapi/expr/functions/filterSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/grep"
-	"github.com/bookingcom/carbonapi/expr/functions/group"
-	"github.com/bookingcom/carbonapi/expr/functions/groupByNode"
-	"github.com/bookingcom/carbonapi/expr/functions/highest"
-	"github.com/bookingcom/carbonapi/expr/functions/hitcount"
-	"github.com/bookingcom/carbonapi/expr/functions/holtWintersAberration"
-	"github.com/bookingcom/carbonapi/expr/functions/holtWintersConfidenceBands"
-	"github.com/bookingcom/carbonapi/expr/functions/holtWintersForecast"
-	"github.com/bookingcom/carbonapi/expr/functions/ifft"
-	"github.com/bookingcom/carbonapi/expr/functions/integral"
-	"github.com/bookingcom/carbonapi/expr/functions/integralByInterval"
-	"github.com/bookingcom/carbonapi/expr/functions/invert"
-	"github.com/bookingcom/carbonapi/expr/functions/isNotNull"
-	"github.com/bookingcom/carbonapi/expr/functions/keepLastValue"
-	"github.com/bookingcom/carbonapi/expr/functions/kolmogorovSmirnovTest2"
-	"github.com/bookingcom/carbonapi/expr/functions/legendValue"
-	"github.com/bookingcom/carbonapi/expr/functions/limit"
-	"github.com/bookingcom/carbonapi/expr/functions/linearRegression"
-	"github.com/bookingcom/carbonapi/expr/functions/logarithm"
-	"github.com/bookingcom/carbonapi/expr/functions/lowPass"
-	"github.com/bookingcom/carbonapi/expr/functions/lowest"
-	"github.com/bookingcom/carbonapi/expr/functions/mapSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/medianSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/minMax"
-	"github.com/bookingcom/carbonapi/expr/functions/mostDeviant"
-	"github.com/bookingcom/carbonapi/expr/functions/moving"
-	"github.com/bookingcom/carbonapi/expr/functions/movingMedian"
-	"github.com/bookingcom/carbonapi/expr/functions/multiplySeries"
-	"github.com/bookingcom/carbonapi/expr/functions/multiplySeriesWithWildcards"
-	"github.com/bookingcom/carbonapi/expr/functions/nPercentile"
-	"github.com/bookingcom/carbonapi/expr/functions/nonNegativeDerivative"
-	"github.com/bookingcom/carbonapi/expr/functions/offset"
-	"github.com/bookingcom/carbonapi/expr/functions/offsetToZero"
-	"github.com/bookingcom/carbonapi/expr/functions/pearson"
-	"github.com/bookingcom/carbonapi/expr/functions/pearsonClosest"
-	"github.com/bookingcom/carbonapi/expr/functions/perSecond"
-	"github.com/bookingcom/carbonapi/expr/functions/percentileOfSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/polyfit"
-	"github.com/bookingcom/carbonapi/expr/functions/pow"
-	"github.com/bookingcom/carbonapi/expr/functions/randomWalk"
-	"github.com/bookingcom/carbonapi/expr/functions/rangeOfSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/reduce"
-	"github.com/bookingcom/carbonapi/expr/functions/removeBelowSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/removeEmptySeries"
-	"github.com/bookingcom/carbonapi/expr/functions/scale"
-	"github.com/bookingcom/carbonapi/expr/functions/scaleToSeconds"
-	"github.com/bookingcom/carbonapi/expr/functions/seriesList"
-	"github.com/bookingcom/carbonapi/expr/functions/sortBy"
-	"github.com/bookingcom/carbonapi/expr/functions/sortByName"
-	"github.com/bookingcom/carbonapi/expr/functions/squareRoot"
-	"github.com/bookingcom/carbonapi/expr/functions/stddevSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/stdev"
-	"github.com/bookingcom/carbonapi/expr/functions/substr"
-	"github.com/bookingcom/carbonapi/expr/functions/sum"
-	"github.com/bookingcom/carbonapi/expr/functions/sumSeriesWithWildcards"
-	"github.com/bookingcom/carbonapi/expr/functions/summarize"
-	"github.com/bookingcom/carbonapi/expr/functions/timeFunction"
-	"github.com/bookingcom/carbonapi/expr/functions/timeLag"
-	"github.com/bookingcom/carbonapi/expr/functions/timeShift"
-	"github.com/bookingcom/carbonapi/expr/functions/timeStack"
-	"github.com/bookingcom/carbonapi/expr/functions/transformNull"
-	"github.com/bookingcom/carbonapi/expr/functions/tukey"
-	"github.com/bookingcom/carbonapi/expr/functions/weightedAverage"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/absolute"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/aggregate"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/aggregateLine"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/aggregateSeriesLists"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/alias"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/aliasByMetric"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/aliasByNode"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/aliasByTags"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/aliasSub"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/applyByNode"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/areaBetween"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/asPercent"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/averageSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/averageSeriesWithWildcards"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/below"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/cactiStyle"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/cairo"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/changed"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/consolidateBy"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/constantLine"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/countSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/cumulative"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/delay"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/derivative"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/diffSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/divideSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/ewma"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/exclude"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/fallbackSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/fft"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/filterSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/grep"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/group"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/groupByNode"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/groupByTags"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/highest"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/histogramQuantile"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/hitcount"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/holtWintersAberration"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/holtWintersConfidenceBands"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/holtWintersForecast"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/ifft"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/integral"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/integralByInterval"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/invert"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/isNotNull"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/keepLastValue"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/kolmogorovSmirnovTest2"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/legendValue"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/limit"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/linearRegression"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/logarithm"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/lowPass"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/lowest"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/macro"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/mapSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/medianSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/minMax"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/mostDeviant"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/moving"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/movingMedian"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/multiplySeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/multiplySeriesWithWildcards"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/nPercentile"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/nonNegativeDerivative"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/offset"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/offsetToZero"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/pearson"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/pearsonClosest"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/perSecond"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/percentileOfSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/polyfit"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/pow"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/randomWalk"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/rangeOfSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/reduce"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/removeBelowSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/removeEmptySeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/scale"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/scaleToSeconds"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/secondYAxis"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/seriesByTag"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/seriesList"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/setXFilesFactor"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/sortBy"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/sortByName"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/squareRoot"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/stacked"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/stddevSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/stdev"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/substr"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/sum"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/sumSeriesWithWildcards"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/summarize"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/timeFunction"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/timeLag"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/timeShift"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/timeSlice"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/timeStack"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/transformNull"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/tukey"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/weightedAverage"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
 )
 
 type initFunc struct {
@@ -105,20 +118,29 @@ type initFunc struct {
 }
 
 func New(configs map[string]string, logger *zap.Logger) {
-	funcs := make([]initFunc, 0, 87)
+	funcs := make([]initFunc, 0, 95)
 
 	funcs = append(funcs, initFunc{name: "absolute", order: absolute.GetOrder(), f: absolute.New})
 
+	funcs = append(funcs, initFunc{name: "aggregate", order: aggregate.GetOrder(), f: aggregate.New})
+
+	funcs = append(funcs, initFunc{name: "aggregateLine", order: aggregateLine.GetOrder(), f: aggregateLine.New})
+
+	funcs = append(funcs, initFunc{name: "aggregateSeriesLists", order: aggregateSeriesLists.GetOrder(), f: aggregateSeriesLists.New})
+
 	funcs = append(funcs, initFunc{name: "alias", order: alias.GetOrder(), f: alias.New})
 
 	funcs = append(funcs, initFunc{name: "aliasByMetric", order: aliasByMetric.GetOrder(), f: aliasByMetric.New})
 
 	funcs = append(funcs, initFunc{name: "aliasByNode", order: aliasByNode.GetOrder(), f: aliasByNode.New})
+	funcs = append(funcs, initFunc{name: "aliasByTags", order: aliasByTags.GetOrder(), f: aliasByTags.New})
 
 	funcs = append(funcs, initFunc{name: "aliasSub", order: aliasSub.GetOrder(), f: aliasSub.New})
 
 	funcs = append(funcs, initFunc{name: "applyByNode", order: applyByNode.GetOrder(), f: applyByNode.New})
 
+	funcs = append(funcs, initFunc{name: "areaBetween", order: areaBetween.GetOrder(), f: areaBetween.New})
+
 	funcs = append(funcs, initFunc{name: "asPercent", order: asPercent.GetOrder(), f: asPercent.New})
 
 	funcs = append(funcs, initFunc{name: "averageSeries", order: averageSeries.GetOrder(), f: averageSeries.New})
@@ -164,9 +186,12 @@ func New(configs map[string]string, logger *zap.Logger) {
 	funcs = append(funcs, initFunc{name: "group", order: group.GetOrder(), f: group.New})
 
 	funcs = append(funcs, initFunc{name: "groupByNode", order: groupByNode.GetOrder(), f: groupByNode.New})
+	funcs = append(funcs, initFunc{name: "groupByTags", order: groupByTags.GetOrder(), f: groupByTags.New})
 
 	funcs = append(funcs, initFunc{name: "highest", order: highest.GetOrder(), f: highest.New})
 
+	funcs = append(funcs, initFunc{name: "histogramQuantile", order: histogramQuantile.GetOrder(), f: histogramQuantile.New})
+
 	funcs = append(funcs, initFunc{name: "hitcount", order: hitcount.GetOrder(), f: hitcount.New})
 
 	funcs = append(funcs, initFunc{name: "holtWintersAberration", order: holtWintersAberration.GetOrder(), f: holtWintersAberration.New})
@@ -201,6 +226,8 @@ func New(configs map[string]string, logger *zap.Logger) {
 
 	funcs = append(funcs, initFunc{name: "lowest", order: lowest.GetOrder(), f: lowest.New})
 
+	funcs = append(funcs, initFunc{name: "macro", order: macro.GetOrder(), f: macro.New})
+
 	funcs = append(funcs, initFunc{name: "mapSeries", order: mapSeries.GetOrder(), f: mapSeries.New})
 
 	funcs = append(funcs, initFunc{name: "medianSeries", order: medianSeries.GetOrder(), f: medianSeries.New})
@@ -251,14 +278,21 @@ func New(configs map[string]string, logger *zap.Logger) {
 
 	funcs = append(funcs, initFunc{name: "scaleToSeconds", order: scaleToSeconds.GetOrder(), f: scaleToSeconds.New})
 
+	funcs = append(funcs, initFunc{name: "secondYAxis", order: secondYAxis.GetOrder(), f: secondYAxis.New})
+
+	funcs = append(funcs, initFunc{name: "seriesByTag", order: seriesByTag.GetOrder(), f: seriesByTag.New})
 	funcs = append(funcs, initFunc{name: "seriesList", order: seriesList.GetOrder(), f: seriesList.New})
 
+	funcs = append(funcs, initFunc{name: "setXFilesFactor", order: setXFilesFactor.GetOrder(), f: setXFilesFactor.New})
+
 	funcs = append(funcs, initFunc{name: "sortBy", order: sortBy.GetOrder(), f: sortBy.New})
 
 	funcs = append(funcs, initFunc{name: "sortByName", order: sortByName.GetOrder(), f: sortByName.New})
 
 	funcs = append(funcs, initFunc{name: "squareRoot", order: squareRoot.GetOrder(), f: squareRoot.New})
 
+	funcs = append(funcs, initFunc{name: "stacked", order: stacked.GetOrder(), f: stacked.New})
+
 	funcs = append(funcs, initFunc{name: "stddevSeries", order: stddevSeries.GetOrder(), f: stddevSeries.New})
 
 	funcs = append(funcs, initFunc{name: "stdev", order: stdev.GetOrder(), f: stdev.New})
@@ -277,6 +311,8 @@ func New(configs map[string]string, logger *zap.Logger) {
 
 	funcs = append(funcs, initFunc{name: "timeShift", order: timeShift.GetOrder(), f: timeShift.New})
 
+	funcs = append(funcs, initFunc{name: "timeSlice", order: timeSlice.GetOrder(), f: timeSlice.New})
+
 	funcs = append(funcs, initFunc{name: "timeStack", order: timeStack.GetOrder(), f: timeStack.New})
 
 	funcs = append(funcs, initFunc{name: "transformNull", order: transformNull.GetOrder(), f: transformNull.New})
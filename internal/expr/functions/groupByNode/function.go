@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type groupByNode struct {
@@ -37,45 +37,59 @@ func (f *groupByNode) Do(ctx context.Context, e parser.Expr, from, until int32,
 		return nil, err
 	}
 	var callback string
-	var fields []int
+	var fields []parser.NodeOrTag
 
 	if e.Target() == "groupByNode" {
-		var field int
-		field, err = e.GetIntArg(1)
+		fields, err = e.GetNodeOrTagArgs(1)
 		if err != nil {
 			return nil, err
 		}
+		// the callback follows the node
+		fields = fields[:1]
 
-		callback, err = e.GetStringArg(2)
+		callback, err = e.GetStringArgDefault(2, "average")
 		if err != nil {
 			return nil, err
 		}
-		fields = []int{field}
 	} else {
 		callback, err = e.GetStringArg(1)
 		if err != nil {
 			return nil, err
 		}
 
-		fields, err = e.GetIntArgs(2)
+		fields, err = e.GetNodeOrTagArgs(2)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	var results []*types.MetricData
+	// like graphite-web, series functions are accepted as callbacks by the
+	// name of their aggregation, e.g. sumSeries for sum
+	callback = strings.TrimSuffix(callback, "Series")
+	if _, err := helper.GetAggregateFunc(callback); err != nil {
+		return nil, err
+	}
 
 	groups := make(map[string][]*types.MetricData)
 	nodeList := []string{}
 	for _, a := range args {
-		metric := helper.ExtractMetric(a.Name)
+		tags := a.GetTags()
+		metric := helper.ExtractMetric(tags["name"])
 		nodes := strings.Split(metric, ".")
 		nodeKey := make([]string, 0, len(fields))
 		for _, f := range fields {
-			if f < 0 || f >= len(nodes) {
-				return nil, fmt.Errorf("%s: %w: %d", e.Target(), parser.ErrInvalidArgumentValue, f)
+			if f.IsTag {
+				nodeKey = append(nodeKey, tags[f.Tag])
+				continue
+			}
+			n := f.Node
+			if n < 0 {
+				n += len(nodes)
 			}
-			nodeKey = append(nodeKey, nodes[f])
+			if n < 0 || n >= len(nodes) {
+				return nil, fmt.Errorf("%s: %w: %d", e.Target(), parser.ErrInvalidArgumentValue, f.Node)
+			}
+			nodeKey = append(nodeKey, nodes[n])
 		}
 		node := strings.Join(nodeKey, ".")
 		if len(groups[node]) == 0 {
@@ -85,40 +99,13 @@ func (f *groupByNode) Do(ctx context.Context, e parser.Expr, from, until int32,
 		groups[node] = append(groups[node], a)
 	}
 
+	var results []*types.MetricData
 	for _, k := range nodeList {
-		k := k // k's reference is used later, so it's important to make it unique per loop
-		v := groups[k]
-
-		// Ensure that names won't be parsed as consts, appending stub to them
-		expr := fmt.Sprintf("%s(stub_%s)", callback, k)
-
-		// create a stub context to evaluate the callback in
-		nexpr, _, err := parser.ParseExpr(expr)
-		// remove all stub_ prefixes we've prepended before
-		nexpr.SetRawArgs(strings.Replace(nexpr.RawArgs(), "stub_", "", 1))
-		for argIdx := range nexpr.Args() {
-			nexpr.Args()[argIdx].SetTarget(strings.Replace(nexpr.Args()[0].Target(), "stub_", "", 1))
-		}
+		r, err := helper.AggregateSeriesByName(k, groups[k], callback, helper.DefaultXFilesFactor())
 		if err != nil {
 			return nil, err
 		}
-
-		nvalues := values
-		if e.Target() == "groupByNode" || e.Target() == "groupByNodes" {
-			nvalues = map[parser.MetricRequest][]*types.MetricData{
-				parser.MetricRequest{
-					Metric: k,
-					From:   from,
-					Until:  until,
-				}: v,
-			}
-		}
-
-		r, _ := f.Evaluator.EvalExpr(ctx, nexpr, from, until, nvalues, getTargetData)
-		if r != nil {
-			r[0].Name = k
-			results = append(results, r...)
-		}
+		results = append(results, r...)
 	}
 
 	return results, nil
@@ -2,46 +2,22 @@ package groupByNode
 
 import (
 	"go.uber.org/zap"
+	"math"
 	"testing"
 	"time"
 
-	"github.com/bookingcom/carbonapi/expr/functions/averageSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/diffSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/minMax"
-	"github.com/bookingcom/carbonapi/expr/functions/stddevSeries"
-	"github.com/bookingcom/carbonapi/expr/functions/sum"
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/metadata"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	th "github.com/bookingcom/carbonapi/tests"
 )
 
 func init() {
-	s := sum.New("")
-	for _, m := range s {
-		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
-	}
 	md := New("")
 	for _, m := range md {
 		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
 	}
-	mm := minMax.New("")
-	for _, m := range mm {
-		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
-	}
-	as := averageSeries.New("")
-	for _, m := range as {
-		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
-	}
-	stds := stddevSeries.New("")
-	for _, m := range stds {
-		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
-	}
-	ds := diffSeries.New("")
-	for _, m := range ds {
-		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
-	}
 	evaluator := th.EvaluatorFromFuncWithMetadata(metadata.FunctionMD.Functions)
 	metadata.SetEvaluator(evaluator)
 	helper.SetEvaluator(evaluator)
@@ -164,6 +140,35 @@ func TestGroupByNode(t *testing.T) {
 				"127_0_0_1:2004": {types.MakeMetricData("127_0_0_1:2004", []float64{13, 15, 17, 19, 21}, 1, now32)},
 			},
 		},
+		{
+			"groupByNode(metric1.foo.*.*,3,\"p50\")",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*.*", 0, 1}: {
+					types.MakeMetricData("metric1.foo.bar1.baz", []float64{1, 2, 3, 4, 5}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar2.baz", []float64{5, 6, 7, 8, 9}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar3.baz", []float64{3, 4, 5, 6, 7}, 1, now32),
+				},
+			},
+			"groupByNodeP50",
+			map[string][]*types.MetricData{
+				"baz": {types.MakeMetricData("baz", []float64{3, 4, 5, 6, 7}, 1, now32)},
+			},
+		},
+		{
+			"groupByNodes(metric1.foo.*.*,\"percentile100\",3)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*.*", 0, 1}: {
+					types.MakeMetricData("metric1.foo.bar1.baz", []float64{1, 2, 3, 4, 5}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar2.baz", []float64{5, 6, 7, 8, 9}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar3.qux", []float64{3, 4, 5, 6, 7}, 1, now32),
+				},
+			},
+			"groupByNodesPercentile100",
+			map[string][]*types.MetricData{
+				"baz": {types.MakeMetricData("baz", []float64{5, 6, 7, 8, 9}, 1, now32)},
+				"qux": {types.MakeMetricData("qux", []float64{3, 4, 5, 6, 7}, 1, now32)},
+			},
+		},
 		{
 			"groupByNodes(metric1.foo.*.*,\"sum\",0,1,3)",
 			map[parser.MetricRequest][]*types.MetricData{
@@ -180,6 +185,49 @@ func TestGroupByNode(t *testing.T) {
 				"metric1.foo.qux": {types.MakeMetricData("metric1.foo.qux", []float64{13, 15, 17, 19, 21}, 1, now32)},
 			},
 		},
+		{
+			"groupByNodes(metric1.foo.*.*,\"average\",2,-1)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*.*", 0, 1}: {
+					types.MakeMetricData("metric1.foo.bar1.baz", []float64{1, 2, 3, 4, 5}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar1.baz", []float64{3, 4, 5, 6, 7}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar2.baz", []float64{11, 12, 13, 14, 15}, 1, now32),
+				},
+			},
+			"groupByNodesAverage",
+			map[string][]*types.MetricData{
+				"bar1.baz": {types.MakeMetricData("bar1.baz", []float64{2, 3, 4, 5, 6}, 1, now32)},
+				"bar2.baz": {types.MakeMetricData("bar2.baz", []float64{11, 12, 13, 14, 15}, 1, now32)},
+			},
+		},
+		{
+			"groupByNodes(metric1.foo.*.*,\"multiplySeries\",3)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1.foo.*.*", 0, 1}: {
+					types.MakeMetricData("metric1.foo.bar1.baz", []float64{1, 2, 3, 4, 5}, 1, now32),
+					types.MakeMetricData("metric1.foo.bar2.baz", []float64{2, 2, math.NaN(), 2, 2}, 1, now32),
+				},
+			},
+			"groupByNodesMultiplySeries",
+			map[string][]*types.MetricData{
+				"baz": {types.MakeMetricData("baz", []float64{2, 4, math.NaN(), 8, 10}, 1, now32)},
+			},
+		},
+		{
+			"groupByNodes(cpu.*,\"max\",\"dc\",1)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"cpu.*", 0, 1}: {
+					types.MakeMetricData("cpu.user;dc=dc1;host=a", []float64{1, 2, 3, 4, 5}, 1, now32),
+					types.MakeMetricData("cpu.user;dc=dc1;host=b", []float64{5, 4, 3, 2, 1}, 1, now32),
+					types.MakeMetricData("cpu.user;dc=dc2;host=c", []float64{7, 7, 7, 7, 7}, 1, now32),
+				},
+			},
+			"groupByNodesTags",
+			map[string][]*types.MetricData{
+				"dc1.user": {types.MakeMetricData("dc1.user", []float64{5, 4, 3, 4, 5}, 1, now32)},
+				"dc2.user": {types.MakeMetricData("dc2.user", []float64{7, 7, 7, 7, 7}, 1, now32)},
+			},
+		},
 	}
 
 	for _, tt := range tests {
@@ -0,0 +1,128 @@
+package groupByTags
+
+import (
+	"context"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type groupByTags struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &groupByTags{}
+	for _, n := range []string{"groupByTags"} {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// groupByTags(seriesList, callback, *tags)
+func (f *groupByTags) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	callback, err := e.GetStringArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := helper.GetAggregateFunc(callback); err != nil {
+		return nil, err
+	}
+
+	if len(e.Args()) < 3 {
+		return nil, parser.ErrMissingArgument
+	}
+	tags := make([]string, 0, len(e.Args())-2)
+	for i := 2; i < len(e.Args()); i++ {
+		tag, err := e.GetStringArg(i)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	// as in graphite-web, groups are named after the name tag if it's
+	// grouped by or the same for all series, and after the callback if not
+	groupName := callback
+	names := make(map[string]bool)
+	for _, a := range args {
+		names[a.GetTags()["name"]] = true
+	}
+	if len(names) == 1 {
+		for name := range names {
+			groupName = name
+		}
+	}
+
+	groups := make(map[string][]*types.MetricData)
+	keys := []string{}
+	for _, a := range args {
+		seriesTags := a.GetTags()
+		groupTags := map[string]string{"name": groupName}
+		for _, tag := range tags {
+			groupTags[tag] = seriesTags[tag]
+		}
+		k := types.TaggedName(groupTags)
+		if len(groups[k]) == 0 {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], a)
+	}
+
+	var results []*types.MetricData
+	for _, k := range keys {
+		r, err := helper.AggregateSeriesByName(k, groups[k], callback, helper.DefaultXFilesFactor())
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range r {
+			s.Tags = types.ExtractTags(k)
+		}
+		results = append(results, r...)
+	}
+
+	return results, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *groupByTags) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"groupByTags": {
+			Description: "Takes a serieslist and maps a callback to subgroups within as defined by multiple tags\n\n.. code-block:: none\n\n  &target=seriesByTag(\"name=cpu\")|groupByTags(\"average\",\"dc\")\n\nWould return multiple series which are each the result of applying the \"averageSeries\" function\nto groups joined on the specified tags resulting in a list of targets like\n\n.. code-block :: none\n\n  averageSeries(seriesByTag(\"name=cpu\",\"dc=dc1\")),averageSeries(seriesByTag(\"name=cpu\",\"dc=dc2\")),...\n\nThis function can be used with all aggregation functions supported by\n:py:func:`aggregate <aggregate>`: ``average``, ``median``, ``sum``, ``min``, ``max``, ``diff``,\n``stddev``, ``range`` & ``multiply``.",
+			Function:    "groupByTags(seriesList, callback, *tags)",
+			Group:       "Combine",
+			Module:      "graphite.render.functions",
+			Name:        "groupByTags",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "callback",
+					Required: true,
+					Type:     types.AggFunc,
+				},
+				{
+					Name:     "tags",
+					Required: true,
+					Multiple: true,
+					Type:     types.Tag,
+				},
+			},
+		},
+	}
+}
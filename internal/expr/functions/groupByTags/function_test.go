@@ -0,0 +1,75 @@
+package groupByTags
+
+import (
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestGroupByTags(t *testing.T) {
+	data := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "metric1", From: 0, Until: 1}: {
+			types.MakeMetricData("cpu;dc=ams;host=web1", []float64{1, 2, 3}, 1, 0),
+			types.MakeMetricData("cpu;dc=ams;host=web2", []float64{3, 4, 5}, 1, 0),
+			types.MakeMetricData("cpu;dc=lhr;host=web3", []float64{5, 6, 7}, 1, 0),
+		},
+		{Metric: "metric2", From: 0, Until: 1}: {
+			types.MakeMetricData("cpu;dc=ams", []float64{1, 2, 3}, 1, 0),
+			types.MakeMetricData("mem;dc=ams", []float64{3, 4, 5}, 1, 0),
+		},
+	}
+
+	tests := []th.MultiReturnEvalTestItem{
+		{
+			"groupByTags(metric1,\"sum\",\"dc\")",
+			data,
+			"groupByTags",
+			map[string][]*types.MetricData{
+				"cpu;dc=ams": {types.MakeMetricData("cpu;dc=ams", []float64{4, 6, 8}, 1, 0)},
+				"cpu;dc=lhr": {types.MakeMetricData("cpu;dc=lhr", []float64{5, 6, 7}, 1, 0)},
+			},
+		},
+		{
+			"groupByTags(metric1,\"max\",\"name\",\"dc\",\"host\")",
+			data,
+			"groupByTagsAll",
+			map[string][]*types.MetricData{
+				"cpu;dc=ams;host=web1": {types.MakeMetricData("cpu;dc=ams;host=web1", []float64{1, 2, 3}, 1, 0)},
+				"cpu;dc=ams;host=web2": {types.MakeMetricData("cpu;dc=ams;host=web2", []float64{3, 4, 5}, 1, 0)},
+				"cpu;dc=lhr;host=web3": {types.MakeMetricData("cpu;dc=lhr;host=web3", []float64{5, 6, 7}, 1, 0)},
+			},
+		},
+		{
+			"groupByTags(metric2,\"average\",\"dc\")",
+			data,
+			"groupByTagsMixedNames",
+			map[string][]*types.MetricData{
+				"average;dc=ams": {types.MakeMetricData("average;dc=ams", []float64{2, 3, 4}, 1, 0)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		testName := tt.Target
+		t.Run(testName, func(t *testing.T) {
+			th.TestMultiReturnEvalExpr(t, &tt)
+		})
+	}
+}
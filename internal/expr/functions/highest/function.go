@@ -5,10 +5,10 @@ import (
 	"context"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type highest struct {
@@ -54,7 +54,7 @@ func (f *highest) Do(ctx context.Context, e parser.Expr, from, until int32, valu
 
 	var mh types.MetricHeap
 
-	var compute func([]float64, []bool) float64
+	var compute func([]float64, types.Absence) float64
 
 	switch e.Target() {
 	case "highestMax":
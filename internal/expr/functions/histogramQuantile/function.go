@@ -0,0 +1,192 @@
+package histogramQuantile
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type histogramQuantile struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &histogramQuantile{}
+	for _, n := range []string{"histogramQuantile"} {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// bucket is a point of a bucket series: the count of the observations less
+// than or equal to its upper bound.
+type bucket struct {
+	upperBound float64
+	count      float64
+}
+
+// histogramQuantile(seriesList, phi)
+func (f *histogramQuantile) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	phi, err := e.GetFloatArg(1)
+	if err != nil {
+		return nil, err
+	}
+	if phi < 0 || phi > 1 || math.IsNaN(phi) {
+		return nil, parser.ParseError("phi must be between 0 and 1")
+	}
+
+	// the buckets of a histogram are the series with the same tags but le,
+	// their upper bound. Series without one are not buckets and are skipped.
+	groups := make(map[string][]*types.MetricData)
+	upperBounds := make(map[*types.MetricData]float64)
+	keys := []string{}
+	for _, a := range args {
+		tags := a.GetTags()
+		le, ok := tags["le"]
+		if !ok {
+			continue
+		}
+		upperBound, err := strconv.ParseFloat(le, 64)
+		if err != nil {
+			continue
+		}
+		upperBounds[a] = upperBound
+
+		groupTags := make(map[string]string, len(tags)-1)
+		for k, v := range tags {
+			if k != "le" {
+				groupTags[k] = v
+			}
+		}
+		k := types.TaggedName(groupTags)
+		if len(groups[k]) == 0 {
+			keys = append(keys, k)
+		}
+		groups[k] = append(groups[k], a)
+	}
+
+	var results []*types.MetricData
+	for _, k := range keys {
+		seriesList, start, end, step, err := helper.Normalize(groups[k])
+		if err != nil {
+			return nil, err
+		}
+
+		length := int((end - start) / step)
+		result := make([]float64, length)
+		isAbsent := types.NewAbsence(length)
+		buckets := make([]bucket, 0, len(seriesList))
+		for i := 0; i < length; i++ {
+			buckets = buckets[:0]
+			for j, s := range seriesList {
+				if i < s.IsAbsent.Len() && !s.IsAbsent.Get(i) {
+					buckets = append(buckets, bucket{upperBound: upperBounds[groups[k][j]], count: s.Values[i]})
+				}
+			}
+
+			var absent bool
+			result[i], absent = quantile(phi, buckets)
+			isAbsent.Set(i, absent)
+		}
+
+		r := helper.NewCombined(fmt.Sprintf("histogramQuantile(%s,%g)", k, phi), result, isAbsent, step, start, groups[k])
+		r.Tags = types.ExtractTags(k)
+		results = append(results, r)
+	}
+
+	return results, nil
+}
+
+// quantile estimates the phi-quantile of the observations counted in
+// buckets as histogram_quantile of Prometheus does: by linear interpolation
+// within the bucket the quantile falls in, assuming the observations are
+// spread evenly in it. It is absent when there are no observations, or no
+// +Inf bucket to count them all.
+func quantile(phi float64, buckets []bucket) (float64, bool) {
+	sort.Slice(buckets, func(i, j int) bool {
+		return buckets[i].upperBound < buckets[j].upperBound
+	})
+	if len(buckets) < 2 || !math.IsInf(buckets[len(buckets)-1].upperBound, 1) {
+		return 0, true
+	}
+
+	// the counts of points scraped at slightly different times may
+	// decrease across buckets, which would make the rank ambiguous
+	for i := 1; i < len(buckets); i++ {
+		if buckets[i].count < buckets[i-1].count {
+			buckets[i].count = buckets[i-1].count
+		}
+	}
+
+	observations := buckets[len(buckets)-1].count
+	if observations <= 0 {
+		return 0, true
+	}
+	rank := phi * observations
+	b := sort.Search(len(buckets)-1, func(i int) bool { return buckets[i].count >= rank })
+
+	// quantiles in the +Inf bucket are its lower bound, quantiles in the
+	// first bucket interpolate from 0 unless it is not above 0
+	if b == len(buckets)-1 {
+		return buckets[len(buckets)-2].upperBound, false
+	}
+	if b == 0 && buckets[0].upperBound <= 0 {
+		return buckets[0].upperBound, false
+	}
+
+	bucketStart := 0.0
+	bucketEnd := buckets[b].upperBound
+	count := buckets[b].count
+	if b > 0 {
+		bucketStart = buckets[b-1].upperBound
+		count -= buckets[b-1].count
+		rank -= buckets[b-1].count
+	}
+	if count == 0 {
+		return bucketStart, false
+	}
+
+	return bucketStart + (bucketEnd-bucketStart)*(rank/count), false
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *histogramQuantile) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"histogramQuantile": {
+			Description: "Estimates the phi-quantile (0 <= phi <= 1) of the histograms of the series, as histogram_quantile of Prometheus does.\n\nThe buckets of a histogram are the series with the same tags but ``le``, the upper bound of the bucket, one of which must be ``+Inf``. Each point of a bucket counts the observations less than or equal to its upper bound, so counters should be given as rates, e.g.\n\n.. code-block:: none\n\n  &target=histogramQuantile(perSecond(seriesByTag('name=http_request_duration_seconds_bucket')),0.9)\n\nSeries without an ``le`` tag are skipped.",
+			Function:    "histogramQuantile(seriesList, phi)",
+			Group:       "Combine",
+			Module:      "graphite.render.functions.custom",
+			Name:        "histogramQuantile",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "phi",
+					Required: true,
+					Type:     types.Float,
+				},
+			},
+		},
+	}
+}
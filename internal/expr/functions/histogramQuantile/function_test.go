@@ -0,0 +1,85 @@
+package histogramQuantile
+
+import (
+	"context"
+	"math"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestHistogramQuantile(t *testing.T) {
+	data := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "latency", From: 0, Until: 1}: {
+			types.MakeMetricData("latency_bucket;dc=ams;le=0.25", []float64{1, 0, 2}, 1, 0),
+			types.MakeMetricData("latency_bucket;dc=ams;le=+Inf", []float64{4, 0, 2}, 1, 0),
+			types.MakeMetricData("latency_bucket;dc=ams;le=0.5", []float64{3, 0, 2}, 1, 0),
+			types.MakeMetricData("latency_bucket;dc=lhr;le=1", []float64{1, 2, 3}, 1, 0),
+			types.MakeMetricData("latency_bucket;dc=lhr;le=+Inf", []float64{2, math.NaN(), 3}, 1, 0),
+			types.MakeMetricData("latency_count;dc=ams", []float64{4, 0, 2}, 1, 0),
+		},
+	}
+
+	tests := []th.MultiReturnEvalTestItem{
+		{
+			"histogramQuantile(latency,0.5)",
+			data,
+			"histogramQuantile",
+			map[string][]*types.MetricData{
+				"histogramQuantile(latency_bucket;dc=ams,0.5)": {types.MakeMetricData("histogramQuantile(latency_bucket;dc=ams,0.5)", []float64{0.375, math.NaN(), 0.125}, 1, 0)},
+				"histogramQuantile(latency_bucket;dc=lhr,0.5)": {types.MakeMetricData("histogramQuantile(latency_bucket;dc=lhr,0.5)", []float64{1, math.NaN(), 0.5}, 1, 0)},
+			},
+		},
+		{
+			"histogramQuantile(latency,1)",
+			data,
+			"histogramQuantileInf",
+			map[string][]*types.MetricData{
+				"histogramQuantile(latency_bucket;dc=ams,1)": {types.MakeMetricData("histogramQuantile(latency_bucket;dc=ams,1)", []float64{0.5, math.NaN(), 0.25}, 1, 0)},
+				"histogramQuantile(latency_bucket;dc=lhr,1)": {types.MakeMetricData("histogramQuantile(latency_bucket;dc=lhr,1)", []float64{1, math.NaN(), 1}, 1, 0)},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		testName := tt.Target
+		t.Run(testName, func(t *testing.T) {
+			th.TestMultiReturnEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestHistogramQuantileInvalidPhi(t *testing.T) {
+	data := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "latency", From: 0, Until: 1}: {
+			types.MakeMetricData("latency_bucket;le=+Inf", []float64{1}, 1, 0),
+		},
+	}
+
+	for _, target := range []string{"histogramQuantile(latency,1.5)", "histogramQuantile(latency,-0.1)"} {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := metadata.GetEvaluator().EvalExpr(context.Background(), exp, 0, 1, data, th.NoopGetTargetData); err == nil {
+			t.Errorf("%s: expected an error", target)
+		}
+	}
+}
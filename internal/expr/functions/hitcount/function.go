@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -71,7 +71,7 @@ func (f *hitcount) Do(ctx context.Context, e parser.Expr, from, until int32, val
 		r := types.MetricData{Metric: dataTypes.Metric{
 			Name:      name,
 			Values:    make([]float64, buckets, buckets+1),
-			IsAbsent:  make([]bool, buckets, buckets+1),
+			IsAbsent:  types.NewAbsence(int(buckets)),
 			StepTime:  bucketSize,
 			StartTime: start,
 			StopTime:  stop,
@@ -84,7 +84,7 @@ func (f *hitcount) Do(ctx context.Context, e parser.Expr, from, until int32, val
 		bucketItems := 0
 		for i, v := range arg.Values {
 			bucketItems++
-			if !arg.IsAbsent[i] {
+			if !arg.IsAbsent.Get(i) {
 				if math.IsNaN(count) {
 					count = 0
 				}
@@ -101,7 +101,7 @@ func (f *hitcount) Do(ctx context.Context, e parser.Expr, from, until int32, val
 			if t >= bucketEnd {
 				if math.IsNaN(count) {
 					r.Values[ridx] = 0
-					r.IsAbsent[ridx] = true
+					r.IsAbsent.Set(ridx, true)
 				} else {
 					r.Values[ridx] = count
 				}
@@ -117,7 +117,7 @@ func (f *hitcount) Do(ctx context.Context, e parser.Expr, from, until int32, val
 		if bucketItems > 0 {
 			if math.IsNaN(count) {
 				r.Values[ridx] = 0
-				r.IsAbsent[ridx] = true
+				r.IsAbsent.Set(ridx, true)
 			} else {
 				r.Values[ridx] = count
 			}
@@ -5,11 +5,11 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/holtwinters"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/holtwinters"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -55,10 +55,9 @@ func (f *holtWintersAberration) Do(ctx context.Context, e parser.Expr, from, unt
 			s = 0
 		}
 		series := arg.Values[s:]
-		absent := arg.IsAbsent[s:]
 
 		for i := range series {
-			if absent[i] {
+			if arg.IsAbsent.Get(int(s) + i) {
 				aberration = append(aberration, 0)
 			} else if !math.IsNaN(upperBand[i]) && series[i] > upperBand[i] {
 				aberration = append(aberration, series[i]-upperBand[i])
@@ -72,7 +71,7 @@ func (f *holtWintersAberration) Do(ctx context.Context, e parser.Expr, from, unt
 		r := types.MetricData{Metric: dataTypes.Metric{
 			Name:      fmt.Sprintf("holtWintersAberration(%s)", arg.Name),
 			Values:    aberration,
-			IsAbsent:  make([]bool, len(aberration)),
+			IsAbsent:  types.NewAbsence(len(aberration)),
 			StepTime:  arg.StepTime,
 			StartTime: arg.StopTime - int32(datapoints)*stepTime,
 			StopTime:  arg.StopTime,
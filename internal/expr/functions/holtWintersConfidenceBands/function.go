@@ -5,11 +5,11 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/holtwinters"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/holtwinters"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -48,7 +48,7 @@ func (f *holtWintersConfidenceBands) Do(ctx context.Context, e parser.Expr, from
 		values := make([]float64, len(arg.Values))
 		for i := 0; i < len(values); i++ {
 			values[i] = arg.Values[i]
-			if arg.IsAbsent[i] {
+			if arg.IsAbsent.Get(i) {
 				values[i] = math.NaN()
 			}
 		}
@@ -57,7 +57,7 @@ func (f *holtWintersConfidenceBands) Do(ctx context.Context, e parser.Expr, from
 		lowerSeries := types.MetricData{Metric: dataTypes.Metric{
 			Name:      fmt.Sprintf("holtWintersConfidenceLower(%s)", arg.Name),
 			Values:    lowerBand,
-			IsAbsent:  make([]bool, len(lowerBand)),
+			IsAbsent:  types.NewAbsence(len(lowerBand)),
 			StepTime:  arg.StepTime,
 			StartTime: arg.StopTime - int32(datapoints)*stepTime,
 			StopTime:  arg.StopTime,
@@ -66,14 +66,14 @@ func (f *holtWintersConfidenceBands) Do(ctx context.Context, e parser.Expr, from
 		for i, val := range lowerSeries.Values {
 			if math.IsNaN(val) {
 				lowerSeries.Values[i] = 0
-				lowerSeries.IsAbsent[i] = true
+				lowerSeries.IsAbsent.Set(i, true)
 			}
 		}
 
 		upperSeries := types.MetricData{Metric: dataTypes.Metric{
 			Name:      fmt.Sprintf("holtWintersConfidenceUpper(%s)", arg.Name),
 			Values:    upperBand,
-			IsAbsent:  make([]bool, len(upperBand)),
+			IsAbsent:  types.NewAbsence(len(upperBand)),
 			StepTime:  arg.StepTime,
 			StartTime: arg.StopTime - int32(datapoints)*stepTime,
 			StopTime:  arg.StopTime,
@@ -82,7 +82,7 @@ func (f *holtWintersConfidenceBands) Do(ctx context.Context, e parser.Expr, from
 		for i, val := range upperSeries.Values {
 			if math.IsNaN(val) {
 				upperSeries.Values[i] = 0
-				upperSeries.IsAbsent[i] = true
+				upperSeries.IsAbsent.Set(i, true)
 			}
 		}
 
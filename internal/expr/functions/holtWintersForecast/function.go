@@ -4,11 +4,11 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/holtwinters"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/holtwinters"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -48,7 +48,7 @@ func (f *holtWintersForecast) Do(ctx context.Context, e parser.Expr, from, until
 		r := types.MetricData{Metric: dataTypes.Metric{
 			Name:      fmt.Sprintf("holtWintersForecast(%s)", arg.Name),
 			Values:    predictionsOfInterest,
-			IsAbsent:  make([]bool, len(predictionsOfInterest)),
+			IsAbsent:  types.NewAbsence(len(predictionsOfInterest)),
 			StepTime:  arg.StepTime,
 			StartTime: arg.StartTime + 7*86400,
 			StopTime:  arg.StopTime,
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math/cmplx"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	realFFT "github.com/mjibson/go-dsp/fft"
 )
 
@@ -49,14 +49,14 @@ func (f *ifft) Do(ctx context.Context, e parser.Expr, from, until int32, values
 	for j, a := range absSeriesList {
 		r := *a
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 		if len(phaseSeriesList) > j {
 			p := phaseSeriesList[j]
 			name := fmt.Sprintf("ifft(%s, %s)", a.Name, p.Name)
 			r.Name = name
 			values := make([]complex128, len(a.Values))
 			for i, v := range a.Values {
-				if a.IsAbsent[i] {
+				if a.IsAbsent.Get(i) {
 					v = 0
 				}
 
@@ -0,0 +1,137 @@
+package integral
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type integral struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &integral{}
+	functions := []string{"integral"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// integral(seriesList, resetInterval=None, maxValue=None)
+func (f *integral) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	resetInterval, err := e.GetStringNamedOrPosArgDefault("resetInterval", 1, "")
+	if err != nil {
+		return nil, err
+	}
+	var reset int64
+	if resetInterval != "" {
+		interval, err := parser.IntervalString(resetInterval, 1)
+		if err != nil {
+			return nil, err
+		}
+		if interval <= 0 {
+			return nil, fmt.Errorf("%s: %w: resetInterval %s", e.Target(), parser.ErrInvalidArgumentValue, resetInterval)
+		}
+		reset = int64(interval)
+	}
+
+	maxValue, err := e.GetFloatNamedOrPosArgDefault("maxValue", 2, math.NaN())
+	if err != nil {
+		return nil, err
+	}
+	hasMax := !math.IsNaN(maxValue)
+	if hasMax && maxValue <= 0 {
+		return nil, fmt.Errorf("%s: %w: maxValue %g", e.Target(), parser.ErrInvalidArgumentValue, maxValue)
+	}
+
+	loc := interfaces.TimeZone(ctx)
+
+	return helper.ForEachSeriesDo(ctx, e, from, until, values, func(a *types.MetricData, r *types.MetricData) *types.MetricData {
+		switch {
+		case hasMax && reset > 0:
+			r.Name = fmt.Sprintf("integral(%s,'%s',%g)", a.Name, resetInterval, maxValue)
+		case hasMax:
+			r.Name = fmt.Sprintf("integral(%s,maxValue=%g)", a.Name, maxValue)
+		case reset > 0:
+			r.Name = fmt.Sprintf("integral(%s,'%s')", a.Name, resetInterval)
+		}
+
+		current := 0.0
+		var period int64
+		for i, v := range a.Values {
+			if reset > 0 {
+				// periods are aligned to the request time zone, so that a
+				// reset interval of 1d starts over at local midnight
+				t := time.Unix(int64(a.StartTime)+int64(i)*int64(a.StepTime), 0)
+				_, offset := t.In(loc).Zone()
+				p := floorDiv(t.Unix()+int64(offset), reset)
+				if i > 0 && p != period {
+					current = 0
+				}
+				period = p
+			}
+			if a.IsAbsent.Get(i) {
+				r.Values[i] = 0
+				r.IsAbsent.Set(i, true)
+				continue
+			}
+			if hasMax && v < 0 {
+				// the delta of a counter that can hold up to maxValue and
+				// wrapped around to zero, as nonNegativeDerivative counts it
+				v += maxValue + 1
+			}
+			current += v
+			r.Values[i] = current
+		}
+		return r
+	}, getTargetData)
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if a%b != 0 && (a < 0) != (b < 0) {
+		q--
+	}
+	return q
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *integral) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"integral": {
+			Description: "This will show the sum over time, sort of like a continuous addition function.\nUseful for finding totals or trends in metrics that are collected per minute.\n\nExample:\n\n.. code-block:: none\n\n  &target=integral(company.sales.perMinute)\n\nThis would start at zero on the left side of the graph, adding the sales each\nminute, and show the total sales for the time period selected at the right\nside, (time now, or the time specified by '&until=').",
+			Function:    "integral(seriesList, resetInterval=None, maxValue=None)",
+			Group:       "Transform",
+			Module:      "graphite.render.functions",
+			Name:        "integral",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name: "resetInterval",
+					Type: types.Interval,
+				},
+				{
+					Name: "maxValue",
+					Type: types.Float,
+				},
+			},
+		},
+	}
+}
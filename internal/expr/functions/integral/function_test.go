@@ -0,0 +1,131 @@
+package integral
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestFunction(t *testing.T) {
+	tests := []th.EvalTestItem{
+		{
+			Target: "integral(metric1)",
+			M: map[parser.MetricRequest][]*types.MetricData{
+				{Metric: "metric1", From: 0, Until: 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6}, 2, 0),
+				},
+			},
+			Want: []*types.MetricData{
+				types.MakeMetricData("integral(metric1)", []float64{1, 3, 6, 10, 15, 21}, 2, 0),
+			},
+		},
+		{
+			Target: "integral(metric1,'6s')",
+			M: map[parser.MetricRequest][]*types.MetricData{
+				{Metric: "metric1", From: 0, Until: 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6}, 2, 0),
+				},
+			},
+			Want: []*types.MetricData{
+				types.MakeMetricData("integral(metric1,'6s')", []float64{1, 3, 6, 4, 9, 15}, 2, 0),
+			},
+		},
+		{
+			Target: "integral(metric1,resetInterval='6s')",
+			M: map[parser.MetricRequest][]*types.MetricData{
+				{Metric: "metric1", From: 0, Until: 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6}, 2, 4),
+				},
+			},
+			Want: []*types.MetricData{
+				types.MakeMetricData("integral(metric1,'6s')", []float64{1, 2, 5, 9, 5, 11}, 2, 4),
+			},
+		},
+		{
+			Target: "integral(metric1,maxValue=9)",
+			M: map[parser.MetricRequest][]*types.MetricData{
+				{Metric: "metric1", From: 0, Until: 1}: {
+					// the deltas of a counter going 0, 3, 7, 9, 1, 3, which
+					// wraps from 9 to 1, a delta of 2 rather than -8
+					types.MakeMetricData("metric1", []float64{3, 4, 2, -8, 2}, 2, 0),
+				},
+			},
+			Want: []*types.MetricData{
+				types.MakeMetricData("integral(metric1,maxValue=9)", []float64{3, 7, 9, 11, 13}, 2, 0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		testName := tt.Target
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestIntegralResetInTimeZone(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// hourly points from 22:00 to 02:00 in Amsterdam
+	start := int32(time.Date(2023, time.July, 2, 22, 0, 0, 0, amsterdam).Unix())
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "metric1", From: 0, Until: 1}: {
+			types.MakeMetricData("metric1", []float64{1, 1, 1, 1, 1}, 3600, start),
+		},
+	}
+	exp, _, err := parser.ParseExpr("integral(metric1,'1d')")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		loc  *time.Location
+		want []float64
+	}{
+		{amsterdam, []float64{1, 2, 1, 2, 3}},
+		{time.UTC, []float64{1, 2, 3, 4, 1}},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.loc.String(), func(t *testing.T) {
+			ctx := interfaces.WithTimeZone(context.Background(), tst.loc)
+			got, err := metadata.GetEvaluator().EvalExpr(ctx, exp, 0, 1, values, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 {
+				t.Fatalf("expected one series, got %d", len(got))
+			}
+			for i, v := range tst.want {
+				if got[0].Values[i] != v {
+					t.Errorf("expected %v, got %v", tst.want, got[0].Values)
+					break
+				}
+			}
+		})
+	}
+}
@@ -3,10 +3,10 @@ package invert
 import (
 	"context"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type invert struct {
@@ -31,9 +31,9 @@ func New(configFile string) []interfaces.FunctionMetadata {
 func (f *invert) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
 	return helper.ForEachSeriesDo(ctx, e, from, until, values, func(a *types.MetricData, r *types.MetricData) *types.MetricData {
 		for i, v := range a.Values {
-			if a.IsAbsent[i] || v == 0 {
+			if a.IsAbsent.Get(i) || v == 0 {
 				r.Values[i] = 0
-				r.IsAbsent[i] = true
+				r.IsAbsent.Set(i, true)
 				continue
 			}
 			r.Values[i] = 1 / v
@@ -3,10 +3,10 @@ package isNotNull
 import (
 	"context"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type isNotNull struct {
@@ -34,8 +34,8 @@ func (f *isNotNull) Do(ctx context.Context, e parser.Expr, from, until int32, va
 
 	return helper.ForEachSeriesDo(ctx, e, from, until, values, func(a *types.MetricData, r *types.MetricData) *types.MetricData {
 		for i := range a.Values {
-			r.IsAbsent[i] = false
-			if a.IsAbsent[i] {
+			r.IsAbsent.Set(i, false)
+			if a.IsAbsent.Get(i) {
 				r.Values[i] = 0
 			} else {
 				r.Values[i] = 1
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type keepLastValue struct {
@@ -58,19 +58,19 @@ func (f *keepLastValue) Do(ctx context.Context, e parser.Expr, from, until int32
 		r := *a
 		r.Name = name
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 
 		prev := math.NaN()
 		missing := 0
 
 		for i, v := range a.Values {
-			if a.IsAbsent[i] {
+			if a.IsAbsent.Get(i) {
 
 				if (keep < 0 || missing < keep) && !math.IsNaN(prev) {
 					r.Values[i] = prev
 					missing++
 				} else {
-					r.IsAbsent[i] = true
+					r.IsAbsent.Set(i, true)
 				}
 
 				continue
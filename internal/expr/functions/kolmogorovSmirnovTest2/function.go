@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	"github.com/dgryski/go-onlinestats"
 )
 
@@ -61,7 +61,7 @@ func (f *kolmogorovSmirnovTest2) Do(ctx context.Context, e parser.Expr, from, un
 	r := *a1
 	r.Name = fmt.Sprintf("kolmogorovSmirnovTest2(%s,%s,%d)", a1.Name, a2.Name, windowSize)
 	r.Values = make([]float64, len(a1.Values))
-	r.IsAbsent = make([]bool, len(a1.Values))
+	r.IsAbsent = types.NewAbsence(len(a1.Values))
 	r.StartTime = from
 	r.StopTime = until
 
@@ -70,7 +70,7 @@ func (f *kolmogorovSmirnovTest2) Do(ctx context.Context, e parser.Expr, from, un
 
 	for i, v1 := range a1.Values {
 		v2 := a2.Values[i]
-		if a1.IsAbsent[i] || a2.IsAbsent[i] {
+		if a1.IsAbsent.Get(i) || a2.IsAbsent.Get(i) {
 			// make sure missing values are ignored
 			v1 = math.NaN()
 			v2 = math.NaN()
@@ -85,7 +85,7 @@ func (f *kolmogorovSmirnovTest2) Do(ctx context.Context, e parser.Expr, from, un
 			r.Values[i] = onlinestats.KS(d1, d2)
 		} else {
 			r.Values[i] = 0
-			r.IsAbsent[i] = true
+			r.IsAbsent.Set(i, true)
 		}
 	}
 	return []*types.MetricData{&r}, nil
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type logarithm struct {
@@ -61,12 +61,12 @@ func (f *logarithm) Do(ctx context.Context, e parser.Expr, from, until int32, va
 		r := *a
 		r.Name = name
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 
 		for i, v := range a.Values {
-			if a.IsAbsent[i] {
+			if a.IsAbsent.Get(i) {
 				r.Values[i] = 0
-				r.IsAbsent[i] = true
+				r.IsAbsent.Set(i, true)
 				continue
 			}
 			r.Values[i] = math.Log(v) / baseLog
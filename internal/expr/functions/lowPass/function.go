@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type lowPass struct {
@@ -46,14 +46,14 @@ func (f *lowPass) Do(ctx context.Context, e parser.Expr, from, until int32, valu
 		r := *a
 		r.Name = name
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 		lowCut := int((cutPercent / 200) * float64(len(a.Values)))
 		highCut := len(a.Values) - lowCut
 		for i, v := range a.Values {
 			if i < lowCut || i >= highCut {
 				r.Values[i] = v
 			} else {
-				r.IsAbsent[i] = true
+				r.IsAbsent.Set(i, true)
 			}
 		}
 
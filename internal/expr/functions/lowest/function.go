@@ -4,10 +4,10 @@ import (
 	"container/heap"
 	"context"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type lowest struct {
@@ -51,7 +51,7 @@ func (f *lowest) Do(ctx context.Context, e parser.Expr, from, until int32, value
 
 	var mh types.MetricHeap
 
-	var compute func([]float64, []bool) float64
+	var compute func([]float64, types.Absence) float64
 
 	switch e.Target() {
 	case "lowestAverage":
@@ -0,0 +1,274 @@
+package macro
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Param is a parameter of a macro, referred to as $name in its expression
+type Param struct {
+	Name string `yaml:"name"`
+	// Default is the text of the argument if it is not given. Parameters
+	// without a default are required.
+	Default string `yaml:"default"`
+	// Type is the graphite-web type of the parameter, e.g. seriesList or
+	// float, for the function description. Defaults to seriesList.
+	Type string `yaml:"type"`
+}
+
+// Macro is a function defined in config by an expression, in which the
+// parameters are replaced by the arguments of each call
+type Macro struct {
+	Expression  string  `yaml:"expression"`
+	Params      []Param `yaml:"params"`
+	Description string  `yaml:"description"`
+	// Group is the group of the function description. Defaults to Macro.
+	Group string `yaml:"group"`
+}
+
+// Config is the config file of the macros
+type Config struct {
+	Macros map[string]Macro `yaml:"macros"`
+}
+
+type macro struct {
+	interfaces.FunctionBase
+
+	name string
+	def  Macro
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Last
+}
+
+// New registers the macros of configFile. A broken config registers none,
+// Load tells why.
+func New(configFile string) []interfaces.FunctionMetadata {
+	if configFile == "" {
+		return nil
+	}
+	macros, err := Load(configFile)
+	if err != nil {
+		return nil
+	}
+
+	res := make([]interfaces.FunctionMetadata, 0, len(macros))
+	for name, def := range macros {
+		res = append(res, interfaces.FunctionMetadata{Name: name, F: newMacro(name, def)})
+	}
+	return res
+}
+
+var identifier = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// Load reads and checks the macros of configFile
+func Load(configFile string) (map[string]Macro, error) {
+	b, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.UnmarshalStrict(b, &config); err != nil {
+		return nil, err
+	}
+
+	calls := make(map[string][]string, len(config.Macros))
+	for name, def := range config.Macros {
+		if !identifier.MatchString(name) {
+			return nil, fmt.Errorf("macro %q: invalid name", name)
+		}
+
+		seen := make(map[string]bool, len(def.Params))
+		placeholders := make(map[string]string, len(def.Params))
+		for i, p := range def.Params {
+			if !identifier.MatchString(p.Name) {
+				return nil, fmt.Errorf("macro %s: invalid name of parameter %d: %q", name, i, p.Name)
+			}
+			if seen[p.Name] {
+				return nil, fmt.Errorf("macro %s: duplicate parameter %s", name, p.Name)
+			}
+			seen[p.Name] = true
+			if p.Type != "" {
+				var t types.FunctionType
+				if err := t.UnmarshalJSON([]byte(p.Type)); err != nil {
+					return nil, fmt.Errorf("macro %s: parameter %s: %w", name, p.Name, err)
+				}
+			}
+			placeholders[p.Name] = "x"
+		}
+
+		exp, _, err := parser.ParseExpr(replacer(placeholders).Replace(def.Expression))
+		if err != nil {
+			return nil, fmt.Errorf("macro %s: invalid expression %q: %w", name, def.Expression, err)
+		}
+		calls[name] = functions(exp)
+	}
+
+	// A macro calling itself, even through other macros, would never end
+	for name := range config.Macros {
+		if cycle := findCycle(name, calls, nil); cycle != nil {
+			return nil, fmt.Errorf("macro %s: calls itself through %s", name, strings.Join(cycle, " -> "))
+		}
+	}
+
+	return config.Macros, nil
+}
+
+// functions returns the names of the functions called in exp
+func functions(exp parser.Expr) []string {
+	if !exp.IsFunc() {
+		return nil
+	}
+
+	names := []string{exp.Target()}
+	for _, a := range exp.Args() {
+		names = append(names, functions(a)...)
+	}
+	for _, a := range exp.NamedArgs() {
+		names = append(names, functions(a)...)
+	}
+	return names
+}
+
+// findCycle returns the macros that name calls back to itself through, if
+// any
+func findCycle(name string, calls map[string][]string, path []string) []string {
+	path = append(path, name)
+	for _, callee := range calls[name] {
+		if callee == path[0] {
+			return append(path, callee)
+		}
+		if _, ok := calls[callee]; !ok {
+			continue
+		}
+		visited := false
+		for _, p := range path {
+			visited = visited || p == callee
+		}
+		if visited {
+			continue
+		}
+		if cycle := findCycle(callee, calls, path); cycle != nil {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// replacer replaces $name by args[name] in expressions. Longer names go
+// first, so that $total is not taken for $tot followed by al.
+func replacer(args map[string]string) *strings.Replacer {
+	names := make([]string, 0, len(args))
+	for name := range args {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+
+	oldnew := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		oldnew = append(oldnew, "$"+name, args[name])
+	}
+	return strings.NewReplacer(oldnew...)
+}
+
+func newMacro(name string, def Macro) *macro {
+	return &macro{
+		name: name,
+		def:  def,
+	}
+}
+
+// expand returns the expression of the macro for the call e
+func (f *macro) expand(e parser.Expr) (parser.Expr, error) {
+	if len(e.Args()) > len(f.def.Params) {
+		return nil, fmt.Errorf("%s: %w: takes at most %d arguments, got %d", e.Target(), parser.ErrInvalidArgumentValue, len(f.def.Params), len(e.Args()))
+	}
+
+	args := make(map[string]string, len(f.def.Params))
+	for i, p := range f.def.Params {
+		switch {
+		case i < len(e.Args()):
+			args[p.Name] = e.Args()[i].ToString()
+		case e.NamedArgs()[p.Name] != nil:
+			args[p.Name] = e.NamedArgs()[p.Name].ToString()
+		case p.Default != "":
+			args[p.Name] = p.Default
+		default:
+			return nil, fmt.Errorf("%s: %w: %s", e.Target(), parser.ErrMissingArgument, p.Name)
+		}
+	}
+
+	exp, _, err := parser.ParseExpr(replacer(args).Replace(f.def.Expression))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.Target(), err)
+	}
+	return exp, nil
+}
+
+// Do evaluates the expression of the macro, with the arguments of e
+func (f *macro) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	exp, err := f.expand(e)
+	if err != nil {
+		return nil, err
+	}
+
+	// retrieve the metrics of the expression that are not arguments
+	err, _ = getTargetData(ctx, exp, from, until, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.Evaluator.EvalExpr(ctx, exp, from, until, values, getTargetData)
+}
+
+// Description describes the macro from its config
+func (f *macro) Description() map[string]types.FunctionDescription {
+	group := f.def.Group
+	if group == "" {
+		group = "Macro"
+	}
+
+	signature := make([]string, 0, len(f.def.Params))
+	params := make([]types.FunctionParam, 0, len(f.def.Params))
+	for _, p := range f.def.Params {
+		param := types.FunctionParam{
+			Name:     p.Name,
+			Required: p.Default == "",
+			Type:     types.SeriesList,
+		}
+		if p.Type != "" {
+			_ = param.Type.UnmarshalJSON([]byte(p.Type))
+		}
+		if p.Default != "" {
+			param.Default = types.NewSuggestion(p.Default)
+			signature = append(signature, p.Name+"="+p.Default)
+		} else {
+			signature = append(signature, p.Name)
+		}
+		params = append(params, param)
+	}
+
+	return map[string]types.FunctionDescription{
+		f.name: {
+			Description: f.def.Description,
+			Function:    fmt.Sprintf("%s(%s)", f.name, strings.Join(signature, ", ")),
+			Group:       group,
+			Module:      "carbonapi.macros",
+			Name:        f.name,
+			Params:      params,
+		},
+	}
+}
@@ -0,0 +1,133 @@
+package macro
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/functions/divideSeries"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/scale"
+	"github.com/bookingcom/carbonapi/internal/expr/functions/sum"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	for _, md := range [][]interfaces.FunctionMetadata{
+		New("../../../../testdata/macros/macros.yaml"),
+		divideSeries.New(""),
+		scale.New(""),
+		sum.New(""),
+	} {
+		for _, m := range md {
+			metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+		}
+	}
+	evaluator := th.EvaluatorFromFuncWithMetadata(metadata.FunctionMD.Functions)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+}
+
+func TestMacro(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+	metrics := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "errors", From: 0, Until: 1}: {
+			types.MakeMetricData("errors", []float64{1, 2, 3}, 1, now32),
+		},
+		{Metric: "total", From: 0, Until: 1}: {
+			types.MakeMetricData("total", []float64{10, 10, 10}, 1, now32),
+		},
+	}
+
+	tests := []th.EvalTestItem{
+		{
+			Target: "errorRatio(errors,total)",
+			M:      metrics,
+			Want: []*types.MetricData{
+				types.MakeMetricData("divideSeries(sumSeries(errors),sumSeries(total))", []float64{0.1, 0.2, 0.3}, 1, now32),
+			},
+		},
+		{
+			Target: "errorRatio(errors,total=total)",
+			M:      metrics,
+			Want: []*types.MetricData{
+				types.MakeMetricData("divideSeries(sumSeries(errors),sumSeries(total))", []float64{0.1, 0.2, 0.3}, 1, now32),
+			},
+		},
+		{
+			Target: "scaled(errors)",
+			M:      metrics,
+			Want: []*types.MetricData{
+				types.MakeMetricData("scale(errors,2)", []float64{2, 4, 6}, 1, now32),
+			},
+		},
+		{
+			Target: "scaledErrorRatio(errors,total)",
+			M:      metrics,
+			Want: []*types.MetricData{
+				types.MakeMetricData("scale(divideSeries(sumSeries(errors),sumSeries(total)),100)", []float64{10, 20, 30}, 1, now32),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		testName := tt.Target
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestMacroArguments(t *testing.T) {
+	f := newMacro("errorRatio", Macro{
+		Expression: "divideSeries(sumSeries($errors),sumSeries($total))",
+		Params:     []Param{{Name: "errors"}, {Name: "total"}},
+	})
+
+	for _, target := range []string{"errorRatio(errors)", "errorRatio(errors,total,other)"} {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.expand(exp); err == nil {
+			t.Errorf("expected an error for %s", target)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	macros, err := Load("../../../../testdata/macros/macros.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(macros) != 3 {
+		t.Errorf("expected 3 macros, got %d", len(macros))
+	}
+
+	for _, file := range []string{"cycle.yaml", "invalid_expression.yaml", "invalid_type.yaml", "missing.yaml"} {
+		if _, err := Load("../../../../testdata/macros/" + file); err == nil {
+			t.Errorf("expected an error for %s", file)
+		}
+	}
+}
+
+func TestDescription(t *testing.T) {
+	f := newMacro("scaled", Macro{
+		Expression: "scale($seriesList,$factor)",
+		Params:     []Param{{Name: "seriesList"}, {Name: "factor", Type: "float", Default: "2"}},
+	})
+
+	d := f.Description()["scaled"]
+	if d.Function != "scaled(seriesList, factor=2)" || d.Group != "Macro" {
+		t.Errorf("unexpected description %+v", d)
+	}
+	if len(d.Params) != 2 || !d.Params[0].Required || d.Params[1].Required || d.Params[1].Type != types.Float {
+		t.Errorf("unexpected parameters %+v", d.Params)
+	}
+}
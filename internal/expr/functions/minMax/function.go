@@ -3,12 +3,11 @@ package minMax
 import (
 	"context"
 	"fmt"
-	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type minMax struct {
@@ -42,25 +41,9 @@ func (f *minMax) Do(ctx context.Context, e parser.Expr, from, until int32, value
 
 	switch e.Target() {
 	case "maxSeries", "max":
-		return helper.AggregateSeries(name, args, false, false, func(values []float64) (float64, bool) {
-			max := math.Inf(-1)
-			for _, value := range values {
-				if value > max {
-					max = value
-				}
-			}
-			return max, false
-		})
+		return helper.AggregateSeriesByName(name, args, "max", helper.DefaultXFilesFactor())
 	case "minSeries", "min":
-		return helper.AggregateSeries(name, args, false, false, func(values []float64) (float64, bool) {
-			min := math.Inf(1)
-			for _, value := range values {
-				if value < min {
-					min = value
-				}
-			}
-			return min, false
-		})
+		return helper.AggregateSeriesByName(name, args, "min", helper.DefaultXFilesFactor())
 	}
 
 	return nil, fmt.Errorf("%w: unsupported target: %v", parser.ErrInvalidArgumentValue, e.Target())
@@ -6,10 +6,10 @@ import (
 	"math"
 	"strconv"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type moving struct {
@@ -30,7 +30,7 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-// movingXyz(seriesList, windowSize)
+// movingXyz(seriesList, windowSize, xFilesFactor=None)
 func (f *moving) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
 	var n int
 	var err error
@@ -56,6 +56,11 @@ func (f *moving) Do(ctx context.Context, e parser.Expr, from, until int32, value
 		return nil, err
 	}
 
+	xFilesFactor, err := helper.GetXFilesFactor(e, 2)
+	if err != nil {
+		return nil, err
+	}
+
 	windowSize := n
 
 	start := from
@@ -82,48 +87,51 @@ func (f *moving) Do(ctx context.Context, e parser.Expr, from, until int32, value
 	}
 
 	for _, a := range arg {
-		w := &types.Windowed{Data: make([]float64, windowSize)}
-
 		r := *a
 		r.Name = fmt.Sprintf("%s(%s,%s)", e.Target(), a.Name, argstr)
 		r.Values = make([]float64, len(a.Values)-offset)
-		r.IsAbsent = make([]bool, len(a.Values)-offset)
+		r.IsAbsent = types.NewAbsence(len(a.Values) - offset)
 		r.StartTime = from
 		r.StopTime = until
 
+		if windowSize == 0 {
+			// Fix error on long time ranges (greater than 30 days), sampling to 10 min
+			for i := range r.Values {
+				r.Values[i] = math.NaN()
+			}
+			result = append(result, &r)
+			continue
+		}
+
+		w := helper.NewMovingWindow(windowSize)
 		for i, v := range a.Values {
-			if windowSize == 0 {
-				// Fix error on long time ranges (greater than 30 days), sampling to 10 min
-				r.Values = make([]float64, len(a.Values))
-				for i := range a.Values {
-					r.Values[i] = math.NaN()
-				}
-			} else {
-				if a.IsAbsent[i] {
-					// make sure missing values are ignored
-					v = math.NaN()
-				}
+			if !helper.Known(v, a.IsAbsent.Get(i)) {
+				// make sure missing values are ignored
+				v = math.NaN()
+			}
 
-				if ridx := i - offset; ridx >= 0 {
-					switch e.Target() {
-					case "movingAverage":
-						r.Values[ridx] = w.Mean()
-					case "movingSum":
-						r.Values[ridx] = w.Sum()
-						//TODO(cldellow): consider a linear time min/max-heap for these,
-						// e.g. http://stackoverflow.com/questions/8905525/computing-a-moving-maximum/8905575#8905575
-					case "movingMin":
-						r.Values[ridx] = w.Min()
-					case "movingMax":
-						r.Values[ridx] = w.Max()
-					}
-					if i < windowSize || math.IsNaN(r.Values[ridx]) {
-						r.Values[ridx] = 0
-						r.IsAbsent[ridx] = true
-					}
+			if ridx := i - offset; ridx >= 0 && w.Full() && w.Len() == 0 {
+				var absent bool
+				r.Values[ridx], absent = helper.EmptyAggregation()
+				r.IsAbsent.Set(ridx, absent)
+			} else if ridx >= 0 {
+				switch e.Target() {
+				case "movingAverage":
+					r.Values[ridx] = w.Mean()
+				case "movingSum":
+					r.Values[ridx] = w.Sum()
+				case "movingMin":
+					r.Values[ridx] = w.Min()
+				case "movingMax":
+					r.Values[ridx] = w.Max()
+				}
+				// windows with less than xFilesFactor of their points known are absent
+				if !w.Full() || math.IsNaN(r.Values[ridx]) || !helper.XFilesFactor(w.Len(), windowSize, xFilesFactor) {
+					r.Values[ridx] = 0
+					r.IsAbsent.Set(ridx, true)
 				}
-				w.Push(v)
 			}
+			w.Push(v)
 		}
 		result = append(result, &r)
 	}
@@ -7,10 +7,10 @@ import (
 	"strconv"
 
 	"github.com/JaderDias/movingmedian"
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type movingMedian struct {
@@ -86,14 +86,14 @@ func (f *movingMedian) Do(ctx context.Context, e parser.Expr, from, until int32,
 		r := *a
 		r.Name = fmt.Sprintf("movingMedian(%s,%s)", a.Name, argstr)
 		r.Values = make([]float64, len(a.Values)-offset)
-		r.IsAbsent = make([]bool, len(a.Values)-offset)
+		r.IsAbsent = types.NewAbsence(len(a.Values) - offset)
 		r.StartTime = from
 		r.StopTime = until
 
 		data := movingmedian.NewMovingMedian(windowSize)
 
 		for i, v := range a.Values {
-			if a.IsAbsent[i] {
+			if a.IsAbsent.Get(i) {
 				data.Push(math.NaN())
 			} else {
 				data.Push(v)
@@ -104,7 +104,7 @@ func (f *movingMedian) Do(ctx context.Context, e parser.Expr, from, until int32,
 					r.Values[ridx] = data.Median()
 				}
 				if math.IsNaN(r.Values[ridx]) {
-					r.IsAbsent[ridx] = true
+					r.IsAbsent.Set(ridx, true)
 				}
 			}
 		}
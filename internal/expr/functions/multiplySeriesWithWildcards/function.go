@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type multiplySeriesWithWildcards struct {
@@ -70,39 +70,18 @@ func (f *multiplySeriesWithWildcards) Do(ctx context.Context, e parser.Expr, fro
 		groups[node] = append(groups[node], a)
 	}
 
-	for _, series := range nodeList {
-		args := groups[series]
-		r := *args[0]
-		r.Name = fmt.Sprintf("multiplySeriesWithWildcards(%s)", series)
-		r.Values = make([]float64, len(args[0].Values))
-		r.IsAbsent = make([]bool, len(args[0].Values))
-
-		atLeastOne := make([]bool, len(args[0].Values))
-		hasVal := make([]bool, len(args[0].Values))
-
-		for _, arg := range args {
-			for i, v := range arg.Values {
-				if arg.IsAbsent[i] {
-					continue
-				}
-
-				atLeastOne[i] = true
-				if !hasVal[i] {
-					r.Values[i] = v
-					hasVal[i] = true
-				} else {
-					r.Values[i] *= v
-				}
-			}
-		}
+	aggregate, err := helper.GetAggregateFunc("multiply")
+	if err != nil {
+		return nil, err
+	}
 
-		for i, v := range atLeastOne {
-			if !v {
-				r.IsAbsent[i] = true
-			}
+	for _, series := range nodeList {
+		r, err := helper.AggregateSeries(fmt.Sprintf("multiplySeriesWithWildcards(%s)", series), groups[series], false, false, aggregate)
+		if err != nil {
+			return nil, err
 		}
 
-		results = append(results, &r)
+		results = append(results, r...)
 	}
 	return results, nil
 }
@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type nPercentile struct {
@@ -44,11 +44,11 @@ func (f *nPercentile) Do(ctx context.Context, e parser.Expr, from, until int32,
 		r := *a
 		r.Name = fmt.Sprintf("nPercentile(%s,%g)", a.Name, percent)
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 
 		var values []float64
-		for i, v := range a.IsAbsent {
-			if !v {
+		for i := range a.Values {
+			if !a.IsAbsent.Get(i) {
 				values = append(values, a.Values[i])
 			}
 		}
@@ -56,7 +56,7 @@ func (f *nPercentile) Do(ctx context.Context, e parser.Expr, from, until int32,
 		value, absent := helper.Percentile(values, percent, true)
 		for i := range r.Values {
 			r.Values[i] = value
-			r.IsAbsent[i] = absent
+			r.IsAbsent.Set(i, absent)
 		}
 
 		results = append(results, &r)
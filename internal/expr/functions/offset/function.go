@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type offset struct {
@@ -44,12 +44,12 @@ func (f *offset) Do(ctx context.Context, e parser.Expr, from, until int32, value
 		r := *a
 		r.Name = fmt.Sprintf("offset(%s,%g)", a.Name, factor)
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 
 		for i, v := range a.Values {
-			if a.IsAbsent[i] {
+			if a.IsAbsent.Get(i) {
 				r.Values[i] = 0
-				r.IsAbsent[i] = true
+				r.IsAbsent.Set(i, true)
 				continue
 			}
 			r.Values[i] = v + factor
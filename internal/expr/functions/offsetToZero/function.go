@@ -4,10 +4,10 @@ import (
 	"context"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type offsetToZero struct {
@@ -33,14 +33,14 @@ func (f *offsetToZero) Do(ctx context.Context, e parser.Expr, from, until int32,
 	return helper.ForEachSeriesDo(ctx, e, from, until, values, func(a *types.MetricData, r *types.MetricData) *types.MetricData {
 		minimum := math.Inf(1)
 		for i, v := range a.Values {
-			if !a.IsAbsent[i] && v < minimum {
+			if !a.IsAbsent.Get(i) && v < minimum {
 				minimum = v
 			}
 		}
 		for i, v := range a.Values {
-			if a.IsAbsent[i] {
+			if a.IsAbsent.Get(i) {
 				r.Values[i] = 0
-				r.IsAbsent[i] = true
+				r.IsAbsent.Set(i, true)
 				continue
 			}
 			r.Values[i] = v - minimum
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	"github.com/dgryski/go-onlinestats"
 )
 
@@ -60,13 +60,13 @@ func (f *pearson) Do(ctx context.Context, e parser.Expr, from, until int32, valu
 	r := *a1
 	r.Name = fmt.Sprintf("pearson(%s,%s,%d)", a1.Name, a2.Name, windowSize)
 	r.Values = make([]float64, len(a1.Values))
-	r.IsAbsent = make([]bool, len(a1.Values))
+	r.IsAbsent = types.NewAbsence(len(a1.Values))
 	r.StartTime = from
 	r.StopTime = until
 
 	for i, v1 := range a1.Values {
 		v2 := a2.Values[i]
-		if a1.IsAbsent[i] || a2.IsAbsent[i] {
+		if a1.IsAbsent.Get(i) || a2.IsAbsent.Get(i) {
 			// ignore if either is missing
 			v1 = math.NaN()
 			v2 = math.NaN()
@@ -77,7 +77,7 @@ func (f *pearson) Do(ctx context.Context, e parser.Expr, from, until int32, valu
 			r.Values[i] = onlinestats.Pearson(w1.Data, w2.Data)
 		} else {
 			r.Values[i] = 0
-			r.IsAbsent[i] = true
+			r.IsAbsent.Set(i, true)
 		}
 	}
 
@@ -5,10 +5,10 @@ import (
 	"context"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	"github.com/dgryski/go-onlinestats"
 )
 
@@ -67,8 +67,8 @@ func (f *pearsonClosest) Do(ctx context.Context, e parser.Expr, from, until int3
 
 	refValues := make([]float64, len(ref[0].Values))
 	copy(refValues, ref[0].Values)
-	for i, v := range ref[0].IsAbsent {
-		if v {
+	for i := range ref[0].Values {
+		if ref[0].IsAbsent.Get(i) {
 			refValues[i] = math.NaN()
 		}
 	}
@@ -82,8 +82,8 @@ func (f *pearsonClosest) Do(ctx context.Context, e parser.Expr, from, until int3
 			// Pearson will panic if arrays are not equal length; skip
 			continue
 		}
-		for i, v := range a.IsAbsent {
-			if v {
+		for i := range a.Values {
+			if a.IsAbsent.Get(i) {
 				compareValues[i] = math.NaN()
 			}
 		}
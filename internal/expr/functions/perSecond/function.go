@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type perSecond struct {
@@ -79,12 +79,12 @@ func (f *perSecond) Do(ctx context.Context, e parser.Expr, from, until int32, va
 		r := *a
 		r.Name = name
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 
 		prev := a.Values[0]
 		for i, v := range a.Values {
-			if i == 0 || a.IsAbsent[i] || a.IsAbsent[i-1] {
-				r.IsAbsent[i] = true
+			if i == 0 || a.IsAbsent.Get(i) || a.IsAbsent.Get(i-1) {
+				r.IsAbsent.Set(i, true)
 				prev = v
 				continue
 			}
@@ -97,7 +97,7 @@ func (f *perSecond) Do(ctx context.Context, e parser.Expr, from, until int32, va
 				r.Values[i] = (v - minValue) / float64(a.StepTime)
 			} else {
 				r.Values[i] = 0
-				r.IsAbsent[i] = true
+				r.IsAbsent.Set(i, true)
 			}
 			prev = v
 		}
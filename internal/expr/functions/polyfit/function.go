@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 
 	"gonum.org/v1/gonum/mat"
 )
@@ -68,20 +68,18 @@ func (f *polyfit) Do(ctx context.Context, e parser.Expr, from, until int32, valu
 		}
 		// Extending slice by "offset" so our graph slides into future!
 		r.Values = make([]float64, len(a.Values)+int(offs/r.StepTime))
-		r.IsAbsent = make([]bool, len(r.Values))
+		r.IsAbsent = types.NewAbsence(len(r.Values))
 		r.StopTime = a.StopTime + offs
 
 		// Removing absent values from original dataset
 		nonNulls := make([]float64, 0)
 		for i := range a.Values {
-			if !a.IsAbsent[i] {
+			if !a.IsAbsent.Get(i) {
 				nonNulls = append(nonNulls, a.Values[i])
 			}
 		}
 		if len(nonNulls) < 2 {
-			for i := range r.IsAbsent {
-				r.IsAbsent[i] = true
-			}
+			r.IsAbsent = types.AllAbsent(r.IsAbsent.Len())
 			results = append(results, &r)
 			continue
 		}
@@ -4,9 +4,9 @@ import (
 	"context"
 	"math/rand"
 
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -40,7 +40,7 @@ func (f *randomWalk) Do(ctx context.Context, e parser.Expr, from, until int32, v
 	r := types.MetricData{Metric: dataTypes.Metric{
 		Name:      name,
 		Values:    make([]float64, size),
-		IsAbsent:  make([]bool, size),
+		IsAbsent:  types.NewAbsence(int(size)),
 		StepTime:  1,
 		StartTime: from,
 		StopTime:  until,
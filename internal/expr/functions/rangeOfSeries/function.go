@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type rangeOfSeries struct {
@@ -36,16 +36,19 @@ func (f *rangeOfSeries) Do(ctx context.Context, e parser.Expr, from, until int32
 		return nil, err
 	}
 
-	r := *series[0]
-	r.Name = fmt.Sprintf("%s(%s)", e.Target(), e.RawArgs())
-	r.Values = make([]float64, len(series[0].Values))
-	r.IsAbsent = make([]bool, len(series[0].Values))
+	normalized, start, end, step, err := helper.Normalize(series)
+	if err != nil {
+		return nil, err
+	}
+
+	length := int((end - start) / step)
+	r := helper.NewCombined(fmt.Sprintf("%s(%s)", e.Target(), e.RawArgs()), make([]float64, length), types.NewAbsence(length), step, start, series)
 
-	for i := range series[0].Values {
+	for i := range r.Values {
 		var min, max float64
 		count := 0
-		for _, s := range series {
-			if s.IsAbsent[i] {
+		for _, s := range normalized {
+			if i >= s.IsAbsent.Len() || s.IsAbsent.Get(i) {
 				continue
 			}
 
@@ -63,10 +66,10 @@ func (f *rangeOfSeries) Do(ctx context.Context, e parser.Expr, from, until int32
 		if count >= 2 {
 			r.Values[i] = max - min
 		} else {
-			r.IsAbsent[i] = true
+			r.IsAbsent.Set(i, true)
 		}
 	}
-	return []*types.MetricData{&r}, err
+	return []*types.MetricData{r}, nil
 }
 
 // Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
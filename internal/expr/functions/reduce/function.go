@@ -3,10 +3,10 @@ package reduce
 import (
 	"context"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 
 	"strings"
 )
@@ -84,8 +84,8 @@ func (f *reduce) Do(ctx context.Context, e parser.Expr, from, until int32, value
 		reduceGroups[aliasName][reduceNodeKey] = series
 		valueKey := parser.MetricRequest{
 			Metric: series.Name,
-			From:   from,
-			Until:  until,
+			From:   int64(from),
+			Until:  int64(until),
 		}
 		reducedValues[valueKey] = append(reducedValues[valueKey], series)
 	}
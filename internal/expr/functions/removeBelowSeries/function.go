@@ -6,10 +6,10 @@ import (
 	"math"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type removeBelowSeries struct {
@@ -23,14 +23,14 @@ func GetOrder() interfaces.Order {
 func New(configFile string) []interfaces.FunctionMetadata {
 	res := make([]interfaces.FunctionMetadata, 0)
 	f := &removeBelowSeries{}
-	functions := []string{"removeBelowValue", "removeAboveValue", "removeBelowPercentile", "removeAbovePercentile"}
+	functions := []string{"removeBelowValue", "removeAboveValue", "removeBelowPercentile", "removeAbovePercentile", "removeBetweenPercentile"}
 	for _, n := range functions {
 		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
 	}
 	return res
 }
 
-// removeBelowValue(seriesLists, n), removeAboveValue(seriesLists, n), removeBelowPercentile(seriesLists, percent), removeAbovePercentile(seriesLists, percent)
+// removeBelowValue(seriesLists, n), removeAboveValue(seriesLists, n), removeBelowPercentile(seriesLists, percent), removeAbovePercentile(seriesLists, percent), removeBetweenPercentile(seriesLists, percent)
 func (f *removeBelowSeries) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
 	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
 	if err != nil {
@@ -42,6 +42,10 @@ func (f *removeBelowSeries) Do(ctx context.Context, e parser.Expr, from, until i
 		return nil, err
 	}
 
+	if e.Target() == "removeBetweenPercentile" {
+		return removeBetweenPercentile(args, number), nil
+	}
+
 	condition := func(v float64, threshold float64) bool {
 		return v < threshold
 	}
@@ -58,8 +62,8 @@ func (f *removeBelowSeries) Do(ctx context.Context, e parser.Expr, from, until i
 		threshold := number
 		if strings.HasSuffix(e.Target(), "Percentile") {
 			var values []float64
-			for i, v := range a.IsAbsent {
-				if !v {
+			for i := range a.Values {
+				if !a.IsAbsent.Get(i) {
 					values = append(values, a.Values[i])
 				}
 			}
@@ -69,13 +73,13 @@ func (f *removeBelowSeries) Do(ctx context.Context, e parser.Expr, from, until i
 
 		r := *a
 		r.Name = fmt.Sprintf("%s(%s, %g)", e.Target(), a.Name, number)
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 		r.Values = make([]float64, len(a.Values))
 
 		for i, v := range a.Values {
-			if a.IsAbsent[i] || condition(v, threshold) {
+			if a.IsAbsent.Get(i) || condition(v, threshold) {
 				r.Values[i] = math.NaN()
-				r.IsAbsent[i] = true
+				r.IsAbsent.Set(i, true)
 				continue
 			}
 
@@ -88,6 +92,55 @@ func (f *removeBelowSeries) Do(ctx context.Context, e parser.Expr, from, until i
 	return results, nil
 }
 
+// removeBetweenPercentile keeps the series with a value outside of the
+// percentiles n and 100-n of the values of all the series at its time.
+func removeBetweenPercentile(args []*types.MetricData, n float64) []*types.MetricData {
+	if n < 50 {
+		n = 100 - n
+	}
+
+	var length int
+	for _, a := range args {
+		if len(a.Values) > length {
+			length = len(a.Values)
+		}
+	}
+
+	low := make([]float64, length)
+	high := make([]float64, length)
+	present := make([]bool, length)
+	for i := 0; i < length; i++ {
+		var values []float64
+		for _, a := range args {
+			if i < len(a.Values) && !a.IsAbsent.Get(i) {
+				values = append(values, a.Values[i])
+			}
+		}
+		if len(values) == 0 {
+			continue
+		}
+
+		low[i], _ = helper.Percentile(values, 100-n, true)
+		high[i], _ = helper.Percentile(values, n, true)
+		present[i] = true
+	}
+
+	var results []*types.MetricData
+	for _, a := range args {
+		for i, v := range a.Values {
+			if a.IsAbsent.Get(i) || !present[i] {
+				continue
+			}
+			if v <= low[i] || v >= high[i] {
+				results = append(results, a)
+				break
+			}
+		}
+	}
+
+	return results
+}
+
 // Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
 func (f *removeBelowSeries) Description() map[string]types.FunctionDescription {
 	return map[string]types.FunctionDescription{
@@ -167,5 +220,24 @@ func (f *removeBelowSeries) Description() map[string]types.FunctionDescription {
 				},
 			},
 		},
+		"removeBetweenPercentile": {
+			Description: "Removes series that do not have an value lying in the x-percentile of all the values at a moment",
+			Function:    "removeBetweenPercentile(seriesList, n)",
+			Group:       "Filter Series",
+			Module:      "graphite.render.functions",
+			Name:        "removeBetweenPercentile",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "n",
+					Required: true,
+					Type:     types.Integer,
+				},
+			},
+		},
 	}
 }
@@ -3,10 +3,10 @@ package removeEmptySeries
 import (
 	"context"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type removeEmptySeries struct {
@@ -39,8 +39,8 @@ func (f *removeEmptySeries) Do(ctx context.Context, e parser.Expr, from, until i
 	var results []*types.MetricData
 
 	for _, a := range args {
-		for i, v := range a.IsAbsent {
-			if !v {
+		for i := range a.Values {
+			if !a.IsAbsent.Get(i) {
 				if e.Target() == "removeEmptySeries" || (a.Values[i] != 0) {
 					results = append(results, a)
 					break
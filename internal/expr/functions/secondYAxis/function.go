@@ -0,0 +1,67 @@
+package secondYAxis
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type secondYAxis struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &secondYAxis{}
+	functions := []string{"secondYAxis"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// secondYAxis(seriesList)
+func (f *secondYAxis) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*types.MetricData, 0, len(args))
+	for _, a := range args {
+		r := *a
+		r.Name = fmt.Sprintf("secondYAxis(%s)", a.Name)
+		r.SecondYAxis = true
+		results = append(results, &r)
+	}
+
+	return results, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *secondYAxis) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"secondYAxis": {
+			Description: "Graph the series on the secondary Y axis.",
+			Function:    "secondYAxis(seriesList)",
+			Group:       "Graph",
+			Module:      "graphite.render.functions",
+			Name:        "secondYAxis",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,74 @@
+package secondYAxis
+
+import (
+	"context"
+	"go.uber.org/zap"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestSecondYAxis(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	tests := []th.EvalTestItem{
+		{
+			"secondYAxis(metric1)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3}, 1, now32)},
+			},
+			[]*types.MetricData{types.MakeMetricData("secondYAxis(metric1)",
+				[]float64{1, 2, 3}, 1, now32)},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		testName := tt.Target
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
+
+func TestSecondYAxisFlag(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	exp, _, err := parser.ParseExpr("secondYAxis(metric1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{"metric1", 0, 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3}, 1, now32)},
+	}
+
+	f := &secondYAxis{}
+	results, err := f.Do(context.Background(), exp, 0, 1, values, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 1 || !results[0].SecondYAxis {
+		t.Error("Expected series to be flagged for the second Y axis")
+	}
+
+	if values[parser.MetricRequest{Metric: "metric1", From: 0, Until: 1}][0].SecondYAxis {
+		t.Error("Input series must not be modified")
+	}
+}
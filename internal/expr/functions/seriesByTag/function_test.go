@@ -0,0 +1,66 @@
+package seriesByTag
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestSeriesByTag(t *testing.T) {
+	target := "seriesByTag('name=cpu','dc=~ams.*')"
+	exp, _, err := parser.ParseExpr(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole query is a single metric request, sent to the backends as is
+	metrics := exp.Metrics()
+	if len(metrics) != 1 || metrics[0].Metric != target {
+		t.Fatalf("expected a single request for %s, got %v", target, metrics)
+	}
+
+	tt := th.EvalTestItem{
+		Target: target,
+		M: map[parser.MetricRequest][]*types.MetricData{
+			{Metric: target, From: 0, Until: 1}: {
+				types.MakeMetricData("cpu;dc=ams1", []float64{1, 2, 3}, 1, 0),
+				types.MakeMetricData("cpu;dc=ams2", []float64{4, 5, 6}, 1, 0),
+			},
+		},
+		Want: []*types.MetricData{
+			types.MakeMetricData("cpu;dc=ams1", []float64{1, 2, 3}, 1, 0),
+			types.MakeMetricData("cpu;dc=ams2", []float64{4, 5, 6}, 1, 0),
+		},
+	}
+	th.TestEvalExpr(t, &tt)
+}
+
+func TestSeriesByTagInvalid(t *testing.T) {
+	for _, target := range []string{"seriesByTag()", "seriesByTag('cpu')", "seriesByTag('=cpu')"} {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			continue
+		}
+		_, err = metadata.GetEvaluator().EvalExpr(context.Background(), exp, 0, 1, nil, th.NoopGetTargetData)
+		if err == nil {
+			t.Errorf("expected an error for %s", target)
+		}
+	}
+}
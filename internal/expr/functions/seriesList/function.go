@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type seriesList struct {
@@ -39,8 +39,9 @@ func (f *seriesList) Do(ctx context.Context, e parser.Expr, from, until int32, v
 		return nil, err
 	}
 
-	if len(numerators) != len(denominators) {
-		return nil, fmt.Errorf("%w: %s", parser.ErrDifferentCountMetrics, e.Target())
+	pairs, err := helper.PairSeriesLists(numerators, denominators, helper.ListMismatchError)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", e.Target(), err)
 	}
 
 	var results []*types.MetricData
@@ -63,8 +64,8 @@ func (f *seriesList) Do(ctx context.Context, e parser.Expr, from, until int32, v
 	case "powSeriesLists":
 		compute = func(l, r float64) (float64, bool) { return math.Pow(l, r), false }
 	}
-	for i, numerator := range numerators {
-		denominator := denominators[i]
+	for _, pair := range pairs {
+		numerator, denominator := pair[0], pair[1]
 		name := fmt.Sprintf("%s(%s,%s)", functionName, numerator.Name, denominator.Name)
 		result := helper.CombineSeries(numerator, denominator, name, compute)
 		results = append(results, result)
@@ -0,0 +1,64 @@
+package setXFilesFactor
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+
+	"go.uber.org/zap"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestSetXFilesFactor(t *testing.T) {
+	for _, target := range []string{"setXFilesFactor(metric1,0.5)", "xFilesFactor(metric1,xFilesFactor=0.5)"} {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values := map[parser.MetricRequest][]*types.MetricData{
+			{Metric: "metric1", From: 0, Until: 1}: {types.MakeMetricData("metric1", []float64{1, 2, 3, 4}, 1, 0)},
+		}
+		results, err := metadata.GetEvaluator().EvalExpr(context.Background(), exp, 0, 1, values, th.NoopGetTargetData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(results) != 1 || results[0].Name != "metric1" || results[0].XFilesFactor != 0.5 {
+			t.Errorf("%s: unexpected result %+v", target, results)
+		}
+		if values[parser.MetricRequest{Metric: "metric1", From: 0, Until: 1}][0].XFilesFactor != 0 {
+			t.Errorf("%s: the input series was changed", target)
+		}
+	}
+}
+
+func TestSetXFilesFactorErrors(t *testing.T) {
+	for _, target := range []string{"setXFilesFactor(metric1)", "setXFilesFactor(metric1,2)"} {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values := map[parser.MetricRequest][]*types.MetricData{
+			{Metric: "metric1", From: 0, Until: 1}: {types.MakeMetricData("metric1", []float64{1}, 1, 0)},
+		}
+		if _, err := metadata.GetEvaluator().EvalExpr(context.Background(), exp, 0, 1, values, th.NoopGetTargetData); err == nil {
+			t.Errorf("%s: expected an error", target)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package stacked
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type stacked struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &stacked{}
+	functions := []string{"stacked"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// stacked(seriesLists, stackName='__DEFAULT__')
+func (f *stacked) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	args, err := helper.GetSeriesArg(ctx, e.Args()[0], from, until, values, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	stackName, err := e.GetStringNamedOrPosArgDefault("stack", 1, types.DefaultStackName)
+	if err != nil {
+		return nil, err
+	}
+
+	// the totals carry over to the stacks of the same name of the request
+	results := make([]*types.MetricData, 0, len(args))
+	interfaces.GetStacks(ctx).Stack(stackName, func(total []float64) []float64 {
+		for _, a := range args {
+			r := *a
+			if stackName == types.DefaultStackName {
+				r.Name = fmt.Sprintf("stacked(%s)", a.Name)
+			}
+			r.Stacked = true
+			r.StackName = stackName
+			r.Cumulative = true
+			r.Values = make([]float64, len(a.Values))
+			r.IsAbsent = types.NewAbsence(len(a.Values))
+
+			for i, v := range a.Values {
+				if len(total) <= i {
+					total = append(total, 0)
+				}
+
+				if a.IsAbsent.Get(i) {
+					r.IsAbsent.Set(i, true)
+					continue
+				}
+
+				total[i] += v
+				r.Values[i] = total[i]
+			}
+
+			results = append(results, &r)
+		}
+
+		return total
+	})
+
+	return results, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *stacked) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"stacked": {
+			Description: "Takes one metric or a wildcard seriesList and change them so they are\nstacked. This is a way of stacking just a couple of metrics without having\nto use the stacked area mode (that stacks everything). By means of this a mixed\nstacked and non stacked graph can be made\n\nIt can also take an optional argument with a name of the stack, in case there is\nmore than one, e.g. for input and output metrics.\n\nExample:\n\n.. code-block:: none\n\n  &target=stacked(company.server.application01.ifconfig.TXPackets, 'tx')",
+			Function:    "stacked(seriesLists, stackName='__DEFAULT__')",
+			Group:       "Graph",
+			Module:      "graphite.render.functions",
+			Name:        "stacked",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name: "stack",
+					Type: types.String,
+				},
+			},
+		},
+	}
+}
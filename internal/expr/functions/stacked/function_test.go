@@ -0,0 +1,67 @@
+package stacked
+
+import (
+	"go.uber.org/zap"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestStacked(t *testing.T) {
+	now32 := int32(time.Now().Unix())
+
+	tests := []th.EvalTestItem{
+		{
+			"stacked(metric*)",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric*", 0, 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2, math.NaN(), 4}, 1, now32),
+					types.MakeMetricData("metric2", []float64{1, math.NaN(), 3, 4}, 1, now32),
+					types.MakeMetricData("metric3", []float64{1, 2, 3, 4}, 1, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("stacked(metric1)", []float64{1, 2, math.NaN(), 4}, 1, now32),
+				types.MakeMetricData("stacked(metric2)", []float64{2, math.NaN(), 3, 8}, 1, now32),
+				types.MakeMetricData("stacked(metric3)", []float64{3, 4, 6, 12}, 1, now32),
+			},
+		},
+		{
+			"stacked(metric*, 'tx')",
+			map[parser.MetricRequest][]*types.MetricData{
+				{"metric*", 0, 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2}, 1, now32),
+					types.MakeMetricData("metric2", []float64{1, 2}, 1, now32),
+				},
+			},
+			[]*types.MetricData{
+				types.MakeMetricData("metric1", []float64{1, 2}, 1, now32),
+				types.MakeMetricData("metric2", []float64{2, 4}, 1, now32),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		testName := tt.Target
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -5,10 +5,10 @@ import (
 	"fmt"
 	"math"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type stdev struct {
@@ -57,10 +57,10 @@ func (f *stdev) Do(ctx context.Context, e parser.Expr, from, until int32, values
 		r := *a
 		r.Name = fmt.Sprintf("stdev(%s,%d)", a.Name, points)
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 
 		for i, v := range a.Values {
-			if a.IsAbsent[i] {
+			if a.IsAbsent.Get(i) {
 				// make sure missing values are ignored
 				v = math.NaN()
 			}
@@ -68,7 +68,7 @@ func (f *stdev) Do(ctx context.Context, e parser.Expr, from, until int32, values
 			r.Values[i] = w.Stdev()
 			if math.IsNaN(r.Values[i]) || (i >= minLen && w.Len() < minLen) {
 				r.Values[i] = 0
-				r.IsAbsent[i] = true
+				r.IsAbsent.Set(i, true)
 			}
 		}
 		result = append(result, &r)
@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type sum struct {
@@ -47,7 +47,7 @@ func (f *sum) Do(ctx context.Context, e parser.Expr, from, until int32, values m
 
 	e.SetTarget("sumSeries")
 	name := fmt.Sprintf("%s(%s)", e.Target(), e.RawArgs())
-	return helper.AggregateSeries(name, args, false, false, SumAggregation)
+	return helper.AggregateSeriesByName(name, args, "sum", helper.DefaultXFilesFactor())
 }
 
 // Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -63,6 +63,9 @@ func (f *summarize) Do(ctx context.Context, e parser.Expr, from, until int32, va
 		alignOk = len(e.Args()) > 3
 	}
 
+	// Buckets with less than xFilesFactor of their points known are absent
+	xFilesFactor := helper.DefaultXFilesFactor()
+
 	start := args[0].StartTime
 	stop := args[0].StopTime
 	if !alignToFrom {
@@ -108,7 +111,7 @@ func (f *summarize) Do(ctx context.Context, e parser.Expr, from, until int32, va
 			Metric: dataTypes.Metric{
 				Name:      name,
 				Values:    make([]float64, buckets),
-				IsAbsent:  make([]bool, buckets),
+				IsAbsent:  types.NewAbsence(int(buckets)),
 				StepTime:  bucketSize,
 				StartTime: start,
 				StopTime:  stop,
@@ -121,7 +124,7 @@ func (f *summarize) Do(ctx context.Context, e parser.Expr, from, until int32, va
 		bucketItems := 0
 		for i, v := range arg.Values {
 			bucketItems++
-			if !arg.IsAbsent[i] {
+			if helper.Known(v, arg.IsAbsent.Get(i)) {
 				values = append(values, v)
 			}
 
@@ -132,9 +135,15 @@ func (f *summarize) Do(ctx context.Context, e parser.Expr, from, until int32, va
 			}
 
 			if t >= bucketEnd {
-				r.Values[ridx], r.IsAbsent[ridx], err = helper.SummarizeValues(summarizeFunction, values)
-				if err != nil {
-					return []*types.MetricData{}, err
+				if len(values) > 0 && !helper.XFilesFactor(len(values), bucketItems, xFilesFactor) {
+					r.IsAbsent.Set(ridx, true)
+				} else {
+					var absent bool
+					r.Values[ridx], absent, err = helper.SummarizeValues(summarizeFunction, values)
+					if err != nil {
+						return []*types.MetricData{}, err
+					}
+					r.IsAbsent.Set(ridx, absent)
 				}
 				ridx++
 				bucketEnd += bucketSize
@@ -145,9 +154,15 @@ func (f *summarize) Do(ctx context.Context, e parser.Expr, from, until int32, va
 
 		// last partial bucket
 		if bucketItems > 0 {
-			r.Values[ridx], r.IsAbsent[ridx], err = helper.SummarizeValues(summarizeFunction, values)
-			if err != nil {
-				return []*types.MetricData{}, err
+			if len(values) > 0 && !helper.XFilesFactor(len(values), bucketItems, xFilesFactor) {
+				r.IsAbsent.Set(ridx, true)
+			} else {
+				var absent bool
+				r.Values[ridx], absent, err = helper.SummarizeValues(summarizeFunction, values)
+				if err != nil {
+					return []*types.MetricData{}, err
+				}
+				r.IsAbsent.Set(ridx, absent)
 			}
 		}
 
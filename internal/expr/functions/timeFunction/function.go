@@ -3,9 +3,9 @@ package timeFunction
 import (
 	"context"
 
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -20,7 +20,7 @@ func GetOrder() interfaces.Order {
 func New(configFile string) []interfaces.FunctionMetadata {
 	res := make([]interfaces.FunctionMetadata, 0)
 	f := &timeFunction{}
-	functions := []string{"timeFunction", "time"}
+	functions := []string{"timeFunction", "time", "identity"}
 	for _, n := range functions {
 		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
 	}
@@ -33,7 +33,7 @@ func (f *timeFunction) Do(ctx context.Context, e parser.Expr, from, until int32,
 		return nil, err
 	}
 
-	stepInt, err := e.GetIntArgDefault(1, 60)
+	stepInt, err := e.GetIntNamedOrPosArgDefault("step", 1, 60)
 	if err != nil {
 		return nil, err
 	}
@@ -61,7 +61,7 @@ func (f *timeFunction) Do(ctx context.Context, e parser.Expr, from, until int32,
 			StopTime:  until,
 			StepTime:  step,
 			Values:    newValues,
-			IsAbsent:  make([]bool, len(newValues)),
+			IsAbsent:  types.NewAbsence(len(newValues)),
 		},
 	}
 
@@ -109,5 +109,24 @@ func (f *timeFunction) Description() map[string]types.FunctionDescription {
 				},
 			},
 		},
+		"identity": {
+			Description: "Identity function:\nReturns datapoints where the value equals the timestamp of the datapoint.\nUseful when you have another series where the value is a timestamp, and\nyou want to compare it to the time of the datapoint, to render an age\n\nExample:\n\n.. code-block:: none\n\n  &target=identity(\"The.time.series\")\n\nThis would create a series named \"The.time.series\" that contains points where\nx(t) == t.",
+			Function:    "identity(name, step=60)",
+			Group:       "Calculate",
+			Module:      "graphite.render.functions",
+			Name:        "identity",
+			Params: []types.FunctionParam{
+				{
+					Name:     "name",
+					Required: true,
+					Type:     types.String,
+				},
+				{
+					Default: types.NewSuggestion(60),
+					Name:    "step",
+					Type:    types.Integer,
+				},
+			},
+		},
 	}
 }
@@ -4,10 +4,10 @@ import (
 	"context"
 	"fmt"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type timeLag struct {
@@ -39,7 +39,7 @@ func MakeTimeLag(consumerMetric, producerMetric *types.MetricData, name string)
 	r.Name = name
 
 	r.Values = make([]float64, len(consumerMetric.Values))
-	r.IsAbsent = make([]bool, len(consumerMetric.Values))
+	r.IsAbsent = types.NewAbsence(len(consumerMetric.Values))
 
 	var pIndex int32 = 0
 	for i, v := range consumerMetric.Values {
@@ -47,26 +47,26 @@ func MakeTimeLag(consumerMetric, producerMetric *types.MetricData, name string)
 		if i > 0 && consumerMetric.Values[i-1] > v {
 			pIndex = 0
 		}
-		if consumerMetric.IsAbsent[i] || len(producerMetric.Values) == 0 {
-			r.IsAbsent[i] = true
+		if consumerMetric.IsAbsent.Get(i) || len(producerMetric.Values) == 0 {
+			r.IsAbsent.Set(i, true)
 			continue
 		}
 
 		npIndex := pIndex
 		// npIndex: find first index in producer metric that is higher than v
-		for (producerMetric.IsAbsent[npIndex] || producerMetric.Values[npIndex] <= v) && (npIndex+1) < pLen {
+		for (producerMetric.IsAbsent.Get(int(npIndex)) || producerMetric.Values[npIndex] <= v) && (npIndex+1) < pLen {
 			npIndex++
-			for producerMetric.IsAbsent[npIndex] && (npIndex+1) < pLen {
+			for producerMetric.IsAbsent.Get(int(npIndex)) && (npIndex+1) < pLen {
 				npIndex++
 			}
 			// maintain: pIndex is highest index for which producer metric <= v
-			if !producerMetric.IsAbsent[npIndex] && producerMetric.Values[npIndex] <= v {
+			if !producerMetric.IsAbsent.Get(int(npIndex)) && producerMetric.Values[npIndex] <= v {
 				pIndex = npIndex
 			}
 		}
 		// we can't compute timeLag for the value that is lower than the smallest data point in producer metric
-		if producerMetric.IsAbsent[pIndex] || producerMetric.Values[pIndex] > v {
-			r.IsAbsent[i] = true
+		if producerMetric.IsAbsent.Get(int(pIndex)) || producerMetric.Values[pIndex] > v {
+			r.IsAbsent.Set(i, true)
 			continue
 		}
 
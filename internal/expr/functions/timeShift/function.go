@@ -3,11 +3,12 @@ package timeShift
 import (
 	"context"
 	"fmt"
+	"time"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type timeShift struct {
@@ -28,16 +29,32 @@ func New(configFile string) []interfaces.FunctionMetadata {
 	return res
 }
 
-// timeShift(seriesList, timeShift, resetEnd=True)
+// timeShift(seriesList, timeShift, resetEnd=True, alignDST=False)
 func (f *timeShift) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
 	// FIXME(dgryski): support resetEnd=true
-	// FIXME(civil): support alignDst
 	offs, err := e.GetIntervalArg(1, -1)
 	if err != nil {
 		return nil, err
 	}
 
-	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from+offs, until+offs, values, getTargetData)
+	alignDST, err := e.GetBoolNamedOrPosArgDefault("alignDST", 3, false)
+	if err != nil {
+		return nil, err
+	}
+
+	shift := offs
+	if alignDST {
+		if dst := dstOffset(interfaces.TimeZone(ctx), from, until, offs); dst != 0 {
+			shift += dst
+			// the prefetched range only accounts for offs
+			err, _ = getTargetData(ctx, e.Args()[0], from+shift, until+shift, values)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	arg, err := helper.GetSeriesArg(ctx, e.Args()[0], from+shift, until+shift, values, getTargetData)
 	if err != nil {
 		return nil, err
 	}
@@ -47,14 +64,33 @@ func (f *timeShift) Do(ctx context.Context, e parser.Expr, from, until int32, va
 	for _, a := range arg {
 		r := *a
 		r.Name = fmt.Sprintf("timeShift(%s,'%d')", a.Name, offs)
-		r.StartTime = a.StartTime - offs
-		r.StopTime = a.StopTime - offs
+		r.StartTime = a.StartTime - shift
+		r.StopTime = a.StopTime - shift
 		results = append(results, &r)
 	}
 
 	return results, nil
 }
 
+// dstOffset returns the adjustment that keeps a series shifted by offs
+// aligned to the same local time of day in loc, like graphite-web does. It is
+// only non-zero when both the requested and the shifted ranges are entirely
+// on either side of a UTC offset change, e.g. between summer and winter time.
+func dstOffset(loc *time.Location, from, until, offs int32) int32 {
+	zoneOffset := func(t int32) int {
+		_, offset := time.Unix(int64(t), 0).In(loc).Zone()
+		return offset
+	}
+
+	reqStart, reqEnd := zoneOffset(from), zoneOffset(until)
+	shiftedStart, shiftedEnd := zoneOffset(from+offs), zoneOffset(until+offs)
+	if reqStart != reqEnd || shiftedStart != shiftedEnd {
+		return 0
+	}
+
+	return int32(reqStart - shiftedStart)
+}
+
 // Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
 func (f *timeShift) Description() map[string]types.FunctionDescription {
 	return map[string]types.FunctionDescription{
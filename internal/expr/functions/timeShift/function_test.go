@@ -0,0 +1,80 @@
+package timeShift
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestTimeShift(t *testing.T) {
+	amsterdam, err := time.LoadLocation("Europe/Amsterdam")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// midnight of 2023-07-03 in Amsterdam, in summer time
+	from := int32(time.Date(2023, time.July, 3, 0, 0, 0, 0, amsterdam).Unix())
+	until := from + 3600
+	week := int32(7 * 86400)
+	halfYear := int32(182 * 86400)
+
+	tests := []struct {
+		name   string
+		target string
+		loc    *time.Location
+		shift  int32 // how far back the series is fetched
+	}{
+		{"plain", "timeShift(m,'1w')", amsterdam, week},
+		{"alignDST same offset", "timeShift(m,'1w',true,true)", amsterdam, week},
+		{"alignDST into winter time", "timeShift(m,'182d',true,true)", amsterdam, halfYear - 3600},
+		{"alignDST in UTC", "timeShift(m,'182d',alignDST=true)", time.UTC, halfYear},
+		{"no alignDST", "timeShift(m,'182d')", amsterdam, halfYear},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			exp, _, err := parser.ParseExpr(tst.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			start := from - tst.shift
+			values := map[parser.MetricRequest][]*types.MetricData{
+				{Metric: "m", From: int64(start), Until: int64(until - tst.shift)}: {
+					types.MakeMetricData("m", []float64{1, 2, 3}, 1200, start),
+				},
+			}
+			getTargetData := func(ctx context.Context, exp parser.Expr, from, until int32, metricMap map[parser.MetricRequest][]*types.MetricData) (error, int) {
+				return nil, 0
+			}
+
+			ctx := interfaces.WithTimeZone(context.Background(), tst.loc)
+			got, err := metadata.GetEvaluator().EvalExpr(ctx, exp, from, until, values, getTargetData)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != 1 || got[0].StartTime != from {
+				t.Fatalf("expected one series shifted to start at %d, got %v", from, got)
+			}
+		})
+	}
+}
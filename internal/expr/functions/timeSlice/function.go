@@ -0,0 +1,121 @@
+package timeSlice
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/date"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type timeSlice struct {
+	interfaces.FunctionBase
+}
+
+func GetOrder() interfaces.Order {
+	return interfaces.Any
+}
+
+func New(configFile string) []interfaces.FunctionMetadata {
+	res := make([]interfaces.FunctionMetadata, 0)
+	f := &timeSlice{}
+	functions := []string{"timeSlice"}
+	for _, n := range functions {
+		res = append(res, interfaces.FunctionMetadata{Name: n, F: f})
+	}
+	return res
+}
+
+// timeSlice(seriesList, startSliceAt, endSliceAt="now")
+func (f *timeSlice) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	start, err := sliceAt(ctx, e, "startSliceAt", 1, "")
+	if err != nil {
+		return nil, err
+	}
+	end, err := sliceAt(ctx, e, "endSliceAt", 2, "now")
+	if err != nil {
+		return nil, err
+	}
+	if end < start {
+		return nil, fmt.Errorf("%s: %w: endSliceAt must be after startSliceAt", e.Target(), parser.ErrInvalidArgumentValue)
+	}
+
+	return helper.ForEachSeriesDo(ctx, e, from, until, values, func(a *types.MetricData, r *types.MetricData) *types.MetricData {
+		r.Name = fmt.Sprintf("timeSlice(%s, %d, %d)", a.Name, start, end)
+		t := a.StartTime
+		for i, v := range a.Values {
+			if a.IsAbsent.Get(i) || t < start || t > end {
+				r.Values[i] = 0
+				r.IsAbsent.Set(i, true)
+			} else {
+				r.Values[i] = v
+			}
+			t += a.StepTime
+		}
+		return r
+	}, getTargetData)
+}
+
+// sliceAt parses the time argument k or n of e, a timestamp or a time as in
+// the from and until parameters of render requests, d if it is not given.
+func sliceAt(ctx context.Context, e parser.Expr, k string, n int, d string) (int32, error) {
+	arg, ok := e.NamedArgs()[k]
+	if !ok && len(e.Args()) > n {
+		arg, ok = e.Args()[n], true
+	}
+
+	s := d
+	if ok {
+		switch arg.Type() {
+		case parser.EtConst:
+			return int32(arg.FloatValue()), nil
+		case parser.EtString:
+			s = arg.StringValue()
+		default:
+			return 0, parser.ErrBadType
+		}
+	}
+	if s == "" {
+		return 0, parser.ErrMissingArgument
+	}
+
+	t, err := date.DateParamToEpoch(s, "", 0, interfaces.TimeZone(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("%w: %s %s: %v", parser.ErrInvalidArgumentValue, k, s, err)
+	}
+
+	return t, nil
+}
+
+// Description is auto-generated description, based on output of https://github.com/graphite-project/graphite-web
+func (f *timeSlice) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{
+		"timeSlice": {
+			Description: "Takes one metric or a wildcard metric, followed by a quoted string with the\ntime to start the line and another quoted string with the time to end the line.\nThe start and end times are inclusive. See ``from / until`` in the :doc:`Render API <render_api>`\nfor examples of time formats.\n\nUseful for filtering out a part of a series of data from a wider range of\ndata.\n\nExample:\n\n.. code-block:: none\n\n  &target=timeSlice(network.core.port1,\"00:00 20140101\",\"11:59 20140630\")\n  &target=timeSlice(network.core.port1,\"12:00 20140630\",\"now\")",
+			Function:    "timeSlice(seriesList, startSliceAt, endSliceAt='now')",
+			Group:       "Transform",
+			Module:      "graphite.render.functions",
+			Name:        "timeSlice",
+			Params: []types.FunctionParam{
+				{
+					Name:     "seriesList",
+					Required: true,
+					Type:     types.SeriesList,
+				},
+				{
+					Name:     "startSliceAt",
+					Required: true,
+					Type:     types.Date,
+				},
+				{
+					Default: types.NewSuggestion("now"),
+					Name:    "endSliceAt",
+					Type:    types.Date,
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,70 @@
+package timeSlice
+
+import (
+	"math"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+	th "github.com/bookingcom/carbonapi/tests"
+)
+
+func init() {
+	md := New("")
+	evaluator := th.EvaluatorFromFunc(md[0].F)
+	metadata.SetEvaluator(evaluator)
+	helper.SetEvaluator(evaluator)
+	for _, m := range md {
+		metadata.RegisterFunction(m.Name, m.F, zap.NewNop())
+	}
+}
+
+func TestTimeSlice(t *testing.T) {
+	tests := []th.EvalTestItem{
+		{
+			Target: "timeSlice(metric1,60,120)",
+			M: map[parser.MetricRequest][]*types.MetricData{
+				{Metric: "metric1", From: 0, Until: 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2, 3, math.NaN(), 5, 6}, 30, 0),
+				},
+			},
+			Want: []*types.MetricData{
+				types.MakeMetricData("timeSlice(metric1, 60, 120)", []float64{math.NaN(), math.NaN(), 3, math.NaN(), 5, math.NaN()}, 30, 0),
+			},
+		},
+		{
+			Target: "timeSlice(metric1,'00:01_19700101','00:02_19700101')",
+			M: map[parser.MetricRequest][]*types.MetricData{
+				{Metric: "metric1", From: 0, Until: 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6}, 30, 0),
+				},
+			},
+			Want: []*types.MetricData{
+				types.MakeMetricData("timeSlice(metric1, 60, 120)", []float64{math.NaN(), math.NaN(), 3, 4, 5, math.NaN()}, 30, 0),
+			},
+		},
+		{
+			Target: "timeSlice(metric1,90,endSliceAt=150)",
+			M: map[parser.MetricRequest][]*types.MetricData{
+				{Metric: "metric1", From: 0, Until: 1}: {
+					types.MakeMetricData("metric1", []float64{1, 2, 3, 4, 5, 6}, 30, 0),
+				},
+			},
+			Want: []*types.MetricData{
+				types.MakeMetricData("timeSlice(metric1, 90, 150)", []float64{math.NaN(), math.NaN(), math.NaN(), 4, 5, 6}, 30, 0),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		testName := tt.Target
+		t.Run(testName, func(t *testing.T) {
+			th.TestEvalExpr(t, &tt)
+		})
+	}
+}
@@ -6,10 +6,10 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type tukey struct {
@@ -87,7 +87,7 @@ func (f *tukey) Do(ctx context.Context, e parser.Expr, from, until int32, values
 	var points []float64
 	for _, a := range arg {
 		for i, m := range a.Values[beginInterval:endInterval] {
-			if a.IsAbsent[beginInterval+i] {
+			if a.IsAbsent.Get(beginInterval + i) {
 				continue
 			}
 			points = append(points, m)
@@ -112,7 +112,7 @@ func (f *tukey) Do(ctx context.Context, e parser.Expr, from, until int32, values
 	for i, a := range arg {
 		var outlier int
 		for i, m := range a.Values[beginInterval:endInterval] {
-			if a.IsAbsent[beginInterval+i] {
+			if a.IsAbsent.Get(beginInterval + i) {
 				continue
 			}
 			if isAbove {
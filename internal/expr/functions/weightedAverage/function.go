@@ -7,11 +7,10 @@ import (
 	"strconv"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/functions/sum"
-	"github.com/bookingcom/carbonapi/expr/helper"
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/helper"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type weightedAverage struct {
@@ -79,12 +78,12 @@ func (f *weightedAverage) Do(ctx context.Context, e parser.Expr, from, until int
 	}
 
 	sumOfProductMetricName := "sumOfProducts"
-	sumOfProductsMetrics, err := helper.AggregateSeries(sumOfProductMetricName, productMetrics, false, false, sum.SumAggregation)
+	sumOfProductsMetrics, err := helper.AggregateSeriesByName(sumOfProductMetricName, productMetrics, "sum", helper.DefaultXFilesFactor())
 	if err != nil {
 		return nil, err
 	}
 	sumOfWeightsMetricName := "sumOfWeights"
-	sumOfWeightsMetrics, err := helper.AggregateSeries(sumOfWeightsMetricName, weightArg, false, false, sum.SumAggregation)
+	sumOfWeightsMetrics, err := helper.AggregateSeriesByName(sumOfWeightsMetricName, weightArg, "sum", helper.DefaultXFilesFactor())
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,89 @@
+package helper
+
+import (
+	"github.com/bookingcom/carbonapi/internal/expr/kernel"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+)
+
+// aggregationOps are the aggregations of GetAggregateFunc that have a
+// kernel, by name
+var aggregationOps = map[string]kernel.Op{
+	"sum":     kernel.Sum,
+	"total":   kernel.Sum,
+	"avg":     kernel.Mean,
+	"average": kernel.Mean,
+	"min":     kernel.Min,
+	"max":     kernel.Max,
+	"count":   kernel.Count,
+}
+
+// AggregateSeriesByName aggregates args into a series named name with the
+// aggregation function named aggFunc, like AggregateSeriesXFilesFactor with
+// GetAggregateFunc(aggFunc). Sums, averages, minimums, maximums and counts
+// are computed in kernels, the others by AggregateSeriesXFilesFactor, points
+// of multiply being absent if absent in any of args.
+func AggregateSeriesByName(name string, args []*types.MetricData, aggFunc string, xFilesFactor float64) ([]*types.MetricData, error) {
+	op, ok := aggregationOps[aggFunc]
+	if !ok {
+		function, err := GetAggregateFunc(aggFunc)
+		if err != nil {
+			return nil, err
+		}
+		return AggregateSeriesXFilesFactor(name, args, false, aggFunc == "multiply", xFilesFactor, function)
+	}
+
+	return aggregateSeriesKernel(name, args, op, xFilesFactor), nil
+}
+
+// aggregateSeriesKernel is AggregateSeriesXFilesFactor by op.
+func aggregateSeriesKernel(name string, args []*types.MetricData, op kernel.Op, xFilesFactor float64) []*types.MetricData {
+	if len(args) == 0 {
+		return []*types.MetricData{}
+	}
+
+	step := args[0].StepTime
+	start, end := args[0].StartTime, args[0].StopTime
+	for _, s := range args {
+		step = LCM(step, s.StepTime)
+		if start > s.StartTime {
+			start = s.StartTime
+		}
+		if end < s.StopTime {
+			end = s.StopTime
+		}
+	}
+	if len(args) > 1 {
+		end -= (end - start) % step
+	}
+	length := int((end - start) / step)
+
+	a := kernel.New(op, length)
+	infinityAbsent := infinityPolicy == InfinityPolicyNull
+	var points []float64
+	for _, s := range args {
+		// consolidated like Normalize does, without copying the others
+		if s.StepTime != step {
+			s = s.Consolidate(int(step / s.StepTime))
+		}
+		points = kernel.Points(points, s.Values, s.IsAbsent, infinityAbsent)
+		a.Add(points)
+	}
+
+	values := make([]float64, length)
+	isAbsent := types.NewAbsence(length)
+	for i := range values {
+		known := a.Known(i)
+		switch {
+		case known == 0:
+			var absent bool
+			values[i], absent = EmptyAggregation()
+			isAbsent.Set(i, absent)
+		case XFilesFactor(known, len(args), xFilesFactor):
+			values[i] = a.Value(i)
+		default:
+			isAbsent.Set(i, true)
+		}
+	}
+
+	return []*types.MetricData{NewCombined(name, values, isAbsent, step, start, args)}
+}
@@ -0,0 +1,76 @@
+package helper
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+)
+
+func TestAggregateSeriesByName(t *testing.T) {
+	args := []*types.MetricData{
+		types.MakeMetricData("a", []float64{1, math.NaN(), 3, 4, math.Inf(-1), 6}, 10, 0),
+		types.MakeMetricData("b", []float64{2, math.NaN(), math.NaN(), 8, 1, 2, 3}, 10, 0),
+		// consolidated to a step of 20
+		types.MakeMetricData("c", []float64{1, 3, math.NaN(), math.NaN(), 5, 7}, 5, 0),
+	}
+
+	for _, aggFunc := range []string{"sum", "total", "avg", "average", "min", "max", "count", "median", "multiply"} {
+		t.Run(aggFunc, func(t *testing.T) {
+			function, err := GetAggregateFunc(aggFunc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := AggregateSeriesXFilesFactor("s", args, false, aggFunc == "multiply", 0.5, function)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := AggregateSeriesByName("s", args, aggFunc, 0.5)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != 1 || got[0].StartTime != want[0].StartTime || got[0].StepTime != want[0].StepTime ||
+				len(got[0].Values) != len(want[0].Values) {
+				t.Fatalf("got %+v, expected %+v", got[0], want[0])
+			}
+			for i := range want[0].Values {
+				if got[0].IsAbsent.Get(i) != want[0].IsAbsent.Get(i) || !want[0].IsAbsent.Get(i) && got[0].Values[i] != want[0].Values[i] {
+					t.Errorf("point %d: got %g (absent %t), expected %g (absent %t)", i,
+						got[0].Values[i], got[0].IsAbsent.Get(i), want[0].Values[i], want[0].IsAbsent.Get(i))
+				}
+			}
+		})
+	}
+}
+
+func benchmarkSeries(n, points int) []*types.MetricData {
+	args := make([]*types.MetricData, n)
+	for i := range args {
+		values := make([]float64, points)
+		for j := range values {
+			values[j] = float64(i + j)
+			if (i+j)%7 == 0 {
+				values[j] = math.NaN()
+			}
+		}
+		args[i] = types.MakeMetricData("a", values, 60, 0)
+	}
+	return args
+}
+
+func BenchmarkAggregateSeriesSum(b *testing.B) {
+	args := benchmarkSeries(1000, 1440)
+
+	b.Run("generic", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = AggregateSeries("s", args, false, false, sumValues)
+		}
+	})
+	b.Run("kernel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			_, _ = AggregateSeriesByName("s", args, "sum", 0)
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package helper
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type Operator func(l, r float64) (float64, bool)
+
+// CombineSeries applied operator() on two series. If they do not have the same length, series are consolidated with a Lower Common Multiple step.
+func CombineSeries(originalA, originalB *types.MetricData, name string, operator Operator) *types.MetricData {
+
+	step := LCM(originalA.StepTime, originalB.StepTime)
+
+	a := originalA.Consolidate(int(step / originalA.StepTime))
+	b := originalB.Consolidate(int(step / originalB.StepTime))
+
+	start := a.StartTime
+	if start > b.StartTime {
+		start = b.StartTime
+	}
+	end := a.StopTime
+	if end < b.StopTime {
+		end = b.StopTime
+	}
+	end -= (end - start) % step
+	length := int((end - start) / step)
+
+	if a.IsAbsent.Len() > length {
+		length = a.IsAbsent.Len()
+	}
+	if len(a.Values) > length {
+		length = len(a.Values)
+	}
+	if b.IsAbsent.Len() > length {
+		length = b.IsAbsent.Len()
+	}
+	if len(b.Values) > length {
+		length = len(b.Values)
+	}
+	values := make([]float64, length)
+	isAbsent := types.NewAbsence(length)
+	for i := 0; i < length; i++ {
+		if i >= a.IsAbsent.Len() || i >= b.IsAbsent.Len() ||
+			i >= len(a.Values) || i >= len(b.Values) {
+			isAbsent.Set(i, true)
+			continue
+		}
+		if a.IsAbsent.Get(i) || b.IsAbsent.Get(i) {
+			isAbsent.Set(i, true)
+			continue
+		}
+		var absent bool
+		values[i], absent = operator(a.Values[i], b.Values[i])
+		isAbsent.Set(i, absent)
+	}
+
+	return NewCombined(name, values, isAbsent, step, start, []*types.MetricData{originalA, originalB})
+}
+
+// ListMismatchPolicy is how two series lists of different lengths are paired
+// by position.
+type ListMismatchPolicy string
+
+const (
+	// ListMismatchError fails.
+	ListMismatchError ListMismatchPolicy = "error"
+	// ListMismatchTruncate drops the series of the longer list that have no
+	// pair.
+	ListMismatchTruncate ListMismatchPolicy = "truncate"
+	// ListMismatchPadNull pairs the series of the longer list that have no
+	// pair with a series of nulls.
+	ListMismatchPadNull ListMismatchPolicy = "pad-null"
+)
+
+// ParseListMismatchPolicy returns the policy named s.
+func ParseListMismatchPolicy(s string) (ListMismatchPolicy, error) {
+	switch p := ListMismatchPolicy(s); p {
+	case ListMismatchError, ListMismatchTruncate, ListMismatchPadNull:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("%w: unknown mismatch policy %q, must be %s, %s or %s", parser.ErrInvalidArgumentValue,
+		s, ListMismatchError, ListMismatchTruncate, ListMismatchPadNull)
+}
+
+// PairSeriesLists pairs the series of a and b by position, by policy when the
+// lists are of different lengths. The series of nulls padding a list are
+// named None, and have the step and range of their pair.
+func PairSeriesLists(a, b []*types.MetricData, policy ListMismatchPolicy) ([][2]*types.MetricData, error) {
+	n := len(a)
+	if len(a) != len(b) {
+		switch policy {
+		case ListMismatchTruncate:
+			if len(b) < n {
+				n = len(b)
+			}
+		case ListMismatchPadNull:
+			if len(b) > n {
+				n = len(b)
+			}
+		default:
+			return nil, fmt.Errorf("%w: %d and %d metrics", parser.ErrDifferentCountMetrics, len(a), len(b))
+		}
+	}
+
+	pairs := make([][2]*types.MetricData, n)
+	for i := range pairs {
+		switch {
+		case i >= len(a):
+			pairs[i] = [2]*types.MetricData{nullSeries(b[i]), b[i]}
+		case i >= len(b):
+			pairs[i] = [2]*types.MetricData{a[i], nullSeries(a[i])}
+		default:
+			pairs[i] = [2]*types.MetricData{a[i], b[i]}
+		}
+	}
+
+	return pairs, nil
+}
+
+// nullSeries returns a series of nulls with the step and range of s.
+func nullSeries(s *types.MetricData) *types.MetricData {
+	values := make([]float64, len(s.Values))
+	for i := range values {
+		values[i] = math.NaN()
+	}
+
+	return types.MakeMetricData("None", values, s.StepTime, s.StartTime)
+}
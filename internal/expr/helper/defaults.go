@@ -0,0 +1,76 @@
+package helper
+
+import (
+	"fmt"
+	"math"
+)
+
+// NullPolicy is what an aggregation of points that are all absent gives.
+type NullPolicy string
+
+const (
+	// NullPolicyNull gives an absent point, like graphite-web.
+	NullPolicyNull NullPolicy = "null"
+	// NullPolicyZero gives 0.
+	NullPolicyZero NullPolicy = "zero"
+)
+
+// InfinityPolicy is how aggregations treat infinite points.
+type InfinityPolicy string
+
+const (
+	// InfinityPolicyKeep aggregates them, like graphite-web.
+	InfinityPolicyKeep InfinityPolicy = "keep"
+	// InfinityPolicyNull treats them as absent.
+	InfinityPolicyNull InfinityPolicy = "null"
+)
+
+var (
+	nullPolicy     = NullPolicyNull
+	infinityPolicy = InfinityPolicyKeep
+)
+
+// ParseNullPolicy returns the policy named s.
+func ParseNullPolicy(s string) (NullPolicy, error) {
+	switch p := NullPolicy(s); p {
+	case NullPolicyNull, NullPolicyZero:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown null policy %q, expected %s or %s", s, NullPolicyNull, NullPolicyZero)
+}
+
+// ParseInfinityPolicy returns the policy named s.
+func ParseInfinityPolicy(s string) (InfinityPolicy, error) {
+	switch p := InfinityPolicy(s); p {
+	case InfinityPolicyKeep, InfinityPolicyNull:
+		return p, nil
+	}
+
+	return "", fmt.Errorf("unknown infinity policy %q, expected %s or %s", s, InfinityPolicyKeep, InfinityPolicyNull)
+}
+
+// SetNullPolicy sets what the aggregations of points that are all absent
+// give, in aggregating and summarizing functions
+func SetNullPolicy(p NullPolicy) {
+	nullPolicy = p
+}
+
+// SetInfinityPolicy sets how aggregating and summarizing functions treat
+// infinite points
+func SetInfinityPolicy(p InfinityPolicy) {
+	infinityPolicy = p
+}
+
+// Known reports whether a point of value v, absent or not, is aggregated.
+func Known(v float64, absent bool) bool {
+	return !absent && !(infinityPolicy == InfinityPolicyNull && math.IsInf(v, 0))
+}
+
+// EmptyAggregation returns the aggregation of points that are all absent.
+func EmptyAggregation() (float64, bool) {
+	if nullPolicy == NullPolicyZero {
+		return 0, false
+	}
+	return 0, true
+}
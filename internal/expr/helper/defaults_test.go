@@ -0,0 +1,63 @@
+package helper
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+)
+
+func TestAggregateSeriesPolicies(t *testing.T) {
+	defer func() {
+		SetNullPolicy(NullPolicyNull)
+		SetInfinityPolicy(InfinityPolicyKeep)
+	}()
+
+	tests := []struct {
+		name     string
+		null     NullPolicy
+		infinity InfinityPolicy
+		want     []float64
+	}{
+		{"graphite-web", NullPolicyNull, InfinityPolicyKeep, []float64{3, math.Inf(1), math.NaN()}},
+		{"zero", NullPolicyZero, InfinityPolicyKeep, []float64{3, math.Inf(1), 0}},
+		{"infinity as null", NullPolicyNull, InfinityPolicyNull, []float64{3, 2, math.NaN()}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetNullPolicy(tt.null)
+			SetInfinityPolicy(tt.infinity)
+
+			args := []*types.MetricData{
+				types.MakeMetricData("a", []float64{1, math.Inf(1), math.NaN()}, 1, 0),
+				types.MakeMetricData("b", []float64{2, 2, math.NaN()}, 1, 0),
+			}
+			got, err := AggregateSeries("sumSeries(a,b)", args, false, false, sumValues)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			want := types.MakeMetricData("sumSeries(a,b)", tt.want, 1, 0)
+			for i := range want.Values {
+				if got[0].IsAbsent.Get(i) != want.IsAbsent.Get(i) || !want.IsAbsent.Get(i) && got[0].Values[i] != want.Values[i] {
+					t.Errorf("point %d: got %g (absent %t), expected %g (absent %t)",
+						i, got[0].Values[i], got[0].IsAbsent.Get(i), want.Values[i], want.IsAbsent.Get(i))
+				}
+			}
+		})
+	}
+}
+
+func TestSummarizeValuesNullPolicy(t *testing.T) {
+	defer SetNullPolicy(NullPolicyNull)
+
+	if _, absent, err := SummarizeValues("sum", nil); err != nil || !absent {
+		t.Errorf("expected an absent sum of no values, got absent %t, error %v", absent, err)
+	}
+
+	SetNullPolicy(NullPolicyZero)
+	if v, absent, err := SummarizeValues("max", nil); err != nil || absent || v != 0 {
+		t.Errorf("expected a max of 0 of no values, got %g, absent %t, error %v", v, absent, err)
+	}
+}
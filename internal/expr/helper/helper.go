@@ -10,9 +10,9 @@ import (
 	"unicode"
 	"unicode/utf8"
 
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 
 	"github.com/wangjohn/quickselect"
 	"gonum.org/v1/gonum/mat"
@@ -103,7 +103,7 @@ func ForEachSeriesDo(ctx context.Context, e parser.Expr, from, until int32, valu
 		r := *a
 		r.Name = fmt.Sprintf("%s(%s)", e.Target(), a.Name)
 		r.Values = make([]float64, len(a.Values))
-		r.IsAbsent = make([]bool, len(a.Values))
+		r.IsAbsent = types.NewAbsence(len(a.Values))
 		results = append(results, function(a, &r))
 	}
 	return results, nil
@@ -114,6 +114,12 @@ type AggregateFunc func([]float64) (float64, bool)
 
 // AggregateSeries aggregates series
 func AggregateSeries(name string, args []*types.MetricData, absent_if_first_series_absent bool, absent_if_any_absent bool, function AggregateFunc) ([]*types.MetricData, error) {
+	return AggregateSeriesXFilesFactor(name, args, absent_if_first_series_absent, absent_if_any_absent, defaultXFilesFactor, function)
+}
+
+// AggregateSeriesXFilesFactor is AggregateSeries for points known in at
+// least xFilesFactor of the series
+func AggregateSeriesXFilesFactor(name string, args []*types.MetricData, absent_if_first_series_absent bool, absent_if_any_absent bool, xFilesFactor float64, function AggregateFunc) ([]*types.MetricData, error) {
 	seriesList, start, end, step, err := Normalize(args)
 	if err != nil {
 		return nil, err
@@ -123,88 +129,178 @@ func AggregateSeries(name string, args []*types.MetricData, absent_if_first_seri
 	}
 	length := int((end - start) / step)
 	result := make([]float64, length)
-	isAbsent := make([]bool, length)
+	isAbsent := types.NewAbsence(length)
+	var values []float64
 	for i := 0; i < length; i++ {
-		var values []float64
+		values = values[:0]
 		absent := false
 		for _, s := range seriesList {
-			if i < len(s.IsAbsent) && !s.IsAbsent[i] {
+			if i < s.IsAbsent.Len() && Known(s.Values[i], s.IsAbsent.Get(i)) {
 				values = append(values, s.Values[i])
 			} else {
 				absent = absent || absent_if_any_absent
 			}
 		}
 		result[i] = 0
-		isAbsent[i] = true
+		isAbsent.Set(i, true)
 
-		absent = absent || (absent_if_first_series_absent && (i >= len(seriesList[0].IsAbsent) || seriesList[0].IsAbsent[i]))
-		if len(values) > 0 && !absent {
-			result[i], isAbsent[i] = function(values)
+		absent = absent || (absent_if_first_series_absent && (i >= seriesList[0].IsAbsent.Len() || seriesList[0].IsAbsent.Get(i)))
+		if absent {
+			continue
+		}
+		if len(values) == 0 {
+			result[i], absent = EmptyAggregation()
+			isAbsent.Set(i, absent)
+		} else if XFilesFactor(len(values), len(seriesList), xFilesFactor) {
+			result[i], absent = function(values)
+			isAbsent.Set(i, absent)
 		}
 	}
-	ret := types.New(name, result, isAbsent, step, start)
+	ret := NewCombined(name, result, isAbsent, step, start, args)
 	return []*types.MetricData{ret}, nil
 }
 
-// SummarizeValues summarizes values
-func SummarizeValues(f string, values []float64) (float64, bool, error) {
-	rv := 0.0
+// percentileAggregation matches the names of percentile aggregations, e.g.
+// p95, p99.9 or percentile95
+var percentileAggregation = regexp.MustCompile(`^p(?:ercentile)?(\d+(?:\.\d+)?)$`)
+
+// aggregations are the aggregation functions of graphite-web, by name
+var aggregations = map[string]AggregateFunc{
+	"sum":      sumValues,
+	"total":    sumValues,
+	"avg":      avgValues,
+	"average":  avgValues,
+	"max":      maxValues,
+	"min":      minValues,
+	"last":     lastValues,
+	"current":  lastValues,
+	"count":    countValues,
+	"median":   medianValues,
+	"diff":     diffValues,
+	"stddev":   stddevValues,
+	"range":    rangeValues,
+	"rangeOf":  rangeValues,
+	"multiply": multiplyValues,
+}
 
-	if len(values) == 0 {
-		return 0, true, nil
+// GetAggregateFunc returns the aggregation function with the given
+// graphite-web name, e.g. "sum", "stddev" or "p95"
+func GetAggregateFunc(f string) (AggregateFunc, error) {
+	if fn, ok := aggregations[f]; ok {
+		return fn, nil
 	}
 
-	switch f {
-	case "sum", "total":
-		for _, av := range values {
-			rv += av
-		}
-	case "avg", "average":
-		for _, av := range values {
-			rv += av
-		}
-		rv /= float64(len(values))
-	case "max":
-		rv = math.Inf(-1)
-		for _, av := range values {
-			if av > rv {
-				rv = av
-			}
-		}
-	case "min":
-		rv = math.Inf(1)
-		for _, av := range values {
-			if av < rv {
-				rv = av
-			}
+	if m := percentileAggregation.FindStringSubmatch(f); m != nil {
+		percent, err := strconv.ParseFloat(m[1], 64)
+		if err != nil {
+			return nil, parser.ParseError(err.Error())
 		}
-	case "last":
-		if len(values) > 0 {
-			rv = values[len(values)-1]
+		if percent > 100 {
+			return nil, parser.ParseError(fmt.Sprintf("percentile out of range: %s", f))
 		}
-	case "count":
-		rv = float64(len(values))
-	case "median":
-		val, absent := Percentile(values, 50, true)
-		return val, absent, nil
-	default:
-		looks_like_percentile, err := regexp.MatchString(`^p\d\d?$`, f)
-		if err != nil {
-			return 0, true, err
+		return func(values []float64) (float64, bool) {
+			return Percentile(values, percent, true)
+		}, nil
+	}
+
+	return nil, parser.ParseError(fmt.Sprintf("unsupported aggregation function: %s", f))
+}
+
+// IsPercentileAggregation reports whether f names a percentile aggregation,
+// e.g. "p95" or "percentile95"
+func IsPercentileAggregation(f string) bool {
+	return percentileAggregation.MatchString(f)
+}
+
+// SummarizeValues summarizes values, by EmptyAggregation if there are none
+func SummarizeValues(f string, values []float64) (float64, bool, error) {
+	fn, err := GetAggregateFunc(f)
+	if err != nil {
+		return 0, true, err
+	}
+	if len(values) == 0 {
+		rv, absent := EmptyAggregation()
+		return rv, absent, nil
+	}
+
+	rv, absent := fn(values)
+	return rv, absent, nil
+}
+
+func sumValues(values []float64) (float64, bool) {
+	rv := 0.0
+	for _, av := range values {
+		rv += av
+	}
+	return rv, false
+}
+
+func avgValues(values []float64) (float64, bool) {
+	rv, _ := sumValues(values)
+	return rv / float64(len(values)), false
+}
+
+func maxValues(values []float64) (float64, bool) {
+	rv := math.Inf(-1)
+	for _, av := range values {
+		if av > rv {
+			rv = av
 		}
-		if looks_like_percentile {
-			f = strings.Split(f, "p")[1]
-			percent, err := strconv.ParseFloat(f, 64)
-			if err != nil {
-				return 0, true, parser.ParseError(err.Error())
-			}
-			val, absent := Percentile(values, percent, true)
-			return val, absent, nil
-		} else {
-			return 0, true, parser.ParseError(fmt.Sprintf("unsupported aggregation function: %s", f))
+	}
+	return rv, false
+}
+
+func minValues(values []float64) (float64, bool) {
+	rv := math.Inf(1)
+	for _, av := range values {
+		if av < rv {
+			rv = av
 		}
 	}
-	return rv, false, nil
+	return rv, false
+}
+
+func lastValues(values []float64) (float64, bool) {
+	return values[len(values)-1], false
+}
+
+func countValues(values []float64) (float64, bool) {
+	return float64(len(values)), false
+}
+
+func medianValues(values []float64) (float64, bool) {
+	return Percentile(values, 50, true)
+}
+
+func diffValues(values []float64) (float64, bool) {
+	rv := values[0]
+	for _, av := range values[1:] {
+		rv -= av
+	}
+	return rv, false
+}
+
+func stddevValues(values []float64) (float64, bool) {
+	avg, _ := avgValues(values)
+	diffSqr := 0.0
+	for _, av := range values {
+		diffSqr += (av - avg) * (av - avg)
+	}
+	return math.Sqrt(diffSqr / float64(len(values))), false
+}
+
+func rangeValues(values []float64) (float64, bool) {
+	max, _ := maxValues(values)
+	min, _ := minValues(values)
+	return max - min, false
+}
+
+func multiplyValues(values []float64) (float64, bool) {
+	rv := values[0]
+	for _, av := range values[1:] {
+		rv *= av
+	}
+	return rv, false
 }
 
 // ExtractMetric extracts metric out of function list
@@ -295,10 +391,10 @@ func Percentile(data []float64, percent float64, interpolate bool) (float64, boo
 }
 
 // MaxValue returns maximum from the list
-func MaxValue(f64s []float64, absent []bool) float64 {
+func MaxValue(f64s []float64, absent types.Absence) float64 {
 	m := math.Inf(-1)
 	for i, v := range f64s {
-		if absent[i] {
+		if absent.Get(i) {
 			continue
 		}
 		if v > m {
@@ -309,10 +405,10 @@ func MaxValue(f64s []float64, absent []bool) float64 {
 }
 
 // MinValue returns minimal from the list
-func MinValue(f64s []float64, absent []bool) float64 {
+func MinValue(f64s []float64, absent types.Absence) float64 {
 	m := math.Inf(1)
 	for i, v := range f64s {
-		if absent[i] {
+		if absent.Get(i) {
 			continue
 		}
 		if v < m {
@@ -323,11 +419,11 @@ func MinValue(f64s []float64, absent []bool) float64 {
 }
 
 // AvgValue returns average of list of values
-func AvgValue(f64s []float64, absent []bool) float64 {
+func AvgValue(f64s []float64, absent types.Absence) float64 {
 	var t float64
 	var elts int
 	for i, v := range f64s {
-		if absent[i] {
+		if absent.Get(i) {
 			continue
 		}
 		elts++
@@ -337,9 +433,9 @@ func AvgValue(f64s []float64, absent []bool) float64 {
 }
 
 // CurrentValue returns last non-absent value (if any), otherwise returns NaN
-func CurrentValue(f64s []float64, absent []bool) float64 {
+func CurrentValue(f64s []float64, absent types.Absence) float64 {
 	for i := len(f64s) - 1; i >= 0; i-- {
-		if !absent[i] {
+		if !absent.Get(i) {
 			return f64s[i]
 		}
 	}
@@ -348,7 +444,7 @@ func CurrentValue(f64s []float64, absent []bool) float64 {
 }
 
 // VarianceValue gets variances of list of values
-func VarianceValue(f64s []float64, absent []bool) float64 {
+func VarianceValue(f64s []float64, absent types.Absence) float64 {
 	var squareSum float64
 	var elts int
 
@@ -358,7 +454,7 @@ func VarianceValue(f64s []float64, absent []bool) float64 {
 	}
 
 	for i, v := range f64s {
-		if absent[i] {
+		if absent.Get(i) {
 			continue
 		}
 		elts++
@@ -368,10 +464,10 @@ func VarianceValue(f64s []float64, absent []bool) float64 {
 }
 
 // Vandermonde creates a Vandermonde matrix
-func Vandermonde(absent []bool, deg int) *mat.Dense {
+func Vandermonde(absent types.Absence, deg int) *mat.Dense {
 	e := []float64{}
-	for i := range absent {
-		if absent[i] {
+	for i := 0; i < absent.Len(); i++ {
+		if absent.Get(i) {
 			continue
 		}
 		v := 1
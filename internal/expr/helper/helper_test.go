@@ -0,0 +1,112 @@
+package helper
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+)
+
+func TestPercentile(t *testing.T) {
+	input := []struct {
+		name        string
+		data        []float64
+		percent     float64
+		interpolate bool
+		expected    float64
+		absent      bool
+	}{
+		{"simple", []float64{1, 2, 3}, 50, false, 2, false},
+		{"80", []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}, 80, false, 9, false},
+	}
+
+	for _, test := range input {
+		got, absent := Percentile(test.data, test.percent, test.interpolate)
+		if got != test.expected {
+			t.Errorf("Expected: %f. Got: %f. Test: %+v", test.expected, got, test)
+		}
+		if absent != test.absent {
+			t.Errorf("Expected absent: %t. Got: %t. Test: %+v", test.absent, absent, test)
+		}
+	}
+}
+
+func TestGetAggregateFuncPercentile(t *testing.T) {
+	data := []float64{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+	input := []struct {
+		name     string
+		expected float64
+		err      bool
+	}{
+		{"p50", 5.5, false},
+		{"p100", 10, false},
+		{"p99.9", 9.991, false},
+		{"percentile50", 5.5, false},
+		{"percentile0", 1, false},
+		{"p101", 0, true},
+		{"percentile", 0, true},
+		{"px", 0, true},
+	}
+
+	for _, test := range input {
+		fn, err := GetAggregateFunc(test.name)
+		if test.err {
+			if err == nil {
+				t.Errorf("Expected an error for %s", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Unexpected error for %s: %v", test.name, err)
+			continue
+		}
+		got, _ := fn(append([]float64(nil), data...))
+		if math.Abs(got-test.expected) > 1e-9 {
+			t.Errorf("Expected: %f. Got: %f. Test: %s", test.expected, got, test.name)
+		}
+	}
+}
+
+func TestXFilesFactor(t *testing.T) {
+	input := []struct {
+		nonNull      int
+		total        int
+		xFilesFactor float64
+		expected     bool
+	}{
+		{0, 0, 0, false},
+		{0, 4, 0, false},
+		{1, 4, 0, true},
+		{1, 4, 0.25, true},
+		{1, 4, 0.5, false},
+		{4, 4, 1, true},
+	}
+
+	for _, test := range input {
+		if got := XFilesFactor(test.nonNull, test.total, test.xFilesFactor); got != test.expected {
+			t.Errorf("Expected: %t. Got: %t. Test: %+v", test.expected, got, test)
+		}
+	}
+}
+
+func TestAggregateSeriesDefaultXFilesFactor(t *testing.T) {
+	defer SetDefaultXFilesFactor(DefaultXFilesFactor())
+	SetDefaultXFilesFactor(0.5)
+
+	args := []*types.MetricData{
+		types.MakeMetricData("a", []float64{1, 1, 1}, 1, 0),
+		types.MakeMetricData("b", []float64{1, 1, math.NaN()}, 1, 0),
+		types.MakeMetricData("c", []float64{1, math.NaN(), math.NaN()}, 1, 0),
+	}
+	got, err := AggregateSeries("sumSeries(a,b,c)", args, false, false, sumValues)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedAbsent := []bool{false, false, true}
+	for i, absent := range expectedAbsent {
+		if got[0].IsAbsent.Get(i) != absent {
+			t.Errorf("Expected absent: %t at %d. Got: %t", absent, i, got[0].IsAbsent.Get(i))
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package helper
+
+import (
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+)
+
+// NewCombined creates the series that results from combining inputs into
+// one, e.g. by summing or dividing them. The time range is the one given,
+// which is expected to come from Normalize or an equivalent computation over
+// all of the inputs. The rest of the metadata is derived from the inputs
+// rather than copied from the first one:
+//
+//   - the consolidation function is the one of the first input that has one
+//     set, e.g. with consolidateBy(). Inputs without one are skipped, so that
+//     a default never hides an explicit choice.
+//   - the xFilesFactor is the one of the first input that has one set, with
+//     setXFilesFactor(), likewise.
+//   - the tags are those that all inputs have with the same value, like in
+//     graphite-web. The name tag is the one of the result, unless the inputs
+//     share it.
+//   - presentation options, such as colors, stacking or the Y axis, are not
+//     carried over, since they belong to the inputs and not to the result.
+func NewCombined(name string, values []float64, isAbsent types.Absence, step, start int32, inputs []*types.MetricData) *types.MetricData {
+	r := types.New(name, values, isAbsent, step, start)
+	r.AggregateFunction = CombinedConsolidation(inputs)
+	r.XFilesFactor = CombinedXFilesFactor(inputs)
+	r.Tags = CombinedTags(name, inputs)
+
+	return r
+}
+
+// CombinedXFilesFactor returns the xFilesFactor of the first of inputs that
+// has one set, or 0 if none has.
+func CombinedXFilesFactor(inputs []*types.MetricData) float64 {
+	for _, s := range inputs {
+		if s != nil && s.XFilesFactor != 0 {
+			return s.XFilesFactor
+		}
+	}
+
+	return 0
+}
+
+// CombinedTags returns the tags all of inputs have with the same value, with
+// name as the name tag if they do not share one. It returns nil without
+// inputs, for the tags to be parsed from the name of the result.
+func CombinedTags(name string, inputs []*types.MetricData) map[string]string {
+	var tags map[string]string
+	for _, s := range inputs {
+		if s == nil {
+			continue
+		}
+		seriesTags := s.GetTags()
+		if tags == nil {
+			tags = make(map[string]string, len(seriesTags))
+			for k, v := range seriesTags {
+				tags[k] = v
+			}
+			continue
+		}
+		for k, v := range tags {
+			if sv, ok := seriesTags[k]; !ok || sv != v {
+				delete(tags, k)
+			}
+		}
+	}
+	if tags == nil {
+		return nil
+	}
+
+	if _, ok := tags["name"]; !ok {
+		tags["name"] = name
+	}
+
+	return tags
+}
+
+// CombinedConsolidation returns the consolidation function of the first of
+// inputs that has one set, or nil if none has.
+func CombinedConsolidation(inputs []*types.MetricData) func([]float64, []bool) (float64, bool) {
+	for _, s := range inputs {
+		if s != nil && s.AggregateFunction != nil {
+			return s.AggregateFunction
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,135 @@
+package helper
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+)
+
+func sameFunc(a, b interface{}) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+func TestCombinedMetadata(t *testing.T) {
+	plain := types.MakeMetricData("a", []float64{1, 2, 3, 4}, 1, 0)
+	plain.Stacked = true
+	plain.SecondYAxis = true
+
+	maxed := types.MakeMetricData("b", []float64{1, 2}, 2, 0)
+	maxed.AggregateFunction = types.AggMax
+
+	summed := types.MakeMetricData("c", []float64{1, 2, 3, 4}, 1, 0)
+	summed.AggregateFunction = types.AggSum
+
+	sum, err := GetAggregateFunc("sum")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		got      *types.MetricData
+		step     int32
+		stop     int32
+		consolFn func([]float64, []bool) (float64, bool)
+	}{
+		{
+			"combine keeps the explicit consolidation of the second series",
+			CombineSeries(plain, maxed, "combined", func(l, r float64) (float64, bool) { return l + r, false }),
+			2, 4, types.AggMax,
+		},
+		{
+			"aggregate takes the first explicit consolidation",
+			func() *types.MetricData {
+				r, _ := AggregateSeries("aggregated", []*types.MetricData{plain, maxed, summed}, false, false, sum)
+				return r[0]
+			}(),
+			2, 4, types.AggMax,
+		},
+		{
+			"no explicit consolidation",
+			func() *types.MetricData {
+				r, _ := AggregateSeries("aggregated", []*types.MetricData{plain, plain}, false, false, sum)
+				return r[0]
+			}(),
+			1, 4, nil,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			if tst.got.StepTime != tst.step || tst.got.StartTime != 0 || tst.got.StopTime != tst.stop {
+				t.Errorf("expected range 0-%d step %d, got %d-%d step %d",
+					tst.stop, tst.step, tst.got.StartTime, tst.got.StopTime, tst.got.StepTime)
+			}
+			if tst.consolFn == nil && tst.got.AggregateFunction != nil {
+				t.Error("expected no consolidation function")
+			}
+			if tst.consolFn != nil && (tst.got.AggregateFunction == nil || !sameFunc(tst.got.AggregateFunction, tst.consolFn)) {
+				t.Error("unexpected consolidation function")
+			}
+			if tst.got.Stacked || tst.got.SecondYAxis {
+				t.Error("presentation options of the inputs were carried over")
+			}
+		})
+	}
+}
+
+func TestCombinedXFilesFactorAndTags(t *testing.T) {
+	a := types.MakeMetricData("cpu;dc=ams;host=a", []float64{1, 2}, 1, 0)
+	a.Tags = types.ExtractTags(a.Name)
+	b := types.MakeMetricData("cpu;dc=ams;host=b", []float64{1, 2}, 1, 0)
+	b.Tags = types.ExtractTags(b.Name)
+	b.XFilesFactor = 0.5
+	c := types.MakeMetricData("mem;dc=ams;host=a", []float64{1, 2}, 1, 0)
+
+	tests := []struct {
+		name         string
+		inputs       []*types.MetricData
+		xFilesFactor float64
+		tags         map[string]string
+	}{
+		{
+			"the first explicit xFilesFactor and the common tags",
+			[]*types.MetricData{a, b},
+			0.5, map[string]string{"name": "cpu", "dc": "ams"},
+		},
+		{
+			"the name of the result if the inputs do not share one",
+			[]*types.MetricData{a, c},
+			0, map[string]string{"name": "combined", "dc": "ams", "host": "a"},
+		},
+		{
+			"no inputs",
+			nil,
+			0, nil,
+		},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			r := NewCombined("combined", []float64{1, 2}, types.NewAbsence(2), 1, 0, tst.inputs)
+			if r.XFilesFactor != tst.xFilesFactor {
+				t.Errorf("expected xFilesFactor %g, got %g", tst.xFilesFactor, r.XFilesFactor)
+			}
+			if !reflect.DeepEqual(r.Tags, tst.tags) {
+				t.Errorf("expected tags %v, got %v", tst.tags, r.Tags)
+			}
+		})
+	}
+
+	if a.Tags["host"] != "a" || b.Tags["host"] != "b" {
+		t.Error("the tags of the inputs were changed")
+	}
+
+	// the xFilesFactor carried over applies when the result is consolidated
+	r := NewCombined("combined", []float64{1, 0, 0, 4}, types.AbsenceOf(false, true, true, false), 1, 0, []*types.MetricData{b})
+	if got := r.Consolidate(2).IsAbsent.Bools(); !reflect.DeepEqual(got, []bool{false, false}) {
+		t.Errorf("expected buckets half known to be kept, got absence %v", got)
+	}
+	r.XFilesFactor = 0.6
+	if got := r.Consolidate(2).IsAbsent.Bools(); !reflect.DeepEqual(got, []bool{true, true}) {
+		t.Errorf("expected buckets under the xFilesFactor to be absent, got absence %v", got)
+	}
+}
@@ -0,0 +1,118 @@
+package helper
+
+import (
+	"math"
+)
+
+// MovingWindow is a window over the last points pushed, in a ring buffer,
+// that tells their sum, mean, minimum and maximum in constant time, so that
+// the moving* functions take one pass over a series whatever the size of the
+// window. The minimum and maximum are kept in monotonic deques of the points
+// that can still be one. Absent points are pushed as NaN and ignored.
+type MovingWindow struct {
+	values []float64
+	pushed int
+	known  int
+	sum    float64
+	mins   []windowPoint
+	maxs   []windowPoint
+}
+
+type windowPoint struct {
+	pos   int
+	value float64
+}
+
+// NewMovingWindow returns an empty window of size points.
+func NewMovingWindow(size int) *MovingWindow {
+	return &MovingWindow{values: make([]float64, size)}
+}
+
+// Push pushes v into the window, and out the oldest point of a full window.
+func (w *MovingWindow) Push(v float64) {
+	size := len(w.values)
+	if size == 0 {
+		return
+	}
+
+	pos := w.pushed
+	slot := pos % size
+	if pos >= size {
+		if old := w.values[slot]; !math.IsNaN(old) {
+			w.sum -= old
+			w.known--
+		}
+	}
+	w.values[slot] = v
+	w.pushed++
+
+	// points at or before the one pushed out are out of the window
+	for len(w.mins) > 0 && w.mins[0].pos <= pos-size {
+		w.mins = w.mins[1:]
+	}
+	for len(w.maxs) > 0 && w.maxs[0].pos <= pos-size {
+		w.maxs = w.maxs[1:]
+	}
+
+	if math.IsNaN(v) {
+		return
+	}
+	w.sum += v
+	w.known++
+
+	// v stays in the window longer than the points it beats, which can no
+	// longer be the minimum or maximum
+	for n := len(w.mins); n > 0 && w.mins[n-1].value >= v; n-- {
+		w.mins = w.mins[:n-1]
+	}
+	w.mins = append(w.mins, windowPoint{pos: pos, value: v})
+	for n := len(w.maxs); n > 0 && w.maxs[n-1].value <= v; n-- {
+		w.maxs = w.maxs[:n-1]
+	}
+	w.maxs = append(w.maxs, windowPoint{pos: pos, value: v})
+}
+
+// Full tells if as many points as the size of the window were pushed.
+func (w *MovingWindow) Full() bool {
+	return w.pushed >= len(w.values)
+}
+
+// Len returns the number of points of the window that are not absent.
+func (w *MovingWindow) Len() int {
+	return w.known
+}
+
+// Sum returns the sum of the points of the window, 0 if they are all absent.
+func (w *MovingWindow) Sum() float64 {
+	if w.known == 0 {
+		return 0
+	}
+	return w.sum
+}
+
+// Mean returns the mean of the points of the window, NaN if they are all
+// absent.
+func (w *MovingWindow) Mean() float64 {
+	if w.known == 0 {
+		return math.NaN()
+	}
+	return w.sum / float64(w.known)
+}
+
+// Min returns the minimum of the points of the window, NaN if they are all
+// absent.
+func (w *MovingWindow) Min() float64 {
+	if len(w.mins) == 0 {
+		return math.NaN()
+	}
+	return w.mins[0].value
+}
+
+// Max returns the maximum of the points of the window, NaN if they are all
+// absent.
+func (w *MovingWindow) Max() float64 {
+	if len(w.maxs) == 0 {
+		return math.NaN()
+	}
+	return w.maxs[0].value
+}
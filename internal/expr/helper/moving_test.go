@@ -0,0 +1,74 @@
+package helper
+
+import (
+	"math"
+	"math/rand"
+	"strconv"
+	"testing"
+)
+
+func TestMovingWindow(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	values := make([]float64, 1000)
+	for i := range values {
+		values[i] = float64(rnd.Intn(100))
+		if rnd.Intn(5) == 0 {
+			values[i] = math.NaN()
+		}
+	}
+
+	for _, size := range []int{1, 2, 7, 64} {
+		w := NewMovingWindow(size)
+		for i, v := range values {
+			w.Push(v)
+
+			var known int
+			sum, min, max := 0.0, math.NaN(), math.NaN()
+			for j := i - size + 1; j <= i; j++ {
+				if j < 0 || math.IsNaN(values[j]) {
+					continue
+				}
+				known++
+				sum += values[j]
+				if math.IsNaN(min) || values[j] < min {
+					min = values[j]
+				}
+				if math.IsNaN(max) || values[j] > max {
+					max = values[j]
+				}
+			}
+
+			if w.Full() != (i+1 >= size) || w.Len() != known || w.Sum() != sum ||
+				!sameFloat(w.Min(), min) || !sameFloat(w.Max(), max) {
+				t.Fatalf("size %d, point %d: got len %d sum %g min %g max %g, expected len %d sum %g min %g max %g",
+					size, i, w.Len(), w.Sum(), w.Min(), w.Max(), known, sum, min, max)
+			}
+			if mean := sum / float64(known); !sameFloat(w.Mean(), mean) {
+				t.Fatalf("size %d, point %d: got mean %g, expected %g", size, i, w.Mean(), mean)
+			}
+		}
+	}
+}
+
+func sameFloat(a, b float64) bool {
+	return a == b || math.IsNaN(a) && math.IsNaN(b)
+}
+
+func BenchmarkMovingWindowMax(b *testing.B) {
+	values := make([]float64, 86400)
+	for i := range values {
+		values[i] = float64(i % 1000)
+	}
+
+	for _, size := range []int{60, 3600} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				w := NewMovingWindow(size)
+				for _, v := range values {
+					w.Push(v)
+					_ = w.Max()
+				}
+			}
+		})
+	}
+}
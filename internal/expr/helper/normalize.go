@@ -1,7 +1,7 @@
 package helper
 
 import (
-	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
 )
 
 func Normalize(args []*types.MetricData) ([]*types.MetricData, int32, int32, int32, error) {
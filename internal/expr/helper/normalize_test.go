@@ -6,7 +6,7 @@ import (
 	"testing"
 	"time"
 
-	"github.com/bookingcom/carbonapi/expr/types"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
 	"github.com/google/go-cmp/cmp"
 )
 
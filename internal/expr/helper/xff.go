@@ -0,0 +1,47 @@
+package helper
+
+import (
+	"fmt"
+
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+// defaultXFilesFactor is the xFilesFactor of functions that are not given
+// one, like DEFAULT_XFILES_FACTOR in graphite-web
+var defaultXFilesFactor float64
+
+// SetDefaultXFilesFactor sets the xFilesFactor used by functions that are
+// not given one
+func SetDefaultXFilesFactor(xFilesFactor float64) {
+	defaultXFilesFactor = xFilesFactor
+}
+
+// DefaultXFilesFactor returns the xFilesFactor used by functions that are
+// not given one
+func DefaultXFilesFactor() float64 {
+	return defaultXFilesFactor
+}
+
+// GetXFilesFactor returns the xFilesFactor argument of e, either named or
+// at position n, or the default one
+func GetXFilesFactor(e parser.Expr, n int) (float64, error) {
+	xFilesFactor, err := e.GetFloatNamedOrPosArgDefault("xFilesFactor", n, defaultXFilesFactor)
+	if err != nil {
+		return 0, err
+	}
+	if xFilesFactor < 0 || xFilesFactor > 1 {
+		return 0, fmt.Errorf("%s: %w: xFilesFactor must be between 0 and 1, got %g", e.Target(), parser.ErrInvalidArgumentValue, xFilesFactor)
+	}
+
+	return xFilesFactor, nil
+}
+
+// XFilesFactor reports whether nonNull known values out of total are enough
+// to aggregate them, that is if at least xFilesFactor of them are known
+func XFilesFactor(nonNull, total int, xFilesFactor float64) bool {
+	if nonNull == 0 || total == 0 {
+		return false
+	}
+
+	return float64(nonNull)/float64(total) >= xFilesFactor
+}
@@ -0,0 +1,102 @@
+package interfaces
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type timeZoneKey struct{}
+
+// WithTimeZone returns a copy of ctx that makes the functions evaluated with
+// it work in loc, as asked for with the tz parameter of a request.
+func WithTimeZone(ctx context.Context, loc *time.Location) context.Context {
+	return context.WithValue(ctx, timeZoneKey{}, loc)
+}
+
+// TimeZone returns the time zone functions evaluated with ctx work in.
+// Defaults to UTC.
+func TimeZone(ctx context.Context) *time.Location {
+	if loc, ok := ctx.Value(timeZoneKey{}).(*time.Location); ok && loc != nil {
+		return loc
+	}
+
+	return time.UTC
+}
+
+// FetchStats describe the series fetched for the metrics of the expression
+// a function is evaluated on.
+type FetchStats struct {
+	// Series is the number of series fetched, and Points the number of
+	// their points.
+	Series int
+	Points int
+	// MinStep and MaxStep are the smallest and the largest steps of the
+	// series.
+	MinStep int32
+	MaxStep int32
+	// StartTime is the earliest start of the series, and StopTime the
+	// latest stop.
+	StartTime int32
+	StopTime  int32
+}
+
+type fetchStatsKey struct{}
+
+// WithFetchStats returns a copy of ctx that gives the functions evaluated
+// with it the stats returned by stats, which is called once, the first time
+// they are asked for.
+func WithFetchStats(ctx context.Context, stats func() FetchStats) context.Context {
+	var once sync.Once
+	var s FetchStats
+
+	return context.WithValue(ctx, fetchStatsKey{}, func() FetchStats {
+		once.Do(func() { s = stats() })
+		return s
+	})
+}
+
+// GetFetchStats returns the stats of the series fetched for the expression
+// evaluated with ctx, and whether there are any.
+func GetFetchStats(ctx context.Context) (FetchStats, bool) {
+	if stats, ok := ctx.Value(fetchStatsKey{}).(func() FetchStats); ok {
+		return stats(), true
+	}
+
+	return FetchStats{}, false
+}
+
+// Stacks are the running totals of the stacks of a request, by stack name.
+type Stacks struct {
+	mu     sync.Mutex
+	totals map[string][]float64
+}
+
+type stacksKey struct{}
+
+// WithStacks returns a copy of ctx that makes the series stacked by the
+// functions evaluated with it add up by stack name, across the targets of a
+// request, as graphite-web does.
+func WithStacks(ctx context.Context) context.Context {
+	return context.WithValue(ctx, stacksKey{}, &Stacks{totals: make(map[string][]float64)})
+}
+
+// GetStacks returns the stacks of the request of ctx, or nil if the totals
+// are not kept beyond a single function call.
+func GetStacks(ctx context.Context) *Stacks {
+	s, _ := ctx.Value(stacksKey{}).(*Stacks)
+	return s
+}
+
+// Stack calls f with the running total of the stack name, and keeps the total
+// f returns. f is called with a nil total the first time, and s may be nil.
+func (s *Stacks) Stack(name string, f func(total []float64) []float64) {
+	if s == nil {
+		f(nil)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.totals[name] = f(s.totals[name])
+}
@@ -3,8 +3,8 @@ package interfaces
 import (
 	"context"
 
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 type GetTargetData func(ctx context.Context, exp parser.Expr, from, until int32, metricMap map[parser.MetricRequest][]*types.MetricData) (error, int)
@@ -29,10 +29,15 @@ type Evaluator interface {
 	EvalExpr(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData GetTargetData) ([]*types.MetricData, error)
 }
 
+// Order is the order functions are registered in. Functions of order Last
+// are registered after those of order Any, and replace those of the same
+// name.
 type Order int
 
 const (
+	// Any registers a function in no particular order
 	Any Order = iota
+	// Last registers a function after the others
 	Last
 )
 
@@ -49,3 +54,13 @@ type Function interface {
 	Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData GetTargetData) ([]*types.MetricData, error)
 	Description() map[string]types.FunctionDescription
 }
+
+// RewriteFunction is implemented by the functions that rewrite their call
+// into other targets before the expression is evaluated, e.g. from the names
+// of the series of an argument, like applyByNode. The targets are evaluated
+// in place of the call, their series concatenated, and Do is only called if
+// the call is not rewritten: Rewrite may return no targets to leave it as it
+// is.
+type RewriteFunction interface {
+	Rewrite(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData GetTargetData) ([]string, error)
+}
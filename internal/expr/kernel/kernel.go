@@ -0,0 +1,167 @@
+// Package kernel has the loops that aggregate series point by point, for the
+// functions that combine many series. The points of a series are a dense
+// slice of float64 in which absent points are NaN, rather than a slice of
+// values and a slice of absent flags, and the series are added one at a time
+// to the columns of the result, in loops unrolled by 4 without allocations.
+package kernel
+
+import (
+	"math"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// Op is an aggregation of the points of a column.
+type Op int
+
+const (
+	// Sum is the sum of the known points.
+	Sum Op = iota
+	// Mean is the mean of the known points.
+	Mean
+	// Min is the minimum of the known points.
+	Min
+	// Max is the maximum of the known points.
+	Max
+	// Count is the number of known points.
+	Count
+)
+
+// Aggregator aggregates series by op, column by column.
+type Aggregator struct {
+	op    Op
+	acc   []float64
+	known []float64
+}
+
+// New returns an aggregator of series of n points.
+func New(op Op, n int) *Aggregator {
+	a := &Aggregator{
+		op:    op,
+		acc:   make([]float64, n),
+		known: make([]float64, n),
+	}
+	if op == Min || op == Max {
+		for i := range a.acc {
+			a.acc[i] = math.NaN()
+		}
+	}
+
+	return a
+}
+
+// Add adds the points of a series, absent ones NaN, to the columns. The
+// points past the columns are ignored, and the columns past the points are
+// absent for the series.
+func (a *Aggregator) Add(points []float64) {
+	n := len(a.acc)
+	if len(points) < n {
+		n = len(points)
+	}
+	acc, known, points := a.acc[:n], a.known[:n], points[:n]
+
+	switch a.op {
+	case Min:
+		addMin(acc, known, points)
+	case Max:
+		addMax(acc, known, points)
+	default:
+		addSum(acc, known, points)
+	}
+}
+
+// Known returns the number of known points of column i.
+func (a *Aggregator) Known(i int) int {
+	return int(a.known[i])
+}
+
+// Value returns the aggregation of column i, meaningless if none of its
+// points are known.
+func (a *Aggregator) Value(i int) float64 {
+	switch a.op {
+	case Mean:
+		return a.acc[i] / a.known[i]
+	case Count:
+		return a.known[i]
+	default:
+		return a.acc[i]
+	}
+}
+
+func addSum(acc, known, points []float64) {
+	i := 0
+	for ; i+4 <= len(points); i += 4 {
+		p := points[i : i+4 : i+4]
+		s := acc[i : i+4 : i+4]
+		k := known[i : i+4 : i+4]
+		if p[0] == p[0] {
+			s[0] += p[0]
+			k[0]++
+		}
+		if p[1] == p[1] {
+			s[1] += p[1]
+			k[1]++
+		}
+		if p[2] == p[2] {
+			s[2] += p[2]
+			k[2]++
+		}
+		if p[3] == p[3] {
+			s[3] += p[3]
+			k[3]++
+		}
+	}
+	for ; i < len(points); i++ {
+		if v := points[i]; v == v {
+			acc[i] += v
+			known[i]++
+		}
+	}
+}
+
+// addMin keeps the minimum in acc, NaN until a point is known.
+func addMin(acc, known, points []float64) {
+	for i, v := range points {
+		if v == v {
+			if !(v >= acc[i]) {
+				acc[i] = v
+			}
+			known[i]++
+		}
+	}
+}
+
+// addMax keeps the maximum in acc, NaN until a point is known.
+func addMax(acc, known, points []float64) {
+	for i, v := range points {
+		if v == v {
+			if !(v <= acc[i]) {
+				acc[i] = v
+			}
+			known[i]++
+		}
+	}
+}
+
+// Points fills dst with values, NaN where absent, and returns it, grown
+// if it is too short to hold them. Infinite values are NaN too if
+// infinityAbsent. Values without an absent flag are left out.
+func Points(dst, values []float64, absent types.Absence, infinityAbsent bool) []float64 {
+	n := len(values)
+	if absent.Len() < n {
+		n = absent.Len()
+	}
+	if cap(dst) < n {
+		dst = make([]float64, n)
+	}
+	dst = dst[:n]
+
+	copy(dst, values)
+	for i := range dst {
+		if absent.Get(i) || infinityAbsent && math.IsInf(dst[i], 0) {
+			dst[i] = math.NaN()
+		}
+	}
+
+	return dst
+}
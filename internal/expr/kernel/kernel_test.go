@@ -0,0 +1,62 @@
+package kernel
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestAggregator(t *testing.T) {
+	nan := math.NaN()
+	series := [][]float64{
+		{1, nan, 3, 4, 5, nan},
+		{2, nan, nan, 1, 7, 6},
+		{3, nan, 1},
+	}
+
+	tests := []struct {
+		op   Op
+		want []float64
+	}{
+		{Sum, []float64{6, nan, 4, 5, 12, 6}},
+		{Mean, []float64{2, nan, 2, 2.5, 6, 6}},
+		{Min, []float64{1, nan, 1, 1, 5, 6}},
+		{Max, []float64{3, nan, 3, 4, 7, 6}},
+		{Count, []float64{3, nan, 2, 2, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		a := New(tt.op, 6)
+		for _, s := range series {
+			a.Add(s)
+		}
+
+		for i, want := range tt.want {
+			if math.IsNaN(want) {
+				if a.Known(i) != 0 {
+					t.Errorf("op %d, column %d: expected no known points, got %d", tt.op, i, a.Known(i))
+				}
+				continue
+			}
+			if got := a.Value(i); got != want {
+				t.Errorf("op %d, column %d: got %g, expected %g", tt.op, i, got, want)
+			}
+		}
+	}
+}
+
+func TestPoints(t *testing.T) {
+	values := []float64{1, 2, math.Inf(1), 4}
+	absent := types.AbsenceOf(false, true, false)
+
+	got := Points(nil, values, absent, true)
+	if len(got) != 3 || got[0] != 1 || !math.IsNaN(got[1]) || !math.IsNaN(got[2]) {
+		t.Errorf("unexpected points %v", got)
+	}
+
+	got = Points(got, values, absent, false)
+	if len(got) != 3 || !math.IsInf(got[2], 1) {
+		t.Errorf("unexpected points %v", got)
+	}
+}
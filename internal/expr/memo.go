@@ -0,0 +1,94 @@
+package expr
+
+import (
+	"context"
+	"reflect"
+	"sync"
+
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+type memoKey struct {
+	target      string
+	from, until int32
+}
+
+// memo holds the results of the function calls evaluated on the series of a
+// request
+type memo struct {
+	sync.Mutex
+	values  uintptr
+	results map[memoKey][]*types.MetricData
+}
+
+type memoCtxKey struct{}
+
+// WithMemo returns a context in which EvalExpr computes each function call
+// on values once for a given time range, e.g. the base expression repeated
+// across the targets of a dashboard. Each call gets a copy of the results,
+// which it may change. Calls on other series, like the groups of
+// groupByNode, are not memoized.
+func WithMemo(ctx context.Context, values map[parser.MetricRequest][]*types.MetricData) context.Context {
+	return context.WithValue(ctx, memoCtxKey{}, &memo{
+		values:  reflect.ValueOf(values).Pointer(),
+		results: make(map[memoKey][]*types.MetricData),
+	})
+}
+
+// getMemo returns the memo of ctx for the calls on values, if any
+func getMemo(ctx context.Context, values map[parser.MetricRequest][]*types.MetricData) *memo {
+	m, _ := ctx.Value(memoCtxKey{}).(*memo)
+	if m == nil || m.values != reflect.ValueOf(values).Pointer() {
+		return nil
+	}
+	return m
+}
+
+func (m *memo) get(key memoKey) ([]*types.MetricData, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	res, ok := m.results[key]
+	if !ok {
+		return nil, false
+	}
+
+	return copyResults(res), true
+}
+
+// set keeps a copy of res, for the caller to change res as it does the
+// copies it gets.
+func (m *memo) set(key memoKey, res []*types.MetricData) {
+	m.Lock()
+	defer m.Unlock()
+
+	m.results[key] = copyResults(res)
+}
+
+func copyResults(res []*types.MetricData) []*types.MetricData {
+	c := make([]*types.MetricData, len(res))
+	for i, r := range res {
+		c[i] = r.Copy()
+	}
+
+	return c
+}
+
+// stacks tells whether e calls stacked, whose results depend on the calls
+// before it and are not to be memoized.
+func stacks(e parser.Expr) bool {
+	if !e.IsFunc() {
+		return false
+	}
+	if e.Target() == "stacked" {
+		return true
+	}
+	for _, a := range e.Args() {
+		if stacks(a) {
+			return true
+		}
+	}
+
+	return false
+}
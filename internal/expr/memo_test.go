@@ -0,0 +1,117 @@
+package expr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+func TestMemo(t *testing.T) {
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo.*", From: 0, Until: 1}: {
+			types.MakeMetricData("foo.a", []float64{1, 4, 9}, 1, 0),
+			types.MakeMetricData("foo.b", []float64{4, 9, 16}, 1, 0),
+		},
+	}
+	ctx := WithMemo(context.Background(), values)
+
+	eval := func(target string, values map[parser.MetricRequest][]*types.MetricData) []*types.MetricData {
+		exp, _, err := parser.ParseExpr(target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := EvalExpr(ctx, exp, 0, 1, values, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	before := functionStatsByName("squareRoot")
+	first := eval("squareRoot(foo.*)", values)
+	second := eval("sumSeries(squareRoot(foo.*))", values)
+	third := eval("squareRoot(foo.*)", values)
+	if calls := functionStatsByName("squareRoot").Calls - before.Calls; calls != 1 {
+		t.Errorf("expected squareRoot to be evaluated once, got %d", calls)
+	}
+	if len(third) != 2 || !equalValues(third[0].Values, first[0].Values) || !equalValues(third[1].Values, first[1].Values) {
+		t.Errorf("expected the memoized result, got %v", third)
+	}
+
+	// results are copies, which callers may change
+	third[0].Values[0] = 100
+	third[0].Name = "changed"
+	fourth := eval("squareRoot(foo.*)", values)
+	if fourth[0] == third[0] || fourth[0].Values[0] != 1 || fourth[0].Name != first[0].Name {
+		t.Errorf("expected a copy of the memoized result, got %v", fourth[0])
+	}
+	if first[0].Values[0] != 1 {
+		t.Errorf("expected the first result to be left alone, got %v", first[0])
+	}
+	if want := []float64{3, 5, 7}; len(second) != 1 || !equalValues(second[0].Values, want) {
+		t.Errorf("expected %v, got %v", want, second)
+	}
+
+	// the same call on other series is evaluated again
+	other := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo.*", From: 0, Until: 1}: {
+			types.MakeMetricData("foo.a", []float64{16}, 1, 0),
+		},
+	}
+	before = functionStatsByName("squareRoot")
+	res := eval("squareRoot(foo.*)", other)
+	if calls := functionStatsByName("squareRoot").Calls - before.Calls; calls != 1 {
+		t.Errorf("expected squareRoot to be evaluated on other series, got %d calls", calls)
+	}
+	if len(res) != 1 || res[0].Values[0] != 4 {
+		t.Errorf("unexpected result %v on other series", res)
+	}
+}
+
+func TestMemoStacked(t *testing.T) {
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo.a", From: 0, Until: 1}: {types.MakeMetricData("foo.a", []float64{1, 2}, 1, 0)},
+		{Metric: "foo.b", From: 0, Until: 1}: {types.MakeMetricData("foo.b", []float64{10, 20}, 1, 0)},
+	}
+	ctx := WithMemo(interfaces.WithStacks(context.Background()), values)
+
+	// the targets of a request add up to the totals of their stacks
+	tests := []struct {
+		target string
+		want   []float64
+	}{
+		{"stacked(foo.a)", []float64{1, 2}},
+		{"stacked(foo.b)", []float64{11, 22}},
+		{"stacked(foo.a, 'tx')", []float64{1, 2}},
+		{"alias(stacked(foo.a), 'a')", []float64{12, 24}},
+		{"stacked(foo.a)", []float64{13, 26}},
+	}
+	for _, tt := range tests {
+		exp, _, err := parser.ParseExpr(tt.target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := EvalExpr(ctx, exp, 0, 1, values, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(res) != 1 || !equalValues(res[0].Values, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.target, tt.want, res)
+		}
+	}
+}
+
+func equalValues(got, want []float64) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,93 @@
+package expr
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+// maxRewrites bounds the rewrites of an expression, as functions may
+// rewrite into calls of rewrite functions.
+const maxRewrites = 16
+
+// RewriteExpr is the first pass of evaluation, before EvalExpr: it replaces
+// the calls of rewrite functions in e by the targets they rewrite into,
+// grouped if there are several, and gets their data. It returns e as it is
+// if nothing is rewritten.
+//
+// Only the calls whose results are the series of the target are rewritten,
+// i.e. e itself or the arguments of group: other functions name their
+// results after the text of their arguments, which must stay the one
+// written. Their calls of rewrite functions are evaluated in place.
+func RewriteExpr(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) (parser.Expr, error) {
+	for i := 0; i < maxRewrites; i++ {
+		target, rewritten, err := rewrite(ctx, e, from, until, values, getTargetData)
+		if err != nil || !rewritten {
+			return e, err
+		}
+
+		next, _, err := parser.ParseExpr(target)
+		if err != nil {
+			return nil, fmt.Errorf("%s is rewritten into %s: %w", e.ToString(), target, err)
+		}
+		if err, _ := getTargetData(ctx, next, from, until, values); err != nil {
+			return nil, err
+		}
+		e = next
+	}
+
+	return nil, fmt.Errorf("%s is rewritten more than %d times", e.ToString(), maxRewrites)
+}
+
+// rewrite returns e with the calls of rewrite functions rewritten, and
+// whether there were any.
+func rewrite(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) (string, bool, error) {
+	if !e.IsFunc() {
+		return e.ToCanonicalString(), false, nil
+	}
+
+	metadata.FunctionMD.RLock()
+	f := metadata.FunctionMD.Functions[e.Target()]
+	metadata.FunctionMD.RUnlock()
+
+	if rf, ok := f.(interfaces.RewriteFunction); ok {
+		targets, err := rf.Rewrite(ctx, e, from, until, values, getTargetData)
+		if err != nil {
+			return "", false, err
+		}
+		switch len(targets) {
+		case 0:
+			return e.ToString(), false, nil
+		case 1:
+			return targets[0], true, nil
+		default:
+			return "group(" + strings.Join(targets, ",") + ")", true, nil
+		}
+	}
+
+	if e.Target() != "group" {
+		return e.ToString(), false, nil
+	}
+
+	var args []string
+	rewritten := false
+	for _, arg := range e.Args() {
+		s, r, err := rewrite(ctx, arg, from, until, values, getTargetData)
+		if err != nil {
+			return "", false, err
+		}
+		args = append(args, s)
+		rewritten = rewritten || r
+	}
+	if !rewritten {
+		// as it is written
+		return e.ToString(), false, nil
+	}
+
+	return e.Target() + "(" + strings.Join(args, ",") + ")", true, nil
+}
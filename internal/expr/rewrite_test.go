@@ -0,0 +1,146 @@
+package expr
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+func TestRewriteExpr(t *testing.T) {
+	stored := map[string][]*types.MetricData{
+		"servers.s*.disk.bytes_free": {
+			types.MakeMetricData("servers.s1.disk.bytes_free", []float64{90, 80, 70}, 1, 0),
+			types.MakeMetricData("servers.s2.disk.bytes_free", []float64{99, 98, 97}, 1, 0),
+		},
+		"servers.s1.disk.bytes_*": {
+			types.MakeMetricData("servers.s1.disk.bytes_free", []float64{90, 80, 70}, 1, 0),
+			types.MakeMetricData("servers.s1.disk.bytes_used", []float64{10, 20, 30}, 1, 0),
+		},
+		"servers.s2.disk.bytes_*": {
+			types.MakeMetricData("servers.s2.disk.bytes_free", []float64{99, 98, 97}, 1, 0),
+			types.MakeMetricData("servers.s2.disk.bytes_used", []float64{1, 2, 3}, 1, 0),
+		},
+	}
+
+	var fetched []string
+	getTargetData := func(ctx context.Context, exp parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) (error, int) {
+		for _, m := range exp.Metrics() {
+			r := parser.MetricRequest{Metric: m.Metric, From: int64(from), Until: int64(until)}
+			if _, ok := values[r]; !ok {
+				fetched = append(fetched, m.Metric)
+				values[r] = stored[m.Metric]
+			}
+		}
+		return nil, 0
+	}
+
+	exp, _, err := parser.ParseExpr("group(applyByNode(servers.s*.disk.bytes_free, 1, 'sumSeries(%.disk.bytes_*)', \"%'s disk\"))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[parser.MetricRequest][]*types.MetricData)
+	getTargetData(context.Background(), exp, 0, 3, values)
+
+	rewritten, err := RewriteExpr(context.Background(), exp, 0, 3, values, getTargetData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `group(group(alias(sumSeries(servers.s1.disk.bytes_*),"servers.s1's disk"),alias(sumSeries(servers.s2.disk.bytes_*),"servers.s2's disk")))`
+	if got := rewritten.ToString(); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if len(fetched) != 3 {
+		t.Errorf("expected the series of the rewritten targets to be fetched, got %v", fetched)
+	}
+
+	results, err := EvalExpr(context.Background(), rewritten, 0, 3, values, getTargetData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Name != "servers.s1's disk" || results[0].Values[0] != 100 ||
+		results[1].Name != "servers.s2's disk" || results[1].Values[0] != 100 {
+		t.Errorf("expected the sum of the bytes of each server, got %v", results)
+	}
+}
+
+// The calls of rewrite functions in the arguments of other functions are not
+// rewritten, as the names of the results would change.
+func TestRewriteExprOuterNames(t *testing.T) {
+	getTargetData := func(ctx context.Context, exp parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) (error, int) {
+		for _, m := range exp.Metrics() {
+			r := parser.MetricRequest{Metric: m.Metric, From: int64(from), Until: int64(until)}
+			switch m.Metric {
+			case "servers.s*.disk.bytes_free":
+				values[r] = []*types.MetricData{
+					types.MakeMetricData("servers.s1.disk.bytes_free", []float64{90}, 1, 0),
+					types.MakeMetricData("servers.s2.disk.bytes_free", []float64{99}, 1, 0),
+				}
+			default:
+				values[r] = []*types.MetricData{
+					types.MakeMetricData(strings.Replace(m.Metric, "*", "used", 1), []float64{10}, 1, 0),
+				}
+			}
+		}
+		return nil, 0
+	}
+
+	target := "sumSeries(applyByNode(servers.s*.disk.bytes_free, 1, 'sumSeries(%.disk.bytes_*)'))"
+	exp, _, err := parser.ParseExpr(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[parser.MetricRequest][]*types.MetricData)
+	getTargetData(context.Background(), exp, 0, 1, values)
+
+	rewritten, err := RewriteExpr(context.Background(), exp, 0, 1, values, getTargetData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := EvalExpr(context.Background(), rewritten, 0, 1, values, getTargetData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "sumSeries(applyByNode(servers.s*.disk.bytes_free, 1, 'sumSeries(%.disk.bytes_*)'))"
+	if len(results) != 1 || results[0].Name != expected {
+		t.Fatalf("expected a single series named %s, got %v", expected, results)
+	}
+	if results[0].Values[0] != 20 {
+		t.Errorf("expected the sum of the used bytes, got %v", results[0].Values)
+	}
+}
+
+func TestRewriteExprNothing(t *testing.T) {
+	exp, _, err := parser.ParseExpr("sumSeries(foo,  scale(bar, 2))")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rewritten, err := RewriteExpr(context.Background(), exp, 0, 1, nil, noopGetTargetData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rewritten != exp {
+		t.Errorf("expected %s not to be rewritten, got %s", exp.ToString(), rewritten.ToString())
+	}
+}
+
+func TestRewriteExprInvalid(t *testing.T) {
+	exp, _, err := parser.ParseExpr("applyByNode(servers.s*.disk.bytes_free, 1, 'sumSeries(%.disk.bytes_*')")
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "servers.s*.disk.bytes_free", From: 0, Until: 1}: {
+			types.MakeMetricData("servers.s1.disk.bytes_free", []float64{90}, 1, 0),
+		},
+	}
+
+	if _, err := RewriteExpr(context.Background(), exp, 0, 1, values, noopGetTargetData); err == nil {
+		t.Error("expected the rewritten target not to parse")
+	}
+}
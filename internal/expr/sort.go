@@ -5,8 +5,8 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 // type for sorting a list of metrics by the nth part of the metric name.
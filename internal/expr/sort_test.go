@@ -3,8 +3,8 @@ package expr
 import (
 	"testing"
 
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 )
 
 func TestSortMetrics(t *testing.T) {
@@ -0,0 +1,145 @@
+package expr
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+)
+
+// FunctionStats are the execution statistics of a graphite function.
+// Time is the wall time spent in the function itself, not counting the
+// evaluation of the functions in its arguments.
+type FunctionStats struct {
+	Name         string `json:"name"`
+	Calls        int64  `json:"calls"`
+	Errors       int64  `json:"errors"`
+	TimeNS       int64  `json:"timeNs"`
+	OutputSeries int64  `json:"outputSeries"`
+	OutputPoints int64  `json:"outputPoints"`
+}
+
+type functionStats struct {
+	sync.RWMutex
+	byName map[string]*FunctionStats
+}
+
+var stats = functionStats{
+	byName: make(map[string]*FunctionStats),
+}
+
+func (s *functionStats) get(name string) *FunctionStats {
+	s.RLock()
+	fs, ok := s.byName[name]
+	s.RUnlock()
+	if ok {
+		return fs
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	if fs, ok = s.byName[name]; !ok {
+		fs = &FunctionStats{Name: name}
+		s.byName[name] = fs
+	}
+	return fs
+}
+
+// GetFunctionStats returns a snapshot of the statistics of all the functions
+// evaluated so far, sorted by name.
+func GetFunctionStats() []FunctionStats {
+	stats.RLock()
+	defer stats.RUnlock()
+
+	res := make([]FunctionStats, 0, len(stats.byName))
+	for _, fs := range stats.byName {
+		res = append(res, FunctionStats{
+			Name:         fs.Name,
+			Calls:        atomic.LoadInt64(&fs.Calls),
+			Errors:       atomic.LoadInt64(&fs.Errors),
+			TimeNS:       atomic.LoadInt64(&fs.TimeNS),
+			OutputSeries: atomic.LoadInt64(&fs.OutputSeries),
+			OutputPoints: atomic.LoadInt64(&fs.OutputPoints),
+		})
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].Name < res[j].Name })
+
+	return res
+}
+
+// evalFrame accumulates the time spent evaluating the nested functions of a
+// function call, so that it can be excluded from the time of the call.
+type evalFrame struct {
+	childNS int64
+}
+
+type evalFrameKey struct{}
+
+// evalWithStats calls do and records its statistics under name.
+func evalWithStats(ctx context.Context, name string, do func(context.Context) ([]*types.MetricData, error)) ([]*types.MetricData, error) {
+	parent, _ := ctx.Value(evalFrameKey{}).(*evalFrame)
+	frame := &evalFrame{}
+
+	t0 := time.Now()
+	res, err := do(context.WithValue(ctx, evalFrameKey{}, frame))
+	total := time.Since(t0).Nanoseconds()
+
+	if parent != nil {
+		atomic.AddInt64(&parent.childNS, total)
+	}
+
+	fs := stats.get(name)
+	atomic.AddInt64(&fs.Calls, 1)
+	atomic.AddInt64(&fs.TimeNS, total-atomic.LoadInt64(&frame.childNS))
+	if err != nil {
+		atomic.AddInt64(&fs.Errors, 1)
+		return res, err
+	}
+
+	points := 0
+	for _, r := range res {
+		points += len(r.Values)
+	}
+	atomic.AddInt64(&fs.OutputSeries, int64(len(res)))
+	atomic.AddInt64(&fs.OutputPoints, int64(points))
+
+	return res, err
+}
+
+// fetchStats returns the stats of the series of values fetched for the
+// metrics of e, evaluated from until.
+func fetchStats(e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) interfaces.FetchStats {
+	var s interfaces.FetchStats
+	seen := make(map[parser.MetricRequest]bool)
+	for _, m := range e.Metrics() {
+		r := parser.MetricRequest{Metric: m.Metric, From: m.From + int64(from), Until: m.Until + int64(until)}
+		if seen[r] {
+			continue
+		}
+		seen[r] = true
+
+		for _, series := range values[r] {
+			if s.Series == 0 || series.StepTime < s.MinStep {
+				s.MinStep = series.StepTime
+			}
+			if s.Series == 0 || series.StepTime > s.MaxStep {
+				s.MaxStep = series.StepTime
+			}
+			if s.Series == 0 || series.StartTime < s.StartTime {
+				s.StartTime = series.StartTime
+			}
+			if s.Series == 0 || series.StopTime > s.StopTime {
+				s.StopTime = series.StopTime
+			}
+			s.Series++
+			s.Points += len(series.Values)
+		}
+	}
+
+	return s
+}
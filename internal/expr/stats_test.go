@@ -0,0 +1,129 @@
+package expr
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+
+	"go.uber.org/zap"
+)
+
+func functionStatsByName(name string) FunctionStats {
+	for _, fs := range GetFunctionStats() {
+		if fs.Name == name {
+			return fs
+		}
+	}
+	return FunctionStats{Name: name}
+}
+
+func TestFunctionStats(t *testing.T) {
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo.*", From: 0, Until: 1}: {
+			types.MakeMetricData("foo.a", []float64{1, -2, 3}, 1, 0),
+			types.MakeMetricData("foo.b", []float64{-1, 2, -3}, 1, 0),
+		},
+	}
+
+	absBefore := functionStatsByName("absolute")
+	sumBefore := functionStatsByName("sumSeries")
+
+	exp, _, err := parser.ParseExpr("sumSeries(absolute(foo.*))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EvalExpr(context.Background(), exp, 0, 1, values, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	exp, _, err = parser.ParseExpr("absolute(foo.bar)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EvalExpr(context.Background(), exp, 0, 1, values, nil); err == nil {
+		t.Fatal("expected an error for a missing series")
+	}
+
+	abs := functionStatsByName("absolute")
+	sum := functionStatsByName("sumSeries")
+
+	if got := abs.Calls - absBefore.Calls; got != 2 {
+		t.Errorf("expected 2 calls of absolute, got %d", got)
+	}
+	if got := abs.Errors - absBefore.Errors; got != 1 {
+		t.Errorf("expected 1 error of absolute, got %d", got)
+	}
+	if got := abs.OutputSeries - absBefore.OutputSeries; got != 2 {
+		t.Errorf("expected 2 series from absolute, got %d", got)
+	}
+	if got := abs.OutputPoints - absBefore.OutputPoints; got != 6 {
+		t.Errorf("expected 6 points from absolute, got %d", got)
+	}
+	if got := sum.Calls - sumBefore.Calls; got != 1 {
+		t.Errorf("expected 1 call of sumSeries, got %d", got)
+	}
+	if got := sum.OutputSeries - sumBefore.OutputSeries; got != 1 {
+		t.Errorf("expected 1 series from sumSeries, got %d", got)
+	}
+	if sum.TimeNS < 0 || abs.TimeNS < 0 {
+		t.Errorf("got negative function time: sumSeries %d, absolute %d", sum.TimeNS, abs.TimeNS)
+	}
+}
+
+// fetchStatsProbe keeps the fetch stats it is evaluated with.
+type fetchStatsProbe struct {
+	interfaces.FunctionBase
+	stats interfaces.FetchStats
+	ok    bool
+}
+
+func (f *fetchStatsProbe) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	f.stats, f.ok = interfaces.GetFetchStats(ctx)
+	return nil, nil
+}
+
+func (f *fetchStatsProbe) Description() map[string]types.FunctionDescription {
+	return nil
+}
+
+func TestFetchStats(t *testing.T) {
+	probe := &fetchStatsProbe{}
+	metadata.RegisterFunction("fetchStatsProbe", probe, zap.NewNop())
+
+	values := map[parser.MetricRequest][]*types.MetricData{
+		{Metric: "foo.*", From: 0, Until: 10}: {
+			types.MakeMetricData("foo.a", []float64{1, 2, 3}, 1, 2),
+			types.MakeMetricData("foo.b", []float64{1, 2, 3, 4}, 2, 0),
+		},
+		{Metric: "bar", From: -60, Until: -50}: {
+			types.MakeMetricData("bar", []float64{1, 2}, 5, -60),
+		},
+		{Metric: "baz", From: 0, Until: 10}: {
+			types.MakeMetricData("baz", []float64{1}, 10, 0),
+		},
+	}
+
+	exp, _, err := parser.ParseExpr("fetchStatsProbe(foo.*, timeShift(bar, '1min'), foo.*)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := EvalExpr(context.Background(), exp, 0, 10, values, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !probe.ok {
+		t.Fatal("expected fetch stats in the context of the function")
+	}
+	expected := interfaces.FetchStats{Series: 3, Points: 9, MinStep: 1, MaxStep: 5, StartTime: -60, StopTime: 8}
+	if probe.stats != expected {
+		t.Errorf("expected %+v, got %+v", expected, probe.stats)
+	}
+
+	if _, ok := interfaces.GetFetchStats(context.Background()); ok {
+		t.Error("expected no fetch stats outside of an evaluation")
+	}
+}
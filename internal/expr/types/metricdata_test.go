@@ -23,13 +23,38 @@ func TestJSONResponse(t *testing.T) {
 	}
 
 	for _, tt := range tests {
-		b := MarshalJSON(tt.results)
+		b := MarshalJSON(tt.results, false)
 		if !bytes.Equal(b, tt.out) {
 			t.Errorf("marshalJSON(%+v)=%+v, want %+v", tt.results, string(b), string(tt.out))
 		}
 	}
 }
 
+func TestJSONResponseSecondYAxis(t *testing.T) {
+	m := MakeMetricData("secondYAxis(metric1)", []float64{1, math.NaN()}, 100, 100)
+	m.SecondYAxis = true
+
+	exp := []byte(`[{"target":"secondYAxis(metric1)","secondYAxis":true,"datapoints":[[1,100],[null,200]]}]`)
+	b := MarshalJSON([]*MetricData{m}, false)
+	if !bytes.Equal(b, exp) {
+		t.Errorf("marshalJSON=%+v, want %+v", string(b), string(exp))
+	}
+}
+
+func TestJSONResponseNoNullPoints(t *testing.T) {
+	results := []*MetricData{
+		MakeMetricData("metric1", []float64{math.NaN(), 1, math.NaN(), 2}, 100, 100),
+		MakeMetricData("metric2", []float64{math.NaN(), math.NaN()}, 100, 100),
+		MakeMetricData("metric3", []float64{3, math.Inf(1)}, 100, 100),
+	}
+
+	exp := []byte(`[{"target":"metric1","datapoints":[[1,200],[2,400]]},{"target":"metric3","datapoints":[[3,100]]}]`)
+	b := MarshalJSON(results, true)
+	if !bytes.Equal(b, exp) {
+		t.Errorf("marshalJSON=%+v, want %+v", string(b), string(exp))
+	}
+}
+
 func TestRawResponse(t *testing.T) {
 
 	tests := []struct {
@@ -41,7 +66,7 @@ func TestRawResponse(t *testing.T) {
 				MakeMetricData("metric1", []float64{1, 1.5, 2.25, math.NaN()}, 100, 100),
 				MakeMetricData("metric2", []float64{2, 2.5, 3.25, 4, 5}, 100, 100),
 			},
-			[]byte(`metric1,100,500,100|1,1.5,2.25,None` + "\n" + `metric2,100,600,100|2,2.5,3.25,4,5` + "\n"),
+			[]byte(`metric1,100,500,100|1.0,1.5,2.25,None` + "\n" + `metric2,100,600,100|2.0,2.5,3.25,4.0,5.0` + "\n"),
 		},
 	}
 
@@ -71,6 +96,6 @@ func BenchmarkMarshalJSON(b *testing.B) {
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
-		_ = MarshalJSON(data)
+		_ = MarshalJSON(data, false)
 	}
 }
@@ -0,0 +1,66 @@
+package types
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// ExtractTags parses the tags of a series name in the graphite format
+// "name;tag1=value1;tag2=value2". The part before the first ';' is returned
+// as the "name" tag, so every series has at least that one.
+func ExtractTags(s string) map[string]string {
+	parts := strings.Split(s, ";")
+	tags := make(map[string]string, len(parts))
+	tags["name"] = parts[0]
+	for _, p := range parts[1:] {
+		kv := strings.SplitN(p, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			continue
+		}
+		tags[kv[0]] = kv[1]
+	}
+
+	return tags
+}
+
+// GetTags returns the tags of the series, parsing them from its name if they
+// were not set when it was fetched.
+func (r *MetricData) GetTags() map[string]string {
+	if r.Tags != nil {
+		return r.Tags
+	}
+
+	return ExtractTags(r.Name)
+}
+
+// TaggedName builds the name of a series with the given tags, the reverse of
+// ExtractTags. The tags other than "name" are sorted, so that equal sets of
+// tags give equal names.
+func TaggedName(tags map[string]string) string {
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		if k != "name" {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteString(tags["name"])
+	for _, k := range keys {
+		b.WriteString(";" + k + "=" + tags[k])
+	}
+
+	return b.String()
+}
+
+// FromMetric wraps a series as decoded from a backend response, parsing its
+// tags from its name.
+func FromMetric(m types.Metric) *MetricData {
+	return &MetricData{
+		Metric: m,
+		Tags:   ExtractTags(m.Name),
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	"github.com/bookingcom/carbonapi/pkg/types"
 
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/csv"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/raw"
 
 	pickle "github.com/lomik/og-rek"
 )
@@ -27,12 +29,53 @@ type MetricData struct {
 
 	GraphOptions
 
+	// SecondYAxis is set by secondYAxis() and asks renderers to draw the
+	// series against the secondary Y axis.
+	SecondYAxis bool
+
+	// Stacked series are drawn on top of the previous series of the same
+	// stack. If Cumulative is set, values already hold the running total of
+	// the stack, as produced by stacked() and areaBetween().
+	Stacked    bool
+	StackName  string
+	Cumulative bool
+	// Invisible series take part in stacking but are not drawn.
+	Invisible bool
+
 	ValuesPerPoint    int
 	AggregateFunction func([]float64, []bool) (float64, bool)
+	// XFilesFactor is the ratio of the points of a bucket that have to be
+	// known for Consolidate to aggregate them, as set by setXFilesFactor.
+	XFilesFactor float64
+
+	// Tags of the series, as parsed from the name it was fetched with.
+	// Functions renaming a series keep them unless they say otherwise.
+	Tags map[string]string
+}
+
+// DefaultStackName is the name of the stack series are put in if none is given.
+const DefaultStackName = "__DEFAULT__"
+
+// Absence is the set of the absent points of a metric.
+type Absence = types.Absence
+
+// NewAbsence returns the absence of n points, none of them absent.
+func NewAbsence(n int) Absence {
+	return types.NewAbsence(n)
+}
+
+// AllAbsent returns the absence of n points, all of them absent.
+func AllAbsent(n int) Absence {
+	return types.AllAbsent(n)
+}
+
+// AbsenceOf returns the absence of the points for which absent is true.
+func AbsenceOf(absent ...bool) Absence {
+	return types.AbsenceOf(absent...)
 }
 
 // New creates new MetricData with given metric timeseries values and isAbsent
-func New(name string, values []float64, isAbsent []bool, step, start int32) *MetricData {
+func New(name string, values []float64, isAbsent Absence, step, start int32) *MetricData {
 	stop := start + int32(len(values))*step
 
 	return &MetricData{Metric: types.Metric{
@@ -48,12 +91,12 @@ func New(name string, values []float64, isAbsent []bool, step, start int32) *Met
 // MakeMetricData creates new metrics data with given metric timeseries. values have math.NaN() for absent
 func MakeMetricData(name string, values []float64, step, start int32) *MetricData {
 
-	absent := make([]bool, len(values))
+	absent := NewAbsence(len(values))
 
 	for i, v := range values {
 		if math.IsNaN(v) {
 			values[i] = 0
-			absent[i] = true
+			absent.Set(i, true)
 		}
 	}
 	stop := start + int32(len(values))*step
@@ -68,36 +111,29 @@ func MakeMetricData(name string, values []float64, step, start int32) *MetricDat
 	}}
 }
 
-// MarshalCSV marshals metric data to CSV
-func MarshalCSV(results []*MetricData, location *time.Location) []byte {
-
-	var b []byte
-
-	for _, r := range results {
-
-		step := r.StepTime
-		t := r.StartTime
-		for i, v := range r.Values {
-			b = append(b, '"')
-			b = append(b, r.Name...)
-			b = append(b, '"')
-			b = append(b, ',')
+// Copy returns a copy of r that shares nothing with it.
+func (r *MetricData) Copy() *MetricData {
+	c := *r
+	c.Values = append([]float64(nil), r.Values...)
+	c.IsAbsent = r.IsAbsent.Clone()
+	if r.Tags != nil {
+		c.Tags = make(map[string]string, len(r.Tags))
+		for k, v := range r.Tags {
+			c.Tags[k] = v
+		}
+	}
 
-			tmp := time.Unix(int64(t), 0)
-			if location != nil {
-				tmp = tmp.In(location)
-			}
+	return &c
+}
 
-			b = append(b, tmp.Format("2006-01-02 15:04:05")...)
-			b = append(b, ',')
-			if !r.IsAbsent[i] {
-				b = strconv.AppendFloat(b, v, 'f', -1, 64)
-			}
-			b = append(b, '\n')
-			t += step
-		}
+// MarshalCSV marshals metric data to CSV
+func MarshalCSV(results []*MetricData, location *time.Location) []byte {
+	metrics := make([]types.Metric, 0, len(results))
+	for _, metric := range results {
+		metrics = append(metrics, metric.Metric)
 	}
-	return b
+
+	return csv.RenderEncoder(metrics, location)
 }
 
 // ConsolidateJSON consolidates values to maxDataPoints size
@@ -135,8 +171,9 @@ func ConsolidateJSON(maxDataPoints int, results []*MetricData) (consolidated []*
 	return ret
 }
 
-// MarshalJSON marshals metric data to JSON
-func MarshalJSON(results []*MetricData) []byte {
+// MarshalJSON marshals metric data to JSON. With noNullPoints, absent values
+// are left out, and so are the series without any value, as graphite-web does.
+func MarshalJSON(results []*MetricData, noNullPoints bool) []byte {
 	var b []byte
 	b = append(b, '[')
 
@@ -146,6 +183,23 @@ func MarshalJSON(results []*MetricData) []byte {
 			continue
 		}
 
+		null := func(i int) bool {
+			v := r.Values[i]
+			return r.IsAbsent.Get(i) || math.IsInf(v, 0) || math.IsNaN(v)
+		}
+		if noNullPoints {
+			empty := true
+			for i := range r.Values {
+				if !null(i) {
+					empty = false
+					break
+				}
+			}
+			if empty {
+				continue
+			}
+		}
+
 		if topComma {
 			b = append(b, ',')
 		}
@@ -153,12 +207,19 @@ func MarshalJSON(results []*MetricData) []byte {
 
 		b = append(b, `{"target":`...)
 		b = strconv.AppendQuoteToASCII(b, r.Name)
+		if r.SecondYAxis {
+			b = append(b, `,"secondYAxis":true`...)
+		}
 		b = append(b, `,"datapoints":[`...)
 
 		var innerComma bool
 		t := r.StartTime
-		absent := r.IsAbsent
 		for i, v := range r.Values {
+			if noNullPoints && null(i) {
+				t += r.StepTime
+				continue
+			}
+
 			if innerComma {
 				b = append(b, ',')
 			}
@@ -166,7 +227,7 @@ func MarshalJSON(results []*MetricData) []byte {
 
 			b = append(b, '[')
 
-			if absent[i] || math.IsInf(v, 0) || math.IsNaN(v) {
+			if null(i) {
 				b = append(b, "null"...)
 			} else {
 				b = strconv.AppendFloat(b, v, 'f', -1, 64)
@@ -197,7 +258,7 @@ func MarshalPickle(results []*MetricData) ([]byte, error) {
 	for _, r := range results {
 		values := make([]interface{}, len(r.Values))
 		for i, v := range r.Values {
-			if r.IsAbsent[i] {
+			if r.IsAbsent.Get(i) {
 				values[i] = pickle.None{}
 			} else {
 				values[i] = v
@@ -233,37 +294,12 @@ func MarshalProtobuf(results []*MetricData) ([]byte, error) {
 
 // MarshalRaw marshals metric data to graphite's internal format, called 'raw'
 func MarshalRaw(results []*MetricData) []byte {
-
-	var b []byte
-
-	for _, r := range results {
-
-		b = append(b, r.Name...)
-
-		b = append(b, ',')
-		b = strconv.AppendInt(b, int64(r.StartTime), 10)
-		b = append(b, ',')
-		b = strconv.AppendInt(b, int64(r.StopTime), 10)
-		b = append(b, ',')
-		b = strconv.AppendInt(b, int64(r.StepTime), 10)
-		b = append(b, '|')
-
-		var comma bool
-		for i, v := range r.Values {
-			if comma {
-				b = append(b, ',')
-			}
-			comma = true
-			if r.IsAbsent[i] {
-				b = append(b, "None"...)
-			} else {
-				b = strconv.AppendFloat(b, v, 'f', -1, 64)
-			}
-		}
-
-		b = append(b, '\n')
+	metrics := make([]types.Metric, 0, len(results))
+	for _, metric := range results {
+		metrics = append(metrics, metric.Metric)
 	}
-	return b
+
+	return raw.RenderEncoder(metrics)
 }
 
 // Consolidate returns a consolidated copy of this MetricData.
@@ -272,9 +308,8 @@ func (r *MetricData) Consolidate(valuesPerPoint int) *MetricData {
 	if valuesPerPoint == 1 || valuesPerPoint == 0 {
 		ret.ValuesPerPoint = 1
 		ret.Values = make([]float64, len(r.Values))
-		ret.IsAbsent = make([]bool, len(r.IsAbsent))
+		ret.IsAbsent = r.IsAbsent.Clone()
 		copy(ret.Values, r.Values)
-		copy(ret.IsAbsent, r.IsAbsent)
 		return &ret
 	}
 
@@ -284,36 +319,37 @@ func (r *MetricData) Consolidate(valuesPerPoint int) *MetricData {
 		ret.AggregateFunction = AggMean
 	}
 
-	n := len(r.Values)/valuesPerPoint + 1
-	aggV := make([]float64, 0, n)
-	aggA := make([]bool, 0, n)
-
-	v := r.Values
-	absent := r.IsAbsent
-
-	for len(v) >= valuesPerPoint {
-		val, abs := ret.AggregateFunction(v[:valuesPerPoint], absent[:valuesPerPoint])
-		if math.IsNaN(val) {
-			val = 0
+	n := (len(r.Values) + valuesPerPoint - 1) / valuesPerPoint
+	ret.Values = make([]float64, n)
+	ret.IsAbsent = NewAbsence(n)
+
+	// the aggregation functions take the absence of a bucket as bools
+	absent := make([]bool, valuesPerPoint)
+	for i := 0; i < n; i++ {
+		start := i * valuesPerPoint
+		stop := start + valuesPerPoint
+		if stop > len(r.Values) {
+			stop = len(r.Values)
+		}
+		known := 0
+		for j := start; j < stop; j++ {
+			absent[j-start] = r.IsAbsent.Get(j)
+			if !absent[j-start] {
+				known++
+			}
 		}
-		aggV = append(aggV, val)
-		aggA = append(aggA, abs)
-		v = v[valuesPerPoint:]
-		absent = absent[valuesPerPoint:]
-	}
 
-	if len(v) > 0 {
-		val, abs := ret.AggregateFunction(v, absent)
+		val, abs := ret.AggregateFunction(r.Values[start:stop], absent[:stop-start])
+		if float64(known) < r.XFilesFactor*float64(stop-start) {
+			val, abs = 0, true
+		}
 		if math.IsNaN(val) {
 			val = 0
 		}
-		aggV = append(aggV, val)
-		aggA = append(aggA, abs)
+		ret.Values[i] = val
+		ret.IsAbsent.Set(i, abs)
 	}
 
-	ret.Values = aggV
-	ret.IsAbsent = aggA
-
 	return &ret
 }
 
@@ -18,7 +18,7 @@ func TestMarshalCSVInUTC(t *testing.T) {
 				StopTime:  1,
 				StepTime:  1,
 				Values:    []float64{2},
-				IsAbsent:  []bool{false},
+				IsAbsent:  types.AbsenceOf(false),
 			},
 		},
 	}
@@ -26,7 +26,7 @@ func TestMarshalCSVInUTC(t *testing.T) {
 	blob := MarshalCSV(results, time.UTC)
 	got := string(blob)
 
-	exp := "\"foo\",1970-01-01 00:00:00,2\n"
+	exp := "foo,1970-01-01 00:00:00,2.0\r\n"
 
 	if got != exp {
 		t.Errorf("Expected '%s', got '%s'", exp, got)
@@ -42,7 +42,7 @@ func TestMarshalCSVNotInUTC(t *testing.T) {
 				StopTime:  1,
 				StepTime:  1,
 				Values:    []float64{2},
-				IsAbsent:  []bool{false},
+				IsAbsent:  types.AbsenceOf(false),
 			},
 		},
 	}
@@ -51,7 +51,7 @@ func TestMarshalCSVNotInUTC(t *testing.T) {
 	blob := MarshalCSV(results, tz)
 	got := string(blob)
 
-	exp := "\"foo\",1970-01-01 01:00:00,2\n"
+	exp := "foo,1970-01-01 01:00:00,2.0\r\n"
 
 	if got != exp {
 		t.Errorf("Expected '%s', got '%s'", exp, got)
@@ -137,3 +137,48 @@ func TestConsolidate(t *testing.T) {
 
 	}
 }
+
+func TestExtractTags(t *testing.T) {
+	tests := []struct {
+		name string
+		want map[string]string
+	}{
+		{"cpu.load", map[string]string{"name": "cpu.load"}},
+		{"cpu.load;dc=ams;host=web1", map[string]string{"name": "cpu.load", "dc": "ams", "host": "web1"}},
+		{"cpu.load;dc=;broken;=x", map[string]string{"name": "cpu.load", "dc": ""}},
+	}
+
+	for _, tt := range tests {
+		if got := ExtractTags(tt.name); !cmp.Equal(got, tt.want) {
+			t.Errorf("ExtractTags(%q): %s", tt.name, cmp.Diff(tt.want, got))
+		}
+	}
+}
+
+func TestTaggedName(t *testing.T) {
+	tests := []struct {
+		tags map[string]string
+		want string
+	}{
+		{map[string]string{"name": "cpu.load"}, "cpu.load"},
+		{map[string]string{"name": "cpu.load", "host": "web1", "dc": "ams"}, "cpu.load;dc=ams;host=web1"},
+	}
+
+	for _, tt := range tests {
+		got := TaggedName(tt.tags)
+		if got != tt.want {
+			t.Errorf("TaggedName(%v): expected %q, got %q", tt.tags, tt.want, got)
+		}
+		if back := ExtractTags(got); !cmp.Equal(back, tt.tags) {
+			t.Errorf("ExtractTags(%q) does not round trip: %s", got, cmp.Diff(tt.tags, back))
+		}
+	}
+}
+
+func TestFromMetric(t *testing.T) {
+	r := FromMetric(types.Metric{Name: "cpu.load;host=web1"})
+	want := map[string]string{"name": "cpu.load", "host": "web1"}
+	if !cmp.Equal(r.Tags, want) {
+		t.Errorf("unexpected tags: %s", cmp.Diff(want, r.Tags))
+	}
+}
@@ -2,14 +2,24 @@ package parser
 
 import (
 	"fmt"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // MetricRequest contains all necessary data to request a metric.
 type MetricRequest struct {
 	Metric string
-	From   int32
-	Until  int32
+	From   int64
+	Until  int64
+}
+
+// NodeOrTag is an argument referring either to a node of a series name, by
+// its index, or to a tag of the series, by its name.
+type NodeOrTag struct {
+	IsTag bool
+	Node  int
+	Tag   string
 }
 
 // ExprType defines a type for expression types constants (e.x. functions, values, constants, parameters, strings)
@@ -51,6 +61,8 @@ var (
 	ErrBraceInBrackets = ParseError("brace within brackets")
 	// ErrNestedBrackets is a parse error returned when an expression has nested brackets.
 	ErrNestedBrackets = ParseError("nested brackets")
+	// ErrPipeToNotFunction is a parse error returned when an expression is piped to a series or a value.
+	ErrPipeToNotFunction = ParseError("pipe to not a function")
 	// ErrBadType is an eval error returned when a argument has wrong type.
 	ErrBadType = ParseError("bad type")
 	// ErrMissingArgument is an eval error returned when a argument is missing.
@@ -61,6 +73,14 @@ var (
 	ErrSeriesDoesNotExist = ParseError("no timeseries with that name")
 	// ErrUnknownTimeUnits is an eval error returned when a time unit is unknown to system
 	ErrUnknownTimeUnits = ParseError("unknown time units")
+	// ErrMissingTimeUnits is an eval error returned when a number in an interval has no time unit.
+	ErrMissingTimeUnits = ParseError("missing time units")
+	// ErrMissingIntervalValue is an eval error returned when a time unit in an interval has no number.
+	ErrMissingIntervalValue = ParseError("missing interval value")
+	// ErrEmptyInterval is an eval error returned when an interval is empty.
+	ErrEmptyInterval = ParseError("empty interval")
+	// ErrIntervalOutOfRange is an eval error returned when an interval does not fit in 32 bits of seconds.
+	ErrIntervalOutOfRange = ParseError("value out of range")
 	// ErrDifferentCountMetrics is an eval error returned when a function that works on pairs of metrics receives arguments having different number of metrics.
 	ErrDifferentCountMetrics = ParseError("both arguments must have the same number of metrics")
 	// ErrInvalidArgumentValue is an eval error returned when a function received an argument that has the right type but invalid value
@@ -74,6 +94,50 @@ func (p ParseError) Error() string {
 	return string(p)
 }
 
+// SyntaxError tells where an expression is invalid, and why.
+type SyntaxError struct {
+	// Offset is the offset in bytes of Token in the expression
+	Offset int `json:"offset"`
+	// Token is where parsing failed, empty at the end of the expression
+	Token   string `json:"token"`
+	Message string `json:"message"`
+	Err     error  `json:"-"`
+}
+
+// NewSyntaxError returns the error err of parsing expression, which failed
+// with rest left to parse.
+func NewSyntaxError(expression, rest string, err error) *SyntaxError {
+	return &SyntaxError{
+		Offset:  len(expression) - len(rest),
+		Token:   token(rest),
+		Message: err.Error(),
+		Err:     err,
+	}
+}
+
+func (err *SyntaxError) Error() string {
+	if err.Token == "" {
+		return fmt.Sprintf("%s at offset %d", err.Message, err.Offset)
+	}
+	return fmt.Sprintf("%s at offset %d, near %q", err.Message, err.Offset, err.Token)
+}
+
+func (err *SyntaxError) Unwrap() error {
+	return err.Err
+}
+
+// token returns the name or the character s starts with
+func token(s string) string {
+	i := 0
+	for i < len(s) && IsNameChar(s[i]) {
+		i++
+	}
+	if i == 0 && s != "" {
+		_, i = utf8.DecodeRuneInString(s)
+	}
+	return s[:i]
+}
+
 // Expr defines an interface to talk with expressions
 type Expr interface {
 	// IsName checks if Expression is 'Series Name' expression
@@ -92,8 +156,14 @@ type Expr interface {
 	SetTarget(string)
 	// MutateTarget changes target for the expression and returns new interface. Please note that it doesn't copy object yet
 	MutateTarget(string) Expr
-	// ToString returns string representation of expression
+	// ToString returns string representation of expression, with the
+	// arguments of functions exactly as they are written
 	ToString() string
+	// ToCanonicalString returns string representation of expression the same
+	// whatever the way it is written: without spaces, with named arguments
+	// after positional ones and sorted by name, constants in their shortest
+	// form and strings in single quotes, unless they hold one
+	ToCanonicalString() string
 
 	// FloatValue returns float value for expression.
 	FloatValue() float64
@@ -126,6 +196,8 @@ type Expr interface {
 	GetStringArg(n int) (string, error)
 	// GetIntervalArg returns n-th argument as string. It will replace it with Default value if none present.
 	GetStringArgDefault(n int, s string) (string, error)
+	// GetStringArgs returns the arguments from the n-th on as slice of strings.
+	GetStringArgs(n int) ([]string, error)
 	// GetStringNamedOrPosArgDefault returns specific positioned string-typed argument or replace it with default if none found.
 	GetStringNamedOrPosArgDefault(k string, n int, s string) (string, error)
 
@@ -133,6 +205,8 @@ type Expr interface {
 	GetFloatArg(n int) (float64, error)
 	// GetFloatArgDefault returns n-th argument as float. It will replace it with Default value if none present.
 	GetFloatArgDefault(n int, v float64) (float64, error)
+	// GetFloatArgs returns the arguments from the n-th on as slice of floats.
+	GetFloatArgs(n int) ([]float64, error)
 	// GetFloatNamedOrPosArgDefault returns specific positioned float64-typed argument or replace it with default if none found.
 	GetFloatNamedOrPosArgDefault(k string, n int, v float64) (float64, error)
 
@@ -140,6 +214,9 @@ type Expr interface {
 	GetIntArg(n int) (int, error)
 	// GetIntArgs returns n-th argument as slice of ints
 	GetIntArgs(n int) ([]int, error)
+	// GetNodeOrTagArgs returns the arguments from the n-th on as nodes, for
+	// ints, or tags, for strings.
+	GetNodeOrTagArgs(n int) ([]NodeOrTag, error)
 	// GetIntArgDefault returns n-th argument as int. It will replace it with Default value if none present.
 	GetIntArgDefault(n int, d int) (int, error)
 	// GetIntNamedOrPosArgDefault returns specific positioned int-typed argument or replace it with default if none found.
@@ -222,9 +299,15 @@ func NewExpr(target string, vaArgs ...interface{}) Expr {
 
 	if nArgs != nil {
 		nArgsFinal = make(map[string]*expr)
+		names := make([]string, 0, len(nArgs))
 		for k, v := range nArgs {
 			nArgsFinal[k] = v
-			argStrs = append(argStrs, k+"="+v.RawArgs())
+			names = append(names, k)
+		}
+		// in a stable order
+		sort.Strings(names)
+		for _, k := range names {
+			argStrs = append(argStrs, k+"="+nArgs[k].RawArgs())
 		}
 	}
 
@@ -0,0 +1,235 @@
+package parser
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Lengths of calendar units, in seconds. Months and years have no fixed
+// length, so like graphite-web they are approximated as 30 and 365 days.
+const (
+	secondsPerMinute = 60
+	secondsPerHour   = 60 * secondsPerMinute
+	secondsPerDay    = 24 * secondsPerHour
+	secondsPerWeek   = 7 * secondsPerDay
+	secondsPerMonth  = 30 * secondsPerDay
+	secondsPerYear   = 365 * secondsPerDay
+)
+
+// IntervalError describes why an interval string could not be parsed.
+type IntervalError struct {
+	Interval string // The interval as given.
+	Pos      int    // Byte offset in Interval where the problem was found.
+	Err      error  // One of the interval related ParseErrors.
+}
+
+func (e *IntervalError) Error() string {
+	return fmt.Sprintf("%v at position %d in interval %q", e.Err, e.Pos, e.Interval)
+}
+
+// Unwrap returns the underlying ParseError.
+func (e *IntervalError) Unwrap() error {
+	return e.Err
+}
+
+// IntervalString converts a sign and string into a number of seconds.
+//
+// Two formats are accepted, both with an optional leading sign that overrides
+// defaultSign:
+//
+//   - graphite-style strings made of one or more number and unit pairs, such
+//     as "5min", "1d12h" or "2weeks";
+//   - ISO 8601 durations such as "PT5M", "P1D" or "P1Y2M3DT4H".
+//
+// A month counts as 30 days and a year as 365 days. Errors are of type
+// *IntervalError.
+func IntervalString(s string, defaultSign int) (int32, error) {
+	interval := s
+	if s == "" {
+		return 0, &IntervalError{Interval: interval, Err: ErrEmptyInterval}
+	}
+
+	sign := defaultSign
+	pos := 0
+
+	switch s[0] {
+	case '-':
+		sign = -1
+		pos++
+	case '+':
+		sign = 1
+		pos++
+	}
+
+	if pos == len(s) {
+		return 0, &IntervalError{Interval: interval, Pos: pos, Err: ErrEmptyInterval}
+	}
+
+	var total int64
+	var err error
+	if s[pos] == 'P' || s[pos] == 'p' {
+		total, err = isoInterval(interval, pos+1)
+	} else {
+		total, err = graphiteInterval(interval, pos)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	total *= int64(sign)
+	if total > math.MaxInt32 || total < math.MinInt32 {
+		return 0, &IntervalError{Interval: interval, Pos: 0, Err: ErrIntervalOutOfRange}
+	}
+
+	return int32(total), nil
+}
+
+// graphiteInterval parses the unsigned part of a graphite-style interval,
+// starting at pos.
+func graphiteInterval(s string, pos int) (int64, error) {
+	var total int64
+	for pos < len(s) {
+		numStart := pos
+		for pos < len(s) && isDigit(s[pos]) {
+			pos++
+		}
+		numStr := s[numStart:pos]
+
+		unitStart := pos
+		for pos < len(s) && !isDigit(s[pos]) {
+			pos++
+		}
+		unitStr := s[unitStart:pos]
+
+		if numStr == "" {
+			return 0, &IntervalError{Interval: s, Pos: numStart, Err: ErrMissingIntervalValue}
+		}
+
+		if unitStr == "" {
+			return 0, &IntervalError{Interval: s, Pos: unitStart, Err: ErrMissingTimeUnits}
+		}
+
+		var units int64
+		switch unitStr {
+		case "s", "sec", "secs", "second", "seconds":
+			units = 1
+		case "m", "min", "mins", "minute", "minutes":
+			units = secondsPerMinute
+		case "h", "hour", "hours":
+			units = secondsPerHour
+		case "d", "day", "days":
+			units = secondsPerDay
+		case "w", "week", "weeks":
+			units = secondsPerWeek
+		case "mon", "month", "months":
+			units = secondsPerMonth
+		case "y", "year", "years":
+			units = secondsPerYear
+		default:
+			return 0, &IntervalError{Interval: s, Pos: unitStart, Err: ErrUnknownTimeUnits}
+		}
+
+		var err error
+		total, err = addInterval(total, numStr, units)
+		if err != nil {
+			return 0, &IntervalError{Interval: s, Pos: numStart, Err: err}
+		}
+	}
+
+	return total, nil
+}
+
+// isoInterval parses an ISO 8601 duration, starting right after the leading
+// "P" at pos. Fractional values are not supported.
+func isoInterval(s string, pos int) (int64, error) {
+	if pos == len(s) {
+		return 0, &IntervalError{Interval: s, Pos: pos, Err: ErrEmptyInterval}
+	}
+
+	var total int64
+	inTime := false
+	for pos < len(s) {
+		if c := s[pos]; c == 'T' || c == 't' {
+			if inTime {
+				return 0, &IntervalError{Interval: s, Pos: pos, Err: ErrUnknownTimeUnits}
+			}
+			if pos == len(s)-1 {
+				return 0, &IntervalError{Interval: s, Pos: pos + 1, Err: ErrMissingIntervalValue}
+			}
+			inTime = true
+			pos++
+			continue
+		}
+
+		numStart := pos
+		for pos < len(s) && isDigit(s[pos]) {
+			pos++
+		}
+		numStr := s[numStart:pos]
+
+		if numStr == "" {
+			return 0, &IntervalError{Interval: s, Pos: numStart, Err: ErrMissingIntervalValue}
+		}
+
+		if pos == len(s) {
+			return 0, &IntervalError{Interval: s, Pos: pos, Err: ErrMissingTimeUnits}
+		}
+
+		var units int64
+		switch c := strings.ToUpper(s[pos : pos+1]); {
+		case !inTime && c == "Y":
+			units = secondsPerYear
+		case !inTime && c == "M":
+			units = secondsPerMonth
+		case !inTime && c == "W":
+			units = secondsPerWeek
+		case !inTime && c == "D":
+			units = secondsPerDay
+		case inTime && c == "H":
+			units = secondsPerHour
+		case inTime && c == "M":
+			units = secondsPerMinute
+		case inTime && c == "S":
+			units = 1
+		default:
+			return 0, &IntervalError{Interval: s, Pos: pos, Err: ErrUnknownTimeUnits}
+		}
+
+		var err error
+		total, err = addInterval(total, numStr, units)
+		if err != nil {
+			return 0, &IntervalError{Interval: s, Pos: numStart, Err: err}
+		}
+		pos++
+	}
+
+	return total, nil
+}
+
+// addInterval adds numStr times units to total, guarding against overflow.
+func addInterval(total int64, numStr string, units int64) (int64, error) {
+	n, err := strconv.ParseInt(numStr, 10, 64)
+	if err != nil || n > math.MaxInt32 {
+		return 0, ErrIntervalOutOfRange
+	}
+
+	total += n * units
+	if total > math.MaxInt32+1 {
+		return 0, ErrIntervalOutOfRange
+	}
+
+	return total, nil
+}
+
+// TruthyBool evaluates a string into a boolean
+func TruthyBool(s string) bool {
+	switch s {
+	case "", "0", "false", "False", "no", "No":
+		return false
+	case "1", "true", "True", "yes", "Yes":
+		return true
+	}
+	return false
+}
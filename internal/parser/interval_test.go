@@ -1,6 +1,7 @@
 package parser
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -26,6 +27,17 @@ func TestInterval(t *testing.T) {
 		{"-10hours", -60 * 60 * 10, -1},
 		{"-360h2min", -360*60*60 - 2*60, -1},
 		{"+2mon1w", 2*30*24*60*60 + 7*24*60*60, -1},
+		{"1y", 365 * 24 * 60 * 60, 1},
+		{"1d12h", 36 * 60 * 60, 1},
+
+		{"PT5M", 5 * 60, 1},
+		{"-PT5M", -5 * 60, 1},
+		{"PT1H30M15S", 60*60 + 30*60 + 15, 1},
+		{"P1D", -24 * 60 * 60, -1},
+		{"P1DT12H", 36 * 60 * 60, 1},
+		{"P2W", 2 * 7 * 24 * 60 * 60, 1},
+		{"P1Y2M", 365*24*60*60 + 2*30*24*60*60, 1},
+		{"pt10m", 10 * 60, 1},
 	}
 
 	for _, tt := range tests {
@@ -42,6 +54,18 @@ func TestInterval(t *testing.T) {
 	}{
 		{"10x10s", 0, "unknown time units", 1},
 		{"10000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000y", 0, "value out of range", 1},
+		{"100y", 0, "value out of range", 1},
+		{"", 0, "empty interval", 1},
+		{"-", 0, "empty interval at position 1", 1},
+		{"10", 0, "missing time units at position 2", 1},
+		{"1dh", 0, "unknown time units at position 1", 1},
+		{"min", 0, "missing interval value at position 0", 1},
+		{"P", 0, "empty interval", 1},
+		{"PT", 0, "missing interval value at position 2", 1},
+		{"P5H", 0, "unknown time units at position 2", 1},
+		{"PT5D", 0, "unknown time units at position 3", 1},
+		{"PT5", 0, "missing time units at position 3", 1},
+		{"PT1.5M", 0, "unknown time units at position 3", 1},
 	}
 	for _, tt := range exceptTests {
 		secs, err := IntervalString(tt.t, tt.sign)
@@ -54,6 +78,23 @@ func TestInterval(t *testing.T) {
 	}
 }
 
+func TestIntervalError(t *testing.T) {
+	_, err := IntervalString("10x10s", 1)
+
+	var ie *IntervalError
+	if !errors.As(err, &ie) {
+		t.Fatalf("Expected *IntervalError, got %T", err)
+	}
+
+	if ie.Pos != 2 || ie.Interval != "10x10s" {
+		t.Errorf("Unexpected error details %+v", ie)
+	}
+
+	if !errors.Is(err, ErrUnknownTimeUnits) {
+		t.Errorf("Expected %v, got %v", ErrUnknownTimeUnits, err)
+	}
+}
+
 func TestTruthyBool(t *testing.T) {
 
 	trueWords := []string{"1", "true", "True", "yes", "Yes"}
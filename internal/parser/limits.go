@@ -0,0 +1,58 @@
+package parser
+
+import "fmt"
+
+// Limits bound the expressions accepted by ParseExpr, so that crafted
+// targets cannot exhaust the stack or the CPU. Zero values mean no limit.
+type Limits struct {
+	// MaxDepth limits the nesting of function calls, pipes included.
+	MaxDepth int
+	// MaxArguments limits the arguments, named or not, of a function call.
+	MaxArguments int
+	// MaxLength limits the length of the expression, in bytes.
+	MaxLength int
+	// MaxPipes limits the pipes of a chain, e.g. 2 for a|b()|c(), besides
+	// the depth they add to.
+	MaxPipes int
+}
+
+var limits Limits
+
+// SetLimits sets the limits of the expressions parsed from now on.
+func SetLimits(l Limits) {
+	limits = l
+}
+
+// ErrLimitExceeded is wrapped by the errors of expressions over the limits.
+var ErrLimitExceeded = ParseError("expression exceeds parser limits")
+
+// LimitError is returned for an expression over one of the Limits, named
+// by Limit: depth, arguments, length or pipes.
+type LimitError struct {
+	Limit string
+	Max   int
+}
+
+func (err LimitError) Error() string {
+	return fmt.Sprintf("%s: %s is limited to %d", ErrLimitExceeded, err.Limit, err.Max)
+}
+
+func (err LimitError) Unwrap() error {
+	return ErrLimitExceeded
+}
+
+// height returns the nesting of the function calls of e.
+func height(e *expr) int {
+	if e.etype != EtFunc {
+		return 0
+	}
+
+	h := 0
+	for _, a := range e.args {
+		if ah := height(a); ah > h {
+			h = ah
+		}
+	}
+
+	return h + 1
+}
@@ -1,8 +1,9 @@
 package parser
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"unicode"
@@ -57,6 +58,39 @@ func (e *expr) ToString() string {
 	}
 }
 
+func (e *expr) ToCanonicalString() string {
+	switch e.etype {
+	case EtFunc:
+		args := make([]string, 0, len(e.args)+len(e.namedArgs))
+		for _, arg := range e.args {
+			args = append(args, arg.ToCanonicalString())
+		}
+		names := make([]string, 0, len(e.namedArgs))
+		for name := range e.namedArgs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			args = append(args, name+"="+e.namedArgs[name].ToCanonicalString())
+		}
+		return e.target + "(" + strings.Join(args, ",") + ")"
+	case EtConst:
+		return strconv.FormatFloat(e.val, 'g', -1, 64)
+	case EtString:
+		if e.target != "" {
+			// true or false, as written
+			return e.target
+		}
+		// quoted as parsed, which is without escapes
+		if strings.Contains(e.valStr, "'") {
+			return `"` + e.valStr + `"`
+		}
+		return "'" + e.valStr + "'"
+	default:
+		return e.target
+	}
+}
+
 func (e *expr) SetTarget(target string) {
 	e.target = target
 }
@@ -123,6 +157,11 @@ func (e *expr) Metrics() []MetricRequest {
 	case EtConst, EtString:
 		return nil
 	case EtFunc:
+		if e.target == "seriesByTag" {
+			// the whole query is sent to the backends, which know the tags
+			return []MetricRequest{{Metric: e.ToString()}}
+		}
+
 		var r []MetricRequest
 		for _, a := range e.args {
 			r = append(r, a.Metrics()...)
@@ -135,8 +174,8 @@ func (e *expr) Metrics() []MetricRequest {
 				return nil
 			}
 			for i := range r {
-				r[i].From += offs
-				r[i].Until += offs
+				r[i].From += int64(offs)
+				r[i].Until += int64(offs)
 			}
 		case "timeStack":
 			offs, err := e.GetIntervalArg(1, -1)
@@ -156,11 +195,11 @@ func (e *expr) Metrics() []MetricRequest {
 
 			var r2 []MetricRequest
 			for _, v := range r {
-				for i := int32(start); i < int32(end); i++ {
+				for i := int64(start); i < int64(end); i++ {
 					r2 = append(r2, MetricRequest{
 						Metric: v.Metric,
-						From:   v.From + (i * offs),
-						Until:  v.Until + (i * offs),
+						From:   v.From + (i * int64(offs)),
+						Until:  v.Until + (i * int64(offs)),
 					})
 				}
 			}
@@ -177,7 +216,7 @@ func (e *expr) Metrics() []MetricRequest {
 					return nil
 				}
 				for i := range r {
-					r[i].From -= offs
+					r[i].From -= int64(offs)
 				}
 			}
 		}
@@ -198,7 +237,7 @@ func (e *expr) GetIntervalArg(n int, defaultSign int) (int32, error) {
 
 	seconds, err := IntervalString(e.args[n].valStr, defaultSign)
 	if err != nil {
-		return 0, ErrBadType
+		return 0, err
 	}
 
 	return seconds, nil
@@ -212,6 +251,23 @@ func (e *expr) GetStringArg(n int) (string, error) {
 	return e.args[n].doGetStringArg()
 }
 
+func (e *expr) GetStringArgs(n int) ([]string, error) {
+	if len(e.args) <= n {
+		return nil, ErrMissingArgument
+	}
+
+	strs := make([]string, 0, len(e.args)-n)
+	for i := n; i < len(e.args); i++ {
+		a, err := e.args[i].doGetStringArg()
+		if err != nil {
+			return nil, err
+		}
+		strs = append(strs, a)
+	}
+
+	return strs, nil
+}
+
 func (e *expr) GetStringArgDefault(n int, s string) (string, error) {
 	if len(e.args) <= n {
 		return s, nil
@@ -236,6 +292,23 @@ func (e *expr) GetFloatArg(n int) (float64, error) {
 	return e.args[n].doGetFloatArg()
 }
 
+func (e *expr) GetFloatArgs(n int) ([]float64, error) {
+	if len(e.args) <= n {
+		return nil, ErrMissingArgument
+	}
+
+	floats := make([]float64, 0, len(e.args)-n)
+	for i := n; i < len(e.args); i++ {
+		a, err := e.args[i].doGetFloatArg()
+		if err != nil {
+			return nil, err
+		}
+		floats = append(floats, a)
+	}
+
+	return floats, nil
+}
+
 func (e *expr) GetFloatArgDefault(n int, v float64) (float64, error) {
 	if len(e.args) <= n {
 		return v, nil
@@ -279,6 +352,30 @@ func (e *expr) GetIntArgs(n int) ([]int, error) {
 	return ints, nil
 }
 
+func (e *expr) GetNodeOrTagArgs(n int) ([]NodeOrTag, error) {
+	if len(e.args) <= n {
+		return nil, ErrMissingArgument
+	}
+
+	nodes := make([]NodeOrTag, 0, len(e.args)-n)
+	for i := n; i < len(e.args); i++ {
+		switch e.args[i].etype {
+		case EtConst:
+			node, err := e.args[i].doGetIntArg()
+			if err != nil {
+				return nil, err
+			}
+			nodes = append(nodes, NodeOrTag{Node: node})
+		case EtString:
+			nodes = append(nodes, NodeOrTag{IsTag: true, Tag: e.args[i].valStr})
+		default:
+			return nil, ErrBadType
+		}
+	}
+
+	return nodes, nil
+}
+
 func (e *expr) GetIntArgDefault(n int, d int) (int, error) {
 	if len(e.args) <= n {
 		return d, nil
@@ -317,7 +414,7 @@ func (e *expr) GetBoolArgDefault(n int, b bool) (bool, error) {
 
 func (e *expr) insertFirstArg(exp *expr) error {
 	if e.etype != EtFunc {
-		return fmt.Errorf("pipe to not a function")
+		return ErrPipeToNotFunction
 	}
 
 	newArgs := []*expr{exp}
@@ -332,7 +429,7 @@ func (e *expr) insertFirstArg(exp *expr) error {
 	return nil
 }
 
-func parseExprWithoutPipe(e string) (Expr, string, error) {
+func parseExprWithoutPipe(e string, depth int) (Expr, string, error) {
 	// skip whitespace
 	for len(e) > 1 && unicode.IsSpace(rune(e[0])) {
 		e = e[1:]
@@ -374,8 +471,12 @@ func parseExprWithoutPipe(e string) (Expr, string, error) {
 	if e != "" && e[0] == '(' {
 		var err error
 
+		if limits.MaxDepth > 0 && depth >= limits.MaxDepth {
+			return nil, e, LimitError{Limit: "depth", Max: limits.MaxDepth}
+		}
+
 		exp := &expr{target: name, etype: EtFunc}
-		exp.argString, exp.args, exp.namedArgs, e, err = parseArgList(e)
+		exp.argString, exp.args, exp.namedArgs, e, err = parseArgList(e, depth+1)
 
 		return exp, e, err
 	}
@@ -384,15 +485,32 @@ func parseExprWithoutPipe(e string) (Expr, string, error) {
 }
 
 // ParseExpr actually do all the parsing. It returns expression, original string and error (if any)
+// Errors are *SyntaxError, telling where the expression is invalid.
 func ParseExpr(e string) (Expr, string, error) {
-	exp, e, err := parseExprWithoutPipe(e)
+	if limits.MaxLength > 0 && len(e) > limits.MaxLength {
+		return nil, e, NewSyntaxError(e, e[limits.MaxLength:], LimitError{Limit: "length", Max: limits.MaxLength})
+	}
+
+	exp, rest, err := parseExpr(e, 0)
+	if err != nil {
+		return exp, rest, NewSyntaxError(e, rest, err)
+	}
+	return exp, rest, nil
+}
+
+// parseExpr parses an expression nested in depth function calls
+func parseExpr(e string, depth int) (Expr, string, error) {
+	exp, e, err := parseExprWithoutPipe(e, depth)
 	if err != nil {
 		return exp, e, err
 	}
-	return pipe(exp.(*expr), e)
+	return pipe(exp.(*expr), e, depth, 1)
 }
 
-func pipe(exp *expr, e string) (*expr, string, error) {
+// pipe parses the n-th pipe of a chain, if e starts with one, and those
+// after it. Errors of the segments piped to tell which pipe they are in,
+// and start where the segment does.
+func pipe(exp *expr, e string, depth int, n int) (*expr, string, error) {
 	for len(e) > 1 && unicode.IsSpace(rune(e[0])) {
 		e = e[1:]
 	}
@@ -400,8 +518,15 @@ func pipe(exp *expr, e string) (*expr, string, error) {
 	if e == "" || e[0] != '|' {
 		return exp, e, nil
 	}
+	if limits.MaxPipes > 0 && n > limits.MaxPipes {
+		return exp, e, LimitError{Limit: "pipes", Max: limits.MaxPipes}
+	}
 
-	wr, e, err := parseExprWithoutPipe(e[1:])
+	segment := strings.TrimLeftFunc(e[1:], unicode.IsSpace)
+	wr, e, err := parseExprWithoutPipe(segment, depth)
+	if (errors.Is(err, ErrMissingExpr) || errors.Is(err, ErrMissingArgument)) && e == segment {
+		return exp, segment, fmt.Errorf("%w: pipe %d is to nothing", ErrMissingExpr, n)
+	}
 	if err != nil {
 		return exp, e, err
 	}
@@ -411,11 +536,21 @@ func pipe(exp *expr, e string) (*expr, string, error) {
 
 	err = wr.(*expr).insertFirstArg(exp)
 	if err != nil {
-		return exp, e, err
+		return exp, segment, fmt.Errorf("%w: pipe %d is to %s", err, n, strings.TrimSpace(segment[:len(segment)-len(e)]))
 	}
 	exp = wr.(*expr)
 
-	return pipe(exp, e)
+	if limits.MaxDepth > 0 && depth+height(exp) > limits.MaxDepth {
+		return exp, e, LimitError{Limit: "depth", Max: limits.MaxDepth}
+	}
+
+	return pipe(exp, e, depth, n+1)
+}
+
+// IsSeriesByTag checks if a metric request is a seriesByTag query, which is
+// resolved by the backends rather than expanded as a glob
+func IsSeriesByTag(metric string) bool {
+	return strings.HasPrefix(metric, "seriesByTag(")
 }
 
 // IsNameChar checks if specified char is actually a valid (from graphite's protocol point of view)
@@ -436,7 +571,7 @@ func isDigit(r byte) bool {
 	return '0' <= r && r <= '9'
 }
 
-func parseArgList(e string) (string, []*expr, map[string]*expr, string, error) {
+func parseArgList(e string, depth int) (string, []*expr, map[string]*expr, string, error) {
 
 	var (
 		posArgs   []*expr
@@ -447,9 +582,9 @@ func parseArgList(e string) (string, []*expr, map[string]*expr, string, error) {
 		panic("arg list should start with paren")
 	}
 
-	var argStringBuffer bytes.Buffer
-
 	e = e[1:]
+	// the arguments are kept as they are written, up to the closing paren
+	argString := e
 
 	// check for empty args
 	t := strings.TrimLeftFunc(e, unicode.IsSpace)
@@ -461,8 +596,11 @@ func parseArgList(e string) (string, []*expr, map[string]*expr, string, error) {
 		var arg Expr
 		var err error
 
-		argString := e
-		arg, e, err = ParseExpr(e)
+		if limits.MaxArguments > 0 && len(posArgs)+len(namedArgs) >= limits.MaxArguments {
+			return "", nil, nil, e, LimitError{Limit: "arguments", Max: limits.MaxArguments}
+		}
+
+		arg, e, err = parseExpr(e, depth)
 		if err != nil {
 			return "", nil, nil, e, err
 		}
@@ -474,7 +612,7 @@ func parseArgList(e string) (string, []*expr, map[string]*expr, string, error) {
 		// we now know we're parsing a key-value pair
 		if arg.IsName() && e[0] == '=' {
 			e = e[1:]
-			argCont, eCont, errCont := ParseExpr(e)
+			argCont, eCont, errCont := parseExpr(e, depth)
 			if errCont != nil {
 				return "", nil, nil, eCont, errCont
 			}
@@ -500,22 +638,8 @@ func parseArgList(e string) (string, []*expr, map[string]*expr, string, error) {
 			namedArgs[arg.Target()] = exp
 
 			e = eCont
-			if argStringBuffer.Len() > 0 {
-				argStringBuffer.WriteByte(',')
-			}
-			argStringBuffer.WriteString(argString[:len(argString)-len(e)])
 		} else {
-			exp := arg.toExpr().(*expr)
-			posArgs = append(posArgs, exp)
-
-			if argStringBuffer.Len() > 0 {
-				argStringBuffer.WriteByte(',')
-			}
-			if exp.IsFunc() {
-				argStringBuffer.WriteString(exp.ToString())
-			} else {
-				argStringBuffer.WriteString(argString[:len(argString)-len(e)])
-			}
+			posArgs = append(posArgs, arg.toExpr().(*expr))
 		}
 
 		// after the argument, trim any trailing spaces
@@ -530,11 +654,11 @@ func parseArgList(e string) (string, []*expr, map[string]*expr, string, error) {
 		}
 
 		if e[0] == ')' {
-			return argStringBuffer.String(), posArgs, namedArgs, e[1:], nil
+			return argString[:len(argString)-len(e)], posArgs, namedArgs, e[1:], nil
 		}
 
 		if e[0] != ',' && e[0] != ' ' {
-			return "", nil, nil, "", ErrUnexpectedCharacter
+			return "", nil, nil, e, ErrUnexpectedCharacter
 		}
 
 		e = e[1:]
@@ -585,7 +709,7 @@ FOR:
 			// No way escape { in metric names, thus using it
 			// in the range brackets should be an error.
 			if brackets > 0 {
-				return s, "", ErrBraceInBrackets
+				return s, s[i:], ErrBraceInBrackets
 			}
 
 			braces++
@@ -593,9 +717,9 @@ FOR:
 			// No way escape } in metric names, thus using it
 			// in the range brackets should be an error.
 			if brackets > 0 {
-				return s, "", ErrBraceInBrackets
+				return s, s[i:], ErrBraceInBrackets
 			} else if braces == 0 {
-				return s, "", ErrMissingBrace
+				return s, s[i:], ErrMissingBrace
 			}
 
 			braces--
@@ -608,7 +732,7 @@ FOR:
 			// user and no metrics are returned. It's arguably
 			// worse than just return an error.
 			if brackets > 0 {
-				return s, "", ErrNestedBrackets
+				return s, s[i:], ErrNestedBrackets
 			}
 
 			brackets++
@@ -616,7 +740,7 @@ FOR:
 			// No way to escape braces {} and brackets [] in
 			// graphite query, thus missing open [ means it's a query bug.
 			if brackets == 0 {
-				return s, "", ErrMissingBracket
+				return s, s[i:], ErrMissingBracket
 			}
 
 			brackets--
@@ -625,7 +749,7 @@ FOR:
 			// metric name is not allowed to have comma within it,
 			// thus it isn't allowed to query it within [].
 			if brackets > 0 {
-				return s, "", ErrCommaInBrackets
+				return s, s[i:], ErrCommaInBrackets
 			}
 
 			if braces == 0 {
@@ -643,10 +767,10 @@ FOR:
 			// the current parser also doesn't support spaces in
 			// value list syntax {} and would return an 400 error.
 			if braces > 0 {
-				return s, "", ErrSpacesInBraces
+				return s, s[i:], ErrSpacesInBraces
 			}
 			if brackets > 0 {
-				return s, "", ErrSpacesInBrackets
+				return s, s[i:], ErrSpacesInBrackets
 			}
 
 			break FOR
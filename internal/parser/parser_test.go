@@ -1,8 +1,10 @@
 package parser
 
 import (
+	"errors"
 	"reflect"
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/davecgh/go-spew/spew"
@@ -301,7 +303,7 @@ func TestParseExpr(t *testing.T) {
 					},
 					{etype: EtString, valStr: "5min"},
 				},
-				argString: `aliasByNode(company.server*.applicationInstance.requestsHandled,1),"5min"`,
+				argString: `company.server*.applicationInstance.requestsHandled|aliasByNode(1),"5min"`,
 			},
 		},
 		{
@@ -424,8 +426,7 @@ func TestParseExpr(t *testing.T) {
 					},
 					{target: "s"},
 				},
-				// The reason that func is without any whitespaces is that we behave argString of funcs exceptionally.
-				argString: "func(\ng\r),   \ns\t",
+				argString: "  \rfunc \n (\ng\r)  ,   \ns\t",
 			},
 		},
 	}
@@ -437,7 +438,7 @@ func TestParseExpr(t *testing.T) {
 			t.Logf("run case: go test -run 'TestParseExpr/%s'", regexp.QuoteMeta(tt.s))
 
 			e, _, err := ParseExpr(tt.s)
-			if err != tt.err {
+			if !errors.Is(err, tt.err) {
 				t.Errorf(`parse for %+v expects error "%v" but received "%v"`, tt.s, tt.err, err)
 			}
 			if err == nil && !reflect.DeepEqual(e, tt.e) {
@@ -446,3 +447,243 @@ func TestParseExpr(t *testing.T) {
 		})
 	}
 }
+
+func TestParseExprLimits(t *testing.T) {
+	SetLimits(Limits{MaxDepth: 2, MaxArguments: 3, MaxLength: 64, MaxPipes: 2})
+	defer SetLimits(Limits{})
+
+	tests := []struct {
+		s     string
+		limit string
+	}{
+		{s: "f(g(x))"},
+		{s: "g(x)|f()"},
+		{s: "f(a,b,c=1)"},
+		{s: "f(g(h(x)))", limit: "depth"},
+		{s: "g(x)|f()|h()", limit: "depth"},
+		{s: "f(h(x)|g())", limit: "depth"},
+		{s: "x|f()|g()"},
+		{s: "x|f()|g()|h()", limit: "pipes"},
+		{s: "f(a,b,c,d)", limit: "arguments"},
+		{s: "f(a,b,c,d=1)", limit: "arguments"},
+		{s: "f(" + strings.Repeat("a", 64) + ")", limit: "length"},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.s, func(t *testing.T) {
+			_, _, err := ParseExpr(tt.s)
+			if tt.limit == "" {
+				if err != nil {
+					t.Errorf("unexpected error %v", err)
+				}
+				return
+			}
+
+			var limitErr LimitError
+			if !errors.As(err, &limitErr) || !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("expected a limit error, got %v", err)
+			}
+			if limitErr.Limit != tt.limit {
+				t.Errorf("expected the %s limit, got %s", tt.limit, limitErr.Limit)
+			}
+		})
+	}
+}
+
+func TestSyntaxError(t *testing.T) {
+	tests := []struct {
+		s      string
+		err    error
+		offset int
+		token  string
+	}{
+		{s: "sum(foo.bar baz)", err: ErrUnexpectedCharacter, offset: 12, token: "baz"},
+		{s: "sum(foo.{bar baz})", err: ErrSpacesInBraces, offset: 12, token: " "},
+		{s: "sum(foo.bar]", err: ErrMissingBracket, offset: 11, token: "]"},
+		{s: "sum(foo.bar", err: ErrMissingComma, offset: 11},
+		{s: "alias(foo.bar,'baz)", err: ErrMissingQuote, offset: 19},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.s, func(t *testing.T) {
+			_, _, err := ParseExpr(tt.s)
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) || !errors.Is(err, tt.err) {
+				t.Fatalf("expected a syntax error wrapping %v, got %v", tt.err, err)
+			}
+			if syntaxErr.Offset != tt.offset || syntaxErr.Token != tt.token || syntaxErr.Message != tt.err.Error() {
+				t.Errorf("expected %q at offset %d, got %+v", tt.token, tt.offset, syntaxErr)
+			}
+		})
+	}
+}
+
+func TestPipeError(t *testing.T) {
+	tests := []struct {
+		s       string
+		err     error
+		offset  int
+		token   string
+		message string
+	}{
+		{
+			s:       "foo.bar|sum()| baz.qux",
+			err:     ErrPipeToNotFunction,
+			offset:  15,
+			token:   "baz.qux",
+			message: "pipe to not a function: pipe 2 is to baz.qux",
+		},
+		{
+			s:       "sum(foo|'bar', baz)",
+			err:     ErrPipeToNotFunction,
+			offset:  8,
+			token:   "'",
+			message: "pipe to not a function: pipe 1 is to 'bar'",
+		},
+		{
+			s:       "foo|sum()|",
+			err:     ErrMissingExpr,
+			offset:  10,
+			message: "missing expression: pipe 2 is to nothing",
+		},
+		{
+			s:       "sum(foo| , bar)",
+			err:     ErrMissingExpr,
+			offset:  9,
+			token:   ",",
+			message: "missing expression: pipe 1 is to nothing",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.s, func(t *testing.T) {
+			_, _, err := ParseExpr(tt.s)
+
+			var syntaxErr *SyntaxError
+			if !errors.As(err, &syntaxErr) || !errors.Is(err, tt.err) {
+				t.Fatalf("expected a syntax error wrapping %v, got %v", tt.err, err)
+			}
+			if syntaxErr.Offset != tt.offset || syntaxErr.Token != tt.token || syntaxErr.Message != tt.message {
+				t.Errorf("expected %q at offset %d, %q, got %+v", tt.token, tt.offset, tt.message, syntaxErr)
+			}
+		})
+	}
+}
+
+func TestToString(t *testing.T) {
+	tests := []struct {
+		s         string
+		str       string
+		canonical string
+	}{
+		{
+			s:         "aliasSub(sumSeries(foo.*, bar), '(.*)', '\\1')",
+			str:       "aliasSub(sumSeries(foo.*, bar), '(.*)', '\\1')",
+			canonical: "aliasSub(sumSeries(foo.*,bar),'(.*)','\\1')",
+		},
+		{
+			s:         "summarize(foo, '1h', alignToFrom=true, func='max')",
+			str:       "summarize(foo, '1h', alignToFrom=true, func='max')",
+			canonical: "summarize(foo,'1h',alignToFrom=true,func='max')",
+		},
+		{
+			s:         "summarize(foo, func=\"max\", alignToFrom=true, '1h')",
+			str:       "summarize(foo, func=\"max\", alignToFrom=true, '1h')",
+			canonical: "summarize(foo,'1h',alignToFrom=true,func='max')",
+		},
+		{
+			s:         "scale( sum ( foo|offset(1.50) ) , 2e1 )",
+			str:       "scale( sum ( foo|offset(1.50) ) , 2e1 )",
+			canonical: "scale(sum(offset(foo,1.5)),20)",
+		},
+		{
+			s:         `alias(foo, "it's")`,
+			str:       `alias(foo, "it's")`,
+			canonical: `alias(foo,"it's")`,
+		},
+		{
+			s:         "foo|scale(2)",
+			str:       "scale(foo,2)",
+			canonical: "scale(foo,2)",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.s, func(t *testing.T) {
+			e, _, err := ParseExpr(tt.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.ToString(); got != tt.str {
+				t.Errorf("expected %q, got %q", tt.str, got)
+			}
+			if got := e.ToCanonicalString(); got != tt.canonical {
+				t.Errorf("expected canonical %q, got %q", tt.canonical, got)
+			}
+		})
+	}
+}
+
+func TestNewExprNamedArgsOrder(t *testing.T) {
+	e := NewExpr("summarize", "foo", NamedArgs{"func": ArgValue("max"), "alignToFrom": ArgValue("true"), "bucket": ArgName("1h")})
+	if got := e.RawArgs(); got != "foo,alignToFrom=true,bucket=1h,func=max" {
+		t.Errorf("expected the named arguments sorted by name, got %q", got)
+	}
+}
+
+func TestGetArgs(t *testing.T) {
+	e, _, err := ParseExpr(`f(foo.bar, 'a', "b", 1, -2.5, 'c')`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	strs, err := e.GetStringArgs(1)
+	if !errors.Is(err, ErrBadType) {
+		t.Errorf("expected ints not to be strings, got %v, %v", strs, err)
+	}
+	strs, err = e.GetStringArgs(5)
+	if err != nil || !reflect.DeepEqual(strs, []string{"c"}) {
+		t.Errorf("expected [c], got %v, %v", strs, err)
+	}
+
+	floats, err := e.GetFloatArgs(3)
+	if !errors.Is(err, ErrBadType) {
+		t.Errorf("expected strings not to be floats, got %v, %v", floats, err)
+	}
+
+	nodes, err := e.GetNodeOrTagArgs(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []NodeOrTag{{IsTag: true, Tag: "a"}, {IsTag: true, Tag: "b"}, {Node: 1}, {Node: -2}, {IsTag: true, Tag: "c"}}
+	if !reflect.DeepEqual(nodes, expected) {
+		t.Errorf("expected %v, got %v", expected, nodes)
+	}
+	if _, err := e.GetNodeOrTagArgs(0); !errors.Is(err, ErrBadType) {
+		t.Errorf("expected a series not to be a node, got %v", err)
+	}
+
+	for _, get := range []func(int) error{
+		func(n int) error { _, err := e.GetStringArgs(n); return err },
+		func(n int) error { _, err := e.GetFloatArgs(n); return err },
+		func(n int) error { _, err := e.GetNodeOrTagArgs(n); return err },
+	} {
+		if err := get(6); !errors.Is(err, ErrMissingArgument) {
+			t.Errorf("expected %v past the last argument, got %v", ErrMissingArgument, err)
+		}
+	}
+
+	e, _, err = ParseExpr(`f(foo.bar, 1, 2.5, -3)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	floats, err = e.GetFloatArgs(1)
+	if err != nil || !reflect.DeepEqual(floats, []float64{1, 2.5, -3}) {
+		t.Errorf("expected [1 2.5 -3], got %v, %v", floats, err)
+	}
+}
@@ -0,0 +1,433 @@
+/*
+Package api is the stable API of carbonapi for the programs that embed it: it
+parses Graphite targets, evaluates them over series, and registers the
+functions they call.
+
+The rest of the repository is free to change between releases; this package
+is not. It owns all of its types, and converts them to and from those of the
+packages that implement them, so that their changes stay behind it. Within a
+major version of the module, as tagged vMAJOR.MINOR.PATCH, names are only
+ever added to this package: they are not removed, and their signatures and
+behaviour do not change. Version is the version of the API of the tree, and
+testdata/api.txt lists the API it promises, see TestAPICompatibility.
+*/
+package api
+
+import (
+	"context"
+	"errors"
+	"math"
+
+	"github.com/bookingcom/carbonapi/internal/expr"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
+
+	"go.uber.org/zap"
+)
+
+// Version is the semantic version of the API of this package.
+const Version = "1.0.0"
+
+// Expr is a parsed target.
+type Expr struct {
+	e parser.Expr
+}
+
+// String returns the target as it is written.
+func (e Expr) String() string {
+	if e.e == nil {
+		return ""
+	}
+	return e.e.ToString()
+}
+
+// Metrics returns the series the target asks for when it is evaluated
+// between the unix times from and until. Functions may ask for more while
+// they are evaluated, e.g. to shift them in time.
+func (e Expr) Metrics(from, until int64) []MetricRequest {
+	if e.e == nil {
+		return nil
+	}
+
+	var requests []MetricRequest
+	for _, m := range e.e.Metrics() {
+		requests = append(requests, MetricRequest{
+			Metric: m.Metric,
+			From:   m.From + from,
+			Until:  m.Until + until,
+		})
+	}
+
+	return requests
+}
+
+// MetricRequest asks for the series of a metric, a name or a glob, between
+// two unix times.
+type MetricRequest struct {
+	Metric string
+	From   int64
+	Until  int64
+}
+
+// ParserLimits bound the targets the parser accepts. Zero is no limit.
+type ParserLimits struct {
+	// MaxDepth limits the nesting of function calls, pipes included.
+	MaxDepth int
+	// MaxArguments limits the arguments, named or not, of a function call.
+	MaxArguments int
+	// MaxLength limits the length of the target, in bytes.
+	MaxLength int
+	// MaxPipes limits the pipes of a chain, e.g. 2 for a|b()|c().
+	MaxPipes int
+}
+
+// SyntaxError tells where a target is invalid.
+type SyntaxError struct {
+	// Offset is the offset in bytes of Token in the target.
+	Offset int
+	// Token is where parsing failed, empty at the end of the target.
+	Token   string
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	return e.Message
+}
+
+// Series is a series of datapoints, the values of targets. The point i is
+// at the unix time StartTime + i*StepTime, and absent if IsAbsent[i].
+type Series struct {
+	Name      string
+	StartTime int64
+	StopTime  int64
+	StepTime  int64
+	Values    []float64
+	IsAbsent  []bool
+}
+
+// NewSeries returns a series named name of the values starting at the unix
+// time start, step seconds apart. NaN values are absent.
+func NewSeries(name string, values []float64, step, start int64) *Series {
+	s := &Series{
+		Name:      name,
+		StartTime: start,
+		StopTime:  start + int64(len(values))*step,
+		StepTime:  step,
+		Values:    make([]float64, len(values)),
+		IsAbsent:  make([]bool, len(values)),
+	}
+	for i, v := range values {
+		if math.IsNaN(v) {
+			s.IsAbsent[i] = true
+		} else {
+			s.Values[i] = v
+		}
+	}
+
+	return s
+}
+
+// Parse parses target. It fails with a *SyntaxError if target is invalid.
+func Parse(target string) (Expr, error) {
+	e, rest, err := parser.ParseExpr(target)
+	if err == nil && rest != "" {
+		err = parser.NewSyntaxError(target, rest, errors.New("unexpected input"))
+	}
+	if err != nil {
+		var syntaxErr *parser.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return Expr{}, &SyntaxError{
+				Offset:  syntaxErr.Offset,
+				Token:   syntaxErr.Token,
+				Message: syntaxErr.Error(),
+			}
+		}
+		return Expr{}, err
+	}
+
+	return Expr{e: e}, nil
+}
+
+// SetParserLimits sets the limits of the parser, for all the targets parsed
+// afterwards.
+func SetParserLimits(l ParserLimits) {
+	parser.SetLimits(parser.Limits{
+		MaxDepth:     l.MaxDepth,
+		MaxArguments: l.MaxArguments,
+		MaxLength:    l.MaxLength,
+		MaxPipes:     l.MaxPipes,
+	})
+}
+
+// Fetch returns the series of the metric requests, by request. Requests it
+// has no series for are left out.
+type Fetch func(ctx context.Context, requests []MetricRequest) (map[MetricRequest][]*Series, error)
+
+// Eval evaluates e between the unix times from and until, with the series of
+// the metrics it asks for in values. fetch, if not nil, fetches those that
+// are not in values, and those that functions only ask for while they are
+// evaluated. The series of values are not changed.
+func Eval(ctx context.Context, e Expr, from, until int64, values map[MetricRequest][]*Series, fetch Fetch) ([]*Series, error) {
+	if e.e == nil {
+		return nil, errors.New("empty expression")
+	}
+
+	m := make(map[parser.MetricRequest][]*types.MetricData, len(values))
+	for r, series := range values {
+		m[parser.MetricRequest(r)] = toMetricData(series)
+	}
+
+	getTargetData := fetchTargetData(fetch)
+	from32, until32 := timestamp(from), timestamp(until)
+	if err, _ := getTargetData(ctx, e.e, from32, until32, m); err != nil {
+		return nil, err
+	}
+	results, err := expr.EvalExpr(ctx, e.e, from32, until32, m, getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromMetricData(results), nil
+}
+
+// FunctionDescription describes a function and its parameters, as listed
+// by the /functions handler.
+type FunctionDescription struct {
+	Description string
+	// Function is the signature, e.g. double(seriesList).
+	Function string
+	Group    string
+	Params   []FunctionParam
+}
+
+// FunctionParam describes a parameter of a function.
+type FunctionParam struct {
+	Name string
+	// Type is the type of the parameter as graphite-web names it, e.g.
+	// seriesList, integer or string.
+	Type     string
+	Required bool
+	Multiple bool
+}
+
+// Function is a Graphite function that targets call.
+type Function interface {
+	// Do evaluates a call of the function.
+	Do(ctx context.Context, call *Call) ([]*Series, error)
+	// Description describes the function.
+	Description() FunctionDescription
+}
+
+// Call is a call of a function in a target being evaluated.
+type Call struct {
+	e             parser.Expr
+	from, until   int32
+	values        map[parser.MetricRequest][]*types.MetricData
+	getTargetData interfaces.GetTargetData
+	evaluator     interfaces.Evaluator
+}
+
+// Name returns the name the function is called by.
+func (c *Call) Name() string {
+	return c.e.Target()
+}
+
+// String returns the call as it is written.
+func (c *Call) String() string {
+	return c.e.ToString()
+}
+
+// From returns the unix time the target is evaluated from.
+func (c *Call) From() int64 {
+	return int64(c.from)
+}
+
+// Until returns the unix time the target is evaluated until.
+func (c *Call) Until() int64 {
+	return int64(c.until)
+}
+
+// NumArgs returns the number of positional arguments of the call.
+func (c *Call) NumArgs() int {
+	return len(c.e.Args())
+}
+
+// StringArg returns the positional argument i, a string.
+func (c *Call) StringArg(i int) (string, error) {
+	return c.e.GetStringArg(i)
+}
+
+// FloatArg returns the positional argument i, a number.
+func (c *Call) FloatArg(i int) (float64, error) {
+	return c.e.GetFloatArg(i)
+}
+
+// IntArg returns the positional argument i, an integer.
+func (c *Call) IntArg(i int) (int, error) {
+	return c.e.GetIntArg(i)
+}
+
+// SeriesArg evaluates the positional argument i, a series list. The series
+// are copies, which the function may change.
+func (c *Call) SeriesArg(ctx context.Context, i int) ([]*Series, error) {
+	if i >= len(c.e.Args()) {
+		return nil, parser.ErrMissingArgument
+	}
+	arg := c.e.Args()[i]
+	if !arg.IsName() && !arg.IsFunc() {
+		return nil, parser.ErrMissingTimeseries
+	}
+
+	series, err := c.evaluator.EvalExpr(ctx, arg, c.from, c.until, c.values, c.getTargetData)
+	if err != nil {
+		return nil, err
+	}
+
+	return fromMetricData(series), nil
+}
+
+// RegisterFunction registers f under name, replacing the function of that
+// name if there is one, so that the targets evaluated afterwards can call it.
+// logger, if not nil, tells when a function is replaced.
+func RegisterFunction(name string, f Function, logger *zap.Logger) error {
+	d := f.Description()
+	description := types.FunctionDescription{
+		Description: d.Description,
+		Function:    d.Function,
+		Group:       d.Group,
+		Module:      "graphite.render.functions.custom",
+		Name:        name,
+	}
+	for _, p := range d.Params {
+		var t types.FunctionType
+		if err := t.UnmarshalJSON([]byte(p.Type)); err != nil {
+			return err
+		}
+		description.Params = append(description.Params, types.FunctionParam{
+			Name:     p.Name,
+			Type:     t,
+			Required: p.Required,
+			Multiple: p.Multiple,
+		})
+	}
+
+	if logger == nil {
+		logger = zap.NewNop()
+	}
+	metadata.RegisterFunction(name, &function{f: f, description: description}, logger)
+
+	return nil
+}
+
+// function adapts a Function of the API to the one of the evaluator.
+type function struct {
+	interfaces.FunctionBase
+
+	f           Function
+	description types.FunctionDescription
+}
+
+func (f *function) Do(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
+	results, err := f.f.Do(ctx, &Call{
+		e:             e,
+		from:          from,
+		until:         until,
+		values:        values,
+		getTargetData: getTargetData,
+		evaluator:     f.Evaluator,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return toMetricData(results), nil
+}
+
+func (f *function) Description() map[string]types.FunctionDescription {
+	return map[string]types.FunctionDescription{f.description.Name: f.description}
+}
+
+// fetchTargetData returns the GetTargetData of the evaluator that gets the
+// series missing from its values with fetch.
+func fetchTargetData(fetch Fetch) interfaces.GetTargetData {
+	return func(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData) (error, int) {
+		if fetch == nil {
+			return nil, 0
+		}
+
+		var missing []MetricRequest
+		for _, m := range e.Metrics() {
+			m.From += int64(from)
+			m.Until += int64(until)
+			if _, ok := values[m]; !ok {
+				missing = append(missing, MetricRequest(m))
+			}
+		}
+		if len(missing) == 0 {
+			return nil, 0
+		}
+
+		fetched, err := fetch(ctx, missing)
+		if err != nil {
+			return err, 0
+		}
+		n := 0
+		for r, series := range fetched {
+			values[parser.MetricRequest(r)] = toMetricData(series)
+			n += len(series)
+		}
+
+		return nil, n
+	}
+}
+
+// toMetricData copies series to the series of the evaluator.
+func toMetricData(series []*Series) []*types.MetricData {
+	data := make([]*types.MetricData, 0, len(series))
+	for _, s := range series {
+		if s == nil {
+			continue
+		}
+		absent := types.NewAbsence(len(s.Values))
+		for i := range s.Values {
+			absent.Set(i, i < len(s.IsAbsent) && s.IsAbsent[i])
+		}
+		d := types.New(s.Name, append([]float64(nil), s.Values...), absent, timestamp(s.StepTime), timestamp(s.StartTime))
+		d.StopTime = timestamp(s.StopTime)
+		data = append(data, d)
+	}
+
+	return data
+}
+
+// fromMetricData copies the series of the evaluator.
+func fromMetricData(data []*types.MetricData) []*Series {
+	series := make([]*Series, 0, len(data))
+	for _, d := range data {
+		series = append(series, &Series{
+			Name:      d.Name,
+			StartTime: int64(d.StartTime),
+			StopTime:  int64(d.StopTime),
+			StepTime:  int64(d.StepTime),
+			Values:    append([]float64(nil), d.Values...),
+			IsAbsent:  d.IsAbsent.Bools(),
+		})
+	}
+
+	return series
+}
+
+// timestamp converts t to the 32-bit unix times of the evaluator, clamped to
+// the nearest one.
+func timestamp(t int64) int32 {
+	if t > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if t < math.MinInt32 {
+		return math.MinInt32
+	}
+
+	return int32(t)
+}
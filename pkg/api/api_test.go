@@ -0,0 +1,121 @@
+package api
+
+import (
+	"context"
+	"math"
+	"reflect"
+	"testing"
+)
+
+type double struct{}
+
+func (f *double) Do(ctx context.Context, call *Call) ([]*Series, error) {
+	series, err := call.SeriesArg(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, s := range series {
+		for i := range s.Values {
+			s.Values[i] *= 2
+		}
+		s.Name = "double(" + s.Name + ")"
+	}
+	return series, nil
+}
+
+func (f *double) Description() FunctionDescription {
+	return FunctionDescription{
+		Function: "double(seriesList)",
+		Group:    "Transform",
+		Params:   []FunctionParam{{Name: "seriesList", Type: "seriesList", Required: true}},
+	}
+}
+
+func TestRegisterAndEval(t *testing.T) {
+	if err := RegisterFunction("double", &double{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	e, err := Parse("double(foo.bar)")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := MetricRequest{Metric: "foo.bar", From: 60, Until: 180}
+	if got := e.Metrics(60, 180); !reflect.DeepEqual(got, []MetricRequest{request}) {
+		t.Fatalf("unexpected metrics %+v", got)
+	}
+
+	input := NewSeries("foo.bar", []float64{1, math.NaN(), 2}, 60, 60)
+	values := map[MetricRequest][]*Series{request: {input}}
+	got, err := Eval(context.Background(), e, 60, 180, values, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*Series{{
+		Name:      "double(foo.bar)",
+		StartTime: 60,
+		StopTime:  240,
+		StepTime:  60,
+		Values:    []float64{2, 0, 4},
+		IsAbsent:  []bool{false, true, false},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got[0], want[0])
+	}
+	if input.Values[0] != 1 {
+		t.Errorf("the series of values changed: %+v", input)
+	}
+}
+
+func TestEvalFetch(t *testing.T) {
+	e, err := Parse("foo.*")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var fetched []MetricRequest
+	fetch := func(ctx context.Context, requests []MetricRequest) (map[MetricRequest][]*Series, error) {
+		fetched = append(fetched, requests...)
+		return map[MetricRequest][]*Series{
+			requests[0]: {NewSeries("foo.bar", []float64{1}, 60, 60)},
+		}, nil
+	}
+
+	got, err := Eval(context.Background(), e, 60, 120, nil, fetch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []MetricRequest{{Metric: "foo.*", From: 60, Until: 120}}; !reflect.DeepEqual(fetched, want) {
+		t.Errorf("fetched %+v, want %+v", fetched, want)
+	}
+	if len(got) != 1 || got[0].Name != "foo.bar" {
+		t.Errorf("unexpected result %+v", got)
+	}
+}
+
+func TestRegisterFunctionInvalidParam(t *testing.T) {
+	f := &invalidParam{}
+	if err := RegisterFunction("invalidParam", f, nil); err == nil {
+		t.Error("expected an error for an unknown parameter type")
+	}
+}
+
+type invalidParam struct {
+	double
+}
+
+func (f *invalidParam) Description() FunctionDescription {
+	return FunctionDescription{Params: []FunctionParam{{Name: "x", Type: "unknown"}}}
+}
+
+func TestParseSyntaxError(t *testing.T) {
+	for _, target := range []string{"foo.bar(", "foo.bar) baz"} {
+		_, err := Parse(target)
+		if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("%s: expected a syntax error, got %v", target, err)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package api
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update testdata/api.txt with the API of the package")
+
+const apiFile = "testdata/api.txt"
+
+// TestAPICompatibility compares the exported API of the package to the one
+// it promises in testdata/api.txt. A name that is missing or whose signature
+// changed breaks the programs that embed carbonapi; a new one has to be
+// added to testdata/api.txt, with go test -update, and Version bumped.
+func TestAPICompatibility(t *testing.T) {
+	got, err := exportedAPI(".")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *update {
+		if err := ioutil.WriteFile(apiFile, []byte(strings.Join(got, "\n")+"\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	b, err := ioutil.ReadFile(apiFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := strings.Split(strings.TrimSpace(string(b)), "\n")
+
+	have := make(map[string]bool, len(got))
+	for _, l := range got {
+		have[l] = true
+	}
+	promised := make(map[string]bool, len(want))
+	for _, l := range want {
+		promised[l] = true
+		if !have[l] {
+			t.Errorf("incompatible change, %s is gone or changed", l)
+		}
+	}
+	for _, l := range got {
+		if !promised[l] {
+			t.Errorf("%s is new, add it to %s with go test -update and bump Version", l, apiFile)
+		}
+	}
+}
+
+// exportedAPI lists the exported declarations of the package in dir, one
+// per line: constants, variables, functions, types, their fields and their
+// methods, with their types.
+func exportedAPI(dir string) ([]string, error) {
+	fset := token.NewFileSet()
+	pkgs, err := parser.ParseDir(fset, dir, func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	node := func(n ast.Node) string {
+		var buf bytes.Buffer
+		_ = printer.Fprint(&buf, fset, n)
+		return strings.Join(strings.Fields(buf.String()), " ")
+	}
+
+	var api []string
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			for _, decl := range f.Decls {
+				switch d := decl.(type) {
+				case *ast.FuncDecl:
+					if !d.Name.IsExported() {
+						continue
+					}
+					if d.Recv == nil {
+						api = append(api, fmt.Sprintf("func %s%s", d.Name.Name, strings.TrimPrefix(node(d.Type), "func")))
+						continue
+					}
+					recv := node(d.Recv.List[0].Type)
+					if !ast.IsExported(strings.TrimPrefix(recv, "*")) {
+						continue
+					}
+					api = append(api, fmt.Sprintf("method (%s) %s%s", recv, d.Name.Name, strings.TrimPrefix(node(d.Type), "func")))
+				case *ast.GenDecl:
+					for _, spec := range d.Specs {
+						switch s := spec.(type) {
+						case *ast.ValueSpec:
+							for _, name := range s.Names {
+								if name.IsExported() {
+									api = append(api, fmt.Sprintf("%s %s", d.Tok, name.Name))
+								}
+							}
+						case *ast.TypeSpec:
+							if s.Name.IsExported() {
+								api = append(api, typeAPI(s, node)...)
+							}
+						}
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(api)
+
+	return api, nil
+}
+
+func typeAPI(s *ast.TypeSpec, node func(ast.Node) string) []string {
+	name := s.Name.Name
+	switch t := s.Type.(type) {
+	case *ast.StructType:
+		api := []string{fmt.Sprintf("type %s struct", name)}
+		for _, field := range t.Fields.List {
+			for _, n := range field.Names {
+				if n.IsExported() {
+					api = append(api, fmt.Sprintf("field %s.%s %s", name, n.Name, node(field.Type)))
+				}
+			}
+		}
+		return api
+	case *ast.InterfaceType:
+		api := []string{fmt.Sprintf("type %s interface", name)}
+		for _, m := range t.Methods.List {
+			for _, n := range m.Names {
+				api = append(api, fmt.Sprintf("method %s.%s%s", name, n.Name, strings.TrimPrefix(node(m.Type), "func")))
+			}
+		}
+		return api
+	default:
+		return []string{fmt.Sprintf("type %s %s", name, node(s.Type))}
+	}
+}
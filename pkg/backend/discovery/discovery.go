@@ -0,0 +1,152 @@
+// Package discovery finds the addresses of backends in DNS SRV records or in
+// a file, and watches them for changes.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Discoverer finds the addresses of backends, e.g. http://host:port.
+type Discoverer interface {
+	Backends(context.Context) ([]string, error)
+}
+
+// New makes the Discoverer of config.
+func New(config cfg.Discovery) (Discoverer, error) {
+	if config.Name == "" {
+		return nil, errors.New("discovery without a name")
+	}
+
+	switch config.Type {
+	case "srv":
+		scheme := config.Scheme
+		if scheme == "" {
+			scheme = "http"
+		}
+		if scheme != "http" && scheme != "grpc" {
+			return nil, fmt.Errorf("unknown discovery scheme %s", scheme)
+		}
+		return SRV{Name: config.Name, Scheme: scheme, Resolver: net.DefaultResolver}, nil
+	case "file":
+		return File{Path: config.Name}, nil
+	default:
+		return nil, fmt.Errorf("unknown discovery type %s", config.Type)
+	}
+}
+
+// SRV finds backends in the SRV records of Name, e.g. the ones of a headless
+// service in Kubernetes.
+type SRV struct {
+	Name     string
+	Scheme   string
+	Resolver *net.Resolver
+}
+
+// Backends returns the targets of the SRV records, in order.
+func (s SRV) Backends(ctx context.Context) ([]string, error) {
+	_, records, err := s.Resolver.LookupSRV(ctx, "", "", s.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	return srvBackends(s.Scheme, records)
+}
+
+func srvBackends(scheme string, records []*net.SRV) ([]string, error) {
+	if len(records) == 0 {
+		return nil, errors.New("no SRV records")
+	}
+
+	backends := make([]string, 0, len(records))
+	for _, r := range records {
+		host := strings.TrimSuffix(r.Target, ".")
+		backends = append(backends, scheme+"://"+net.JoinHostPort(host, strconv.Itoa(int(r.Port))))
+	}
+	sort.Strings(backends)
+
+	return backends, nil
+}
+
+// File finds backends in a file holding a JSON or YAML list of them. On
+// Linux, it notifies of the changes of the file, with inotify.
+type File struct {
+	Path string
+}
+
+// Backends returns the backends listed in the file.
+func (f File) Backends(ctx context.Context) ([]string, error) {
+	b, err := ioutil.ReadFile(f.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	var backends []string
+	if err := yaml.Unmarshal(b, &backends); err != nil {
+		return nil, fmt.Errorf("invalid backends file %s: %w", f.Path, err)
+	}
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("no backends in %s", f.Path)
+	}
+
+	return backends, nil
+}
+
+// Notifier is a Discoverer that tells when its backends may have changed,
+// e.g. a watched file. Its channel is nil if it cannot tell.
+type Notifier interface {
+	Changes(context.Context) (<-chan struct{}, error)
+}
+
+// Watch looks up the backends every interval, and whenever d notifies of a
+// change if it is a Notifier, until ctx is done, and calls update when they
+// differ from the last ones, starting with current. A failed lookup is passed
+// to onError and leaves the backends as they are, so that a DNS hiccup does
+// not take all backends away.
+func Watch(ctx context.Context, d Discoverer, interval, timeout time.Duration,
+	current []string, update func([]string) error, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var changes <-chan struct{}
+	if n, ok := d.(Notifier); ok {
+		var err error
+		if changes, err = n.Changes(ctx); err != nil {
+			onError(err)
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-changes:
+		}
+
+		lookupCtx, cancel := context.WithTimeout(ctx, timeout)
+		backends, err := d.Backends(lookupCtx)
+		cancel()
+		if err == nil && !reflect.DeepEqual(backends, current) {
+			err = update(backends)
+			if err == nil {
+				current = backends
+			}
+		}
+		if err != nil {
+			onError(err)
+		}
+	}
+}
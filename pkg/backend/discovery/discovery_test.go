@@ -0,0 +1,116 @@
+package discovery
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+func TestSRVBackends(t *testing.T) {
+	got, err := srvBackends("grpc", []*net.SRV{
+		{Target: "b.graphite.svc.", Port: 8080},
+		{Target: "a.graphite.svc.", Port: 8080},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"grpc://a.graphite.svc:8080", "grpc://b.graphite.svc:8080"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if _, err := srvBackends("http", nil); err == nil {
+		t.Error("expected an error without records")
+	}
+}
+
+func TestFileBackends(t *testing.T) {
+	dir := t.TempDir()
+	want := []string{"http://a:8080", "http://b:8080"}
+
+	for name, content := range map[string]string{
+		"backends.json": `["http://a:8080", "http://b:8080"]`,
+		"backends.yaml": "- http://a:8080\n- http://b:8080\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := File{Path: path}.Backends(context.Background())
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: expected %v, got %v", name, want, got)
+		}
+	}
+
+	empty := filepath.Join(dir, "empty.json")
+	if err := ioutil.WriteFile(empty, []byte("[]"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (File{Path: empty}).Backends(context.Background()); err == nil {
+		t.Error("expected an error for an empty list")
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, config := range []cfg.Discovery{
+		{Type: "srv"},
+		{Type: "dns", Name: "graphite"},
+		{Type: "srv", Name: "graphite", Scheme: "ftp"},
+	} {
+		if _, err := New(config); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
+
+type discovererFunc func(context.Context) ([]string, error)
+
+func (f discovererFunc) Backends(ctx context.Context) ([]string, error) {
+	return f(ctx)
+}
+
+func TestWatch(t *testing.T) {
+	lookups := [][]string{{"a"}, nil, {"a", "b"}}
+	i := 0
+	d := discovererFunc(func(context.Context) ([]string, error) {
+		if i >= len(lookups) {
+			return []string{"a", "b"}, nil
+		}
+		backends := lookups[i]
+		i++
+		if backends == nil {
+			return nil, errors.New("lookup failed")
+		}
+		return backends, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var updates [][]string
+	errs := 0
+	update := func(backends []string) error {
+		updates = append(updates, backends)
+		if len(backends) == 2 {
+			cancel()
+		}
+		return nil
+	}
+
+	Watch(ctx, d, time.Millisecond, time.Second, []string{"a"}, update, func(error) { errs++ })
+
+	if want := [][]string{{"a", "b"}}; !reflect.DeepEqual(updates, want) {
+		t.Errorf("expected updates %v, got %v", want, updates)
+	}
+	if errs != 1 {
+		t.Errorf("expected 1 error, got %d", errs)
+	}
+}
@@ -0,0 +1,53 @@
+//go:build linux
+// +build linux
+
+package discovery
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// Changes returns a channel that receives when the directory of the file
+// changes, until ctx is done. The directory is watched rather than the file,
+// which editors and Kubernetes replace rather than write to.
+func (f File) Changes(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	// non-blocking, the file is read through the runtime poller, and closing
+	// it ends the read
+	inotify := os.NewFile(uintptr(fd), "inotify")
+
+	const mask = syscall.IN_CLOSE_WRITE | syscall.IN_CREATE | syscall.IN_DELETE |
+		syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO
+	if _, err := syscall.InotifyAddWatch(fd, filepath.Dir(f.Path), mask); err != nil {
+		inotify.Close()
+		return nil, os.NewSyscallError("inotify_add_watch", err)
+	}
+
+	changes := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		inotify.Close()
+	}()
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			if _, err := inotify.Read(buf); err != nil {
+				return
+			}
+			// the backends are compared with the last ones, so any change
+			// of the directory is worth a lookup
+			select {
+			case changes <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	return changes, nil
+}
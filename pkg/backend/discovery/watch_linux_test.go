@@ -0,0 +1,60 @@
+//go:build linux
+// +build linux
+
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestWatchFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backends.yaml")
+	if err := ioutil.WriteFile(path, []byte("- http://a:8080\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	updates := make(chan []string, 1)
+	update := func(backends []string) error {
+		updates <- backends
+		cancel()
+		return nil
+	}
+	onError := func(err error) { t.Errorf("unexpected error %v", err) }
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// far longer than the test, the file is only read on change
+		Watch(ctx, File{Path: path}, time.Hour, time.Second, []string{"http://a:8080"}, update, onError)
+	}()
+
+	// replaced, the way editors and Kubernetes do
+	tmp := filepath.Join(dir, "backends.yaml.tmp")
+	if err := ioutil.WriteFile(tmp, []byte("- http://a:8080\n- http://b:8080\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	// let Watch set up its watch first
+	time.Sleep(100 * time.Millisecond)
+	if err := os.Rename(tmp, path); err != nil {
+		t.Fatal(err)
+	}
+
+	<-done
+	select {
+	case got := <-updates:
+		if want := []string{"http://a:8080", "http://b:8080"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("expected %v, got %v", want, got)
+		}
+	default:
+		t.Error("expected the backends to be updated on change")
+	}
+}
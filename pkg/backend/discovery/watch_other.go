@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package discovery
+
+import "context"
+
+// Changes returns nil: the file is only read again every interval.
+func (f File) Changes(ctx context.Context) (<-chan struct{}, error) {
+	return nil, nil
+}
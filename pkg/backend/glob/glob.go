@@ -0,0 +1,46 @@
+// Package glob matches the segments of graphite globs against names, for
+// backends that resolve them themselves.
+package glob
+
+import (
+	"path"
+	"strings"
+)
+
+// IsGlob reports whether the query has any of the glob metacharacters.
+func IsGlob(query string) bool {
+	return strings.ContainsAny(query, "*?[{")
+}
+
+// Match reports whether name matches pattern, a segment of a graphite glob.
+// It is path.Match with the alternatives of braces, as in a{b,c}d.
+func Match(pattern, name string) bool {
+	for _, p := range expandBraces(pattern) {
+		if ok, _ := path.Match(p, name); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// expandBraces returns the alternatives of a graphite glob, e.g. a{b,c}d is
+// abd or acd.
+func expandBraces(pattern string) []string {
+	open := strings.IndexByte(pattern, '{')
+	if open < 0 {
+		return []string{pattern}
+	}
+	close := strings.IndexByte(pattern[open:], '}')
+	if close < 0 {
+		return []string{pattern}
+	}
+	close += open
+
+	var expanded []string
+	for _, alternative := range strings.Split(pattern[open+1:close], ",") {
+		expanded = append(expanded, expandBraces(pattern[:open]+alternative+pattern[close+1:])...)
+	}
+
+	return expanded
+}
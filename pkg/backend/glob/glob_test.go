@@ -0,0 +1,21 @@
+package glob
+
+import "testing"
+
+func TestMatch(t *testing.T) {
+	for _, tc := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"cpu*", "cpu0", true},
+		{"cpu?", "cpu10", false},
+		{"{cpu,mem}", "mem", true},
+		{"a{b,c}d{e,f}", "acdf", true},
+		{"a{b,c}d", "aed", false},
+		{"[0-9]", "7", true},
+	} {
+		if got := Match(tc.pattern, tc.name); got != tc.want {
+			t.Errorf("Match(%q, %q) = %v, expected %v", tc.pattern, tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,380 @@
+// Package grpc implements a backend that communicates with storage nodes
+// over gRPC, using the carbonapi_v3_grpc service definition.
+//
+// Compared to the HTTP backend, it keeps a small pool of long-lived HTTP/2
+// connections per storage node, which avoids connection churn on large
+// deployments.
+package grpc
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/prioritylimiter"
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
+	"github.com/bookingcom/carbonapi/util"
+
+	"github.com/dgryski/go-expirecache"
+	"github.com/go-graphite/protocol/carbonapi_v3_grpc"
+	"github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Scheme is the address scheme that selects the gRPC backend, as in
+// "grpc://host:port".
+const Scheme = "grpc"
+
+// uuidKey is the metadata key carrying the request UUID, the gRPC counterpart
+// of the X-CTX-CarbonAPI-UUID HTTP header.
+const uuidKey = "x-ctx-carbonapi-uuid"
+
+// Backend represents a host that accepts requests for metrics over gRPC.
+type Backend struct {
+	address        string
+	dc             string
+	cluster        string
+	conns          []*grpclib.ClientConn
+	clients        []carbonapi_v3_grpc.CarbonV1Client
+	next           *uint32
+	timeout        time.Duration
+	limiter        *prioritylimiter.Limiter
+	logger         *zap.Logger
+	cache          *expirecache.Cache
+	cacheExpirySec int32
+}
+
+// Config configures a gRPC backend.
+//
+// The only required field is Address, which must be of the form
+// "[grpc://]address:port".
+type Config struct {
+	Address string // The backend address.
+
+	// Optional fields
+	DC                 string        // The DC where backend belongs to
+	Cluster            string        // The cluster where backend belongs to
+	PoolSize           int           // Number of connections to keep open to backend. Defaults to 1.
+	Timeout            time.Duration // Set per-call deadline. Defaults to no deadline.
+	ConnectTimeout     time.Duration // Set timeout of establishing a connection. Defaults to 20 seconds.
+	KeepAliveInterval  time.Duration // Set interval of keepalive pings. Defaults to no pings.
+	KeepAliveTimeout   time.Duration // Set time to wait for a keepalive ping ack. Defaults to 20 seconds.
+	Limit              int           // Set limit of concurrent requests to backend. Defaults to no limit.
+	PathCacheExpirySec uint32        // Set time in seconds before items in path cache expire. Defaults to 10 minutes.
+	Logger             *zap.Logger   // Logger to use. Defaults to a no-op logger.
+	UserAgent          string        // User-Agent of connections, prepended to the one of grpc-go.
+	ActiveRequests     prometheus.Gauge
+	WaitingRequests    prometheus.Gauge
+
+	// MaxQueueDepth limits the requests waiting for Limit. The ones beyond
+	// fail with prioritylimiter.ErrQueueFull. Defaults to no limit.
+	MaxQueueDepth int
+	// QueueTime observes the seconds requests wait for Limit. Optional.
+	QueueTime prometheus.Histogram
+}
+
+// New creates a new backend from the given configuration. Connections are
+// established lazily, so New does not fail if the backend is down.
+func New(cfg Config) (*Backend, error) {
+	b := &Backend{
+		address: strings.TrimPrefix(cfg.Address, Scheme+"://"),
+		dc:      cfg.DC,
+		cluster: cfg.Cluster,
+		next:    new(uint32),
+		timeout: cfg.Timeout,
+		cache:   expirecache.New(0),
+	}
+
+	if b.address == "" {
+		return nil, errors.New("empty backend address")
+	}
+
+	if cfg.PathCacheExpirySec > 0 {
+		b.cacheExpirySec = int32(cfg.PathCacheExpirySec)
+	} else {
+		b.cacheExpirySec = int32(10 * time.Minute / time.Second)
+	}
+
+	if cfg.Limit > 0 {
+		options := []prioritylimiter.LimiterOption{
+			prioritylimiter.WithMaxWaiting(cfg.MaxQueueDepth),
+			prioritylimiter.WithQueueTime(cfg.QueueTime),
+		}
+		if cfg.ActiveRequests != nil && cfg.WaitingRequests != nil {
+			options = append(options, prioritylimiter.WithMetrics(cfg.ActiveRequests, cfg.WaitingRequests))
+		}
+		b.limiter = prioritylimiter.New(cfg.Limit, options...)
+	}
+
+	if cfg.Logger != nil {
+		b.logger = cfg.Logger
+	} else {
+		b.logger = zap.New(nil)
+	}
+
+	opts := []grpclib.DialOption{
+		grpclib.WithInsecure(),
+	}
+	if cfg.UserAgent != "" {
+		opts = append(opts, grpclib.WithUserAgent(cfg.UserAgent))
+	}
+	if cfg.ConnectTimeout > 0 {
+		opts = append(opts, grpclib.WithConnectParams(connectParams(cfg)))
+	}
+	if cfg.KeepAliveInterval > 0 {
+		kp := keepalive.ClientParameters{
+			Time:                cfg.KeepAliveInterval,
+			Timeout:             cfg.KeepAliveTimeout,
+			PermitWithoutStream: true,
+		}
+		opts = append(opts, grpclib.WithKeepaliveParams(kp))
+	}
+
+	poolSize := cfg.PoolSize
+	if poolSize <= 0 {
+		poolSize = 1
+	}
+
+	for i := 0; i < poolSize; i++ {
+		conn, err := grpclib.Dial(b.address, opts...)
+		if err != nil {
+			b.Close()
+			return nil, errors.Wrapf(err, "failed to dial %s", b.address)
+		}
+
+		b.conns = append(b.conns, conn)
+		b.clients = append(b.clients, carbonapi_v3_grpc.NewCarbonV1Client(conn))
+	}
+
+	return b, nil
+}
+
+// connectParams are the parameters of the connections to the backend. The
+// backoff is that of gRPC by default: left zero, a backend that is down
+// would be redialed without a pause.
+func connectParams(cfg Config) grpclib.ConnectParams {
+	return grpclib.ConnectParams{
+		Backoff:           backoff.DefaultConfig,
+		MinConnectTimeout: cfg.ConnectTimeout,
+	}
+}
+
+// Close closes all connections to the backend.
+func (b Backend) Close() error {
+	var err error
+	for _, conn := range b.conns {
+		if cerr := conn.Close(); cerr != nil {
+			err = cerr
+		}
+	}
+
+	return err
+}
+
+// GetServerAddress returns the server address for this backend.
+func (b Backend) GetServerAddress() string {
+	return b.address
+}
+
+// Logger returns logger for this backend. Needed to satisfy interface.
+func (b Backend) Logger() *zap.Logger {
+	return b.logger
+}
+
+// client picks a connection from the pool in round-robin order.
+func (b Backend) client() carbonapi_v3_grpc.CarbonV1Client {
+	i := atomic.AddUint32(b.next, 1)
+
+	return b.clients[int(i)%len(b.clients)]
+}
+
+func (b Backend) enter(ctx context.Context) error {
+	if b.limiter == nil {
+		return nil
+	}
+	priority := util.GetPriority(ctx)
+	uuid := util.GetUUID(ctx)
+	return b.limiter.EnterAs(ctx, util.GetTenant(ctx), priority, uuid)
+}
+
+func (b Backend) leave() error {
+	if b.limiter == nil {
+		return nil
+	}
+	return b.limiter.Leave()
+}
+
+func (b Backend) setTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.timeout > 0 {
+		return context.WithTimeout(ctx, b.timeout)
+	}
+
+	return context.WithCancel(ctx)
+}
+
+// call makes a call to a backend.
+// If the backend timeout is positive, call will override the context timeout
+// with the backend timeout.
+// call ensures that the outgoing request has a UUID set.
+func (b Backend) call(ctx context.Context, trace types.Trace, rpc func(context.Context) error) error {
+	ctx, cancel := b.setTimeout(ctx)
+	defer cancel()
+
+	t0 := time.Now()
+	err := b.enter(ctx)
+	trace.AddLimiter(t0)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if limiterErr := b.leave(); limiterErr != nil {
+			b.logger.Error("Backend limiter full",
+				zap.String("host", b.address),
+				zap.String("uuid", util.GetUUID(ctx)),
+				zap.Error(limiterErr),
+			)
+		}
+	}()
+
+	ctx = util.WithUUID(ctx)
+	ctx = metadata.AppendToOutgoingContext(ctx, uuidKey, util.GetUUID(ctx))
+
+	t1 := time.Now()
+	err = rpc(ctx)
+	trace.AddHTTPCall(t1)
+	trace.ObserveOutDuration(time.Since(t1), b.dc, b.cluster)
+
+	return err
+}
+
+func isNotFound(err error) bool {
+	return status.Code(err) == codes.NotFound
+}
+
+// Contains reports whether the backend contains any of the given targets.
+func (b Backend) Contains(targets []string) bool {
+	for _, target := range targets {
+		if _, ok := b.cache.Get(target); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Render fetches raw metrics from a backend.
+func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+	t0 := time.Now()
+	req := carbonapi_v3.RenderRequestToPB(request.Targets, request.From, request.Until)
+	request.Trace.AddMarshal(t0)
+
+	var resp *carbonapi_v3_pb.MultiFetchResponse
+	err := b.call(ctx, request.Trace, func(ctx context.Context) error {
+		var err error
+		resp, err = b.client().FetchMetrics(ctx, &req)
+		return err
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, types.ErrMetricsNotFound
+		}
+
+		return nil, errors.Wrap(err, "gRPC call failed")
+	}
+
+	t1 := time.Now()
+	metrics := carbonapi_v3.MetricsFromPB(*resp)
+	request.Trace.AddUnmarshal(t1)
+
+	if len(metrics) == 0 {
+		return nil, types.ErrMetricsNotFound
+	}
+
+	for _, metric := range metrics {
+		b.cache.Set(metric.Name, struct{}{}, 0, b.cacheExpirySec)
+	}
+
+	return metrics, nil
+}
+
+// Info fetches metadata about a metric from a backend.
+func (b Backend) Info(ctx context.Context, request types.InfoRequest) ([]types.Info, error) {
+	req := carbonapi_v3_pb.MultiMetricsInfoRequest{
+		Names: []string{request.Target},
+	}
+
+	var resp *carbonapi_v3_pb.MultiMetricsInfoResponse
+	err := b.call(ctx, request.Trace, func(ctx context.Context) error {
+		var err error
+		resp, err = b.client().MetricsInfo(ctx, &req)
+		return err
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, types.ErrInfoNotFound
+		}
+
+		return nil, errors.Wrap(err, "gRPC call failed")
+	}
+
+	t1 := time.Now()
+	infos := carbonapi_v3.InfosFromPB(*resp, b.address)
+	request.Trace.AddUnmarshal(t1)
+
+	if len(infos) == 0 {
+		return nil, types.ErrInfoNotFound
+	}
+
+	return infos, nil
+}
+
+// Find resolves globs and finds metrics in a backend.
+func (b Backend) Find(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+	req := carbonapi_v3_pb.MultiGlobRequest{
+		Metrics: []string{request.Query},
+	}
+
+	var resp *carbonapi_v3_pb.MultiGlobResponse
+	err := b.call(ctx, request.Trace, func(ctx context.Context) error {
+		var err error
+		resp, err = b.client().FindMetrics(ctx, &req)
+		return err
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return types.Matches{}, types.ErrMatchesNotFound
+		}
+
+		return types.Matches{}, errors.Wrap(err, "gRPC call failed")
+	}
+
+	t1 := time.Now()
+	matches := carbonapi_v3.MatchesFromPB(*resp)
+	request.Trace.AddUnmarshal(t1)
+	if matches.Name == "" {
+		matches.Name = request.Query
+	}
+
+	if len(matches.Matches) == 0 {
+		return matches, types.ErrMatchesNotFound
+	}
+
+	for _, match := range matches.Matches {
+		if match.IsLeaf {
+			b.cache.Set(match.Path, struct{}{}, 0, b.cacheExpirySec)
+		}
+	}
+
+	return matches, nil
+}
@@ -0,0 +1,219 @@
+package grpc
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/go-graphite/protocol/carbonapi_v3_grpc"
+	"github.com/go-graphite/protocol/carbonapi_v3_pb"
+	"github.com/golang/protobuf/ptypes/empty"
+	grpclib "google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+type server struct {
+	uuids chan string
+}
+
+func (s server) GetVersion(ctx context.Context, in *empty.Empty) (*carbonapi_v3_grpc.ProtocolVersionResponse, error) {
+	return &carbonapi_v3_grpc.ProtocolVersionResponse{Version: 3}, nil
+}
+
+func (s server) FetchMetrics(ctx context.Context, in *carbonapi_v3_pb.MultiFetchRequest) (*carbonapi_v3_pb.MultiFetchResponse, error) {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && s.uuids != nil {
+		if ids := md.Get(uuidKey); len(ids) > 0 {
+			s.uuids <- ids[0]
+		}
+	}
+
+	resp := &carbonapi_v3_pb.MultiFetchResponse{}
+	for _, m := range in.Metrics {
+		if m.Name == "missing" {
+			return nil, status.Error(codes.NotFound, "not found")
+		}
+		resp.Metrics = append(resp.Metrics, carbonapi_v3_pb.FetchResponse{
+			Name:      m.Name,
+			StartTime: m.StartTime,
+			StopTime:  m.StopTime,
+			StepTime:  m.StopTime - m.StartTime,
+			Values:    []float64{1},
+		})
+	}
+
+	return resp, nil
+}
+
+func (s server) FindMetrics(ctx context.Context, in *carbonapi_v3_pb.MultiGlobRequest) (*carbonapi_v3_pb.MultiGlobResponse, error) {
+	return &carbonapi_v3_pb.MultiGlobResponse{
+		Metrics: []carbonapi_v3_pb.GlobResponse{
+			{
+				Name:    in.Metrics[0],
+				Matches: []carbonapi_v3_pb.GlobMatch{{Path: "foo.bar", IsLeaf: true}},
+			},
+		},
+	}, nil
+}
+
+func (s server) MetricsInfo(ctx context.Context, in *carbonapi_v3_pb.MultiMetricsInfoRequest) (*carbonapi_v3_pb.MultiMetricsInfoResponse, error) {
+	return &carbonapi_v3_pb.MultiMetricsInfoResponse{
+		Metrics: []carbonapi_v3_pb.MetricsInfoResponse{
+			{Name: in.Names[0], MaxRetention: 60},
+		},
+	}, nil
+}
+
+func (s server) ListMetrics(ctx context.Context, in *empty.Empty) (*carbonapi_v3_pb.ListMetricsResponse, error) {
+	return &carbonapi_v3_pb.ListMetricsResponse{}, nil
+}
+
+func (s server) Stats(ctx context.Context, in *empty.Empty) (*carbonapi_v3_pb.MetricDetailsResponse, error) {
+	return &carbonapi_v3_pb.MetricDetailsResponse{}, nil
+}
+
+func newServer(t *testing.T, srv server) (string, func()) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := grpclib.NewServer()
+	carbonapi_v3_grpc.RegisterCarbonV1Server(s, srv)
+	go s.Serve(lis)
+
+	return lis.Addr().String(), s.Stop
+}
+
+func TestNew(t *testing.T) {
+	b, err := New(Config{
+		Address:  "grpc://localhost:8080",
+		PoolSize: 3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	if b.GetServerAddress() != "localhost:8080" {
+		t.Errorf("Expected localhost:8080, got '%s'", b.GetServerAddress())
+	}
+
+	if len(b.clients) != 3 {
+		t.Errorf("Expected 3 connections, got %d", len(b.clients))
+	}
+
+	if _, err := New(Config{}); err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func TestConnectParams(t *testing.T) {
+	p := connectParams(Config{ConnectTimeout: 3 * time.Second})
+	if p.MinConnectTimeout != 3*time.Second {
+		t.Errorf("expected the connect timeout of the config, got %v", p.MinConnectTimeout)
+	}
+	if p.Backoff.BaseDelay <= 0 || p.Backoff.MaxDelay <= 0 || p.Backoff.Multiplier <= 1 {
+		t.Errorf("expected a backoff between dials, got %+v", p.Backoff)
+	}
+}
+
+func TestRender(t *testing.T) {
+	uuids := make(chan string, 1)
+	addr, stop := newServer(t, server{uuids: uuids})
+	defer stop()
+
+	b, err := New(Config{
+		Address:           addr,
+		PoolSize:          2,
+		Timeout:           time.Second,
+		KeepAliveInterval: time.Minute,
+		Limit:             1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	metrics, err := b.Render(context.Background(), types.NewRenderRequest([]string{"foo"}, 100, 160))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(metrics) != 1 || metrics[0].Name != "foo" || metrics[0].StepTime != 60 {
+		t.Errorf("Unexpected metrics %v", metrics)
+	}
+
+	if !b.Contains([]string{"foo"}) {
+		t.Error("Expected foo to be cached")
+	}
+
+	if id := <-uuids; id == "" {
+		t.Error("Expected request UUID to be sent")
+	}
+
+	_, err = b.Render(context.Background(), types.NewRenderRequest([]string{"missing"}, 100, 160))
+	if err != types.ErrMetricsNotFound {
+		t.Errorf("Expected %v, got %v", types.ErrMetricsNotFound, err)
+	}
+}
+
+func TestFind(t *testing.T) {
+	addr, stop := newServer(t, server{})
+	defer stop()
+
+	b, err := New(Config{Address: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	matches, err := b.Find(context.Background(), types.NewFindRequest("foo.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if matches.Name != "foo.*" || len(matches.Matches) != 1 || matches.Matches[0].Path != "foo.bar" {
+		t.Errorf("Unexpected matches %v", matches)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	addr, stop := newServer(t, server{})
+	defer stop()
+
+	b, err := New(Config{Address: addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	infos, err := b.Info(context.Background(), types.NewInfoRequest("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(infos) != 1 || infos[0].Name != "foo" || infos[0].Host != addr {
+		t.Errorf("Unexpected infos %v", infos)
+	}
+}
+
+func TestCallTimeout(t *testing.T) {
+	b, err := New(Config{
+		Address: "127.0.0.1:1",
+		Timeout: 10 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	_, err = b.Render(context.Background(), types.NewRenderRequest([]string{"foo"}, 100, 160))
+	if err == nil {
+		t.Error("Expected error")
+	}
+}
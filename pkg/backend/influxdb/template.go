@@ -0,0 +1,185 @@
+package influxdb
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	measurementToken = "measurement"
+	fieldToken       = "field"
+
+	// defaultField is the field of templates without one, as in the graphite
+	// input of InfluxDB.
+	defaultField = "value"
+)
+
+// template maps the segments of graphite paths to the measurement, the field
+// and the tags of InfluxDB series, like the templates of the graphite input
+// of InfluxDB: host.measurement.field reads the path a.cpu.idle as the field
+// idle of the measurement cpu, tagged host=a.
+type template []string
+
+func parseTemplate(s string) (template, error) {
+	t := template(strings.Split(s, "."))
+	seen := make(map[string]bool)
+	for _, token := range t {
+		if token == "" || strings.ContainsAny(token, "*?{}[]") {
+			return nil, fmt.Errorf("invalid template %q", s)
+		}
+		if seen[token] {
+			return nil, fmt.Errorf("repeated %q in template %q", token, s)
+		}
+		seen[token] = true
+	}
+	if !seen[measurementToken] {
+		return nil, fmt.Errorf("template %q has no measurement", s)
+	}
+
+	return t, nil
+}
+
+func (t template) hasField() bool {
+	for _, token := range t {
+		if token == fieldToken {
+			return true
+		}
+	}
+	return false
+}
+
+// series is a series of InfluxDB as named by SHOW SERIES.
+type series struct {
+	measurement string
+	tags        map[string]string
+}
+
+// parseSeriesKey parses a series key of the line protocol, such as
+// cpu,host=a,region=eu, unescaping its backslashes.
+func parseSeriesKey(key string) series {
+	parts := splitUnescaped(key, ',')
+	s := series{
+		measurement: unescape(parts[0]),
+		tags:        make(map[string]string, len(parts)-1),
+	}
+	for _, part := range parts[1:] {
+		kv := splitUnescaped(part, '=')
+		if len(kv) == 2 {
+			s.tags[unescape(kv[0])] = unescape(kv[1])
+		}
+	}
+
+	return s
+}
+
+func splitUnescaped(s string, sep byte) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+func unescape(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// quoteIdent quotes an identifier of InfluxQL.
+func quoteIdent(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// quoteString quotes a string literal of InfluxQL.
+func quoteString(s string) string {
+	return `'` + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + `'`
+}
+
+// globRegexp returns a regular expression literal of InfluxQL that matches at
+// least the names matched by the graphite glob segment, which is matched
+// exactly afterwards. The literal ends at the first unescaped '/', so both
+// '/' and '\' are escaped everywhere, character classes included.
+func globRegexp(segment string) (string, error) {
+	var b strings.Builder
+	b.WriteString("^")
+	inBraces, inClass := false, false
+	for _, r := range segment {
+		switch {
+		case inClass:
+			switch r {
+			case ']':
+				inClass = false
+				b.WriteRune(r)
+			case '/':
+				b.WriteString(`\/`)
+			case '\\':
+				b.WriteString(`\\`)
+			default:
+				b.WriteRune(r)
+			}
+		case r == '*':
+			b.WriteString(".*")
+		case r == '?':
+			b.WriteString(".")
+		case r == '[':
+			inClass = true
+			b.WriteRune(r)
+		case r == '{':
+			inBraces = true
+			b.WriteString("(?:")
+		case r == '}' && inBraces:
+			inBraces = false
+			b.WriteString(")")
+		case r == ',' && inBraces:
+			b.WriteString("|")
+		default:
+			b.WriteString(strings.Replace(regexp.QuoteMeta(string(r)), "/", `\/`, -1))
+		}
+	}
+	if inClass {
+		return "", fmt.Errorf("invalid glob %q: unterminated [", segment)
+	}
+	if inBraces {
+		return "", fmt.Errorf("invalid glob %q: unterminated {", segment)
+	}
+	b.WriteString("$")
+
+	return "/" + b.String() + "/", nil
+}
+
+// condition returns the condition of InfluxQL for a name matching segment.
+func condition(ident, segment string) (string, error) {
+	if strings.ContainsAny(segment, "*?{}[]") {
+		re, err := globRegexp(segment)
+		return ident + " =~ " + re, err
+	}
+	return ident + " = " + quoteString(segment), nil
+}
+
+// source returns the FROM clause for measurements matching segment.
+func source(segment string) (string, error) {
+	if strings.ContainsAny(segment, "*?{}[]") {
+		return globRegexp(segment)
+	}
+	return quoteIdent(segment), nil
+}
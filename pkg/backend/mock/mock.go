@@ -26,6 +26,7 @@ type Backend struct {
 	info     func(context.Context, types.InfoRequest) ([]types.Info, error)
 	render   func(context.Context, types.RenderRequest) ([]types.Metric, error)
 	contains func([]string) bool
+	address  string
 }
 
 // Config configures a mock Backend. Define ad-hoc functions to return
@@ -37,6 +38,8 @@ type Config struct {
 	Info     func(context.Context, types.InfoRequest) ([]types.Info, error)
 	Render   func(context.Context, types.RenderRequest) ([]types.Metric, error)
 	Contains func([]string) bool
+	// Address is the address of the backend, empty by default.
+	Address string
 }
 
 var (
@@ -55,8 +58,23 @@ func (b Backend) Info(ctx context.Context, request types.InfoRequest) ([]types.I
 	return b.info(ctx, request)
 }
 
+// Render returns copies of the metrics of the render function, since like
+// those of the other backends, they are the caller's own, whose values are
+// given back to the pool once merged and answered.
 func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
-	return b.render(ctx, request)
+	metrics, err := b.render(ctx, request)
+	if metrics == nil {
+		return metrics, err
+	}
+
+	copies := make([]types.Metric, len(metrics))
+	for i, m := range metrics {
+		copies[i] = m
+		copies[i].Values = append([]float64(nil), m.Values...)
+		copies[i].IsAbsent = m.IsAbsent.Clone()
+	}
+
+	return copies, err
 }
 
 // Logger returns a no-op logger.
@@ -66,7 +84,7 @@ func (b Backend) Logger() *zap.Logger {
 
 // New creates a new mock backend.
 func New(cfg Config) Backend {
-	b := Backend{}
+	b := Backend{address: cfg.Address}
 
 	if cfg.Find != nil {
 		b.find = cfg.Find
@@ -100,5 +118,5 @@ func (b Backend) Contains(targets []string) bool {
 }
 
 func (b Backend) GetServerAddress() string {
-	return ""
+	return b.address
 }
@@ -3,6 +3,8 @@
 package net
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"io/ioutil"
@@ -10,6 +12,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/bookingcom/carbonapi/pkg/prioritylimiter"
@@ -17,10 +20,16 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/pickle"
 	"github.com/bookingcom/carbonapi/util"
 
 	"github.com/dgryski/go-expirecache"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/kv"
+	"go.opentelemetry.io/otel/api/standard"
+	apitrace "go.opentelemetry.io/otel/api/trace"
 	"go.opentelemetry.io/otel/instrumentation/httptrace"
 	"go.uber.org/zap"
 )
@@ -41,6 +50,18 @@ func (e ErrHTTPCode) Error() string {
 	}
 }
 
+// ErrDecode is returned for backend responses that could not be decoded.
+type ErrDecode struct {
+	Err error
+}
+
+func (e ErrDecode) Error() string {
+	return e.Err.Error()
+}
+
+// tracerName names the tracer of the spans of the calls to backends.
+const tracerName = "github.com/bookingcom/carbonapi/pkg/backend/net"
+
 // Backend represents a host that accepts requests for metrics over HTTP.
 type Backend struct {
 	address        string
@@ -53,6 +74,48 @@ type Backend struct {
 	logger         *zap.Logger
 	cache          *expirecache.Cache
 	cacheExpirySec int32
+	protocol       *protocolState
+	findProtocol   *protocolState
+	renderProtocol *protocolState
+	infoProtocol   *protocolState
+	userAgent      string
+	queryParams    map[string]string
+	compression    bool
+
+	fallbackProtocol  string
+	protocolFallbacks *prometheus.CounterVec
+}
+
+// Protocols a backend can be configured with.
+const (
+	ProtocolCarbonAPIV2 = "carbonapi_v2_pb"
+	ProtocolCarbonAPIV3 = carbonapi_v3.ProtocolName
+	// ProtocolAuto asks the backend for its capabilities and uses
+	// carbonapi_v3_pb if it is supported, carbonapi_v2_pb otherwise.
+	ProtocolAuto = "auto"
+	// ProtocolPickle is the protocol of graphite-web. It is only supported
+	// for find.
+	ProtocolPickle = "pickle"
+)
+
+// Headers of the render responses of carbonzipper telling the datapoints
+// of replicas that mismatched, and how many of them it fixed.
+const (
+	MismatchesHeader      = "X-Carbonzipper-Mismatches"
+	FixedMismatchesHeader = "X-Carbonzipper-Fixed-Mismatches"
+)
+
+// negotiationRetry is how long to stick to carbonapi_v2_pb after a failed
+// capability negotiation before asking the backend again.
+const negotiationRetry = time.Minute
+
+// protocolState holds the protocol chosen for a backend. It is shared between
+// copies of the Backend value.
+type protocolState struct {
+	mu         sync.Mutex
+	configured string
+	negotiated string
+	retryAt    time.Time
 }
 
 // Config configures an HTTP backend.
@@ -71,8 +134,34 @@ type Config struct {
 	Limit              int           // Set limit of concurrent requests to backend. Defaults to no limit.
 	PathCacheExpirySec uint32        // Set time in seconds before items in path cache expire. Defaults to 10 minutes.
 	Logger             *zap.Logger   // Logger to use. Defaults to a no-op logger.
+	Protocol           string        // Protocol to talk to backend, one of carbonapi_v2_pb, carbonapi_v3_pb or auto. Defaults to carbonapi_v2_pb.
 	ActiveRequests     prometheus.Gauge
 	WaitingRequests    prometheus.Gauge
+
+	UserAgent   string            // User-Agent header of requests. Defaults to the one of net/http.
+	QueryParams map[string]string // Parameters added to the query string of every request.
+	Compression bool              // Ask for responses compressed in gzip.
+
+	// TenantWeights are the weights of tenants in sharing Limit. Others weigh 1.
+	TenantWeights map[string]float64
+
+	// MaxQueueDepth limits the requests waiting for Limit. The ones beyond
+	// fail with prioritylimiter.ErrQueueFull. Defaults to no limit.
+	MaxQueueDepth int
+	// QueueTime observes the seconds requests wait for Limit. Optional.
+	QueueTime prometheus.Histogram
+
+	// Protocols per endpoint, overriding Protocol. FindProtocol can also be pickle.
+	FindProtocol   string
+	RenderProtocol string
+	InfoProtocol   string
+
+	// FallbackProtocol is the protocol a request is retried with once if its
+	// response can not be decoded, e.g. while the backend is upgraded to
+	// another protocol. pickle is only used for find. Defaults to no retry.
+	FallbackProtocol string
+	// ProtocolFallbacks counts the retries with FallbackProtocol, by endpoint.
+	ProtocolFallbacks *prometheus.CounterVec
 }
 
 var fmtProto = []string{"protobuf"}
@@ -98,6 +187,9 @@ func New(cfg Config) (*Backend, error) {
 	b.scheme = scheme
 	b.cluster = cfg.Cluster
 	b.dc = cfg.DC
+	b.userAgent = cfg.UserAgent
+	b.queryParams = cfg.QueryParams
+	b.compression = cfg.Compression
 
 	if cfg.Timeout > 0 {
 		b.timeout = cfg.Timeout
@@ -112,11 +204,15 @@ func New(cfg Config) (*Backend, error) {
 	}
 
 	if cfg.Limit > 0 {
+		options := []prioritylimiter.LimiterOption{
+			prioritylimiter.WithTenantWeights(cfg.TenantWeights),
+			prioritylimiter.WithMaxWaiting(cfg.MaxQueueDepth),
+			prioritylimiter.WithQueueTime(cfg.QueueTime),
+		}
 		if cfg.ActiveRequests != nil && cfg.WaitingRequests != nil {
-			b.limiter = prioritylimiter.New(cfg.Limit, prioritylimiter.WithMetrics(cfg.ActiveRequests, cfg.WaitingRequests))
-		} else {
-			b.limiter = prioritylimiter.New(cfg.Limit)
+			options = append(options, prioritylimiter.WithMetrics(cfg.ActiveRequests, cfg.WaitingRequests))
 		}
+		b.limiter = prioritylimiter.New(cfg.Limit, options...)
 	}
 
 	if cfg.Logger != nil {
@@ -125,9 +221,54 @@ func New(cfg Config) (*Backend, error) {
 		b.logger = zap.New(nil)
 	}
 
+	switch cfg.Protocol {
+	case "":
+		b.protocol = &protocolState{configured: ProtocolCarbonAPIV2}
+	case ProtocolCarbonAPIV2, ProtocolCarbonAPIV3, ProtocolAuto:
+		b.protocol = &protocolState{configured: cfg.Protocol}
+	default:
+		return nil, errors.Errorf("unknown backend protocol '%s'", cfg.Protocol)
+	}
+
+	if b.findProtocol, err = b.endpointProtocol("find", cfg.FindProtocol, ProtocolPickle); err != nil {
+		return nil, err
+	}
+	if b.renderProtocol, err = b.endpointProtocol("render", cfg.RenderProtocol); err != nil {
+		return nil, err
+	}
+	if b.infoProtocol, err = b.endpointProtocol("info", cfg.InfoProtocol); err != nil {
+		return nil, err
+	}
+
+	switch cfg.FallbackProtocol {
+	case "", ProtocolCarbonAPIV2, ProtocolCarbonAPIV3, ProtocolPickle:
+		b.fallbackProtocol = cfg.FallbackProtocol
+	default:
+		return nil, errors.Errorf("unknown backend fallback protocol '%s'", cfg.FallbackProtocol)
+	}
+	b.protocolFallbacks = cfg.ProtocolFallbacks
+
 	return b, nil
 }
 
+// endpointProtocol returns the protocol state for an endpoint configured with
+// protocol, which is the one of the backend if it is not set.
+func (b *Backend) endpointProtocol(endpoint, protocol string, extra ...string) (*protocolState, error) {
+	switch protocol {
+	case "":
+		return b.protocol, nil
+	case ProtocolCarbonAPIV2, ProtocolCarbonAPIV3, ProtocolAuto:
+		return &protocolState{configured: protocol}, nil
+	}
+	for _, p := range extra {
+		if protocol == p {
+			return &protocolState{configured: protocol}, nil
+		}
+	}
+
+	return nil, errors.Errorf("unknown backend protocol '%s' for %s", protocol, endpoint)
+}
+
 func parseAddress(address string) (string, string, error) {
 	if !strings.Contains(address, "://") {
 		address = "http://" + address
@@ -165,7 +306,7 @@ func (b Backend) enter(ctx context.Context) error {
 	}
 	priority := util.GetPriority(ctx)
 	uuid := util.GetUUID(ctx)
-	return b.limiter.Enter(ctx, priority, uuid)
+	return b.limiter.EnterAs(ctx, util.GetTenant(ctx), priority, uuid)
 }
 
 func (b Backend) leave() error {
@@ -183,12 +324,36 @@ func (b Backend) setTimeout(ctx context.Context) (context.Context, context.Cance
 	return context.WithCancel(ctx)
 }
 
-func (b Backend) request(ctx context.Context, u *url.URL) (*http.Request, error) {
-	req, err := http.NewRequest("GET", "", nil)
+// request builds a GET request, or a POST request if body is not nil.
+func (b Backend) request(ctx context.Context, u *url.URL, body []byte) (*http.Request, error) {
+	var req *http.Request
+	var err error
+	if body == nil {
+		req, err = http.NewRequest("GET", "", nil)
+	} else {
+		req, err = http.NewRequest("POST", "", bytes.NewReader(body))
+	}
 	if err != nil {
 		return nil, err
 	}
+	if len(b.queryParams) > 0 {
+		q := u.Query()
+		for k, v := range b.queryParams {
+			q.Add(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
 	req.URL = u
+	if body != nil {
+		req.Header.Set("Content-Type", carbonapi_v3.ContentType)
+	}
+	if b.userAgent != "" {
+		req.Header.Set("User-Agent", b.userAgent)
+	}
+	if b.compression {
+		// set explicitly, the response is not decompressed by the transport
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
 
 	req = req.WithContext(ctx)
 	req = util.MarshalCtx(ctx, req)
@@ -197,7 +362,10 @@ func (b Backend) request(ctx context.Context, u *url.URL) (*http.Request, error)
 	return req, nil
 }
 
+// do sends req to the backend. The span of the context of req, if any, gets
+// the status and the size of the response.
 func (b Backend) do(trace types.Trace, req *http.Request) (string, []byte, error) {
+	span := apitrace.SpanFromContext(req.Context())
 
 	t0 := time.Now()
 	resp, err := b.client.Do(req)
@@ -205,41 +373,122 @@ func (b Backend) do(trace types.Trace, req *http.Request) (string, []byte, error
 	trace.ObserveOutDuration(time.Since(t0), b.dc, b.cluster)
 
 	if err != nil {
+		span.RecordError(req.Context(), err)
 		return "", nil, err
 	}
+	span.SetAttributes(standard.HTTPAttributesFromHTTPStatusCode(resp.StatusCode)...)
+	span.SetStatus(standard.SpanStatusFromHTTPStatusCode(resp.StatusCode))
 
 	var body []byte
 	var bodyErr error
 	if resp.Body != nil {
 		defer resp.Body.Close()
 		t1 := time.Now()
-		body, bodyErr = ioutil.ReadAll(resp.Body)
+		body, bodyErr = readBody(resp)
 		if bodyErr != nil {
+			span.RecordError(req.Context(), bodyErr)
 			return "", nil, bodyErr
 		}
 		trace.AddReadBody(t1)
 	}
+	span.SetAttributes(kv.Int("backend.response_size", len(body)))
 
-	if resp.StatusCode != http.StatusOK {
+	switch resp.StatusCode {
+	case http.StatusOK:
+		mismatches, _ := strconv.ParseInt(resp.Header.Get(MismatchesHeader), 10, 64)
+		fixed, _ := strconv.ParseInt(resp.Header.Get(FixedMismatchesHeader), 10, 64)
+		if mismatches > 0 {
+			trace.AddMismatches(mismatches, fixed)
+		}
+		return resp.Header.Get("Content-Type"), body, nil
+	case http.StatusBadRequest:
+		return "", body, types.ErrBadRequest(errorMessage(resp.StatusCode, body))
+	case http.StatusForbidden:
+		return "", body, types.ErrForbidden(errorMessage(resp.StatusCode, body))
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return "", body, types.ErrBackendOverloaded{
+			Message:    errorMessage(resp.StatusCode, body),
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		}
+	default:
 		return "", body, ErrHTTPCode(resp.StatusCode)
 	}
+}
 
-	return resp.Header.Get("Content-Type"), body, nil
+// maxErrorMessage bounds the message of the response of a backend kept in
+// its error.
+const maxErrorMessage = 256
 
+// errorMessage returns the message of an error response of a backend, or
+// the text of its HTTP code if it has none.
+func errorMessage(code int, body []byte) string {
+	msg := strings.TrimSpace(string(body))
+	if msg == "" {
+		return http.StatusText(code)
+	}
+	if len(msg) > maxErrorMessage {
+		msg = msg[:maxErrorMessage]
+	}
+
+	return msg
+}
+
+// retryAfter returns how long the Retry-After header h tells to wait, in
+// seconds or until a date, or 0 if it tells neither.
+func retryAfter(h string) time.Duration {
+	if seconds, err := strconv.Atoi(h); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+
+	return 0
+}
+
+// readBody reads the body of resp, decompressing it if it is in gzip.
+func readBody(resp *http.Response) ([]byte, error) {
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		return ioutil.ReadAll(resp.Body)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+
+	return ioutil.ReadAll(gz)
 }
 
 // Call makes a call to a backend.
 // If the backend timeout is positive, Call will override the context timeout
 // with the backend timeout.
 // Call ensures that the outgoing request has a UUID set.
-func (b Backend) call(ctx context.Context, trace types.Trace, u *url.URL) (string, []byte, error) {
+// Each call is a client span, child of the span of ctx, whose context the
+// request propagates to the backend.
+func (b Backend) call(ctx context.Context, trace types.Trace, u *url.URL, body []byte) (string, []byte, error) {
 	ctx, cancel := b.setTimeout(ctx)
 	defer cancel()
 
+	ctx, span := global.Tracer(tracerName).Start(ctx, "backend "+u.Path,
+		apitrace.WithSpanKind(apitrace.SpanKindClient),
+		apitrace.WithAttributes(
+			kv.String("backend.address", b.address),
+			kv.String("backend.dc", b.dc),
+			kv.String("backend.cluster", b.cluster),
+			kv.Int("backend.request_size", len(body)),
+		),
+	)
+	defer span.End()
+
 	t0 := time.Now()
 	err := b.enter(ctx)
 	trace.AddLimiter(t0)
 	if err != nil {
+		span.RecordError(ctx, err)
 		return "", nil, err
 	}
 
@@ -254,7 +503,7 @@ func (b Backend) call(ctx context.Context, trace types.Trace, u *url.URL) (strin
 	}()
 
 	t1 := time.Now()
-	req, err := b.request(ctx, u)
+	req, err := b.request(ctx, u, body)
 
 	trace.AddMarshal(t1)
 	if err != nil {
@@ -281,18 +530,143 @@ func (b Backend) Contains(targets []string) bool {
 	return false
 }
 
+// Protocol returns the protocol to use for requests to the backend. If the
+// backend is configured with ProtocolAuto, the first call negotiates the
+// protocol with the backend.
+func (b Backend) Protocol(ctx context.Context) string {
+	return b.protocolOf(ctx, b.protocol)
+}
+
+func (b Backend) protocolOf(ctx context.Context, p *protocolState) string {
+	if p == nil {
+		return ProtocolCarbonAPIV2
+	}
+
+	p.mu.Lock()
+	if p.configured != ProtocolAuto {
+		p.mu.Unlock()
+		return p.configured
+	}
+	if p.negotiated != "" && (p.retryAt.IsZero() || time.Now().Before(p.retryAt)) {
+		p.mu.Unlock()
+		return p.negotiated
+	}
+	p.mu.Unlock()
+
+	protocol, err := b.negotiate(ctx)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		b.logger.Warn("Backend capability negotiation failed, falling back to carbonapi_v2_pb",
+			zap.String("host", b.address),
+			zap.Error(err),
+		)
+		p.negotiated = ProtocolCarbonAPIV2
+		p.retryAt = time.Now().Add(negotiationRetry)
+	} else {
+		p.negotiated = protocol
+		p.retryAt = time.Time{}
+	}
+
+	return p.negotiated
+}
+
+// negotiate asks the backend which protocols it supports. Backends that do not
+// know about capabilities are assumed to only support carbonapi_v2_pb.
+func (b Backend) negotiate(ctx context.Context) (string, error) {
+	u := b.url("/_internal/capabilities/")
+	u.RawQuery = url.Values{"format": []string{carbonapi_v3.ProtocolName}}.Encode()
+
+	body, err := carbonapi_v3.CapabilityRequestEncoder()
+	if err != nil {
+		return "", err
+	}
+
+	contentType, resp, err := b.call(ctx, types.NewTrace(), u, body)
+	if err != nil {
+		if code, ok := err.(ErrHTTPCode); ok && code == http.StatusNotFound {
+			return ProtocolCarbonAPIV2, nil
+		}
+
+		return "", err
+	}
+
+	if contentType != carbonapi_v3.ContentType {
+		return ProtocolCarbonAPIV2, nil
+	}
+
+	protocols, err := carbonapi_v3.CapabilityDecoder(resp)
+	if err != nil {
+		return "", errors.Wrap(err, "Protobuf unmarshal failed")
+	}
+
+	for _, protocol := range protocols {
+		if protocol == carbonapi_v3.ProtocolName {
+			return ProtocolCarbonAPIV3, nil
+		}
+	}
+
+	return ProtocolCarbonAPIV2, nil
+}
+
+// fallback returns the protocol to retry a request to endpoint with, if it
+// was made in protocol and its response could not be decoded.
+func (b Backend) fallback(endpoint, protocol string, err error) (string, bool) {
+	if _, ok := err.(ErrDecode); !ok {
+		return "", false
+	}
+	if b.fallbackProtocol == "" || b.fallbackProtocol == protocol {
+		return "", false
+	}
+	if b.fallbackProtocol == ProtocolPickle && endpoint != "find" {
+		return "", false
+	}
+
+	b.logger.Warn("retrying backend request with the fallback protocol",
+		zap.String("endpoint", endpoint),
+		zap.String("protocol", protocol),
+		zap.String("fallback_protocol", b.fallbackProtocol),
+		zap.Error(err),
+	)
+	if b.protocolFallbacks != nil {
+		b.protocolFallbacks.WithLabelValues(endpoint).Inc()
+	}
+
+	return b.fallbackProtocol, true
+}
+
 // Render fetches raw metrics from a backend.
 func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+	protocol := b.protocolOf(ctx, b.renderProtocol)
+	metrics, err := b.render(ctx, request, protocol)
+	if fallback, ok := b.fallback("render", protocol, err); ok {
+		return b.render(ctx, request, fallback)
+	}
+
+	return metrics, err
+}
+
+func (b Backend) render(ctx context.Context, request types.RenderRequest, protocol string) ([]types.Metric, error) {
 	from := request.From
 	until := request.Until
 	targets := request.Targets
 
 	t0 := time.Now()
 	u := b.url("/render/")
-	u = carbonapiV2RenderEncoder(u, from, until, targets)
+	var body []byte
+	var err error
+	if protocol == ProtocolCarbonAPIV3 {
+		u, body, err = carbonapiV3RenderEncoder(u, from, until, targets)
+		if err != nil {
+			return nil, errors.Wrap(err, "Marshal failed")
+		}
+	} else {
+		u = carbonapiV2RenderEncoder(u, from, until, targets)
+	}
 	request.Trace.AddMarshal(t0)
 
-	contentType, resp, err := b.call(ctx, request.Trace, u)
+	contentType, resp, err := b.call(ctx, request.Trace, u, body)
 	if err != nil {
 		if code, ok := err.(ErrHTTPCode); ok && code == http.StatusNotFound {
 			return nil, types.ErrMetricsNotFound
@@ -311,25 +685,26 @@ func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]typ
 	case "application/x-protobuf", "application/protobuf", "application/octet-stream":
 		metrics, err = carbonapi_v2.RenderDecoder(resp)
 
+	case carbonapi_v3.ContentType:
+		metrics, err = carbonapi_v3.RenderDecoder(resp)
+
 		/* TODO(gmagnusson)
 		case "application/json":
 
 		case "application/pickle":
 
 		case "application/x-msgpack":
-
-		case "application/x-carbonapi-v3-pb":
 		*/
 
 	case "application/text":
 		return nil, errors.Errorf("Unexpected application/text response:\n%s", string(resp))
 
 	default:
-		return nil, errors.Errorf("Unknown content type '%s'", contentType)
+		return nil, ErrDecode{errors.Errorf("Unknown content type '%s'", contentType)}
 	}
 
 	if err != nil {
-		return metrics, errors.Wrap(err, "Unmarshal failed")
+		return metrics, ErrDecode{errors.Wrap(err, "Unmarshal failed")}
 	}
 
 	if len(metrics) == 0 {
@@ -343,28 +718,54 @@ func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]typ
 	return metrics, nil
 }
 
-func carbonapiV2RenderEncoder(u *url.URL, from int32, until int32, targets []string) *url.URL {
+func carbonapiV2RenderEncoder(u *url.URL, from int64, until int64, targets []string) *url.URL {
 	vals := url.Values{
 		"target": targets,
 		"format": fmtProto,
-		"from":   []string{strconv.Itoa(int(from))},
-		"until":  []string{strconv.Itoa(int(until))},
+		"from":   []string{strconv.Itoa(int(carbonapi_v2.Timestamp(from)))},
+		"until":  []string{strconv.Itoa(int(carbonapi_v2.Timestamp(until)))},
 	}
 	u.RawQuery = vals.Encode()
 
 	return u
 }
 
+func carbonapiV3RenderEncoder(u *url.URL, from int64, until int64, targets []string) (*url.URL, []byte, error) {
+	u.RawQuery = url.Values{"format": []string{carbonapi_v3.ProtocolName}}.Encode()
+	body, err := carbonapi_v3.RenderRequestEncoder(targets, from, until)
+
+	return u, body, err
+}
+
 // Info fetches metadata about a metric from a backend.
 func (b Backend) Info(ctx context.Context, request types.InfoRequest) ([]types.Info, error) {
+	protocol := b.protocolOf(ctx, b.infoProtocol)
+	infos, err := b.info(ctx, request, protocol)
+	if fallback, ok := b.fallback("info", protocol, err); ok {
+		return b.info(ctx, request, fallback)
+	}
+
+	return infos, err
+}
+
+func (b Backend) info(ctx context.Context, request types.InfoRequest, protocol string) ([]types.Info, error) {
 	metric := request.Target
 
 	t0 := time.Now()
 	u := b.url("/info/")
-	u = carbonapiV2InfoEncoder(u, metric)
+	var body []byte
+	var err error
+	if protocol == ProtocolCarbonAPIV3 {
+		u, body, err = carbonapiV3InfoEncoder(u, metric)
+		if err != nil {
+			return nil, errors.Wrap(err, "Marshal failed")
+		}
+	} else {
+		u = carbonapiV2InfoEncoder(u, metric)
+	}
 	request.Trace.AddMarshal(t0)
 
-	_, resp, err := b.call(ctx, request.Trace, u)
+	contentType, resp, err := b.call(ctx, request.Trace, u, body)
 
 	if code, ok := err.(ErrHTTPCode); ok && code == http.StatusNotFound {
 		return nil, types.ErrInfoNotFound
@@ -374,24 +775,22 @@ func (b Backend) Info(ctx context.Context, request types.InfoRequest) ([]types.I
 		return nil, errors.Wrap(err, "HTTP call failed")
 	}
 
-	single, err := carbonapi_v2.IsInfoResponse(resp)
-	if err != nil {
-		return nil, errors.Wrap(err, "Protobuf unmarshal failed")
-	}
-
-	t1 := time.Now()
-	defer func() {
-		request.Trace.AddUnmarshal(t1)
-	}()
 	var infos []types.Info
-	if single {
-		infos, err = carbonapi_v2.SingleInfoDecoder(resp, b.address)
-	} else {
-		infos, err = carbonapi_v2.MultiInfoDecoder(resp)
+	switch contentType {
+	case carbonapi_v3.ContentType:
+		t1 := time.Now()
+		infos, err = carbonapi_v3.InfoDecoder(resp, b.address)
+		request.Trace.AddUnmarshal(t1)
+	case carbonapi_v3.ZipperInfoContentType:
+		t1 := time.Now()
+		infos, err = carbonapi_v3.MultiInfoDecoder(resp)
+		request.Trace.AddUnmarshal(t1)
+	default:
+		infos, err = b.carbonapiV2InfoDecoder(request.Trace, resp)
 	}
 
 	if err != nil {
-		return nil, errors.Wrap(err, "Protobuf unmarshal failed")
+		return nil, ErrDecode{errors.Wrap(err, "Protobuf unmarshal failed")}
 	}
 
 	if len(infos) == 0 {
@@ -401,6 +800,23 @@ func (b Backend) Info(ctx context.Context, request types.InfoRequest) ([]types.I
 	return infos, nil
 }
 
+func (b Backend) carbonapiV2InfoDecoder(trace types.Trace, resp []byte) ([]types.Info, error) {
+	single, err := carbonapi_v2.IsInfoResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	t1 := time.Now()
+	defer func() {
+		trace.AddUnmarshal(t1)
+	}()
+	if single {
+		return carbonapi_v2.SingleInfoDecoder(resp, b.address)
+	}
+
+	return carbonapi_v2.MultiInfoDecoder(resp)
+}
+
 func carbonapiV2InfoEncoder(u *url.URL, metric string) *url.URL {
 	vals := url.Values{
 		"target": []string{metric},
@@ -411,16 +827,45 @@ func carbonapiV2InfoEncoder(u *url.URL, metric string) *url.URL {
 	return u
 }
 
+func carbonapiV3InfoEncoder(u *url.URL, metric string) (*url.URL, []byte, error) {
+	u.RawQuery = url.Values{"format": []string{carbonapi_v3.ProtocolName}}.Encode()
+	body, err := carbonapi_v3.InfoRequestEncoder([]string{metric})
+
+	return u, body, err
+}
+
 // Find resolves globs and finds metrics in a backend.
 func (b Backend) Find(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+	protocol := b.protocolOf(ctx, b.findProtocol)
+	matches, err := b.find(ctx, request, protocol)
+	if fallback, ok := b.fallback("find", protocol, err); ok {
+		return b.find(ctx, request, fallback)
+	}
+
+	return matches, err
+}
+
+func (b Backend) find(ctx context.Context, request types.FindRequest, protocol string) (types.Matches, error) {
 	query := request.Query
 
 	t0 := time.Now()
 	u := b.url("/metrics/find/")
-	u = carbonapiV2FindEncoder(u, query)
+	var body []byte
+	var err error
+	switch protocol {
+	case ProtocolCarbonAPIV3:
+		u, body, err = carbonapiV3FindEncoder(u, query)
+		if err != nil {
+			return types.Matches{}, errors.Wrap(err, "Marshal failed")
+		}
+	case ProtocolPickle:
+		u = pickleFindEncoder(u, query)
+	default:
+		u = carbonapiV2FindEncoder(u, query)
+	}
 	request.Trace.AddMarshal(t0)
 
-	contentType, resp, err := b.call(ctx, request.Trace, u)
+	contentType, resp, err := b.call(ctx, request.Trace, u, body)
 	if err != nil {
 		if code, ok := err.(ErrHTTPCode); ok && code == http.StatusNotFound {
 			return types.Matches{}, types.ErrMatchesNotFound
@@ -439,22 +884,29 @@ func (b Backend) Find(ctx context.Context, request types.FindRequest) (types.Mat
 	case "application/x-protobuf", "application/protobuf", "application/octet-stream":
 		matches, err = carbonapi_v2.FindDecoder(resp)
 
-	/* TODO(gmagnusson)
-	case "application/json":
+	case carbonapi_v3.ContentType:
+		matches, err = carbonapi_v3.FindDecoder(resp)
+		if matches.Name == "" {
+			matches.Name = query
+		}
 
 	case "application/pickle":
+		matches, err = pickle.FindDecoder(resp)
+		if matches.Name == "" {
+			matches.Name = query
+		}
 
-	case "application/x-msgpack":
+	/* TODO(gmagnusson)
+	case "application/json":
 
-	case "application/x-carbonapi-v3-pb":
-	TODO (grzkv): This is rather hypothetical
+	case "application/x-msgpack":
 	*/
 	default:
-		return types.Matches{}, errors.Errorf("Unknown content type '%s'", contentType)
+		return types.Matches{}, ErrDecode{errors.Errorf("Unknown content type '%s'", contentType)}
 	}
 
 	if err != nil {
-		return matches, errors.Wrap(err, "Protobuf unmarshal failed")
+		return matches, ErrDecode{errors.Wrap(err, "Protobuf unmarshal failed")}
 	}
 
 	if len(matches.Matches) == 0 {
@@ -479,3 +931,20 @@ func carbonapiV2FindEncoder(u *url.URL, query string) *url.URL {
 
 	return u
 }
+
+func pickleFindEncoder(u *url.URL, query string) *url.URL {
+	vals := url.Values{
+		"query":  []string{query},
+		"format": []string{ProtocolPickle},
+	}
+	u.RawQuery = vals.Encode()
+
+	return u
+}
+
+func carbonapiV3FindEncoder(u *url.URL, query string) (*url.URL, []byte, error) {
+	u.RawQuery = url.Values{"format": []string{carbonapi_v3.ProtocolName}}.Encode()
+	body, err := carbonapi_v3.FindRequestEncoder([]string{query})
+
+	return u, body, err
+}
@@ -2,6 +2,7 @@ package net
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"fmt"
 	"net/http"
@@ -12,8 +13,19 @@ import (
 	"time"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v2"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/carbonapi_v3"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/pickle"
 
 	"github.com/dgryski/go-expirecache"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"go.opentelemetry.io/otel/api/global"
+	"go.opentelemetry.io/otel/api/kv"
+	"go.opentelemetry.io/otel/api/propagation"
+	apitrace "go.opentelemetry.io/otel/api/trace"
+	export "go.opentelemetry.io/otel/sdk/export/trace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func TestAddress(t *testing.T) {
@@ -103,7 +115,7 @@ func TestCall(t *testing.T) {
 		return
 	}
 
-	_, got, err := b.call(context.Background(), types.NewTrace(), b.url("/render"))
+	_, got, err := b.call(context.Background(), types.NewTrace(), b.url("/render"), nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -113,6 +125,91 @@ func TestCall(t *testing.T) {
 	}
 }
 
+// spanRecorder keeps the spans exported to it.
+type spanRecorder struct {
+	spans []*export.SpanData
+}
+
+func (r *spanRecorder) ExportSpan(_ context.Context, s *export.SpanData) {
+	r.spans = append(r.spans, s)
+}
+
+func TestCallPropagatesTrace(t *testing.T) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	recorder := &spanRecorder{}
+	provider, err := sdktrace.NewProvider(
+		sdktrace.WithConfig(sdktrace.Config{DefaultSampler: sdktrace.AlwaysSample()}),
+		sdktrace.WithSyncer(recorder),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(p apitrace.Provider, props propagation.Propagators) {
+		global.SetTraceProvider(p)
+		global.SetPropagators(props)
+	}(global.TraceProvider(), global.Propagators())
+	global.SetTraceProvider(provider)
+	global.SetPropagators(propagation.New(
+		propagation.WithInjectors(apitrace.B3{}, apitrace.TraceContext{}),
+	))
+
+	b, err := New(Config{
+		Address: server.URL,
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "render")
+	_, _, err = b.call(ctx, types.NewTrace(), b.url("/render"), []byte("query"))
+	parent.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	traceID := parent.SpanContext().TraceID.String()
+	if got := headers.Get("X-B3-TraceId"); got != traceID {
+		t.Errorf("expected b3 trace id %s, got %q", traceID, got)
+	}
+	if got := headers.Get("Traceparent"); !strings.Contains(got, traceID) {
+		t.Errorf("expected traceparent of trace %s, got %q", traceID, got)
+	}
+
+	if len(recorder.spans) != 2 {
+		t.Fatalf("expected the span of the call and its parent, got %d spans", len(recorder.spans))
+	}
+	span := recorder.spans[0]
+	if span.ParentSpanID != parent.SpanContext().SpanID || span.SpanKind != apitrace.SpanKindClient {
+		t.Errorf("expected a client span child of %s, got %+v", parent.SpanContext().SpanID, span)
+	}
+	if got := headers.Get("X-B3-SpanId"); got != span.SpanContext.SpanID.String() {
+		t.Errorf("expected the backend to get span %s, got %q", span.SpanContext.SpanID, got)
+	}
+
+	attrs := make(map[kv.Key]string)
+	for _, a := range span.Attributes {
+		attrs[a.Key] = a.Value.Emit()
+	}
+	expected := map[kv.Key]string{
+		"backend.address":       b.address,
+		"backend.request_size":  "5",
+		"backend.response_size": "2",
+		"http.status_code":      "200",
+	}
+	for k, v := range expected {
+		if attrs[k] != v {
+			t.Errorf("expected attribute %s=%s, got %q", k, v, attrs[k])
+		}
+	}
+}
+
 func TestCallServerError(t *testing.T) {
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Bad", 500)
@@ -128,7 +225,7 @@ func TestCallServerError(t *testing.T) {
 		return
 	}
 
-	_, _, err = b.call(context.Background(), types.NewTrace(), b.url("/render"))
+	_, _, err = b.call(context.Background(), types.NewTrace(), b.url("/render"), nil)
 	if err == nil {
 		t.Error("Expected error")
 	}
@@ -147,7 +244,7 @@ func TestCallTimeout(t *testing.T) {
 		return
 	}
 
-	_, _, err = b.call(context.Background(), types.NewTrace(), b.url("/render"))
+	_, _, err = b.call(context.Background(), types.NewTrace(), b.url("/render"), nil)
 	if err == nil {
 		t.Error("Expected error")
 	}
@@ -170,7 +267,7 @@ func TestCallLimiterTimeout(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 0)
 	defer cancel()
 
-	_, _, err = b.call(ctx, types.NewTrace(), b.url("/render"))
+	_, _, err = b.call(ctx, types.NewTrace(), b.url("/render"), nil)
 	if err == nil {
 		t.Error("Expected to time out")
 	}
@@ -193,7 +290,7 @@ func TestCallTimeoutLeavesLimiter(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), 0)
 	defer cancel()
 
-	_, _, err = b.call(ctx, types.NewTrace(), b.url("/render"))
+	_, _, err = b.call(ctx, types.NewTrace(), b.url("/render"), nil)
 	if err == nil {
 		t.Error("Expected to time out")
 	}
@@ -217,7 +314,7 @@ func TestDo(t *testing.T) {
 		return
 	}
 
-	req, err := b.request(context.Background(), b.url("/render"))
+	req, err := b.request(context.Background(), b.url("/render"), nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -232,6 +329,76 @@ func TestDo(t *testing.T) {
 	}
 }
 
+func TestDoMismatches(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set(MismatchesHeader, "5")
+		w.Header().Set(FixedMismatchesHeader, "3")
+		w.Write([]byte("OK"))
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address: strings.TrimPrefix(server.URL, "http://"),
+		Client:  server.Client(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := b.request(context.Background(), b.url("/render"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trace := types.NewTrace()
+	if _, _, err := b.do(trace, req); err != nil {
+		t.Fatal(err)
+	}
+	if mismatches, fixed := trace.Mismatches(); mismatches != 5 || fixed != 3 {
+		t.Errorf("expected 5 mismatches, 3 fixed, got %d, %d fixed", mismatches, fixed)
+	}
+}
+
+func TestDoCompression(t *testing.T) {
+	exp := []byte(strings.Repeat("OK", 100))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Accept-Encoding") != "gzip" {
+			w.Write(exp)
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		gz := gzip.NewWriter(w)
+		gz.Write(exp)
+		gz.Close()
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address:     strings.TrimPrefix(server.URL, "http://"),
+		Client:      server.Client(),
+		Compression: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req, err := b.request(context.Background(), b.url("/render"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enc := req.Header.Get("Accept-Encoding"); enc != "gzip" {
+		t.Errorf("Expected to accept gzip, got %q", enc)
+	}
+
+	_, got, err := b.do(types.NewTrace(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, exp) {
+		t.Errorf("Bad response body\nExp %s\nGot %s", exp, got)
+	}
+}
+
 func TestDoHTTPTimeout(t *testing.T) {
 	d := time.Nanosecond
 	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -252,7 +419,7 @@ func TestDoHTTPTimeout(t *testing.T) {
 	ctx, cancel := context.WithTimeout(context.Background(), d)
 	defer cancel()
 
-	req, err := b.request(ctx, b.url("/render"))
+	req, err := b.request(ctx, b.url("/render"), nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -278,7 +445,7 @@ func TestDoHTTPError(t *testing.T) {
 		return
 	}
 
-	req, err := b.request(context.Background(), b.url("/render"))
+	req, err := b.request(context.Background(), b.url("/render"), nil)
 	if err != nil {
 		t.Error(err)
 	}
@@ -289,6 +456,50 @@ func TestDoHTTPError(t *testing.T) {
 	}
 }
 
+func TestDoHTTPClientErrors(t *testing.T) {
+	tests := []struct {
+		code       int
+		body       string
+		retryAfter string
+		expected   error
+	}{
+		{http.StatusBadRequest, "bad query\n", "", types.ErrBadRequest("bad query")},
+		{http.StatusForbidden, "too many metrics", "", types.ErrForbidden("too many metrics")},
+		{http.StatusForbidden, "", "", types.ErrForbidden("Forbidden")},
+		{http.StatusBadRequest, strings.Repeat("x", 1000), "", types.ErrBadRequest(strings.Repeat("x", maxErrorMessage))},
+		{http.StatusTooManyRequests, "slow down", "", types.ErrBackendOverloaded{Message: "slow down"}},
+		{http.StatusServiceUnavailable, "", "30", types.ErrBackendOverloaded{Message: "Service Unavailable", RetryAfter: 30 * time.Second}},
+		{http.StatusBadGateway, "", "30", ErrHTTPCode(http.StatusBadGateway)},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.retryAfter != "" {
+				w.Header().Set("Retry-After", tt.retryAfter)
+			}
+			w.WriteHeader(tt.code)
+			w.Write([]byte(tt.body))
+		}))
+
+		b, err := New(Config{
+			Address: strings.TrimPrefix(server.URL, "http://"),
+			Client:  server.Client(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := b.request(context.Background(), b.url("/render"), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err = b.do(types.NewTrace(), req); err != tt.expected {
+			t.Errorf("%d %q: expected error %v, got %v", tt.code, tt.body, tt.expected, err)
+		}
+		server.Close()
+	}
+}
+
 func TestRequest(t *testing.T) {
 	b, err := New(Config{Address: "localhost"})
 	if err != nil {
@@ -296,12 +507,38 @@ func TestRequest(t *testing.T) {
 		return
 	}
 
-	_, err = b.request(context.Background(), b.url("/render"))
+	_, err = b.request(context.Background(), b.url("/render"), nil)
 	if err != nil {
 		t.Error(err)
 	}
 }
 
+func TestRequestTagging(t *testing.T) {
+	b, err := New(Config{
+		Address:     "localhost",
+		UserAgent:   "carbonzipper/1.0 (host1)",
+		QueryParams: map[string]string{"source": "carbonapi-dc1"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u := b.url("/render")
+	u.RawQuery = url.Values{"target": []string{"foo"}}.Encode()
+	req, err := b.request(context.Background(), u, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := req.Header.Get("User-Agent"); got != "carbonzipper/1.0 (host1)" {
+		t.Errorf("Expected User-Agent 'carbonzipper/1.0 (host1)', got '%s'", got)
+	}
+	q := req.URL.Query()
+	if q.Get("source") != "carbonapi-dc1" || q.Get("target") != "foo" {
+		t.Errorf("Unexpected query string '%s'", req.URL.RawQuery)
+	}
+}
+
 func TestEnterNilLimiter(t *testing.T) {
 	b, err := New(Config{})
 	if err != nil {
@@ -428,8 +665,8 @@ func TestURL(t *testing.T) {
 func TestCarbonapiv2RenderEncoder(t *testing.T) {
 	u := &url.URL{}
 
-	var from int32 = 100
-	var until int32 = 200
+	var from int64 = 100
+	var until int64 = 200
 	metrics := []string{"foo", "bar"}
 
 	gotURL := carbonapiV2RenderEncoder(u, from, until, metrics)
@@ -475,3 +712,275 @@ func TestCarbonapiv2FindEncoder(t *testing.T) {
 	}
 
 }
+
+func TestUnknownProtocol(t *testing.T) {
+	_, err := New(Config{
+		Address:  "localhost:8080",
+		Protocol: "foo",
+	})
+	if err == nil {
+		t.Error("Expected error")
+	}
+}
+
+func TestUnknownEndpointProtocol(t *testing.T) {
+	for _, cfg := range []Config{
+		{Address: "localhost:8080", FindProtocol: "foo"},
+		{Address: "localhost:8080", RenderProtocol: ProtocolPickle},
+		{Address: "localhost:8080", InfoProtocol: ProtocolPickle},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("Expected error for %+v", cfg)
+		}
+	}
+}
+
+func TestEndpointProtocols(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		format := r.URL.Query().Get("format")
+		switch r.URL.Path {
+		case "/metrics/find/":
+			if format != ProtocolPickle {
+				http.Error(w, "Bad", http.StatusBadRequest)
+				return
+			}
+			blob, _ := pickle.FindEncoderV0_9(types.Matches{
+				Matches: []types.Match{{Path: "foo", IsLeaf: true}},
+			})
+			w.Header().Set("Content-Type", "application/pickle")
+			w.Write(blob)
+
+		case "/render/":
+			if format != carbonapi_v3.ProtocolName {
+				http.Error(w, "Bad", http.StatusBadRequest)
+				return
+			}
+			blob, _ := carbonapi_v3.RenderEncoder([]types.Metric{
+				{
+					Name:      "foo",
+					StartTime: 100,
+					StopTime:  200,
+					StepTime:  100,
+					Values:    []float64{1},
+					IsAbsent:  types.AbsenceOf(false),
+				},
+			})
+			w.Header().Set("Content-Type", carbonapi_v3.ContentType)
+			w.Write(blob)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address:      server.URL,
+		Client:       server.Client(),
+		Protocol:     ProtocolCarbonAPIV3,
+		FindProtocol: ProtocolPickle,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	matches, err := b.Find(context.Background(), types.NewFindRequest("foo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches.Matches) != 1 || matches.Matches[0].Path != "foo" || matches.Name != "foo" {
+		t.Errorf("Unexpected matches %v", matches)
+	}
+
+	metrics, err := b.Render(context.Background(), types.NewRenderRequest([]string{"foo"}, 100, 200))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Name != "foo" {
+		t.Errorf("Unexpected metrics %v", metrics)
+	}
+}
+
+func TestFallbackProtocol(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/render/" {
+			http.NotFound(w, r)
+			return
+		}
+
+		// The backend claims carbonapi_v3_pb but answers garbage to it.
+		if r.URL.Query().Get("format") == carbonapi_v3.ProtocolName {
+			w.Header().Set("Content-Type", carbonapi_v3.ContentType)
+			w.Write([]byte("garbage"))
+			return
+		}
+		blob, _ := carbonapi_v2.RenderEncoder([]types.Metric{
+			{
+				Name:      "foo",
+				StartTime: 100,
+				StopTime:  200,
+				StepTime:  100,
+				Values:    []float64{1},
+				IsAbsent:  types.AbsenceOf(false),
+			},
+		})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(blob)
+	}))
+	defer server.Close()
+
+	request := types.NewRenderRequest([]string{"foo"}, 100, 200)
+
+	b, err := New(Config{
+		Address:  server.URL,
+		Client:   server.Client(),
+		Protocol: ProtocolCarbonAPIV3,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.Render(context.Background(), request); err == nil {
+		t.Fatal("Expected a decode error without a fallback protocol")
+	} else if _, ok := err.(ErrDecode); !ok {
+		t.Fatalf("Expected a decode error, got %v", err)
+	}
+
+	fallbacks := prometheus.NewCounterVec(prometheus.CounterOpts{Name: "fallbacks"}, []string{"endpoint"})
+	b, err = New(Config{
+		Address:           server.URL,
+		Client:            server.Client(),
+		Protocol:          ProtocolCarbonAPIV3,
+		FallbackProtocol:  ProtocolCarbonAPIV2,
+		ProtocolFallbacks: fallbacks,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	metrics, err := b.Render(context.Background(), request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Name != "foo" {
+		t.Errorf("Unexpected metrics %v", metrics)
+	}
+
+	var m dto.Metric
+	if err := fallbacks.WithLabelValues("render").Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.GetCounter().GetValue(); got != 1 {
+		t.Errorf("Expected 1 fallback, got %v", got)
+	}
+}
+
+func TestUnknownFallbackProtocol(t *testing.T) {
+	_, err := New(Config{
+		Address:          "localhost:8080",
+		FallbackProtocol: "json",
+	})
+	if err == nil {
+		t.Error("Expected an error for an unknown fallback protocol")
+	}
+}
+
+func TestProtocolNegotiation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/_internal/capabilities/":
+			blob, _ := carbonapi_v3.CapabilityEncoder("test", []string{carbonapi_v3.ProtocolName})
+			w.Header().Set("Content-Type", carbonapi_v3.ContentType)
+			w.Write(blob)
+
+		case "/render/":
+			if r.Method != "POST" || r.URL.Query().Get("format") != carbonapi_v3.ProtocolName {
+				http.Error(w, "Bad", http.StatusBadRequest)
+				return
+			}
+			blob, _ := carbonapi_v3.RenderEncoder([]types.Metric{
+				{
+					Name:      "foo",
+					StartTime: 100,
+					StopTime:  200,
+					StepTime:  100,
+					Values:    []float64{1},
+					IsAbsent:  types.AbsenceOf(false),
+				},
+			})
+			w.Header().Set("Content-Type", carbonapi_v3.ContentType)
+			w.Write(blob)
+
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address:  server.URL,
+		Client:   server.Client(),
+		Protocol: ProtocolAuto,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if got := b.Protocol(context.Background()); got != ProtocolCarbonAPIV3 {
+		t.Errorf("Expected %s, got %s", ProtocolCarbonAPIV3, got)
+	}
+
+	metrics, err := b.Render(context.Background(), types.NewRenderRequest([]string{"foo"}, 100, 200))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(metrics) != 1 || metrics[0].Name != "foo" {
+		t.Errorf("Unexpected metrics %v", metrics)
+	}
+}
+
+func TestProtocolNegotiationFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.NotFound(w, r)
+	}))
+	defer server.Close()
+
+	b, err := New(Config{
+		Address:  server.URL,
+		Client:   server.Client(),
+		Protocol: ProtocolAuto,
+	})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if got := b.Protocol(context.Background()); got != ProtocolCarbonAPIV2 {
+		t.Errorf("Expected %s, got %s", ProtocolCarbonAPIV2, got)
+	}
+}
+
+func TestCarbonapiv3RenderEncoder(t *testing.T) {
+	u := &url.URL{}
+
+	gotURL, body, err := carbonapiV3RenderEncoder(u, 100, 200, []string{"foo", "bar"})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if got := gotURL.Query().Get("format"); got != carbonapi_v3.ProtocolName {
+		t.Errorf("Bad format: got %s", got)
+	}
+
+	targets, from, until, err := carbonapi_v3.RenderRequestDecoder(body)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(targets) != 2 || targets[0] != "foo" || targets[1] != "bar" || from != 100 || until != 200 {
+		t.Errorf("Bad request: got %v %d %d", targets, from, until)
+	}
+}
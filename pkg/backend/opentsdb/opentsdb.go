@@ -0,0 +1,605 @@
+// Package opentsdb implements a backend that translates find and render into
+// the /api/suggest and /api/query requests of OpenTSDB 2.x, for metrics that
+// are only in OpenTSDB.
+//
+// The path of a series is its metric name, under a prefix if set, followed by
+// the values of a list of tags, see Scheme. Render reads each path by the
+// step of the backend, aggregating the series that only differ by other tags.
+package opentsdb
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend/glob"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/dgryski/go-expirecache"
+	"go.uber.org/zap"
+)
+
+// Scheme is the address scheme that selects the OpenTSDB backend, as in
+// "opentsdb://host:4242?prefix=tsdb&tags=host,cpu".
+//
+// With these parameters, the series of the metric sys.cpu.user tagged
+// host=a and cpu=0 is the path tsdb.sys.cpu.user.a.0. The query parameters
+// are:
+//
+//	prefix:     the path under which the metrics are, default none
+//	tags:       the tags whose values follow the metric name, default none
+//	aggregator: aggregates the series of a path and their points by step,
+//	            default avg
+//	step:       the interval of the points of render, default 1m
+//	lookback:   how far back find looks for the values of tags, default 24h
+//	max:        the most metrics a find suggests, default 10000
+//	tls:        true to connect with HTTPS
+const Scheme = "opentsdb"
+
+// Backend is an OpenTSDB cluster.
+type Backend struct {
+	address    string
+	endpoint   string
+	prefix     []string
+	tags       []string
+	aggregator string
+	step       int64
+	lookback   int64
+	max        int
+
+	client         *http.Client
+	timeout        time.Duration
+	logger         *zap.Logger
+	cache          *expirecache.Cache
+	cacheExpirySec int32
+	now            func() time.Time
+}
+
+// Config configures an OpenTSDB backend.
+//
+// The only required field is Address, see Scheme for its format.
+type Config struct {
+	Address string // The backend address.
+
+	// Optional fields
+	Client             *http.Client  // The client to use to communicate with backend. Defaults to http.DefaultClient.
+	Timeout            time.Duration // Set request timeout. Defaults to no timeout.
+	PathCacheExpirySec uint32        // Set time in seconds before items in path cache expire. Defaults to 10 minutes.
+	Logger             *zap.Logger   // Logger to use. Defaults to a no-op logger.
+}
+
+// New creates a new backend from the given configuration.
+func New(cfg Config) (*Backend, error) {
+	u, err := url.Parse(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != Scheme || u.Host == "" {
+		return nil, fmt.Errorf("invalid OpenTSDB address '%s'", cfg.Address)
+	}
+
+	params := u.Query()
+	b := &Backend{
+		aggregator:     "avg",
+		max:            10000,
+		client:         http.DefaultClient,
+		timeout:        cfg.Timeout,
+		logger:         zap.New(nil),
+		cache:          expirecache.New(0),
+		cacheExpirySec: int32(10 * time.Minute / time.Second),
+		now:            time.Now,
+	}
+	if p := params.Get("prefix"); p != "" {
+		b.prefix = strings.Split(p, ".")
+	}
+	if t := params.Get("tags"); t != "" {
+		b.tags = strings.Split(t, ",")
+	}
+	for _, s := range append(append([]string{}, b.prefix...), b.tags...) {
+		if s == "" || glob.IsGlob(s) {
+			return nil, fmt.Errorf("invalid prefix or tags in OpenTSDB address '%s'", cfg.Address)
+		}
+	}
+	if a := params.Get("aggregator"); a != "" {
+		b.aggregator = a
+	}
+	if b.step, err = seconds(params.Get("step"), time.Minute); err != nil {
+		return nil, err
+	}
+	if b.lookback, err = seconds(params.Get("lookback"), 24*time.Hour); err != nil {
+		return nil, err
+	}
+	if m := params.Get("max"); m != "" {
+		if b.max, err = strconv.Atoi(m); err != nil || b.max <= 0 {
+			return nil, fmt.Errorf("invalid OpenTSDB max '%s'", m)
+		}
+	}
+	scheme := "http"
+	if params.Get("tls") == "true" {
+		scheme = "https"
+	}
+	b.endpoint = (&url.URL{Scheme: scheme, Host: u.Host}).String()
+	b.address = u.String()
+
+	if cfg.Client != nil {
+		b.client = cfg.Client
+	}
+	if cfg.Logger != nil {
+		b.logger = cfg.Logger
+	}
+	if cfg.PathCacheExpirySec > 0 {
+		b.cacheExpirySec = int32(cfg.PathCacheExpirySec)
+	}
+
+	return b, nil
+}
+
+// seconds parses a duration of whole seconds, or returns def if s is empty.
+func seconds(s string, def time.Duration) (int64, error) {
+	d := def
+	if s != "" {
+		var err error
+		if d, err = time.ParseDuration(s); err != nil {
+			return 0, err
+		}
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("invalid OpenTSDB duration %s", d)
+	}
+
+	return int64(d / time.Second), nil
+}
+
+// GetServerAddress returns the address of the backend.
+func (b Backend) GetServerAddress() string {
+	return b.address
+}
+
+// Logger returns the logger of the backend.
+func (b Backend) Logger() *zap.Logger {
+	return b.logger
+}
+
+// Contains reports whether any of the targets is under the prefix of the
+// backend, or without a prefix whether the backend found any of them
+// recently.
+func (b Backend) Contains(targets []string) bool {
+	for _, target := range targets {
+		if len(b.prefix) > 0 {
+			segments := strings.Split(target, ".")
+			if len(segments) > len(b.prefix) && b.matchPrefix(segments) {
+				return true
+			}
+			continue
+		}
+		if _, ok := b.cache.Get(target); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchPrefix reports whether the leading segments of a query match the
+// prefix.
+func (b Backend) matchPrefix(segments []string) bool {
+	for i := 0; i < len(b.prefix) && i < len(segments); i++ {
+		if !glob.Match(segments[i], b.prefix[i]) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// errNoSuchName is the error of OpenTSDB for unknown metrics and tags.
+const errNoSuchName = "No such name"
+
+type apiError struct {
+	Error struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// call sends a request to path of the API, posting the body if any, and
+// decodes the response into v. Unknown names leave v empty.
+func (b Backend) call(ctx context.Context, path string, body interface{}, v interface{}) error {
+	if b.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, b.timeout)
+		defer cancel()
+	}
+
+	method, payload := http.MethodGet, []byte(nil)
+	if body != nil {
+		var err error
+		if payload, err = json.Marshal(body); err != nil {
+			return err
+		}
+		method = http.MethodPost
+	}
+	req, err := http.NewRequest(method, b.endpoint+path, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req = req.WithContext(ctx)
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	blob, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var e apiError
+		if json.Unmarshal(blob, &e) != nil || e.Error.Message == "" {
+			return fmt.Errorf("OpenTSDB returned status %d", resp.StatusCode)
+		}
+		if strings.Contains(e.Error.Message, errNoSuchName) {
+			return nil
+		}
+		return fmt.Errorf("OpenTSDB returned status %d: %s", resp.StatusCode, e.Error.Message)
+	}
+
+	return json.Unmarshal(blob, v)
+}
+
+// suggest returns the metric names starting with prefix.
+func (b Backend) suggest(ctx context.Context, prefix string) ([]string, error) {
+	params := url.Values{
+		"type": {"metrics"},
+		"q":    {prefix},
+		"max":  {strconv.Itoa(b.max)},
+	}
+	var metrics []string
+	if err := b.call(ctx, "/api/suggest?"+params.Encode(), nil, &metrics); err != nil {
+		return nil, err
+	}
+	if len(metrics) >= b.max {
+		b.logger.Warn("OpenTSDB suggested the most metrics asked, some may be missing",
+			zap.String("prefix", prefix),
+			zap.Int("max", b.max),
+		)
+	}
+
+	return metrics, nil
+}
+
+type queryRequest struct {
+	Start   int64      `json:"start"`
+	End     int64      `json:"end"`
+	Queries []subQuery `json:"queries"`
+}
+
+type subQuery struct {
+	Aggregator string   `json:"aggregator"`
+	Metric     string   `json:"metric"`
+	Downsample string   `json:"downsample"`
+	Filters    []filter `json:"filters,omitempty"`
+}
+
+type filter struct {
+	Type    string `json:"type"`
+	Tagk    string `json:"tagk"`
+	Filter  string `json:"filter"`
+	GroupBy bool   `json:"groupBy"`
+}
+
+type series struct {
+	Metric string              `json:"metric"`
+	Tags   map[string]string   `json:"tags"`
+	Dps    map[string]*float64 `json:"dps"`
+}
+
+// query runs the queries over [start, end) and returns their series.
+func (b Backend) query(ctx context.Context, start, end int64, queries []subQuery) ([]series, error) {
+	var result []series
+	err := b.call(ctx, "/api/query", queryRequest{Start: start, End: end - 1, Queries: queries}, &result)
+
+	return result, err
+}
+
+// node is a node of the graphite tree, and for leaves the series it reads.
+type node struct {
+	path string
+	leaf bool
+
+	metric string
+	tags   []string
+}
+
+// key identifies the series of a metric and the values of the tags.
+func key(metric string, values []string) string {
+	return metric + "\x00" + strings.Join(values, "\x00")
+}
+
+// nodes returns the nodes matching query, sorted by path.
+func (b Backend) nodes(ctx context.Context, query string) ([]node, error) {
+	segments := strings.Split(query, ".")
+	if !b.matchPrefix(segments) {
+		return nil, nil
+	}
+	if len(segments) <= len(b.prefix) {
+		return []node{{path: strings.Join(b.prefix[:len(segments)], ".")}}, nil
+	}
+	base := strings.Join(b.prefix, ".")
+	segments = segments[len(b.prefix):]
+
+	// The metric names are at least as long as the query without the values
+	// of the tags, and start with its literal part.
+	var literal string
+	if n := len(segments) - len(b.tags); n > 0 {
+		literal = strings.Join(segments[:n], ".")
+	}
+	if i := strings.IndexAny(literal, "*?[{"); i >= 0 {
+		literal = literal[:i]
+	}
+	metrics, err := b.suggest(ctx, literal)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var nodes []node
+	add := func(parts []string, leaf bool, metric string, tags []string) {
+		path := strings.Join(parts, ".")
+		if base != "" {
+			path = base + "." + path
+		}
+		if seen[path+strconv.FormatBool(leaf)] {
+			return
+		}
+		seen[path+strconv.FormatBool(leaf)] = true
+		nodes = append(nodes, node{path: path, leaf: leaf, metric: metric, tags: tags})
+	}
+
+	// Metrics shorter than the query are followed by tag values, which are
+	// looked up by metric.
+	var tagged []subQuery
+	for _, metric := range metrics {
+		parts := strings.Split(metric, ".")
+		k := len(parts)
+		if k > len(segments) {
+			k = len(segments)
+		}
+		if !matchSegments(segments[:k], parts[:k]) {
+			continue
+		}
+		switch {
+		case len(parts) >= len(segments):
+			add(parts[:k], len(parts) == len(segments) && len(b.tags) == 0, metric, nil)
+		case len(segments)-len(parts) <= len(b.tags):
+			tagged = append(tagged, b.lookupQuery(metric, segments[len(parts):]))
+		}
+	}
+
+	if len(tagged) > 0 {
+		now := b.now().Unix()
+		result, err := b.query(ctx, now-b.lookback, now+1, tagged)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range result {
+			parts := strings.Split(s.Metric, ".")
+			n := len(segments) - len(parts)
+			if n <= 0 {
+				continue
+			}
+			values := make([]string, n)
+			for i := range values {
+				values[i] = s.Tags[b.tags[i]]
+			}
+			if !matchSegments(segments[len(parts):], values) {
+				continue
+			}
+			add(append(parts, values...), n == len(b.tags), s.Metric, values)
+		}
+	}
+
+	sort.Slice(nodes, func(i, j int) bool {
+		if nodes[i].path == nodes[j].path {
+			return !nodes[i].leaf
+		}
+		return nodes[i].path < nodes[j].path
+	})
+
+	return nodes, nil
+}
+
+// matchSegments reports whether the names match the segments of a glob.
+// Dots can't be in graphite nodes.
+func matchSegments(segments, names []string) bool {
+	for i, name := range names {
+		if name == "" || strings.Contains(name, ".") || !glob.Match(segments[i], name) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// lookupQuery returns the query of the series of metric grouped by the
+// values of the tags matching segments.
+func (b Backend) lookupQuery(metric string, segments []string) subQuery {
+	q := subQuery{
+		Aggregator: "sum",
+		Metric:     metric,
+		Downsample: fmt.Sprintf("%ds-count", b.lookback),
+	}
+	for i, segment := range segments {
+		f := filter{Type: "wildcard", Tagk: b.tags[i], Filter: "*", GroupBy: true}
+		if !glob.IsGlob(segment) {
+			f.Type, f.Filter = "literal_or", segment
+		}
+		q.Filters = append(q.Filters, f)
+	}
+
+	return q
+}
+
+// Find resolves globs and finds metrics in OpenTSDB.
+func (b Backend) Find(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+	matches := types.Matches{Name: request.Query}
+
+	nodes, err := b.nodes(ctx, request.Query)
+	if err != nil {
+		return matches, err
+	}
+	for _, n := range nodes {
+		matches.Matches = append(matches.Matches, types.Match{
+			Path:   n.path,
+			IsLeaf: n.leaf,
+		})
+		if n.leaf {
+			b.cache.Set(n.path, struct{}{}, 0, b.cacheExpirySec)
+		}
+	}
+	if len(matches.Matches) == 0 {
+		return matches, types.ErrMatchesNotFound
+	}
+
+	return matches, nil
+}
+
+func (b Backend) leaves(ctx context.Context, targets []string) ([]node, error) {
+	seen := make(map[string]bool)
+	var leaves []node
+	for _, target := range targets {
+		nodes, err := b.nodes(ctx, target)
+		if err != nil {
+			return nil, err
+		}
+		for _, n := range nodes {
+			if n.leaf && !seen[n.path] {
+				seen[n.path] = true
+				leaves = append(leaves, n)
+			}
+		}
+	}
+
+	return leaves, nil
+}
+
+// Info returns the step of the metrics of the target.
+func (b Backend) Info(ctx context.Context, request types.InfoRequest) ([]types.Info, error) {
+	leaves, err := b.leaves(ctx, []string{request.Target})
+	if err != nil {
+		return nil, err
+	}
+
+	aggregation := b.aggregator
+	if aggregation == "avg" {
+		aggregation = "average"
+	}
+	var infos []types.Info
+	for _, n := range leaves {
+		infos = append(infos, types.Info{
+			Host:              b.address,
+			Name:              n.path,
+			AggregationMethod: aggregation,
+			Retentions:        []types.Retention{{SecondsPerPoint: int32(b.step)}},
+		})
+	}
+	if len(infos) == 0 {
+		return nil, types.ErrInfoNotFound
+	}
+
+	return infos, nil
+}
+
+// Render reads the metrics of the targets by the step of the backend, in a
+// single request.
+func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+	leaves, err := b.leaves(ctx, request.Targets)
+	if err != nil {
+		return nil, err
+	}
+	if len(leaves) == 0 {
+		return nil, types.ErrMetricsNotFound
+	}
+
+	queries := make([]subQuery, 0, len(leaves))
+	for _, n := range leaves {
+		q := subQuery{
+			Aggregator: b.aggregator,
+			Metric:     n.metric,
+			Downsample: fmt.Sprintf("%ds-%s-null", b.step, b.aggregator),
+		}
+		for i, value := range n.tags {
+			q.Filters = append(q.Filters, filter{Type: "literal_or", Tagk: b.tags[i], Filter: value})
+		}
+		queries = append(queries, q)
+	}
+	result, err := b.query(ctx, request.From, request.Until, queries)
+	if err != nil {
+		return nil, err
+	}
+
+	byKey := make(map[string]series, len(result))
+	for _, s := range result {
+		values := make([]string, len(b.tags))
+		for i, tag := range b.tags {
+			values[i] = s.Tags[tag]
+		}
+		byKey[key(s.Metric, values)] = s
+	}
+
+	var metrics []types.Metric
+	for _, n := range leaves {
+		if s, ok := byKey[key(n.metric, n.tags)]; ok && len(s.Dps) > 0 {
+			metrics = append(metrics, b.metric(n.path, s, request.From, request.Until))
+		}
+	}
+	if len(metrics) == 0 {
+		return nil, types.ErrMetricsNotFound
+	}
+
+	return metrics, nil
+}
+
+// metric converts the points of a series, downsampled by step, to a metric
+// of [from, until) aligned to the step.
+func (b Backend) metric(name string, s series, from, until int64) types.Metric {
+	start := from - from%b.step
+	n := (until - start + b.step - 1) / b.step
+	m := types.Metric{
+		Name:      name,
+		StartTime: int32(start),
+		StopTime:  int32(start + n*b.step),
+		StepTime:  int32(b.step),
+		Values:    make([]float64, n),
+		IsAbsent:  types.AllAbsent(int(n)),
+	}
+	for ts, v := range s.Dps {
+		t, err := strconv.ParseInt(ts, 10, 64)
+		if err != nil || v == nil {
+			continue
+		}
+		// Timestamps are in milliseconds if asked for, or by some versions.
+		if t > 1e11 {
+			t /= 1000
+		}
+		if i := (t - start) / b.step; t >= start && i < n {
+			m.Values[i] = *v
+			m.IsAbsent.Set(int(i), false)
+		}
+	}
+
+	return m
+}
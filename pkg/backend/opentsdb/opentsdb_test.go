@@ -0,0 +1,211 @@
+package opentsdb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+var (
+	testMetrics = []string{"sys.cpu.system", "sys.cpu.user", "sys.mem.free"}
+	testHosts   = []string{"a", "b"}
+)
+
+// newTestBackend serves suggestions of testMetrics and the series of
+// testHosts, and records the queries it gets.
+func newTestBackend(t *testing.T, params string) (*Backend, *[]subQuery) {
+	t.Helper()
+
+	var queries []subQuery
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/suggest", func(w http.ResponseWriter, r *http.Request) {
+		suggested := []string{}
+		for _, m := range testMetrics {
+			if strings.HasPrefix(m, r.FormValue("q")) {
+				suggested = append(suggested, m)
+			}
+		}
+		_ = json.NewEncoder(w).Encode(suggested)
+	})
+	mux.HandleFunc("/api/query", func(w http.ResponseWriter, r *http.Request) {
+		var req queryRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		result := []series{}
+		for _, q := range req.Queries {
+			queries = append(queries, q)
+			if q.Metric == "sys.nope" {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprint(w, `{"error": {"code": 400, "message": "No such name for 'metrics': 'sys.nope'"}}`)
+				return
+			}
+			for _, host := range testHosts {
+				if len(q.Filters) > 0 && q.Filters[0].Type == "literal_or" && q.Filters[0].Filter != host {
+					continue
+				}
+				one, none, three := 1.5, (*float64)(nil), 3.0
+				result = append(result, series{
+					Metric: q.Metric,
+					Tags:   map[string]string{"host": host},
+					Dps:    map[string]*float64{"60": &one, "120": none, "180": &three, "240": &three},
+				})
+			}
+		}
+		_ = json.NewEncoder(w).Encode(result)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	b, err := New(Config{Address: Scheme + "://" + strings.TrimPrefix(server.URL, "http://") + params})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.now = func() time.Time { return time.Unix(100000, 0) }
+
+	return b, &queries
+}
+
+func TestFind(t *testing.T) {
+	b, queries := newTestBackend(t, "?prefix=tsdb&tags=host")
+
+	for query, want := range map[string][]types.Match{
+		"*": {
+			{Path: "tsdb", IsLeaf: false},
+		},
+		"tsdb.sys.*": {
+			{Path: "tsdb.sys.cpu", IsLeaf: false},
+			{Path: "tsdb.sys.mem", IsLeaf: false},
+		},
+		"tsdb.sys.cpu.*": {
+			{Path: "tsdb.sys.cpu.system", IsLeaf: false},
+			{Path: "tsdb.sys.cpu.user", IsLeaf: false},
+		},
+		"tsdb.sys.cpu.u*.*": {
+			{Path: "tsdb.sys.cpu.user.a", IsLeaf: true},
+			{Path: "tsdb.sys.cpu.user.b", IsLeaf: true},
+		},
+	} {
+		got, err := b.Find(context.Background(), types.NewFindRequest(query))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if !reflect.DeepEqual(got.Matches, want) {
+			t.Errorf("%s: expected %v, got %v", query, want, got.Matches)
+		}
+	}
+
+	want := subQuery{
+		Aggregator: "sum",
+		Metric:     "sys.cpu.user",
+		Downsample: "86400s-count",
+		Filters:    []filter{{Type: "wildcard", Tagk: "host", Filter: "*", GroupBy: true}},
+	}
+	if len(*queries) != 1 || !reflect.DeepEqual((*queries)[0], want) {
+		t.Errorf("expected the lookup %+v, got %+v", want, *queries)
+	}
+
+	for _, query := range []string{"graphite.sys.*", "tsdb.sys.cpu.user.a.b"} {
+		if _, err := b.Find(context.Background(), types.NewFindRequest(query)); err != types.ErrMatchesNotFound {
+			t.Errorf("%s: expected %v, got %v", query, types.ErrMatchesNotFound, err)
+		}
+	}
+
+	if !b.Contains([]string{"tsdb.sys.cpu.user.a"}) || b.Contains([]string{"graphite.sys"}) || b.Contains([]string{"tsdb"}) {
+		t.Error("expected the backend to contain the paths under its prefix")
+	}
+}
+
+func TestFindWithoutTags(t *testing.T) {
+	b, _ := newTestBackend(t, "")
+
+	got, err := b.Find(context.Background(), types.NewFindRequest("sys.{cpu,mem}.*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.Match{
+		{Path: "sys.cpu.system", IsLeaf: true},
+		{Path: "sys.cpu.user", IsLeaf: true},
+		{Path: "sys.mem.free", IsLeaf: true},
+	}
+	if !reflect.DeepEqual(got.Matches, want) {
+		t.Errorf("expected %v, got %v", want, got.Matches)
+	}
+	if !b.Contains([]string{"sys.mem.free"}) || b.Contains([]string{"sys.mem.used"}) {
+		t.Error("expected the backend to contain the leaves it found")
+	}
+}
+
+func TestRender(t *testing.T) {
+	b, queries := newTestBackend(t, "?prefix=tsdb&tags=host&aggregator=max")
+
+	got, err := b.Render(context.Background(), types.NewRenderRequest([]string{"tsdb.sys.cpu.user.b"}, 90, 240))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.Metric{{
+		Name:      "tsdb.sys.cpu.user.b",
+		StartTime: 60,
+		StopTime:  240,
+		StepTime:  60,
+		Values:    []float64{1.5, 0, 3},
+		IsAbsent:  types.AbsenceOf(false, true, false),
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+
+	render := (*queries)[len(*queries)-1]
+	wantQuery := subQuery{
+		Aggregator: "max",
+		Metric:     "sys.cpu.user",
+		Downsample: "60s-max-null",
+		Filters:    []filter{{Type: "literal_or", Tagk: "host", Filter: "b"}},
+	}
+	if !reflect.DeepEqual(render, wantQuery) {
+		t.Errorf("expected the query %+v, got %+v", wantQuery, render)
+	}
+}
+
+func TestNoSuchName(t *testing.T) {
+	testMetrics = append(testMetrics, "sys.nope")
+	defer func() { testMetrics = testMetrics[:len(testMetrics)-1] }()
+	b, _ := newTestBackend(t, "")
+
+	if _, err := b.Render(context.Background(), types.NewRenderRequest([]string{"sys.nope"}, 60, 240)); err != types.ErrMetricsNotFound {
+		t.Errorf("expected %v for an unknown metric, got %v", types.ErrMetricsNotFound, err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	b, err := New(Config{Address: "opentsdb://tsdb:4242?prefix=a.b&tags=host,cpu&step=10s&tls=true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b.step != 10 || b.endpoint != "https://tsdb:4242" || !reflect.DeepEqual(b.prefix, []string{"a", "b"}) || !reflect.DeepEqual(b.tags, []string{"host", "cpu"}) {
+		t.Errorf("unexpected backend %+v", b)
+	}
+
+	for _, address := range []string{
+		"http://tsdb:4242",
+		"opentsdb:///",
+		"opentsdb://tsdb:4242?prefix=a..b",
+		"opentsdb://tsdb:4242?tags=host,*",
+		"opentsdb://tsdb:4242?step=1ms",
+		"opentsdb://tsdb:4242?max=0",
+	} {
+		if _, err := New(Config{Address: address}); err == nil {
+			t.Errorf("expected an error for %s", address)
+		}
+	}
+}
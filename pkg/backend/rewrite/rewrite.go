@@ -0,0 +1,237 @@
+// Package rewrite implements a backend that renames the metrics of another
+// backend on the fly, for backends that store metrics under other names than
+// the ones clients know.
+//
+// Names are rewritten in requests by the first rule that matches them, and
+// back in responses. Names that no rule matches in requests are not in the
+// backend: they are not asked for.
+package rewrite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend"
+	"github.com/bookingcom/carbonapi/pkg/backend/glob"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// rule rewrites names in requests and back in responses.
+type rule struct {
+	// prefix is the segments of the prefix known by clients, for prefix
+	// rules.
+	prefix []string
+
+	request         *regexp.Regexp
+	requestReplace  string
+	response        *regexp.Regexp
+	responseReplace string
+}
+
+// Backend renames the metrics of the backend it embeds.
+type Backend struct {
+	backend.Backend
+	rules []rule
+}
+
+// New returns b, renaming its metrics by the rewrites.
+func New(b backend.Backend, rewrites []cfg.NameRewrite) (*Backend, error) {
+	rb := &Backend{Backend: b}
+	for i, rw := range rewrites {
+		r, err := newRule(rw)
+		if err != nil {
+			return nil, fmt.Errorf("rewrite %d: %v", i, err)
+		}
+		rb.rules = append(rb.rules, r)
+	}
+
+	return rb, nil
+}
+
+func newRule(rw cfg.NameRewrite) (rule, error) {
+	if rw.Match == "" && rw.ResponseMatch == "" {
+		prefix, backendPrefix := withDot(rw.Prefix), withDot(rw.BackendPrefix)
+		if prefix == backendPrefix {
+			return rule{}, errors.New("no prefix to replace")
+		}
+		r := rule{
+			request:         regexp.MustCompile("^" + regexp.QuoteMeta(prefix)),
+			requestReplace:  strings.Replace(backendPrefix, "$", "$$", -1),
+			response:        regexp.MustCompile("^" + regexp.QuoteMeta(backendPrefix)),
+			responseReplace: strings.Replace(prefix, "$", "$$", -1),
+		}
+		if prefix != "" {
+			r.prefix = strings.Split(strings.TrimSuffix(prefix, "."), ".")
+		}
+
+		return r, nil
+	}
+
+	if rw.Prefix != "" || rw.BackendPrefix != "" {
+		return rule{}, errors.New("both a prefix and a match")
+	}
+	if rw.Match == "" || rw.ResponseMatch == "" {
+		return rule{}, errors.New("a match without a response match, or the opposite")
+	}
+	request, err := regexp.Compile(rw.Match)
+	if err != nil {
+		return rule{}, err
+	}
+	response, err := regexp.Compile(rw.ResponseMatch)
+	if err != nil {
+		return rule{}, err
+	}
+
+	return rule{
+		request:         request,
+		requestReplace:  rw.Replace,
+		response:        response,
+		responseReplace: rw.ResponseReplace,
+	}, nil
+}
+
+func withDot(prefix string) string {
+	if prefix == "" || strings.HasSuffix(prefix, ".") {
+		return prefix
+	}
+	return prefix + "."
+}
+
+// request returns the name of the backend for name, if a rule matches it.
+func (b *Backend) request(name string) (string, bool) {
+	for _, r := range b.rules {
+		if r.request.MatchString(name) {
+			return r.request.ReplaceAllString(name, r.requestReplace), true
+		}
+	}
+
+	return "", false
+}
+
+// response returns the name known by clients for name of the backend.
+func (b *Backend) response(name string) string {
+	for _, r := range b.rules {
+		if r.response.MatchString(name) {
+			return r.response.ReplaceAllString(name, r.responseReplace)
+		}
+	}
+
+	return name
+}
+
+// prefixes returns the nodes of the client prefixes that a query shorter
+// than them matches, e.g. dc1 for * and a prefix dc1.hosts.
+func (b *Backend) prefixes(query string) []types.Match {
+	segments := strings.Split(query, ".")
+	seen := make(map[string]bool)
+	var matches []types.Match
+	for _, r := range b.rules {
+		if len(segments) > len(r.prefix) {
+			continue
+		}
+		matched := true
+		for i, segment := range segments {
+			if !glob.Match(segment, r.prefix[i]) {
+				matched = false
+				break
+			}
+		}
+		path := strings.Join(r.prefix[:len(segments)], ".")
+		if matched && !seen[path] {
+			seen[path] = true
+			matches = append(matches, types.Match{Path: path})
+		}
+	}
+
+	return matches
+}
+
+// Find finds the metrics of the backend under the names known by clients.
+func (b *Backend) Find(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+	matches := types.Matches{
+		Name:    request.Query,
+		Matches: b.prefixes(request.Query),
+	}
+
+	if query, ok := b.request(request.Query); ok {
+		request.Query = query
+		found, err := b.Backend.Find(ctx, request)
+		if _, notFound := err.(types.ErrNotFound); err != nil && !notFound {
+			return matches, err
+		}
+		for _, m := range found.Matches {
+			m.Path = b.response(m.Path)
+			matches.Matches = append(matches.Matches, m)
+		}
+	}
+	if len(matches.Matches) == 0 {
+		return matches, types.ErrMatchesNotFound
+	}
+
+	return matches, nil
+}
+
+// Info returns the info of the metrics of the target under the names known
+// by clients.
+func (b *Backend) Info(ctx context.Context, request types.InfoRequest) ([]types.Info, error) {
+	target, ok := b.request(request.Target)
+	if !ok {
+		return nil, types.ErrInfoNotFound
+	}
+	request.Target = target
+
+	infos, err := b.Backend.Info(ctx, request)
+	for i := range infos {
+		infos[i].Name = b.response(infos[i].Name)
+	}
+
+	return infos, err
+}
+
+// Render renders the metrics of the targets under the names known by
+// clients.
+func (b *Backend) Render(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+	targets := make([]string, 0, len(request.Targets))
+	for _, target := range request.Targets {
+		if t, ok := b.request(target); ok {
+			targets = append(targets, t)
+		}
+	}
+	if len(targets) == 0 {
+		return nil, types.ErrMetricsNotFound
+	}
+	request.Targets = targets
+
+	metrics, err := b.Backend.Render(ctx, request)
+	for i := range metrics {
+		metrics[i].Name = b.response(metrics[i].Name)
+	}
+
+	return metrics, err
+}
+
+// Contains reports whether the backend contains any of the targets, under
+// its own names.
+func (b *Backend) Contains(targets []string) bool {
+	renamed := make([]string, 0, len(targets))
+	for _, target := range targets {
+		if t, ok := b.request(target); ok {
+			renamed = append(renamed, t)
+		}
+	}
+
+	return len(renamed) > 0 && b.Backend.Contains(renamed)
+}
+
+// Close closes the backend, if it holds connections.
+func (b *Backend) Close() error {
+	if closer, ok := b.Backend.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
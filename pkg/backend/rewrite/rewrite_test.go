@@ -0,0 +1,146 @@
+package rewrite
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+	"github.com/bookingcom/carbonapi/pkg/backend/mock"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// stored are the metrics of the test backend, under its own names.
+var stored = []string{"legacy.web.cpu", "legacy.web.mem", "hosts.db1.load"}
+
+func newTestBackend(t *testing.T, rewrites []cfg.NameRewrite) (*Backend, *[]string) {
+	t.Helper()
+
+	var asked []string
+	b, err := New(mock.New(mock.Config{
+		Find: func(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+			asked = append(asked, request.Query)
+			matches := types.Matches{Name: request.Query}
+			for _, name := range stored {
+				if strings.HasPrefix(name, strings.TrimSuffix(request.Query, "*")) {
+					matches.Matches = append(matches.Matches, types.Match{Path: name, IsLeaf: true})
+				}
+			}
+			if len(matches.Matches) == 0 {
+				return matches, types.ErrMatchesNotFound
+			}
+			return matches, nil
+		},
+		Render: func(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+			asked = append(asked, request.Targets...)
+			var metrics []types.Metric
+			for _, target := range request.Targets {
+				metrics = append(metrics, types.Metric{Name: target})
+			}
+			return metrics, nil
+		},
+		Contains: func(targets []string) bool {
+			asked = append(asked, targets...)
+			return true
+		},
+	}), rewrites)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b, &asked
+}
+
+func paths(matches types.Matches) []string {
+	var p []string
+	for _, m := range matches.Matches {
+		p = append(p, m.Path)
+	}
+	return p
+}
+
+func TestPrefixRewrite(t *testing.T) {
+	b, asked := newTestBackend(t, []cfg.NameRewrite{
+		{Prefix: "dc1.app", BackendPrefix: "legacy"},
+	})
+
+	for query, want := range map[string][]string{
+		"*":             {"dc1"},
+		"dc1.*":         {"dc1.app"},
+		"dc1.app.web.*": {"dc1.app.web.cpu", "dc1.app.web.mem"},
+	} {
+		got, err := b.Find(context.Background(), types.NewFindRequest(query))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if !reflect.DeepEqual(paths(got), want) {
+			t.Errorf("%s: expected %v, got %v", query, want, paths(got))
+		}
+	}
+	if !reflect.DeepEqual(*asked, []string{"legacy.web.*"}) {
+		t.Errorf("expected only the queries under the prefix to be sent, got %v", *asked)
+	}
+
+	if _, err := b.Find(context.Background(), types.NewFindRequest("dc2.*")); err != types.ErrMatchesNotFound {
+		t.Errorf("expected %v outside the prefix, got %v", types.ErrMatchesNotFound, err)
+	}
+
+	metrics, err := b.Render(context.Background(), types.NewRenderRequest([]string{"dc1.app.web.cpu", "dc2.web.cpu"}, 0, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(metrics) != 1 || metrics[0].Name != "dc1.app.web.cpu" {
+		t.Errorf("expected the metric under the name of the client, got %+v", metrics)
+	}
+
+	if b.Contains([]string{"dc2.web.cpu"}) {
+		t.Error("expected the backend not to contain names outside the prefix")
+	}
+}
+
+func TestAddPrefix(t *testing.T) {
+	b, _ := newTestBackend(t, []cfg.NameRewrite{
+		{BackendPrefix: "legacy."},
+	})
+
+	got, err := b.Find(context.Background(), types.NewFindRequest("web.c*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"web.cpu"}; !reflect.DeepEqual(paths(got), want) {
+		t.Errorf("expected %v, got %v", want, paths(got))
+	}
+}
+
+func TestRegexpRewrite(t *testing.T) {
+	b, asked := newTestBackend(t, []cfg.NameRewrite{
+		{
+			Match:           `^servers\.([^.]+)\.load$`,
+			Replace:         "hosts.$1.load",
+			ResponseMatch:   `^hosts\.([^.]+)\.load$`,
+			ResponseReplace: "servers.$1.load",
+		},
+	})
+
+	metrics, err := b.Render(context.Background(), types.NewRenderRequest([]string{"servers.db1.load"}, 0, 60))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*asked, []string{"hosts.db1.load"}) || metrics[0].Name != "servers.db1.load" {
+		t.Errorf("expected hosts.db1.load to be asked for as servers.db1.load, asked %v for %+v", *asked, metrics)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	for _, rw := range []cfg.NameRewrite{
+		{Prefix: "a", BackendPrefix: "a."},
+		{Prefix: "a", Match: "^b"},
+		{Match: "^b", Replace: "c"},
+		{Match: "(", ResponseMatch: "^c"},
+	} {
+		if _, err := New(mock.New(mock.Config{}), []cfg.NameRewrite{rw}); err == nil {
+			t.Errorf("expected an error for %+v", rw)
+		}
+	}
+}
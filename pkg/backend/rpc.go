@@ -17,6 +17,9 @@ package backend
 
 import (
 	"context"
+	"sync"
+	"time"
+
 	"github.com/bookingcom/carbonapi/cfg"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
@@ -28,6 +31,8 @@ import (
 type Backend interface {
 	Find(context.Context, types.FindRequest) (types.Matches, error)
 	Info(context.Context, types.InfoRequest) ([]types.Info, error)
+	// Render returns metrics that are the caller's own, not shared with
+	// other calls, since Renders gives their values back to the pool.
 	Render(context.Context, types.RenderRequest) ([]types.Metric, error)
 
 	Contains([]string) bool // Reports whether a backend contains any of the given targets.
@@ -35,11 +40,25 @@ type Backend interface {
 	GetServerAddress() string
 }
 
-// TODO(gmagnusson): ^ Remove IsAbsent: IsAbsent[i] => Values[i] == NaN
-// Doing math on NaN is expensive, but assuming that all functions will treat a
-// default value of 0 intelligently is wrong (see multiplication). Thus math
-// needs an if IsAbsent[i] check anyway, which is also expensive if we're
-// worrying about those levels of performance in the first place.
+// Error is the error of a call to a backend, telling which one failed.
+// Renders, Infos and Finds return the errors of their backends as Error.
+type Error struct {
+	Backend string
+	Err     error
+}
+
+func (err Error) Error() string {
+	return err.Backend + ": " + err.Err.Error()
+}
+
+func (err Error) Unwrap() error {
+	return err.Err
+}
+
+// The absent points of the metrics are a bitset, types.Absence, rather than
+// NaN values: doing math on NaN is expensive, and assuming that all functions
+// treat a default value intelligently is wrong (see multiplication), so the
+// math needs an absence check anyway.
 
 // Renders makes Render calls to multiple backends.
 // replicaMatchMode indicates how data points of the metrics fetched from replicas
@@ -57,15 +76,27 @@ func Renders(
 
 	msgCh := make(chan []types.Metric, len(backends))
 	errCh := make(chan error, len(backends))
+	var sanitizedMu sync.Mutex
+	sanitized := make(map[string]types.SanitizeStats)
 	for _, backend := range backends {
 		request.IncCall()
 		go func(b Backend) {
 			msg, err := b.Render(ctx, request)
 			if err != nil {
-				errCh <- err
-			} else {
-				msgCh <- msg
+				errCh <- Error{Backend: b.GetServerAddress(), Err: err}
+				return
 			}
+
+			if replicaMismatchConfig.RenderSanitize {
+				var stats types.SanitizeStats
+				msg, stats = types.SanitizeMetrics(msg, replicaMismatchConfig.RenderDuplicatePolicy)
+				if stats != (types.SanitizeStats{}) {
+					sanitizedMu.Lock()
+					sanitized[b.GetServerAddress()] = stats
+					sanitizedMu.Unlock()
+				}
+			}
+			msgCh <- msg
 		}(backend)
 	}
 
@@ -80,7 +111,12 @@ func Renders(
 		}
 	}
 
+	t0 := time.Now()
 	metrics, stats := types.MergeMetrics(msgs, replicaMismatchConfig, logger)
+	request.Trace.AddMerge(t0)
+	if len(sanitized) > 0 {
+		stats.Sanitized = sanitized
+	}
 	return metrics, stats, errs
 }
 
@@ -97,7 +133,7 @@ func Infos(ctx context.Context, backends []Backend, request types.InfoRequest) (
 		go func(b Backend) {
 			msg, err := b.Info(ctx, request)
 			if err != nil {
-				errCh <- err
+				errCh <- Error{Backend: b.GetServerAddress(), Err: err}
 			} else {
 				msgCh <- msg
 			}
@@ -131,7 +167,7 @@ func Finds(ctx context.Context, backends []Backend, request types.FindRequest) (
 		go func(b Backend) {
 			msg, err := b.Find(ctx, request)
 			if err != nil {
-				errCh <- err
+				errCh <- Error{Backend: b.GetServerAddress(), Err: err}
 			} else {
 				msgCh <- msg
 			}
@@ -93,7 +93,7 @@ func TestCarbonapiv2Renders(t *testing.T) {
 					StartTime: 0,
 					StopTime:  5,
 					Values:    []float64{0, 1, 2, 3, 4, 5},
-					IsAbsent:  []bool{false, false, false, false, false, false},
+					IsAbsent:  types.AbsenceOf(false, false, false, false, false, false),
 					StepTime:  1,
 				},
 			}, nil
@@ -134,6 +134,42 @@ func TestCarbonapiv2Renders(t *testing.T) {
 	}
 }
 
+func TestRendersSanitize(t *testing.T) {
+	render := func(context.Context, types.RenderRequest) ([]types.Metric, error) {
+		foo := types.Metric{
+			Name:      "foo",
+			StartTime: 0,
+			StopTime:  2,
+			Values:    []float64{0, 1},
+			IsAbsent:  types.AbsenceOf(false, false),
+			StepTime:  1,
+		}
+		return []types.Metric{foo, foo}, nil
+	}
+	backends := []Backend{
+		mock.New(mock.Config{Render: render, Address: "a"}),
+		mock.New(mock.Config{Render: render, Address: "b"}),
+	}
+
+	got, stats, errs := Renders(context.Background(), backends, types.NewRenderRequest(nil, 0, 1), cfg.RenderReplicaMismatchConfig{
+		RenderReplicaMatchMode: cfg.ReplicaMatchModeNormal,
+		RenderSanitize:         true,
+		RenderDuplicatePolicy:  cfg.DuplicatePolicyFirst,
+	}, zap.NewNop())
+	if len(errs) != 0 {
+		t.Fatal(errs[0])
+	}
+
+	if len(got) != 1 {
+		t.Errorf("Expected the duplicates to be merged, got %+v", got)
+	}
+	for _, b := range []string{"a", "b"} {
+		if s := stats.Sanitized[b]; s.Duplicates != 2 {
+			t.Errorf("Expected 2 duplicate points of backend %s, got %+v", b, s)
+		}
+	}
+}
+
 func TestCarbonapiv2RendersError(t *testing.T) {
 	render := func(context.Context, types.RenderRequest) ([]types.Metric, error) {
 		return nil, errors.New("No")
@@ -0,0 +1,279 @@
+package rrd
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// The sizes of the structures of rrd_format.h as rrdtool lays them out on
+// 64-bit platforms, the only ones supported: RRD files are written in the
+// native layout of the host and are not portable.
+const (
+	statHeadSize  = 128
+	dsDefSize     = 120
+	rraDefSize    = 120
+	pdpPrepSize   = 112
+	cdpPrepSize   = 80
+	rraPtrSize    = 8
+	valueSize     = 8
+	maxDataSource = 4096
+)
+
+// floatCookie is written in the header to check the float format.
+const floatCookie = 8.642135e130
+
+var errLayout = errors.New("not an RRD file of a 64-bit little-endian host")
+
+type rraDef struct {
+	cf           string
+	rows         int64
+	pdpPerRow    int64
+	xFilesFactor float64
+
+	offset int64 // of the data
+	curRow int64
+}
+
+// rrdFile is an open RRD file and its header.
+type rrdFile struct {
+	f *os.File
+
+	pdpStep    int64
+	lastUpdate int64
+	dataSource []string
+	archives   []rraDef
+}
+
+func openRRD(path string) (*rrdFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &rrdFile{f: f}
+	if err := r.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return r, nil
+}
+
+func (r *rrdFile) Close() error {
+	return r.f.Close()
+}
+
+func cString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+	return string(b)
+}
+
+func (r *rrdFile) readHeader() error {
+	le := binary.LittleEndian
+
+	head := make([]byte, statHeadSize)
+	if _, err := io.ReadFull(r.f, head); err != nil {
+		return err
+	}
+	if cString(head[0:4]) != "RRD" {
+		return errors.New("not an RRD file")
+	}
+	version := cString(head[4:9])
+	if version < "0001" || version > "0004" {
+		return fmt.Errorf("unsupported RRD version %q", version)
+	}
+	if math.Float64frombits(le.Uint64(head[16:24])) != floatCookie {
+		return errLayout
+	}
+	dsCount := int64(le.Uint64(head[24:32]))
+	rraCount := int64(le.Uint64(head[32:40]))
+	r.pdpStep = int64(le.Uint64(head[40:48]))
+	if dsCount <= 0 || dsCount > maxDataSource || rraCount <= 0 || rraCount > maxDataSource || r.pdpStep <= 0 {
+		return errLayout
+	}
+
+	defs := make([]byte, dsCount*dsDefSize+rraCount*rraDefSize)
+	if _, err := io.ReadFull(r.f, defs); err != nil {
+		return err
+	}
+	for i := int64(0); i < dsCount; i++ {
+		r.dataSource = append(r.dataSource, cString(defs[i*dsDefSize:i*dsDefSize+20]))
+	}
+	for i := int64(0); i < rraCount; i++ {
+		b := defs[dsCount*dsDefSize+i*rraDefSize:]
+		a := rraDef{
+			cf:           cString(b[0:20]),
+			rows:         int64(le.Uint64(b[24:32])),
+			pdpPerRow:    int64(le.Uint64(b[32:40])),
+			xFilesFactor: math.Float64frombits(le.Uint64(b[40:48])),
+		}
+		if a.rows <= 0 || a.pdpPerRow <= 0 {
+			return errLayout
+		}
+		r.archives = append(r.archives, a)
+	}
+
+	// Versions before 0003 have no microseconds of the last update.
+	liveHeadSize := int64(16)
+	if version < "0003" {
+		liveHeadSize = 8
+	}
+	rest := make([]byte, liveHeadSize+dsCount*pdpPrepSize+rraCount*dsCount*cdpPrepSize+rraCount*rraPtrSize)
+	if _, err := io.ReadFull(r.f, rest); err != nil {
+		return err
+	}
+	r.lastUpdate = int64(le.Uint64(rest[0:8]))
+
+	ptrs := rest[len(rest)-int(rraCount*rraPtrSize):]
+	offset := int64(statHeadSize) + int64(len(defs)) + int64(len(rest))
+	for i := range r.archives {
+		r.archives[i].curRow = int64(le.Uint64(ptrs[i*rraPtrSize:]))
+		if r.archives[i].curRow >= r.archives[i].rows {
+			return errLayout
+		}
+		r.archives[i].offset = offset
+		offset += r.archives[i].rows * dsCount * valueSize
+	}
+
+	return nil
+}
+
+func (r *rrdFile) step(a rraDef) int64 {
+	return r.pdpStep * a.pdpPerRow
+}
+
+// end is the time of the last row of a.
+func (r *rrdFile) end(a rraDef) int64 {
+	step := r.step(a)
+	return r.lastUpdate - r.lastUpdate%step
+}
+
+// averages returns the archives that consolidate by average, which graphite
+// reads, or all of them if there are none.
+func (r *rrdFile) averages() []rraDef {
+	var averages []rraDef
+	for _, a := range r.archives {
+		if a.cf == "AVERAGE" {
+			averages = append(averages, a)
+		}
+	}
+	if len(averages) == 0 {
+		return r.archives
+	}
+
+	return averages
+}
+
+// info describes a data source of the file as the info endpoint does.
+func (r *rrdFile) info(host, name string) types.Info {
+	info := types.Info{
+		Host:              host,
+		Name:              name,
+		AggregationMethod: "average",
+	}
+	for _, a := range r.averages() {
+		info.Retentions = append(info.Retentions, types.Retention{
+			SecondsPerPoint: int32(r.step(a)),
+			NumberOfPoints:  int32(a.rows),
+		})
+		if retention := int32(r.step(a) * a.rows); retention > info.MaxRetention {
+			info.MaxRetention = retention
+		}
+		info.XFilesFactor = float32(a.xFilesFactor)
+	}
+
+	return info
+}
+
+// archive returns the most precise archive that has from, or else the one
+// that goes back the furthest.
+func (r *rrdFile) archive(from int64) rraDef {
+	start := func(a rraDef) int64 {
+		return r.end(a) - a.rows*r.step(a)
+	}
+
+	averages := r.averages()
+	best := averages[0]
+	for _, a := range averages[1:] {
+		covers, bestCovers := start(a) <= from, start(best) <= from
+		if covers && (!bestCovers || r.step(a) < r.step(best)) ||
+			!covers && !bestCovers && start(a) < start(best) {
+			best = a
+		}
+	}
+
+	return best
+}
+
+// fetch reads the points of the data source ds between from and until, within
+// the rows of the archive. Rows are stamped with the start of their interval,
+// as graphite-web does. It returns false if the data source is missing or the
+// range is out of the archive.
+func (r *rrdFile) fetch(name, ds string, from, until int64) (types.Metric, bool, error) {
+	index := -1
+	for i, d := range r.dataSource {
+		if d == ds {
+			index = i
+			break
+		}
+	}
+	if index < 0 {
+		return types.Metric{}, false, nil
+	}
+
+	archive := r.archive(from)
+	step := r.step(archive)
+	end := r.end(archive)
+	firstEnd := from - mod(from, step) + step
+	if oldest := end - (archive.rows-1)*step; firstEnd < oldest {
+		firstEnd = oldest
+	}
+	lastEnd := until - mod(until, step)
+	if lastEnd > end {
+		lastEnd = end
+	}
+	if lastEnd < firstEnd {
+		return types.Metric{}, false, nil
+	}
+	count := (lastEnd-firstEnd)/step + 1
+
+	metric := types.Metric{
+		Name:      name,
+		StartTime: int32(firstEnd - step),
+		StopTime:  int32(lastEnd),
+		StepTime:  int32(step),
+		Values:    types.GetValues(int(count)),
+		IsAbsent:  types.NewAbsence(int(count)),
+	}
+
+	rows := make([]byte, archive.rows*int64(len(r.dataSource))*valueSize)
+	if _, err := r.f.ReadAt(rows, archive.offset); err != nil {
+		return metric, false, err
+	}
+
+	for i := int64(0); i < count; i++ {
+		age := (end-firstEnd)/step - i
+		row := mod(archive.curRow-age, archive.rows)
+		v := math.Float64frombits(binary.LittleEndian.Uint64(rows[(row*int64(len(r.dataSource))+int64(index))*valueSize:]))
+		if math.IsNaN(v) {
+			metric.IsAbsent.Set(int(i), true)
+			continue
+		}
+		metric.Values[i] = v
+	}
+
+	return metric, true, nil
+}
+
+func mod(a, b int64) int64 {
+	return ((a % b) + b) % b
+}
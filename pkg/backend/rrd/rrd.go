@@ -0,0 +1,279 @@
+// Package rrd implements a read-only backend for the RRD files of rrdtool, as
+// written by Munin and collectd, to serve old data through the graphite API.
+package rrd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/backend/glob"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+// Scheme is the address scheme that selects the RRD backend, as in
+// "rrd:///var/lib/munin".
+const Scheme = "rrd"
+
+const extension = ".rrd"
+
+// Backend reads the RRD files under a directory. Like in graphite-web, the
+// file a/b/c.rrd is the node a.b.c, and each of its data sources is a metric
+// under it: a.b.c.value.
+type Backend struct {
+	root    string
+	address string
+	logger  *zap.Logger
+}
+
+// Config configures an RRD backend.
+//
+// The only required field is Address, which must be of the form
+// "rrd://path", where path is the root directory of the RRD files.
+type Config struct {
+	Address string      // The backend address.
+	Logger  *zap.Logger // Logger to use. Defaults to a no-op logger.
+}
+
+// New creates a new backend from the given configuration.
+func New(cfg Config) (*Backend, error) {
+	root := strings.TrimPrefix(cfg.Address, Scheme+"://")
+	if root == "" {
+		return nil, errors.New("empty RRD directory")
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	b := &Backend{
+		root:    root,
+		address: cfg.Address,
+		logger:  zap.New(nil),
+	}
+	if cfg.Logger != nil {
+		b.logger = cfg.Logger
+	}
+
+	return b, nil
+}
+
+// GetServerAddress returns the address of the backend.
+func (b Backend) GetServerAddress() string {
+	return b.address
+}
+
+// Logger returns the logger of the backend.
+func (b Backend) Logger() *zap.Logger {
+	return b.logger
+}
+
+// Contains reports whether any of the targets is a glob or has a file.
+func (b Backend) Contains(targets []string) bool {
+	for _, target := range targets {
+		if glob.IsGlob(target) {
+			return true
+		}
+		i := strings.LastIndexByte(target, '.')
+		if i < 0 || strings.Contains(target, "/") {
+			continue
+		}
+		if _, err := os.Stat(b.file(target[:i])); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b Backend) file(node string) string {
+	return filepath.Join(b.root, filepath.FromSlash(strings.Replace(node, ".", "/", -1))) + extension
+}
+
+func (b Backend) nodeFile(n node) string {
+	return filepath.Join(b.root, filepath.FromSlash(n.path)) + extension
+}
+
+// node is a directory, an RRD file or a data source in one.
+type node struct {
+	path string // with slashes
+	file bool
+	ds   string
+}
+
+func (n node) name() string {
+	name := strings.Replace(n.path, "/", ".", -1)
+	if n.ds != "" {
+		name += "." + n.ds
+	}
+	return name
+}
+
+// glob returns the nodes matching query.
+func (b Backend) glob(query string) ([]node, error) {
+	nodes := []node{{}}
+	for _, segment := range strings.Split(query, ".") {
+		var next []node
+		for _, n := range nodes {
+			children, err := b.children(n, segment)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, children...)
+		}
+		nodes = next
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].name() < nodes[j].name()
+	})
+
+	return nodes, nil
+}
+
+// children returns the children of n matching the segment.
+func (b Backend) children(n node, segment string) ([]node, error) {
+	var children []node
+
+	switch {
+	case n.ds != "":
+	case n.file:
+		r, err := openRRD(b.nodeFile(n))
+		if err != nil {
+			b.logger.Warn("skipping unreadable RRD file", zap.Error(err))
+			return nil, nil
+		}
+		for _, ds := range r.dataSource {
+			if glob.Match(segment, ds) {
+				children = append(children, node{path: n.path, file: true, ds: ds})
+			}
+		}
+		r.Close()
+	default:
+		entries, err := os.ReadDir(filepath.Join(b.root, filepath.FromSlash(n.path)))
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil, nil
+			}
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			file := !entry.IsDir()
+			if file {
+				if !strings.HasSuffix(name, extension) {
+					continue
+				}
+				name = strings.TrimSuffix(name, extension)
+			}
+			// not addressable in graphite
+			if strings.Contains(name, ".") {
+				continue
+			}
+			if glob.Match(segment, name) {
+				children = append(children, node{path: path.Join(n.path, name), file: file})
+			}
+		}
+	}
+
+	return children, nil
+}
+
+// Find resolves globs and finds metrics in the directory.
+func (b Backend) Find(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+	matches := types.Matches{Name: request.Query}
+
+	nodes, err := b.glob(request.Query)
+	if err != nil {
+		return matches, err
+	}
+	for _, n := range nodes {
+		matches.Matches = append(matches.Matches, types.Match{
+			Path:   n.name(),
+			IsLeaf: n.ds != "",
+		})
+	}
+	if len(matches.Matches) == 0 {
+		return matches, types.ErrMatchesNotFound
+	}
+
+	return matches, nil
+}
+
+// Info returns the retentions of the metrics of the target.
+func (b Backend) Info(ctx context.Context, request types.InfoRequest) ([]types.Info, error) {
+	nodes, err := b.glob(request.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []types.Info
+	for _, n := range nodes {
+		if n.ds == "" {
+			continue
+		}
+		r, err := openRRD(b.nodeFile(n))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, r.info(b.address, n.name()))
+		r.Close()
+	}
+	if len(infos) == 0 {
+		return nil, types.ErrInfoNotFound
+	}
+
+	return infos, nil
+}
+
+// Render reads the datapoints of the metrics of the targets.
+func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+	var metrics []types.Metric
+	for _, target := range request.Targets {
+		nodes, err := b.glob(target)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, n := range nodes {
+			if n.ds == "" {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			metric, ok, err := b.fetch(n, request.From, request.Until)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	if len(metrics) == 0 {
+		return nil, types.ErrMetricsNotFound
+	}
+
+	return metrics, nil
+}
+
+func (b Backend) fetch(n node, from, until int64) (types.Metric, bool, error) {
+	r, err := openRRD(b.nodeFile(n))
+	if err != nil {
+		return types.Metric{}, false, err
+	}
+	defer r.Close()
+
+	return r.fetch(n.name(), n.ds, from, until)
+}
@@ -0,0 +1,237 @@
+package rrd
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+type testRRA struct {
+	cf        string
+	pdpPerRow uint64
+	curRow    uint64
+	rows      [][]float64 // by row, then data source
+}
+
+// writeRRD writes an RRD file in the layout of rrdtool on 64-bit
+// little-endian hosts.
+func writeRRD(t *testing.T, path string, step, lastUpdate uint64, dataSources []string, rras []testRRA) {
+	t.Helper()
+
+	le := binary.LittleEndian
+	var buf []byte
+	put := func(size int, fill func(b []byte)) {
+		b := make([]byte, size)
+		fill(b)
+		buf = append(buf, b...)
+	}
+
+	put(statHeadSize, func(b []byte) {
+		copy(b, "RRD\x00")
+		copy(b[4:], "0003\x00")
+		le.PutUint64(b[16:], math.Float64bits(floatCookie))
+		le.PutUint64(b[24:], uint64(len(dataSources)))
+		le.PutUint64(b[32:], uint64(len(rras)))
+		le.PutUint64(b[40:], step)
+	})
+	for _, ds := range dataSources {
+		put(dsDefSize, func(b []byte) {
+			copy(b, ds)
+			copy(b[20:], "GAUGE")
+		})
+	}
+	for _, rra := range rras {
+		put(rraDefSize, func(b []byte) {
+			copy(b, rra.cf)
+			le.PutUint64(b[24:], uint64(len(rra.rows)))
+			le.PutUint64(b[32:], rra.pdpPerRow)
+			le.PutUint64(b[40:], math.Float64bits(0.5))
+		})
+	}
+	put(16, func(b []byte) { le.PutUint64(b, lastUpdate) })
+	put(len(dataSources)*pdpPrepSize+len(rras)*len(dataSources)*cdpPrepSize, func([]byte) {})
+	for _, rra := range rras {
+		put(rraPtrSize, func(b []byte) { le.PutUint64(b, rra.curRow) })
+	}
+	for _, rra := range rras {
+		for _, row := range rra.rows {
+			for _, v := range row {
+				put(valueSize, func(b []byte) { le.PutUint64(b, math.Float64bits(v)) })
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, buf, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestBackend(t *testing.T) *Backend {
+	t.Helper()
+
+	nan := math.NaN()
+	root := t.TempDir()
+	// The last row, 1200, is at index 1: rows hold 1140, 1200, 1020, 1080.
+	writeRRD(t, filepath.Join(root, "host", "cpu.rrd"), 60, 1210, []string{"user", "system"}, []testRRA{
+		{cf: "AVERAGE", pdpPerRow: 1, curRow: 1, rows: [][]float64{{3, 30}, {4, 40}, {1, 10}, {2, nan}}},
+		{cf: "MAX", pdpPerRow: 1, curRow: 0, rows: [][]float64{{9, 9}, {9, 9}, {9, 9}, {9, 9}}},
+		{cf: "AVERAGE", pdpPerRow: 5, curRow: 0, rows: [][]float64{{5, 50}, {nan, nan}}},
+	})
+	writeRRD(t, filepath.Join(root, "host", "load.rrd"), 60, 1210, []string{"load"}, []testRRA{
+		{cf: "AVERAGE", pdpPerRow: 1, curRow: 0, rows: [][]float64{{1}}},
+	})
+	if err := os.MkdirAll(filepath.Join(root, "other"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := New(Config{Address: Scheme + "://" + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return b
+}
+
+func TestFind(t *testing.T) {
+	b := newTestBackend(t)
+
+	for query, want := range map[string][]types.Match{
+		"*": {
+			{Path: "host", IsLeaf: false},
+			{Path: "other", IsLeaf: false},
+		},
+		"host.*": {
+			{Path: "host.cpu", IsLeaf: false},
+			{Path: "host.load", IsLeaf: false},
+		},
+		"host.cpu.*": {
+			{Path: "host.cpu.system", IsLeaf: true},
+			{Path: "host.cpu.user", IsLeaf: true},
+		},
+		"host.{cpu,load}.{user,load}": {
+			{Path: "host.cpu.user", IsLeaf: true},
+			{Path: "host.load.load", IsLeaf: true},
+		},
+	} {
+		got, err := b.Find(context.Background(), types.NewFindRequest(query))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if !reflect.DeepEqual(got.Matches, want) {
+			t.Errorf("%s: expected %v, got %v", query, want, got.Matches)
+		}
+	}
+
+	if _, err := b.Find(context.Background(), types.NewFindRequest("host.cpu.idle")); err != types.ErrMatchesNotFound {
+		t.Errorf("expected %v, got %v", types.ErrMatchesNotFound, err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	b := newTestBackend(t)
+
+	got, err := b.Render(context.Background(), types.NewRenderRequest([]string{"host.cpu.*"}, 1000, 1300))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.Metric{
+		{
+			Name:      "host.cpu.system",
+			StartTime: 960,
+			StopTime:  1200,
+			StepTime:  60,
+			Values:    []float64{10, 0, 30, 40},
+			IsAbsent:  types.AbsenceOf(false, true, false, false),
+		},
+		{
+			Name:      "host.cpu.user",
+			StartTime: 960,
+			StopTime:  1200,
+			StepTime:  60,
+			Values:    []float64{1, 2, 3, 4},
+			IsAbsent:  types.AbsenceOf(false, false, false, false),
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestRenderCoarseArchive(t *testing.T) {
+	b := newTestBackend(t)
+
+	// Older than the 4 minutes of the first archive, so read from the second,
+	// whose rows of 5 minutes end at 900 and 1200.
+	got, err := b.Render(context.Background(), types.NewRenderRequest([]string{"host.cpu.user"}, 500, 1300))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := types.Metric{
+		Name:      "host.cpu.user",
+		StartTime: 600,
+		StopTime:  1200,
+		StepTime:  300,
+		Values:    []float64{0, 5},
+		IsAbsent:  types.AbsenceOf(true, false),
+	}
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("expected %+v, got %+v", want, got[0])
+	}
+}
+
+func TestInfo(t *testing.T) {
+	b := newTestBackend(t)
+
+	got, err := b.Info(context.Background(), types.NewInfoRequest("host.cpu.user"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.Info{{
+		Host:              b.GetServerAddress(),
+		Name:              "host.cpu.user",
+		AggregationMethod: "average",
+		MaxRetention:      600,
+		XFilesFactor:      0.5,
+		Retentions: []types.Retention{
+			{SecondsPerPoint: 60, NumberOfPoints: 4},
+			{SecondsPerPoint: 300, NumberOfPoints: 2},
+		},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestOpenRejectsOtherFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "x.rrd")
+	if err := ioutil.WriteFile(path, make([]byte, statHeadSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openRRD(path); err == nil {
+		t.Error("expected an error for a file that is not an RRD")
+	}
+}
+
+func TestContains(t *testing.T) {
+	b := newTestBackend(t)
+
+	if !b.Contains([]string{"host.cpu.user"}) || !b.Contains([]string{"x.*"}) {
+		t.Error("expected the backend to contain existing metrics and globs")
+	}
+	if b.Contains([]string{"host.mem.free"}) {
+		t.Error("expected the backend not to contain a missing metric")
+	}
+}
@@ -0,0 +1,214 @@
+package whisper
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+const (
+	metadataSize    = 16
+	archiveInfoSize = 12
+	pointSize       = 12
+)
+
+// aggregationMethods are the names of the aggregation types of whisper,
+// which numbers them from 1.
+var aggregationMethods = []string{"", "average", "sum", "last", "max", "min", "avg_zero", "absmax", "absmin"}
+
+type archiveInfo struct {
+	offset          int64
+	secondsPerPoint int64
+	points          int64
+}
+
+func (a archiveInfo) retention() int64 {
+	return a.secondsPerPoint * a.points
+}
+
+// whisperFile is an open whisper file and its header.
+type whisperFile struct {
+	f *os.File
+
+	aggregation  uint32
+	maxRetention int64
+	xFilesFactor float32
+	archives     []archiveInfo
+}
+
+func openWhisper(path string) (*whisperFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &whisperFile{f: f}
+	if err := w.readHeader(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	return w, nil
+}
+
+func (w *whisperFile) Close() error {
+	return w.f.Close()
+}
+
+func (w *whisperFile) readHeader() error {
+	metadata := make([]byte, metadataSize)
+	if _, err := io.ReadFull(w.f, metadata); err != nil {
+		return err
+	}
+	w.aggregation = binary.BigEndian.Uint32(metadata[0:4])
+	w.maxRetention = int64(binary.BigEndian.Uint32(metadata[4:8]))
+	w.xFilesFactor = math.Float32frombits(binary.BigEndian.Uint32(metadata[8:12]))
+	count := binary.BigEndian.Uint32(metadata[12:16])
+	if count == 0 || count > 64 {
+		return fmt.Errorf("invalid number of archives %d", count)
+	}
+
+	infos := make([]byte, archiveInfoSize*int(count))
+	if _, err := io.ReadFull(w.f, infos); err != nil {
+		return err
+	}
+	for i := 0; i < int(count); i++ {
+		b := infos[i*archiveInfoSize:]
+		a := archiveInfo{
+			offset:          int64(binary.BigEndian.Uint32(b[0:4])),
+			secondsPerPoint: int64(binary.BigEndian.Uint32(b[4:8])),
+			points:          int64(binary.BigEndian.Uint32(b[8:12])),
+		}
+		if a.secondsPerPoint == 0 || a.points == 0 {
+			return errors.New("invalid archive")
+		}
+		w.archives = append(w.archives, a)
+	}
+
+	return nil
+}
+
+func (w *whisperFile) aggregationMethod() string {
+	if int(w.aggregation) < len(aggregationMethods) {
+		return aggregationMethods[w.aggregation]
+	}
+	return ""
+}
+
+// info describes the file as the info endpoint does.
+func (w *whisperFile) info(host, name string) types.Info {
+	info := types.Info{
+		Host:              host,
+		Name:              name,
+		AggregationMethod: w.aggregationMethod(),
+		MaxRetention:      int32(w.maxRetention),
+		XFilesFactor:      w.xFilesFactor,
+	}
+	for _, a := range w.archives {
+		info.Retentions = append(info.Retentions, types.Retention{
+			SecondsPerPoint: int32(a.secondsPerPoint),
+			NumberOfPoints:  int32(a.points),
+		})
+	}
+
+	return info
+}
+
+// fetch reads the points between from and until from the most precise archive
+// that retains from, like whisper.fetch of graphite. It returns false if the
+// range is out of the retention of the file.
+func (w *whisperFile) fetch(name string, from, until, now int64) (types.Metric, bool, error) {
+	if until > now {
+		until = now
+	}
+	if from < now-w.maxRetention {
+		from = now - w.maxRetention
+	}
+	if from >= until {
+		return types.Metric{}, false, nil
+	}
+
+	archive := w.archives[len(w.archives)-1]
+	for _, a := range w.archives {
+		if a.retention() >= now-from {
+			archive = a
+			break
+		}
+	}
+
+	step := archive.secondsPerPoint
+	fromInterval := from - mod(from, step) + step
+	untilInterval := until - mod(until, step) + step
+	if fromInterval == untilInterval {
+		untilInterval += step
+	}
+	count := (untilInterval - fromInterval) / step
+
+	metric := types.Metric{
+		Name:      name,
+		StartTime: int32(fromInterval),
+		StopTime:  int32(untilInterval),
+		StepTime:  int32(step),
+		Values:    types.GetValues(int(count)),
+		IsAbsent:  types.AllAbsent(int(count)),
+	}
+
+	buf := make([]byte, pointSize)
+	if _, err := w.f.ReadAt(buf, archive.offset); err != nil {
+		return metric, false, err
+	}
+	baseInterval := int64(binary.BigEndian.Uint32(buf[0:4]))
+	if baseInterval == 0 {
+		// nothing was ever written to the archive
+		return metric, true, nil
+	}
+
+	points, err := w.readPoints(archive, mod((fromInterval-baseInterval)/step, archive.points), count)
+	if err != nil {
+		return metric, false, err
+	}
+	for i := int64(0); i < int64(len(points))/pointSize; i++ {
+		b := points[i*pointSize:]
+		if int64(binary.BigEndian.Uint32(b[0:4])) != fromInterval+i*step {
+			continue
+		}
+		metric.Values[i] = math.Float64frombits(binary.BigEndian.Uint64(b[4:12]))
+		metric.IsAbsent.Set(int(i), false)
+	}
+
+	return metric, true, nil
+}
+
+// readPoints reads count points of archive from the index start on, wrapping
+// around the end of the archive.
+func (w *whisperFile) readPoints(archive archiveInfo, start, count int64) ([]byte, error) {
+	if count > archive.points {
+		count = archive.points
+	}
+	buf := make([]byte, count*pointSize)
+
+	first := count
+	if start+count > archive.points {
+		first = archive.points - start
+	}
+	if _, err := w.f.ReadAt(buf[:first*pointSize], archive.offset+start*pointSize); err != nil {
+		return nil, err
+	}
+	if first < count {
+		if _, err := w.f.ReadAt(buf[first*pointSize:], archive.offset); err != nil {
+			return nil, err
+		}
+	}
+
+	return buf, nil
+}
+
+// mod is the modulo of Python, which whisper is written in: never negative.
+func mod(a, b int64) int64 {
+	return ((a % b) + b) % b
+}
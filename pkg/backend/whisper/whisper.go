@@ -0,0 +1,248 @@
+// Package whisper implements a backend that reads whisper files from a local
+// directory, for small sites that run without carbonserver.
+package whisper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/backend/glob"
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"go.uber.org/zap"
+)
+
+// Scheme is the address scheme that selects the whisper backend, as in
+// "whisper:///var/lib/graphite/whisper".
+const Scheme = "whisper"
+
+const extension = ".wsp"
+
+// Backend reads the whisper files under a directory, laid out like carbon
+// does: the metric a.b.c is in a/b/c.wsp.
+type Backend struct {
+	root    string
+	address string
+	logger  *zap.Logger
+	now     func() time.Time
+}
+
+// Config configures a whisper backend.
+//
+// The only required field is Address, which must be of the form
+// "whisper://path", where path is the root directory of the whisper files.
+type Config struct {
+	Address string      // The backend address.
+	Logger  *zap.Logger // Logger to use. Defaults to a no-op logger.
+}
+
+// New creates a new backend from the given configuration.
+func New(cfg Config) (*Backend, error) {
+	root := strings.TrimPrefix(cfg.Address, Scheme+"://")
+	if root == "" {
+		return nil, errors.New("empty whisper directory")
+	}
+	fi, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	b := &Backend{
+		root:    root,
+		address: cfg.Address,
+		logger:  zap.New(nil),
+		now:     time.Now,
+	}
+	if cfg.Logger != nil {
+		b.logger = cfg.Logger
+	}
+
+	return b, nil
+}
+
+// GetServerAddress returns the address of the backend.
+func (b Backend) GetServerAddress() string {
+	return b.address
+}
+
+// Logger returns the logger of the backend.
+func (b Backend) Logger() *zap.Logger {
+	return b.logger
+}
+
+// Contains reports whether any of the targets is a glob or has a file.
+func (b Backend) Contains(targets []string) bool {
+	for _, target := range targets {
+		if glob.IsGlob(target) {
+			return true
+		}
+		if strings.Contains(target, "/") {
+			continue
+		}
+		if _, err := os.Stat(b.file(target)); err == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (b Backend) file(metric string) string {
+	return filepath.Join(b.root, filepath.FromSlash(strings.Replace(metric, ".", "/", -1))) + extension
+}
+
+// Find resolves globs and finds metrics in the directory.
+func (b Backend) Find(ctx context.Context, request types.FindRequest) (types.Matches, error) {
+	matches := types.Matches{Name: request.Query}
+
+	found, err := b.glob(request.Query)
+	if err != nil {
+		return matches, err
+	}
+	if len(found) == 0 {
+		return matches, types.ErrMatchesNotFound
+	}
+	matches.Matches = found
+
+	return matches, nil
+}
+
+// glob returns the metrics and the directories matching query.
+func (b Backend) glob(query string) ([]types.Match, error) {
+	segments := strings.Split(query, ".")
+	prefixes := []string{""}
+	for i, segment := range segments {
+		last := i == len(segments)-1
+
+		var next []string
+		var matches []types.Match
+		for _, prefix := range prefixes {
+			entries, err := os.ReadDir(filepath.Join(b.root, filepath.FromSlash(prefix)))
+			if err != nil {
+				if os.IsNotExist(err) {
+					continue
+				}
+				return nil, err
+			}
+
+			for _, entry := range entries {
+				name := entry.Name()
+				isLeaf := !entry.IsDir()
+				if isLeaf {
+					if !last || !strings.HasSuffix(name, extension) {
+						continue
+					}
+					name = strings.TrimSuffix(name, extension)
+				}
+				// not addressable in graphite
+				if strings.Contains(name, ".") {
+					continue
+				}
+				if !glob.Match(segment, name) {
+					continue
+				}
+
+				p := path.Join(prefix, name)
+				if last {
+					matches = append(matches, types.Match{
+						Path:   strings.Replace(p, "/", ".", -1),
+						IsLeaf: isLeaf,
+					})
+				} else {
+					next = append(next, p)
+				}
+			}
+		}
+
+		if last {
+			sort.Slice(matches, func(i, j int) bool {
+				return matches[i].Path < matches[j].Path
+			})
+			return matches, nil
+		}
+		prefixes = next
+	}
+
+	return nil, nil
+}
+
+// Info returns the retentions of the metrics of the target.
+func (b Backend) Info(ctx context.Context, request types.InfoRequest) ([]types.Info, error) {
+	found, err := b.glob(request.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []types.Info
+	for _, m := range found {
+		if !m.IsLeaf {
+			continue
+		}
+		w, err := openWhisper(b.file(m.Path))
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, w.info(b.address, m.Path))
+		w.Close()
+	}
+	if len(infos) == 0 {
+		return nil, types.ErrInfoNotFound
+	}
+
+	return infos, nil
+}
+
+// Render reads the datapoints of the metrics of the targets.
+func (b Backend) Render(ctx context.Context, request types.RenderRequest) ([]types.Metric, error) {
+	now := b.now().Unix()
+
+	var metrics []types.Metric
+	for _, target := range request.Targets {
+		found, err := b.glob(target)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, m := range found {
+			if !m.IsLeaf {
+				continue
+			}
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			metric, ok, err := b.fetch(m.Path, request.From, request.Until, now)
+			if err != nil {
+				return nil, err
+			}
+			if ok {
+				metrics = append(metrics, metric)
+			}
+		}
+	}
+	if len(metrics) == 0 {
+		return nil, types.ErrMetricsNotFound
+	}
+
+	return metrics, nil
+}
+
+func (b Backend) fetch(name string, from, until, now int64) (types.Metric, bool, error) {
+	w, err := openWhisper(b.file(name))
+	if err != nil {
+		return types.Metric{}, false, err
+	}
+	defer w.Close()
+
+	return w.fetch(name, from, until, now)
+}
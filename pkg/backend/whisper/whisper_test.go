@@ -0,0 +1,196 @@
+package whisper
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// writeWhisper writes a whisper file with a single archive of points of step
+// seconds, with value i+1 at each of the timestamps in the slots of the ring.
+func writeWhisper(t *testing.T, path string, step, points uint32, timestamps []uint32) {
+	t.Helper()
+
+	header := make([]byte, metadataSize+archiveInfoSize)
+	binary.BigEndian.PutUint32(header[0:4], 1)
+	binary.BigEndian.PutUint32(header[4:8], step*points)
+	binary.BigEndian.PutUint32(header[8:12], math.Float32bits(0.5))
+	binary.BigEndian.PutUint32(header[12:16], 1)
+	binary.BigEndian.PutUint32(header[16:20], uint32(len(header)))
+	binary.BigEndian.PutUint32(header[20:24], step)
+	binary.BigEndian.PutUint32(header[24:28], points)
+
+	data := make([]byte, points*pointSize)
+	if len(timestamps) > 0 {
+		base := timestamps[0]
+		for i, ts := range timestamps {
+			slot := ((ts - base) / step) % points
+			b := data[slot*pointSize:]
+			binary.BigEndian.PutUint32(b[0:4], ts)
+			binary.BigEndian.PutUint64(b[4:12], math.Float64bits(float64(i+1)))
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(path, append(header, data...), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestBackend(t *testing.T) *Backend {
+	t.Helper()
+
+	root := t.TempDir()
+	writeWhisper(t, filepath.Join(root, "a", "b", "c.wsp"), 60, 10, []uint32{1200, 1260, 1320})
+	writeWhisper(t, filepath.Join(root, "a", "b", "d.wsp"), 60, 10, nil)
+	writeWhisper(t, filepath.Join(root, "a", "e", "c.wsp"), 60, 10, []uint32{1200})
+	if err := os.MkdirAll(filepath.Join(root, "a", "f"), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := New(Config{Address: Scheme + "://" + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.now = func() time.Time { return time.Unix(1500, 0) }
+
+	return b
+}
+
+func TestNew(t *testing.T) {
+	if _, err := New(Config{Address: Scheme + "://" + filepath.Join(t.TempDir(), "missing")}); err == nil {
+		t.Error("expected an error for a missing directory")
+	}
+}
+
+func TestFind(t *testing.T) {
+	b := newTestBackend(t)
+
+	for query, want := range map[string][]types.Match{
+		"a.*": {
+			{Path: "a.b", IsLeaf: false},
+			{Path: "a.e", IsLeaf: false},
+			{Path: "a.f", IsLeaf: false},
+		},
+		"a.*.c": {
+			{Path: "a.b.c", IsLeaf: true},
+			{Path: "a.e.c", IsLeaf: true},
+		},
+		"a.b.{c,d}": {
+			{Path: "a.b.c", IsLeaf: true},
+			{Path: "a.b.d", IsLeaf: true},
+		},
+	} {
+		got, err := b.Find(context.Background(), types.NewFindRequest(query))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if got.Name != query || !reflect.DeepEqual(got.Matches, want) {
+			t.Errorf("%s: expected %v, got %v", query, want, got.Matches)
+		}
+	}
+
+	if _, err := b.Find(context.Background(), types.NewFindRequest("a.x.*")); err != types.ErrMatchesNotFound {
+		t.Errorf("expected %v, got %v", types.ErrMatchesNotFound, err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	b := newTestBackend(t)
+
+	got, err := b.Render(context.Background(), types.NewRenderRequest([]string{"a.b.c"}, 1139, 1379))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("expected 1 metric, got %d", len(got))
+	}
+
+	want := types.Metric{
+		Name:      "a.b.c",
+		StartTime: 1140,
+		StopTime:  1380,
+		StepTime:  60,
+		Values:    []float64{0, 1, 2, 3},
+		IsAbsent:  types.AbsenceOf(true, false, false, false),
+	}
+	if !reflect.DeepEqual(got[0], want) {
+		t.Errorf("expected %+v, got %+v", want, got[0])
+	}
+
+	if _, err := b.Render(context.Background(), types.NewRenderRequest([]string{"a.x"}, 1139, 1379)); err != types.ErrMetricsNotFound {
+		t.Errorf("expected %v, got %v", types.ErrMetricsNotFound, err)
+	}
+}
+
+func TestRenderWraparound(t *testing.T) {
+	root := t.TempDir()
+	// 12 points in a ring of 10 overwrite the first two slots.
+	var timestamps []uint32
+	for ts := uint32(600); ts < 600+12*60; ts += 60 {
+		timestamps = append(timestamps, ts)
+	}
+	writeWhisper(t, filepath.Join(root, "m.wsp"), 60, 10, timestamps)
+
+	b, err := New(Config{Address: Scheme + "://" + root})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.now = func() time.Time { return time.Unix(1320, 0) }
+
+	got, err := b.Render(context.Background(), types.NewRenderRequest([]string{"m"}, 719, 1319))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// from is clamped to the retention, 720, and starts at the next interval.
+	want := []float64{4, 5, 6, 7, 8, 9, 10, 11, 12}
+	if !reflect.DeepEqual(got[0].Values, want) {
+		t.Errorf("expected %v, got %v", want, got[0].Values)
+	}
+	if got[0].StartTime != 780 {
+		t.Errorf("expected start 780, got %d", got[0].StartTime)
+	}
+}
+
+func TestInfo(t *testing.T) {
+	b := newTestBackend(t)
+
+	got, err := b.Info(context.Background(), types.NewInfoRequest("a.b.c"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []types.Info{{
+		Host:              b.GetServerAddress(),
+		Name:              "a.b.c",
+		AggregationMethod: "average",
+		MaxRetention:      600,
+		XFilesFactor:      0.5,
+		Retentions:        []types.Retention{{SecondsPerPoint: 60, NumberOfPoints: 10}},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %+v, got %+v", want, got)
+	}
+}
+
+func TestContains(t *testing.T) {
+	b := newTestBackend(t)
+
+	if !b.Contains([]string{"a.b.c"}) || !b.Contains([]string{"x.*"}) {
+		t.Error("expected the backend to contain existing metrics and globs")
+	}
+	if b.Contains([]string{"a.b.x"}) {
+		t.Error("expected the backend not to contain a missing metric")
+	}
+}
@@ -0,0 +1,243 @@
+/*
+Package metrictree caches the metric tree, as browsed with finds.
+
+The tree is kept as the listings of the children of its nodes, found by finds
+of <node>.*, each for a TTL. Finds of globs are answered by walking the tree
+from the listings of the nodes they go through, so that browsing the tree,
+e.g. in the query editor of Grafana, lists each node from the backends once.
+*/
+package metrictree
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// listing is the children of a node.
+type listing struct {
+	matches []types.Match
+	expires time.Time
+}
+
+// Cache is a cache of the metric tree. It is safe for concurrent use.
+type Cache struct {
+	ttl        time.Duration
+	maxEntries int
+
+	mu sync.Mutex
+	// listings are keyed by the path of their node, "" for the root.
+	listings map[string]listing
+	entries  int
+
+	now func() time.Time
+}
+
+// New returns a cache that keeps listings for ttl, and at most maxEntries
+// children in all of them, 0 for no limit.
+func New(ttl time.Duration, maxEntries int) *Cache {
+	return &Cache{
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		listings:   make(map[string]listing),
+		now:        time.Now,
+	}
+}
+
+// isGlob reports whether node matches more than its own name.
+func isGlob(node string) bool {
+	return strings.ContainsAny(node, "*?[]{}")
+}
+
+// nodeMatcher returns whether names match the glob node.
+func nodeMatcher(node string) (func(string) bool, error) {
+	if !isGlob(node) {
+		return func(name string) bool { return name == node }, nil
+	}
+
+	var b strings.Builder
+	b.WriteString("^")
+	inBraces, inClass := false, false
+	for _, r := range node {
+		switch {
+		case inClass:
+			if r == ']' {
+				inClass = false
+			}
+			b.WriteRune(r)
+		case r == '*':
+			b.WriteString(".*")
+		case r == '?':
+			b.WriteString(".")
+		case r == '[':
+			inClass = true
+			b.WriteRune(r)
+		case r == '{':
+			inBraces = true
+			b.WriteString("(?:")
+		case r == '}' && inBraces:
+			inBraces = false
+			b.WriteString(")")
+		case r == ',' && inBraces:
+			b.WriteString("|")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, err
+	}
+
+	return re.MatchString, nil
+}
+
+func child(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// lastNode returns the name of the node of path.
+func lastNode(path string) string {
+	return path[strings.LastIndexByte(path, '.')+1:]
+}
+
+// Get answers the find of query from the listings of the nodes it goes
+// through. It returns false if one of them is not cached, or if nothing
+// matches.
+func (c *Cache) Get(query string) (types.Matches, bool) {
+	if query == "" || strings.Contains(query, "..") {
+		return types.Matches{}, false
+	}
+	nodes := strings.Split(query, ".")
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+
+	// the paths of the nodes matched by the first nodes of query
+	parents := []string{""}
+	var matches []types.Match
+	for i, node := range nodes {
+		last := i == len(nodes)-1
+		if !last && !isGlob(node) {
+			// the node is looked up in its own listing, if it exists
+			for j := range parents {
+				parents[j] = child(parents[j], node)
+			}
+			continue
+		}
+
+		match, err := nodeMatcher(node)
+		if err != nil {
+			return types.Matches{}, false
+		}
+		var next []string
+		for _, parent := range parents {
+			l, ok := c.listings[parent]
+			if !ok || now.After(l.expires) {
+				return types.Matches{}, false
+			}
+			for _, m := range l.matches {
+				if !match(lastNode(m.Path)) {
+					continue
+				}
+				if last {
+					matches = append(matches, m)
+				} else if !m.IsLeaf {
+					next = append(next, m.Path)
+				}
+			}
+		}
+		parents = next
+	}
+
+	// the backends answer for what is not there
+	if len(matches) == 0 {
+		return types.Matches{}, false
+	}
+
+	return types.Matches{Name: query, Matches: matches}, true
+}
+
+// Set caches the matches of the find of query, if it lists the children of
+// a node: it is * or a path followed by .*. It reports whether they were
+// cached, which they are not beyond the maximum number of entries.
+func (c *Cache) Set(query string, matches types.Matches) bool {
+	var parent string
+	switch {
+	case query == "*":
+	case strings.HasSuffix(query, ".*") && !isGlob(strings.TrimSuffix(query, ".*")):
+		parent = strings.TrimSuffix(query, ".*")
+	default:
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+
+	entries := c.entries - len(c.listings[parent].matches) + len(matches.Matches)
+	if c.maxEntries > 0 && entries > c.maxEntries {
+		c.removeExpired(now)
+		entries = c.entries - len(c.listings[parent].matches) + len(matches.Matches)
+		if entries > c.maxEntries {
+			return false
+		}
+	}
+
+	c.listings[parent] = listing{
+		matches: append([]types.Match(nil), matches.Matches...),
+		expires: now.Add(c.ttl),
+	}
+	c.entries = entries
+
+	return true
+}
+
+func (c *Cache) removeExpired(now time.Time) {
+	for path, l := range c.listings {
+		if now.After(l.expires) {
+			c.remove(path)
+		}
+	}
+}
+
+func (c *Cache) remove(path string) {
+	c.entries -= len(c.listings[path].matches)
+	delete(c.listings, path)
+}
+
+// Invalidate drops the listings of the node of path, of the nodes under it,
+// and of the nodes above it, which list it. An empty path drops them all.
+func (c *Cache) Invalidate(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if path == "" {
+		c.listings = make(map[string]listing)
+		c.entries = 0
+		return
+	}
+
+	for p := range c.listings {
+		if p == path || strings.HasPrefix(p, path+".") || p == "" || strings.HasPrefix(path, p+".") {
+			c.remove(p)
+		}
+	}
+}
+
+// Entries returns the number of children in the listings cached.
+func (c *Cache) Entries() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.entries
+}
@@ -0,0 +1,122 @@
+package metrictree
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func listingOf(paths ...string) types.Matches {
+	var m types.Matches
+	for _, p := range paths {
+		// the paths ending with a dot are branches
+		if p[len(p)-1] == '.' {
+			m.Matches = append(m.Matches, types.Match{Path: p[:len(p)-1]})
+		} else {
+			m.Matches = append(m.Matches, types.Match{Path: p, IsLeaf: true})
+		}
+	}
+	return m
+}
+
+func paths(m types.Matches) []string {
+	var p []string
+	for _, match := range m.Matches {
+		p = append(p, match.Path)
+	}
+	return p
+}
+
+func TestCache(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := New(time.Minute, 0)
+	c.now = func() time.Time { return now }
+
+	for query, listing := range map[string]types.Matches{
+		"*":       listingOf("a.", "b."),
+		"a.*":     listingOf("a.x", "a.y", "a.sub."),
+		"b.*":     listingOf("b.x", "b.z"),
+		"a.sub.*": listingOf("a.sub.x"),
+	} {
+		if !c.Set(query, listing) {
+			t.Fatalf("listing of %s was not cached", query)
+		}
+	}
+	if c.Set("a.x*", listingOf("a.x")) {
+		t.Error("expected the matches of a.x* not to be cached")
+	}
+	if c.Entries() != 8 {
+		t.Errorf("expected 8 entries, got %d", c.Entries())
+	}
+
+	tests := []struct {
+		query string
+		paths []string
+		ok    bool
+	}{
+		{"*", []string{"a", "b"}, true},
+		{"a.*", []string{"a.x", "a.y", "a.sub"}, true},
+		{"a.x", []string{"a.x"}, true},
+		{"a.{x,sub}", []string{"a.x", "a.sub"}, true},
+		{"a.[xy]", []string{"a.x", "a.y"}, true},
+		{"*.x", []string{"a.x", "b.x"}, true},
+		{"*.*.x", []string{"a.sub.x"}, true},
+		{"a.sub.*", []string{"a.sub.x"}, true},
+		{"a.missing", nil, false},
+		{"c.*", nil, false},
+		{"a.x.*", nil, false},
+	}
+	for _, tt := range tests {
+		m, ok := c.Get(tt.query)
+		if ok != tt.ok || !reflect.DeepEqual(paths(m), tt.paths) {
+			t.Errorf("%s: expected %v (%v), got %v (%v)", tt.query, tt.paths, tt.ok, paths(m), ok)
+		}
+	}
+
+	c.Invalidate("a.sub")
+	for query, ok := range map[string]bool{"a.sub.*": false, "a.*": false, "*": false, "b.*": true} {
+		if _, got := c.Get(query); got != ok {
+			t.Errorf("expected %s to be cached %v after invalidating a.sub, got %v", query, ok, got)
+		}
+	}
+	if c.Entries() != 2 {
+		t.Errorf("expected 2 entries after invalidating a.sub, got %d", c.Entries())
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, ok := c.Get("b.*"); ok {
+		t.Error("expected the listing of b to expire")
+	}
+
+	c.Invalidate("")
+	if c.Entries() != 0 {
+		t.Errorf("expected no entries after invalidating all, got %d", c.Entries())
+	}
+}
+
+func TestCacheMaxEntries(t *testing.T) {
+	now := time.Unix(1000, 0)
+	c := New(time.Minute, 3)
+	c.now = func() time.Time { return now }
+
+	if !c.Set("a.*", listingOf("a.x", "a.y")) {
+		t.Fatal("expected the listing of a to be cached")
+	}
+	if c.Set("b.*", listingOf("b.x", "b.y")) {
+		t.Fatal("expected the listing of b not to fit")
+	}
+	if !c.Set("a.*", listingOf("a.x", "a.y", "a.z")) {
+		t.Fatal("expected the listing of a to be replaced")
+	}
+
+	// expired listings make room
+	now = now.Add(2 * time.Minute)
+	if !c.Set("b.*", listingOf("b.x", "b.y")) {
+		t.Fatal("expected the listing of b to replace the expired one of a")
+	}
+	if c.Entries() != 2 {
+		t.Errorf("expected 2 entries, got %d", c.Entries())
+	}
+}
@@ -16,29 +16,58 @@ const (
 	indexStateCancelled = -3
 )
 
+// ErrQueueFull is returned to the requests that would wait beyond the
+// maximum number of requests waiting.
+var ErrQueueFull = errors.New("too many requests waiting")
+
 type request struct {
-	priority int // less is more
-	canEnter chan struct{}
-	index    int
-	uuid     string
+	priority   int // less is more
+	canEnter   chan struct{}
+	err        error // why the request can not enter, once canEnter is closed
+	index      int
+	uuid       string
+	tenantName string
+	tenant     *tenant
 }
 
 type requests []*request
 
-// Limiter does two things
+// tenant queues the waiting requests of a tenant. Tenants take turns in the
+// order of their virtual finish times, which advance by the inverse of their
+// weight with every request entering, so that tenants share the limit in
+// proportion to their weights (weighted fair queueing).
+type tenant struct {
+	name     string
+	weight   float64
+	requests requests
+	finish   float64 // of its next request, or of its last one when not waiting
+	index    int     // in Limiter.tenants, -1 when not waiting
+}
+
+type tenants []*tenant
+
+// Limiter does three things
 // a) limits the number of concurrent requests going upstream
-// b) prioritize the "waiting" requests
+// b) shares the limit fairly between the tenants with requests waiting
+// c) prioritize the "waiting" requests of each tenant
 // For prioritization we are using two variables:
 // "priority": that is request complexity, less complexity == more priority
 // "uuid": for requests of equal comlexity, process them ordered by uuid in order do minimize the number of "active" requests
 type Limiter struct {
-	requests      requests
+	tenants       tenants
+	byName        map[string]*tenant
+	weights       map[string]float64
+	virtualTime   float64
+	waiting       int
 	limiter       chan struct{}
 	wantToEnter   chan *request
 	cancelRequest chan *request
 	loopCount     uint32
 	activeGauge   prometheus.Gauge
 	waitingGauge  prometheus.Gauge
+
+	maxWaiting int
+	queueTime  prometheus.Histogram
 }
 
 type LimiterOption func(*Limiter)
@@ -46,6 +75,7 @@ type LimiterOption func(*Limiter)
 // New creates a new limiter that allows maximum "limit" requests to "Enter"
 func New(limit int, options ...LimiterOption) *Limiter {
 	ret := &Limiter{
+		byName:        make(map[string]*tenant),
 		limiter:       make(chan struct{}, limit),
 		wantToEnter:   make(chan *request),
 		cancelRequest: make(chan *request),
@@ -68,15 +98,46 @@ func WithMetrics(activeGauge, waitingGauge prometheus.Gauge) LimiterOption {
 	}
 }
 
+// WithMaxWaiting rejects the requests that would wait while max requests
+// are already waiting, with ErrQueueFull.
+func WithMaxWaiting(max int) LimiterOption {
+	return func(l *Limiter) {
+		l.maxWaiting = max
+	}
+}
+
+// WithQueueTime observes the seconds requests wait before they enter.
+func WithQueueTime(queueTime prometheus.Histogram) LimiterOption {
+	return func(l *Limiter) {
+		l.queueTime = queueTime
+	}
+}
+
+// WithTenantWeights sets the weights of tenants in sharing the limit. Other
+// tenants weigh 1.
+func WithTenantWeights(weights map[string]float64) LimiterOption {
+	return func(l *Limiter) {
+		l.weights = weights
+	}
+}
+
 // Enter blocks this request until it's turn comes
 func (l *Limiter) Enter(ctx context.Context, priority int, uuid string) error {
+	return l.EnterAs(ctx, "", priority, uuid)
+}
+
+// EnterAs blocks this request of tenant until it's turn comes. It returns
+// ErrQueueFull at once if too many requests are waiting.
+func (l *Limiter) EnterAs(ctx context.Context, tenant string, priority int, uuid string) error {
+	t0 := time.Now()
 	canEnter := make(chan struct{})
 
 	req := &request{
-		priority: priority,
-		canEnter: canEnter,
-		uuid:     uuid,
-		index:    indexStateNew,
+		priority:   priority,
+		canEnter:   canEnter,
+		uuid:       uuid,
+		index:      indexStateNew,
+		tenantName: tenant,
 	}
 
 	l.wantToEnter <- req
@@ -92,6 +153,12 @@ func (l *Limiter) Enter(ctx context.Context, priority int, uuid string) error {
 			l.cancelRequest <- req
 			return ctx.Err()
 		case <-canEnter:
+			if req.err != nil {
+				return req.err
+			}
+			if l.queueTime != nil {
+				l.queueTime.Observe(time.Since(t0).Seconds())
+			}
 			return nil
 		}
 	}
@@ -116,54 +183,114 @@ func (l *Limiter) Active() int {
 
 func (l *Limiter) loop() {
 	for {
-		if len(l.requests) == 0 {
+		if l.waiting == 0 {
 			select {
 			case req := <-l.wantToEnter:
-				if req.index != indexStateCancelled {
-					heap.Push(&l.requests, req)
-				}
+				l.enqueue(req)
 			case req := <-l.cancelRequest:
-				index := req.index
-				if index >= 0 {
-					heap.Remove(&l.requests, index)
-				}
-				if index == indexStateActive {
-					// If we are receiving a cancel request at this point,
-					// it means Enter() returned with error, and the caller will not Leave()
-					l.Leave()
-				}
-				req.index = indexStateCancelled
+				l.cancel(req)
 			}
 		} else {
 			select {
 			case req := <-l.wantToEnter:
-				if req.index != indexStateCancelled {
-					heap.Push(&l.requests, req)
-				}
+				l.enqueue(req)
 			case req := <-l.cancelRequest:
-				index := req.index
-				if index >= 0 {
-					heap.Remove(&l.requests, index)
-				}
-				if index == indexStateActive {
-					// If we are receiving a cancel request at this point,
-					// it means Enter() returned with error, and the caller will not Leave()
-					l.Leave()
-				}
-				req.index = indexStateCancelled
+				l.cancel(req)
 			case l.limiter <- struct{}{}:
-				req := heap.Pop(&l.requests).(*request)
+				req := l.pop()
 				close(req.canEnter)
 			}
 		}
+		if l.waiting == 0 {
+			// without requests waiting, there is nothing to be fair about
+			l.byName = make(map[string]*tenant)
+			l.virtualTime = 0
+		}
 		atomic.AddUint32(&l.loopCount, 1)
 		if l.activeGauge != nil {
 			l.activeGauge.Set(float64(len(l.limiter)))
 		}
 		if l.waitingGauge != nil {
-			l.waitingGauge.Set(float64(len(l.requests)))
+			l.waitingGauge.Set(float64(l.waiting))
+		}
+	}
+}
+
+// enqueue queues req, unless it is cancelled or the queue is full
+func (l *Limiter) enqueue(req *request) {
+	if req.index == indexStateCancelled {
+		return
+	}
+	if l.maxWaiting > 0 && l.waiting >= l.maxWaiting {
+		req.err = ErrQueueFull
+		close(req.canEnter)
+		return
+	}
+	l.push(req)
+}
+
+// push queues req with the other requests of its tenant
+func (l *Limiter) push(req *request) {
+	t, ok := l.byName[req.tenantName]
+	if !ok {
+		t = &tenant{
+			name:   req.tenantName,
+			weight: 1,
+			index:  -1,
+		}
+		if w := l.weights[t.name]; w > 0 {
+			t.weight = w
+		}
+		l.byName[t.name] = t
+	}
+	req.tenant = t
+
+	if len(t.requests) == 0 {
+		// a tenant starting to wait takes its turn after the requests that
+		// already entered, or after its own ones
+		if t.finish < l.virtualTime {
+			t.finish = l.virtualTime
 		}
+		t.finish += 1 / t.weight
+		heap.Push(&l.tenants, t)
 	}
+	heap.Push(&t.requests, req)
+	l.waiting++
+}
+
+// pop dequeues the request of the tenant whose turn it is
+func (l *Limiter) pop() *request {
+	t := l.tenants[0]
+	req := heap.Pop(&t.requests).(*request)
+	l.waiting--
+
+	l.virtualTime = t.finish
+	if len(t.requests) > 0 {
+		t.finish += 1 / t.weight
+		heap.Fix(&l.tenants, 0)
+	} else {
+		heap.Pop(&l.tenants)
+	}
+
+	return req
+}
+
+func (l *Limiter) cancel(req *request) {
+	index := req.index
+	if index >= 0 {
+		t := req.tenant
+		heap.Remove(&t.requests, index)
+		l.waiting--
+		if len(t.requests) == 0 {
+			heap.Remove(&l.tenants, t.index)
+		}
+	}
+	if index == indexStateActive {
+		// If we are receiving a cancel request at this point,
+		// it means Enter() returned with error, and the caller will not Leave()
+		l.Leave()
+	}
+	req.index = indexStateCancelled
 }
 
 // used in tests to ensure that loop() processed all the pending messages
@@ -210,3 +337,36 @@ func (r *requests) Pop() interface{} {
 	*r = old[0 : n-1]
 	return req
 }
+
+func (t tenants) Len() int {
+	return len(t)
+}
+
+func (t tenants) Less(i, j int) bool {
+	if t[i].finish == t[j].finish {
+		return t[i].name < t[j].name
+	}
+	return t[i].finish < t[j].finish
+}
+
+func (t tenants) Swap(i, j int) {
+	t[i], t[j] = t[j], t[i]
+	t[i].index = i
+	t[j].index = j
+}
+
+func (t *tenants) Push(x interface{}) {
+	tenant := x.(*tenant)
+	tenant.index = len(*t)
+	*t = append(*t, tenant)
+}
+
+func (t *tenants) Pop() interface{} {
+	old := *t
+	n := len(old)
+	tenant := old[n-1]
+	tenant.index = -1
+	old[n-1] = nil // avoid memory leak
+	*t = old[0 : n-1]
+	return tenant
+}
@@ -212,3 +212,85 @@ func TestPrioUUID(t *testing.T) {
 		t.Errorf("-want +got:\n%s", diff)
 	}
 }
+
+func TestTenantFairness(t *testing.T) {
+	tests := []struct {
+		name    string
+		weights map[string]float64
+		want    []string
+	}{
+		{"equal weights", nil, []string{"a0", "b0", "a1", "b1", "a2", "a3"}},
+		{"weighted", map[string]float64{"a": 2}, []string{"a0", "a1", "b0", "a2", "a3", "b1"}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := New(1, WithTenantWeights(tt.weights))
+			if err := limiter.Enter(context.TODO(), 0, "0"); err != nil {
+				t.Fatal(err)
+			}
+			limiter.waitLoopCount(2)
+
+			entered := make(chan string)
+			enter := func(tenant string, i int) {
+				if err := limiter.EnterAs(context.TODO(), tenant, i, "1"); err != nil {
+					t.Error(err)
+				}
+				entered <- fmt.Sprint(tenant, i)
+			}
+
+			// tenant a bursts before b asks
+			for i := 0; i < 4; i++ {
+				go enter("a", i)
+			}
+			limiter.waitLoopCount(6)
+			for i := 0; i < 2; i++ {
+				go enter("b", i)
+			}
+			limiter.waitLoopCount(8)
+
+			var got []string
+			for range tt.want {
+				if err := limiter.Leave(); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, <-entered)
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("-want +got:\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMaxWaiting(t *testing.T) {
+	limiter := New(1, WithMaxWaiting(1))
+	ctx := context.Background()
+
+	if err := limiter.Enter(ctx, 1, "1"); err != nil {
+		t.Fatal(err)
+	}
+	limiter.waitLoopCount(2)
+
+	entered := make(chan error)
+	go func() {
+		entered <- limiter.Enter(ctx, 1, "2")
+	}()
+	limiter.waitLoopCount(3)
+
+	if err := limiter.Enter(ctx, 1, "3"); err != ErrQueueFull {
+		t.Fatalf("expected the queue to be full, got %v", err)
+	}
+
+	if err := limiter.Leave(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-entered; err != nil {
+		t.Fatalf("expected the request waiting to enter, got %v", err)
+	}
+	if err := limiter.Leave(); err != nil {
+		t.Fatal(err)
+	}
+}
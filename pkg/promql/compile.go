@@ -0,0 +1,166 @@
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/prometheus"
+)
+
+// Target is a Graphite target compiled from a PromQL expression.
+type Target struct {
+	// Target is the target to evaluate.
+	Target string
+
+	// nodes are the labels of the nodes of the names of the series of
+	// Target, "" for the nodes that are not labels. The names of series
+	// without labels are not paths.
+	nodes []string
+}
+
+// Labels returns the labels of the series named name that the target
+// evaluates to, sorted by name, or false if the name does not fit it.
+func (t Target) Labels(name string) ([]prometheus.Label, bool) {
+	labels := []prometheus.Label{}
+	if len(t.nodes) == 0 {
+		return labels, true
+	}
+
+	nodes := strings.Split(name, ".")
+	if len(nodes) != len(t.nodes) {
+		return nil, false
+	}
+	for i, label := range t.nodes {
+		if label != "" {
+			labels = append(labels, prometheus.Label{Name: label, Value: nodes[i]})
+		}
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+	return labels, true
+}
+
+// Compile compiles e to a target over the metrics of the template.
+//
+// A selector is the glob of its metrics, filtered with grep or exclude for
+// the matchers the glob can not narrow down. A rate is the per-second rate
+// of the series averaged over the range, with movingAverage. Aggregations
+// are groupByNodes on the nodes of the labels they are grouped by.
+func (t PathTemplate) Compile(e Expr) (Target, error) {
+	target, nodes, err := t.compile(e)
+	if err != nil {
+		return Target{}, err
+	}
+
+	return Target{Target: target, nodes: nodes}, nil
+}
+
+func (t PathTemplate) compile(e Expr) (string, []string, error) {
+	switch e := e.(type) {
+	case *Selector:
+		return t.compileSelector(e)
+
+	case *Rate:
+		target, nodes, err := t.compileSelector(e.Selector)
+		if err != nil {
+			return "", nil, err
+		}
+		if e.Range < time.Second {
+			return "", nil, fmt.Errorf("range of %s is shorter than a second", e)
+		}
+		// the rate has the names of its series, without their metric name
+		fields := make([]string, len(nodes))
+		for i := range nodes {
+			fields[i] = strconv.Itoa(i)
+			if nodes[i] == "__name__" {
+				nodes[i] = ""
+			}
+		}
+		target = fmt.Sprintf("aliasByNode(movingAverage(perSecond(%s),'%ds'),%s)",
+			target, int64(e.Range/time.Second), strings.Join(fields, ","))
+		return target, nodes, nil
+
+	case *Aggregate:
+		target, nodes, err := t.compile(e.Expr)
+		if err != nil {
+			return "", nil, err
+		}
+		callback := map[string]string{"sum": "sumSeries", "avg": "averageSeries"}[e.Op]
+		if callback == "" {
+			return "", nil, fmt.Errorf("unsupported aggregation %s", e.Op)
+		}
+
+		// the series do not have the labels that are not nodes, or all
+		// have them empty: grouping by them makes no groups
+		var fields, by []string
+		for _, label := range e.By {
+			for i, node := range nodes {
+				if node == label && label != "" {
+					fields = append(fields, strconv.Itoa(i))
+					by = append(by, label)
+					break
+				}
+			}
+		}
+		if len(fields) == 0 {
+			return fmt.Sprintf("%s(%s)", callback, target), nil, nil
+		}
+		return fmt.Sprintf("groupByNodes(%s,'%s',%s)", target, callback, strings.Join(fields, ",")), by, nil
+	}
+
+	return "", nil, fmt.Errorf("unsupported expression %s", e)
+}
+
+func (t PathTemplate) compileSelector(s *Selector) (string, []string, error) {
+	nodes := make([]string, len(t))
+	for i, n := range t {
+		nodes[i] = n.label
+	}
+
+	target := t.Glob(s.Matchers)
+	for i, m := range s.Matchers {
+		node := -1
+		for j, label := range nodes {
+			if label == m.Name {
+				node = j
+				break
+			}
+		}
+		if node < 0 {
+			return "", nil, fmt.Errorf("label %s is not in the path template", m.Name)
+		}
+		if narrowing(m.Name, s.Matchers) == i {
+			continue
+		}
+
+		// the other nodes can not have dots: the value must be one node
+		value := m.Value
+		if m.Type == prometheus.MatchEqual || m.Type == prometheus.MatchNotEqual {
+			value = regexp.QuoteMeta(value)
+		}
+		pattern := make([]string, len(t))
+		for j := range pattern {
+			pattern[j] = `[^.]*`
+		}
+		pattern[node] = "(?:" + value + ")"
+		re := "^" + strings.Join(pattern, `\.`) + "$"
+		if _, err := regexp.Compile(re); err != nil {
+			return "", nil, fmt.Errorf("invalid regular expression %q: %v", m.Value, err)
+		}
+		if strings.Contains(re, "'") {
+			return "", nil, fmt.Errorf("unsupported value %q of label %s", m.Value, m.Name)
+		}
+
+		filter := "grep"
+		if m.Type == prometheus.MatchNotEqual || m.Type == prometheus.MatchNotRegexp {
+			filter = "exclude"
+		}
+		target = fmt.Sprintf("%s(%s,'%s')", filter, target, re)
+	}
+
+	return target, nodes, nil
+}
@@ -0,0 +1,94 @@
+package promql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/prometheus"
+)
+
+func TestCompile(t *testing.T) {
+	tmpl, err := ParsePathTemplate("servers.{host}.{__name__}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		query    string
+		target   string
+		name     string
+		expected []prometheus.Label
+	}{
+		{
+			`cpu{host=~"a|b"}`,
+			"servers.{a,b}.cpu",
+			"servers.a.cpu",
+			[]prometheus.Label{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "a"}},
+		},
+		{
+			`cpu{host!="a.b"}`,
+			`exclude(servers.*.cpu,'^[^.]*\.(?:a\.b)\.[^.]*$')`,
+			"servers.c.cpu",
+			[]prometheus.Label{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "c"}},
+		},
+		{
+			`rate(cpu{host=~"web.*"}[5m])`,
+			`aliasByNode(movingAverage(perSecond(grep(servers.*.cpu,'^[^.]*\.(?:web.*)\.[^.]*$')),'300s'),0,1,2)`,
+			"servers.web1.cpu",
+			[]prometheus.Label{{Name: "host", Value: "web1"}},
+		},
+		{
+			"sum by (host, job) (rate(cpu[1m]))",
+			"groupByNodes(aliasByNode(movingAverage(perSecond(servers.*.cpu),'60s'),0,1,2),'sumSeries',1)",
+			"a",
+			[]prometheus.Label{{Name: "host", Value: "a"}},
+		},
+		{
+			"avg(cpu)",
+			"averageSeries(servers.*.cpu)",
+			"averageSeries(servers.*.cpu)",
+			[]prometheus.Label{},
+		},
+	}
+
+	for _, tt := range tests {
+		e, err := Parse(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.query, err)
+		}
+		target, err := tmpl.Compile(e)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.query, err)
+			continue
+		}
+		if target.Target != tt.target {
+			t.Errorf("%s: expected target %s, got %s", tt.query, tt.target, target.Target)
+		}
+		labels, ok := target.Labels(tt.name)
+		if !ok || !reflect.DeepEqual(labels, tt.expected) {
+			t.Errorf("%s: expected labels %v, got %v", tt.query, tt.expected, labels)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tmpl, err := ParsePathTemplate("servers.{host}.{__name__}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, query := range []string{
+		`cpu{dc="a"}`,
+		`cpu{host=~"("}`,
+		`cpu{host="it's"}`,
+		"rate(cpu[10ms])",
+	} {
+		e, err := Parse(query)
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		if _, err := tmpl.Compile(e); err == nil {
+			t.Errorf("%s: expected an error", query)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+/*
+Package promql compiles a subset of PromQL to Graphite targets, for the
+Prometheus HTTP API to query Graphite metrics as series, whose labels are the
+nodes of their paths.
+
+The subset has selectors of series, like cpu{host=~"a|b"}, their rates over a
+range, like rate(cpu[5m]), and their sums and averages, grouped by some
+labels, like sum by (host) (rate(cpu[5m])).
+*/
+package promql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/prometheus"
+)
+
+// Expr is an expression of the PromQL subset: a *Selector, a *Rate or an
+// *Aggregate.
+type Expr interface {
+	String() string
+}
+
+// Selector selects the series its matchers all select. The name of a
+// selector, as in cpu{host="a"}, is a matcher on the label __name__.
+type Selector struct {
+	Matchers []prometheus.Matcher
+}
+
+// Rate is the per-second rate of the series of a selector, averaged over
+// the range before each sample.
+type Rate struct {
+	Selector *Selector
+	Range    time.Duration
+}
+
+// Aggregate sums or averages the series of an expression that have the
+// same values of the labels By, all of them if By is empty.
+type Aggregate struct {
+	// Op is sum or avg.
+	Op   string
+	By   []string
+	Expr Expr
+}
+
+var matchOps = map[prometheus.MatchType]string{
+	prometheus.MatchEqual:     "=",
+	prometheus.MatchNotEqual:  "!=",
+	prometheus.MatchRegexp:    "=~",
+	prometheus.MatchNotRegexp: "!~",
+}
+
+func (s *Selector) String() string {
+	matchers := make([]string, 0, len(s.Matchers))
+	for _, m := range s.Matchers {
+		matchers = append(matchers, m.Name+matchOps[m.Type]+strconv.Quote(m.Value))
+	}
+	return "{" + strings.Join(matchers, ",") + "}"
+}
+
+func (r *Rate) String() string {
+	return fmt.Sprintf("rate(%s[%ds])", r.Selector, int64(r.Range/time.Second))
+}
+
+func (a *Aggregate) String() string {
+	return fmt.Sprintf("%s by (%s) (%s)", a.Op, strings.Join(a.By, ", "), a.Expr)
+}
+
+// Parse parses a query of the PromQL subset.
+func Parse(query string) (Expr, error) {
+	p := &queryParser{query: query}
+	e, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	if p.pos < len(p.query) {
+		return nil, p.errorf("unexpected %q", p.query[p.pos:])
+	}
+
+	return e, nil
+}
+
+type queryParser struct {
+	query string
+	pos   int
+}
+
+func (p *queryParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("parse error at position %d: %s", p.pos+1, fmt.Sprintf(format, args...))
+}
+
+func (p *queryParser) skipSpace() {
+	for p.pos < len(p.query) && strings.ContainsRune(" \t\r\n", rune(p.query[p.pos])) {
+		p.pos++
+	}
+}
+
+// consume skips s, and reports whether it was next.
+func (p *queryParser) consume(s string) bool {
+	p.skipSpace()
+	if strings.HasPrefix(p.query[p.pos:], s) {
+		p.pos += len(s)
+		return true
+	}
+	return false
+}
+
+func (p *queryParser) expect(s string) error {
+	if !p.consume(s) {
+		if p.pos == len(p.query) {
+			return p.errorf("expected %q, got the end of the query", s)
+		}
+		return p.errorf("expected %q", s)
+	}
+	return nil
+}
+
+func isIdentChar(c byte, first bool) bool {
+	return c == '_' || c == ':' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || !first && '0' <= c && c <= '9'
+}
+
+// ident returns the identifier next, or "" if there is none.
+func (p *queryParser) ident() string {
+	p.skipSpace()
+	start := p.pos
+	for p.pos < len(p.query) && isIdentChar(p.query[p.pos], p.pos == start) {
+		p.pos++
+	}
+	return p.query[start:p.pos]
+}
+
+// peekIdent returns the identifier next, without consuming it.
+func (p *queryParser) peekIdent() string {
+	pos := p.pos
+	ident := p.ident()
+	p.pos = pos
+	return ident
+}
+
+func (p *queryParser) expr() (Expr, error) {
+	switch strings.ToLower(p.peekIdent()) {
+	case "sum", "avg":
+		return p.aggregate()
+	case "rate":
+		return p.rate()
+	}
+
+	return p.selector()
+}
+
+func (p *queryParser) aggregate() (Expr, error) {
+	a := &Aggregate{Op: strings.ToLower(p.ident())}
+
+	var err error
+	grouped := false
+	if strings.ToLower(p.peekIdent()) == "by" {
+		if a.By, err = p.grouping(); err != nil {
+			return nil, err
+		}
+		grouped = true
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if a.Expr, err = p.expr(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if !grouped && strings.ToLower(p.peekIdent()) == "by" {
+		if a.By, err = p.grouping(); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// grouping parses by (label, ...).
+func (p *queryParser) grouping() ([]string, error) {
+	p.ident()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+
+	var labels []string
+	for !p.consume(")") {
+		if len(labels) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			if p.consume(")") {
+				break
+			}
+		}
+		label := p.ident()
+		if label == "" {
+			return nil, p.errorf("expected a label")
+		}
+		labels = append(labels, label)
+	}
+
+	return labels, nil
+}
+
+func (p *queryParser) rate() (Expr, error) {
+	p.ident()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	s, err := p.selector()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("["); err != nil {
+		return nil, err
+	}
+	p.skipSpace()
+	end := strings.IndexByte(p.query[p.pos:], ']')
+	if end < 0 {
+		return nil, p.errorf("expected \"]\"")
+	}
+	r, err := parseDuration(strings.TrimSpace(p.query[p.pos : p.pos+end]))
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	p.pos += end + 1
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	return &Rate{Selector: s, Range: r}, nil
+}
+
+func (p *queryParser) selector() (*Selector, error) {
+	s := &Selector{}
+	if name := p.ident(); name != "" {
+		s.Matchers = append(s.Matchers, prometheus.Matcher{Type: prometheus.MatchEqual, Name: "__name__", Value: name})
+	}
+
+	if p.consume("{") {
+		for first := true; !p.consume("}"); first = false {
+			if !first {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+				if p.consume("}") {
+					break
+				}
+			}
+			m, err := p.matcher()
+			if err != nil {
+				return nil, err
+			}
+			s.Matchers = append(s.Matchers, m)
+		}
+	}
+
+	if len(s.Matchers) == 0 {
+		return nil, p.errorf("expected a selector")
+	}
+
+	return s, nil
+}
+
+func (p *queryParser) matcher() (prometheus.Matcher, error) {
+	m := prometheus.Matcher{Name: p.ident()}
+	if m.Name == "" {
+		return m, p.errorf("expected a label")
+	}
+
+	switch {
+	case p.consume("=~"):
+		m.Type = prometheus.MatchRegexp
+	case p.consume("!~"):
+		m.Type = prometheus.MatchNotRegexp
+	case p.consume("!="):
+		m.Type = prometheus.MatchNotEqual
+	case p.consume("="):
+		m.Type = prometheus.MatchEqual
+	default:
+		return m, p.errorf("expected a label matching operator")
+	}
+
+	var err error
+	m.Value, err = p.string()
+	return m, err
+}
+
+// string parses a string in double, single or back quotes, which escape
+// characters like Go strings, but for the back quotes.
+func (p *queryParser) string() (string, error) {
+	p.skipSpace()
+	if p.pos == len(p.query) || !strings.ContainsRune("\"'`", rune(p.query[p.pos])) {
+		return "", p.errorf("expected a string")
+	}
+
+	quote := p.query[p.pos]
+	end := p.pos + 1
+	for end < len(p.query) && p.query[end] != quote {
+		if p.query[end] == '\\' && quote != '`' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.query) {
+		return "", p.errorf("unterminated string")
+	}
+	raw := p.query[p.pos+1 : end]
+	p.pos = end + 1
+
+	switch quote {
+	case '`':
+		return raw, nil
+	case '\'':
+		// requote it for strconv, which only has runes in single quotes
+		var b strings.Builder
+		for i := 0; i < len(raw); i++ {
+			switch {
+			case raw[i] == '\\' && i+1 < len(raw) && raw[i+1] == '\'':
+				b.WriteByte('\'')
+				i++
+			case raw[i] == '\\' && i+1 < len(raw):
+				b.WriteString(raw[i : i+2])
+				i++
+			case raw[i] == '"':
+				b.WriteString(`\"`)
+			default:
+				b.WriteByte(raw[i])
+			}
+		}
+		raw = b.String()
+	}
+
+	s, err := strconv.Unquote(`"` + raw + `"`)
+	if err != nil || !utf8.ValidString(s) {
+		return "", p.errorf("invalid string %q", raw)
+	}
+	return s, nil
+}
+
+// durationUnits has ms before m, for it to match first.
+var durationUnits = []struct {
+	unit string
+	d    time.Duration
+}{
+	{"ms", time.Millisecond},
+	{"s", time.Second},
+	{"m", time.Minute},
+	{"h", time.Hour},
+	{"d", 24 * time.Hour},
+	{"w", 7 * 24 * time.Hour},
+	{"y", 365 * 24 * time.Hour},
+}
+
+// parseDuration parses a duration of PromQL, like 5m or 1h30m.
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, fmt.Errorf("expected a duration")
+	}
+
+	var d time.Duration
+	for rest := s; rest != ""; {
+		i := 0
+		for i < len(rest) && '0' <= rest[i] && rest[i] <= '9' {
+			i++
+		}
+		if i == 0 {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.ParseInt(rest[:i], 10, 32)
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		rest = rest[i:]
+
+		found := false
+		for _, u := range durationUnits {
+			if strings.HasPrefix(rest, u.unit) {
+				d += time.Duration(n) * u.d
+				rest = rest[len(u.unit):]
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+	}
+
+	return d, nil
+}
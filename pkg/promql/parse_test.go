@@ -0,0 +1,65 @@
+package promql
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/prometheus"
+)
+
+func TestParse(t *testing.T) {
+	cpu := prometheus.Matcher{Type: prometheus.MatchEqual, Name: "__name__", Value: "cpu"}
+	hosts := prometheus.Matcher{Type: prometheus.MatchRegexp, Name: "host", Value: "a|b"}
+
+	tests := []struct {
+		query    string
+		expected Expr
+	}{
+		{"cpu", &Selector{Matchers: []prometheus.Matcher{cpu}}},
+		{`cpu{host=~"a|b"}`, &Selector{Matchers: []prometheus.Matcher{cpu, hosts}}},
+		{`{__name__="cpu", host=~'a|b',}`, &Selector{Matchers: []prometheus.Matcher{cpu, hosts}}},
+		{`cpu{host!="a\"b"}`, &Selector{Matchers: []prometheus.Matcher{cpu,
+			{Type: prometheus.MatchNotEqual, Name: "host", Value: `a"b`}}}},
+		{"cpu{host!~`a\\.b`}", &Selector{Matchers: []prometheus.Matcher{cpu,
+			{Type: prometheus.MatchNotRegexp, Name: "host", Value: `a\.b`}}}},
+		{"rate(cpu[1h30m])", &Rate{Selector: &Selector{Matchers: []prometheus.Matcher{cpu}}, Range: 90 * time.Minute}},
+		{"sum(rate(cpu[5m]))", &Aggregate{Op: "sum",
+			Expr: &Rate{Selector: &Selector{Matchers: []prometheus.Matcher{cpu}}, Range: 5 * time.Minute}}},
+		{"avg by (host, dc) (cpu)", &Aggregate{Op: "avg", By: []string{"host", "dc"},
+			Expr: &Selector{Matchers: []prometheus.Matcher{cpu}}}},
+		{"SUM(cpu) BY (host)", &Aggregate{Op: "sum", By: []string{"host"},
+			Expr: &Selector{Matchers: []prometheus.Matcher{cpu}}}},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.query)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("%s: expected %s, got %s", tt.query, tt.expected, got)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"{}",
+		"cpu{host}",
+		`cpu{host="a"`,
+		`cpu{host="a}`,
+		"rate(cpu)",
+		"rate(cpu[5x])",
+		"sum(cpu",
+		"sum by host (cpu)",
+		"max(cpu)",
+		"cpu + 1",
+	} {
+		if _, err := Parse(query); err == nil {
+			t.Errorf("%q: expected an error", query)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package promql
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/prometheus"
+)
+
+// PathTemplate maps the labels of Prometheus series to the paths of Graphite
+// metrics, one node of the path after the other. A node is either a label,
+// or a literal if it has no label.
+type PathTemplate []templateNode
+
+type templateNode struct {
+	label   string
+	literal string
+}
+
+// ParsePathTemplate parses a template like "{job}.{instance}.{__name__}".
+func ParsePathTemplate(s string) (PathTemplate, error) {
+	var t PathTemplate
+	seen := make(map[string]bool)
+	for _, node := range strings.Split(s, ".") {
+		if node == "" {
+			return nil, fmt.Errorf("path template %q has an empty node", s)
+		}
+		if !strings.HasPrefix(node, "{") || !strings.HasSuffix(node, "}") {
+			if strings.ContainsAny(node, "{}*?[]") {
+				return nil, fmt.Errorf("path template %q has an invalid node %q", s, node)
+			}
+			t = append(t, templateNode{literal: node})
+			continue
+		}
+
+		label := node[1 : len(node)-1]
+		if label == "" || strings.ContainsAny(label, "{}") {
+			return nil, fmt.Errorf("path template %q has an invalid node %q", s, node)
+		}
+		if seen[label] {
+			return nil, fmt.Errorf("path template %q has label %s twice", s, label)
+		}
+		seen[label] = true
+		t = append(t, templateNode{label: label})
+	}
+
+	return t, nil
+}
+
+// plainValues matches the label values that are plain nodes of paths, and
+// their alternations in regular expressions of matchers, e.g. "a|b".
+var plainValues = regexp.MustCompile(`^[\w-]+(\|[\w-]+)*$`)
+
+// narrowing returns the index of the first of matchers that a glob can
+// narrow the node of label down to, or -1 if there is none.
+func narrowing(label string, matchers []prometheus.Matcher) int {
+	for i, m := range matchers {
+		if m.Name != label || !plainValues.MatchString(m.Value) {
+			continue
+		}
+		if m.Type == prometheus.MatchEqual && !strings.Contains(m.Value, "|") {
+			return i
+		}
+		if m.Type == prometheus.MatchRegexp {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Glob returns the glob of the paths of the series matchers may select. The
+// nodes of labels the glob can not narrow down are wildcards: the series
+// must still be filtered by the matchers.
+func (t PathTemplate) Glob(matchers []prometheus.Matcher) string {
+	nodes := make([]string, len(t))
+	for i, n := range t {
+		if n.label == "" {
+			nodes[i] = n.literal
+			continue
+		}
+
+		nodes[i] = "*"
+		if j := narrowing(n.label, matchers); j >= 0 {
+			if v := matchers[j].Value; strings.Contains(v, "|") {
+				nodes[i] = "{" + strings.ReplaceAll(v, "|", ",") + "}"
+			} else {
+				nodes[i] = v
+			}
+		}
+	}
+
+	return strings.Join(nodes, ".")
+}
+
+// Labels returns the labels of the series of the metric path, sorted by
+// name, or false if the path does not fit the template.
+func (t PathTemplate) Labels(path string) ([]prometheus.Label, bool) {
+	nodes := strings.Split(path, ".")
+	if len(nodes) != len(t) {
+		return nil, false
+	}
+
+	labels := make([]prometheus.Label, 0, len(t))
+	for i, n := range t {
+		if n.label == "" {
+			if nodes[i] != n.literal {
+				return nil, false
+			}
+			continue
+		}
+		labels = append(labels, prometheus.Label{Name: n.label, Value: nodes[i]})
+	}
+	sort.Slice(labels, func(i, j int) bool { return labels[i].Name < labels[j].Name })
+
+	return labels, true
+}
+
+// Selects reports whether matchers all select the series of labels. Like in
+// Prometheus, a label the series does not have has the empty value.
+func Selects(matchers []prometheus.Matcher, labels []prometheus.Label) (bool, error) {
+	for _, m := range matchers {
+		value := ""
+		for _, l := range labels {
+			if l.Name == m.Name {
+				value = l.Value
+				break
+			}
+		}
+
+		var ok bool
+		switch m.Type {
+		case prometheus.MatchEqual:
+			ok = value == m.Value
+		case prometheus.MatchNotEqual:
+			ok = value != m.Value
+		case prometheus.MatchRegexp, prometheus.MatchNotRegexp:
+			re, err := regexp.Compile("^(?:" + m.Value + ")$")
+			if err != nil {
+				return false, err
+			}
+			ok = re.MatchString(value) == (m.Type == prometheus.MatchRegexp)
+		default:
+			return false, fmt.Errorf("unknown matcher type %d", m.Type)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
@@ -0,0 +1,91 @@
+package promql
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/prometheus"
+)
+
+func TestParsePathTemplate(t *testing.T) {
+	for _, s := range []string{"{job}.{instance}.{__name__}", "servers.{host}.cpu"} {
+		if _, err := ParsePathTemplate(s); err != nil {
+			t.Errorf("%s: unexpected error %v", s, err)
+		}
+	}
+	for _, s := range []string{"", "{job}..{__name__}", "{}.cpu", "{a}.{a}", "servers.*.{__name__}", "x{a}"} {
+		if _, err := ParsePathTemplate(s); err == nil {
+			t.Errorf("%s: expected an error", s)
+		}
+	}
+}
+
+func TestPathTemplateGlob(t *testing.T) {
+	tmpl, err := ParsePathTemplate("servers.{host}.{__name__}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		matchers []prometheus.Matcher
+		expected string
+	}{
+		{"no matchers", nil, "servers.*.*"},
+		{"equal", []prometheus.Matcher{{Type: prometheus.MatchEqual, Name: "__name__", Value: "cpu"}}, "servers.*.cpu"},
+		{"alternation", []prometheus.Matcher{{Type: prometheus.MatchRegexp, Name: "host", Value: "a|b"}}, "servers.{a,b}.*"},
+		{"regexp", []prometheus.Matcher{{Type: prometheus.MatchRegexp, Name: "host", Value: "a.*"}}, "servers.*.*"},
+		{"not equal", []prometheus.Matcher{{Type: prometheus.MatchNotEqual, Name: "host", Value: "a"}}, "servers.*.*"},
+		{"dotted value", []prometheus.Matcher{{Type: prometheus.MatchEqual, Name: "host", Value: "a.b"}}, "servers.*.*"},
+	}
+
+	for _, tt := range tests {
+		if got := tmpl.Glob(tt.matchers); got != tt.expected {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.expected, got)
+		}
+	}
+}
+
+func TestPathTemplateLabels(t *testing.T) {
+	tmpl, err := ParsePathTemplate("servers.{host}.{__name__}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := tmpl.Labels("servers.a.cpu")
+	expected := []prometheus.Label{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "a"}}
+	if !ok || !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+	for _, path := range []string{"servers.a", "servers.a.cpu.user", "hosts.a.cpu"} {
+		if _, ok := tmpl.Labels(path); ok {
+			t.Errorf("%s: expected the path not to fit", path)
+		}
+	}
+}
+
+func TestSelects(t *testing.T) {
+	labels := []prometheus.Label{{Name: "__name__", Value: "cpu"}, {Name: "host", Value: "a"}}
+	tests := []struct {
+		matcher  prometheus.Matcher
+		expected bool
+	}{
+		{prometheus.Matcher{Type: prometheus.MatchEqual, Name: "host", Value: "a"}, true},
+		{prometheus.Matcher{Type: prometheus.MatchNotEqual, Name: "host", Value: "a"}, false},
+		{prometheus.Matcher{Type: prometheus.MatchRegexp, Name: "host", Value: "a|b"}, true},
+		{prometheus.Matcher{Type: prometheus.MatchRegexp, Name: "host", Value: "b.*"}, false},
+		{prometheus.Matcher{Type: prometheus.MatchNotRegexp, Name: "host", Value: "b.*"}, true},
+		{prometheus.Matcher{Type: prometheus.MatchEqual, Name: "dc", Value: ""}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := Selects([]prometheus.Matcher{tt.matcher}, labels)
+		if err != nil || got != tt.expected {
+			t.Errorf("%+v: expected %v, got %v (%v)", tt.matcher, tt.expected, got, err)
+		}
+	}
+
+	if _, err := Selects([]prometheus.Matcher{{Type: prometheus.MatchRegexp, Name: "host", Value: "("}}, labels); err == nil {
+		t.Error("expected an error for an invalid regexp")
+	}
+}
@@ -68,11 +68,13 @@ func InitTracer(BuildVersion string, serviceName string, logger *zap.Logger, con
 	}
 
 	propagator := trace.B3{}
-	// Grafana propagates traces over b3 headers
+	// Grafana propagates traces over b3 headers, the requests to the
+	// backends carry both b3 and W3C traceparent headers
 	oldProps := global.Propagators()
 	props := propagation.New(
 		propagation.WithExtractors(propagator),
 		propagation.WithExtractors(oldProps.HTTPExtractors()...),
+		propagation.WithInjectors(propagator),
 		propagation.WithInjectors(oldProps.HTTPInjectors()...),
 	)
 	global.SetPropagators(props)
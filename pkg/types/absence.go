@@ -0,0 +1,147 @@
+package types
+
+import (
+	"fmt"
+	"math/bits"
+)
+
+// Absence is the set of the absent points of a metric, a bit per point
+// rather than a bool, which is an eighth of the memory of a []bool along
+// the values. Like a slice, the copies of an Absence share their points,
+// until one of them is appended to past its capacity.
+type Absence struct {
+	words []uint64
+	n     int
+}
+
+// NewAbsence returns the absence of n points, none of them absent.
+func NewAbsence(n int) Absence {
+	return Absence{words: make([]uint64, (n+63)/64), n: n}
+}
+
+// AllAbsent returns the absence of n points, all of them absent.
+func AllAbsent(n int) Absence {
+	a := NewAbsence(n)
+	for i := range a.words {
+		a.words[i] = ^uint64(0)
+	}
+	a.clearTail()
+
+	return a
+}
+
+// AbsenceOf returns the absence of the points for which absent is true.
+func AbsenceOf(absent ...bool) Absence {
+	a := NewAbsence(len(absent))
+	for i, abs := range absent {
+		if abs {
+			a.words[i/64] |= 1 << (uint(i) % 64)
+		}
+	}
+
+	return a
+}
+
+// Len returns the number of points.
+func (a Absence) Len() int {
+	return a.n
+}
+
+// Get tells if point i is absent.
+func (a Absence) Get(i int) bool {
+	if uint(i) >= uint(a.n) {
+		panic(fmt.Sprintf("absence index %d out of range [0:%d]", i, a.n))
+	}
+
+	return a.words[i/64]&(1<<(uint(i)%64)) != 0
+}
+
+// Set sets whether point i is absent.
+func (a *Absence) Set(i int, absent bool) {
+	if uint(i) >= uint(a.n) {
+		panic(fmt.Sprintf("absence index %d out of range [0:%d]", i, a.n))
+	}
+
+	if absent {
+		a.words[i/64] |= 1 << (uint(i) % 64)
+	} else {
+		a.words[i/64] &^= 1 << (uint(i) % 64)
+	}
+}
+
+// Append appends a point, absent or not.
+func (a *Absence) Append(absent bool) {
+	if a.n%64 == 0 {
+		a.words = append(a.words[:a.n/64], 0)
+	}
+	a.n++
+	a.Set(a.n-1, absent)
+}
+
+// Count returns the number of absent points.
+func (a Absence) Count() int {
+	count := 0
+	for _, w := range a.words[:(a.n+63)/64] {
+		count += bits.OnesCount64(w)
+	}
+
+	return count
+}
+
+// Slice returns a copy of the absence of the points from i to j, excluded.
+func (a Absence) Slice(i, j int) Absence {
+	if i < 0 || j < i || j > a.n {
+		panic(fmt.Sprintf("absence slice bounds out of range [%d:%d] with length %d", i, j, a.n))
+	}
+
+	s := NewAbsence(j - i)
+	if i%64 == 0 {
+		copy(s.words, a.words[i/64:])
+		s.clearTail()
+		return s
+	}
+	for k := 0; k < s.n; k++ {
+		if a.Get(i + k) {
+			s.words[k/64] |= 1 << (uint(k) % 64)
+		}
+	}
+
+	return s
+}
+
+// Clone returns a copy of a that shares none of its points.
+func (a Absence) Clone() Absence {
+	return a.Slice(0, a.n)
+}
+
+// Bools returns the absence as a []bool.
+func (a Absence) Bools() []bool {
+	absent := make([]bool, a.n)
+	for i := range absent {
+		absent[i] = a.Get(i)
+	}
+
+	return absent
+}
+
+// Equal tells if a and b have as many points, absent alike.
+func (a Absence) Equal(b Absence) bool {
+	if a.n != b.n {
+		return false
+	}
+	for i, w := range a.words[:(a.n+63)/64] {
+		if w != b.words[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// clearTail clears the bits past the last point, for Count and Equal to
+// compare whole words.
+func (a *Absence) clearTail() {
+	if tail := a.n % 64; tail != 0 {
+		a.words[a.n/64] &= 1<<uint(tail) - 1
+	}
+}
@@ -0,0 +1,112 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAbsence(t *testing.T) {
+	bools := make([]bool, 150)
+	for i := range bools {
+		bools[i] = i%3 == 0 || i == 64
+	}
+
+	a := AbsenceOf(bools...)
+	if a.Len() != len(bools) {
+		t.Fatalf("expected %d points, got %d", len(bools), a.Len())
+	}
+	if !reflect.DeepEqual(a.Bools(), bools) {
+		t.Errorf("expected %v, got %v", bools, a.Bools())
+	}
+	if count := a.Count(); count != 51 {
+		t.Errorf("expected 51 absent points, got %d", count)
+	}
+
+	a.Set(1, true)
+	a.Set(0, false)
+	if !a.Get(1) || a.Get(0) {
+		t.Error("unexpected points after Set")
+	}
+
+	for _, c := range []struct{ i, j int }{{0, 150}, {64, 130}, {3, 70}, {150, 150}} {
+		s := a.Slice(c.i, c.j)
+		if want := a.Bools()[c.i:c.j]; !reflect.DeepEqual(s.Bools(), want) {
+			t.Errorf("Slice(%d, %d): expected %v, got %v", c.i, c.j, want, s.Bools())
+		}
+	}
+
+	clone := a.Clone()
+	clone.Set(2, true)
+	if a.Get(2) || !clone.Get(2) {
+		t.Error("a clone shares its points")
+	}
+	if a.Equal(clone) {
+		t.Error("expected absences that differ not to be equal")
+	}
+	clone.Set(2, false)
+	if !a.Equal(clone) {
+		t.Error("expected equal absences")
+	}
+}
+
+func TestAbsenceAppend(t *testing.T) {
+	var a Absence
+	var bools []bool
+	for i := 0; i < 200; i++ {
+		a.Append(i%7 == 0)
+		bools = append(bools, i%7 == 0)
+	}
+
+	if !a.Equal(AbsenceOf(bools...)) {
+		t.Errorf("expected %v, got %v", bools, a.Bools())
+	}
+}
+
+func TestAllAbsent(t *testing.T) {
+	a := AllAbsent(70)
+	if a.Count() != 70 {
+		t.Errorf("expected 70 absent points, got %d", a.Count())
+	}
+	// the bits past the points are clear, for whole words to compare
+	b := NewAbsence(70)
+	for i := 0; i < 70; i++ {
+		b.Set(i, true)
+	}
+	if !a.Equal(b) || !reflect.DeepEqual(a, b) {
+		t.Error("expected equal absences")
+	}
+}
+
+func TestAbsenceOutOfRange(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	// within the last word, past the last point
+	NewAbsence(10).Get(10)
+}
+
+func BenchmarkAbsence(b *testing.B) {
+	const points = 86400
+
+	b.Run("bools", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			absent := make([]bool, points)
+			for j := range absent {
+				absent[j] = j%5 == 0
+			}
+		}
+	})
+	b.Run("bitset", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			absent := NewAbsence(points)
+			for j := 0; j < points; j++ {
+				absent.Set(j, j%5 == 0)
+			}
+		}
+	})
+}
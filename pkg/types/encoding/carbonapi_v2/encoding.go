@@ -10,13 +10,31 @@ package carbonapi_v2
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
 
 	"github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
+// Timestamp converts t to the 32-bit unix time used by version 2 of the
+// protocol. Times outside of its range are clamped to the nearest value it
+// can represent.
+func Timestamp(t int64) int32 {
+	if t > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if t < math.MinInt32 {
+		return math.MinInt32
+	}
+
+	return int32(t)
+}
+
 func FindEncoder(matches types.Matches) ([]byte, error) {
 	out := carbonapi_v2_pb.GlobResponse{
 		Name:    matches.Name,
@@ -196,7 +214,7 @@ func RenderEncoder(metrics []types.Metric) ([]byte, error) {
 			StopTime:  m.StopTime,
 			StepTime:  m.StepTime,
 			Values:    m.Values,
-			IsAbsent:  m.IsAbsent,
+			IsAbsent:  m.IsAbsent.Bools(),
 		}
 
 		out.Metrics[i] = metric
@@ -205,35 +223,155 @@ func RenderEncoder(metrics []types.Metric) ([]byte, error) {
 	return out.Marshal()
 }
 
+// RenderDecoder decodes a render response straight into metrics, rather than
+// through the structs of the generated code: the values go to slices of the
+// pool of values, and the absent points to bitsets, without a copy in
+// between. Render responses are the bulk of what backends send, and this
+// takes less than a third of the memory to decode them, see
+// BenchmarkRenderDecoder.
 func RenderDecoder(blob []byte) ([]types.Metric, error) {
-	resp := carbonapi_v2_pb.MultiFetchResponse{}
-	if err := resp.Unmarshal(blob); err != nil {
-		return nil, err
+	var metrics []types.Metric
+	for len(blob) > 0 {
+		num, typ, n := protowire.ConsumeTag(blob)
+		if n < 0 {
+			types.ReleaseMetrics(metrics)
+			return nil, protowire.ParseError(n)
+		}
+		blob = blob[n:]
+
+		if num != 1 {
+			n = protowire.ConsumeFieldValue(num, typ, blob)
+		} else if typ != protowire.BytesType {
+			types.ReleaseMetrics(metrics)
+			return nil, fmt.Errorf("proto: wrong wireType = %d for field Metrics", typ)
+		} else {
+			var b []byte
+			b, n = protowire.ConsumeBytes(blob)
+			if n >= 0 {
+				m, err := decodeFetchResponse(b)
+				if err != nil {
+					types.ReleaseMetrics(append(metrics, m))
+					return nil, err
+				}
+				metrics = append(metrics, m)
+			}
+		}
+		if n < 0 {
+			types.ReleaseMetrics(metrics)
+			return nil, protowire.ParseError(n)
+		}
+		blob = blob[n:]
 	}
 
-	metrics := make([]types.Metric, len(resp.Metrics))
-	for i, m := range resp.Metrics {
-		metric := types.Metric{
-			Name:      m.Name,
-			StartTime: m.StartTime,
-			StopTime:  m.StopTime,
-			StepTime:  m.StepTime,
-			Values:    m.Values,
-			IsAbsent:  m.IsAbsent,
-		}
+	return metrics, nil
+}
 
-		/*
-			TODO(gmagnusson):
-			for j, absent := range metric.IsAbsent {
-				if absent {
-					t.Values[i] = math.NaN
+// decodeFetchResponse decodes a FetchResponse of a render response. The
+// values and absent points are packed by the encoders of the protocol, but
+// are accepted unpacked too, as protocol buffers require.
+func decodeFetchResponse(blob []byte) (types.Metric, error) {
+	var m types.Metric
+	for len(blob) > 0 {
+		num, typ, n := protowire.ConsumeTag(blob)
+		if n < 0 {
+			return m, protowire.ParseError(n)
+		}
+		blob = blob[n:]
+
+		var v uint64
+		var b []byte
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			b, n = protowire.ConsumeBytes(blob)
+			m.Name = string(b)
+		case num >= 2 && num <= 4 && typ == protowire.VarintType:
+			v, n = protowire.ConsumeVarint(blob)
+			switch num {
+			case 2:
+				m.StartTime = int32(v)
+			case 3:
+				m.StopTime = int32(v)
+			case 4:
+				m.StepTime = int32(v)
+			}
+		case num == 5 && typ == protowire.BytesType:
+			b, n = protowire.ConsumeBytes(blob)
+			if n >= 0 && len(b)%8 != 0 {
+				return m, errors.New("proto: packed values are not a multiple of 8 bytes")
+			}
+			m.Values = appendValues(m.Values, b)
+		case num == 5 && typ == protowire.Fixed64Type:
+			v, n = protowire.ConsumeFixed64(blob)
+			m.Values = append(m.Values, math.Float64frombits(v))
+		case num == 6 && typ == protowire.BytesType:
+			b, n = protowire.ConsumeBytes(blob)
+			if n >= 0 {
+				if err := appendAbsent(&m.IsAbsent, b); err != nil {
+					return m, err
 				}
 			}
-			and then remove Metric.IsAbsent
-		*/
+		case num == 6 && typ == protowire.VarintType:
+			v, n = protowire.ConsumeVarint(blob)
+			m.IsAbsent.Append(v != 0)
+		case num >= 1 && num <= 6:
+			return m, fmt.Errorf("proto: wrong wireType = %d for field %d of FetchResponse", typ, num)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, blob)
+		}
+		if n < 0 {
+			return m, protowire.ParseError(n)
+		}
+		blob = blob[n:]
+	}
 
-		metrics[i] = metric
+	return m, nil
+}
+
+// appendValues appends the packed doubles of b to values, taking them from
+// the pool if they are the first.
+func appendValues(values []float64, b []byte) []float64 {
+	n := len(b) / 8
+	if values == nil {
+		values = types.GetValues(n)
+	} else {
+		values = append(values, make([]float64, n)...)
 	}
 
-	return metrics, nil
+	dst := values[len(values)-n:]
+	for i := range dst {
+		dst[i] = math.Float64frombits(binary.LittleEndian.Uint64(b[8*i:]))
+	}
+
+	return values
+}
+
+// appendAbsent appends the packed bools of b to absent, sizing it once for
+// all of them if it is empty.
+func appendAbsent(absent *types.Absence, b []byte) error {
+	i := absent.Len()
+	if i == 0 {
+		// a varint ends with its only byte below 0x80
+		n := 0
+		for _, c := range b {
+			if c < 0x80 {
+				n++
+			}
+		}
+		*absent = types.NewAbsence(n)
+	}
+
+	for ; len(b) > 0; i++ {
+		v, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		if i < absent.Len() {
+			absent.Set(i, v != 0)
+		} else {
+			absent.Append(v != 0)
+		}
+		b = b[n:]
+	}
+
+	return nil
 }
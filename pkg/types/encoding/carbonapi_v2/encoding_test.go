@@ -1,13 +1,36 @@
 package carbonapi_v2
 
 import (
+	"fmt"
+	"math"
 	"testing"
 
 	"github.com/bookingcom/carbonapi/pkg/types"
 
 	"github.com/go-graphite/protocol/carbonapi_v2_pb"
+	"google.golang.org/protobuf/encoding/protowire"
 )
 
+func TestTimestamp(t *testing.T) {
+	tests := []struct {
+		in   int64
+		want int32
+	}{
+		{0, 0},
+		{1700000000, 1700000000},
+		{-100, -100},
+		{math.MaxInt32, math.MaxInt32},
+		{2208988800, math.MaxInt32},
+		{math.MinInt64, math.MinInt32},
+	}
+
+	for _, tst := range tests {
+		if got := Timestamp(tst.in); got != tst.want {
+			t.Errorf("Timestamp(%d): got %d, expected %d", tst.in, got, tst.want)
+		}
+	}
+}
+
 func TestIsInfoResponse(t *testing.T) {
 	var blob []byte
 	var ok bool
@@ -194,10 +217,130 @@ func TestResponseRenderUnmarshal(t *testing.T) {
 		StopTime:  2,
 		StepTime:  3,
 		Values:    []float64{0, 1},
-		IsAbsent:  []bool{true, false},
+		IsAbsent:  types.AbsenceOf(true, false),
 	}
 
 	if !types.MetricsEqual(exp, got[0]) {
 		t.Error("Metrics not equal")
 	}
 }
+
+func TestRenderDecoderUnpacked(t *testing.T) {
+	var m []byte
+	m = protowire.AppendTag(m, 1, protowire.BytesType)
+	m = protowire.AppendString(m, "A")
+	m = protowire.AppendTag(m, 4, protowire.VarintType)
+	m = protowire.AppendVarint(m, 60)
+	// unknown fields are skipped
+	m = protowire.AppendTag(m, 9, protowire.VarintType)
+	m = protowire.AppendVarint(m, 1)
+	for i, v := range []float64{0, 1, 2} {
+		m = protowire.AppendTag(m, 5, protowire.Fixed64Type)
+		m = protowire.AppendFixed64(m, math.Float64bits(v))
+		m = protowire.AppendTag(m, 6, protowire.VarintType)
+		m = protowire.AppendVarint(m, protowire.EncodeBool(i == 0))
+	}
+
+	var blob []byte
+	blob = protowire.AppendTag(blob, 1, protowire.BytesType)
+	blob = protowire.AppendBytes(blob, m)
+
+	got, err := RenderDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := types.Metric{
+		Name:     "A",
+		StepTime: 60,
+		Values:   []float64{0, 1, 2},
+		IsAbsent: types.AbsenceOf(true, false, false),
+	}
+	if len(got) != 1 || !types.MetricsEqual(exp, got[0]) {
+		t.Errorf("expected %v, got %v", exp, got)
+	}
+}
+
+func TestRenderDecoderInvalid(t *testing.T) {
+	input := renderResponse(1, 10)
+	blob, err := input.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wrongType := protowire.AppendTag(nil, 1, protowire.VarintType)
+	wrongType = protowire.AppendVarint(wrongType, 1)
+
+	for name, b := range map[string][]byte{
+		"truncated": blob[:len(blob)-1],
+		"wrongType": wrongType,
+	} {
+		if _, err := RenderDecoder(b); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func renderResponse(series, points int) carbonapi_v2_pb.MultiFetchResponse {
+	resp := carbonapi_v2_pb.MultiFetchResponse{
+		Metrics: make([]carbonapi_v2_pb.FetchResponse, series),
+	}
+	for i := range resp.Metrics {
+		m := carbonapi_v2_pb.FetchResponse{
+			Name:      fmt.Sprintf("foo.bar.%d", i),
+			StartTime: 0,
+			StopTime:  int32(points) * 60,
+			StepTime:  60,
+			Values:    make([]float64, points),
+			IsAbsent:  make([]bool, points),
+		}
+		for j := range m.Values {
+			m.Values[j] = float64(i * j)
+			m.IsAbsent[j] = j%10 == 0
+		}
+		resp.Metrics[i] = m
+	}
+
+	return resp
+}
+
+func BenchmarkRenderDecoder(b *testing.B) {
+	input := renderResponse(10000, 100)
+	blob, err := input.Marshal()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	// the decoding through the generated code, as it was
+	b.Run("generated", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			resp := carbonapi_v2_pb.MultiFetchResponse{}
+			if err := resp.Unmarshal(blob); err != nil {
+				b.Fatal(err)
+			}
+			metrics := make([]types.Metric, len(resp.Metrics))
+			for j, m := range resp.Metrics {
+				metrics[j] = types.Metric{
+					Name:      m.Name,
+					StartTime: m.StartTime,
+					StopTime:  m.StopTime,
+					StepTime:  m.StepTime,
+					Values:    m.Values,
+					IsAbsent:  types.AbsenceOf(m.IsAbsent...),
+				}
+			}
+		}
+	})
+	// the metrics are released once answered, as the zipper does
+	b.Run("direct", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			metrics, err := RenderDecoder(blob)
+			if err != nil {
+				b.Fatal(err)
+			}
+			types.ReleaseMetrics(metrics)
+		}
+	})
+}
@@ -0,0 +1,347 @@
+/*
+Package carbonapi_v3 defines encoding and decoding methods for Find, Info and
+Render requests and responses, as well as for capability negotiation.
+
+It uses version 3 of the carbonapi protocol buffer schema. Unlike version 2,
+requests are sent as protobuf bodies, which allows fetching several targets
+with one call, and timestamps are 64 bit wide.
+*/
+package carbonapi_v3
+
+import (
+	"math"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+// ProtocolName is the name backends use to advertise support of this protocol.
+const ProtocolName = "carbonapi_v3_pb"
+
+// ContentType is the content type of responses encoded with this protocol.
+const ContentType = "application/x-carbonapi-v3-pb"
+
+// ZipperInfoContentType is the content type of info responses encoded with
+// InfoEncoder, which hold the infos of several hosts, rather than those of
+// a single storage host.
+const ZipperInfoContentType = ContentType + "; response=zipper"
+
+func CapabilityRequestEncoder() ([]byte, error) {
+	req := carbonapi_v3_pb.CapabilityRequest{}
+
+	return req.Marshal()
+}
+
+func CapabilityEncoder(name string, protocols []string) ([]byte, error) {
+	out := carbonapi_v3_pb.CapabilityResponse{
+		Name:               name,
+		SupportedProtocols: protocols,
+	}
+
+	return out.Marshal()
+}
+
+// CapabilityDecoder returns the list of protocols supported by a backend.
+func CapabilityDecoder(blob []byte) ([]string, error) {
+	resp := carbonapi_v3_pb.CapabilityResponse{}
+	if err := resp.Unmarshal(blob); err != nil {
+		return nil, err
+	}
+
+	return resp.SupportedProtocols, nil
+}
+
+func FindRequestEncoder(queries []string) ([]byte, error) {
+	req := carbonapi_v3_pb.MultiGlobRequest{
+		Metrics: queries,
+	}
+
+	return req.Marshal()
+}
+
+func FindRequestDecoder(blob []byte) ([]string, error) {
+	req := carbonapi_v3_pb.MultiGlobRequest{}
+	if err := req.Unmarshal(blob); err != nil {
+		return nil, err
+	}
+
+	return req.Metrics, nil
+}
+
+func FindEncoder(matches types.Matches) ([]byte, error) {
+	glob := carbonapi_v3_pb.GlobResponse{
+		Name:    matches.Name,
+		Matches: make([]carbonapi_v3_pb.GlobMatch, len(matches.Matches)),
+	}
+
+	for i, match := range matches.Matches {
+		glob.Matches[i] = carbonapi_v3_pb.GlobMatch{
+			Path:   match.Path,
+			IsLeaf: match.IsLeaf,
+		}
+	}
+
+	out := carbonapi_v3_pb.MultiGlobResponse{
+		Metrics: []carbonapi_v3_pb.GlobResponse{glob},
+	}
+
+	return out.Marshal()
+}
+
+// FindDecoder decodes a multi-glob response. Matches of all the globs in the
+// response are merged together, and the name of the first glob is used.
+func FindDecoder(blob []byte) (types.Matches, error) {
+	f := carbonapi_v3_pb.MultiGlobResponse{}
+	if err := f.Unmarshal(blob); err != nil {
+		return types.Matches{}, err
+	}
+
+	return MatchesFromPB(f), nil
+}
+
+// MatchesFromPB converts a multi-glob response the same way FindDecoder does.
+func MatchesFromPB(f carbonapi_v3_pb.MultiGlobResponse) types.Matches {
+	matches := types.Matches{}
+	for i, glob := range f.Metrics {
+		if i == 0 {
+			matches.Name = glob.Name
+		}
+
+		for _, match := range glob.Matches {
+			matches.Matches = append(matches.Matches, types.Match{
+				Path:   match.Path,
+				IsLeaf: match.IsLeaf,
+			})
+		}
+	}
+
+	return matches
+}
+
+func InfoRequestEncoder(names []string) ([]byte, error) {
+	req := carbonapi_v3_pb.MultiMetricsInfoRequest{
+		Names: names,
+	}
+
+	return req.Marshal()
+}
+
+func InfoRequestDecoder(blob []byte) ([]string, error) {
+	req := carbonapi_v3_pb.MultiMetricsInfoRequest{}
+	if err := req.Unmarshal(blob); err != nil {
+		return nil, err
+	}
+
+	return req.Names, nil
+}
+
+// InfoEncoder encodes infos from several hosts into a zipper info response.
+func InfoEncoder(infos []types.Info) ([]byte, error) {
+	out := carbonapi_v3_pb.ZipperInfoResponse{
+		Info: make(map[string]carbonapi_v3_pb.MultiMetricsInfoResponse),
+	}
+
+	for _, info := range infos {
+		resp := out.Info[info.Host]
+		resp.Metrics = append(resp.Metrics, infoToPB(info))
+		out.Info[info.Host] = resp
+	}
+
+	return out.Marshal()
+}
+
+// InfoDecoder decodes the info response of a single storage host.
+func InfoDecoder(blob []byte, host string) ([]types.Info, error) {
+	resp := carbonapi_v3_pb.MultiMetricsInfoResponse{}
+	if err := resp.Unmarshal(blob); err != nil {
+		return nil, err
+	}
+
+	return InfosFromPB(resp, host), nil
+}
+
+// InfosFromPB converts the info response of a single storage host.
+func InfosFromPB(resp carbonapi_v3_pb.MultiMetricsInfoResponse, host string) []types.Info {
+	infos := make([]types.Info, len(resp.Metrics))
+	for i, m := range resp.Metrics {
+		infos[i] = infoFromPB(m, host)
+	}
+
+	return infos
+}
+
+// MultiInfoDecoder decodes a zipper info response, which holds infos from
+// several hosts.
+func MultiInfoDecoder(blob []byte) ([]types.Info, error) {
+	resp := carbonapi_v3_pb.ZipperInfoResponse{}
+	if err := resp.Unmarshal(blob); err != nil {
+		return nil, err
+	}
+
+	infos := make([]types.Info, 0, len(resp.Info))
+	for host, multi := range resp.Info {
+		for _, m := range multi.Metrics {
+			infos = append(infos, infoFromPB(m, host))
+		}
+	}
+
+	return infos, nil
+}
+
+func infoToPB(info types.Info) carbonapi_v3_pb.MetricsInfoResponse {
+	out := carbonapi_v3_pb.MetricsInfoResponse{
+		Name:              info.Name,
+		ConsolidationFunc: info.AggregationMethod,
+		MaxRetention:      int64(info.MaxRetention),
+		XFilesFactor:      info.XFilesFactor,
+		Retentions:        make([]carbonapi_v3_pb.Retention, len(info.Retentions)),
+	}
+
+	for i, r := range info.Retentions {
+		out.Retentions[i] = carbonapi_v3_pb.Retention{
+			SecondsPerPoint: int64(r.SecondsPerPoint),
+			NumberOfPoints:  int64(r.NumberOfPoints),
+		}
+	}
+
+	return out
+}
+
+func infoFromPB(m carbonapi_v3_pb.MetricsInfoResponse, host string) types.Info {
+	info := types.Info{
+		Host:              host,
+		Name:              m.Name,
+		AggregationMethod: m.ConsolidationFunc,
+		MaxRetention:      int32(m.MaxRetention),
+		XFilesFactor:      m.XFilesFactor,
+		Retentions:        make([]types.Retention, len(m.Retentions)),
+	}
+
+	for i, r := range m.Retentions {
+		info.Retentions[i] = types.Retention{
+			SecondsPerPoint: int32(r.SecondsPerPoint),
+			NumberOfPoints:  int32(r.NumberOfPoints),
+		}
+	}
+
+	return info
+}
+
+func RenderRequestEncoder(targets []string, from int64, until int64) ([]byte, error) {
+	req := RenderRequestToPB(targets, from, until)
+
+	return req.Marshal()
+}
+
+// RenderRequestToPB builds a fetch request for targets over the same time range.
+func RenderRequestToPB(targets []string, from int64, until int64) carbonapi_v3_pb.MultiFetchRequest {
+	req := carbonapi_v3_pb.MultiFetchRequest{
+		Metrics: make([]carbonapi_v3_pb.FetchRequest, len(targets)),
+	}
+
+	for i, target := range targets {
+		req.Metrics[i] = carbonapi_v3_pb.FetchRequest{
+			Name:           target,
+			PathExpression: target,
+			StartTime:      from,
+			StopTime:       until,
+		}
+	}
+
+	return req
+}
+
+// RenderRequestDecoder returns the targets and the time range of a fetch
+// request. The time range of the first target is used for all of them.
+func RenderRequestDecoder(blob []byte) ([]string, int64, int64, error) {
+	req := carbonapi_v3_pb.MultiFetchRequest{}
+	if err := req.Unmarshal(blob); err != nil {
+		return nil, 0, 0, err
+	}
+
+	var from, until int64
+	targets := make([]string, len(req.Metrics))
+	for i, m := range req.Metrics {
+		if i == 0 {
+			from, until = m.StartTime, m.StopTime
+		}
+
+		targets[i] = m.Name
+		if m.PathExpression != "" {
+			targets[i] = m.PathExpression
+		}
+	}
+
+	return targets, from, until, nil
+}
+
+// RenderEncoder encodes metrics. Absent points are sent as NaN, since version
+// 3 of the protocol has no separate absence marker.
+func RenderEncoder(metrics []types.Metric) ([]byte, error) {
+	out := carbonapi_v3_pb.MultiFetchResponse{
+		Metrics: make([]carbonapi_v3_pb.FetchResponse, len(metrics)),
+	}
+
+	for i, m := range metrics {
+		values := types.GetValues(len(m.Values))
+		for j, v := range m.Values {
+			if j < m.IsAbsent.Len() && m.IsAbsent.Get(j) {
+				v = math.NaN()
+			}
+			values[j] = v
+		}
+
+		out.Metrics[i] = carbonapi_v3_pb.FetchResponse{
+			Name:           m.Name,
+			PathExpression: m.Name,
+			StartTime:      int64(m.StartTime),
+			StopTime:       int64(m.StopTime),
+			StepTime:       int64(m.StepTime),
+			Values:         values,
+		}
+	}
+
+	blob, err := out.Marshal()
+	// the values are copied to blob
+	for _, m := range out.Metrics {
+		types.PutValues(m.Values)
+	}
+
+	return blob, err
+}
+
+func RenderDecoder(blob []byte) ([]types.Metric, error) {
+	resp := carbonapi_v3_pb.MultiFetchResponse{}
+	if err := resp.Unmarshal(blob); err != nil {
+		return nil, err
+	}
+
+	return MetricsFromPB(resp), nil
+}
+
+// MetricsFromPB converts a fetch response, marking NaN values as absent.
+func MetricsFromPB(resp carbonapi_v3_pb.MultiFetchResponse) []types.Metric {
+	metrics := make([]types.Metric, len(resp.Metrics))
+	for i, m := range resp.Metrics {
+		metric := types.Metric{
+			Name:      m.Name,
+			StartTime: int32(m.StartTime),
+			StopTime:  int32(m.StopTime),
+			StepTime:  int32(m.StepTime),
+			Values:    m.Values,
+			IsAbsent:  types.NewAbsence(len(m.Values)),
+		}
+
+		for j, v := range metric.Values {
+			if math.IsNaN(v) {
+				metric.Values[j] = 0
+				metric.IsAbsent.Set(j, true)
+			}
+		}
+
+		metrics[i] = metric
+	}
+
+	return metrics
+}
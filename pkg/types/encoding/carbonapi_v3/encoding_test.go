@@ -0,0 +1,262 @@
+package carbonapi_v3
+
+import (
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+
+	"github.com/go-graphite/protocol/carbonapi_v3_pb"
+)
+
+func TestCapabilityRoundTrip(t *testing.T) {
+	blob, err := CapabilityEncoder("go-carbon", []string{"carbonapi_v2_pb", ProtocolName})
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := CapabilityDecoder(blob)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	exp := []string{"carbonapi_v2_pb", ProtocolName}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestResponseFindUnmarshal(t *testing.T) {
+	input := carbonapi_v3_pb.MultiGlobResponse{
+		Metrics: []carbonapi_v3_pb.GlobResponse{
+			{
+				Name: "foo.*",
+				Matches: []carbonapi_v3_pb.GlobMatch{
+					{Path: "foo.bar", IsLeaf: true},
+				},
+			},
+			{
+				Name: "baz.*",
+				Matches: []carbonapi_v3_pb.GlobMatch{
+					{Path: "baz.qux", IsLeaf: false},
+				},
+			},
+		},
+	}
+
+	blob, err := input.Marshal()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := FindDecoder(blob)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	exp := types.Matches{
+		Name: "foo.*",
+		Matches: []types.Match{
+			{Path: "foo.bar", IsLeaf: true},
+			{Path: "baz.qux", IsLeaf: false},
+		},
+	}
+
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestResponseInfoUnmarshal(t *testing.T) {
+	input := carbonapi_v3_pb.MultiMetricsInfoResponse{
+		Metrics: []carbonapi_v3_pb.MetricsInfoResponse{
+			{
+				Name:              "foo",
+				ConsolidationFunc: "average",
+				MaxRetention:      100,
+				XFilesFactor:      0.5,
+				Retentions: []carbonapi_v3_pb.Retention{
+					{SecondsPerPoint: 10, NumberOfPoints: 10},
+				},
+			},
+		},
+	}
+
+	blob, err := input.Marshal()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := InfoDecoder(blob, "localhost")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	exp := []types.Info{
+		{
+			Host:              "localhost",
+			Name:              "foo",
+			AggregationMethod: "average",
+			MaxRetention:      100,
+			XFilesFactor:      0.5,
+			Retentions: []types.Retention{
+				{SecondsPerPoint: 10, NumberOfPoints: 10},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestInfoRoundTrip(t *testing.T) {
+	infos := []types.Info{
+		{
+			Host:       "a",
+			Name:       "foo",
+			Retentions: []types.Retention{{SecondsPerPoint: 60, NumberOfPoints: 1440}},
+		},
+	}
+
+	blob, err := InfoEncoder(infos)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := MultiInfoDecoder(blob)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reflect.DeepEqual(got, infos) {
+		t.Errorf("Expected %v, got %v", infos, got)
+	}
+}
+
+func TestResponseRenderUnmarshal(t *testing.T) {
+	input := carbonapi_v3_pb.MultiFetchResponse{
+		Metrics: []carbonapi_v3_pb.FetchResponse{
+			{
+				Name:      "A",
+				StartTime: 1,
+				StopTime:  2,
+				StepTime:  3,
+				Values:    []float64{math.NaN(), 1},
+			},
+		},
+	}
+
+	blob, err := input.Marshal()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := RenderDecoder(blob)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if len(got) != 1 {
+		t.Errorf("Expected 1 metric, got %d", len(got))
+		return
+	}
+
+	exp := types.Metric{
+		Name:      "A",
+		StartTime: 1,
+		StopTime:  2,
+		StepTime:  3,
+		Values:    []float64{0, 1},
+		IsAbsent:  types.AbsenceOf(true, false),
+	}
+
+	if !types.MetricsEqual(exp, got[0]) {
+		t.Error("Metrics not equal")
+	}
+}
+
+func TestRenderRoundTrip(t *testing.T) {
+	metrics := []types.Metric{
+		{
+			Name:      "A",
+			StartTime: 1,
+			StopTime:  4,
+			StepTime:  1,
+			Values:    []float64{0, 1, 2},
+			IsAbsent:  types.AbsenceOf(false, true, false),
+		},
+	}
+
+	blob, err := RenderEncoder(metrics)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	got, err := RenderDecoder(blob)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	exp := types.Metric{
+		Name:      "A",
+		StartTime: 1,
+		StopTime:  4,
+		StepTime:  1,
+		Values:    []float64{0, 0, 2},
+		IsAbsent:  types.AbsenceOf(false, true, false),
+	}
+
+	if len(got) != 1 || !types.MetricsEqual(exp, got[0]) {
+		t.Errorf("Expected %v, got %v", exp, got)
+	}
+}
+
+func TestRenderRequestRoundTrip(t *testing.T) {
+	blob, err := RenderRequestEncoder([]string{"foo.*", "bar"}, 10, 20)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	targets, from, until, err := RenderRequestDecoder(blob)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !reflect.DeepEqual(targets, []string{"foo.*", "bar"}) || from != 10 || until != 20 {
+		t.Errorf("Unexpected request %v %d %d", targets, from, until)
+	}
+}
+
+func TestRenderRequestRoundTripAfter2038(t *testing.T) {
+	// 2040-01-01 and 2040-01-02, past the end of 32-bit unix time
+	var from, until int64 = 2208988800, 2209075200
+	blob, err := RenderRequestEncoder([]string{"foo"}, from, until)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, gotFrom, gotUntil, err := RenderRequestDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if gotFrom != from || gotUntil != until {
+		t.Errorf("Expected range %d to %d, got %d to %d", from, until, gotFrom, gotUntil)
+	}
+}
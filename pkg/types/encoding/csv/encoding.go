@@ -0,0 +1,58 @@
+/*
+Package csv defines the encoding of Render responses in the CSV format of
+graphite-web, one row per value.
+*/
+package csv
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/bookingcom/carbonapi/pkg/types/encoding/raw"
+)
+
+// RenderEncoder converts metrics data to CSV, as the excel dialect of the
+// csv module of Python writes it for graphite-web: rows of name, time in
+// location and value, ending in CRLF, with names quoted if needed and
+// absent values empty.
+func RenderEncoder(metrics []types.Metric, location *time.Location) []byte {
+	var b []byte
+	for _, metric := range metrics {
+		name := quote(metric.Name)
+		t := metric.StartTime
+		for i, v := range metric.Values {
+			b = append(b, name...)
+			b = append(b, ',')
+
+			tm := time.Unix(int64(t), 0)
+			if location != nil {
+				tm = tm.In(location)
+			}
+			b = tm.AppendFormat(b, "2006-01-02 15:04:05")
+			b = append(b, ',')
+			if !metric.IsAbsent.Get(i) {
+				b = raw.AppendRepr(b, v)
+			}
+			b = append(b, '\r', '\n')
+			t += metric.StepTime
+		}
+	}
+
+	return b
+}
+
+// quote quotes field only if it holds the delimiter, quotes or line breaks,
+// doubling its quotes.
+func quote(field string) []byte {
+	f := []byte(field)
+	if bytes.IndexAny(f, ",\"\r\n") < 0 {
+		return f
+	}
+
+	q := make([]byte, 0, len(f)+2)
+	q = append(q, '"')
+	q = append(q, bytes.ReplaceAll(f, []byte(`"`), []byte(`""`))...)
+
+	return append(q, '"')
+}
@@ -0,0 +1,39 @@
+package csv
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestRenderEncoder(t *testing.T) {
+	metrics := []types.Metric{
+		{
+			Name:      "foo.bar",
+			StartTime: 0,
+			StopTime:  120,
+			StepTime:  60,
+			Values:    []float64{1, 0},
+			IsAbsent:  types.AbsenceOf(false, true),
+		},
+		{
+			Name:      `alias(sumSeries(a,b),"x")`,
+			StartTime: 0,
+			StopTime:  60,
+			StepTime:  60,
+			Values:    []float64{1e-5},
+			IsAbsent:  types.AbsenceOf(false),
+		},
+	}
+
+	// as written by graphite-web
+	want := "foo.bar,1970-01-01 01:00:00,1.0\r\n" +
+		"foo.bar,1970-01-01 01:01:00,\r\n" +
+		`"alias(sumSeries(a,b),""x"")",1970-01-01 01:00:00,1e-05` + "\r\n"
+
+	got := string(RenderEncoder(metrics, time.FixedZone("UTC+1", int(time.Hour/time.Second))))
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
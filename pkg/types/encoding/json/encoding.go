@@ -177,7 +177,7 @@ func RenderEncoder(metrics []types.Metric) ([]byte, error) {
 		for i := range metric.Values {
 			data := make([]interface{}, 2)
 
-			if metric.IsAbsent[i] || math.IsInf(metric.Values[i], 0) || math.IsNaN(metric.Values[i]) {
+			if metric.IsAbsent.Get(i) || math.IsInf(metric.Values[i], 0) || math.IsNaN(metric.Values[i]) {
 				data[0] = nil
 			} else {
 				data[0] = metric.Values[i]
@@ -206,8 +206,8 @@ func RenderDecoder(blob []byte) ([]types.Metric, error) {
 	for _, jm := range jms {
 		metric := types.Metric{
 			Name:     jm.Name,
-			Values:   make([]float64, len(jm.Datapoints)),
-			IsAbsent: make([]bool, len(jm.Datapoints)),
+			Values:   types.GetValues(len(jm.Datapoints)),
+			IsAbsent: types.NewAbsence(len(jm.Datapoints)),
 		}
 
 		for i, pair := range jm.Datapoints {
@@ -228,7 +228,7 @@ func RenderDecoder(blob []byte) ([]types.Metric, error) {
 			str, ok := pair[0].(string)
 			if ok {
 				if str == "null" {
-					metric.IsAbsent[i] = true
+					metric.IsAbsent.Set(i, true)
 					continue
 				} else {
 					return metrics, errors.Errorf("Invalid string value '%s' in JSON", str)
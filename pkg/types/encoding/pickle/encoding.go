@@ -1,22 +1,17 @@
 /*
-Package pickle defines encoding methods for Find and Render responses.
+Package pickle defines encoding methods for Find and Render responses, and a
+decoding method for Find responses.
 
-The package does not define decoding methods or methods for handling Info
-responses:
-
-- The /info endpoint is a carbonapi invention. It's unlikely that any Python
-  stack will know about it.
-
-- For now, we do not expect to talk to Python stores. Patches to let us do that
-  are welcome.
+The package does not define methods for handling Info responses, as the /info
+endpoint is a carbonapi invention. It's unlikely that any Python stack will
+know about it.
 */
 package pickle
 
 import (
 	"bytes"
-	"time"
+	"fmt"
 
-	"github.com/bookingcom/carbonapi/intervalset"
 	"github.com/bookingcom/carbonapi/pkg/types"
 
 	pickle "github.com/lomik/og-rek"
@@ -25,19 +20,8 @@ import (
 // FindEncoderV0_9 encodes a Find response in a format that graphite-web 0.9.x
 // can understand.
 func FindEncoderV0_9(matches types.Matches) ([]byte, error) {
-	// Used to live in cmd/carbonapi/main.go
-	var result []map[string]interface{}
-	for _, m := range matches.Matches {
-		mm := map[string]interface{}{
-			"metric_path": m.Path,
-			"isLeaf":      m.IsLeaf,
-		}
-		result = append(result, mm)
-	}
-
 	var buf bytes.Buffer
-	penc := pickle.NewEncoder(&buf)
-	err := penc.Encode(result)
+	err := FindEncoder{GraphiteWeb09: true}.Encode(&buf, matches)
 
 	return buf.Bytes(), err
 }
@@ -45,31 +29,56 @@ func FindEncoderV0_9(matches types.Matches) ([]byte, error) {
 // FindEncoderV1_0 encodes a Find response in a format that graphite-web 0.1
 // can understand.
 func FindEncoderV1_0(matches types.Matches) ([]byte, error) {
-	// Used to live in cmd/carbonapi/main.go
-	now := int32(time.Now().Unix() + 60)
-	interval := &intervalset.IntervalSet{Start: 0, End: now}
-
-	var result []map[string]interface{}
-	for _, m := range matches.Matches {
-		mm := map[string]interface{}{
-			"is_leaf":   m.IsLeaf,
-			"path":      m.Path,
-			"intervals": interval,
-		}
-		result = append(result, mm)
-	}
-
 	var buf bytes.Buffer
-	penc := pickle.NewEncoder(&buf)
-	err := penc.Encode(result)
+	err := FindEncoder{}.Encode(&buf, matches)
 
 	return buf.Bytes(), err
 }
 
-/* TODO(gmagnusson)
-func FindDecoder(blob []byte) ([]types.Match, error) {
+// FindDecoder decodes a Find response in the format of either graphite-web
+// 0.9.x or 1.0 and later, as encoded by FindEncoderV0_9 and FindEncoderV1_0 or
+// by graphite-web itself. The intervals of the matches are skipped.
+func FindDecoder(blob []byte) (types.Matches, error) {
+	decoded, err := unpickle(blob)
+	if err != nil {
+		return types.Matches{}, err
+	}
+
+	list, ok := decoded.([]interface{})
+	if !ok {
+		return types.Matches{}, fmt.Errorf("unexpected find response of type %T", decoded)
+	}
+
+	matches := types.Matches{
+		Matches: make([]types.Match, 0, len(list)),
+	}
+	for _, item := range list {
+		m, ok := item.(map[interface{}]interface{})
+		if !ok {
+			return types.Matches{}, fmt.Errorf("unexpected find match of type %T", item)
+		}
+
+		path, ok := m["path"].(string)
+		if !ok {
+			path, ok = m["metric_path"].(string)
+		}
+		if !ok {
+			return types.Matches{}, fmt.Errorf("find match without a path: %v", m)
+		}
+
+		isLeaf, ok := m["is_leaf"].(bool)
+		if !ok {
+			isLeaf, _ = m["isLeaf"].(bool)
+		}
+
+		matches.Matches = append(matches.Matches, types.Match{
+			Path:   path,
+			IsLeaf: isLeaf,
+		})
+	}
+
+	return matches, nil
 }
-*/
 
 // RenderEncoder encodes a Render response in a format graphite-web can understand.
 func RenderEncoder(metrics []types.Metric) ([]byte, error) {
@@ -79,7 +88,7 @@ func RenderEncoder(metrics []types.Metric) ([]byte, error) {
 	for _, metric := range metrics {
 		values := make([]interface{}, len(metric.Values))
 		for i, v := range metric.Values {
-			if metric.IsAbsent[i] {
+			if metric.IsAbsent.Get(i) {
 				values[i] = pickle.None{}
 			} else {
 				values[i] = v
@@ -0,0 +1,161 @@
+package pickle
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestFindDecoder(t *testing.T) {
+	matches := types.Matches{
+		Matches: []types.Match{
+			{Path: "foo.bar", IsLeaf: false},
+			{Path: "foo.baz", IsLeaf: true},
+		},
+	}
+
+	encoders := map[string]func(types.Matches) ([]byte, error){
+		"0.9": FindEncoderV0_9,
+		"1.0": FindEncoderV1_0,
+	}
+	for version, encode := range encoders {
+		blob, err := encode(matches)
+		if err != nil {
+			t.Fatalf("%s: %v", version, err)
+		}
+
+		got, err := FindDecoder(blob)
+		if err != nil {
+			t.Fatalf("%s: %v", version, err)
+		}
+		if !cmp.Equal(got, matches) {
+			t.Errorf("%s: %s", version, cmp.Diff(matches, got))
+		}
+	}
+}
+
+// TestFindDecoderGraphiteWeb decodes matches with intervals, as pickled by
+// Python with protocols 0 and 2.
+func TestFindDecoderGraphiteWeb(t *testing.T) {
+	matches := types.Matches{
+		Matches: []types.Match{
+			{Path: "foo.bar", IsLeaf: false},
+			{Path: "foo.baz", IsLeaf: true},
+		},
+	}
+
+	blobs := map[string]string{
+		"0": "(lp0\n(dp1\nVpath\np2\nVfoo.bar\np3\nsVis_leaf\np4\nI00\nsVintervals\np5\nccopy_reg\n_reconstructor\np6\n(cgraphite.intervals\nIntervalSet\np7\nc__builtin__\nobject\np8\nNtp9\nRp10\n(dp11\ng5\n(lp12\ng6\n(cgraphite.intervals\nInterval\np13\ng8\nNtp14\nRp15\n(dp16\nVstart\np17\nF1.0\nsVend\np18\nF2.0\nsVtuple\np19\n(F1.0\nF2.0\ntp20\nsVsize\np21\nF1.0\nsbasg21\nF1.0\nsbsa(dp22\ng2\nVfoo.baz\np23\nsg4\nI01\nsg5\ng10\nsa.",
+		"2": "\x80\x02]q\x00(}q\x01(X\x04\x00\x00\x00pathq\x02X\x07\x00\x00\x00foo.barq\x03X\x07\x00\x00\x00is_leafq\x04\x89X\t\x00\x00\x00intervalsq\x05cgraphite.intervals\nIntervalSet\nq\x06)\x81q\x07}q\x08(h\x05]q\tcgraphite.intervals\nInterval\nq\n)\x81q\x0b}q\x0c(X\x05\x00\x00\x00startq\rG?\xf0\x00\x00\x00\x00\x00\x00X\x03\x00\x00\x00endq\x0eG@\x00\x00\x00\x00\x00\x00\x00X\x05\x00\x00\x00tupleq\x0fG?\xf0\x00\x00\x00\x00\x00\x00G@\x00\x00\x00\x00\x00\x00\x00\x86q\x10X\x04\x00\x00\x00sizeq\x11G?\xf0\x00\x00\x00\x00\x00\x00ubah\x11G?\xf0\x00\x00\x00\x00\x00\x00ubu}q\x12(h\x02X\x07\x00\x00\x00foo.bazq\x13h\x04\x88h\x05h\x07ue.",
+	}
+	for protocol, blob := range blobs {
+		got, err := FindDecoder([]byte(blob))
+		if err != nil {
+			t.Fatalf("protocol %s: %v", protocol, err)
+		}
+		if !cmp.Equal(got, matches) {
+			t.Errorf("protocol %s: %s", protocol, cmp.Diff(matches, got))
+		}
+	}
+}
+
+func TestFindDecoderInvalid(t *testing.T) {
+	blobs := []string{
+		"not a pickle",
+		"]",             // no STOP
+		"](U\x10foo.",   // a string past the end
+		"]q\x00h\x00a.", // a list that contains itself
+	}
+	for _, blob := range blobs {
+		if _, err := FindDecoder([]byte(blob)); err == nil {
+			t.Errorf("%q: expected an error", blob)
+		}
+	}
+}
+
+func TestUnpickleSharedLists(t *testing.T) {
+	// each list holds the previous one twice, as memoised: 2^30 lists if
+	// they were resolved at every reference
+	var blob bytes.Buffer
+	blob.WriteString("]q\x00")
+	for i := 0; i < 30; i++ {
+		blob.WriteString("(h\x00h\x00lq\x00")
+	}
+	blob.WriteString(".")
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := unpickle(blob.Bytes())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("shared lists are resolved once per reference")
+	}
+}
+
+func TestFindEncoderProtocols(t *testing.T) {
+	matches := types.Matches{
+		Matches: []types.Match{
+			{Path: "foo.bar", IsLeaf: false},
+			{Path: "foo.baz", IsLeaf: true},
+		},
+	}
+
+	for _, protocol := range []int{0, 2, 3} {
+		var buf bytes.Buffer
+		if err := (FindEncoder{Protocol: protocol, GraphiteWeb09: true}).Encode(&buf, matches); err != nil {
+			t.Fatalf("protocol %d: %v", protocol, err)
+		}
+		if blob := buf.Bytes(); protocol > 0 && (blob[0] != opProto || int(blob[1]) != protocol) {
+			t.Errorf("protocol %d: unexpected header %q", protocol, blob[:2])
+		}
+
+		got, err := FindDecoder(buf.Bytes())
+		if err != nil {
+			t.Fatalf("protocol %d: %v", protocol, err)
+		}
+		if !cmp.Equal(got, matches) {
+			t.Errorf("protocol %d: %s", protocol, cmp.Diff(matches, got))
+		}
+	}
+
+	if err := (FindEncoder{Protocol: 1}).Validate(); err == nil {
+		t.Error("expected protocol 1 to be unsupported")
+	}
+}
+
+func TestFindEncoderMaxSize(t *testing.T) {
+	matches := types.Matches{
+		Matches: []types.Match{
+			{Path: "foo.bar", IsLeaf: true},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := (FindEncoder{GraphiteWeb09: true}).Encode(&buf, matches); err != nil {
+		t.Fatal(err)
+	}
+	size := buf.Len()
+
+	buf.Reset()
+	if err := (FindEncoder{MaxSize: size, GraphiteWeb09: true}).Encode(&buf, matches); err != nil {
+		t.Errorf("expected a response of %d bytes to fit, got %v", size, err)
+	}
+
+	buf.Reset()
+	err := FindEncoder{MaxSize: size - 1, GraphiteWeb09: true}.Encode(&buf, matches)
+	if tooLarge, ok := err.(ErrTooLarge); !ok || tooLarge.Size != size {
+		t.Errorf("expected ErrTooLarge of %d bytes, got %v", size, err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected nothing to be written, got %d bytes", buf.Len())
+	}
+}
@@ -0,0 +1,195 @@
+package pickle
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bookingcom/carbonapi/intervalset"
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// Opcodes of the find responses, as named in pickletools.py.
+const (
+	opMark           byte = '('
+	opStop           byte = '.'
+	opBinstring      byte = 'T'
+	opShortBinstring byte = 'U'
+	opBinunicode     byte = 'X'
+	opEmptyDict      byte = '}'
+	opAppends        byte = 'e'
+	opEmptyList      byte = ']'
+	opSetitems       byte = 'u'
+	opProto          byte = '\x80'
+	opNewtrue        byte = '\x88'
+	opNewfalse       byte = '\x89'
+)
+
+// Booleans before protocol 2, see INT in pickletools.py.
+const (
+	opTrue  = "I01\n"
+	opFalse = "I00\n"
+)
+
+// ErrTooLarge is the error of a response that would take more bytes to
+// encode than the limit of its encoder.
+type ErrTooLarge struct {
+	Size  int
+	Limit int
+}
+
+func (err ErrTooLarge) Error() string {
+	return fmt.Sprintf("pickle of %d bytes exceeds the limit of %d bytes", err.Size, err.Limit)
+}
+
+// FindEncoder encodes Find responses for graphite-web, straight to a writer
+// rather than in one buffer.
+type FindEncoder struct {
+	// Protocol is the pickle protocol, 2 or 3. 0 leaves the protocol out,
+	// which is how graphite-web has always been answered, and which any
+	// version of Python can load. Protocol 3 encodes paths as unicode, for
+	// graphite-web on Python 3.
+	Protocol int
+	// MaxSize is the most bytes a response is encoded in, 0 for no limit.
+	MaxSize int
+	// GraphiteWeb09 encodes the matches the way graphite-web 0.9.x
+	// understands, rather than 1.0 and later.
+	GraphiteWeb09 bool
+}
+
+// Validate tells if the encoder is configured with a protocol it supports.
+func (enc FindEncoder) Validate() error {
+	switch enc.Protocol {
+	case 0, 2, 3:
+	default:
+		return fmt.Errorf("unsupported pickle protocol %d, it must be 0, 2 or 3", enc.Protocol)
+	}
+	if enc.MaxSize < 0 {
+		return fmt.Errorf("invalid pickle size limit %d, it must not be negative", enc.MaxSize)
+	}
+
+	return nil
+}
+
+// Encode writes matches to w. A response larger than MaxSize is measured
+// before anything is written, and is not written at all: ErrTooLarge is
+// returned instead.
+func (enc FindEncoder) Encode(w io.Writer, matches types.Matches) error {
+	if err := enc.Validate(); err != nil {
+		return err
+	}
+
+	var intervals []byte
+	if !enc.GraphiteWeb09 {
+		now := int32(time.Now().Unix() + 60)
+		intervals, _ = (&intervalset.IntervalSet{Start: 0, End: now}).MarshalPickle()
+	}
+
+	if enc.MaxSize > 0 {
+		var size sizeWriter
+		p := &pickler{w: &size, protocol: enc.Protocol}
+		enc.encode(p, matches, intervals)
+		if size > sizeWriter(enc.MaxSize) {
+			return ErrTooLarge{Size: int(size), Limit: enc.MaxSize}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	p := &pickler{w: bw, protocol: enc.Protocol}
+	enc.encode(p, matches, intervals)
+	if p.err != nil {
+		return p.err
+	}
+
+	return bw.Flush()
+}
+
+func (enc FindEncoder) encode(p *pickler, matches types.Matches, intervals []byte) {
+	if enc.Protocol >= 2 {
+		p.write(opProto, byte(enc.Protocol))
+	}
+
+	p.write(opEmptyList, opMark)
+	for _, m := range matches.Matches {
+		p.write(opEmptyDict, opMark)
+		if enc.GraphiteWeb09 {
+			p.string("metric_path")
+			p.string(m.Path)
+			p.string("isLeaf")
+			p.bool(m.IsLeaf)
+		} else {
+			p.string("is_leaf")
+			p.bool(m.IsLeaf)
+			p.string("path")
+			p.string(m.Path)
+			p.string("intervals")
+			p.write(intervals...)
+		}
+		p.write(opSetitems)
+	}
+	p.write(opAppends, opStop)
+}
+
+// pickler writes opcodes, keeping the first error of its writer.
+type pickler struct {
+	w        io.Writer
+	protocol int
+	err      error
+}
+
+func (p *pickler) write(b ...byte) {
+	if p.err == nil {
+		_, p.err = p.w.Write(b)
+	}
+}
+
+func (p *pickler) writeString(s string) {
+	if p.err == nil {
+		_, p.err = io.WriteString(p.w, s)
+	}
+}
+
+func (p *pickler) bool(b bool) {
+	switch {
+	case p.protocol >= 2 && b:
+		p.write(opNewtrue)
+	case p.protocol >= 2:
+		p.write(opNewfalse)
+	case b:
+		p.writeString(opTrue)
+	default:
+		p.writeString(opFalse)
+	}
+}
+
+func (p *pickler) string(s string) {
+	var header [5]byte
+	switch {
+	case p.protocol >= 3:
+		header[0] = opBinunicode
+		binary.LittleEndian.PutUint32(header[1:], uint32(len(s)))
+		p.write(header[:]...)
+	case len(s) < 256:
+		p.write(opShortBinstring, byte(len(s)))
+	default:
+		header[0] = opBinstring
+		binary.LittleEndian.PutUint32(header[1:], uint32(len(s)))
+		p.write(header[:]...)
+	}
+	p.writeString(s)
+}
+
+// sizeWriter counts the bytes written to it, and drops them.
+type sizeWriter int
+
+func (w *sizeWriter) Write(b []byte) (int, error) {
+	*w += sizeWriter(len(b))
+	return len(b), nil
+}
+
+func (w *sizeWriter) WriteString(s string) (int, error) {
+	*w += sizeWriter(len(s))
+	return len(s), nil
+}
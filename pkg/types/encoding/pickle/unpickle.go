@@ -0,0 +1,644 @@
+package pickle
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// More opcodes, those that only responses encoded by Python use.
+const (
+	opPop             byte = '0'
+	opPopMark         byte = '1'
+	opDup             byte = '2'
+	opFloat           byte = 'F'
+	opInt             byte = 'I'
+	opBinint          byte = 'J'
+	opBinint1         byte = 'K'
+	opLong            byte = 'L'
+	opBinint2         byte = 'M'
+	opNone            byte = 'N'
+	opReduce          byte = 'R'
+	opString          byte = 'S'
+	opUnicode         byte = 'V'
+	opAppend          byte = 'a'
+	opBuild           byte = 'b'
+	opGlobal          byte = 'c'
+	opDict            byte = 'd'
+	opGet             byte = 'g'
+	opBinget          byte = 'h'
+	opLongBinget      byte = 'j'
+	opList            byte = 'l'
+	opObj             byte = 'o'
+	opPut             byte = 'p'
+	opBinput          byte = 'q'
+	opLongBinput      byte = 'r'
+	opSetitem         byte = 's'
+	opTuple           byte = 't'
+	opEmptyTuple      byte = ')'
+	opBinfloat        byte = 'G'
+	opNewobj          byte = '\x81'
+	opTuple1          byte = '\x85'
+	opTuple2          byte = '\x86'
+	opTuple3          byte = '\x87'
+	opLong1           byte = '\x8a'
+	opShortBinunicode byte = '\x8c'
+	opStackGlobal     byte = '\x93'
+	opMemoize         byte = '\x94'
+	opFrame           byte = '\x95'
+)
+
+// mark is pushed by MARK, to delimit the items of the opcodes that take
+// them all.
+type mark struct{}
+
+// object is an instance of a Python class. Find responses only have them
+// for their intervals, which are not decoded.
+type object struct{}
+
+// list is a list being decoded, which items may be appended to after it is
+// memoised.
+type list struct {
+	items []interface{}
+}
+
+// unpickle decodes the pickle in blob into slices, maps, strings, bools,
+// int64s, float64s and nils. Instances of classes are decoded as object.
+func unpickle(blob []byte) (interface{}, error) {
+	u := unpickler{
+		r:    bytes.NewReader(blob),
+		memo: make(map[int]interface{}),
+	}
+
+	for {
+		op, err := u.r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		if op == opStop {
+			v, err := u.pop()
+			if err != nil {
+				return nil, err
+			}
+			return resolve(v, 0)
+		}
+		if err := u.step(op); err != nil {
+			return nil, fmt.Errorf("pickle opcode %q: %w", op, err)
+		}
+	}
+}
+
+type unpickler struct {
+	r     *bytes.Reader
+	stack []interface{}
+	memo  map[int]interface{}
+}
+
+var errStackUnderflow = errors.New("stack underflow")
+
+func (u *unpickler) step(op byte) error {
+	switch op {
+	case opProto:
+		_, err := u.r.ReadByte()
+		return err
+	case opFrame:
+		_, err := u.read(8)
+		return err
+	case opMark:
+		u.push(mark{})
+	case opPop:
+		_, err := u.pop()
+		return err
+	case opPopMark:
+		_, err := u.popMark()
+		return err
+	case opDup:
+		v, err := u.top()
+		if err != nil {
+			return err
+		}
+		u.push(v)
+
+	case opNone:
+		u.push(nil)
+	case opNewtrue:
+		u.push(true)
+	case opNewfalse:
+		u.push(false)
+	case opInt:
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		switch line {
+		case "00":
+			u.push(false)
+		case "01":
+			u.push(true)
+		default:
+			i, err := strconv.ParseInt(line, 10, 64)
+			if err != nil {
+				return err
+			}
+			u.push(i)
+		}
+	case opLong:
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		if len(line) > 0 && line[len(line)-1] == 'L' {
+			line = line[:len(line)-1]
+		}
+		i, err := strconv.ParseInt(line, 10, 64)
+		if err != nil {
+			return err
+		}
+		u.push(i)
+	case opBinint:
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		u.push(int64(int32(binary.LittleEndian.Uint32(b))))
+	case opBinint1:
+		b, err := u.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		u.push(int64(b))
+	case opBinint2:
+		b, err := u.read(2)
+		if err != nil {
+			return err
+		}
+		u.push(int64(binary.LittleEndian.Uint16(b)))
+	case opLong1:
+		n, err := u.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		if n > 8 {
+			return fmt.Errorf("long of %d bytes", n)
+		}
+		b, err := u.read(int(n))
+		if err != nil {
+			return err
+		}
+		var i int64
+		for j := len(b) - 1; j >= 0; j-- {
+			i = i<<8 | int64(b[j])
+		}
+		if n > 0 && n < 8 && b[n-1]&0x80 != 0 {
+			i -= 1 << (8 * uint(n))
+		}
+		u.push(i)
+	case opFloat:
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(line, 64)
+		if err != nil {
+			return err
+		}
+		u.push(f)
+	case opBinfloat:
+		b, err := u.read(8)
+		if err != nil {
+			return err
+		}
+		u.push(math.Float64frombits(binary.BigEndian.Uint64(b)))
+
+	case opShortBinstring, opShortBinunicode:
+		n, err := u.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		return u.pushString(int(n))
+	case opBinstring, opBinunicode:
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		return u.pushString(int(binary.LittleEndian.Uint32(b)))
+	case opString:
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		s, err := unquote(line)
+		if err != nil {
+			return err
+		}
+		u.push(s)
+	case opUnicode:
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		str, err := rawUnicodeUnescape(line)
+		if err != nil {
+			return err
+		}
+		u.push(str)
+
+	case opEmptyList, opEmptyTuple:
+		u.push(&list{})
+	case opEmptyDict:
+		u.push(make(map[interface{}]interface{}))
+	case opList, opTuple:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		u.push(&list{items: items})
+	case opTuple1, opTuple2, opTuple3:
+		n := int(op-opTuple1) + 1
+		if len(u.stack) < n {
+			return errStackUnderflow
+		}
+		items := append([]interface{}(nil), u.stack[len(u.stack)-n:]...)
+		u.stack = u.stack[:len(u.stack)-n]
+		u.push(&list{items: items})
+	case opDict:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		d := make(map[interface{}]interface{})
+		if err := setItems(d, items); err != nil {
+			return err
+		}
+		u.push(d)
+	case opAppend:
+		v, err := u.pop()
+		if err != nil {
+			return err
+		}
+		return u.appendItems([]interface{}{v})
+	case opAppends:
+		items, err := u.popMark()
+		if err != nil {
+			return err
+		}
+		return u.appendItems(items)
+	case opSetitem, opSetitems:
+		var items []interface{}
+		var err error
+		if op == opSetitem {
+			if len(u.stack) < 2 {
+				return errStackUnderflow
+			}
+			items = append(items, u.stack[len(u.stack)-2:]...)
+			u.stack = u.stack[:len(u.stack)-2]
+		} else if items, err = u.popMark(); err != nil {
+			return err
+		}
+		v, err := u.top()
+		if err != nil {
+			return err
+		}
+		d, ok := v.(map[interface{}]interface{})
+		if !ok {
+			return fmt.Errorf("setting items of a %T", v)
+		}
+		return setItems(d, items)
+
+	case opPut:
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		i, err := strconv.Atoi(line)
+		if err != nil {
+			return err
+		}
+		return u.put(i)
+	case opBinput:
+		b, err := u.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		return u.put(int(b))
+	case opLongBinput:
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		return u.put(int(binary.LittleEndian.Uint32(b)))
+	case opMemoize:
+		return u.put(len(u.memo))
+	case opGet:
+		line, err := u.line()
+		if err != nil {
+			return err
+		}
+		i, err := strconv.Atoi(line)
+		if err != nil {
+			return err
+		}
+		return u.get(i)
+	case opBinget:
+		b, err := u.r.ReadByte()
+		if err != nil {
+			return err
+		}
+		return u.get(int(b))
+	case opLongBinget:
+		b, err := u.read(4)
+		if err != nil {
+			return err
+		}
+		return u.get(int(binary.LittleEndian.Uint32(b)))
+
+	case opGlobal:
+		// module and name, which do not matter to instances never decoded
+		for i := 0; i < 2; i++ {
+			if _, err := u.line(); err != nil {
+				return err
+			}
+		}
+		u.push(object{})
+	case opStackGlobal:
+		if len(u.stack) < 2 {
+			return errStackUnderflow
+		}
+		u.stack = u.stack[:len(u.stack)-2]
+		u.push(object{})
+	case opObj:
+		if _, err := u.popMark(); err != nil {
+			return err
+		}
+		u.push(object{})
+	case opReduce, opNewobj:
+		if len(u.stack) < 2 {
+			return errStackUnderflow
+		}
+		u.stack = u.stack[:len(u.stack)-2]
+		u.push(object{})
+	case opBuild:
+		// the state of the instance, left on top of it
+		_, err := u.pop()
+		return err
+
+	default:
+		return errors.New("unsupported opcode")
+	}
+
+	return nil
+}
+
+func (u *unpickler) push(v interface{}) {
+	u.stack = append(u.stack, v)
+}
+
+func (u *unpickler) top() (interface{}, error) {
+	if len(u.stack) == 0 {
+		return nil, errStackUnderflow
+	}
+
+	return u.stack[len(u.stack)-1], nil
+}
+
+func (u *unpickler) pop() (interface{}, error) {
+	v, err := u.top()
+	if err == nil {
+		u.stack = u.stack[:len(u.stack)-1]
+	}
+
+	return v, err
+}
+
+// popMark pops the items down to the last mark, and the mark.
+func (u *unpickler) popMark() ([]interface{}, error) {
+	for i := len(u.stack) - 1; i >= 0; i-- {
+		if _, ok := u.stack[i].(mark); ok {
+			items := append([]interface{}(nil), u.stack[i+1:]...)
+			u.stack = u.stack[:i]
+			return items, nil
+		}
+	}
+
+	return nil, errors.New("no mark")
+}
+
+// appendItems appends items to the list on top of the stack.
+func (u *unpickler) appendItems(items []interface{}) error {
+	v, err := u.top()
+	if err != nil {
+		return err
+	}
+	switch l := v.(type) {
+	case *list:
+		l.items = append(l.items, items...)
+	case object:
+		// the items of an instance, as the intervals of an IntervalSet
+	default:
+		return fmt.Errorf("appending to a %T", v)
+	}
+
+	return nil
+}
+
+func (u *unpickler) put(i int) error {
+	v, err := u.top()
+	if err == nil {
+		u.memo[i] = v
+	}
+
+	return err
+}
+
+func (u *unpickler) get(i int) error {
+	v, ok := u.memo[i]
+	if !ok {
+		return fmt.Errorf("memo %d not set", i)
+	}
+	u.push(v)
+
+	return nil
+}
+
+func (u *unpickler) read(n int) ([]byte, error) {
+	if n > u.r.Len() {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err := io.ReadFull(u.r, b)
+
+	return b, err
+}
+
+func (u *unpickler) pushString(n int) error {
+	b, err := u.read(n)
+	if err != nil {
+		return err
+	}
+	u.push(string(b))
+
+	return nil
+}
+
+// line reads up to a newline, which it drops.
+func (u *unpickler) line() (string, error) {
+	var b []byte
+	for {
+		c, err := u.r.ReadByte()
+		if err != nil {
+			return "", io.ErrUnexpectedEOF
+		}
+		if c == '\n' {
+			return string(b), nil
+		}
+		b = append(b, c)
+	}
+}
+
+// maxDepth bounds the nesting of lists and dicts, which may contain
+// themselves.
+const maxDepth = 32
+
+// resolver replaces the lists of a decoded pickle by their items. Lists and
+// dicts may be memoised and referred to any number of times, so each is only
+// resolved once: a pickle of lists of the same list twice, nested, would
+// otherwise take time and memory exponential in its size.
+type resolver struct {
+	lists map[*list][]interface{}
+	dicts map[uintptr]bool
+}
+
+func resolve(v interface{}, depth int) (interface{}, error) {
+	r := resolver{
+		lists: make(map[*list][]interface{}),
+		dicts: make(map[uintptr]bool),
+	}
+
+	return r.resolve(v, depth)
+}
+
+func (r *resolver) resolve(v interface{}, depth int) (interface{}, error) {
+	if depth > maxDepth {
+		return nil, fmt.Errorf("pickle nested more than %d deep", maxDepth)
+	}
+
+	switch v := v.(type) {
+	case *list:
+		if items, ok := r.lists[v]; ok {
+			return items, nil
+		}
+		items := make([]interface{}, len(v.items))
+		for i, item := range v.items {
+			var err error
+			if items[i], err = r.resolve(item, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		r.lists[v] = items
+		return items, nil
+	case map[interface{}]interface{}:
+		p := reflect.ValueOf(v).Pointer()
+		if r.dicts[p] {
+			return v, nil
+		}
+		for k, item := range v {
+			var err error
+			if v[k], err = r.resolve(item, depth+1); err != nil {
+				return nil, err
+			}
+		}
+		r.dicts[p] = true
+	}
+
+	return v, nil
+}
+
+// setItems sets the keys and values, alternated in items, of d.
+func setItems(d map[interface{}]interface{}, items []interface{}) error {
+	if len(items)%2 != 0 {
+		return errors.New("odd number of dict items")
+	}
+	for i := 0; i < len(items); i += 2 {
+		switch items[i].(type) {
+		case string, bool, int64, float64, nil:
+		default:
+			return fmt.Errorf("dict key of type %T", items[i])
+		}
+		d[items[i]] = items[i+1]
+	}
+
+	return nil
+}
+
+// unquote decodes the repr of a Python 2 string, in single or double quotes.
+func unquote(s string) (string, error) {
+	if len(s) < 2 || s[0] != s[len(s)-1] || (s[0] != '\'' && s[0] != '"') {
+		return "", fmt.Errorf("invalid string %s", s)
+	}
+	if s[0] == '"' {
+		return strconv.Unquote(s)
+	}
+
+	var b bytes.Buffer
+	b.WriteByte('"')
+	for i := 1; i < len(s)-1; i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s)-1 && s[i+1] == '\'':
+			b.WriteByte('\'')
+			i++
+		case s[i] == '\\' && i+1 < len(s)-1:
+			b.WriteByte(s[i])
+			b.WriteByte(s[i+1])
+			i++
+		case s[i] == '"':
+			b.WriteString(`\"`)
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	b.WriteByte('"')
+
+	return strconv.Unquote(b.String())
+}
+
+// rawUnicodeUnescape decodes s as Python encodes the strings of UNICODE, in
+// raw-unicode-escape: bytes are Latin-1, and the other characters, along with
+// backslashes and newlines, are escaped as \uXXXX or \UXXXXXXXX.
+func rawUnicodeUnescape(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		n := 0
+		if s[i] == '\\' && i+1 < len(s) {
+			switch s[i+1] {
+			case 'u':
+				n = 4
+			case 'U':
+				n = 8
+			}
+		}
+		if n == 0 {
+			b.WriteRune(rune(s[i]))
+			continue
+		}
+		if i+2+n > len(s) {
+			return "", fmt.Errorf("truncated escape in %q", s)
+		}
+		r, err := strconv.ParseUint(s[i+2:i+2+n], 16, 32)
+		if err != nil {
+			return "", err
+		}
+		b.WriteRune(rune(r))
+		i += 1 + n
+	}
+
+	return b.String(), nil
+}
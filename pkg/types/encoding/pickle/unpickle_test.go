@@ -0,0 +1,52 @@
+package pickle
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestUnpickle(t *testing.T) {
+	tests := []struct {
+		name string
+		blob string
+		want interface{}
+	}{
+		{"protocol 0 ints", "(lp0\nI1\naL-2L\naI00\naI01\naNa.", []interface{}{int64(1), int64(-2), false, true, nil}},
+		{"protocol 0 strings", "(S'a\\'b'\np0\nVcd\np1\nt.", []interface{}{"a'b", "cd"}},
+		{"protocol 0 unicode", "Vfoo.\\u00e9\\u005c\\u000a\\U0001f600\xe9\n.", "foo.é\\\n😀é"},
+		{"protocol 2 numbers", "\x80\x02]q\x00(K\x01M\x00\x01J\xff\xff\xff\xff\x8a\x01\x80G?\xf0\x00\x00\x00\x00\x00\x00e.", []interface{}{int64(1), int64(256), int64(-1), int64(-128), 1.0}},
+		{"protocol 2 memo", "\x80\x02}q\x00(X\x01\x00\x00\x00aq\x01h\x01X\x01\x00\x00\x00bq\x02\x88u.", map[interface{}]interface{}{"a": "a", "b": true}},
+		// instances, like the intervals of graphite-web, are not decoded
+		{"protocol 2 object", "\x80\x02cgraphite.intervals\nIntervalSet\nq\x00)\x81q\x01}q\x02X\x01\x00\x00\x00aK\x01sb.", object{}},
+		{"protocol 0 object", "ccopy_reg\n_reconstructor\np0\n(cgraphite.intervals\nIntervalSet\np1\nc__builtin__\nobject\np2\nNtp3\nRp4\n(dp5\nVa\nI1\nsb.", object{}},
+	}
+
+	for _, tt := range tests {
+		got, err := unpickle([]byte(tt.blob))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !cmp.Equal(got, tt.want) {
+			t.Errorf("%s: %s", tt.name, cmp.Diff(tt.want, got))
+		}
+	}
+}
+
+func TestUnpickleInvalid(t *testing.T) {
+	blobs := []string{
+		"",
+		"]",             // no STOP
+		"](U\x10foo.",   // a string past the end
+		"h\x00.",        // a memo that is not set
+		"0.",            // a stack underflow
+		"Vfoo\\u00\n.",  // a truncated escape
+		"]q\x00h\x00a.", // a list that contains itself
+	}
+	for _, blob := range blobs {
+		if _, err := unpickle([]byte(blob)); err == nil {
+			t.Errorf("%q: expected an error", blob)
+		}
+	}
+}
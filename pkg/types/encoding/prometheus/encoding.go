@@ -0,0 +1,251 @@
+/*
+Package prometheus defines decoding and encoding methods for the requests and
+responses of the remote read protocol of Prometheus.
+
+Both are protocol buffers compressed with snappy, in its block format. Only
+the samples response type is supported, not the streamed chunks.
+*/
+package prometheus
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+)
+
+// ContentType is the content type of remote read requests and responses.
+const ContentType = "application/x-protobuf"
+
+// ContentEncoding is the content encoding of remote read requests and
+// responses.
+const ContentEncoding = "snappy"
+
+// MatchType is how a label matcher compares the values of its label.
+type MatchType int32
+
+const (
+	// MatchEqual matches the values equal to the value of the matcher.
+	MatchEqual MatchType = 0
+	// MatchNotEqual matches the values other than the value of the matcher.
+	MatchNotEqual MatchType = 1
+	// MatchRegexp matches the values the value of the matcher, a regular
+	// expression, matches in full.
+	MatchRegexp MatchType = 2
+	// MatchNotRegexp matches the values MatchRegexp does not.
+	MatchNotRegexp MatchType = 3
+)
+
+// Matcher selects series by the value of their label Name.
+type Matcher struct {
+	Type  MatchType
+	Name  string
+	Value string
+}
+
+// Query asks for the samples of the series its matchers all select, between
+// the unix times in milliseconds Start and End.
+type Query struct {
+	Start    int64
+	End      int64
+	Matchers []Matcher
+}
+
+// Label is a label of a series.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// Sample is a value of a series at a unix time in milliseconds.
+type Sample struct {
+	Value     float64
+	Timestamp int64
+}
+
+// Series is a series of samples, identified by its labels.
+type Series struct {
+	Labels  []Label
+	Samples []Sample
+}
+
+// ReadRequestDecoder decodes the queries of a remote read request.
+func ReadRequestDecoder(blob []byte) ([]Query, error) {
+	b, err := snappy.Decode(nil, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	req := readRequest{}
+	if err := proto.Unmarshal(b, &req); err != nil {
+		return nil, err
+	}
+
+	queries := make([]Query, 0, len(req.Queries))
+	for _, q := range req.Queries {
+		query := Query{
+			Start:    q.StartTimestampMs,
+			End:      q.EndTimestampMs,
+			Matchers: make([]Matcher, 0, len(q.Matchers)),
+		}
+		for _, m := range q.Matchers {
+			query.Matchers = append(query.Matchers, Matcher{Type: m.Type, Name: m.Name, Value: m.Value})
+		}
+		queries = append(queries, query)
+	}
+
+	return queries, nil
+}
+
+// ReadRequestEncoder encodes the queries of a remote read request.
+func ReadRequestEncoder(queries []Query) ([]byte, error) {
+	req := readRequest{Queries: make([]*query, 0, len(queries))}
+	for _, q := range queries {
+		pq := &query{StartTimestampMs: q.Start, EndTimestampMs: q.End}
+		for _, m := range q.Matchers {
+			pq.Matchers = append(pq.Matchers, &labelMatcher{Type: m.Type, Name: m.Name, Value: m.Value})
+		}
+		req.Queries = append(req.Queries, pq)
+	}
+
+	b, err := proto.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+
+	return snappy.Encode(nil, b), nil
+}
+
+// ReadResponseEncoder encodes the response to a remote read request: the
+// series of each of its queries, in the order of the queries.
+func ReadResponseEncoder(results [][]Series) ([]byte, error) {
+	resp := readResponse{Results: make([]*queryResult, 0, len(results))}
+	for _, series := range results {
+		res := &queryResult{Timeseries: make([]*timeSeries, 0, len(series))}
+		for _, s := range series {
+			ts := &timeSeries{
+				Labels:  make([]*label, 0, len(s.Labels)),
+				Samples: make([]*sample, 0, len(s.Samples)),
+			}
+			for _, l := range s.Labels {
+				ts.Labels = append(ts.Labels, &label{Name: l.Name, Value: l.Value})
+			}
+			for _, p := range s.Samples {
+				ts.Samples = append(ts.Samples, &sample{Value: p.Value, Timestamp: p.Timestamp})
+			}
+			res.Timeseries = append(res.Timeseries, ts)
+		}
+		resp.Results = append(resp.Results, res)
+	}
+
+	b, err := proto.Marshal(&resp)
+	if err != nil {
+		return nil, err
+	}
+
+	return snappy.Encode(nil, b), nil
+}
+
+// ReadResponseDecoder decodes the series of each of the queries of a remote
+// read response.
+func ReadResponseDecoder(blob []byte) ([][]Series, error) {
+	b, err := snappy.Decode(nil, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := readResponse{}
+	if err := proto.Unmarshal(b, &resp); err != nil {
+		return nil, err
+	}
+
+	results := make([][]Series, 0, len(resp.Results))
+	for _, res := range resp.Results {
+		series := make([]Series, 0, len(res.Timeseries))
+		for _, ts := range res.Timeseries {
+			s := Series{}
+			for _, l := range ts.Labels {
+				s.Labels = append(s.Labels, Label{Name: l.Name, Value: l.Value})
+			}
+			for _, p := range ts.Samples {
+				s.Samples = append(s.Samples, Sample{Value: p.Value, Timestamp: p.Timestamp})
+			}
+			series = append(series, s)
+		}
+		results = append(results, series)
+	}
+
+	return results, nil
+}
+
+// The messages of prometheus/prompb/remote.proto and types.proto that the
+// samples response type uses.
+
+type readRequest struct {
+	Queries []*query `protobuf:"bytes,1,rep,name=queries,proto3"`
+}
+
+func (m *readRequest) Reset()         { *m = readRequest{} }
+func (m *readRequest) String() string { return proto.CompactTextString(m) }
+func (*readRequest) ProtoMessage()    {}
+
+type query struct {
+	StartTimestampMs int64           `protobuf:"varint,1,opt,name=start_timestamp_ms,proto3"`
+	EndTimestampMs   int64           `protobuf:"varint,2,opt,name=end_timestamp_ms,proto3"`
+	Matchers         []*labelMatcher `protobuf:"bytes,3,rep,name=matchers,proto3"`
+}
+
+func (m *query) Reset()         { *m = query{} }
+func (m *query) String() string { return proto.CompactTextString(m) }
+func (*query) ProtoMessage()    {}
+
+type labelMatcher struct {
+	Type  MatchType `protobuf:"varint,1,opt,name=type,proto3"`
+	Name  string    `protobuf:"bytes,2,opt,name=name,proto3"`
+	Value string    `protobuf:"bytes,3,opt,name=value,proto3"`
+}
+
+func (m *labelMatcher) Reset()         { *m = labelMatcher{} }
+func (m *labelMatcher) String() string { return proto.CompactTextString(m) }
+func (*labelMatcher) ProtoMessage()    {}
+
+type readResponse struct {
+	Results []*queryResult `protobuf:"bytes,1,rep,name=results,proto3"`
+}
+
+func (m *readResponse) Reset()         { *m = readResponse{} }
+func (m *readResponse) String() string { return proto.CompactTextString(m) }
+func (*readResponse) ProtoMessage()    {}
+
+type queryResult struct {
+	Timeseries []*timeSeries `protobuf:"bytes,1,rep,name=timeseries,proto3"`
+}
+
+func (m *queryResult) Reset()         { *m = queryResult{} }
+func (m *queryResult) String() string { return proto.CompactTextString(m) }
+func (*queryResult) ProtoMessage()    {}
+
+type timeSeries struct {
+	Labels  []*label  `protobuf:"bytes,1,rep,name=labels,proto3"`
+	Samples []*sample `protobuf:"bytes,2,rep,name=samples,proto3"`
+}
+
+func (m *timeSeries) Reset()         { *m = timeSeries{} }
+func (m *timeSeries) String() string { return proto.CompactTextString(m) }
+func (*timeSeries) ProtoMessage()    {}
+
+type label struct {
+	Name  string `protobuf:"bytes,1,opt,name=name,proto3"`
+	Value string `protobuf:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *label) Reset()         { *m = label{} }
+func (m *label) String() string { return proto.CompactTextString(m) }
+func (*label) ProtoMessage()    {}
+
+type sample struct {
+	Value     float64 `protobuf:"fixed64,1,opt,name=value,proto3"`
+	Timestamp int64   `protobuf:"varint,2,opt,name=timestamp,proto3"`
+}
+
+func (m *sample) Reset()         { *m = sample{} }
+func (m *sample) String() string { return proto.CompactTextString(m) }
+func (*sample) ProtoMessage()    {}
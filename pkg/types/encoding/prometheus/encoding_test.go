@@ -0,0 +1,67 @@
+package prometheus
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+
+	"github.com/golang/snappy"
+)
+
+func TestReadRequestDecoder(t *testing.T) {
+	// a ReadRequest of prompb, encoded by Prometheus
+	matcher := append([]byte{0x12, 0x08}, "__name__"...)
+	matcher = append(matcher, 0x1a, 0x02, 'u', 'p')
+	query := append([]byte{0x08, 0x01, 0x10, 0x02, 0x1a, byte(len(matcher))}, matcher...)
+	blob := snappy.Encode(nil, append([]byte{0x0a, byte(len(query))}, query...))
+
+	got, err := ReadRequestDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Query{{Start: 1, End: 2, Matchers: []Matcher{{Type: MatchEqual, Name: "__name__", Value: "up"}}}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+
+	reencoded, err := ReadRequestEncoder(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reencoded, blob) {
+		t.Errorf("expected the request to be encoded back as it was, got %x", reencoded)
+	}
+}
+
+func TestReadRequestDecoderInvalid(t *testing.T) {
+	if _, err := ReadRequestDecoder([]byte("not snappy")); err == nil {
+		t.Error("expected an error")
+	}
+}
+
+func TestReadResponseEncoder(t *testing.T) {
+	results := [][]Series{
+		{
+			{
+				Labels:  []Label{{Name: "__name__", Value: "up"}, {Name: "job", Value: "node"}},
+				Samples: []Sample{{Value: 1, Timestamp: 60000}, {Value: 0.5, Timestamp: 120000}},
+			},
+		},
+		{},
+	}
+
+	blob, err := ReadResponseEncoder(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadResponseDecoder(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := [][]Series{results[0], {}}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %+v, got %+v", expected, got)
+	}
+}
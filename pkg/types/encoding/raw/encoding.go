@@ -0,0 +1,71 @@
+/*
+Package raw defines the encoding of Render responses in the raw format of
+graphite-web, one line of values per series.
+*/
+package raw
+
+import (
+	"bytes"
+	"math"
+	"strconv"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+// RenderEncoder converts metrics data to the raw format of graphite-web, as
+// in name,start,stop,step|1.0,None,2.5 for each metric.
+func RenderEncoder(metrics []types.Metric) []byte {
+	var b []byte
+	for _, metric := range metrics {
+		b = append(b, metric.Name...)
+		b = append(b, ',')
+		b = strconv.AppendInt(b, int64(metric.StartTime), 10)
+		b = append(b, ',')
+		b = strconv.AppendInt(b, int64(metric.StopTime), 10)
+		b = append(b, ',')
+		b = strconv.AppendInt(b, int64(metric.StepTime), 10)
+		b = append(b, '|')
+
+		for i, v := range metric.Values {
+			if i > 0 {
+				b = append(b, ',')
+			}
+			if metric.IsAbsent.Get(i) {
+				b = append(b, "None"...)
+			} else {
+				b = AppendRepr(b, v)
+			}
+		}
+		b = append(b, '\n')
+	}
+
+	return b
+}
+
+// AppendRepr appends v as the repr of Python formats it, which graphite-web
+// writes values with: the shortest representation, in exponent notation for
+// exponents below -4 and from 16 on, and with .0 for integral values.
+func AppendRepr(b []byte, v float64) []byte {
+	switch {
+	case math.IsNaN(v):
+		return append(b, "nan"...)
+	case math.IsInf(v, 1):
+		return append(b, "inf"...)
+	case math.IsInf(v, -1):
+		return append(b, "-inf"...)
+	}
+
+	var buf [32]byte
+	e := strconv.AppendFloat(buf[:0], v, 'e', -1, 64)
+	if exp, _ := strconv.Atoi(string(e[bytes.LastIndexByte(e, 'e')+1:])); exp < -4 || exp >= 16 {
+		return append(b, e...)
+	}
+
+	start := len(b)
+	b = strconv.AppendFloat(b, v, 'f', -1, 64)
+	if bytes.IndexByte(b[start:], '.') < 0 {
+		b = append(b, ".0"...)
+	}
+
+	return b
+}
@@ -0,0 +1,61 @@
+package raw
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/pkg/types"
+)
+
+func TestRenderEncoder(t *testing.T) {
+	metrics := []types.Metric{
+		{
+			Name:      "foo.bar",
+			StartTime: 100,
+			StopTime:  400,
+			StepTime:  100,
+			Values:    []float64{1, 0, 2.5},
+			IsAbsent:  types.AbsenceOf(false, true, false),
+		},
+		{
+			Name:      "sumSeries(a.*,b)",
+			StartTime: 100,
+			StopTime:  100,
+			StepTime:  100,
+		},
+	}
+
+	got := string(RenderEncoder(metrics))
+	want := "foo.bar,100,400,100|1.0,None,2.5\nsumSeries(a.*,b),100,100,100|\n"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestAppendRepr(t *testing.T) {
+	// as printed by repr in Python
+	for v, want := range map[float64]string{
+		0:                    "0.0",
+		math.Copysign(0, -1): "-0.0",
+		1.5:                  "1.5",
+		0.1:                  "0.1",
+		1e-4:                 "0.0001",
+		1e-5:                 "1e-05",
+		1.5e-7:               "1.5e-07",
+		1234567890123456:     "1234567890123456.0",
+		9999999999999998:     "9999999999999998.0",
+		1e16:                 "1e+16",
+		12345678901234567:    "1.2345678901234568e+16",
+		-3.25:                "-3.25",
+		1.0 / 3:              "0.3333333333333333",
+		math.Inf(1):          "inf",
+		math.Inf(-1):         "-inf",
+	} {
+		if got := string(AppendRepr(nil, v)); got != want {
+			t.Errorf("AppendRepr(%v) = %s, expected %s", v, got, want)
+		}
+	}
+	if got := string(AppendRepr([]byte("x="), math.NaN())); got != "x=nan" {
+		t.Errorf("expected x=nan, got %s", got)
+	}
+}
@@ -0,0 +1,100 @@
+package types
+
+import (
+	"math/bits"
+	"sync"
+	"sync/atomic"
+)
+
+// The values of metrics are pooled by the power of two of their capacity,
+// from 1<<minPoolClass to 1<<maxPoolClass points: smaller slices are cheaper
+// to allocate than to pool, and larger ones are too rare to keep.
+const (
+	minPoolClass = 6
+	maxPoolClass = 22
+)
+
+var valuesPools [maxPoolClass - minPoolClass + 1]sync.Pool
+
+var poolStats struct {
+	gets, hits, puts int64
+}
+
+// PoolStats counts the use of the pool of values since the start.
+type PoolStats struct {
+	// Gets is the number of slices asked for.
+	Gets int64
+	// Hits is the number of slices reused from the pool.
+	Hits int64
+	// Puts is the number of slices given back to the pool.
+	Puts int64
+}
+
+// GetPoolStats returns the counts of the pool of values.
+func GetPoolStats() PoolStats {
+	return PoolStats{
+		Gets: atomic.LoadInt64(&poolStats.gets),
+		Hits: atomic.LoadInt64(&poolStats.hits),
+		Puts: atomic.LoadInt64(&poolStats.puts),
+	}
+}
+
+// GetValues returns n zero values, reusing a slice given back with
+// PutValues if there is one of the capacity.
+func GetValues(n int) []float64 {
+	atomic.AddInt64(&poolStats.gets, 1)
+
+	// the smallest class that holds n points
+	class := bits.Len(uint(n - 1))
+	if n <= 1 {
+		class = 0
+	}
+	if class < minPoolClass {
+		class = minPoolClass
+	}
+	if class > maxPoolClass {
+		return make([]float64, n)
+	}
+
+	if p, ok := valuesPools[class-minPoolClass].Get().(*[]float64); ok {
+		atomic.AddInt64(&poolStats.hits, 1)
+		values := (*p)[:n]
+		for i := range values {
+			values[i] = 0
+		}
+
+		return values
+	}
+
+	return make([]float64, n, 1<<uint(class))
+}
+
+// PutValues gives values back to the pool, for GetValues to reuse. The
+// values must not be used after.
+func PutValues(values []float64) {
+	// the largest class that values holds
+	class := bits.Len(uint(cap(values))) - 1
+	if class < minPoolClass || class > maxPoolClass {
+		return
+	}
+
+	atomic.AddInt64(&poolStats.puts, 1)
+	values = values[:0]
+	valuesPools[class-minPoolClass].Put(&values)
+}
+
+// ReleaseMetrics gives the values of metrics back to the pool once they are
+// answered, and clears them. Only the metrics of a single request can be
+// released, not those shared with others, as the render of carbonapi shares
+// the fetches in flight.
+func ReleaseMetrics(metrics []Metric) {
+	for i := range metrics {
+		PutValues(metrics[i].Values)
+		metrics[i].Values = nil
+	}
+}
+
+// sameValues tells if a and b are the same values, rather than equal ones.
+func sameValues(a, b []float64) bool {
+	return cap(a) > 0 && cap(b) > 0 && &a[:1][0] == &b[:1][0]
+}
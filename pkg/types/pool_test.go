@@ -0,0 +1,65 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+
+	"go.uber.org/zap"
+)
+
+func TestGetValues(t *testing.T) {
+	for _, n := range []int{0, 1, 63, 64, 65, 1000, 1 << maxPoolClass, 1<<maxPoolClass + 1} {
+		values := GetValues(n)
+		if len(values) != n {
+			t.Errorf("expected %d values, got %d", n, len(values))
+		}
+		for i := range values {
+			values[i] = 1
+		}
+		PutValues(values)
+	}
+
+	// a slice given back is zeroed when reused
+	values := GetValues(100)
+	for i := range values {
+		values[i] = 1
+	}
+	PutValues(values)
+	for i := 0; i < 10; i++ {
+		for _, v := range GetValues(100) {
+			if v != 0 {
+				t.Fatalf("expected zero values, got %v", v)
+			}
+		}
+	}
+}
+
+func TestPutValuesSmall(t *testing.T) {
+	before := GetPoolStats()
+	PutValues(make([]float64, 10))
+	PutValues(nil)
+	if after := GetPoolStats(); after.Puts != before.Puts {
+		t.Errorf("expected small slices to be dropped, got %d puts", after.Puts-before.Puts)
+	}
+}
+
+func TestMergeMetricsReleasesReplicas(t *testing.T) {
+	first, second := make([]float64, 3, 64), make([]float64, 3, 64)
+	copy(second, []float64{1, 2, 3})
+	metrics := [][]Metric{
+		{{Name: "foo", StepTime: 1, Values: first, IsAbsent: AllAbsent(3)}},
+		{{Name: "foo", StepTime: 1, Values: second, IsAbsent: NewAbsence(3)}},
+		// the same values answered twice are released once
+		{{Name: "foo", StepTime: 1, Values: second, IsAbsent: NewAbsence(3)}},
+	}
+
+	before := GetPoolStats()
+	merged, _ := MergeMetrics(metrics, cfg.RenderReplicaMismatchConfig{RenderReplicaMatchMode: cfg.ReplicaMatchModeNormal}, zap.NewNop())
+	if len(merged) != 1 || merged[0].Values[2] != 3 || merged[0].IsAbsent.Get(2) {
+		t.Fatalf("unexpected merged metrics %+v", merged)
+	}
+	if after := GetPoolStats(); after.Puts-before.Puts != 1 {
+		t.Errorf("expected the values of one replica to be released, got %d puts", after.Puts-before.Puts)
+	}
+}
@@ -0,0 +1,116 @@
+package types
+
+import (
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+// SanitizeStats counts what SanitizeMetrics fixed in the metrics of a
+// backend.
+type SanitizeStats struct {
+	// Invalid is the number of metrics dropped, for a step that is not
+	// positive or for as many values as absent flags.
+	Invalid int
+	// Misaligned is the number of metrics whose stop time was fixed.
+	Misaligned int
+	// Duplicates is the number of points answered more than once.
+	Duplicates int
+}
+
+// SanitizeMetrics checks the metrics of a backend before they are merged
+// with those of other backends, and returns them fixed. Metrics with an
+// invalid step are dropped, the stop times that do not follow from the start
+// time, step and values are fixed, and the metrics answered more than once
+// are merged into one, the points they both have resolved by policy.
+// Duplicates with another step, or not aligned to that of the first one,
+// are dropped.
+func SanitizeMetrics(metrics []Metric, policy cfg.DuplicatePolicy) ([]Metric, SanitizeStats) {
+	var stats SanitizeStats
+	sanitized := make([]Metric, 0, len(metrics))
+	byName := make(map[string]int, len(metrics))
+	for _, m := range metrics {
+		if m.StepTime <= 0 || len(m.Values) != m.IsAbsent.Len() {
+			stats.Invalid++
+			continue
+		}
+		if stop := m.StartTime + int32(len(m.Values))*m.StepTime; m.StopTime != stop {
+			m.StopTime = stop
+			stats.Misaligned++
+		}
+
+		i, ok := byName[m.Name]
+		if !ok {
+			byName[m.Name] = len(sanitized)
+			sanitized = append(sanitized, m)
+			continue
+		}
+
+		first := sanitized[i]
+		if m.StepTime != first.StepTime || (m.StartTime-first.StartTime)%m.StepTime != 0 {
+			stats.Duplicates += len(m.Values)
+			continue
+		}
+		var duplicates int
+		sanitized[i], duplicates = mergeDuplicates(first, m, policy)
+		stats.Duplicates += duplicates
+	}
+
+	return sanitized, stats
+}
+
+// mergeDuplicates merges metrics of the same name and step answered by the
+// same backend, over the union of their ranges.
+func mergeDuplicates(first, second Metric, policy cfg.DuplicatePolicy) (Metric, int) {
+	step := first.StepTime
+	start, stop := first.StartTime, first.StopTime
+	if second.StartTime < start {
+		start = second.StartTime
+	}
+	if second.StopTime > stop {
+		stop = second.StopTime
+	}
+
+	n := int((stop - start) / step)
+	merged := first
+	merged.StartTime = start
+	merged.StopTime = stop
+	merged.Values = GetValues(n)
+	merged.IsAbsent = AllAbsent(n)
+
+	var duplicates int
+	for _, m := range []Metric{first, second} {
+		offset := int((m.StartTime - start) / step)
+		for j, v := range m.Values {
+			if m.IsAbsent.Get(j) {
+				continue
+			}
+
+			i := offset + j
+			if merged.IsAbsent.Get(i) {
+				merged.Values[i] = v
+				merged.IsAbsent.Set(i, false)
+				continue
+			}
+
+			duplicates++
+			switch policy {
+			case cfg.DuplicatePolicyLast:
+				merged.Values[i] = v
+			case cfg.DuplicatePolicyMax:
+				if v > merged.Values[i] {
+					merged.Values[i] = v
+				}
+			case cfg.DuplicatePolicyMin:
+				if v < merged.Values[i] {
+					merged.Values[i] = v
+				}
+			}
+		}
+	}
+
+	PutValues(first.Values)
+	if !sameValues(first.Values, second.Values) {
+		PutValues(second.Values)
+	}
+
+	return merged, duplicates
+}
@@ -0,0 +1,93 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/bookingcom/carbonapi/cfg"
+)
+
+func TestSanitizeMetrics(t *testing.T) {
+	metrics := []Metric{
+		{
+			Name:      "foo",
+			StartTime: 0,
+			StopTime:  4,
+			StepTime:  1,
+			Values:    []float64{1, 2, 0, 4},
+			IsAbsent:  AbsenceOf(false, false, true, false),
+		},
+		{
+			Name:      "bar",
+			StartTime: 0,
+			StopTime:  3,
+			StepTime:  0,
+			Values:    []float64{1, 2, 3},
+			IsAbsent:  AbsenceOf(false, false, false),
+		},
+		{
+			// a misaligned stop time
+			Name:      "baz",
+			StartTime: 0,
+			StopTime:  10,
+			StepTime:  1,
+			Values:    []float64{1, 2},
+			IsAbsent:  AbsenceOf(false, false),
+		},
+		{
+			// answered again, overlapping and extending the first
+			Name:      "foo",
+			StartTime: 2,
+			StopTime:  6,
+			StepTime:  1,
+			Values:    []float64{3, 5, 5, 6},
+			IsAbsent:  AbsenceOf(false, false, false, false),
+		},
+		{
+			// answered again with another step
+			Name:      "foo",
+			StartTime: 0,
+			StopTime:  4,
+			StepTime:  2,
+			Values:    []float64{7, 7},
+			IsAbsent:  AbsenceOf(false, false),
+		},
+	}
+
+	tests := []struct {
+		policy cfg.DuplicatePolicy
+		foo    []float64
+	}{
+		{cfg.DuplicatePolicyFirst, []float64{1, 2, 3, 4, 5, 6}},
+		{cfg.DuplicatePolicyLast, []float64{1, 2, 3, 5, 5, 6}},
+		{cfg.DuplicatePolicyMax, []float64{1, 2, 3, 5, 5, 6}},
+		{cfg.DuplicatePolicyMin, []float64{1, 2, 3, 4, 5, 6}},
+	}
+
+	for _, tst := range tests {
+		t.Run(string(tst.policy), func(t *testing.T) {
+			got, stats := SanitizeMetrics(metrics, tst.policy)
+
+			expectedStats := SanitizeStats{Invalid: 1, Misaligned: 1, Duplicates: 3}
+			if stats != expectedStats {
+				t.Errorf("expected stats %+v, got %+v", expectedStats, stats)
+			}
+			if len(got) != 2 || got[0].Name != "foo" || got[1].Name != "baz" {
+				t.Fatalf("expected foo and baz, got %+v", got)
+			}
+
+			foo := got[0]
+			if foo.StartTime != 0 || foo.StopTime != 6 || !reflect.DeepEqual(foo.Values, tst.foo) {
+				t.Errorf("expected foo from 0 to 6 with %v, got %+v", tst.foo, foo)
+			}
+			if got[1].StopTime != 2 {
+				t.Errorf("expected the stop time of baz to be fixed, got %d", got[1].StopTime)
+			}
+		})
+	}
+
+	// the metrics of the backend are left as they are
+	if metrics[0].StopTime != 4 || metrics[2].StopTime != 10 {
+		t.Errorf("expected the metrics not to be changed, got %+v", metrics)
+	}
+}
@@ -17,7 +17,9 @@ import (
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
+	"go.opentelemetry.io/otel/api/kv"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
 )
 
 var (
@@ -34,6 +36,47 @@ func (err ErrNotFound) Error() string {
 	return string(err)
 }
 
+// ErrBadRequest signals the HTTP bad request error: the backend can not
+// answer the request as it is, e.g. because of a bad query.
+type ErrBadRequest string
+
+// Error makes ErrBadRequest compliant with the error interface
+func (err ErrBadRequest) Error() string {
+	return string(err)
+}
+
+// ErrForbidden signals the HTTP forbidden error: the backend refuses the
+// request, e.g. because it is beyond its limits.
+type ErrForbidden string
+
+// Error makes ErrForbidden compliant with the error interface
+func (err ErrForbidden) Error() string {
+	return string(err)
+}
+
+// ErrBackendOverloaded signals the HTTP too many requests and service
+// unavailable errors: the backend throttles or sheds requests for now, and
+// the request may be retried after RetryAfter, zero if it did not tell.
+type ErrBackendOverloaded struct {
+	Message    string
+	RetryAfter time.Duration
+}
+
+// Error makes ErrBackendOverloaded compliant with the error interface
+func (err ErrBackendOverloaded) Error() string {
+	return err.Message
+}
+
+// RetryAfterSeconds returns the Retry-After, in whole seconds and at least
+// one, to answer a request that failed with err.
+func (err ErrBackendOverloaded) RetryAfterSeconds() int {
+	seconds := int((err.RetryAfter + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
+
 // TODO (grzkv): Move to separate file
 
 type FindRequest struct {
@@ -60,14 +103,16 @@ func NewInfoRequest(target string) InfoRequest {
 	}
 }
 
+// RenderRequest asks for the datapoints of Targets between the unix times
+// From and Until.
 type RenderRequest struct {
 	Targets []string
-	From    int32
-	Until   int32
+	From    int64
+	Until   int64
 	Trace
 }
 
-func NewRenderRequest(targets []string, from int32, until int32) RenderRequest {
+func NewRenderRequest(targets []string, from int64, until int64) RenderRequest {
 	return RenderRequest{
 		Targets: targets,
 		From:    from,
@@ -85,7 +130,12 @@ type Trace struct {
 	inHTTPCallNS  *int64
 	inReadBodyNS  *int64
 	inUnmarshalNS *int64
-	OutDuration   *prometheus.HistogramVec
+	inMergeNS     *int64
+	// mismatches and fixedMismatches are the datapoints of replicas that
+	// backends reported mismatched, and how many of them they fixed.
+	mismatches      *int64
+	fixedMismatches *int64
+	OutDuration     *prometheus.HistogramVec
 }
 
 func (t Trace) ObserveOutDuration(ti time.Duration, dc string, cluster string) {
@@ -94,20 +144,63 @@ func (t Trace) ObserveOutDuration(ti time.Duration, dc string, cluster string) {
 	}
 }
 
-func (t Trace) Report() []int64 {
+// TraceReport tells where the time of a request went. The stages of the
+// calls to backends are averaged over the calls.
+type TraceReport struct {
+	Calls int64
+	// Marshal is the time spent encoding the request to a backend.
+	Marshal time.Duration
+	// Queue is the time spent waiting for the concurrency limit of a
+	// backend.
+	Queue time.Duration
+	// Fetch is the time spent on the call to a backend, reading its
+	// response included.
+	Fetch time.Duration
+	// Decode is the time spent decoding the response of a backend.
+	Decode time.Duration
+	// Merge is the time spent merging the responses of the backends.
+	Merge time.Duration
+}
+
+// MarshalLogObject logs the report as an object of durations.
+func (r TraceReport) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt64("calls", r.Calls)
+	enc.AddDuration("marshal_seconds", r.Marshal)
+	enc.AddDuration("queue_seconds", r.Queue)
+	enc.AddDuration("fetch_seconds", r.Fetch)
+	enc.AddDuration("decode_seconds", r.Decode)
+	enc.AddDuration("merge_seconds", r.Merge)
+
+	return nil
+}
+
+// KeyValues returns the report as the attributes of a span.
+func (r TraceReport) KeyValues() []kv.KeyValue {
+	return []kv.KeyValue{
+		kv.Int64("trace.calls", r.Calls),
+		kv.Float64("trace.marshal_seconds", r.Marshal.Seconds()),
+		kv.Float64("trace.queue_seconds", r.Queue.Seconds()),
+		kv.Float64("trace.fetch_seconds", r.Fetch.Seconds()),
+		kv.Float64("trace.decode_seconds", r.Decode.Seconds()),
+		kv.Float64("trace.merge_seconds", r.Merge.Seconds()),
+	}
+}
+
+// Report returns where the time of the request went so far.
+func (t Trace) Report() TraceReport {
 	n := int64(1)
-	c := *t.callCount
+	c := atomic.LoadInt64(t.callCount)
 	if c > 0 {
 		n = c
 	}
 
-	return []int64{
-		c,
-		*t.inMarshalNS / n,
-		*t.inLimiterNS / n,
-		*t.inHTTPCallNS / n,
-		*t.inReadBodyNS / n,
-		*t.inUnmarshalNS / n,
+	return TraceReport{
+		Calls:   c,
+		Marshal: time.Duration(atomic.LoadInt64(t.inMarshalNS) / n),
+		Queue:   time.Duration(atomic.LoadInt64(t.inLimiterNS) / n),
+		Fetch:   time.Duration((atomic.LoadInt64(t.inHTTPCallNS) + atomic.LoadInt64(t.inReadBodyNS)) / n),
+		Decode:  time.Duration(atomic.LoadInt64(t.inUnmarshalNS) / n),
+		Merge:   time.Duration(atomic.LoadInt64(t.inMergeNS)),
 	}
 }
 
@@ -140,14 +233,35 @@ func (t Trace) AddUnmarshal(start time.Time) {
 	atomic.AddInt64(t.inUnmarshalNS, int64(d))
 }
 
+func (t Trace) AddMerge(start time.Time) {
+	d := time.Since(start)
+	atomic.AddInt64(t.inMergeNS, int64(d))
+}
+
+// AddMismatches counts the datapoints of replicas a backend reported
+// mismatched, and how many of them it fixed.
+func (t Trace) AddMismatches(mismatches, fixed int64) {
+	atomic.AddInt64(t.mismatches, mismatches)
+	atomic.AddInt64(t.fixedMismatches, fixed)
+}
+
+// Mismatches returns the mismatched datapoints counted, and how many of them
+// were fixed.
+func (t Trace) Mismatches() (int64, int64) {
+	return atomic.LoadInt64(t.mismatches), atomic.LoadInt64(t.fixedMismatches)
+}
+
 func NewTrace() Trace {
 	return Trace{
-		callCount:     new(int64),
-		inMarshalNS:   new(int64),
-		inLimiterNS:   new(int64),
-		inHTTPCallNS:  new(int64),
-		inReadBodyNS:  new(int64),
-		inUnmarshalNS: new(int64),
+		callCount:       new(int64),
+		inMarshalNS:     new(int64),
+		inLimiterNS:     new(int64),
+		inHTTPCallNS:    new(int64),
+		inReadBodyNS:    new(int64),
+		inUnmarshalNS:   new(int64),
+		inMergeNS:       new(int64),
+		mismatches:      new(int64),
+		fixedMismatches: new(int64),
 	}
 }
 
@@ -186,7 +300,7 @@ type Metric struct {
 	StopTime  int32
 	StepTime  int32
 	Values    []float64
-	IsAbsent  []bool
+	IsAbsent  Absence
 }
 
 // MetricRenderStats represents the stats of rendering and merging metrics.
@@ -194,11 +308,16 @@ type MetricRenderStats struct {
 	DataPointCount     int
 	MismatchCount      int
 	FixedMismatchCount int
+	// Sanitized is what was fixed in the metrics of each backend, by its
+	// address, with RenderSanitize.
+	Sanitized map[string]SanitizeStats
 }
 
 // MergeMetrics merges metrics by name.
 // It returns merged metrics, number of rendered data points for the returned metrics,
 // and number of mismatched data points seen (if mismatchCheck is true).
+// The values of the metrics not returned are given back to the pool of
+// values, so metrics must not be used after.
 func MergeMetrics(metrics [][]Metric, replicaMismatchConfig cfg.RenderReplicaMismatchConfig, logger *zap.Logger) ([]Metric, MetricRenderStats) {
 	if len(metrics) == 0 {
 		return nil, MetricRenderStats{}
@@ -365,7 +484,7 @@ func mergeMetrics(metrics []Metric, replicaMismatchConfig cfg.RenderReplicaMisma
 	valuesForPoint := make([]float64, 0, len(metrics))
 	isMismatchFindConfig := replicaMatchMode != cfg.ReplicaMatchModeNormal
 	for i := range metric.Values {
-		pointExists := !metric.IsAbsent[i]
+		pointExists := !metric.IsAbsent.Get(i)
 		shouldLookForMismatch := isMismatchFindConfig
 		mismatchObserved := false
 		valuesForPoint = valuesForPoint[:0]
@@ -382,14 +501,14 @@ func mergeMetrics(metrics []Metric, replicaMismatchConfig cfg.RenderReplicaMisma
 				break
 			}
 
-			if m.IsAbsent[i] {
+			if m.IsAbsent.Get(i) {
 				continue
 			}
 
 			valuesForPoint = append(valuesForPoint, m.Values[i])
 
 			if !pointExists {
-				metric.IsAbsent[i] = m.IsAbsent[i]
+				metric.IsAbsent.Set(i, m.IsAbsent.Get(i))
 				metric.Values[i] = m.Values[i]
 				healed++
 				pointExists = true
@@ -418,6 +537,18 @@ func mergeMetrics(metrics []Metric, replicaMismatchConfig cfg.RenderReplicaMisma
 		}
 	}
 
+	// the values of the other replicas are not needed anymore, unless they
+	// are the same as those of another
+	for j := 1; j < len(metrics); j++ {
+		shared := false
+		for _, m := range metrics[:j] {
+			shared = shared || sameValues(m.Values, metrics[j].Values)
+		}
+		if !shared {
+			PutValues(metrics[j].Values)
+		}
+	}
+
 	return metric, MetricRenderStats{
 		DataPointCount:     len(metric.Values),
 		MismatchCount:      mismatches,
@@ -508,13 +639,13 @@ func MetricsEqual(a, b Metric) bool {
 		a.StopTime != b.StopTime ||
 		a.StepTime != b.StepTime ||
 		len(a.Values) != len(b.Values) ||
-		len(a.IsAbsent) != len(b.IsAbsent) ||
-		len(a.Values) != len(a.IsAbsent) {
+		a.IsAbsent.Len() != b.IsAbsent.Len() ||
+		len(a.Values) != a.IsAbsent.Len() {
 		return false
 	}
 
 	for i := 0; i < len(a.Values); i++ {
-		if a.Values[i] != b.Values[i] || a.IsAbsent[i] != b.IsAbsent[i] {
+		if a.Values[i] != b.Values[i] || a.IsAbsent.Get(i) != b.IsAbsent.Get(i) {
 			return false
 		}
 	}
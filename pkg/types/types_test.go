@@ -6,6 +6,7 @@ import (
 	"math"
 	"sort"
 	"testing"
+	"time"
 )
 
 func TestMergeInfos(t *testing.T) {
@@ -83,14 +84,14 @@ func TestMergeManyMetricsWithNormal(t *testing.T) {
 			Metric{
 				Name:     "metric",
 				Values:   []float64{0},
-				IsAbsent: []bool{true},
+				IsAbsent: AbsenceOf(true),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{1},
-				IsAbsent: []bool{false},
+				IsAbsent: AbsenceOf(false),
 			},
 		},
 	}
@@ -98,7 +99,7 @@ func TestMergeManyMetricsWithNormal(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1},
-		IsAbsent: []bool{false},
+		IsAbsent: AbsenceOf(false),
 	}
 
 	logger := zap.NewNop()
@@ -118,14 +119,14 @@ func TestMergeManyMismatchedMetricsWithCheck(t *testing.T) {
 			Metric{
 				Name:     "metric",
 				Values:   []float64{2, 1},
-				IsAbsent: []bool{false, false},
+				IsAbsent: AbsenceOf(false, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{1, 1},
-				IsAbsent: []bool{false, false},
+				IsAbsent: AbsenceOf(false, false),
 			},
 		},
 	}
@@ -133,7 +134,7 @@ func TestMergeManyMismatchedMetricsWithCheck(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{2, 1},
-		IsAbsent: []bool{false, false},
+		IsAbsent: AbsenceOf(false, false),
 	}
 
 	logger := zap.NewNop()
@@ -165,14 +166,14 @@ func TestMergeManyMismatchedMetricsWithMajority(t *testing.T) {
 			Metric{
 				Name:     "metric",
 				Values:   []float64{2, 1},
-				IsAbsent: []bool{false, false},
+				IsAbsent: AbsenceOf(false, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{1, 1},
-				IsAbsent: []bool{false, false},
+				IsAbsent: AbsenceOf(false, false),
 			},
 		},
 	}
@@ -180,7 +181,7 @@ func TestMergeManyMismatchedMetricsWithMajority(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{2, 1},
-		IsAbsent: []bool{false, false},
+		IsAbsent: AbsenceOf(false, false),
 	}
 
 	logger := zap.NewNop()
@@ -212,21 +213,21 @@ func TestMergeManyMinorityMismatchedMetricsWithCheck(t *testing.T) {
 			Metric{
 				Name:     "metric",
 				Values:   []float64{1, 1},
-				IsAbsent: []bool{false, false},
+				IsAbsent: AbsenceOf(false, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{2, 1},
-				IsAbsent: []bool{false, false},
+				IsAbsent: AbsenceOf(false, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{2, 1},
-				IsAbsent: []bool{false, false},
+				IsAbsent: AbsenceOf(false, false),
 			},
 		},
 	}
@@ -234,7 +235,7 @@ func TestMergeManyMinorityMismatchedMetricsWithCheck(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1, 1},
-		IsAbsent: []bool{false, false},
+		IsAbsent: AbsenceOf(false, false),
 	}
 
 	logger := zap.NewNop()
@@ -265,21 +266,21 @@ func TestMergeManyMinorityMismatchedMetricsWithMajority(t *testing.T) {
 			Metric{
 				Name:     "metric",
 				Values:   []float64{1, 1},
-				IsAbsent: []bool{false, false},
+				IsAbsent: AbsenceOf(false, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{2, 1},
-				IsAbsent: []bool{false, false},
+				IsAbsent: AbsenceOf(false, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{2, 1},
-				IsAbsent: []bool{false, false},
+				IsAbsent: AbsenceOf(false, false),
 			},
 		},
 	}
@@ -287,7 +288,7 @@ func TestMergeManyMinorityMismatchedMetricsWithMajority(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{2, 1},
-		IsAbsent: []bool{false, false},
+		IsAbsent: AbsenceOf(false, false),
 	}
 
 	logger := zap.NewNop()
@@ -319,21 +320,21 @@ func TestMergeManyRiskyAndMismatchedMetricsWithCheck(t *testing.T) {
 			Metric{
 				Name:     "metric",
 				Values:   []float64{1, 0, 2},
-				IsAbsent: []bool{false, true, false},
+				IsAbsent: AbsenceOf(false, true, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{2, 0, 3},
-				IsAbsent: []bool{false, true, false},
+				IsAbsent: AbsenceOf(false, true, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{2, 1, 4},
-				IsAbsent: []bool{false, false, false},
+				IsAbsent: AbsenceOf(false, false, false),
 			},
 		},
 	}
@@ -341,7 +342,7 @@ func TestMergeManyRiskyAndMismatchedMetricsWithCheck(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1, 1, 2},
-		IsAbsent: []bool{false, false, false},
+		IsAbsent: AbsenceOf(false, false, false),
 	}
 
 	logger := zap.NewNop()
@@ -373,21 +374,21 @@ func TestMergeManyRiskyAndMismatchedMetricsWithMajority(t *testing.T) {
 			Metric{
 				Name:     "metric",
 				Values:   []float64{1, 0, 2},
-				IsAbsent: []bool{false, true, false},
+				IsAbsent: AbsenceOf(false, true, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{2, 0, 3},
-				IsAbsent: []bool{false, true, false},
+				IsAbsent: AbsenceOf(false, true, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{2, 1, 4},
-				IsAbsent: []bool{false, false, false},
+				IsAbsent: AbsenceOf(false, false, false),
 			},
 		},
 	}
@@ -395,7 +396,7 @@ func TestMergeManyRiskyAndMismatchedMetricsWithMajority(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{2, 1, 2},
-		IsAbsent: []bool{false, false, false},
+		IsAbsent: AbsenceOf(false, false, false),
 	}
 
 	logger := zap.NewNop()
@@ -431,21 +432,21 @@ func TestMergeManyRiskyAndMismatchedMetricsWithMajorityApproximateBadFloat(t *te
 			Metric{
 				Name:     "metric",
 				Values:   []float64{0.25, 0, 2},
-				IsAbsent: []bool{false, true, false},
+				IsAbsent: AbsenceOf(false, true, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{f3, 0, 3},
-				IsAbsent: []bool{false, true, false},
+				IsAbsent: AbsenceOf(false, true, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{f3appr, 1, 4},
-				IsAbsent: []bool{false, false, false},
+				IsAbsent: AbsenceOf(false, false, false),
 			},
 		},
 	}
@@ -453,7 +454,7 @@ func TestMergeManyRiskyAndMismatchedMetricsWithMajorityApproximateBadFloat(t *te
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{math.Max(f3, f3appr), 1, 2},
-		IsAbsent: []bool{false, false, false},
+		IsAbsent: AbsenceOf(false, false, false),
 	}
 
 	logger := zap.NewNop()
@@ -492,21 +493,21 @@ func TestMergeMismatchedMetricsWithMajorityApproximateBadFloat(t *testing.T) {
 			Metric{
 				Name:     "metric",
 				Values:   []float64{f3appr, 1, 4},
-				IsAbsent: []bool{false, true, false},
+				IsAbsent: AbsenceOf(false, true, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{f3, 1, 4},
-				IsAbsent: []bool{false, true, false},
+				IsAbsent: AbsenceOf(false, true, false),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric",
 				Values:   []float64{f3, 1, 4},
-				IsAbsent: []bool{false, false, false},
+				IsAbsent: AbsenceOf(false, false, false),
 			},
 		},
 	}
@@ -514,7 +515,7 @@ func TestMergeMismatchedMetricsWithMajorityApproximateBadFloat(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{f3appr, 1, 4},
-		IsAbsent: []bool{false, false, false},
+		IsAbsent: AbsenceOf(false, false, false),
 	}
 
 	logger := zap.NewNop()
@@ -549,14 +550,14 @@ func TestMergeManyMetricsDifferent(t *testing.T) {
 			Metric{
 				Name:     "metric1",
 				Values:   []float64{0},
-				IsAbsent: []bool{true},
+				IsAbsent: AbsenceOf(true),
 			},
 		},
 		[]Metric{
 			Metric{
 				Name:     "metric2",
 				Values:   []float64{1},
-				IsAbsent: []bool{false},
+				IsAbsent: AbsenceOf(false),
 			},
 		},
 	}
@@ -573,19 +574,19 @@ func TestMergeMetricsNormal(t *testing.T) {
 		Metric{
 			Name:     "metric",
 			Values:   []float64{0},
-			IsAbsent: []bool{true},
+			IsAbsent: AbsenceOf(true),
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1},
-			IsAbsent: []bool{false},
+			IsAbsent: AbsenceOf(false),
 		},
 	}
 
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1},
-		IsAbsent: []bool{false},
+		IsAbsent: AbsenceOf(false),
 	}
 
 	doTest(t, input, expected)
@@ -596,13 +597,13 @@ func TestMergeMetricsMismatchedNormal(t *testing.T) {
 		Metric{
 			Name:     "metric",
 			Values:   []float64{0, 0},
-			IsAbsent: []bool{true, true},
+			IsAbsent: AbsenceOf(true, true),
 			StepTime: 1,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1},
-			IsAbsent: []bool{false},
+			IsAbsent: AbsenceOf(false),
 			StepTime: 2,
 		},
 	}
@@ -610,7 +611,7 @@ func TestMergeMetricsMismatchedNormal(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{0, 0},
-		IsAbsent: []bool{true, true},
+		IsAbsent: AbsenceOf(true, true),
 		StepTime: 1,
 	}
 
@@ -629,24 +630,24 @@ func TestMergeMetricsPreferFirstPresent(t *testing.T) {
 		Metric{
 			Name:     "metric",
 			Values:   []float64{0},
-			IsAbsent: []bool{true},
+			IsAbsent: AbsenceOf(true),
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1},
-			IsAbsent: []bool{false},
+			IsAbsent: AbsenceOf(false),
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{2},
-			IsAbsent: []bool{false},
+			IsAbsent: AbsenceOf(false),
 		},
 	}
 
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1},
-		IsAbsent: []bool{false},
+		IsAbsent: AbsenceOf(false),
 	}
 
 	doTest(t, input, expected)
@@ -658,19 +659,19 @@ func TestMergeMetricsDifferingStepTimes1(t *testing.T) {
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1},
-			IsAbsent: []bool{false},
+			IsAbsent: AbsenceOf(false),
 			StepTime: 2,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{0, 1},
-			IsAbsent: []bool{true, false},
+			IsAbsent: AbsenceOf(true, false),
 			StepTime: 1,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1, 0},
-			IsAbsent: []bool{false, true},
+			IsAbsent: AbsenceOf(false, true),
 			StepTime: 1,
 		},
 	}
@@ -678,7 +679,7 @@ func TestMergeMetricsDifferingStepTimes1(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1, 1},
-		IsAbsent: []bool{false, false},
+		IsAbsent: AbsenceOf(false, false),
 		StepTime: 1,
 	}
 
@@ -691,19 +692,19 @@ func TestMergeMetricsDifferingStepTimes2(t *testing.T) {
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1},
-			IsAbsent: []bool{false},
+			IsAbsent: AbsenceOf(false),
 			StepTime: 2,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1, 0},
-			IsAbsent: []bool{false, true},
+			IsAbsent: AbsenceOf(false, true),
 			StepTime: 1,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{0, 1},
-			IsAbsent: []bool{true, false},
+			IsAbsent: AbsenceOf(true, false),
 			StepTime: 1,
 		},
 	}
@@ -711,7 +712,7 @@ func TestMergeMetricsDifferingStepTimes2(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1, 1},
-		IsAbsent: []bool{false, false},
+		IsAbsent: AbsenceOf(false, false),
 		StepTime: 1,
 	}
 
@@ -724,19 +725,19 @@ func TestMergeMetricsDifferingStepTimes3(t *testing.T) {
 		Metric{
 			Name:     "metric",
 			Values:   []float64{0, 1},
-			IsAbsent: []bool{true, false},
+			IsAbsent: AbsenceOf(true, false),
 			StepTime: 1,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1},
-			IsAbsent: []bool{false},
+			IsAbsent: AbsenceOf(false),
 			StepTime: 2,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1, 0},
-			IsAbsent: []bool{false, true},
+			IsAbsent: AbsenceOf(false, true),
 			StepTime: 1,
 		},
 	}
@@ -744,7 +745,7 @@ func TestMergeMetricsDifferingStepTimes3(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1, 1},
-		IsAbsent: []bool{false, false},
+		IsAbsent: AbsenceOf(false, false),
 		StepTime: 1,
 	}
 
@@ -757,19 +758,19 @@ func TestMergeMetricsDifferingStepTimes4(t *testing.T) {
 		Metric{
 			Name:     "metric",
 			Values:   []float64{0, 1},
-			IsAbsent: []bool{true, false},
+			IsAbsent: AbsenceOf(true, false),
 			StepTime: 1,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1, 0},
-			IsAbsent: []bool{false, true},
+			IsAbsent: AbsenceOf(false, true),
 			StepTime: 1,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1},
-			IsAbsent: []bool{false},
+			IsAbsent: AbsenceOf(false),
 			StepTime: 2,
 		},
 	}
@@ -777,7 +778,7 @@ func TestMergeMetricsDifferingStepTimes4(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1, 1},
-		IsAbsent: []bool{false, false},
+		IsAbsent: AbsenceOf(false, false),
 		StepTime: 1,
 	}
 
@@ -790,19 +791,19 @@ func TestMergeMetricsDifferingStepTimes5(t *testing.T) {
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1, 0},
-			IsAbsent: []bool{false, true},
+			IsAbsent: AbsenceOf(false, true),
 			StepTime: 1,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1},
-			IsAbsent: []bool{false},
+			IsAbsent: AbsenceOf(false),
 			StepTime: 2,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{0, 1},
-			IsAbsent: []bool{true, false},
+			IsAbsent: AbsenceOf(true, false),
 			StepTime: 1,
 		},
 	}
@@ -810,7 +811,7 @@ func TestMergeMetricsDifferingStepTimes5(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1, 1},
-		IsAbsent: []bool{false, false},
+		IsAbsent: AbsenceOf(false, false),
 		StepTime: 1,
 	}
 
@@ -823,19 +824,19 @@ func TestMergeMetricsDifferingStepTimes6(t *testing.T) {
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1, 0},
-			IsAbsent: []bool{false, true},
+			IsAbsent: AbsenceOf(false, true),
 			StepTime: 1,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{0, 1},
-			IsAbsent: []bool{true, false},
+			IsAbsent: AbsenceOf(true, false),
 			StepTime: 1,
 		},
 		Metric{
 			Name:     "metric",
 			Values:   []float64{1},
-			IsAbsent: []bool{false},
+			IsAbsent: AbsenceOf(false),
 			StepTime: 2,
 		},
 	}
@@ -843,7 +844,7 @@ func TestMergeMetricsDifferingStepTimes6(t *testing.T) {
 	expected := Metric{
 		Name:     "metric",
 		Values:   []float64{1, 1},
-		IsAbsent: []bool{false, false},
+		IsAbsent: AbsenceOf(false, false),
 		StepTime: 1,
 	}
 
@@ -857,3 +858,26 @@ func doTest(t *testing.T, input []Metric, expected Metric) {
 		t.Errorf("Merge failed\nExp: %+v\nGot: %+v\n", expected, got)
 	}
 }
+
+func TestTraceReport(t *testing.T) {
+	trace := NewTrace()
+	trace.IncCall()
+	trace.IncCall()
+	*trace.inLimiterNS = int64(4 * time.Millisecond)
+	*trace.inHTTPCallNS = int64(6 * time.Millisecond)
+	*trace.inReadBodyNS = int64(2 * time.Millisecond)
+	*trace.inUnmarshalNS = int64(time.Millisecond)
+	*trace.inMergeNS = int64(3 * time.Millisecond)
+
+	// the calls to backends are averaged, the merge is not
+	expected := TraceReport{
+		Calls:  2,
+		Queue:  2 * time.Millisecond,
+		Fetch:  4 * time.Millisecond,
+		Decode: 500 * time.Microsecond,
+		Merge:  3 * time.Millisecond,
+	}
+	if got := trace.Report(); got != expected {
+		t.Errorf("expected report %+v, got %+v", expected, got)
+	}
+}
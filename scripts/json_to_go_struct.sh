@@ -36,7 +36,7 @@ fi
 
 shift
 
-FUNCTIONS=$(egrep 'RegisterFunction|functions :=' "${CODE_PATH}"/expr/functions/"${FUNCTION_FILE}"/function.go | grep -v 'RegisterFunction(f,' | egrep -o '"[^"]+"' | tr -d '"')
+FUNCTIONS=$(egrep 'RegisterFunction|functions :=' "${CODE_PATH}"/internal/expr/functions/"${FUNCTION_FILE}"/function.go | grep -v 'RegisterFunction(f,' | egrep -o '"[^"]+"' | tr -d '"')
 
 {
   echo
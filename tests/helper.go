@@ -8,10 +8,10 @@ import (
 	"testing"
 	"time"
 
-	"github.com/bookingcom/carbonapi/expr/interfaces"
-	"github.com/bookingcom/carbonapi/expr/metadata"
-	"github.com/bookingcom/carbonapi/expr/types"
-	"github.com/bookingcom/carbonapi/pkg/parser"
+	"github.com/bookingcom/carbonapi/internal/expr/interfaces"
+	"github.com/bookingcom/carbonapi/internal/expr/metadata"
+	"github.com/bookingcom/carbonapi/internal/expr/types"
+	"github.com/bookingcom/carbonapi/internal/parser"
 	dataTypes "github.com/bookingcom/carbonapi/pkg/types"
 )
 
@@ -21,7 +21,7 @@ type FuncEvaluator struct {
 
 func (evaluator *FuncEvaluator) EvalExpr(ctx context.Context, e parser.Expr, from, until int32, values map[parser.MetricRequest][]*types.MetricData, getTargetData interfaces.GetTargetData) ([]*types.MetricData, error) {
 	if e.IsName() {
-		return values[parser.MetricRequest{Metric: e.Target(), From: from, Until: until}], nil
+		return values[parser.MetricRequest{Metric: e.Target(), From: int64(from), Until: int64(until)}], nil
 	} else if e.IsConst() {
 		p := types.MetricData{
 			Metric: dataTypes.Metric{
@@ -72,12 +72,11 @@ func DeepClone(original map[parser.MetricRequest][]*types.MetricData) map[parser
 					StopTime:  originalMetric.StopTime,
 					StepTime:  originalMetric.StepTime,
 					Values:    make([]float64, len(originalMetric.Values)),
-					IsAbsent:  make([]bool, len(originalMetric.IsAbsent)),
+					IsAbsent:  originalMetric.IsAbsent.Clone(),
 				},
 			}
 
 			copy(copiedMetric.Values, originalMetric.Values)
-			copy(copiedMetric.IsAbsent, originalMetric.IsAbsent)
 			copiedMetrics = append(copiedMetrics, &copiedMetric)
 		}
 
@@ -116,7 +115,7 @@ func DeepEqual(t *testing.T, target string, original, modified map[parser.Metric
 
 const eps = 0.0000000001
 
-func NearlyEqual(a []float64, absent []bool, b []float64) bool {
+func NearlyEqual(a []float64, absent types.Absence, b []float64) bool {
 
 	if len(a) != len(b) {
 		return false
@@ -124,10 +123,10 @@ func NearlyEqual(a []float64, absent []bool, b []float64) bool {
 
 	for i, v := range a {
 		// "same"
-		if absent[i] && math.IsNaN(b[i]) {
+		if absent.Get(i) && math.IsNaN(b[i]) {
 			continue
 		}
-		if absent[i] || math.IsNaN(b[i]) {
+		if absent.Get(i) || math.IsNaN(b[i]) {
 			// unexpected NaN
 			return false
 		}
@@ -142,12 +141,12 @@ func NearlyEqual(a []float64, absent []bool, b []float64) bool {
 
 func NearlyEqualMetrics(a, b *types.MetricData) bool {
 
-	if len(a.IsAbsent) != len(b.IsAbsent) {
+	if a.IsAbsent.Len() != b.IsAbsent.Len() {
 		return false
 	}
 
-	for i := range a.IsAbsent {
-		if a.IsAbsent[i] != b.IsAbsent[i] {
+	for i := 0; i < a.IsAbsent.Len(); i++ {
+		if a.IsAbsent.Get(i) != b.IsAbsent.Get(i) {
 			return false
 		}
 		// "close enough"
@@ -277,7 +276,7 @@ func TestMultiReturnEvalExpr(t *testing.T, tt *MultiReturnEvalTestItem) {
 		if r[0].Name != gg.Name {
 			t.Errorf("result Name mismatch, got\n%#v,\nwant\n%#v", gg.Name, r[0].Name)
 		}
-		if !reflect.DeepEqual(r[0].Values, gg.Values) || !reflect.DeepEqual(r[0].IsAbsent, gg.IsAbsent) ||
+		if !reflect.DeepEqual(r[0].Values, gg.Values) || !r[0].IsAbsent.Equal(gg.IsAbsent) ||
 			r[0].StartTime != gg.StartTime ||
 			r[0].StopTime != gg.StopTime ||
 			r[0].StepTime != gg.StepTime {
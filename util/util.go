@@ -14,6 +14,7 @@ const (
 
 	uuidKey key = iota
 	priorityKey
+	tenantKey
 )
 
 // GetPriority returns the current request priority. Less is more
@@ -30,6 +31,20 @@ func WithPriority(ctx context.Context, priority int) context.Context {
 	return context.WithValue(ctx, priorityKey, priority)
 }
 
+// GetTenant returns the tenant the request is made for, by which backend
+// capacity is shared. If not set, returns the empty tenant.
+func GetTenant(ctx context.Context) string {
+	if t := ctx.Value(tenantKey); t != nil {
+		return t.(string)
+	}
+	return ""
+}
+
+// WithTenant returns new context with tenant set
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantKey, tenant)
+}
+
 // GetUUID gets the Carbon UUID of a request.
 func GetUUID(ctx context.Context) string {
 	if id := ctx.Value(uuidKey); id != nil {